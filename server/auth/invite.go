@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// GenerateInviteToken generates base64url encoded invitation token for the room.
+// token: [64bit expire, 8bit len(roomId), roomId, 8bit len(userId), userId, 256bit hmac]
+// userIdが空でないときは、そのユーザだけが使える.
+func GenerateInviteToken(key, roomId, userId string, expire time.Time) (string, error) {
+	if len(roomId) > 255 || len(userId) > 255 {
+		return "", xerrors.Errorf("too long id: room=%v user=%v", len(roomId), len(userId))
+	}
+
+	d := make([]byte, 8, 8+1+len(roomId)+1+len(userId)+32)
+	binary.BigEndian.PutUint64(d, uint64(expire.Unix()))
+	d = append(d, byte(len(roomId)))
+	d = append(d, roomId...)
+	d = append(d, byte(len(userId)))
+	d = append(d, userId...)
+	d = append(d, CalculateHMAC([]byte(key), d)...)
+
+	return base64.RawURLEncoding.EncodeToString(d), nil
+}
+
+// ValidInviteToken validates invitation token and returns roomId and userId in the token.
+func ValidInviteToken(token, key string, now time.Time) (roomId, userId string, err error) {
+	d, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", "", xerrors.Errorf("decode base64: %w", err)
+	}
+	if len(d) < 8+1+1+32 {
+		return "", "", xerrors.Errorf("too short: %v", len(d))
+	}
+
+	data, mac := d[:len(d)-32], d[len(d)-32:]
+	if !ValidHMAC(mac, []byte(key), data) {
+		return "", "", xerrors.Errorf("hmac mismatch")
+	}
+
+	roomId, userId, expire, err := parseInviteData(data)
+	if err != nil {
+		return "", "", err
+	}
+	if now.After(expire) {
+		return "", "", xerrors.Errorf("expired: %v", expire)
+	}
+	return roomId, userId, nil
+}
+
+// ParseInviteToken returns roomId, userId and expire in the token without validating hmac.
+// Lobbyで検証済みのトークンをgameサーバで使うためのもの. (gameサーバは更新前のapp keyを知らない)
+func ParseInviteToken(token string) (roomId, userId string, expire time.Time, err error) {
+	d, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", "", time.Time{}, xerrors.Errorf("decode base64: %w", err)
+	}
+	if len(d) < 8+1+1+32 {
+		return "", "", time.Time{}, xerrors.Errorf("too short: %v", len(d))
+	}
+	return parseInviteData(d[:len(d)-32])
+}
+
+func parseInviteData(data []byte) (roomId, userId string, expire time.Time, err error) {
+	expire = time.Unix(int64(binary.BigEndian.Uint64(data)), 0)
+	data = data[8:]
+
+	l := int(data[0])
+	if len(data) < 1+l+1 {
+		return "", "", time.Time{}, xerrors.Errorf("invalid room id length: %v", l)
+	}
+	roomId, data = string(data[1:1+l]), data[1+l:]
+
+	l = int(data[0])
+	if len(data) != 1+l {
+		return "", "", time.Time{}, xerrors.Errorf("invalid user id length: %v", l)
+	}
+	userId = string(data[1:])
+
+	return roomId, userId, expire, nil
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInviteToken(t *testing.T) {
+	key := "testappkey"
+	now := time.Now()
+	expire := now.Add(time.Hour)
+
+	for _, userId := range []string{"", "user001"} {
+		token, err := GenerateInviteToken(key, "0123456789abcdef", userId, expire)
+		if err != nil {
+			t.Fatalf("GenerateInviteToken: %+v", err)
+		}
+
+		roomId, uid, err := ValidInviteToken(token, key, now)
+		if err != nil {
+			t.Fatalf("ValidInviteToken: %+v", err)
+		}
+		if roomId != "0123456789abcdef" || uid != userId {
+			t.Fatalf("ValidInviteToken = (%q, %q), wants (%q, %q)", roomId, uid, "0123456789abcdef", userId)
+		}
+	}
+
+	token, err := GenerateInviteToken(key, "0123456789abcdef", "user001", expire)
+	if err != nil {
+		t.Fatalf("GenerateInviteToken: %+v", err)
+	}
+	tampered := []byte(token)
+	if tampered[10] == 'A' {
+		tampered[10] = 'B'
+	} else {
+		tampered[10] = 'A'
+	}
+	tests := map[string]struct {
+		token string
+		key   string
+		now   time.Time
+	}{
+		"expired":    {token, key, expire.Add(time.Second)},
+		"other key":  {token, "otherkey", now},
+		"tampered":   {string(tampered), key, now},
+		"too short":  {token[:20], key, now},
+		"not base64": {"!!" + token[2:], key, now},
+	}
+	for name, tc := range tests {
+		if _, _, err := ValidInviteToken(tc.token, tc.key, tc.now); err == nil {
+			t.Fatalf("%v: must be error", name)
+		}
+	}
+}
+
+func TestParseInviteToken(t *testing.T) {
+	expire := time.Unix(time.Now().Add(-time.Hour).Unix(), 0)
+	token, err := GenerateInviteToken("testappkey", "0123456789abcdef", "user001", expire)
+	if err != nil {
+		t.Fatalf("GenerateInviteToken: %+v", err)
+	}
+
+	// 期限切れでも中身を返す. 期限は呼び出し側で確認する
+	roomId, userId, exp, err := ParseInviteToken(token)
+	if err != nil {
+		t.Fatalf("ParseInviteToken: %+v", err)
+	}
+	if roomId != "0123456789abcdef" || userId != "user001" || !exp.Equal(expire) {
+		t.Fatalf("ParseInviteToken = (%q, %q, %v), wants (%q, %q, %v)",
+			roomId, userId, exp, "0123456789abcdef", "user001", expire)
+	}
+
+	if _, _, _, err := ParseInviteToken(token[:20]); err == nil {
+		t.Fatalf("too short token must be error")
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
 	"hash"
 	"strings"
@@ -13,6 +15,61 @@ import (
 	"golang.org/x/xerrors"
 )
 
+// Msgの認証に使うHMACアルゴリズム.
+// クライアントは入室時に対応するアルゴリズムを申告し (pb.Capabilities.MacAlgorithms)、
+// サーバが選んだものを返す (pb.JoinedRoomRes.MacAlgorithm).
+const (
+	// MACAlgorithmSHA1 : 申告しないクライアントはこれのみに対応しているとみなす
+	MACAlgorithmSHA1   = "hmac-sha1"
+	MACAlgorithmSHA256 = "hmac-sha256"
+	MACAlgorithmSHA512 = "hmac-sha512"
+	// MACAlgorithmNone : 認証しない. 信頼できるプロキシ経由でのみ接続されるapp向け
+	MACAlgorithmNone = "none"
+)
+
+// NewMsgMAC : algでMsgを認証するhash.Hashを作る
+func NewMsgMAC(alg, key string) (hash.Hash, error) {
+	switch alg {
+	case MACAlgorithmSHA1:
+		return hmac.New(sha1.New, []byte(key)), nil
+	case MACAlgorithmSHA256:
+		return hmac.New(sha256.New, []byte(key)), nil
+	case MACAlgorithmSHA512:
+		return hmac.New(sha512.New, []byte(key)), nil
+	case MACAlgorithmNone:
+		return noMAC{}, nil
+	}
+	return nil, xerrors.Errorf("unknown MAC algorithm: %q", alg)
+}
+
+// SelectMACAlgorithm : allowedのうち、offeredに含まれる最初のアルゴリズムを返す.
+// offeredが空ならMACAlgorithmSHA1のみを申告したものとする.
+func SelectMACAlgorithm(offered, allowed []string) (string, error) {
+	if len(offered) == 0 {
+		offered = []string{MACAlgorithmSHA1}
+	}
+	for _, a := range allowed {
+		if _, err := NewMsgMAC(a, ""); err != nil {
+			continue
+		}
+		for _, o := range offered {
+			if o == a {
+				return a, nil
+			}
+		}
+	}
+	return "", xerrors.Errorf("no acceptable MAC algorithm: offered=%v, allowed=%v", offered, allowed)
+}
+
+// noMAC : 長さ0のMACを返すhash.Hash (MACAlgorithmNone)
+type noMAC struct{}
+
+func (noMAC) Write(p []byte) (int, error) { return len(p), nil }
+func (noMAC) Sum(b []byte) []byte         { return b }
+func (noMAC) Reset()                      {}
+func (noMAC) Size() int                   { return 0 }
+func (noMAC) BlockSize() int              { return 1 }
+
 // DecryptMACKey decodes a MACKey
 func DecryptMACKey(appKey, encMKey string) (string, error) {
 	data, err := base64.StdEncoding.DecodeString(encMKey)
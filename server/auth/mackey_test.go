@@ -20,3 +20,58 @@ func TestMACKey(t *testing.T) {
 		t.Fatalf("decrypted = %q, wants %q", r, mackey)
 	}
 }
+
+func TestSelectMACAlgorithm(t *testing.T) {
+	allowed := []string{MACAlgorithmSHA512, MACAlgorithmSHA256, MACAlgorithmSHA1}
+	tests := map[string]struct {
+		offered []string
+		allowed []string
+		want    string
+	}{
+		"legacy":        {nil, allowed, MACAlgorithmSHA1},
+		"server order":  {[]string{MACAlgorithmSHA256, MACAlgorithmSHA512}, allowed, MACAlgorithmSHA512},
+		"none":          {[]string{MACAlgorithmNone, MACAlgorithmSHA1}, []string{MACAlgorithmNone}, MACAlgorithmNone},
+		"unknown":       {[]string{"hmac-md5", MACAlgorithmSHA256}, []string{"hmac-md5", MACAlgorithmSHA256}, MACAlgorithmSHA256},
+		"no match":      {[]string{MACAlgorithmSHA256}, []string{MACAlgorithmSHA1}, ""},
+		"legacy denied": {nil, []string{MACAlgorithmSHA256}, ""},
+	}
+	for name, tc := range tests {
+		alg, err := SelectMACAlgorithm(tc.offered, tc.allowed)
+		if tc.want == "" {
+			if err == nil {
+				t.Fatalf("%v: selected %q, wants error", name, alg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if alg != tc.want {
+			t.Fatalf("%v: selected %q, wants %q", name, alg, tc.want)
+		}
+	}
+}
+
+func TestMsgMAC(t *testing.T) {
+	data := []byte("message")
+	for _, alg := range []string{MACAlgorithmSHA1, MACAlgorithmSHA256, MACAlgorithmSHA512, MACAlgorithmNone} {
+		mac, err := NewMsgMAC(alg, "key")
+		if err != nil {
+			t.Fatalf("NewMsgMAC(%v): %v", alg, err)
+		}
+		signed := append(append([]byte{}, data...), CalculateMsgHMAC(mac, data)...)
+		d, ok := ValidateMsgHMAC(mac, signed)
+		if !ok || string(d) != string(data) {
+			t.Fatalf("%v: ValidateMsgHMAC = (%q, %v)", alg, d, ok)
+		}
+		if alg != MACAlgorithmNone {
+			signed[0] ^= 1
+			if _, ok := ValidateMsgHMAC(mac, signed); ok {
+				t.Fatalf("%v: tampered message is valid", alg)
+			}
+		}
+	}
+	if _, err := NewMsgMAC("hmac-md5", "key"); err == nil {
+		t.Fatalf("NewMsgMAC must fail with unknown algorithm")
+	}
+}
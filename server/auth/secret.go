@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"golang.org/x/xerrors"
+)
+
+// SealSecret : サーバの鍵でplainをAES-GCMで暗号化してbase64で返す. DBに保存する秘密情報に使う
+func SealSecret(serverKey, plain string) (string, error) {
+	aead, err := newSecretAEAD(serverKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", xerrors.Errorf("nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(plain), nil)), nil
+}
+
+// OpenSecret : SealSecretで暗号化した値を復号する
+func OpenSecret(serverKey, sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", xerrors.Errorf("base64: %w", err)
+	}
+	aead, err := newSecretAEAD(serverKey)
+	if err != nil {
+		return "", err
+	}
+	ns := aead.NonceSize()
+	if len(data) < ns {
+		return "", xerrors.Errorf("data too short")
+	}
+	plain, err := aead.Open(nil, data[:ns], data[ns:], nil)
+	if err != nil {
+		return "", xerrors.Errorf("open: %w", err)
+	}
+	return string(plain), nil
+}
+
+func newSecretAEAD(serverKey string) (cipher.AEAD, error) {
+	ckey := sha256.Sum256([]byte(serverKey))
+	b, err := aes.NewCipher(ckey[:])
+	if err != nil {
+		return nil, xerrors.Errorf("aes: %w", err)
+	}
+	return cipher.NewGCM(b)
+}
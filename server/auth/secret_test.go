@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSealSecret(t *testing.T) {
+	key := "serverkey"
+	secret := "testMACKey"
+
+	sealed, err := SealSecret(key, secret)
+	if err != nil {
+		t.Fatalf("SealSecret: %v", err)
+	}
+	if strings.Contains(sealed, secret) {
+		t.Fatalf("sealed contains plain text: %q", sealed)
+	}
+	if s2, _ := SealSecret(key, secret); s2 == sealed {
+		t.Fatalf("sealed twice into the same value: %q", sealed)
+	}
+
+	r, err := OpenSecret(key, sealed)
+	if err != nil {
+		t.Fatalf("OpenSecret: %v", err)
+	}
+	if r != secret {
+		t.Fatalf("opened = %q, wants %q", r, secret)
+	}
+
+	if _, err := OpenSecret("otherkey", sealed); err == nil {
+		t.Fatalf("OpenSecret with other key must fail")
+	}
+	if _, err := OpenSecret(key, secret); err == nil {
+		t.Fatalf("OpenSecret of plain text must fail")
+	}
+}
@@ -0,0 +1,93 @@
+package binary
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"hash"
+	"io"
+	"testing"
+	"time"
+
+	"wsnet2/pb"
+)
+
+// ホットパスのアロケーションのbenchmarkと上限の検査.
+// 上限を超えるとTestAllocBudgetが失敗するので、CIで性能の劣化に気付ける.
+// 意図して増やすときはbenchmarkで確認してからallocBudgetsを更新すること.
+
+type allocCase struct {
+	name   string
+	budget float64 // 1回あたりのアロケーション回数の上限
+	setup  func() func()
+}
+
+func benchMAC() hash.Hash {
+	return hmac.New(sha1.New, []byte("benchkey"))
+}
+
+var allocBudgets = []allocCase{
+	{"UnmarshalMsg/Broadcast", 2, func() func() {
+		mac := benchMAC()
+		data := BuildRegularMsgFrame(MsgTypeBroadcast, 1, make([]byte, 64), mac)
+		return func() { UnmarshalMsg(mac, data) }
+	}},
+	{"UnmarshalMsg/Ping", 2, func() func() {
+		mac := benchMAC()
+		data := NewMsgPing(time.Unix(1, 0)).Marshal(mac)
+		return func() { UnmarshalMsg(mac, data) }
+	}},
+	{"Marshal/EvMessage", 4, func() func() {
+		body := make([]byte, 64)
+		return func() { NewEvMessage("sender", body).Marshal(1) }
+	}},
+	{"Marshal/EvJoined", 1, func() func() {
+		cli := &pb.ClientInfo{Id: "player", Props: MarshalDict(Dict{"name": MarshalStr8("player")})}
+		return func() { NewEvJoined(cli).Marshal(1) }
+	}},
+	{"Marshal/EvPong", 6, func() func() {
+		lastMsg := Dict{"player": MarshalULong(1)}
+		return func() { NewEvPong(1, 10, lastMsg).Marshal() }
+	}},
+	{"Marshal/Batch", 1, func() func() {
+		evs := []*RegularEvent{
+			NewEvMessage("player1", make([]byte, 64)),
+			NewEvMessage("player2", make([]byte, 64)),
+		}
+		return func() { MarshalBatch(evs, 1) }
+	}},
+	{"Broadcast/PutHeader", 0, func() func() {
+		ev := NewEvMessage("sender", make([]byte, 1024))
+		var hdr [RegularEventHeaderSize]byte
+		return func() {
+			for p := 0; p < benchPeers; p++ {
+				ev.PutHeader(hdr[:], p)
+				io.Discard.Write(hdr[:])
+				io.Discard.Write(ev.Payload())
+			}
+		}
+	}},
+}
+
+func TestAllocBudget(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations differ with the race detector")
+	}
+	for _, c := range allocBudgets {
+		f := c.setup()
+		if n := testing.AllocsPerRun(100, f); n > c.budget {
+			t.Errorf("%v: %v allocs/op, budget %v", c.name, n, c.budget)
+		}
+	}
+}
+
+func BenchmarkAllocBudget(b *testing.B) {
+	for _, c := range allocBudgets {
+		f := c.setup()
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				f()
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package binary
+
+import (
+	"wsnet2/pb"
+
+	"golang.org/x/xerrors"
+)
+
+// ClientCapsKey : クライアントの申告した対応機能(pb.Capabilities)を格納するClientInfo.Propsのキー.
+// サーバが入室時に設定し、クライアントからは変更できない.
+const ClientCapsKey = "@caps"
+
+// MarshalCaps : 対応機能をDictとしてmarshalする
+func MarshalCaps(caps *pb.Capabilities) []byte {
+	return MarshalDict(Dict{
+		"compression": MarshalBool(caps.Compression),
+		"batch":       MarshalBool(caps.Batch),
+		"protocol":    MarshalInt(int(caps.ProtocolVersion)),
+		"platform":    MarshalStr8(caps.Platform),
+	})
+}
+
+// UnmarshalCaps : ClientInfo.Propsから対応機能を取り出す.
+// 申告されていないときはnilを返す.
+func UnmarshalCaps(props []byte) (*pb.Capabilities, error) {
+	v, err := NewDictView(props)
+	if err != nil {
+		return nil, xerrors.Errorf("UnmarshalCaps: %w", err)
+	}
+	c, ok := v.Get(ClientCapsKey)
+	if !ok {
+		return nil, nil
+	}
+	d, err := NewDictView(c)
+	if err != nil {
+		return nil, xerrors.Errorf("UnmarshalCaps: %w", err)
+	}
+	caps := &pb.Capabilities{}
+	if caps.Compression, err = d.GetBool("compression"); err != nil {
+		return nil, xerrors.Errorf("UnmarshalCaps: %w", err)
+	}
+	if caps.Batch, err = d.GetBool("batch"); err != nil {
+		return nil, xerrors.Errorf("UnmarshalCaps: %w", err)
+	}
+	ver, err := d.GetInt("protocol")
+	if err != nil {
+		return nil, xerrors.Errorf("UnmarshalCaps: %w", err)
+	}
+	caps.ProtocolVersion = uint32(ver)
+	if caps.Platform, err = d.GetStr("platform"); err != nil {
+		return nil, xerrors.Errorf("UnmarshalCaps: %w", err)
+	}
+	return caps, nil
+}
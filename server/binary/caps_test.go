@@ -0,0 +1,42 @@
+package binary
+
+import (
+	"testing"
+
+	"wsnet2/pb"
+)
+
+func TestUnmarshalCaps(t *testing.T) {
+	caps := &pb.Capabilities{
+		Compression:     true,
+		Batch:           true,
+		ProtocolVersion: ProtocolVersionHubStatus,
+		Platform:        "go",
+	}
+	props := MarshalDict(Dict{
+		"name":        MarshalStr8("alice"),
+		ClientCapsKey: MarshalCaps(caps),
+	})
+
+	got, err := UnmarshalCaps(props)
+	if err != nil {
+		t.Fatalf("UnmarshalCaps: %v", err)
+	}
+	if got.Compression != caps.Compression || got.Batch != caps.Batch ||
+		got.ProtocolVersion != caps.ProtocolVersion || got.Platform != caps.Platform {
+		t.Fatalf("UnmarshalCaps = %+v, wants %+v", got, caps)
+	}
+
+	got, err = UnmarshalCaps(MarshalDict(Dict{"name": MarshalStr8("bob")}))
+	if err != nil {
+		t.Fatalf("UnmarshalCaps: %v", err)
+	}
+	if got != nil {
+		t.Fatalf("UnmarshalCaps = %+v, wants nil", got)
+	}
+
+	_, err = UnmarshalCaps(MarshalDict(Dict{ClientCapsKey: MarshalInt(1)}))
+	if err == nil {
+		t.Fatalf("UnmarshalCaps must fail for non-dict caps")
+	}
+}
@@ -0,0 +1,85 @@
+package binary
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CloseReason : サーバがwebsocketを閉じた理由.
+// Close frameのreason textの先頭に "{reason}:" として付与し、
+// クライアントがcloseコードと合わせて再接続するかどうかを判断できるようにする.
+type CloseReason byte
+
+const (
+	// CloseReasonUnknown : 理由不明 (理由の付与に対応していないサーバなど)
+	CloseReasonUnknown CloseReason = iota
+	// CloseReasonRoomClosed : 部屋が終了した. 再接続不要
+	CloseReasonRoomClosed
+	// CloseReasonPeerReplaced : 同じクライアントの新しい接続に置き換えられた. 再接続不要
+	CloseReasonPeerReplaced
+	// CloseReasonEventLost : 未受信のイベントがサーバのバッファから消えていて復帰できない. 再接続不要
+	CloseReasonEventLost
+	// CloseReasonServerError : サーバ側の送受信エラー. 再接続で復帰できる
+	CloseReasonServerError
+	// CloseReasonClientError : クライアントのエラー (メッセージ不正など). 再接続で復帰できる
+	CloseReasonClientError
+	// CloseReasonInvalidMessage : 受信したメッセージを解釈できなかった. 再接続で復帰できる
+	CloseReasonInvalidMessage
+	// CloseReasonRemoved : 退室した (Kickを含む). 再接続不要
+	CloseReasonRemoved
+	// CloseReasonDisplaced : 同じクライアントIDの別の接続が入室した. 再接続不要
+	CloseReasonDisplaced
+	// CloseReasonAttachFailed : 多重化した接続でハンドルに部屋を割り当てられなかった. 再接続不要
+	CloseReasonAttachFailed
+	// CloseReasonSlowConsumer : イベントの受信が遅れ続けたので切断した (CloseCodeSlowConsumer). 再接続で未受信のイベントから復帰できる
+	CloseReasonSlowConsumer
+	// CloseReasonHandshakeFailed : 先頭フレームでの認証に失敗した. 再接続不要
+	CloseReasonHandshakeFailed
+
+	closeReasonEnd
+)
+
+var closeReasonNames = [closeReasonEnd]string{
+	"Unknown",
+	"RoomClosed",
+	"PeerReplaced",
+	"EventLost",
+	"ServerError",
+	"ClientError",
+	"InvalidMessage",
+	"Removed",
+	"Displaced",
+	"AttachFailed",
+	"SlowConsumer",
+	"HandshakeFailed",
+}
+
+// CloseCodeSlowConsumer : 受信の遅いクライアントを切断するときのwebsocketのclose code (4000-4999はアプリケーション用).
+// 再接続させないときはno_reconnect_close_codesに加える.
+const CloseCodeSlowConsumer = 4000
+
+func (r CloseReason) String() string {
+	if r >= closeReasonEnd {
+		return "CloseReason(" + strconv.Itoa(int(r)) + ")"
+	}
+	return closeReasonNames[r]
+}
+
+// FormatCloseText : Close frameのreason textを作る. 形式は "{reason}:{text}"
+func FormatCloseText(reason CloseReason, text string) string {
+	return strconv.Itoa(int(reason)) + ":" + text
+}
+
+// ParseCloseText : Close frameのreason textから理由とメッセージを取り出す.
+// 理由が付与されていないときはCloseReasonUnknownとtext全体を返す.
+func ParseCloseText(text string) (CloseReason, string) {
+	r, msg, ok := strings.Cut(text, ":")
+	if !ok {
+		return CloseReasonUnknown, text
+	}
+	n, err := strconv.Atoi(r)
+	if err != nil || n < 0 || n > 255 {
+		return CloseReasonUnknown, text
+	}
+	return CloseReason(n), msg
+}
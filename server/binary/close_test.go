@@ -0,0 +1,24 @@
+package binary
+
+import "testing"
+
+func TestCloseText(t *testing.T) {
+	tests := []struct {
+		text   string
+		reason CloseReason
+		msg    string
+	}{
+		{FormatCloseText(CloseReasonRoomClosed, "room closed"), CloseReasonRoomClosed, "room closed"},
+		{FormatCloseText(CloseReasonServerError, "write: broken pipe"), CloseReasonServerError, "write: broken pipe"},
+		{FormatCloseText(CloseReasonEventLost, ""), CloseReasonEventLost, ""},
+		{"room closed", CloseReasonUnknown, "room closed"},
+		{"read: connection reset", CloseReasonUnknown, "read: connection reset"},
+		{"300:overflow", CloseReasonUnknown, "300:overflow"},
+	}
+	for _, tt := range tests {
+		reason, msg := ParseCloseText(tt.text)
+		if reason != tt.reason || msg != tt.msg {
+			t.Errorf("ParseCloseText(%q) = (%v, %q), wants (%v, %q)", tt.text, reason, msg, tt.reason, tt.msg)
+		}
+	}
+}
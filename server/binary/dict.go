@@ -0,0 +1,262 @@
+package binary
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// DictView : marshal済みのDictをmapに展開せずに参照する.
+// 値やキーは元のバイト列を参照するので、元のバイト列を書き換えてはいけない.
+type DictView []byte
+
+// NewDictView : srcがDictとして正しいか検査してDictViewを返す.
+// srcがNullのときは空のDictViewを返す.
+func NewDictView(src []byte) (DictView, error) {
+	if len(src) > 0 && Type(src[0]) == TypeNull {
+		return nil, nil
+	}
+	if len(src) < 2 || Type(src[0]) != TypeDict {
+		return nil, xerrors.Errorf("NewDictView error: not a dict")
+	}
+	count := get8(src[1:])
+	l := 2
+	for i := 0; i < count; i++ {
+		if len(src) < l+1 {
+			return nil, xerrors.Errorf("NewDictView[%v](%v..) error: not enough data (%v)", i, l, len(src))
+		}
+		lk := get8(src[l:])
+		l += 1
+		if len(src) < l+lk+2 {
+			return nil, xerrors.Errorf("NewDictView[%v](%v..%v..2) error: not enough data (%v)", i, l, lk, len(src))
+		}
+		l += lk
+		lv := get16(src[l:])
+		l += 2
+		if len(src) < l+lv {
+			return nil, xerrors.Errorf("NewDictView[%v](%v..%v) error: not enough data (%v)", i, l, lv, len(src))
+		}
+		l += lv
+	}
+	return DictView(src[:l]), nil
+}
+
+// Len : 要素数
+func (d DictView) Len() int {
+	if len(d) < 2 {
+		return 0
+	}
+	return get8(d[1:])
+}
+
+// Range : 要素を順に f に渡す. f が false を返したら終了する.
+// keyは元のバイト列を参照している.
+func (d DictView) Range(f func(key string, val []byte) bool) {
+	count := d.Len()
+	l := 2
+	for i := 0; i < count; i++ {
+		lk := get8(d[l:])
+		l += 1
+		key := d[l : l+lk]
+		l += lk
+		lv := get16(d[l:])
+		l += 2
+		if !f(unsafeString(key), d[l:l+lv]) {
+			return
+		}
+		l += lv
+	}
+}
+
+// Get : keyに対応するmarshal済みの値
+func (d DictView) Get(key string) ([]byte, bool) {
+	var v []byte
+	found := false
+	d.Range(func(k string, val []byte) bool {
+		if k == key {
+			v, found = val, true
+			return false
+		}
+		return true
+	})
+	return v, found
+}
+
+// GetBool : keyに対応するbool値
+func (d DictView) GetBool(key string) (bool, error) {
+	v, ok := d.Get(key)
+	if !ok {
+		return false, xerrors.Errorf("GetBool: key not found: %q", key)
+	}
+	if len(v) > 0 {
+		switch Type(v[0]) {
+		case TypeTrue:
+			return true, nil
+		case TypeFalse:
+			return false, nil
+		}
+	}
+	return false, xerrors.Errorf("GetBool: %q is not a bool", key)
+}
+
+// GetInt : keyに対応する整数値. Byte,SByte,Short,UShort,Int,UInt を受け付ける.
+func (d DictView) GetInt(key string) (int, error) {
+	v, ok := d.Get(key)
+	if !ok {
+		return 0, xerrors.Errorf("GetInt: key not found: %q", key)
+	}
+	if len(v) == 0 {
+		return 0, xerrors.Errorf("GetInt: %q is empty", key)
+	}
+	var i int
+	var err error
+	switch Type(v[0]) {
+	case TypeByte:
+		i, _, err = unmarshalByte(v)
+	case TypeSByte:
+		i, _, err = unmarshalSByte(v)
+	case TypeShort:
+		i, _, err = unmarshalShort(v)
+	case TypeUShort:
+		i, _, err = unmarshalUShort(v)
+	case TypeInt:
+		i, _, err = unmarshalInt(v)
+	case TypeUInt:
+		i, _, err = unmarshalUInt(v)
+	default:
+		return 0, xerrors.Errorf("GetInt: %q is not an integer (%v)", key, Type(v[0]))
+	}
+	if err != nil {
+		return 0, xerrors.Errorf("GetInt %q: %w", key, err)
+	}
+	return i, nil
+}
+
+// GetLong : keyに対応する64bit整数値
+func (d DictView) GetLong(key string) (int64, error) {
+	v, ok := d.Get(key)
+	if !ok {
+		return 0, xerrors.Errorf("GetLong: key not found: %q", key)
+	}
+	if len(v) == 0 || Type(v[0]) != TypeLong {
+		return 0, xerrors.Errorf("GetLong: %q is not a long", key)
+	}
+	i, _, err := unmarshalLong(v)
+	if err != nil {
+		return 0, xerrors.Errorf("GetLong %q: %w", key, err)
+	}
+	return i, nil
+}
+
+// GetStr : keyに対応する文字列. 戻り値は元のバイト列を参照している.
+func (d DictView) GetStr(key string) (string, error) {
+	v, ok := d.Get(key)
+	if !ok {
+		return "", xerrors.Errorf("GetStr: key not found: %q", key)
+	}
+	if len(v) == 0 {
+		return "", xerrors.Errorf("GetStr: %q is empty", key)
+	}
+	var s string
+	var err error
+	switch Type(v[0]) {
+	case TypeStr8:
+		s, _, err = unmarshalStr8(v)
+	case TypeStr16:
+		s, _, err = unmarshalStr16(v)
+	default:
+		return "", xerrors.Errorf("GetStr: %q is not a string (%v)", key, Type(v[0]))
+	}
+	if err != nil {
+		return "", xerrors.Errorf("GetStr %q: %w", key, err)
+	}
+	return s, nil
+}
+
+// DictEncoder : Dictをmapを経由せずにバイト列に追記していく.
+//
+//	var e DictEncoder
+//	e.Reset(buf[:0])
+//	e.Add("key", MarshalInt(1))
+//	buf = e.Bytes()
+type DictEncoder struct {
+	buf   []byte
+	start int
+}
+
+// Reset : dstの末尾にDictを書き込み始める
+func (e *DictEncoder) Reset(dst []byte) {
+	e.start = len(dst)
+	e.buf = append(dst, byte(TypeDict), 0)
+}
+
+// Add : 要素を追加する. 同じキーを重複して追加しないこと.
+// 要素数が255を超える場合やkeyが255byteを超える場合はエラー.
+func (e *DictEncoder) Add(key string, val []byte) error {
+	if e.buf == nil {
+		e.Reset(nil)
+	}
+	if e.buf[e.start+1] == 255 {
+		return xerrors.Errorf("DictEncoder: too many entries")
+	}
+	if len(key) > 255 {
+		return xerrors.Errorf("DictEncoder: key too long: %v", len(key))
+	}
+	if len(val) > 65535 {
+		return xerrors.Errorf("DictEncoder: value too long: %v", len(val))
+	}
+	e.buf = append(e.buf, byte(len(key)))
+	e.buf = append(e.buf, key...)
+	e.buf = append(e.buf, byte(len(val)>>8), byte(len(val)))
+	e.buf = append(e.buf, val...)
+	e.buf[e.start+1]++
+	return nil
+}
+
+// Bytes : Resetで渡したdstに追記したバイト列
+func (e *DictEncoder) Bytes() []byte {
+	if e.buf == nil {
+		e.Reset(nil)
+	}
+	return e.buf
+}
+
+// marshaledDictSize : MarshalDict(dict)の長さ
+func marshaledDictSize(dict Dict) int {
+	size := 2
+	for k, v := range dict {
+		size += 1 + len(k) + 2 + len(v)
+	}
+	return size
+}
+
+// MergeDict : marshal済みのDict srcにupdを適用したDictをdstに追記する.
+// updの値が空のキーは削除する. srcとupdはmapに展開しない.
+func MergeDict(dst []byte, src DictView, upd Dict) ([]byte, error) {
+	var e DictEncoder
+	e.Reset(dst)
+	var err error
+	src.Range(func(k string, v []byte) bool {
+		if u, ok := upd[k]; ok {
+			if len(u) == 0 {
+				return true
+			}
+			v = u
+		}
+		err = e.Add(k, v)
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range upd {
+		if len(v) == 0 {
+			continue
+		}
+		if _, ok := src.Get(k); ok {
+			continue
+		}
+		if err := e.Add(k, v); err != nil {
+			return nil, err
+		}
+	}
+	return e.Bytes(), nil
+}
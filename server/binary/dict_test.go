@@ -0,0 +1,188 @@
+package binary
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDictView(t *testing.T) {
+	dict := Dict{
+		"bool":  MarshalBool(true),
+		"byte":  MarshalByte(200),
+		"short": MarshalShort(-300),
+		"int":   MarshalInt(-70000),
+		"long":  MarshalLong(1 << 40),
+		"str":   MarshalStr8("hello"),
+		"null":  MarshalNull(),
+	}
+	v, err := NewDictView(MarshalDict(dict))
+	if err != nil {
+		t.Fatalf("NewDictView: %v", err)
+	}
+	if v.Len() != len(dict) {
+		t.Fatalf("Len() = %v, wants %v", v.Len(), len(dict))
+	}
+
+	got := make(Dict)
+	v.Range(func(k string, val []byte) bool {
+		got[k] = val
+		return true
+	})
+	if diff := cmp.Diff(got, dict); diff != "" {
+		t.Fatalf("Range: (-got +want)\n%s", diff)
+	}
+
+	if b, err := v.GetBool("bool"); err != nil || !b {
+		t.Fatalf("GetBool = %v, %v", b, err)
+	}
+	ints := map[string]int{"byte": 200, "short": -300, "int": -70000}
+	for k, want := range ints {
+		if i, err := v.GetInt(k); err != nil || i != want {
+			t.Fatalf("GetInt(%q) = %v, %v wants %v", k, i, err, want)
+		}
+	}
+	if l, err := v.GetLong("long"); err != nil || l != 1<<40 {
+		t.Fatalf("GetLong = %v, %v", l, err)
+	}
+	if s, err := v.GetStr("str"); err != nil || s != "hello" {
+		t.Fatalf("GetStr = %v, %v", s, err)
+	}
+	if _, err := v.GetInt("str"); err == nil {
+		t.Fatalf("GetInt(str) must fail")
+	}
+	if _, ok := v.Get("nokey"); ok {
+		t.Fatalf("Get(nokey) found")
+	}
+
+	if v, err := NewDictView(MarshalNull()); err != nil || v.Len() != 0 {
+		t.Fatalf("NewDictView(null) = %v, %v", v, err)
+	}
+	if _, err := NewDictView([]byte{byte(TypeDict), 1, 3, 'a'}); err == nil {
+		t.Fatalf("NewDictView must fail for truncated data")
+	}
+}
+
+func TestDictEncoder(t *testing.T) {
+	var e DictEncoder
+	prefix := []byte{1, 2, 3}
+	e.Reset(prefix)
+	e.Add("a", MarshalInt(1))
+	e.Add("b", MarshalStr8("x"))
+	buf := e.Bytes()
+
+	if diff := cmp.Diff(buf[:3], prefix); diff != "" {
+		t.Fatalf("prefix: (-got +want)\n%s", diff)
+	}
+	d, _, err := UnmarshalNullDict(buf[3:])
+	if err != nil {
+		t.Fatalf("UnmarshalNullDict: %v", err)
+	}
+	want := Dict{"a": MarshalInt(1), "b": MarshalStr8("x")}
+	if diff := cmp.Diff(d, want); diff != "" {
+		t.Fatalf("encoded: (-got +want)\n%s", diff)
+	}
+
+	e.Reset(nil)
+	for i := 0; i < 255; i++ {
+		if err := e.Add(fmt.Sprint(i), MarshalNull()); err != nil {
+			t.Fatalf("Add(%v): %v", i, err)
+		}
+	}
+	if err := e.Add("256", MarshalNull()); err == nil {
+		t.Fatalf("Add must fail over 255 entries")
+	}
+}
+
+func TestMergeDict(t *testing.T) {
+	tests := []struct {
+		src  Dict
+		upd  Dict
+		want Dict
+	}{
+		{
+			Dict{"a": MarshalInt(1), "b": MarshalInt(2)},
+			Dict{"b": MarshalInt(3), "c": MarshalInt(4)},
+			Dict{"a": MarshalInt(1), "b": MarshalInt(3), "c": MarshalInt(4)},
+		},
+		{
+			Dict{"a": MarshalInt(1), "b": MarshalInt(2)},
+			Dict{"a": []byte{}, "z": []byte{}},
+			Dict{"b": MarshalInt(2)},
+		},
+		{
+			Dict{},
+			Dict{"a": MarshalInt(1)},
+			Dict{"a": MarshalInt(1)},
+		},
+	}
+	for _, test := range tests {
+		v, err := NewDictView(MarshalDict(test.src))
+		if err != nil {
+			t.Fatalf("NewDictView: %v", err)
+		}
+		buf, err := MergeDict(nil, v, test.upd)
+		if err != nil {
+			t.Fatalf("MergeDict: %v", err)
+		}
+		got, _, err := UnmarshalNullDict(buf)
+		if err != nil {
+			t.Fatalf("UnmarshalNullDict: %v", err)
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Fatalf("MergeDict(%v, %v): (-got +want)\n%s", test.src, test.upd, diff)
+		}
+	}
+}
+
+func benchDict() Dict {
+	d := make(Dict)
+	for i := 0; i < 32; i++ {
+		d[fmt.Sprintf("key%02d", i)] = MarshalInt(i)
+	}
+	return d
+}
+
+func BenchmarkDictGetUnmarshal(b *testing.B) {
+	buf := MarshalDict(benchDict())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d, _, _ := UnmarshalNullDict(buf)
+		Unmarshal(d["key16"])
+	}
+}
+
+func BenchmarkDictGetView(b *testing.B) {
+	buf := MarshalDict(benchDict())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		v, _ := NewDictView(buf)
+		v.GetInt("key16")
+	}
+}
+
+// msgRoomPropでの変更の適用
+func BenchmarkDictMergeMap(b *testing.B) {
+	buf := MarshalDict(benchDict())
+	upd := Dict{"key03": MarshalInt(100), "new": MarshalInt(1)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d, _, _ := UnmarshalNullDict(buf)
+		for k, v := range upd {
+			d[k] = v
+		}
+		MarshalDict(d)
+	}
+}
+
+func BenchmarkDictMergeView(b *testing.B) {
+	buf := MarshalDict(benchDict())
+	upd := Dict{"key03": MarshalInt(100), "new": MarshalInt(1)}
+	dst := make([]byte, 0, len(buf)*2)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		v, _ := NewDictView(buf)
+		MergeDict(dst[:0], v, upd)
+	}
+}
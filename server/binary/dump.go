@@ -0,0 +1,75 @@
+package binary
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// DumpEvent : イベントを人が読める1行の文字列にする (プロトコルのデバッグ用).
+// seqが負ならsequence numberを表示しない. payloadの表示はdumpPayloadを参照.
+func DumpEvent(ev Event, seq int) string {
+	var b strings.Builder
+	b.WriteString(ev.Type().String())
+	if seq >= 0 {
+		b.WriteString(" seq=" + strconv.Itoa(seq))
+	}
+	dumpPayload(&b, ev.Payload())
+	return b.String()
+}
+
+// DumpEventFrame : 受信したフレームをDumpEventの行にする. EvTypeBatchはイベント毎の行に分ける.
+func DumpEventFrame(data []byte) ([]string, error) {
+	ev, seq, err := UnmarshalEvent(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ev.(*SystemEvent); ok {
+		if ev.Type() != EvTypeBatch {
+			return []string{DumpEvent(ev, -1)}, nil
+		}
+		entries, err := UnmarshalBatchPayload(ev.Payload())
+		if err != nil {
+			return nil, xerrors.Errorf("batch: %w", err)
+		}
+		lines := make([]string, 0, len(entries))
+		for _, e := range entries {
+			ev, seq, err := UnmarshalEvent(e)
+			if err != nil {
+				return nil, xerrors.Errorf("batch entry: %w", err)
+			}
+			lines = append(lines, DumpEvent(ev, seq))
+		}
+		return lines, nil
+	}
+	return []string{DumpEvent(ev, seq)}, nil
+}
+
+// DumpMsg : Msgを人が読める1行の文字列にする (プロトコルのデバッグ用).
+func DumpMsg(msg Msg) string {
+	var b strings.Builder
+	b.WriteString(msg.Type().String())
+	if m, ok := msg.(RegularMsg); ok {
+		b.WriteString(" seq=" + strconv.Itoa(m.SequenceNum()))
+	}
+	dumpPayload(&b, msg.Payload())
+	return b.String()
+}
+
+// dumpPayload : payloadを値の並びとして解釈できればJSONで、できなければhexで書き込む.
+// 値の並びでないpayload (Pingのタイムスタンプなど) も解釈できてしまうことがあるので、hexも併記する.
+func dumpPayload(b *strings.Builder, payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+	if v, err := UnmarshalRecursive(payload); err == nil {
+		if j, err := json.Marshal(v); err == nil {
+			b.WriteString(" ")
+			b.Write(j)
+		}
+	}
+	b.WriteString(" hex=" + hex.EncodeToString(payload))
+}
@@ -0,0 +1,45 @@
+package binary
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDumpEventFrame(t *testing.T) {
+	msg := NewEvMessage("player", MarshalStr8("hi"))
+	lines, err := DumpEventFrame(msg.Marshal(3))
+	if err != nil {
+		t.Fatalf("DumpEventFrame: %v", err)
+	}
+	want := []string{`EvTypeMessage seq=3 ["player","hi"] hex=0f06706c617965720f026869`}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("lines = %q, wants %q", lines, want)
+	}
+
+	lines, err = DumpEventFrame(MarshalBatch([]*RegularEvent{msg, msg}, 4))
+	if err != nil {
+		t.Fatalf("DumpEventFrame(batch): %v", err)
+	}
+	if len(lines) != 2 || lines[1][:len("EvTypeMessage seq=5 ")] != "EvTypeMessage seq=5 " {
+		t.Fatalf("batch lines = %q", lines)
+	}
+
+	lines, _ = DumpEventFrame(NewEvPeerReady(10).Marshal())
+	if len(lines) != 1 || lines[0][:len("EvTypePeerReady ")] != "EvTypePeerReady " {
+		t.Fatalf("system event lines = %q", lines)
+	}
+
+	if _, err := DumpEventFrame(nil); err == nil {
+		t.Fatalf("DumpEventFrame(nil) must fail")
+	}
+}
+
+func TestDumpMsg(t *testing.T) {
+	msg := &regularMsg{MsgTypeBroadcast, 7, MarshalStr8("hi")}
+	if s, want := DumpMsg(msg), `MsgTypeBroadcast seq=7 "hi" hex=0f026869`; s != want {
+		t.Fatalf("DumpMsg = %q, wants %q", s, want)
+	}
+	if s, want := DumpMsg(&nonregularMsg{MsgTypePing, nil}), "MsgTypePing"; s != want {
+		t.Fatalf("DumpMsg = %q, wants %q", s, want)
+	}
+}
@@ -0,0 +1,69 @@
+package binary
+
+import (
+	"strconv"
+)
+
+// ErrorCode : クライアントに返すエラーの種類.
+// EvTypeErrorのpayloadやLobbyのレスポンスに含め、クライアントがメッセージ文字列に頼らず分岐できるようにする.
+type ErrorCode byte
+
+// ErrorCodeHeader : エラーのレスポンスでErrorCodeを通知するHTTPヘッダ
+const ErrorCodeHeader = "Wsnet2-Error-Code"
+
+const (
+	// ErrorCodeUnknown : 分類されていないエラー
+	ErrorCodeUnknown ErrorCode = iota
+	// ErrorCodeInvalidArgument : リクエストの引数が不正
+	ErrorCodeInvalidArgument
+	// ErrorCodeRoomNotFound : 部屋が存在しない
+	ErrorCodeRoomNotFound
+	// ErrorCodeRoomFull : 部屋が満員
+	ErrorCodeRoomFull
+	// ErrorCodeNotJoinable : 入室できる部屋が無い (Joinableでない, 検索条件に合う部屋が無いなど)
+	ErrorCodeNotJoinable
+	// ErrorCodeNotWatchable : 観戦できない部屋
+	ErrorCodeNotWatchable
+	// ErrorCodeBanned : 部屋からbanされている
+	ErrorCodeBanned
+	// ErrorCodeAlreadyJoined : 同じクライアントIDが既に入室している
+	ErrorCodeAlreadyJoined
+	// ErrorCodeRateLimited : 送信頻度の上限を超えた
+	ErrorCodeRateLimited
+	// ErrorCodeSchemaViolation : メッセージやプロパティの形式が不正, または上限を超えている
+	ErrorCodeSchemaViolation
+	// ErrorCodeRoomLimit : 部屋数の上限に達している
+	ErrorCodeRoomLimit
+	// ErrorCodePermissionDenied : 操作の権限が無い
+	ErrorCodePermissionDenied
+	// ErrorCodeAppMismatch : 接続先の部屋が別のappの部屋
+	ErrorCodeAppMismatch
+	// ErrorCodeGameServerDown : 部屋のあるgameサーバが停止している
+	ErrorCodeGameServerDown
+
+	errorCodeEnd
+)
+
+var errorCodeNames = [errorCodeEnd]string{
+	"Unknown",
+	"InvalidArgument",
+	"RoomNotFound",
+	"RoomFull",
+	"NotJoinable",
+	"NotWatchable",
+	"Banned",
+	"AlreadyJoined",
+	"RateLimited",
+	"SchemaViolation",
+	"RoomLimit",
+	"PermissionDenied",
+	"AppMismatch",
+	"GameServerDown",
+}
+
+func (c ErrorCode) String() string {
+	if c >= errorCodeEnd {
+		return "ErrorCode(" + strconv.Itoa(int(c)) + ")"
+	}
+	return errorCodeNames[c]
+}
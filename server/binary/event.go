@@ -15,8 +15,90 @@ const (
 	// NewEvPeerReady : Peer準備完了イベント
 	// payload:
 	// | 24bit-be msg sequence number |
+	// ProtocolVersionPingInterval以降は続けて
+	//  - UInt: ping interval (millisecond)
+	//  - UInt: client deadline (millisecond)
 	EvTypePeerReady EvType = 1 + iota
 	EvTypePong
+
+	// EvTypeBackpressure : 送信頻度を下げるよう求める
+	// payload:
+	//  - UInt: suggested send interval (millisecond)
+	//  - Byte: source (BackpressureSource)
+	EvTypeBackpressure
+
+	// EvTypeBatch : 複数のRegularEventを1つのフレームにまとめたもの (ProtocolVersionBatch以降)
+	// payload:
+	// | 32bit-be length | regular event | の繰り返し
+	EvTypeBatch
+
+	// EvTypeHubStatus : Hubとgameサーバ間の接続状態 (ProtocolVersionHubStatus以降)
+	// payload:
+	//  - Byte: status (HubStatus)
+	EvTypeHubStatus
+
+	// EvTypeRejoinRequested : 同じクライアントIDの別の接続が入室しようとしている (ProtocolVersionRejoinConfirm以降)
+	// MsgTypeConfirmRejoinで応答する
+	// payload:
+	//  - UInt: timeout (millisecond)
+	EvTypeRejoinRequested
+
+	// EvTypeDisplaced : 同じクライアントIDの別の接続に置き換えられた (ProtocolVersionRejoinConfirm以降)
+	// この後websocketはCloseReasonDisplacedで閉じられる
+	// payload:
+	//  - Byte: reason (DisplacedReason)
+	EvTypeDisplaced
+
+	// EvTypeError : エラーの通知 (ProtocolVersionErrorEvent以降)
+	// 不正なメッセージで切断するときは、Close frameの前に送る
+	// payload:
+	//  - Byte: code (ErrorCode)
+	//  - str16: message
+	EvTypeError
+
+	// EvTypeSlowConsumer : イベントの受信が遅れている警告 (ProtocolVersionSlowConsumer以降)
+	// 部屋の設定によっては、この後websocketはCloseReasonSlowConsumerで閉じられる
+	// payload:
+	//  - UInt: lag (送信時に溜まっていたイベント数)
+	//  - UInt: latency (送信にかかった時間; millisecond)
+	EvTypeSlowConsumer
+
+	// EvTypeDeadlineChanged : ClientDeadlineの変更 (ProtocolVersionDeadlineChanged以降)
+	// サーバは次にクライアントからメッセージ (Ping) が届いてから新しいdeadlineを適用する.
+	// 対応しているのはGoのクライアントのみ
+	// payload:
+	//  - UInt: ping interval (millisecond)
+	//  - UInt: client deadline (millisecond)
+	EvTypeDeadlineChanged
+)
+
+// ProtocolVersionHeader : クライアントが対応するプロトコルバージョンを通知するHTTPヘッダ
+const ProtocolVersionHeader = "Wsnet2-ProtocolVersion"
+
+const (
+	// ProtocolVersion1 : RegularEventを1つずつのフレームで送る
+	ProtocolVersion1 = 1
+	// ProtocolVersionBatch : RegularEventをEvTypeBatchのフレームにまとめて送ることがある
+	ProtocolVersionBatch = 2
+	// ProtocolVersionHubStatus : Hubから観戦者へEvTypeHubStatusを送ることがある
+	ProtocolVersionHubStatus = 3
+	// ProtocolVersionRejoinConfirm : 同じクライアントIDの再入室時にEvTypeRejoinRequestedとEvTypeDisplacedを送ることがある
+	ProtocolVersionRejoinConfirm = 4
+	// ProtocolVersionRedaction : 審査で取り消されたメッセージをEvTypeRedactedで通知することがある
+	ProtocolVersionRedaction = 5
+	// ProtocolVersionEncryption : EvTypeEncryptedとEvTypeKeyExchangeを受け取れる
+	ProtocolVersionEncryption = 6
+	// ProtocolVersionErrorEvent : エラーの種類をEvTypeErrorで通知することがある
+	ProtocolVersionErrorEvent = 7
+	// ProtocolVersionSlowConsumer : 受信の遅れをEvTypeSlowConsumerで警告することがある
+	ProtocolVersionSlowConsumer = 8
+	// ProtocolVersionPingInterval : EvTypePeerReadyでPingの間隔とdeadlineを指定する
+	ProtocolVersionPingInterval = 9
+	// ProtocolVersionDeadlineChanged : ClientDeadlineの変更をEvTypeDeadlineChangedで通知する
+	ProtocolVersionDeadlineChanged = 10
+
+	// ProtocolVersionLatest : サーバが対応する最新のプロトコルバージョン
+	ProtocolVersionLatest = ProtocolVersionDeadlineChanged
 )
 const (
 	// EvTypeJoined : クライアントが入室した
@@ -29,6 +111,9 @@ const (
 	// payload:
 	//  - str8: client ID
 	//  - str8: master client ID
+	//  - str8: cause
+	//  - Byte: kick reason code (kickされたときのみ)
+	//  - UInt: ban duration (second, kickされたときのみ)
 	EvTypeLeft
 
 	// EvTypeRoomProp : 部屋情報の変更
@@ -61,6 +146,142 @@ const (
 	//  - str8: client ID
 	//  - Dict: properties
 	EvTypeRejoined
+
+	// EvTypeKVUpdated : 部屋KVストアの変更
+	// payload:
+	//  - str8: client ID (サーバによる変更のときは空文字列)
+	//  - Dict: values (modified keys only. 削除されたキーは空)
+	//  - Dict: owners (modified keys only. str8: owner client ID)
+	EvTypeKVUpdated
+
+	// EvTypeVoteStarted : 投票が開始された
+	// payload:
+	//  - str8: client ID
+	//  - str8: vote id
+	//  - List: options (str8)
+	//  - UShort: duration (second)
+	EvTypeVoteStarted
+
+	// EvTypeVoteResult : 投票の結果
+	// payload:
+	//  - str8: vote id
+	//  - UShorts: count of each option
+	//  - Dict: votes (key: client ID, value: Byte option index)
+	EvTypeVoteResult
+
+	// EvTypeAdminMessage : 管理者からのメッセージ
+	// payload:
+	//  - string: message
+	EvTypeAdminMessage
+
+	// EvTypeRoomClosed : 部屋が管理者により閉じられる
+	// payload:
+	//  - str8: reason
+	EvTypeRoomClosed
+
+	// EvTypeHistory : 入室前にbroadcastされたメッセージ (MsgTypeFetchHistoryへの応答)
+	// payload:
+	//  - repeat (古い順):
+	//    - 32bit-be: length
+	//    - EvTypeMessageのpayload
+	EvTypeHistory
+
+	// EvTypeMasterSwitchRequested : Masterの移譲を打診された (MsgTypeAcceptMasterで受諾する)
+	// payload:
+	//  - str8: current master client ID
+	EvTypeMasterSwitchRequested
+
+	// EvTypeServerMessage : appのサーバからpush APIで送られたメッセージ
+	// payload:
+	//  - str8: target client ID (部屋全体へのときは空文字列)
+	//  - marshaled bytes: data
+	EvTypeServerMessage
+
+	// EvTypeRedacted : 審査により取り消されたメッセージ (ProtocolVersionRedaction以降)
+	// payload:
+	//  - str8: sender client ID
+	//  - marshaled bytes: 取り消されたメッセージのdata
+	EvTypeRedacted
+
+	// EvTypeEncrypted : 暗号化されたメッセージ (ProtocolVersionEncryption以降)
+	// payload:
+	//  - str8: sender client ID
+	//  - envelope bytes...
+	EvTypeEncrypted
+
+	// EvTypeKeyExchange : 暗号化のための鍵交換メッセージ (ProtocolVersionEncryption以降)
+	// payload:
+	//  - str8: sender client ID
+	//  - key exchange bytes...
+	EvTypeKeyExchange
+
+	// EvTypeRoomPaused : 部屋が一時停止された
+	// payload:
+	//  - str8: client ID
+	EvTypeRoomPaused
+
+	// EvTypeRoomResumed : 部屋の一時停止が解除された
+	// payload:
+	//  - str8: client ID (一時停止の上限時間を過ぎて解除されたときは空文字列)
+	//  - UInt: 一時停止していた時間 (millisecond)
+	EvTypeRoomResumed
+
+	// EvTypeRoomSuccessor : Masterが後継の部屋を作った. Lobbyの招待での入室に使うトークンを含む
+	// payload:
+	//  - str8: successor room ID
+	//  - str16: join token
+	//  - ULong: token expire (unixtime sec)
+	EvTypeRoomSuccessor
+
+	// EvTypeChildRoomMessage : 子の部屋から中継されたメッセージ (ロビー部屋のみ)
+	// payload:
+	//  - str8: child room ID
+	//  - str8: sender client ID (RoomRelayClosedのときは空文字列)
+	//  - Byte: kind (see RoomRelayKind)
+	//  - marshaled data
+	EvTypeChildRoomMessage
+
+	// EvTypePlayerStale : Playerの最後のメッセージから部屋の閾値を過ぎた (Masterのみ)
+	// payload:
+	//  - str8: client ID
+	//  - UInt: 最後のメッセージからの経過時間 (millisecond)
+	EvTypePlayerStale
+
+	// EvTypePlayerRecovered : EvTypePlayerStaleを通知したPlayerからメッセージが届いた (Masterのみ)
+	// payload:
+	//  - str8: client ID
+	EvTypePlayerRecovered
+
+	// EvTypeBotJoined : MasterがボットのPlayerを追加した
+	// payload:
+	//  - str8: bot client ID
+	//  - Dict: properties
+	EvTypeBotJoined
+
+	// EvTypePromoteRequested : 観戦者がPlayerへの昇格を申請した (Masterのみ)
+	// MsgTypePromoteWatcherで承認する
+	// payload:
+	//  - str8: watcher client ID
+	EvTypePromoteRequested
+
+	// EvTypePromoteRedirect : Hub経由の観戦者の昇格が承認された
+	// Hub経由では昇格できないので、観戦をやめてPlayerとして入室し直す. 席は確保しない
+	// payload:
+	//  - str8: watcher client ID
+	EvTypePromoteRedirect
+
+	// EvTypePlayerDemoted : Kickされたが切断されずに観戦者になった (EvTypeLeftの後に送る)
+	// payload:
+	//  - str8: client ID
+	EvTypePlayerDemoted
+
+	// EvTypeRoomMerged : 部屋が別の部屋に統合される. Lobbyの招待での入室に使うトークンを含む
+	// 統合先の部屋には席が確保されている. この後EvTypeRoomClosedが届いて部屋は閉じる
+	// payload: (EvTypeRoomSuccessorと同じ)
+	//  - str8: 統合先の room ID
+	//  - str16: join token
+	//  - ULong: token expire (unixtime sec)
+	EvTypeRoomMerged
 )
 const (
 	// EvTypeSucceeded:
@@ -80,6 +301,13 @@ const (
 	//  - List: client IDs
 	//  - marshaled bytes: original msg payload
 	EvTypeTargetNotFound
+
+	// EvTypeKVConflict : KVストアの比較失敗
+	// payload:
+	//  - 24bit be: Msg sequence num
+	//  - marshaled bytes: current value (Null: キーが存在しない)
+	//  - marshaled bytes: original msg payload
+	EvTypeKVConflict
 )
 
 type Event interface {
@@ -115,14 +343,71 @@ func NewRegularEvent(etype EvType, payload []byte) *RegularEvent {
 	return &RegularEvent{etype, payload}
 }
 
+// RegularEventHeaderSize : RegularEventのヘッダ (EvType + sequence number) の長さ
+const RegularEventHeaderSize = 5
+
 func (ev *RegularEvent) Marshal(seqNum int) []byte {
-	buf := make([]byte, len(ev.payload)+5)
-	buf[0] = byte(ev.etype)
-	put32(buf[1:], int64(seqNum))
-	copy(buf[5:], ev.payload)
+	buf := make([]byte, len(ev.payload)+RegularEventHeaderSize)
+	ev.PutHeader(buf, seqNum)
+	copy(buf[RegularEventHeaderSize:], ev.payload)
+	return buf
+}
+
+// PutHeader : sequence numberを含むヘッダをdstに書き込む.
+// payloadは送信先によらず共通なので、ヘッダとpayloadを続けて書き込めば
+// 送信先ごとにイベント全体を複製しなくてよい.
+func (ev *RegularEvent) PutHeader(dst []byte, seqNum int) {
+	dst[0] = byte(ev.etype)
+	put32(dst[1:], int64(seqNum))
+}
+
+// BatchEntryHeaderSize : EvTypeBatchフレーム内の各イベントのヘッダ (length + RegularEventのヘッダ) の長さ
+const BatchEntryHeaderSize = 4 + RegularEventHeaderSize
+
+// PutBatchEntryHeader : EvTypeBatchフレーム内のイベントのヘッダをdstに書き込む.
+// 続けてpayloadを書き込む.
+func (ev *RegularEvent) PutBatchEntryHeader(dst []byte, seqNum int) {
+	put32(dst, int64(RegularEventHeaderSize+len(ev.payload)))
+	ev.PutHeader(dst[4:], seqNum)
+}
+
+// MarshalBatch : evsを連番のsequence numberでEvTypeBatchフレームにする.
+// seqNumは最初のイベントのsequence number.
+func MarshalBatch(evs []*RegularEvent, seqNum int) []byte {
+	size := 1
+	for _, ev := range evs {
+		size += BatchEntryHeaderSize + len(ev.payload)
+	}
+	buf := make([]byte, 1, size)
+	buf[0] = byte(EvTypeBatch)
+	for i, ev := range evs {
+		var hdr [BatchEntryHeaderSize]byte
+		ev.PutBatchEntryHeader(hdr[:], seqNum+i)
+		buf = append(buf, hdr[:]...)
+		buf = append(buf, ev.payload...)
+	}
 	return buf
 }
 
+// UnmarshalBatchPayload : EvTypeBatchのpayloadを個々のイベントのバイト列に分割する.
+// 各要素はUnmarshalEventで復元できる.
+func UnmarshalBatchPayload(payload []byte) ([][]byte, error) {
+	var evs [][]byte
+	for len(payload) > 0 {
+		if len(payload) < 4 {
+			return nil, xerrors.Errorf("batch length not enough: %v", len(payload))
+		}
+		l := get32(payload)
+		payload = payload[4:]
+		if l < RegularEventHeaderSize || len(payload) < l {
+			return nil, xerrors.Errorf("invalid batch entry length: %v (remains %v)", l, len(payload))
+		}
+		evs = append(evs, payload[:l])
+		payload = payload[l:]
+	}
+	return evs, nil
+}
+
 // ParseMsg parse binary data to Event struct
 func UnmarshalEvent(data []byte) (Event, int, error) {
 	if len(data) < 1 {
@@ -148,6 +433,11 @@ func UnmarshalEvent(data []byte) (Event, int, error) {
 // SystemEvent (without sequence number)
 // - EvTypePeerReady
 // - EvTypePong
+// - EvTypeBackpressure
+// - EvTypeBatch
+// - EvTypeHubStatus
+// - EvTypeRejoinRequested
+// - EvTypeDisplaced
 // binary format:
 // | 8bit MsgType | payload ... |
 type SystemEvent struct {
@@ -187,6 +477,56 @@ func UnmarshalEvPeerReadyPayload(payload []byte) (int, error) {
 	return get24(payload), nil
 }
 
+// NewEvPeerReadyWithPing : Pingの間隔とdeadlineを指定するPeer準備完了イベント (ProtocolVersionPingInterval以降)
+// クライアントはdeadlineを超えないようにpingIntervalの間隔でPingを送る.
+// payload:
+// | 24bit-be msg sequence number |
+// - UInt: ping interval (millisecond)
+// - UInt: client deadline (millisecond)
+func NewEvPeerReadyWithPing(seqNum int, pingIntervalMilli, deadlineMilli uint32) *SystemEvent {
+	payload := make([]byte, 3)
+	put24(payload, int64(seqNum))
+	payload = append(payload, MarshalUInt(int(pingIntervalMilli))...)
+	payload = append(payload, MarshalUInt(int(deadlineMilli))...)
+	return &SystemEvent{
+		etype:   EvTypePeerReady,
+		payload: payload,
+	}
+}
+
+type EvPeerReadyPayload struct {
+	LastMsgSeq        int
+	PingIntervalMilli uint32 // 0ならサーバの指定なし
+	DeadlineMilli     uint32 // 0ならサーバの指定なし
+}
+
+// UnmarshalEvPeerReadyPingPayload : Pingの間隔とdeadlineを含めて取り出す. 含まない古いサーバのpayloadも受け付ける
+func UnmarshalEvPeerReadyPingPayload(payload []byte) (*EvPeerReadyPayload, error) {
+	seq, err := UnmarshalEvPeerReadyPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	pr := EvPeerReadyPayload{LastMsgSeq: seq}
+	payload = payload[3:]
+	if len(payload) == 0 {
+		return &pr, nil
+	}
+
+	d, l, e := UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvPeerReady payload (ping interval): %w", e)
+	}
+	pr.PingIntervalMilli = uint32(d.(int))
+
+	d, _, e = UnmarshalAs(payload[l:], TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvPeerReady payload (deadline): %w", e)
+	}
+	pr.DeadlineMilli = uint32(d.(int))
+
+	return &pr, nil
+}
+
 // NewEvPong : Pongイベント
 // payload:
 // - unsigned 64bit-be: timestamp on ping sent.
@@ -237,6 +577,223 @@ func UnmarshalEvPongPayload(payload []byte) (*EvPongPayload, error) {
 	return &pp, nil
 }
 
+// BackpressureSource : 詰まっているキューの種類
+type BackpressureSource byte
+
+const (
+	// BackpressureMsgQueue : 部屋のMsgキュー
+	BackpressureMsgQueue BackpressureSource = 1 + iota
+	// BackpressureEventQueue : クライアントへのEvent送信キュー
+	BackpressureEventQueue
+)
+
+// NewEvBackpressure : 送信頻度を下げるよう求めるイベント
+// payload:
+// - UInt: suggested send interval (millisecond)
+// - Byte: source
+func NewEvBackpressure(intervalMilli uint32, src BackpressureSource) *SystemEvent {
+	payload := MarshalUInt(int(intervalMilli))
+	payload = append(payload, MarshalByte(int(src))...)
+
+	return &SystemEvent{
+		etype:   EvTypeBackpressure,
+		payload: payload,
+	}
+}
+
+type EvBackpressurePayload struct {
+	IntervalMilli uint32
+	Source        BackpressureSource
+}
+
+func UnmarshalEvBackpressurePayload(payload []byte) (*EvBackpressurePayload, error) {
+	bp := EvBackpressurePayload{}
+
+	// interval
+	d, l, e := UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvBackpressure payload (interval): %w", e)
+	}
+	bp.IntervalMilli = uint32(d.(int))
+	payload = payload[l:]
+
+	// source
+	d, _, e = UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvBackpressure payload (source): %w", e)
+	}
+	bp.Source = BackpressureSource(d.(int))
+
+	return &bp, nil
+}
+
+// HubStatus : Hubとgameサーバ間の接続状態
+type HubStatus byte
+
+const (
+	// HubStatusConnected : gameサーバと接続している
+	HubStatusConnected HubStatus = 1 + iota
+	// HubStatusDegraded : gameサーバと再接続中. 再接続するまでイベントは届かない
+	HubStatusDegraded
+)
+
+// NewEvHubStatus : Hubとgameサーバ間の接続状態の通知イベント
+// payload:
+// - Byte: status
+func NewEvHubStatus(status HubStatus) *SystemEvent {
+	return &SystemEvent{
+		etype:   EvTypeHubStatus,
+		payload: MarshalByte(int(status)),
+	}
+}
+
+func UnmarshalEvHubStatusPayload(payload []byte) (HubStatus, error) {
+	d, _, e := UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return 0, xerrors.Errorf("Invalid EvHubStatus payload (status): %w", e)
+	}
+	return HubStatus(d.(int)), nil
+}
+
+// NewEvRejoinRequested : 同じクライアントIDの別の接続からの入室の確認を求めるイベント
+// payload:
+// - UInt: timeout (millisecond)
+func NewEvRejoinRequested(timeoutMilli uint32) *SystemEvent {
+	return &SystemEvent{
+		etype:   EvTypeRejoinRequested,
+		payload: MarshalUInt(int(timeoutMilli)),
+	}
+}
+
+func UnmarshalEvRejoinRequestedPayload(payload []byte) (uint32, error) {
+	d, _, e := UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return 0, xerrors.Errorf("Invalid EvRejoinRequested payload (timeout): %w", e)
+	}
+	return uint32(d.(int)), nil
+}
+
+// DisplacedReason : 別の接続に置き換えられた理由
+type DisplacedReason byte
+
+const (
+	// DisplacedByRejoin : 確認せずに新しい接続に置き換えた
+	DisplacedByRejoin DisplacedReason = 1 + iota
+	// DisplacedConfirmed : EvTypeRejoinRequestedに受諾したので置き換えた
+	DisplacedConfirmed
+)
+
+// NewEvDisplaced : 同じクライアントIDの別の接続に置き換えられたことの通知イベント
+// payload:
+// - Byte: reason
+func NewEvDisplaced(reason DisplacedReason) *SystemEvent {
+	return &SystemEvent{
+		etype:   EvTypeDisplaced,
+		payload: MarshalByte(int(reason)),
+	}
+}
+
+func UnmarshalEvDisplacedPayload(payload []byte) (DisplacedReason, error) {
+	d, _, e := UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return 0, xerrors.Errorf("Invalid EvDisplaced payload (reason): %w", e)
+	}
+	return DisplacedReason(d.(int)), nil
+}
+
+// NewEvError : エラーの種類とメッセージの通知イベント
+// payload:
+// - Byte: code
+// - str16: message
+func NewEvError(code ErrorCode, msg string) *SystemEvent {
+	payload := MarshalByte(int(code))
+	payload = append(payload, MarshalStr16(msg)...)
+	return &SystemEvent{
+		etype:   EvTypeError,
+		payload: payload,
+	}
+}
+
+func UnmarshalEvErrorPayload(payload []byte) (ErrorCode, string, error) {
+	d, l, e := UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return 0, "", xerrors.Errorf("Invalid EvError payload (code): %w", e)
+	}
+	code := ErrorCode(d.(int))
+
+	d, _, e = UnmarshalAs(payload[l:], TypeStr16)
+	if e != nil {
+		return 0, "", xerrors.Errorf("Invalid EvError payload (message): %w", e)
+	}
+	return code, d.(string), nil
+}
+
+// NewEvSlowConsumer : 受信の遅れの警告イベント
+func NewEvSlowConsumer(lag int, latencyMilli uint32) *SystemEvent {
+	payload := MarshalUInt(lag)
+	payload = append(payload, MarshalUInt(int(latencyMilli))...)
+	return &SystemEvent{
+		etype:   EvTypeSlowConsumer,
+		payload: payload,
+	}
+}
+
+type EvSlowConsumerPayload struct {
+	Lag          uint32
+	LatencyMilli uint32
+}
+
+func UnmarshalEvSlowConsumerPayload(payload []byte) (*EvSlowConsumerPayload, error) {
+	sc := EvSlowConsumerPayload{}
+
+	d, l, e := UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvSlowConsumer payload (lag): %w", e)
+	}
+	sc.Lag = uint32(d.(int))
+
+	d, _, e = UnmarshalAs(payload[l:], TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvSlowConsumer payload (latency): %w", e)
+	}
+	sc.LatencyMilli = uint32(d.(int))
+
+	return &sc, nil
+}
+
+// NewEvDeadlineChanged : ClientDeadlineの変更イベント
+func NewEvDeadlineChanged(pingIntervalMilli, deadlineMilli uint32) *SystemEvent {
+	payload := MarshalUInt(int(pingIntervalMilli))
+	payload = append(payload, MarshalUInt(int(deadlineMilli))...)
+	return &SystemEvent{
+		etype:   EvTypeDeadlineChanged,
+		payload: payload,
+	}
+}
+
+type EvDeadlineChangedPayload struct {
+	PingIntervalMilli uint32
+	DeadlineMilli     uint32
+}
+
+func UnmarshalEvDeadlineChangedPayload(payload []byte) (*EvDeadlineChangedPayload, error) {
+	dc := EvDeadlineChangedPayload{}
+
+	d, l, e := UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvDeadlineChanged payload (ping interval): %w", e)
+	}
+	dc.PingIntervalMilli = uint32(d.(int))
+
+	d, _, e = UnmarshalAs(payload[l:], TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvDeadlineChanged payload (deadline): %w", e)
+	}
+	dc.DeadlineMilli = uint32(d.(int))
+
+	return &dc, nil
+}
+
 // NewEvJoind : 入室イベント
 func NewEvJoined(cli *pb.ClientInfo) *RegularEvent {
 	payload := MarshalStr8(cli.Id)
@@ -303,10 +860,21 @@ func NewEvLeft(cliId, masterId, cause string) *RegularEvent {
 	return &RegularEvent{EvTypeLeft, payload}
 }
 
+// NewEvLeftByKick : Kickによる退室イベント
+func NewEvLeftByKick(cliId, masterId, cause string, reason KickReason, banDuration uint32) *RegularEvent {
+	ev := NewEvLeft(cliId, masterId, cause)
+	ev.payload = append(ev.payload, MarshalByte(int(reason))...)
+	ev.payload = append(ev.payload, MarshalUInt(int(banDuration))...)
+	return ev
+}
+
 type EvLeftPayload struct {
-	ClientId string
-	MasterId string
-	Cause    string
+	ClientId    string
+	MasterId    string
+	Cause       string
+	Kicked      bool
+	Reason      KickReason
+	BanDuration uint32
 }
 
 func UnmarshalEvLeftPayload(payload []byte) (*EvLeftPayload, error) {
@@ -328,11 +896,30 @@ func UnmarshalEvLeftPayload(payload []byte) (*EvLeftPayload, error) {
 	um.MasterId = d.(string)
 	payload = payload[l:]
 
-	c, _, e := UnmarshalAs(payload, TypeStr8)
+	c, l, e := UnmarshalAs(payload, TypeStr8)
 	if e != nil {
 		return nil, xerrors.Errorf("Invalid EvLeft payload (cause): %w", e)
 	}
 	um.Cause, _ = c.(string) // cause is "" when c is nil.
+	payload = payload[l:]
+
+	// kick reason, ban duration はkickされたときのみ
+	if len(payload) == 0 {
+		return &um, nil
+	}
+	um.Kicked = true
+	d, l, e = UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvLeft payload (reason): %w", e)
+	}
+	um.Reason = KickReason(d.(int))
+	payload = payload[l:]
+
+	d, _, e = UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvLeft payload (ban duration): %w", e)
+	}
+	um.BanDuration = uint32(d.(int))
 
 	return &um, nil
 }
@@ -416,6 +1003,79 @@ func UnmarshalEvMasterSwitchedPayload(payload []byte) (string, error) {
 	return d.(string), nil
 }
 
+func NewEvMasterSwitchRequested(masterId string) *RegularEvent {
+	return &RegularEvent{EvTypeMasterSwitchRequested, MarshalStr8(masterId)}
+}
+
+func UnmarshalEvMasterSwitchRequestedPayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid EvMasterSwitchRequested payload (master id): %w", e)
+	}
+
+	return d.(string), nil
+}
+
+// NewEvServerMessage : appのサーバからのメッセージイベント
+// dataはマーシャル済みの値
+func NewEvServerMessage(target string, data []byte) *RegularEvent {
+	payload := make([]byte, 0, len(target)+2+len(data))
+	payload = append(payload, MarshalStr8(target)...)
+	payload = append(payload, data...)
+	return &RegularEvent{EvTypeServerMessage, payload}
+}
+
+func UnmarshalEvServerMessagePayload(payload []byte) (target string, data []byte, err error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", nil, xerrors.Errorf("Invalid EvServerMessage payload (target): %w", e)
+	}
+	return d.(string), payload[l:], nil
+}
+
+// NewEvRedacted : 審査により取り消されたメッセージのイベント
+// dataは取り消されたメッセージのマーシャル済みの値
+func NewEvRedacted(sender string, data []byte) *RegularEvent {
+	payload := make([]byte, 0, len(sender)+2+len(data))
+	payload = append(payload, MarshalStr8(sender)...)
+	payload = append(payload, data...)
+	return &RegularEvent{EvTypeRedacted, payload}
+}
+
+func UnmarshalEvRedactedPayload(payload []byte) (sender string, data []byte, err error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", nil, xerrors.Errorf("Invalid EvRedacted payload (sender): %w", e)
+	}
+	return d.(string), payload[l:], nil
+}
+
+// NewEvEncrypted : 暗号化されたメッセージのイベント. envelopeは解釈せずにそのまま送る
+func NewEvEncrypted(sender string, envelope []byte) *RegularEvent {
+	return newEvSenderBytes(EvTypeEncrypted, sender, envelope)
+}
+
+// NewEvKeyExchange : 鍵交換メッセージのイベント. keyは解釈せずにそのまま送る
+func NewEvKeyExchange(sender string, key []byte) *RegularEvent {
+	return newEvSenderBytes(EvTypeKeyExchange, sender, key)
+}
+
+func newEvSenderBytes(etype EvType, sender string, data []byte) *RegularEvent {
+	payload := make([]byte, 0, len(sender)+2+len(data))
+	payload = append(payload, MarshalStr8(sender)...)
+	payload = append(payload, data...)
+	return &RegularEvent{etype, payload}
+}
+
+// UnmarshalEvEncryptedPayload : EvTypeEncryptedとEvTypeKeyExchangeのpayloadを送信元とenvelopeに分ける
+func UnmarshalEvEncryptedPayload(payload []byte) (sender string, envelope []byte, err error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", nil, xerrors.Errorf("Invalid EvEncrypted payload (sender): %w", e)
+	}
+	return d.(string), payload[l:], nil
+}
+
 func NewEvMessage(cliId string, body []byte) *RegularEvent {
 	payload := make([]byte, 0, len(cliId)+1+len(body))
 	payload = append(payload, MarshalStr8(cliId)...)
@@ -432,6 +1092,375 @@ func UnmarshalEvMessage(payload []byte) (cliId string, body []byte, err error) {
 	return d.(string), payload[p:], nil
 }
 
+// NewEvKVUpdated : 部屋KVストア変更イベント
+func NewEvKVUpdated(cliId string, values, owners Dict) *RegularEvent {
+	payload := MarshalStr8(cliId)
+	payload = append(payload, MarshalDict(values)...)
+	payload = append(payload, MarshalDict(owners)...)
+	return &RegularEvent{EvTypeKVUpdated, payload}
+}
+
+type EvKVUpdatedPayload struct {
+	ClientId string
+	Values   Dict
+	Owners   Dict
+}
+
+func UnmarshalEvKVUpdatedPayload(payload []byte) (*EvKVUpdatedPayload, error) {
+	um := EvKVUpdatedPayload{}
+
+	// client id
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvKVUpdated payload (client id): %w", e)
+	}
+	um.ClientId = d.(string)
+	payload = payload[l:]
+
+	// values
+	um.Values, l, e = UnmarshalNullDict(payload)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvKVUpdated payload (values): %w", e)
+	}
+	payload = payload[l:]
+
+	// owners
+	um.Owners, _, e = UnmarshalNullDict(payload)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvKVUpdated payload (owners): %w", e)
+	}
+
+	return &um, nil
+}
+
+// NewEvVoteStarted : 投票開始イベント
+func NewEvVoteStarted(cliId string, svp *MsgStartVotePayload) *RegularEvent {
+	payload := make([]byte, 0, len(cliId)+2+len(svp.EventPayload))
+	payload = append(payload, MarshalStr8(cliId)...)
+	payload = append(payload, svp.EventPayload...)
+	return &RegularEvent{EvTypeVoteStarted, payload}
+}
+
+type EvVoteStartedPayload struct {
+	ClientId string
+	VoteId   string
+	Options  []string
+	Duration uint32
+}
+
+func UnmarshalEvVoteStartedPayload(payload []byte) (*EvVoteStartedPayload, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvVoteStarted payload (client id): %w", e)
+	}
+	svp, e := UnmarshalStartVotePayload(payload[l:])
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvVoteStarted payload: %w", e)
+	}
+	return &EvVoteStartedPayload{
+		ClientId: d.(string),
+		VoteId:   svp.VoteId,
+		Options:  svp.Options,
+		Duration: svp.Duration,
+	}, nil
+}
+
+// NewEvVoteResult : 投票結果イベント
+func NewEvVoteResult(voteId string, counts []int, votes Dict) *RegularEvent {
+	payload := MarshalStr8(voteId)
+	payload = append(payload, MarshalUShorts(counts)...)
+	payload = append(payload, MarshalDict(votes)...)
+	return &RegularEvent{EvTypeVoteResult, payload}
+}
+
+type EvVoteResultPayload struct {
+	VoteId string
+	Counts []int
+	Votes  Dict
+}
+
+func UnmarshalEvVoteResultPayload(payload []byte) (*EvVoteResultPayload, error) {
+	um := EvVoteResultPayload{}
+
+	// vote id
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvVoteResult payload (vote id): %w", e)
+	}
+	um.VoteId = d.(string)
+	payload = payload[l:]
+
+	// counts
+	d, l, e = UnmarshalAs(payload, TypeUShorts)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvVoteResult payload (counts): %w", e)
+	}
+	um.Counts = d.([]int)
+	payload = payload[l:]
+
+	// votes
+	um.Votes, _, e = UnmarshalNullDict(payload)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvVoteResult payload (votes): %w", e)
+	}
+
+	return &um, nil
+}
+
+// NewEvAdminMessage : 管理者メッセージイベント
+func NewEvAdminMessage(message string) *RegularEvent {
+	return &RegularEvent{EvTypeAdminMessage, MarshalStr16(message)}
+}
+
+func UnmarshalEvAdminMessagePayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8, TypeStr16)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid EvAdminMessage payload (message): %w", e)
+	}
+	return d.(string), nil
+}
+
+// サーバが部屋を閉じたときのEvTypeRoomClosedのreason
+const (
+	// RoomClosedExpired : 寿命(RoomOption.Lifetime)が過ぎた
+	RoomClosedExpired = "expired"
+	// RoomClosedMasterLeft : RoomOption.CloseOnMasterLeaveの部屋でMasterが退室した
+	RoomClosedMasterLeft = "master left"
+	// RoomClosedMerged : 別の部屋に統合された (EvTypeRoomMerged)
+	RoomClosedMerged = "merged"
+)
+
+// NewEvRoomClosed : 部屋終了イベント
+func NewEvRoomClosed(reason string) *RegularEvent {
+	return &RegularEvent{EvTypeRoomClosed, MarshalStr8(reason)}
+}
+
+func UnmarshalEvRoomClosedPayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid EvRoomClosed payload (reason): %w", e)
+	}
+	return d.(string), nil
+}
+
+func NewEvRoomPaused(cliId string) *RegularEvent {
+	return &RegularEvent{EvTypeRoomPaused, MarshalStr8(cliId)}
+}
+
+func UnmarshalEvRoomPausedPayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid EvRoomPaused payload (client id): %w", e)
+	}
+	return d.(string), nil
+}
+
+func NewEvRoomResumed(cliId string, pausedMilli uint32) *RegularEvent {
+	payload := MarshalStr8(cliId)
+	payload = append(payload, MarshalUInt(int(pausedMilli))...)
+	return &RegularEvent{EvTypeRoomResumed, payload}
+}
+
+type EvRoomResumedPayload struct {
+	ClientId    string
+	PausedMilli uint32
+}
+
+func UnmarshalEvRoomResumedPayload(payload []byte) (*EvRoomResumedPayload, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvRoomResumed payload (client id): %w", e)
+	}
+	um := EvRoomResumedPayload{ClientId: d.(string)}
+
+	d, _, e = UnmarshalAs(payload[l:], TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvRoomResumed payload (paused): %w", e)
+	}
+	um.PausedMilli = uint32(d.(int))
+	return &um, nil
+}
+
+func NewEvRoomSuccessor(roomId, token string, expire uint64) *RegularEvent {
+	payload := MarshalStr8(roomId)
+	payload = append(payload, MarshalStr16(token)...)
+	payload = append(payload, MarshalULong(expire)...)
+	return &RegularEvent{EvTypeRoomSuccessor, payload}
+}
+
+// NewEvRoomMerged : payloadはEvTypeRoomSuccessorと同じで、UnmarshalEvRoomSuccessorPayloadで読む
+func NewEvRoomMerged(roomId, token string, expire uint64) *RegularEvent {
+	return &RegularEvent{EvTypeRoomMerged, NewEvRoomSuccessor(roomId, token, expire).payload}
+}
+
+type EvRoomSuccessorPayload struct {
+	RoomId string
+	Token  string
+	Expire uint64
+}
+
+func UnmarshalEvRoomSuccessorPayload(payload []byte) (*EvRoomSuccessorPayload, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvRoomSuccessor payload (room id): %w", e)
+	}
+	um := EvRoomSuccessorPayload{RoomId: d.(string)}
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeStr16)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvRoomSuccessor payload (token): %w", e)
+	}
+	um.Token = d.(string)
+	payload = payload[l:]
+
+	d, _, e = UnmarshalAs(payload, TypeULong)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvRoomSuccessor payload (expire): %w", e)
+	}
+	um.Expire = d.(uint64)
+	return &um, nil
+}
+
+func NewEvChildRoomMessage(roomId, sender string, kind RoomRelayKind, data []byte) *RegularEvent {
+	payload := make([]byte, 0, 2+len(roomId)+2+len(sender)+2+len(data))
+	payload = append(payload, MarshalStr8(roomId)...)
+	payload = append(payload, MarshalStr8(sender)...)
+	payload = append(payload, MarshalByte(int(kind))...)
+	payload = append(payload, data...)
+	return &RegularEvent{EvTypeChildRoomMessage, payload}
+}
+
+type EvChildRoomMessagePayload struct {
+	RoomId string
+	Sender string
+	Kind   RoomRelayKind
+	Data   []byte
+}
+
+func UnmarshalEvChildRoomMessagePayload(payload []byte) (*EvChildRoomMessagePayload, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvChildRoomMessage payload (room id): %w", e)
+	}
+	um := EvChildRoomMessagePayload{RoomId: d.(string)}
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvChildRoomMessage payload (sender): %w", e)
+	}
+	um.Sender = d.(string)
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvChildRoomMessage payload (kind): %w", e)
+	}
+	um.Kind = RoomRelayKind(d.(int))
+	um.Data = payload[l:]
+	return &um, nil
+}
+
+// NewEvPlayerStale : Playerの最後のメッセージから閾値を過ぎたことの通知イベント
+func NewEvPlayerStale(cliId string, elapsedMilli uint32) *RegularEvent {
+	payload := MarshalStr8(cliId)
+	payload = append(payload, MarshalUInt(int(elapsedMilli))...)
+	return &RegularEvent{EvTypePlayerStale, payload}
+}
+
+type EvPlayerStalePayload struct {
+	ClientId     string
+	ElapsedMilli uint32
+}
+
+// UnmarshalEvPlayerStalePayload : EvTypePlayerStaleのpayloadをクライアントIDと経過時間に分ける
+func UnmarshalEvPlayerStalePayload(payload []byte) (*EvPlayerStalePayload, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvPlayerStale payload (client id): %w", e)
+	}
+	um := EvPlayerStalePayload{ClientId: d.(string)}
+
+	d, _, e = UnmarshalAs(payload[l:], TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvPlayerStale payload (elapsed): %w", e)
+	}
+	um.ElapsedMilli = uint32(d.(int))
+	return &um, nil
+}
+
+// NewEvPlayerRecovered : Stale通知したPlayerからメッセージが届いたことの通知イベント
+func NewEvPlayerRecovered(cliId string) *RegularEvent {
+	return &RegularEvent{EvTypePlayerRecovered, MarshalStr8(cliId)}
+}
+
+// UnmarshalEvPlayerRecoveredPayload : EvTypePlayerRecoveredのpayloadからクライアントIDを取り出す
+func UnmarshalEvPlayerRecoveredPayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid EvPlayerRecovered payload (client id): %w", e)
+	}
+	return d.(string), nil
+}
+
+// NewEvBotJoined : Bot入室イベント
+func NewEvBotJoined(bot *pb.ClientInfo) *RegularEvent {
+	payload := MarshalStr8(bot.Id)
+	payload = append(payload, bot.Props...) // bot.Props marshaled as TypeDict
+	return &RegularEvent{EvTypeBotJoined, payload}
+}
+
+// UnmarshalEvBotJoinedPayload : EvTypeBotJoinedのpayloadからBotのClientInfoを取り出す
+func UnmarshalEvBotJoinedPayload(payload []byte) (*pb.ClientInfo, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvBotJoined payload (bot id): %w", e)
+	}
+	um := pb.ClientInfo{Id: d.(string), Bot: true}
+	payload = payload[l:]
+
+	_, _, e = UnmarshalNullDict(payload)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid EvBotJoined payload (props): %w", e)
+	}
+	um.Props = payload
+	return &um, nil
+}
+
+// NewEvPromoteRequested : Watcherの昇格要求イベント (Masterのみ)
+func NewEvPromoteRequested(cliId string) *RegularEvent {
+	return &RegularEvent{EvTypePromoteRequested, MarshalStr8(cliId)}
+}
+
+// NewEvPromoteRedirect : Hub経由の観戦者にPlayerとして入室し直すよう求めるイベント
+func NewEvPromoteRedirect(cliId string) *RegularEvent {
+	return &RegularEvent{EvTypePromoteRedirect, MarshalStr8(cliId)}
+}
+
+// UnmarshalEvPromotePayload : EvTypePromoteRequested, EvTypePromoteRedirect のpayload
+func UnmarshalEvPromotePayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid EvPromote payload (client id): %w", e)
+	}
+	return d.(string), nil
+}
+
+// NewEvPlayerDemoted : KickされたPlayerが観戦者になったことの通知イベント
+func NewEvPlayerDemoted(cliId string) *RegularEvent {
+	return &RegularEvent{EvTypePlayerDemoted, MarshalStr8(cliId)}
+}
+
+// UnmarshalEvPlayerDemotedPayload : EvTypePlayerDemotedのpayloadからクライアントIDを取り出す
+func UnmarshalEvPlayerDemotedPayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid EvPlayerDemoted payload (client id): %w", e)
+	}
+	return d.(string), nil
+}
+
 // NewEvSucceeded : 成功イベント
 func NewEvSucceeded(msg RegularMsg) *RegularEvent {
 	payload := make([]byte, 3)
@@ -457,3 +1486,51 @@ func NewEvTargetNotFound(msg RegularMsg, cliIds []string) *RegularEvent {
 	payload = append(payload, msg.Payload()...)
 	return &RegularEvent{EvTypeTargetNotFound, payload}
 }
+
+// NewEvKVConflict : KVストアの比較失敗
+// 現在の値とエラー発生の原因となったメッセージをそのまま返す
+func NewEvKVConflict(msg RegularMsg, current []byte) *RegularEvent {
+	if len(current) == 0 {
+		current = MarshalNull()
+	}
+	payload := make([]byte, 3, 3+len(current)+len(msg.Payload()))
+	put24(payload, int64(msg.SequenceNum()))
+	payload = append(payload, current...)
+	payload = append(payload, msg.Payload()...)
+	return &RegularEvent{EvTypeKVConflict, payload}
+}
+
+// NewEvHistory : broadcastされたEvTypeMessageのイベントをまとめた履歴イベント
+func NewEvHistory(evs []*RegularEvent) *RegularEvent {
+	size := 0
+	for _, ev := range evs {
+		size += 4 + len(ev.payload)
+	}
+	payload := make([]byte, 0, size)
+	for _, ev := range evs {
+		var l [4]byte
+		put32(l[:], int64(len(ev.payload)))
+		payload = append(payload, l[:]...)
+		payload = append(payload, ev.payload...)
+	}
+	return &RegularEvent{EvTypeHistory, payload}
+}
+
+// UnmarshalEvHistoryPayload : EvTypeHistoryのpayloadを個々のEvTypeMessageのpayloadに分割する.
+// 各要素はUnmarshalEvMessageで復元できる.
+func UnmarshalEvHistoryPayload(payload []byte) ([][]byte, error) {
+	var msgs [][]byte
+	for len(payload) > 0 {
+		if len(payload) < 4 {
+			return nil, xerrors.Errorf("history length not enough: %v", len(payload))
+		}
+		l := get32(payload)
+		payload = payload[4:]
+		if len(payload) < l {
+			return nil, xerrors.Errorf("invalid history entry length: %v (remains %v)", l, len(payload))
+		}
+		msgs = append(msgs, payload[:l])
+		payload = payload[l:]
+	}
+	return msgs, nil
+}
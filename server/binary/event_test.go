@@ -0,0 +1,449 @@
+package binary
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"wsnet2/pb"
+)
+
+func TestRegularEventPutHeader(t *testing.T) {
+	ev := NewEvMessage("sender", []byte("message body"))
+	seq := 0x01020304
+
+	var buf bytes.Buffer
+	var hdr [RegularEventHeaderSize]byte
+	ev.PutHeader(hdr[:], seq)
+	buf.Write(hdr[:])
+	buf.Write(ev.Payload())
+
+	if want := ev.Marshal(seq); !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("header+payload = %v, wants %v", buf.Bytes(), want)
+	}
+
+	e, s, err := UnmarshalEvent(buf.Bytes())
+	if err != nil {
+		t.Fatalf("UnmarshalEvent: %v", err)
+	}
+	if s != seq || e.Type() != EvTypeMessage || !bytes.Equal(e.Payload(), ev.Payload()) {
+		t.Fatalf("UnmarshalEvent = %v %v %v, wants %v %v %v", e.Type(), s, e.Payload(), EvTypeMessage, seq, ev.Payload())
+	}
+}
+
+// broadcastで32peerに1KBのイベントを送る場合の比較
+const benchPeers = 32
+
+func BenchmarkBroadcastMarshal(b *testing.B) {
+	ev := NewEvMessage("sender", make([]byte, 1024))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for p := 0; p < benchPeers; p++ {
+			io.Discard.Write(ev.Marshal(i))
+		}
+	}
+}
+
+func BenchmarkBroadcastPutHeader(b *testing.B) {
+	ev := NewEvMessage("sender", make([]byte, 1024))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for p := 0; p < benchPeers; p++ {
+			var hdr [RegularEventHeaderSize]byte
+			ev.PutHeader(hdr[:], i)
+			io.Discard.Write(hdr[:])
+			io.Discard.Write(ev.Payload())
+		}
+	}
+}
+
+func TestEvBackpressure(t *testing.T) {
+	ev := NewEvBackpressure(250, BackpressureEventQueue)
+
+	e, _, err := UnmarshalEvent(ev.Marshal())
+	if err != nil {
+		t.Fatalf("UnmarshalEvent: %v", err)
+	}
+	if e.Type() != EvTypeBackpressure || !IsSystemEvent(e) {
+		t.Fatalf("event type = %v, wants system event %v", e.Type(), EvTypeBackpressure)
+	}
+	p, err := UnmarshalEvBackpressurePayload(e.Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvBackpressurePayload: %v", err)
+	}
+	want := EvBackpressurePayload{250, BackpressureEventQueue}
+	if *p != want {
+		t.Fatalf("payload = %+v, wants %+v", *p, want)
+	}
+}
+
+func TestEvHubStatus(t *testing.T) {
+	for _, status := range []HubStatus{HubStatusConnected, HubStatusDegraded} {
+		e, _, err := UnmarshalEvent(NewEvHubStatus(status).Marshal())
+		if err != nil {
+			t.Fatalf("UnmarshalEvent: %v", err)
+		}
+		if e.Type() != EvTypeHubStatus || !IsSystemEvent(e) {
+			t.Fatalf("event type = %v, wants system event %v", e.Type(), EvTypeHubStatus)
+		}
+		s, err := UnmarshalEvHubStatusPayload(e.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvHubStatusPayload: %v", err)
+		}
+		if s != status {
+			t.Fatalf("status = %v, wants %v", s, status)
+		}
+	}
+}
+
+func TestEvRejoinRequested(t *testing.T) {
+	e, _, err := UnmarshalEvent(NewEvRejoinRequested(3000).Marshal())
+	if err != nil {
+		t.Fatalf("UnmarshalEvent: %v", err)
+	}
+	if e.Type() != EvTypeRejoinRequested || !IsSystemEvent(e) {
+		t.Fatalf("event type = %v, wants system event %v", e.Type(), EvTypeRejoinRequested)
+	}
+	timeout, err := UnmarshalEvRejoinRequestedPayload(e.Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvRejoinRequestedPayload: %v", err)
+	}
+	if timeout != 3000 {
+		t.Fatalf("timeout = %v, wants %v", timeout, 3000)
+	}
+}
+
+func TestEvDisplaced(t *testing.T) {
+	for _, reason := range []DisplacedReason{DisplacedByRejoin, DisplacedConfirmed} {
+		e, _, err := UnmarshalEvent(NewEvDisplaced(reason).Marshal())
+		if err != nil {
+			t.Fatalf("UnmarshalEvent: %v", err)
+		}
+		if e.Type() != EvTypeDisplaced || !IsSystemEvent(e) {
+			t.Fatalf("event type = %v, wants system event %v", e.Type(), EvTypeDisplaced)
+		}
+		r, err := UnmarshalEvDisplacedPayload(e.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvDisplacedPayload: %v", err)
+		}
+		if r != reason {
+			t.Fatalf("reason = %v, wants %v", r, reason)
+		}
+	}
+}
+
+func TestEvError(t *testing.T) {
+	e, _, err := UnmarshalEvent(NewEvError(ErrorCodeSchemaViolation, "too many elements").Marshal())
+	if err != nil {
+		t.Fatalf("UnmarshalEvent: %v", err)
+	}
+	if e.Type() != EvTypeError || !IsSystemEvent(e) {
+		t.Fatalf("event type = %v, wants system event %v", e.Type(), EvTypeError)
+	}
+	code, msg, err := UnmarshalEvErrorPayload(e.Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvErrorPayload: %v", err)
+	}
+	if code != ErrorCodeSchemaViolation || msg != "too many elements" {
+		t.Fatalf("error = (%v, %q), wants (%v, %q)", code, msg, ErrorCodeSchemaViolation, "too many elements")
+	}
+	if s := ErrorCode(200).String(); s != "ErrorCode(200)" {
+		t.Fatalf("String() = %q", s)
+	}
+}
+
+func TestEvPeerReady(t *testing.T) {
+	tests := []struct {
+		ev   *SystemEvent
+		want EvPeerReadyPayload
+	}{
+		{NewEvPeerReady(12), EvPeerReadyPayload{12, 0, 0}},
+		{NewEvPeerReadyWithPing(12, 20000, 60000), EvPeerReadyPayload{12, 20000, 60000}},
+	}
+	for _, tc := range tests {
+		e, _, err := UnmarshalEvent(tc.ev.Marshal())
+		if err != nil {
+			t.Fatalf("UnmarshalEvent: %v", err)
+		}
+		p, err := UnmarshalEvPeerReadyPingPayload(e.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvPeerReadyPingPayload: %v", err)
+		}
+		if *p != tc.want {
+			t.Fatalf("payload = %+v, wants %+v", *p, tc.want)
+		}
+		// 古いクライアントは先頭の通し番号だけを読む
+		if seq, err := UnmarshalEvPeerReadyPayload(e.Payload()); err != nil || seq != tc.want.LastMsgSeq {
+			t.Fatalf("UnmarshalEvPeerReadyPayload = %v, %v", seq, err)
+		}
+	}
+}
+
+func TestEvSlowConsumer(t *testing.T) {
+	e, _, err := UnmarshalEvent(NewEvSlowConsumer(120, 1500).Marshal())
+	if err != nil {
+		t.Fatalf("UnmarshalEvent: %v", err)
+	}
+	if e.Type() != EvTypeSlowConsumer || !IsSystemEvent(e) {
+		t.Fatalf("event type = %v, wants system event %v", e.Type(), EvTypeSlowConsumer)
+	}
+	p, err := UnmarshalEvSlowConsumerPayload(e.Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvSlowConsumerPayload: %v", err)
+	}
+	want := EvSlowConsumerPayload{120, 1500}
+	if *p != want {
+		t.Fatalf("payload = %+v, wants %+v", *p, want)
+	}
+}
+
+func TestEvDeadlineChanged(t *testing.T) {
+	e, _, err := UnmarshalEvent(NewEvDeadlineChanged(3000, 10000).Marshal())
+	if err != nil {
+		t.Fatalf("UnmarshalEvent: %v", err)
+	}
+	if e.Type() != EvTypeDeadlineChanged || !IsSystemEvent(e) {
+		t.Fatalf("event type = %v, wants system event %v", e.Type(), EvTypeDeadlineChanged)
+	}
+	p, err := UnmarshalEvDeadlineChangedPayload(e.Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvDeadlineChangedPayload: %v", err)
+	}
+	want := EvDeadlineChangedPayload{3000, 10000}
+	if *p != want {
+		t.Fatalf("payload = %+v, wants %+v", *p, want)
+	}
+}
+
+func TestEvHistory(t *testing.T) {
+	evs := []*RegularEvent{
+		NewEvMessage("a", []byte("first")),
+		NewEvMessage("b", []byte{}),
+		NewEvMessage("c", make([]byte, 70000)),
+	}
+	e, _, err := UnmarshalEvent(NewEvHistory(evs).Marshal(3))
+	if err != nil {
+		t.Fatalf("UnmarshalEvent: %v", err)
+	}
+	if e.Type() != EvTypeHistory {
+		t.Fatalf("event type = %v, wants %v", e.Type(), EvTypeHistory)
+	}
+	msgs, err := UnmarshalEvHistoryPayload(e.Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvHistoryPayload: %v", err)
+	}
+	if len(msgs) != len(evs) {
+		t.Fatalf("len(msgs) = %v, wants %v", len(msgs), len(evs))
+	}
+	for i, m := range msgs {
+		cid, body, err := UnmarshalEvMessage(m)
+		if err != nil {
+			t.Fatalf("UnmarshalEvMessage[%v]: %v", i, err)
+		}
+		wcid, wbody, _ := UnmarshalEvMessage(evs[i].Payload())
+		if cid != wcid || !bytes.Equal(body, wbody) {
+			t.Fatalf("msgs[%v] = (%v, %v bytes), wants (%v, %v bytes)", i, cid, len(body), wcid, len(wbody))
+		}
+	}
+
+	if _, err := UnmarshalEvHistoryPayload(e.Payload()[:10]); err == nil {
+		t.Fatalf("truncated payload must be error")
+	}
+}
+
+func TestEvServerMessage(t *testing.T) {
+	data := MarshalDict(Dict{"gift": MarshalStr8("flower")})
+	for _, target := range []string{"", "user1"} {
+		e, _, err := UnmarshalEvent(NewEvServerMessage(target, data).Marshal(5))
+		if err != nil {
+			t.Fatalf("UnmarshalEvent: %v", err)
+		}
+		if e.Type() != EvTypeServerMessage || !IsRegularEvent(e) {
+			t.Fatalf("event type = %v, wants regular event %v", e.Type(), EvTypeServerMessage)
+		}
+		tgt, d, err := UnmarshalEvServerMessagePayload(e.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvServerMessagePayload: %v", err)
+		}
+		if tgt != target || !bytes.Equal(d, data) {
+			t.Fatalf("payload = (%q, %v), wants (%q, %v)", tgt, d, target, data)
+		}
+	}
+}
+
+func TestEvRedacted(t *testing.T) {
+	data := MarshalStr8("bad word")
+	e, _, err := UnmarshalEvent(NewEvRedacted("user1", data).Marshal(5))
+	if err != nil {
+		t.Fatalf("UnmarshalEvent: %v", err)
+	}
+	if e.Type() != EvTypeRedacted || !IsRegularEvent(e) {
+		t.Fatalf("event type = %v, wants regular event %v", e.Type(), EvTypeRedacted)
+	}
+	sender, d, err := UnmarshalEvRedactedPayload(e.Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvRedactedPayload: %v", err)
+	}
+	if sender != "user1" || !bytes.Equal(d, data) {
+		t.Fatalf("payload = (%q, %v), wants (%q, %v)", sender, d, "user1", data)
+	}
+}
+
+func TestEvEncrypted(t *testing.T) {
+	envelope := []byte{0x01, 0xff, 0x00, 0x7f}
+	for _, ev := range []*RegularEvent{NewEvEncrypted("user1", envelope), NewEvKeyExchange("user1", envelope)} {
+		e, _, err := UnmarshalEvent(ev.Marshal(5))
+		if err != nil {
+			t.Fatalf("UnmarshalEvent: %v", err)
+		}
+		if e.Type() != ev.Type() || !IsRegularEvent(e) {
+			t.Fatalf("event type = %v, wants regular event %v", e.Type(), ev.Type())
+		}
+		sender, d, err := UnmarshalEvEncryptedPayload(e.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvEncryptedPayload: %v", err)
+		}
+		if sender != "user1" || !bytes.Equal(d, envelope) {
+			t.Fatalf("payload = (%q, %v), wants (%q, %v)", sender, d, "user1", envelope)
+		}
+	}
+}
+
+func TestEvRoomPausedResumed(t *testing.T) {
+	id, err := UnmarshalEvRoomPausedPayload(NewEvRoomPaused("master").Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvRoomPausedPayload: %v", err)
+	}
+	if id != "master" {
+		t.Fatalf("client id = %q, wants %q", id, "master")
+	}
+
+	p, err := UnmarshalEvRoomResumedPayload(NewEvRoomResumed("master", 12345).Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvRoomResumedPayload: %v", err)
+	}
+	if want := (EvRoomResumedPayload{"master", 12345}); *p != want {
+		t.Fatalf("payload = %+v, wants %+v", *p, want)
+	}
+}
+
+func TestEvChildRoomMessage(t *testing.T) {
+	data := MarshalStr8("3-2")
+	p, err := UnmarshalEvChildRoomMessagePayload(NewEvChildRoomMessage("child", "master", RoomRelaySummary, data).Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvChildRoomMessagePayload: %v", err)
+	}
+	if p.RoomId != "child" || p.Sender != "master" || p.Kind != RoomRelaySummary || !bytes.Equal(p.Data, data) {
+		t.Fatalf("payload = %+v", p)
+	}
+}
+
+func TestEvPlayerStaleRecovered(t *testing.T) {
+	p, err := UnmarshalEvPlayerStalePayload(NewEvPlayerStale("user1", 3000).Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvPlayerStalePayload: %v", err)
+	}
+	if want := (EvPlayerStalePayload{"user1", 3000}); *p != want {
+		t.Fatalf("payload = %+v, wants %+v", *p, want)
+	}
+
+	id, err := UnmarshalEvPlayerRecoveredPayload(NewEvPlayerRecovered("user1").Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvPlayerRecoveredPayload: %v", err)
+	}
+	if id != "user1" {
+		t.Fatalf("client id = %q, wants %q", id, "user1")
+	}
+}
+
+func TestEvBotJoined(t *testing.T) {
+	props := MarshalDict(Dict{"level": MarshalInt(3)})
+	bot, err := UnmarshalEvBotJoinedPayload(NewEvBotJoined(&pb.ClientInfo{Id: "bot1", Props: props}).Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvBotJoinedPayload: %v", err)
+	}
+	if bot.Id != "bot1" || !bot.Bot || !bytes.Equal(bot.Props, props) {
+		t.Fatalf("bot = %+v", bot)
+	}
+}
+
+func TestEvPromote(t *testing.T) {
+	for _, ev := range []*RegularEvent{NewEvPromoteRequested("watcher1"), NewEvPromoteRedirect("watcher1")} {
+		id, err := UnmarshalEvPromotePayload(ev.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvPromotePayload(%v): %v", ev.Type(), err)
+		}
+		if id != "watcher1" {
+			t.Fatalf("%v: client id = %q, wants %q", ev.Type(), id, "watcher1")
+		}
+	}
+}
+
+func TestEvPlayerDemoted(t *testing.T) {
+	id, err := UnmarshalEvPlayerDemotedPayload(NewEvPlayerDemoted("player1").Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvPlayerDemotedPayload: %v", err)
+	}
+	if id != "player1" {
+		t.Fatalf("client id = %q, wants %q", id, "player1")
+	}
+}
+
+func TestEvRoomSuccessor(t *testing.T) {
+	token := strings.Repeat("t", 300)
+	p, err := UnmarshalEvRoomSuccessorPayload(NewEvRoomSuccessor("room2", token, 1700000000).Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvRoomSuccessorPayload: %v", err)
+	}
+	if want := (EvRoomSuccessorPayload{"room2", token, 1700000000}); *p != want {
+		t.Fatalf("payload = %+v, wants %+v", *p, want)
+	}
+}
+
+func TestEvRoomMerged(t *testing.T) {
+	ev := NewEvRoomMerged("room3", "token", 1700000000)
+	if ev.Type() != EvTypeRoomMerged {
+		t.Fatalf("type = %v, wants %v", ev.Type(), EvTypeRoomMerged)
+	}
+	p, err := UnmarshalEvRoomSuccessorPayload(ev.Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvRoomSuccessorPayload: %v", err)
+	}
+	if want := (EvRoomSuccessorPayload{"room3", "token", 1700000000}); *p != want {
+		t.Fatalf("payload = %+v, wants %+v", *p, want)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	evs := []*RegularEvent{
+		NewEvMessage("a", []byte("first")),
+		NewEvMessage("b", []byte{}),
+		NewEvMessage("c", make([]byte, 1000)),
+	}
+	seq := 10
+
+	e, _, err := UnmarshalEvent(MarshalBatch(evs, seq))
+	if err != nil {
+		t.Fatalf("UnmarshalEvent: %v", err)
+	}
+	if e.Type() != EvTypeBatch || !IsSystemEvent(e) {
+		t.Fatalf("event type = %v, wants system event %v", e.Type(), EvTypeBatch)
+	}
+	entries, err := UnmarshalBatchPayload(e.Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalBatchPayload: %v", err)
+	}
+	if len(entries) != len(evs) {
+		t.Fatalf("len(entries) = %v, wants %v", len(entries), len(evs))
+	}
+	for i, data := range entries {
+		if want := evs[i].Marshal(seq + i); !bytes.Equal(data, want) {
+			t.Fatalf("entries[%v] = %v, wants %v", i, data, want)
+		}
+	}
+
+	if _, err := UnmarshalBatchPayload([]byte{0, 0, 0, 9, 1, 2}); err == nil {
+		t.Fatalf("UnmarshalBatchPayload must fail for truncated entry")
+	}
+}
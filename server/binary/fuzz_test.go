@@ -0,0 +1,260 @@
+package binary
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"testing"
+	"time"
+
+	"wsnet2/auth"
+	"wsnet2/pb"
+)
+
+// websocketから受け取る信頼できない入力に対してpanicしないことを確認する.
+// 実行例: go test -run=^$ -fuzz=FuzzUnmarshalMsg -fuzztime=30s ./binary
+
+func fuzzSeeds() [][]byte {
+	return [][]byte{
+		{},
+		MarshalNull(),
+		MarshalStr8("hello"),
+		MarshalStr16("world"),
+		MarshalList(List{MarshalByte(1), MarshalStr8("a")}),
+		MarshalDict(Dict{"key": MarshalInt(-1), "obj": MarshalObj(&Obj{1, MarshalUShorts([]int{1, 2})})}),
+		MarshalBools([]bool{true, false, true}),
+		MarshalULongs([]uint64{1, 2, 3}),
+		MarshalDoubles([]float64{1.5}),
+		MarshalStrings([]string{"a", "b"}),
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, s := range fuzzSeeds() {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Unmarshal(data)
+		UnmarshalRecursive(data)
+		CheckLimits(data)
+		UnmarshalNullDict(data)
+		if v, err := NewDictView(data); err == nil {
+			v.Range(func(string, []byte) bool { return true })
+		}
+	})
+}
+
+func FuzzUnmarshalMsg(f *testing.F) {
+	mac := hmac.New(sha1.New, []byte("fuzzkey"))
+	f.Add(NewMsgPing(time.Now()).Marshal(mac))
+	f.Add(BuildRegularMsgFrame(MsgTypeBroadcast, 1, []byte("hello"), mac))
+	f.Add(BuildRegularMsgFrame(MsgTypeKick, 2, MarshalKickPayload("target", "bye", KickReasonNone, 10), mac))
+	f.Add(BuildRegularMsgFrame(MsgTypeKick, 3, MarshalDemotePayload("target", "afk", KickReasonNone), mac))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// HMAC検証を通過させるため末尾にHMACを付与する
+		frame := append(data[:len(data):len(data)], auth.CalculateMsgHMAC(mac, data)...)
+		msg, err := UnmarshalMsg(mac, frame)
+		if err != nil {
+			return
+		}
+		fuzzMsgPayload(msg.Type(), msg.Payload())
+	})
+}
+
+func FuzzMsgPayload(f *testing.F) {
+	f.Add(byte(MsgTypeRoomProp), MarshalRoomPropPayload(true, true, true, 1, 10, 5, Dict{}, Dict{}))
+	f.Add(byte(MsgTypeTargets), MarshalTargetsPayload([]string{"a", "b"}, []byte("data")))
+	f.Add(byte(MsgTypeKVCompareAndSwap), MarshalKVCompareAndSwapPayload("k", KVOwnerNone, nil, MarshalInt(1)))
+	f.Add(byte(MsgTypeStartVote), MarshalStartVotePayload("v", []string{"a", "b"}, 10))
+	f.Fuzz(func(t *testing.T, typ byte, payload []byte) {
+		fuzzMsgPayload(MsgType(typ), payload)
+	})
+}
+
+func fuzzMsgPayload(typ MsgType, payload []byte) {
+	switch typ {
+	case MsgTypePing:
+		UnmarshalPingPayload(payload)
+	case MsgTypeNodeCount:
+		UnmarshalNodeCountPayload(payload)
+	case MsgTypeEventAck:
+		UnmarshalEventAckPayload(payload)
+	case MsgTypeLeave:
+		UnmarshalLeavePayload(payload)
+	case MsgTypeRoomProp:
+		UnmarshalRoomPropPayload(payload)
+		GetRoomPropClientDeadline(payload)
+	case MsgTypeClientProp:
+		UnmarshalClientPropPayload(payload)
+	case MsgTypeSwitchMaster:
+		UnmarshalSwitchMasterPayload(payload)
+	case MsgTypeTargets:
+		UnmarshalTargetsAndData(payload)
+	case MsgTypeRoles:
+		UnmarshalRolesPayload(payload)
+	case MsgTypeToRole:
+		UnmarshalToRolePayload(payload)
+	case MsgTypeStartVote:
+		UnmarshalStartVotePayload(payload)
+	case MsgTypeCastVote:
+		UnmarshalCastVotePayload(payload)
+	case MsgTypeFetchHistory:
+		UnmarshalFetchHistoryPayload(payload)
+	case MsgTypeConfirmRejoin:
+		UnmarshalConfirmRejoinPayload(payload)
+	case MsgTypeBlocklist:
+		UnmarshalBlocklistPayload(payload)
+	case MsgTypeEncrypted, MsgTypeKeyExchange:
+		UnmarshalEncryptedPayload(payload)
+	case MsgTypePauseRoom:
+		UnmarshalPauseRoomPayload(payload)
+	case MsgTypeCreateSuccessor:
+		UnmarshalCreateSuccessorPayload(payload)
+	case MsgTypeRelayToParent:
+		UnmarshalRelayToParentPayload(payload)
+	case MsgTypeAddBot:
+		UnmarshalAddBotPayload(payload)
+	case MsgTypeRemoveBot:
+		UnmarshalRemoveBotPayload(payload)
+	case MsgTypeBotMessage:
+		UnmarshalBotMessagePayload(payload)
+	case MsgTypePromoteWatcher:
+		UnmarshalPromoteWatcherPayload(payload)
+	case MsgTypeMergeRoom:
+		UnmarshalMergeRoomPayload(payload)
+	case MsgTypeClientDeadline:
+		UnmarshalClientDeadlinePayload(payload)
+	case MsgTypeKick:
+		UnmarshalKickPayload(payload)
+	case MsgTypeKVSet:
+		UnmarshalKVSetPayload(payload)
+	case MsgTypeKVDelete:
+		UnmarshalKVDeletePayload(payload)
+	case MsgTypeKVCompareAndSwap:
+		UnmarshalKVCompareAndSwapPayload(payload)
+	default:
+		UnmarshalRecursive(payload)
+	}
+}
+
+func FuzzUnmarshalEvent(f *testing.F) {
+	f.Add(NewEvJoined(&pb.ClientInfo{Id: "id", Props: MarshalDict(Dict{})}).Marshal(1))
+	f.Add(NewEvLeft("id", "master", "cause").Marshal(2))
+	f.Add(NewEvMessage("id", []byte("body")).Marshal(3))
+	f.Add(NewEvKVUpdated("id", Dict{"k": MarshalInt(1)}, Dict{"k": MarshalStr8("id")}).Marshal(4))
+	f.Add(MarshalBatch([]*RegularEvent{NewEvMessage("a", []byte("1")), NewEvMessage("b", []byte("2"))}, 5))
+	f.Add(NewEvHistory([]*RegularEvent{NewEvMessage("a", []byte("1")), NewEvMessage("b", []byte("2"))}).Marshal(6))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ev, _, err := UnmarshalEvent(data)
+		if err != nil {
+			return
+		}
+		payload := ev.Payload()
+		switch ev.Type() {
+		case EvTypePeerReady:
+			UnmarshalEvPeerReadyPayload(payload)
+			UnmarshalEvPeerReadyPingPayload(payload)
+		case EvTypePong:
+			UnmarshalEvPongPayload(payload)
+		case EvTypeBackpressure:
+			UnmarshalEvBackpressurePayload(payload)
+		case EvTypeHubStatus:
+			UnmarshalEvHubStatusPayload(payload)
+		case EvTypeRejoinRequested:
+			UnmarshalEvRejoinRequestedPayload(payload)
+		case EvTypeDisplaced:
+			UnmarshalEvDisplacedPayload(payload)
+		case EvTypeError:
+			UnmarshalEvErrorPayload(payload)
+		case EvTypeSlowConsumer:
+			UnmarshalEvSlowConsumerPayload(payload)
+		case EvTypeDeadlineChanged:
+			UnmarshalEvDeadlineChangedPayload(payload)
+		case EvTypeBatch:
+			if evs, err := UnmarshalBatchPayload(payload); err == nil {
+				for _, e := range evs {
+					UnmarshalEvent(e)
+				}
+			}
+		case EvTypeJoined:
+			UnmarshalEvJoinedPayload(payload)
+		case EvTypeRejoined:
+			UnmarshalEvRejoinedPayload(payload)
+		case EvTypeLeft:
+			UnmarshalEvLeftPayload(payload)
+		case EvTypeRoomProp:
+			UnmarshalEvRoomPropPayload(payload)
+		case EvTypeClientProp:
+			UnmarshalEvClientPropPayload(payload)
+		case EvTypeMasterSwitched:
+			UnmarshalEvMasterSwitchedPayload(payload)
+		case EvTypeMasterSwitchRequested:
+			UnmarshalEvMasterSwitchRequestedPayload(payload)
+		case EvTypeMessage:
+			UnmarshalEvMessage(payload)
+		case EvTypeKVUpdated:
+			UnmarshalEvKVUpdatedPayload(payload)
+		case EvTypeVoteStarted:
+			UnmarshalEvVoteStartedPayload(payload)
+		case EvTypeVoteResult:
+			UnmarshalEvVoteResultPayload(payload)
+		case EvTypeServerMessage:
+			UnmarshalEvServerMessagePayload(payload)
+		case EvTypeRedacted:
+			UnmarshalEvRedactedPayload(payload)
+		case EvTypeEncrypted, EvTypeKeyExchange:
+			UnmarshalEvEncryptedPayload(payload)
+		case EvTypeRoomPaused:
+			UnmarshalEvRoomPausedPayload(payload)
+		case EvTypeRoomResumed:
+			UnmarshalEvRoomResumedPayload(payload)
+		case EvTypeRoomSuccessor, EvTypeRoomMerged:
+			UnmarshalEvRoomSuccessorPayload(payload)
+		case EvTypeChildRoomMessage:
+			UnmarshalEvChildRoomMessagePayload(payload)
+		case EvTypePlayerStale:
+			UnmarshalEvPlayerStalePayload(payload)
+		case EvTypePlayerRecovered:
+			UnmarshalEvPlayerRecoveredPayload(payload)
+		case EvTypeBotJoined:
+			UnmarshalEvBotJoinedPayload(payload)
+		case EvTypePromoteRequested, EvTypePromoteRedirect:
+			UnmarshalEvPromotePayload(payload)
+		case EvTypePlayerDemoted:
+			UnmarshalEvPlayerDemotedPayload(payload)
+		case EvTypeAdminMessage:
+			UnmarshalEvAdminMessagePayload(payload)
+		case EvTypeRoomClosed:
+			UnmarshalEvRoomClosedPayload(payload)
+		case EvTypeHistory:
+			if msgs, err := UnmarshalEvHistoryPayload(payload); err == nil {
+				for _, m := range msgs {
+					UnmarshalEvMessage(m)
+				}
+			}
+		default:
+			UnmarshalRecursive(payload)
+		}
+	})
+}
+
+func FuzzMuxFrame(f *testing.F) {
+	f.Add(MarshalMuxAttach(1, "room", "client", "auth", 10))
+	f.Add(MarshalMuxDetach(2))
+	f.Add(MuxFrame(3, []byte("frame")))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		handle, body, err := ParseMuxFrame(data)
+		if err != nil || handle != MuxControlHandle {
+			return
+		}
+		ctrl, payload, err := UnmarshalMuxControl(body)
+		if err != nil {
+			return
+		}
+		switch ctrl {
+		case MuxAttach:
+			UnmarshalMuxAttachPayload(payload)
+		case MuxDetach:
+			UnmarshalMuxDetachPayload(payload)
+		}
+	})
+}
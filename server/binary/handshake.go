@@ -0,0 +1,75 @@
+package binary
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// 先頭フレームでの認証:
+// websocketのヘッダを付けられないクライアント (ブラウザなど) は、Wsnet2-Appヘッダを付けずに接続し、
+// Upgrade後の最初のbinaryフレームでヘッダの代わりに認証情報を送る.
+// 認証できなければCloseReasonHandshakeFailedで閉じる. 認証後はヘッダで認証した接続と同じ.
+//
+// payload:
+//   - str8: app ID (Wsnet2-App)
+//   - str8: client ID (Wsnet2-User)
+//   - UInt: last event seq (Wsnet2-LastEventSeq)
+//   - str16: auth data (AuthorizationヘッダのBearerトークン)
+//   - UInt: protocol version (Wsnet2-ProtocolVersion)
+
+type Handshake struct {
+	AppId           string
+	ClientId        string
+	LastEvSeq       int
+	AuthData        string
+	ProtocolVersion int
+}
+
+// MarshalHandshake : 先頭フレームを作る
+func MarshalHandshake(appId, clientId string, lastEvSeq int, authData string, protocolVersion int) []byte {
+	p := MarshalStr8(appId)
+	p = append(p, MarshalStr8(clientId)...)
+	p = append(p, MarshalUInt(lastEvSeq)...)
+	p = append(p, MarshalStr16(authData)...)
+	p = append(p, MarshalUInt(protocolVersion)...)
+	return p
+}
+
+// UnmarshalHandshake unmarshals the first frame
+func UnmarshalHandshake(data []byte) (*Handshake, error) {
+	var hs Handshake
+
+	d, l, e := UnmarshalAs(data, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid Handshake (app id): %w", e)
+	}
+	hs.AppId = d.(string)
+	data = data[l:]
+
+	d, l, e = UnmarshalAs(data, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid Handshake (client id): %w", e)
+	}
+	hs.ClientId = d.(string)
+	data = data[l:]
+
+	d, l, e = UnmarshalAs(data, TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid Handshake (last event seq): %w", e)
+	}
+	hs.LastEvSeq = d.(int)
+	data = data[l:]
+
+	d, l, e = UnmarshalAs(data, TypeStr16)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid Handshake (auth data): %w", e)
+	}
+	hs.AuthData = d.(string)
+	data = data[l:]
+
+	d, _, e = UnmarshalAs(data, TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid Handshake (protocol version): %w", e)
+	}
+	hs.ProtocolVersion = d.(int)
+	return &hs, nil
+}
@@ -0,0 +1,22 @@
+package binary
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	data := MarshalHandshake("app", "client", 10, "auth", ProtocolVersionLatest)
+	hs, err := UnmarshalHandshake(data)
+	if err != nil {
+		t.Fatalf("UnmarshalHandshake: %v", err)
+	}
+	want := &Handshake{AppId: "app", ClientId: "client", LastEvSeq: 10, AuthData: "auth", ProtocolVersion: ProtocolVersionLatest}
+	if !reflect.DeepEqual(hs, want) {
+		t.Fatalf("handshake = %+v, wants %+v", hs, want)
+	}
+
+	if _, err := UnmarshalHandshake(data[:len(data)-1]); err == nil {
+		t.Fatalf("UnmarshalHandshake must fail with truncated data")
+	}
+}
@@ -0,0 +1,144 @@
+package binary
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"golang.org/x/xerrors"
+)
+
+// UnmarshalLimits : クライアントから受け取ったデータをUnmarshalするときの上限. 0は制限しない.
+// 小さな入力から大量の値を作らせたり、深い入れ子で再帰させたりする細工を防ぐ.
+type UnmarshalLimits struct {
+	// MaxCount : List, Dictの要素数
+	MaxCount int
+	// MaxDepth : List, Dict, Objの入れ子の深さ
+	MaxDepth int
+	// MaxValues : 入れ子の中も含めた値の総数
+	MaxValues int
+	// MaxBytes : 入れ子の中も含めたデコードした値 (List, Dict, Obj以外) のバイト数の総数
+	MaxBytes int
+}
+
+var (
+	// ErrCountLimit : List, Dictの要素数がMaxCountを超えた
+	ErrCountLimit = errors.New("too many elements")
+	// ErrDepthLimit : 入れ子がMaxDepthより深い
+	ErrDepthLimit = errors.New("too deep nesting")
+	// ErrValuesLimit : 値の総数がMaxValuesを超えた
+	ErrValuesLimit = errors.New("too many values")
+	// ErrBytesLimit : デコードした値のバイト数の総数がMaxBytesを超えた
+	ErrBytesLimit = errors.New("too many bytes")
+)
+
+var limits atomic.Pointer[UnmarshalLimits]
+
+func init() {
+	limits.Store(&UnmarshalLimits{})
+}
+
+// SetUnmarshalLimits : UnmarshalとCheckLimitsの上限を設定する. サーバの起動時に呼ぶ
+func SetUnmarshalLimits(l UnmarshalLimits) {
+	limits.Store(&l)
+}
+
+func checkCount(typ Type, count int) error {
+	if limit := limits.Load().MaxCount; limit > 0 && count > limit {
+		return xerrors.Errorf("Unmarshal %v(%v) error: %w (max %v)", typ, count, ErrCountLimit, limit)
+	}
+	return nil
+}
+
+// limitCounter : 入れ子の深さと値の総数、デコードした値のバイト数を数える
+type limitCounter struct {
+	limits *UnmarshalLimits
+	values int
+	bytes  int
+}
+
+func newLimitCounter() *limitCounter {
+	return &limitCounter{limits: limits.Load()}
+}
+
+// enter : depthの値を1つ数える
+func (lc *limitCounter) enter(depth int) error {
+	if limit := lc.limits.MaxDepth; limit > 0 && depth > limit {
+		return xerrors.Errorf("Unmarshal error: %w (max %v)", ErrDepthLimit, limit)
+	}
+	lc.values++
+	if limit := lc.limits.MaxValues; limit > 0 && lc.values > limit {
+		return xerrors.Errorf("Unmarshal error: %w (max %v)", ErrValuesLimit, limit)
+	}
+	return nil
+}
+
+// decoded : List, Dict, Obj以外のn bytesの値を1つデコードした
+func (lc *limitCounter) decoded(n int) error {
+	lc.bytes += n
+	if limit := lc.limits.MaxBytes; limit > 0 && lc.bytes > limit {
+		return xerrors.Errorf("Unmarshal error: %w (max %v)", ErrBytesLimit, limit)
+	}
+	return nil
+}
+
+// CheckLimits : srcに続けて並ぶ値を入れ子の中まで検査する. 値は作らない.
+// 上限を超えていればErrCountLimit, ErrDepthLimit, ErrValuesLimit, ErrBytesLimitをwrapしたエラーを返す.
+func CheckLimits(src []byte) error {
+	return newLimitCounter().checkSeq(src, 1)
+}
+
+// CheckDictLimits : Dictの各値を入れ子の中まで検査する. Dict自体を1段目として数える
+func CheckDictLimits(dict Dict) error {
+	lc := newLimitCounter()
+	if err := lc.enter(1); err != nil {
+		return err
+	}
+	for _, v := range dict {
+		if err := lc.checkSeq(v, 2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lc *limitCounter) checkSeq(src []byte, depth int) error {
+	for len(src) > 0 {
+		n, err := lc.check(src, depth)
+		if err != nil {
+			return err
+		}
+		src = src[n:]
+	}
+	return nil
+}
+
+func (lc *limitCounter) check(src []byte, depth int) (int, error) {
+	if err := lc.enter(depth); err != nil {
+		return 0, err
+	}
+	u, n, err := Unmarshal(src)
+	if err != nil {
+		return 0, err
+	}
+	switch v := u.(type) {
+	case *Obj:
+		return n, lc.checkSeq(v.Body, depth+1)
+	case Dict:
+		for _, e := range v {
+			if err := lc.checkSeq(e, depth+1); err != nil {
+				return 0, err
+			}
+		}
+	case List:
+		for _, e := range v {
+			if err := lc.checkSeq(e, depth+1); err != nil {
+				return 0, err
+			}
+		}
+	default:
+		if err := lc.decoded(n); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,53 @@
+package binary
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUnmarshalLimits(t *testing.T) {
+	SetUnmarshalLimits(UnmarshalLimits{MaxCount: 2, MaxDepth: 2, MaxValues: 4, MaxBytes: 16})
+	t.Cleanup(func() { SetUnmarshalLimits(UnmarshalLimits{}) })
+
+	nested := func(depth int) []byte {
+		v := MarshalNull()
+		for i := 1; i < depth; i++ {
+			v = MarshalList(List{v})
+		}
+		return v
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"depth ok", nested(2), nil},
+		{"too deep", nested(3), ErrDepthLimit},
+		{"too many elements", MarshalList(List{MarshalNull(), MarshalNull(), MarshalNull()}), ErrCountLimit},
+		{"too many values", []byte{byte(TypeNull), byte(TypeNull), byte(TypeNull), byte(TypeNull), byte(TypeNull)}, ErrValuesLimit},
+		{"obj body", MarshalObj(&Obj{1, MarshalList(List{MarshalNull()})}), ErrDepthLimit},
+		{"bytes ok", MarshalList(List{MarshalStr8("01234"), MarshalStr8("01234")}), nil},
+		{"too many bytes", MarshalList(List{MarshalStr8("012345"), MarshalStr8("0123456")}), ErrBytesLimit},
+	}
+	for _, tt := range tests {
+		if err := CheckLimits(tt.data); !errors.Is(err, tt.err) {
+			t.Errorf("%v: CheckLimits = %v, wants %v", tt.name, err, tt.err)
+		}
+		if _, err := UnmarshalRecursive(tt.data); !errors.Is(err, tt.err) {
+			t.Errorf("%v: UnmarshalRecursive = %v, wants %v", tt.name, err, tt.err)
+		}
+	}
+
+	// 値が空のキーは削除の指定なので検査しない
+	if err := CheckDictLimits(Dict{"a": nil, "b": nested(1)}); err != nil {
+		t.Errorf("CheckDictLimits: %v", err)
+	}
+	if err := CheckDictLimits(Dict{"a": nested(2)}); !errors.Is(err, ErrDepthLimit) {
+		t.Errorf("CheckDictLimits = %v, wants %v", err, ErrDepthLimit)
+	}
+	// Dictの値をまとめて数える
+	if err := CheckDictLimits(Dict{"a": MarshalStr8("0123456789"), "b": MarshalStr8("0123456789")}); !errors.Is(err, ErrBytesLimit) {
+		t.Errorf("CheckDictLimits = %v, wants %v", err, ErrBytesLimit)
+	}
+}
@@ -1,7 +1,9 @@
 package binary
 
 import (
+	"encoding/hex"
 	"math"
+	"time"
 	"unicode/utf16"
 
 	"golang.org/x/xerrors"
@@ -45,6 +47,9 @@ const (
 	TypeFloats   // C#:float[]
 	TypeDoubles  // C#:double[]
 	TypeDecimals // C#:decimal[]
+
+	TypeTimestamp // C#:DateTimeOffset; milliseconds since unix epoch
+	TypeUUID      // C#:Guid; RFC 4122 byte order
 )
 
 const (
@@ -61,6 +66,8 @@ const (
 	DoubleDataSize = 8
 	// DecimalDataSize
 
+	TimestampDataSize = 8
+	UUIDDataSize      = 16
 )
 
 var NumTypeDataSize = map[Type]int{
@@ -102,6 +109,32 @@ type List [][]byte
 
 type Dict map[string][]byte
 
+// UUID : RFC 4122のUUID. 文字列表記と同じ順序 (big endian) のバイト列
+type UUID [16]byte
+
+// ParseUUID : "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx" 形式の文字列をUUIDにする
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, xerrors.Errorf("invalid UUID: %q", s)
+	}
+	h := s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	if _, err := hex.Decode(u[:], []byte(h)); err != nil {
+		return u, xerrors.Errorf("invalid UUID: %q: %w", s, err)
+	}
+	return u, nil
+}
+
+func (u UUID) String() string {
+	h := hex.EncodeToString(u[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// MarshalText : JSONなどで文字列表記にする
+func (u UUID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
 // MarshalNull marshals null
 func MarshalNull() []byte {
 	return []byte{byte(TypeNull)}
@@ -328,6 +361,41 @@ func unmarshalDouble(src []byte) (float64, int, error) {
 	return math.Float64frombits(v), 1 + DoubleDataSize, nil
 }
 
+// MarshalTimestamp marshals time as milliseconds since unix epoch comparably.
+//
+// The encoding is the same as MarshalLong except the type.
+// Sub-millisecond precision and the location are not preserved.
+func MarshalTimestamp(t time.Time) []byte {
+	buf := MarshalLong(t.UnixMilli())
+	buf[0] = byte(TypeTimestamp)
+	return buf
+}
+
+func unmarshalTimestamp(src []byte) (time.Time, int, error) {
+	if len(src) < 1+TimestampDataSize {
+		return time.Time{}, 0, xerrors.Errorf("Unmarshal Timestamp error: not enough data (%v)", len(src))
+	}
+	ms, n, _ := unmarshalLong(src)
+	return time.UnixMilli(ms).UTC(), n, nil
+}
+
+// MarshalUUID marshals UUID as 16 bytes in RFC 4122 (big endian) order
+func MarshalUUID(u UUID) []byte {
+	buf := make([]byte, 1+UUIDDataSize)
+	buf[0] = byte(TypeUUID)
+	copy(buf[1:], u[:])
+	return buf
+}
+
+func unmarshalUUID(src []byte) (UUID, int, error) {
+	var u UUID
+	if len(src) < 1+UUIDDataSize {
+		return u, 0, xerrors.Errorf("Unmarshal UUID error: not enough data (%v)", len(src))
+	}
+	copy(u[:], src[1:])
+	return u, 1 + UUIDDataSize, nil
+}
+
 // MarshalStr8 marshals short string (len <= 255)
 func MarshalStr8(str string) []byte {
 	len := len(str)
@@ -440,7 +508,14 @@ func unmarshalList(src []byte) (List, int, error) {
 		return nil, 0, xerrors.Errorf("Unmarshal List error: not enough data (%v)", len(src))
 	}
 	count := get8(src[1:])
+	if err := checkCount(TypeList, count); err != nil {
+		return nil, 0, err
+	}
 	l := 2
+	// 各要素は少なくとも長さの2byteを持つので、確保する前に検査する
+	if len(src) < l+count*2 {
+		return nil, 0, xerrors.Errorf("Unmarshal List(%v) error: not enough data (%v)", count, len(src))
+	}
 	list := make(List, count)
 	for i := 0; i < count; i++ {
 		if len(src) < l+2 {
@@ -470,15 +545,13 @@ func MarshalDict(dict Dict) []byte {
 	if dict == nil {
 		return MarshalNull()
 	}
-	buf := make([]byte, 2)
+	buf := make([]byte, 2, marshaledDictSize(dict))
 	buf[0] = byte(TypeDict)
 	buf[1] = byte(len(dict))
-	sizebuf := make([]byte, 2)
 	for k, v := range dict {
 		buf = append(buf, byte(len(k)))
-		buf = append(buf, []byte(k)...)
-		put16(sizebuf, int64(len(v)))
-		buf = append(buf, sizebuf...)
+		buf = append(buf, k...)
+		buf = append(buf, byte(len(v)>>8), byte(len(v)))
 		buf = append(buf, v...)
 	}
 	return buf
@@ -489,6 +562,9 @@ func unmarshalDict(src []byte) (Dict, int, error) {
 		return nil, 0, xerrors.Errorf("Unmarshal Dict error: not enough data (%v)", len(src))
 	}
 	count := get8(src[1:])
+	if err := checkCount(TypeDict, count); err != nil {
+		return nil, 0, err
+	}
 	l := 2
 	dict := make(Dict)
 	for i := 0; i < count; i++ {
@@ -1143,6 +1219,10 @@ func Unmarshal(src []byte) (interface{}, int, error) {
 		return unmarshalFloats(src)
 	case TypeDoubles:
 		return unmarshalDoubles(src)
+	case TypeTimestamp:
+		return unmarshalTimestamp(src)
+	case TypeUUID:
+		return unmarshalUUID(src)
 	}
 	return nil, 0, xerrors.Errorf("Unknown type: %v", Type(src[0]))
 }
@@ -5,7 +5,9 @@ import (
 	"math"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -359,6 +361,78 @@ func TestMarshalDouble(t *testing.T) {
 	}
 }
 
+func TestMarshalTimestamp(t *testing.T) {
+	tests := []struct {
+		in  time.Time
+		out time.Time
+		buf []byte
+	}{
+		{time.UnixMilli(0), time.UnixMilli(0).UTC(),
+			[]byte{byte(TypeTimestamp), 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{time.UnixMilli(-1), time.UnixMilli(-1).UTC(),
+			[]byte{byte(TypeTimestamp), 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		// ミリ秒未満は切り捨てる
+		{time.Date(2023, 11, 15, 7, 13, 20, 123456789, time.FixedZone("JST", 9*60*60)),
+			time.Date(2023, 11, 14, 22, 13, 20, 123000000, time.UTC),
+			[]byte{byte(TypeTimestamp), 0x80, 0x00, 0x01, 0x8b, 0xcf, 0xe5, 0x68, 0x7b}},
+	}
+	for _, test := range tests {
+		b := MarshalTimestamp(test.in)
+		if !reflect.DeepEqual(b, test.buf) {
+			t.Fatalf("MarshalTimestamp(%v):\n%#v\n%#v", test.in, b, test.buf)
+		}
+		r, l, e := Unmarshal(b)
+		if e != nil {
+			t.Fatalf("MarshalTimestamp(%v): Unmarshal error: %v", test.in, e)
+		}
+		if r != test.out || l != len(test.buf) {
+			t.Fatalf("MarshalTimestamp(%v): Unmarshal = %v (len=%v) wants %v (len=%v)",
+				test.in, r, l, test.out, len(test.buf))
+		}
+	}
+
+	// 時刻の順序とバイト列の順序が一致する
+	if bytes.Compare(MarshalTimestamp(time.UnixMilli(-1)), MarshalTimestamp(time.UnixMilli(1))) >= 0 {
+		t.Fatalf("MarshalTimestamp must be comparable")
+	}
+}
+
+func TestMarshalUUID(t *testing.T) {
+	s := "123e4567-e89b-12d3-a456-426614174000"
+	u, err := ParseUUID(s)
+	if err != nil {
+		t.Fatalf("ParseUUID(%q): %v", s, err)
+	}
+	if u.String() != s {
+		t.Fatalf("UUID.String() = %q, wants %q", u.String(), s)
+	}
+	if u2, _ := ParseUUID(strings.ToUpper(s)); u2 != u {
+		t.Fatalf("ParseUUID must accept upper case: %v", u2)
+	}
+	for _, bad := range []string{"", "123e4567e89b12d3a456426614174000", "123e4567-e89b-12d3-a456-42661417400g"} {
+		if _, err := ParseUUID(bad); err == nil {
+			t.Fatalf("ParseUUID(%q) must be an error", bad)
+		}
+	}
+
+	buf := []byte{byte(TypeUUID),
+		0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+	b := MarshalUUID(u)
+	if !reflect.DeepEqual(b, buf) {
+		t.Fatalf("MarshalUUID(%v):\n%#v\n%#v", u, b, buf)
+	}
+	r, l, e := Unmarshal(b)
+	if e != nil {
+		t.Fatalf("MarshalUUID(%v): Unmarshal error: %v", u, e)
+	}
+	if r != u || l != len(buf) {
+		t.Fatalf("MarshalUUID(%v): Unmarshal = %v (len=%v) wants %v (len=%v)", u, r, l, u, len(buf))
+	}
+	if _, _, e := Unmarshal(buf[:len(buf)-1]); e == nil {
+		t.Fatalf("Unmarshal short UUID must be an error")
+	}
+}
+
 func TestMarshalStr8(t *testing.T) {
 	s := "0123456789abcdef0123456789abcdef" // len=32
 	s = s + s + s + s + s + s + s + s       // len=256
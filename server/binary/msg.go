@@ -17,6 +17,8 @@ import (
 //
 // nonregular message (without sequence number)
 // - MsgTypePing
+// - MsgTypeNodeCount
+// - MsgTypeEventAck
 // binary format:
 // | 8bit MsgType | payload ... |
 type Msg interface {
@@ -47,6 +49,12 @@ const (
 	// payload:
 	// - UInt: node count
 	MsgTypeNodeCount
+
+	// MsgTypeEventAck : 受信済みのイベントの通知 (任意).
+	// サーバは配信の遅延と未受信のイベント数の計測に使う. タイムアウトの判定には使わない
+	// payload:
+	// - UInt: 最後に受信したイベントのsequence number
+	MsgTypeEventAck
 )
 const (
 	// regular msg
@@ -95,7 +103,148 @@ const (
 	// payload:
 	// - str8: client id
 	// - string: message
+	// - Byte: reason code (optional)
+	// - UInt: ban duration (second, optional)
+	// - Bool: demote (optional. trueなら切断せず観戦者にする)
 	MsgTypeKick
+
+	// MsgTypeKVSet : 部屋KVストアへの書き込み
+	// payload:
+	// - str8: key
+	// - Byte: ownership (0=none, 1=delete on leave, 2=transfer to master on leave)
+	// - marshaled data: value
+	MsgTypeKVSet
+
+	// MsgTypeKVDelete : 部屋KVストアからの削除
+	// payload:
+	// - str8: key
+	MsgTypeKVDelete
+
+	// MsgTypeKVCompareAndSwap : 部屋KVストアの値を比較して書き換え
+	// payload:
+	// - str8: key
+	// - Byte: ownership (0=none, 1=delete on leave, 2=transfer to master on leave)
+	// - marshaled data: expected value (Null: キーが存在しないこと)
+	// - marshaled data: new value
+	MsgTypeKVCompareAndSwap
+
+	// MsgTypeRoles : 自身のロールの登録
+	// 登録済みのロールは置き換えられる
+	// payload:
+	// - List: roles
+	MsgTypeRoles
+
+	// MsgTypeToRole : 指定ロールを持つクライアントへ送信
+	// payload:
+	// - str8: role
+	// - marshaled data...
+	MsgTypeToRole
+
+	// MsgTypeStartVote : 投票の開始
+	// payload:
+	// - str8: vote id
+	// - List: options (str8)
+	// - UShort: duration (second)
+	MsgTypeStartVote
+
+	// MsgTypeCastVote : 投票
+	// payload:
+	// - str8: vote id
+	// - Byte: option index
+	MsgTypeCastVote
+
+	// MsgTypeFetchHistory : 入室前に部屋でbroadcastされたメッセージの取得
+	// payload:
+	// - UShort: max count (0: 保持しているすべて)
+	MsgTypeFetchHistory
+
+	// MsgTypeAcceptMaster : Masterの移譲の受諾 (EvTypeMasterSwitchRequestedへの応答)
+	// payload: (empty)
+	MsgTypeAcceptMaster
+
+	// MsgTypeConfirmRejoin : 同じクライアントIDの別の接続からの入室への応答 (EvTypeRejoinRequestedへの応答)
+	// payload:
+	// - Bool: accept (true: 新しい接続に譲る)
+	MsgTypeConfirmRejoin
+
+	// MsgTypeBlocklist : 自身へのメッセージを受け取らない送信元の登録
+	// 登録済みのブロックリストは置き換えられる
+	// payload:
+	// - List: client IDs
+	MsgTypeBlocklist
+
+	// MsgTypeEncrypted : 暗号化されたメッセージをPlayerへ送信
+	// サーバはenvelopeを解釈せずに中継する (サイズと頻度のみ検査する)
+	// payload:
+	//  - List: user ids (空なら自分以外の全Player)
+	//  - envelope bytes...
+	MsgTypeEncrypted
+
+	// MsgTypeKeyExchange : 暗号化のための鍵交換メッセージをPlayerへ送信
+	// payload:
+	//  - List: user ids (空なら自分以外の全Player)
+	//  - key exchange bytes...
+	MsgTypeKeyExchange
+
+	// MsgTypePauseRoom : 部屋の一時停止と再開 (Masterのみ)
+	// 一時停止中はクライアントのタイムアウトと投票の締め切りを止める
+	// payload:
+	// - Bool: pause (true: 一時停止, false: 再開)
+	MsgTypePauseRoom
+
+	// MsgTypeCreateSuccessor : 部屋を引き継いだ後継の部屋を作る (Masterのみ)
+	// 作成後、PlayerにEvTypeRoomSuccessorが届く
+	// payload:
+	// - Dict: 後継の部屋の公開プロパティの変更 (Nullなら全て引き継ぐ)
+	MsgTypeCreateSuccessor
+
+	// MsgTypeRelayToParent : 子の部屋からロビー部屋 (親の部屋) への中継
+	// 親の部屋のクライアントにEvTypeChildRoomMessageが届く
+	// payload:
+	// - Byte: kind (see RoomRelayKind)
+	// - str8: 親の部屋のあて先のクライアントID (RoomRelayInviteのとき)
+	// - marshaled data
+	MsgTypeRelayToParent
+
+	// MsgTypeAddBot : ボットのPlayerを追加する (Masterのみ)
+	// ボットはMaxPlayersに数え、PlayerにEvTypeBotJoinedが届く.
+	// 同じクライアントIDのPlayerが入室するとボットから置き換わり、EvTypeRejoinedが届く.
+	// payload:
+	// - str8: bot client ID
+	// - Dict: properties
+	MsgTypeAddBot
+
+	// MsgTypeRemoveBot : ボットのPlayerを退室させる (Masterのみ)
+	// payload:
+	// - str8: bot client ID
+	MsgTypeRemoveBot
+
+	// MsgTypeBotMessage : ボットを送信者として全員に送信する (Masterのみ)
+	// payload:
+	// - str8: bot client ID
+	// - marshaled data
+	MsgTypeBotMessage
+
+	// MsgTypePromoteWatcher : 観戦者をPlayerに昇格する
+	// Masterが送ると指定した観戦者を昇格する. 観戦者が自身のIDで送ると昇格を申請する.
+	// 昇格すると再接続せずにPlayerになり、EvTypeJoinedが届く.
+	// Hub経由の観戦者にはEvTypePromoteRedirectが届くので、Playerとして入室し直す.
+	// payload:
+	// - str8: watcher client ID
+	MsgTypePromoteWatcher
+
+	// MsgTypeMergeRoom : Playerの少ない部屋を同じgameサーバの別の部屋に統合する (Masterのみ)
+	// 統合先の部屋にPlayerの席を確保し、PlayerにEvTypeRoomMergedが届いた後、この部屋は閉じる.
+	// payload:
+	// - str8: 統合先の部屋のID
+	MsgTypeMergeRoom
+
+	// MsgTypeClientDeadline : Player毎にClientDeadlineを指定する (Masterのみ)
+	// 部屋のClientDeadlineを変更しても、指定したPlayerには指定した値を使う.
+	// payload:
+	// - str8: client ID
+	// - UInt: deadline (秒. 0なら部屋のClientDeadlineに戻す)
+	MsgTypeClientDeadline
 )
 
 type nonregularMsg struct {
@@ -210,6 +359,23 @@ func UnmarshalNodeCountPayload(payload []byte) (uint32, error) {
 	return uint32(d.(int)), nil
 }
 
+// NewMsgEventAck constructs MsgEventAck
+func NewMsgEventAck(seq int) Msg {
+	return &nonregularMsg{
+		mtype:   MsgTypeEventAck,
+		payload: MarshalUInt(seq),
+	}
+}
+
+// UnmarshalEventAckPayload parses payload of MsgTypeEventAck
+func UnmarshalEventAckPayload(payload []byte) (int, error) {
+	d, _, e := UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return 0, xerrors.Errorf("Invalid MsgEventAck payload (sequence number): %w", e)
+	}
+	return d.(int), nil
+}
+
 // MarshalLeavePayload marshals MsgLeave payload
 func MarshalLeavePayload(message string) []byte {
 	const limit = 123
@@ -321,6 +487,9 @@ func UnmarshalRoomPropPayload(payload []byte) (*MsgRoomPropPayload, error) {
 
 	// public props
 	rpp.PublicProps, l, e = UnmarshalNullDict(payload)
+	if e == nil {
+		e = CheckDictLimits(rpp.PublicProps)
+	}
 	if e != nil {
 		return nil, xerrors.Errorf("Invalid MsgRoomProp payload (public props): %w", e)
 	}
@@ -328,6 +497,9 @@ func UnmarshalRoomPropPayload(payload []byte) (*MsgRoomPropPayload, error) {
 
 	// private props
 	rpp.PrivateProps, _, e = UnmarshalNullDict(payload)
+	if e == nil {
+		e = CheckDictLimits(rpp.PrivateProps)
+	}
 	if e != nil {
 		return nil, xerrors.Errorf("Invalid MsgRoomProp payload (private props): %w", e)
 	}
@@ -351,6 +523,9 @@ func MarshalClientPropPayload(prop Dict) []byte {
 // UnmarshalClientPropPayload unmarshals MsgClientProp payload
 func UnmarshalClientPropPayload(payload []byte) (Dict, error) {
 	d, _, e := UnmarshalNullDict(payload)
+	if e == nil {
+		e = CheckDictLimits(d)
+	}
 	if e != nil {
 		return nil, xerrors.Errorf("Invalid MsgClientProp payload (props): %w", e)
 	}
@@ -406,23 +581,592 @@ func UnmarshalTargetsAndData(payload []byte) ([]string, []byte, error) {
 	return targets, payload[l:], nil
 }
 
+// MarshalEncryptedPayload marshals MsgEncrypted and MsgKeyExchange payload
+func MarshalEncryptedPayload(targets []string, envelope []byte) []byte {
+	return MarshalTargetsPayload(targets, envelope)
+}
+
+// UnmarshalEncryptedPayload unmarshals MsgEncrypted and MsgKeyExchange payload
+func UnmarshalEncryptedPayload(payload []byte) (targets []string, envelope []byte, err error) {
+	targets, envelope, err = UnmarshalTargetsAndData(payload)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("Invalid MsgEncrypted payload: %w", err)
+	}
+	return targets, envelope, nil
+}
+
+// MarshalRolesPayload marshals MsgRoles payload
+func MarshalRolesPayload(roles []string) []byte {
+	return MarshalStrings(roles)
+}
+
+// UnmarshalRolesPayload unmarshals MsgRoles payload
+func UnmarshalRolesPayload(payload []byte) ([]string, error) {
+	r, _, e := UnmarshalAs(payload, TypeList, TypeNull)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgRoles payload (roles): %w", e)
+	}
+	ls, _ := r.(List)
+	roles := make([]string, len(ls))
+	for i, p := range ls {
+		r, _, e := UnmarshalAs(p, TypeStr8, TypeStr16)
+		if e != nil {
+			return nil, xerrors.Errorf("Invalid MsgRoles payload (role[%v]): %w", i, e)
+		}
+		roles[i] = r.(string)
+	}
+	return roles, nil
+}
+
+// MarshalBlocklistPayload marshals MsgBlocklist payload
+func MarshalBlocklistPayload(clientIds []string) []byte {
+	return MarshalStrings(clientIds)
+}
+
+// UnmarshalBlocklistPayload unmarshals MsgBlocklist payload
+func UnmarshalBlocklistPayload(payload []byte) ([]string, error) {
+	r, _, e := UnmarshalAs(payload, TypeList, TypeNull)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgBlocklist payload (client ids): %w", e)
+	}
+	ls, _ := r.(List)
+	ids := make([]string, len(ls))
+	for i, p := range ls {
+		id, _, e := UnmarshalAs(p, TypeStr8)
+		if e != nil {
+			return nil, xerrors.Errorf("Invalid MsgBlocklist payload (client id[%v]): %w", i, e)
+		}
+		ids[i] = id.(string)
+	}
+	return ids, nil
+}
+
+// MarshalToRolePayload marshals MsgToRole payload
+func MarshalToRolePayload(role string, data []byte) []byte {
+	p := MarshalStr8(role)
+	p = append(p, data...)
+	return p
+}
+
+// UnmarshalToRolePayload unmarshals MsgToRole payload
+func UnmarshalToRolePayload(payload []byte) (string, []byte, error) {
+	r, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", nil, xerrors.Errorf("Invalid MsgToRole payload (role): %w", e)
+	}
+	return r.(string), payload[l:], nil
+}
+
+type MsgStartVotePayload struct {
+	EventPayload []byte
+
+	VoteId   string
+	Options  []string
+	Duration uint32
+}
+
+// MarshalStartVotePayload marshals MsgStartVote payload
+func MarshalStartVotePayload(voteId string, options []string, duration uint32) []byte {
+	p := MarshalStr8(voteId)
+	p = append(p, MarshalStrings(options)...)
+	p = append(p, MarshalUShort(int(duration))...)
+	return p
+}
+
+// UnmarshalStartVotePayload unmarshals MsgStartVote payload
+func UnmarshalStartVotePayload(payload []byte) (*MsgStartVotePayload, error) {
+	svp := MsgStartVotePayload{
+		EventPayload: payload,
+	}
+
+	// vote id
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgStartVote payload (vote id): %w", e)
+	}
+	svp.VoteId = d.(string)
+	payload = payload[l:]
+
+	// options
+	d, l, e = UnmarshalAs(payload, TypeList)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgStartVote payload (options): %w", e)
+	}
+	for i, o := range d.(List) {
+		s, _, e := UnmarshalAs(o, TypeStr8, TypeStr16)
+		if e != nil {
+			return nil, xerrors.Errorf("Invalid MsgStartVote payload (option[%v]): %w", i, e)
+		}
+		svp.Options = append(svp.Options, s.(string))
+	}
+	payload = payload[l:]
+
+	// duration
+	d, _, e = UnmarshalAs(payload, TypeUShort)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgStartVote payload (duration): %w", e)
+	}
+	svp.Duration = uint32(d.(int))
+
+	return &svp, nil
+}
+
+// MarshalCastVotePayload marshals MsgCastVote payload
+func MarshalCastVotePayload(voteId string, option int) []byte {
+	p := MarshalStr8(voteId)
+	p = append(p, MarshalByte(option)...)
+	return p
+}
+
+// UnmarshalCastVotePayload unmarshals MsgCastVote payload
+func UnmarshalCastVotePayload(payload []byte) (string, int, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", 0, xerrors.Errorf("Invalid MsgCastVote payload (vote id): %w", e)
+	}
+	id := d.(string)
+
+	o, _, e := UnmarshalAs(payload[l:], TypeByte)
+	if e != nil {
+		return "", 0, xerrors.Errorf("Invalid MsgCastVote payload (option): %w", e)
+	}
+	return id, o.(int), nil
+}
+
+// MarshalFetchHistoryPayload marshals MsgFetchHistory payload
+func MarshalFetchHistoryPayload(count int) []byte {
+	return MarshalUShort(count)
+}
+
+// UnmarshalFetchHistoryPayload unmarshals MsgFetchHistory payload
+func UnmarshalFetchHistoryPayload(payload []byte) (int, error) {
+	d, _, e := UnmarshalAs(payload, TypeUShort)
+	if e != nil {
+		return 0, xerrors.Errorf("Invalid MsgFetchHistory payload (count): %w", e)
+	}
+	return d.(int), nil
+}
+
+// MarshalConfirmRejoinPayload marshals MsgConfirmRejoin payload
+func MarshalConfirmRejoinPayload(accept bool) []byte {
+	return MarshalBool(accept)
+}
+
+// UnmarshalConfirmRejoinPayload unmarshals MsgConfirmRejoin payload
+func UnmarshalConfirmRejoinPayload(payload []byte) (bool, error) {
+	d, _, e := UnmarshalAs(payload, TypeFalse, TypeTrue)
+	if e != nil {
+		return false, xerrors.Errorf("Invalid MsgConfirmRejoin payload (accept): %w", e)
+	}
+	return d.(bool), nil
+}
+
+// MarshalPauseRoomPayload marshals MsgPauseRoom payload
+func MarshalPauseRoomPayload(pause bool) []byte {
+	return MarshalBool(pause)
+}
+
+// UnmarshalPauseRoomPayload unmarshals MsgPauseRoom payload
+func UnmarshalPauseRoomPayload(payload []byte) (bool, error) {
+	d, _, e := UnmarshalAs(payload, TypeFalse, TypeTrue)
+	if e != nil {
+		return false, xerrors.Errorf("Invalid MsgPauseRoom payload (pause): %w", e)
+	}
+	return d.(bool), nil
+}
+
+func MarshalCreateSuccessorPayload(props Dict) []byte {
+	return MarshalDict(props)
+}
+
+// UnmarshalCreateSuccessorPayload unmarshals MsgCreateSuccessor payload
+func UnmarshalCreateSuccessorPayload(payload []byte) (Dict, error) {
+	d, _, e := UnmarshalNullDict(payload)
+	if e == nil {
+		e = CheckDictLimits(d)
+	}
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgCreateSuccessor payload (props): %w", e)
+	}
+	return d, nil
+}
+
+// RoomRelayKind : 子の部屋からロビー部屋へ中継するメッセージの種類
+type RoomRelayKind byte
+
+const (
+	// RoomRelayInvite : 招待. 親の部屋の指定したクライアントに送る
+	RoomRelayInvite RoomRelayKind = 1 + iota
+	// RoomRelaySummary : 対戦状況の要約. 子の部屋のMasterだけが送れ、親の部屋の全員に送る
+	RoomRelaySummary
+	// RoomRelayClosed : 子の部屋が閉じた. サーバが親の部屋の全員に送る (dataは空)
+	RoomRelayClosed
+)
+
+// MarshalRelayToParentPayload marshals MsgRelayToParent payload
+func MarshalRelayToParentPayload(kind RoomRelayKind, target string, data []byte) []byte {
+	p := MarshalByte(int(kind))
+	p = append(p, MarshalStr8(target)...)
+	p = append(p, data...)
+	return p
+}
+
+type MsgRelayToParentPayload struct {
+	Kind   RoomRelayKind
+	Target string
+	Data   []byte
+}
+
+// UnmarshalRelayToParentPayload unmarshals MsgRelayToParent payload
+func UnmarshalRelayToParentPayload(payload []byte) (*MsgRelayToParentPayload, error) {
+	d, l, e := UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgRelayToParent payload (kind): %w", e)
+	}
+	um := MsgRelayToParentPayload{Kind: RoomRelayKind(d.(int))}
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgRelayToParent payload (target): %w", e)
+	}
+	um.Target = d.(string)
+	um.Data = payload[l:]
+	return &um, nil
+}
+
+// MarshalAddBotPayload marshals MsgAddBot payload
+func MarshalAddBotPayload(botId string, props Dict) []byte {
+	p := MarshalStr8(botId)
+	p = append(p, MarshalDict(props)...)
+	return p
+}
+
+type MsgAddBotPayload struct {
+	BotId string
+	Props []byte // marshaled Dict
+}
+
+// UnmarshalAddBotPayload unmarshals MsgAddBot payload
+func UnmarshalAddBotPayload(payload []byte) (*MsgAddBotPayload, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgAddBot payload (bot id): %w", e)
+	}
+	um := MsgAddBotPayload{BotId: d.(string)}
+	payload = payload[l:]
+
+	props, _, e := UnmarshalNullDict(payload)
+	if e == nil {
+		e = CheckDictLimits(props)
+	}
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgAddBot payload (props): %w", e)
+	}
+	um.Props = payload
+	return &um, nil
+}
+
+// UnmarshalRemoveBotPayload unmarshals MsgRemoveBot payload
+func UnmarshalRemoveBotPayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid MsgRemoveBot payload (bot id): %w", e)
+	}
+	return d.(string), nil
+}
+
+// MarshalBotMessagePayload marshals MsgBotMessage payload
+func MarshalBotMessagePayload(botId string, data []byte) []byte {
+	p := MarshalStr8(botId)
+	p = append(p, data...)
+	return p
+}
+
+type MsgBotMessagePayload struct {
+	BotId string
+	Data  []byte
+}
+
+// UnmarshalBotMessagePayload unmarshals MsgBotMessage payload
+func UnmarshalBotMessagePayload(payload []byte) (*MsgBotMessagePayload, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgBotMessage payload (bot id): %w", e)
+	}
+	return &MsgBotMessagePayload{BotId: d.(string), Data: payload[l:]}, nil
+}
+
+// UnmarshalPromoteWatcherPayload unmarshals MsgPromoteWatcher payload
+func UnmarshalPromoteWatcherPayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid MsgPromoteWatcher payload (watcher id): %w", e)
+	}
+	return d.(string), nil
+}
+
+func UnmarshalMergeRoomPayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid MsgMergeRoom payload (room id): %w", e)
+	}
+	return d.(string), nil
+}
+
+// MarshalClientDeadlinePayload marshals MsgClientDeadline payload
+func MarshalClientDeadlinePayload(clientId string, deadline uint32) []byte {
+	p := MarshalStr8(clientId)
+	p = append(p, MarshalUInt(int(deadline))...)
+	return p
+}
+
+// UnmarshalClientDeadlinePayload unmarshals MsgClientDeadline payload
+func UnmarshalClientDeadlinePayload(payload []byte) (string, uint32, error) {
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", 0, xerrors.Errorf("Invalid MsgClientDeadline payload (client id): %w", e)
+	}
+	id := d.(string)
+	d, _, e = UnmarshalAs(payload[l:], TypeUInt)
+	if e != nil {
+		return "", 0, xerrors.Errorf("Invalid MsgClientDeadline payload (deadline): %w", e)
+	}
+	return id, uint32(d.(int)), nil
+}
+
+// KickReason : Kickの理由コード. 値の意味はアプリケーションで定義する
+type KickReason byte
+
+// KickReasonNone : 理由コードなし
+const KickReasonNone KickReason = 0
+
+type MsgKickPayload struct {
+	ClientId    string
+	Message     string
+	Reason      KickReason
+	BanDuration uint32 // second
+	Demote      bool   // 切断せず観戦者にする
+}
+
+// MarshalKickPayload marshals MsgKick payload
+func MarshalKickPayload(clientId, message string, reason KickReason, banDuration uint32) []byte {
+	p := MarshalStr8(clientId)
+	p = append(p, MarshalStr8(message)...)
+	p = append(p, MarshalByte(int(reason))...)
+	p = append(p, MarshalUInt(int(banDuration))...)
+	return p
+}
+
+// MarshalDemotePayload marshals MsgKick payload which demotes the player to a watcher
+func MarshalDemotePayload(clientId, message string, reason KickReason) []byte {
+	p := MarshalKickPayload(clientId, message, reason, 0)
+	p = append(p, MarshalBool(true)...)
+	return p
+}
+
 // UnmarshalKickPayload parses payload of MsgTypeKick
-func UnmarshalKickPayload(payload []byte) (string, string, error) {
+func UnmarshalKickPayload(payload []byte) (*MsgKickPayload, error) {
 	d, l, e := UnmarshalAs(payload, TypeStr8)
 	if e != nil {
-		return "", "", xerrors.Errorf("Invalid MsgKick payload (client id): %w", e)
+		return nil, xerrors.Errorf("Invalid MsgKick payload (client id): %w", e)
 	}
-	m, _, e := Unmarshal(payload[l:])
+	kp := MsgKickPayload{ClientId: d.(string)}
+	payload = payload[l:]
+
+	m, l, e := Unmarshal(payload)
 	if e != nil {
-		return d.(string), "", xerrors.Errorf("Invalid MsgKick payload (message): %w", e)
+		return nil, xerrors.Errorf("Invalid MsgKick payload (message): %w", e)
 	}
 	msg, ok := m.(string)
 	if !ok {
-		return d.(string), "", xerrors.Errorf("Invalid MsgKick payload (message): %T", m)
+		return nil, xerrors.Errorf("Invalid MsgKick payload (message): %T", m)
 	}
 	if msg == "" {
 		msg = "kicked"
 	}
+	kp.Message = msg
+	payload = payload[l:]
 
-	return d.(string), msg, nil
+	// reason, ban duration は省略可能
+	if len(payload) == 0 {
+		return &kp, nil
+	}
+	d, l, e = UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgKick payload (reason): %w", e)
+	}
+	kp.Reason = KickReason(d.(int))
+	payload = payload[l:]
+
+	if len(payload) == 0 {
+		return &kp, nil
+	}
+	d, l, e = UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgKick payload (ban duration): %w", e)
+	}
+	kp.BanDuration = uint32(d.(int))
+	payload = payload[l:]
+
+	if len(payload) == 0 {
+		return &kp, nil
+	}
+	d, _, e = UnmarshalAs(payload, TypeFalse, TypeTrue)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgKick payload (demote): %w", e)
+	}
+	kp.Demote = d.(bool)
+
+	return &kp, nil
+}
+
+// KVOwnership : 部屋KVストアのキーの所有方法
+type KVOwnership byte
+
+const (
+	// KVOwnerNone : 所有者なし（部屋の所有）
+	KVOwnerNone KVOwnership = iota
+	// KVOwnerDeleteOnLeave : 書き込んだPlayerの所有. 所有者の退室時に削除される
+	KVOwnerDeleteOnLeave
+	// KVOwnerTransferOnLeave : 書き込んだPlayerの所有. 所有者の退室時にMasterへ移譲される
+	KVOwnerTransferOnLeave
+)
+
+type MsgKVSetPayload struct {
+	Key       string
+	Ownership KVOwnership
+	Value     []byte
+}
+
+// MarshalKVSetPayload marshals MsgKVSet payload
+func MarshalKVSetPayload(key string, ownership KVOwnership, value []byte) []byte {
+	p := MarshalStr8(key)
+	p = append(p, MarshalByte(int(ownership))...)
+	p = append(p, value...)
+	return p
+}
+
+// UnmarshalKVSetPayload unmarshals MsgKVSet payload
+func UnmarshalKVSetPayload(payload []byte) (*MsgKVSetPayload, error) {
+	var kvp MsgKVSetPayload
+
+	// key
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgKVSet payload (key): %w", e)
+	}
+	kvp.Key = d.(string)
+	payload = payload[l:]
+
+	// ownership
+	kvp.Ownership, l, e = unmarshalKVOwnership(payload)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgKVSet payload (ownership): %w", e)
+	}
+	payload = payload[l:]
+
+	// value
+	_, l, e = Unmarshal(payload)
+	if e == nil {
+		e = CheckLimits(payload[:l])
+	}
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgKVSet payload (value): %w", e)
+	}
+	kvp.Value = payload[:l]
+
+	return &kvp, nil
+}
+
+// MarshalKVDeletePayload marshals MsgKVDelete payload
+func MarshalKVDeletePayload(key string) []byte {
+	return MarshalStr8(key)
+}
+
+// UnmarshalKVDeletePayload unmarshals MsgKVDelete payload
+func UnmarshalKVDeletePayload(payload []byte) (string, error) {
+	d, _, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return "", xerrors.Errorf("Invalid MsgKVDelete payload (key): %w", e)
+	}
+	return d.(string), nil
+}
+
+type MsgKVCompareAndSwapPayload struct {
+	Key       string
+	Ownership KVOwnership
+	Expected  []byte
+	Value     []byte
+}
+
+// MarshalKVCompareAndSwapPayload marshals MsgKVCompareAndSwap payload
+//
+// expected に nil を渡すとキーが存在しないことを条件とする
+func MarshalKVCompareAndSwapPayload(key string, ownership KVOwnership, expected, value []byte) []byte {
+	if len(expected) == 0 {
+		expected = MarshalNull()
+	}
+	p := MarshalStr8(key)
+	p = append(p, MarshalByte(int(ownership))...)
+	p = append(p, expected...)
+	p = append(p, value...)
+	return p
+}
+
+// UnmarshalKVCompareAndSwapPayload unmarshals MsgKVCompareAndSwap payload
+func UnmarshalKVCompareAndSwapPayload(payload []byte) (*MsgKVCompareAndSwapPayload, error) {
+	var kvp MsgKVCompareAndSwapPayload
+
+	// key
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgKVCompareAndSwap payload (key): %w", e)
+	}
+	kvp.Key = d.(string)
+	payload = payload[l:]
+
+	// ownership
+	kvp.Ownership, l, e = unmarshalKVOwnership(payload)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgKVCompareAndSwap payload (ownership): %w", e)
+	}
+	payload = payload[l:]
+
+	// expected value
+	d, l, e = Unmarshal(payload)
+	if e == nil {
+		e = CheckLimits(payload[:l])
+	}
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgKVCompareAndSwap payload (expected): %w", e)
+	}
+	if d != nil {
+		kvp.Expected = payload[:l]
+	}
+	payload = payload[l:]
+
+	// new value
+	_, l, e = Unmarshal(payload)
+	if e == nil {
+		e = CheckLimits(payload[:l])
+	}
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MsgKVCompareAndSwap payload (value): %w", e)
+	}
+	kvp.Value = payload[:l]
+
+	return &kvp, nil
+}
+
+func unmarshalKVOwnership(payload []byte) (KVOwnership, int, error) {
+	d, l, e := UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return 0, l, e
+	}
+	o := KVOwnership(d.(int))
+	if o > KVOwnerTransferOnLeave {
+		return 0, l, xerrors.Errorf("unknown ownership: %v", o)
+	}
+	return o, l, nil
 }
@@ -1,6 +1,7 @@
 package binary
 
 import (
+	"bytes"
 	"reflect"
 	"testing"
 )
@@ -149,3 +150,297 @@ func TestSwitchMasterPayload(t *testing.T) {
 		t.Fatalf("new master: %v, wants %v", u, newmaster)
 	}
 }
+
+func TestKVSetPayload(t *testing.T) {
+	const key = "kvkey"
+	val := MarshalStr8("kvvalue")
+
+	p := MarshalKVSetPayload(key, KVOwnerTransferOnLeave, val)
+	u, err := UnmarshalKVSetPayload(p)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if u.Key != key {
+		t.Fatalf("Key = %v, wants %v", u.Key, key)
+	}
+	if u.Ownership != KVOwnerTransferOnLeave {
+		t.Fatalf("Ownership = %v, wants %v", u.Ownership, KVOwnerTransferOnLeave)
+	}
+	if !reflect.DeepEqual(u.Value, val) {
+		t.Fatalf("Value = %v, wants %v", u.Value, val)
+	}
+}
+
+func TestKVCompareAndSwapPayload(t *testing.T) {
+	tests := map[string]struct {
+		expected []byte
+	}{
+		"absent": {nil},
+		"value":  {MarshalInt(10)},
+	}
+	val := MarshalInt(11)
+	for k, tc := range tests {
+		p := MarshalKVCompareAndSwapPayload("key", KVOwnerNone, tc.expected, val)
+		u, err := UnmarshalKVCompareAndSwapPayload(p)
+		if err != nil {
+			t.Fatalf("%v: %v", k, err)
+		}
+		if !reflect.DeepEqual(u.Expected, tc.expected) {
+			t.Fatalf("%v: Expected = %v, wants %v", k, u.Expected, tc.expected)
+		}
+		if !reflect.DeepEqual(u.Value, val) {
+			t.Fatalf("%v: Value = %v, wants %v", k, u.Value, val)
+		}
+	}
+}
+
+func TestRolesPayload(t *testing.T) {
+	tests := map[string]struct {
+		roles []string
+		exp   []string
+	}{
+		"empty": {[]string{}, []string{}},
+		"roles": {[]string{"referee", "team:red"}, []string{"referee", "team:red"}},
+	}
+	for k, tc := range tests {
+		p := MarshalRolesPayload(tc.roles)
+		u, err := UnmarshalRolesPayload(p)
+		if err != nil {
+			t.Fatalf("%v: %v", k, err)
+		}
+		if !reflect.DeepEqual(u, tc.exp) {
+			t.Fatalf("%v: %#v, wants %#v", k, u, tc.exp)
+		}
+	}
+}
+
+func TestBlocklistPayload(t *testing.T) {
+	tests := map[string]struct {
+		payload []byte
+		exp     []string
+	}{
+		"empty": {MarshalBlocklistPayload([]string{}), []string{}},
+		"null":  {MarshalNull(), []string{}},
+		"ids":   {MarshalBlocklistPayload([]string{"player1", "player2"}), []string{"player1", "player2"}},
+	}
+	for k, tc := range tests {
+		u, err := UnmarshalBlocklistPayload(tc.payload)
+		if err != nil {
+			t.Fatalf("%v: %v", k, err)
+		}
+		if !reflect.DeepEqual(u, tc.exp) {
+			t.Fatalf("%v: %#v, wants %#v", k, u, tc.exp)
+		}
+	}
+}
+
+func TestEncryptedPayload(t *testing.T) {
+	tests := map[string]struct {
+		targets  []string
+		envelope []byte
+	}{
+		"all":     {[]string{}, []byte{1, 2, 3}},
+		"targets": {[]string{"player1", "player2"}, []byte("ciphertext")},
+		"empty":   {[]string{"player1"}, []byte{}},
+	}
+	for k, tc := range tests {
+		targets, envelope, err := UnmarshalEncryptedPayload(MarshalEncryptedPayload(tc.targets, tc.envelope))
+		if err != nil {
+			t.Fatalf("%v: %v", k, err)
+		}
+		if !reflect.DeepEqual(targets, tc.targets) || !bytes.Equal(envelope, tc.envelope) {
+			t.Fatalf("%v: (%v, %v), wants (%v, %v)", k, targets, envelope, tc.targets, tc.envelope)
+		}
+	}
+}
+
+func TestStartVotePayload(t *testing.T) {
+	const id = "rematch"
+	opts := []string{"yes", "no"}
+	const dur = 30
+
+	p := MarshalStartVotePayload(id, opts, dur)
+	ev := NewEvVoteStarted("starter", &MsgStartVotePayload{EventPayload: p})
+	u, err := UnmarshalEvVoteStartedPayload(ev.Payload())
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if u.ClientId != "starter" {
+		t.Fatalf("ClientId = %v, wants %v", u.ClientId, "starter")
+	}
+	if u.VoteId != id {
+		t.Fatalf("VoteId = %v, wants %v", u.VoteId, id)
+	}
+	if !reflect.DeepEqual(u.Options, opts) {
+		t.Fatalf("Options = %v, wants %v", u.Options, opts)
+	}
+	if u.Duration != dur {
+		t.Fatalf("Duration = %v, wants %v", u.Duration, dur)
+	}
+}
+
+func TestFetchHistoryPayload(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 65535} {
+		c, err := UnmarshalFetchHistoryPayload(MarshalFetchHistoryPayload(n))
+		if err != nil {
+			t.Fatalf("unmarshal(%v): %v", n, err)
+		}
+		if c != n {
+			t.Fatalf("count = %v, wants %v", c, n)
+		}
+	}
+	if _, err := UnmarshalFetchHistoryPayload(MarshalStr8("x")); err == nil {
+		t.Fatalf("invalid payload must be error")
+	}
+}
+
+func TestEventAckPayload(t *testing.T) {
+	for _, seq := range []int{0, 1, 0xffffff} {
+		m := NewMsgEventAck(seq)
+		if m.Type() != MsgTypeEventAck {
+			t.Fatalf("type = %v, wants %v", m.Type(), MsgTypeEventAck)
+		}
+		s, err := UnmarshalEventAckPayload(m.Payload())
+		if err != nil {
+			t.Fatalf("unmarshal(%v): %v", seq, err)
+		}
+		if s != seq {
+			t.Fatalf("seq = %v, wants %v", s, seq)
+		}
+	}
+	if _, err := UnmarshalEventAckPayload(MarshalStr8("x")); err == nil {
+		t.Fatalf("invalid payload must be error")
+	}
+}
+
+func TestConfirmRejoinPayload(t *testing.T) {
+	for _, accept := range []bool{true, false} {
+		a, err := UnmarshalConfirmRejoinPayload(MarshalConfirmRejoinPayload(accept))
+		if err != nil {
+			t.Fatalf("unmarshal(%v): %v", accept, err)
+		}
+		if a != accept {
+			t.Fatalf("accept = %v, wants %v", a, accept)
+		}
+	}
+	if _, err := UnmarshalConfirmRejoinPayload(MarshalByte(1)); err == nil {
+		t.Fatalf("invalid payload must be error")
+	}
+}
+
+func TestPauseRoomPayload(t *testing.T) {
+	for _, pause := range []bool{true, false} {
+		p, err := UnmarshalPauseRoomPayload(MarshalPauseRoomPayload(pause))
+		if err != nil {
+			t.Fatalf("unmarshal(%v): %v", pause, err)
+		}
+		if p != pause {
+			t.Fatalf("pause = %v, wants %v", p, pause)
+		}
+	}
+}
+
+func TestClientDeadlinePayload(t *testing.T) {
+	for _, deadline := range []uint32{0, 30} {
+		id, d, err := UnmarshalClientDeadlinePayload(MarshalClientDeadlinePayload("player1", deadline))
+		if err != nil {
+			t.Fatalf("unmarshal(%v): %v", deadline, err)
+		}
+		if id != "player1" || d != deadline {
+			t.Fatalf("payload = %v, %v, wants player1, %v", id, d, deadline)
+		}
+	}
+	if _, _, err := UnmarshalClientDeadlinePayload(MarshalStr8("player1")); err == nil {
+		t.Fatalf("payload without deadline must be an error")
+	}
+}
+
+func TestCreateSuccessorPayload(t *testing.T) {
+	for _, props := range []Dict{nil, {"round": MarshalInt(2)}} {
+		p, err := UnmarshalCreateSuccessorPayload(MarshalCreateSuccessorPayload(props))
+		if err != nil {
+			t.Fatalf("unmarshal(%v): %v", props, err)
+		}
+		if len(p) != len(props) || !reflect.DeepEqual(p["round"], props["round"]) {
+			t.Fatalf("props = %v, wants %v", p, props)
+		}
+	}
+}
+
+func TestRelayToParentPayload(t *testing.T) {
+	data := MarshalStr8("join us")
+	p, err := UnmarshalRelayToParentPayload(MarshalRelayToParentPayload(RoomRelayInvite, "friend", data))
+	if err != nil {
+		t.Fatalf("UnmarshalRelayToParentPayload: %v", err)
+	}
+	want := &MsgRelayToParentPayload{RoomRelayInvite, "friend", data}
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("payload = %+v, wants %+v", p, want)
+	}
+}
+
+func TestBotPayloads(t *testing.T) {
+	props := Dict{"level": MarshalInt(3)}
+	p, err := UnmarshalAddBotPayload(MarshalAddBotPayload("bot1", props))
+	if err != nil {
+		t.Fatalf("UnmarshalAddBotPayload: %v", err)
+	}
+	if want := (&MsgAddBotPayload{"bot1", MarshalDict(props)}); !reflect.DeepEqual(p, want) {
+		t.Fatalf("payload = %+v, wants %+v", p, want)
+	}
+
+	id, err := UnmarshalRemoveBotPayload(MarshalStr8("bot1"))
+	if err != nil {
+		t.Fatalf("UnmarshalRemoveBotPayload: %v", err)
+	}
+	if id != "bot1" {
+		t.Fatalf("bot id = %q, wants %q", id, "bot1")
+	}
+
+	data := MarshalStr8("hello")
+	m, err := UnmarshalBotMessagePayload(MarshalBotMessagePayload("bot1", data))
+	if err != nil {
+		t.Fatalf("UnmarshalBotMessagePayload: %v", err)
+	}
+	if want := (&MsgBotMessagePayload{"bot1", data}); !reflect.DeepEqual(m, want) {
+		t.Fatalf("payload = %+v, wants %+v", m, want)
+	}
+}
+
+func TestKickPayload(t *testing.T) {
+	tests := map[string]struct {
+		payload []byte
+		exp     MsgKickPayload
+	}{
+		"legacy": {
+			append(MarshalStr8("target"), MarshalStr8("")...),
+			MsgKickPayload{"target", "kicked", KickReasonNone, 0, false},
+		},
+		"reason": {
+			MarshalKickPayload("target", "bye", 3, 600),
+			MsgKickPayload{"target", "bye", 3, 600, false},
+		},
+		"demote": {
+			MarshalDemotePayload("target", "afk", 5),
+			MsgKickPayload{"target", "afk", 5, 0, true},
+		},
+	}
+	for k, tc := range tests {
+		u, err := UnmarshalKickPayload(tc.payload)
+		if err != nil {
+			t.Fatalf("%v: %v", k, err)
+		}
+		if *u != tc.exp {
+			t.Fatalf("%v: %#v, wants %#v", k, *u, tc.exp)
+		}
+
+		ev := NewEvLeftByKick(u.ClientId, "master", u.Message, u.Reason, u.BanDuration)
+		left, err := UnmarshalEvLeftPayload(ev.Payload())
+		if err != nil {
+			t.Fatalf("%v: EvLeft: %v", k, err)
+		}
+		if !left.Kicked || left.Reason != u.Reason || left.BanDuration != u.BanDuration {
+			t.Fatalf("%v: EvLeft: %#v, wants %#v", k, left, u)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package binary
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// 多重化した接続 (/mux):
+// 1つのwebsocket接続で同じgameサーバの複数の部屋に接続する.
+// 各フレームの先頭1バイトが部屋のハンドルで、続くバイト列は部屋毎の接続と同じMsg/Eventのフレーム.
+// ハンドルはクライアントが1から255の範囲で割り当て、0は接続自体の制御に使う.
+//
+// 制御フレーム (handle 0):
+//   - Byte: MuxControl
+//   - payload (MuxControl毎)
+
+// MuxControlHandle : 制御フレームのハンドル
+const MuxControlHandle = 0
+
+// MuxControl : 制御フレームの種類
+type MuxControl byte
+
+const (
+	// MuxAttach : 部屋のクライアントとしてハンドルを割り当てる (client -> server)
+	// 成功するとそのハンドルでEvTypePeerReadyが届く.
+	// payload:
+	//  - Byte: handle
+	//  - str8: room ID
+	//  - str8: client ID
+	//  - str16: auth data (部屋毎の接続のAuthorizationヘッダのBearerトークン)
+	//  - UInt: last event seq
+	MuxAttach MuxControl = 1 + iota
+
+	// MuxDetach : ハンドルの接続を切る (client -> server)
+	// 部屋毎の接続をクライアントから閉じたときと同じく、退室はしない.
+	// payload:
+	//  - Byte: handle
+	MuxDetach
+
+	// MuxClosed : ハンドルの接続をサーバが閉じた (server -> client)
+	// 以降そのハンドルは再び割り当てられる.
+	// payload:
+	//  - Byte: handle
+	//  - Close frameのpayload (2byteのclose code + reason text. see FormatCloseText)
+	MuxClosed
+)
+
+// MuxFrame : handleのフレームを作る
+func MuxFrame(handle byte, body []byte) []byte {
+	frame := make([]byte, 1+len(body))
+	frame[0] = handle
+	copy(frame[1:], body)
+	return frame
+}
+
+// ParseMuxFrame : フレームをハンドルと部屋毎の接続のフレームに分ける
+func ParseMuxFrame(data []byte) (byte, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, xerrors.Errorf("mux frame too short: %v", len(data))
+	}
+	return data[0], data[1:], nil
+}
+
+// MarshalMuxAttach : MuxAttachの制御フレームを作る
+func MarshalMuxAttach(handle byte, roomId, clientId, authData string, lastEvSeq int) []byte {
+	p := []byte{MuxControlHandle, byte(MuxAttach)}
+	p = append(p, MarshalByte(int(handle))...)
+	p = append(p, MarshalStr8(roomId)...)
+	p = append(p, MarshalStr8(clientId)...)
+	p = append(p, MarshalStr16(authData)...)
+	p = append(p, MarshalUInt(lastEvSeq)...)
+	return p
+}
+
+// MarshalMuxDetach : MuxDetachの制御フレームを作る
+func MarshalMuxDetach(handle byte) []byte {
+	p := []byte{MuxControlHandle, byte(MuxDetach)}
+	return append(p, MarshalByte(int(handle))...)
+}
+
+// MarshalMuxClosed : MuxClosedの制御フレームを作る. closeMsgはClose frameのpayload
+func MarshalMuxClosed(handle byte, closeMsg []byte) []byte {
+	p := []byte{MuxControlHandle, byte(MuxClosed)}
+	p = append(p, MarshalByte(int(handle))...)
+	return append(p, closeMsg...)
+}
+
+// UnmarshalMuxControl : 制御フレームのbodyを種類とpayloadに分ける
+func UnmarshalMuxControl(body []byte) (MuxControl, []byte, error) {
+	if len(body) < 1 {
+		return 0, nil, xerrors.Errorf("mux control frame too short")
+	}
+	return MuxControl(body[0]), body[1:], nil
+}
+
+type MuxAttachPayload struct {
+	Handle    byte
+	RoomId    string
+	ClientId  string
+	AuthData  string
+	LastEvSeq int
+}
+
+// UnmarshalMuxAttachPayload unmarshals MuxAttach payload
+func UnmarshalMuxAttachPayload(payload []byte) (*MuxAttachPayload, error) {
+	handle, err := unmarshalMuxHandle(payload)
+	if err != nil {
+		return nil, xerrors.Errorf("Invalid MuxAttach payload (handle): %w", err)
+	}
+	um := MuxAttachPayload{Handle: handle}
+	payload = payload[1+ByteDataSize:]
+
+	d, l, e := UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MuxAttach payload (room id): %w", e)
+	}
+	um.RoomId = d.(string)
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeStr8)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MuxAttach payload (client id): %w", e)
+	}
+	um.ClientId = d.(string)
+	payload = payload[l:]
+
+	d, l, e = UnmarshalAs(payload, TypeStr16)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MuxAttach payload (auth data): %w", e)
+	}
+	um.AuthData = d.(string)
+	payload = payload[l:]
+
+	d, _, e = UnmarshalAs(payload, TypeUInt)
+	if e != nil {
+		return nil, xerrors.Errorf("Invalid MuxAttach payload (last event seq): %w", e)
+	}
+	um.LastEvSeq = d.(int)
+	return &um, nil
+}
+
+// UnmarshalMuxDetachPayload unmarshals MuxDetach payload
+func UnmarshalMuxDetachPayload(payload []byte) (byte, error) {
+	handle, err := unmarshalMuxHandle(payload)
+	if err != nil {
+		return 0, xerrors.Errorf("Invalid MuxDetach payload (handle): %w", err)
+	}
+	return handle, nil
+}
+
+// UnmarshalMuxClosedPayload unmarshals MuxClosed payload into the handle and the close frame payload
+func UnmarshalMuxClosedPayload(payload []byte) (byte, []byte, error) {
+	handle, err := unmarshalMuxHandle(payload)
+	if err != nil {
+		return 0, nil, xerrors.Errorf("Invalid MuxClosed payload (handle): %w", err)
+	}
+	return handle, payload[1+ByteDataSize:], nil
+}
+
+func unmarshalMuxHandle(payload []byte) (byte, error) {
+	d, _, e := UnmarshalAs(payload, TypeByte)
+	if e != nil {
+		return 0, e
+	}
+	h := byte(d.(int))
+	if h == MuxControlHandle {
+		return 0, xerrors.Errorf("handle %v is reserved", h)
+	}
+	return h, nil
+}
@@ -0,0 +1,64 @@
+package binary
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMuxAttach(t *testing.T) {
+	handle, body, err := ParseMuxFrame(MarshalMuxAttach(3, "room", "client", "auth", 10))
+	if err != nil {
+		t.Fatalf("ParseMuxFrame: %v", err)
+	}
+	if handle != MuxControlHandle {
+		t.Fatalf("handle = %v, wants %v", handle, MuxControlHandle)
+	}
+	ctrl, payload, err := UnmarshalMuxControl(body)
+	if err != nil || ctrl != MuxAttach {
+		t.Fatalf("UnmarshalMuxControl = (%v, %v), wants %v", ctrl, err, MuxAttach)
+	}
+	p, err := UnmarshalMuxAttachPayload(payload)
+	if err != nil {
+		t.Fatalf("UnmarshalMuxAttachPayload: %v", err)
+	}
+	want := &MuxAttachPayload{Handle: 3, RoomId: "room", ClientId: "client", AuthData: "auth", LastEvSeq: 10}
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("payload = %+v, wants %+v", p, want)
+	}
+
+	// 制御用のハンドルは割り当てられない
+	_, body, _ = ParseMuxFrame(MarshalMuxAttach(MuxControlHandle, "room", "client", "auth", 10))
+	_, payload, _ = UnmarshalMuxControl(body)
+	if _, err := UnmarshalMuxAttachPayload(payload); err == nil {
+		t.Fatalf("UnmarshalMuxAttachPayload must fail with control handle")
+	}
+}
+
+func TestMuxFrame(t *testing.T) {
+	frame := MuxFrame(5, []byte("body"))
+	handle, body, err := ParseMuxFrame(frame)
+	if err != nil || handle != 5 || string(body) != "body" {
+		t.Fatalf("ParseMuxFrame = (%v, %q, %v), wants (5, %q, nil)", handle, body, err, "body")
+	}
+	if _, _, err := ParseMuxFrame([]byte{5}); err == nil {
+		t.Fatalf("ParseMuxFrame must fail without body")
+	}
+
+	_, body, _ = ParseMuxFrame(MarshalMuxDetach(7))
+	ctrl, payload, _ := UnmarshalMuxControl(body)
+	if h, err := UnmarshalMuxDetachPayload(payload); ctrl != MuxDetach || err != nil || h != 7 {
+		t.Fatalf("detach = (%v, %v, %v), wants (%v, 7, nil)", ctrl, h, err, MuxDetach)
+	}
+}
+
+func TestMuxClosed(t *testing.T) {
+	_, body, _ := ParseMuxFrame(MarshalMuxClosed(4, []byte{0x03, 0xe8, 'b', 'y', 'e'}))
+	ctrl, payload, _ := UnmarshalMuxControl(body)
+	if ctrl != MuxClosed {
+		t.Fatalf("ctrl = %v, wants %v", ctrl, MuxClosed)
+	}
+	h, closeMsg, err := UnmarshalMuxClosedPayload(payload)
+	if err != nil || h != 4 || string(closeMsg) != "\x03\xe8bye" {
+		t.Fatalf("closed = (%v, %q, %v), wants (4, %q, nil)", h, closeMsg, err, "\x03\xe8bye")
+	}
+}
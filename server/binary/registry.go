@@ -0,0 +1,46 @@
+package binary
+
+// RegistryEntry : 数値と名前の組
+type RegistryEntry struct {
+	Code int    `json:"code"`
+	Name string `json:"name"`
+}
+
+// Registry : サーバが送りうるエラーコードと切断理由の一覧.
+// クライアントが全てのケースを扱えているか検証できるように、Lobbyの /errors で返す.
+type Registry struct {
+	// ProtocolVersion : サーバが対応する最新のプロトコルバージョン
+	ProtocolVersion int `json:"protocol_version"`
+	// ErrorCodes : EvTypeErrorとLobbyのレスポンスのErrorCode
+	ErrorCodes []RegistryEntry `json:"error_codes"`
+	// CloseReasons : Close frameのreason textに付与するCloseReason
+	CloseReasons []RegistryEntry `json:"close_reasons"`
+	// CloseCodes : サーバが送るwebsocketのclose code
+	CloseCodes []RegistryEntry `json:"close_codes"`
+}
+
+// closeCodes : サーバが送るwebsocketのclose code (RFC 6455とアプリケーション用のもの)
+var closeCodes = []RegistryEntry{
+	{1000, "NormalClosure"},
+	{1001, "GoingAway"},
+	{1007, "InvalidFramePayloadData"},
+	{1011, "InternalServerErr"},
+	{CloseCodeSlowConsumer, "SlowConsumer"},
+}
+
+// NewRegistry : ErrorCodeとCloseReasonの定数から一覧を作る
+func NewRegistry() *Registry {
+	reg := &Registry{
+		ProtocolVersion: ProtocolVersionLatest,
+		ErrorCodes:      make([]RegistryEntry, 0, errorCodeEnd),
+		CloseReasons:    make([]RegistryEntry, 0, closeReasonEnd),
+		CloseCodes:      closeCodes,
+	}
+	for c := ErrorCode(0); c < errorCodeEnd; c++ {
+		reg.ErrorCodes = append(reg.ErrorCodes, RegistryEntry{int(c), c.String()})
+	}
+	for r := CloseReason(0); r < closeReasonEnd; r++ {
+		reg.CloseReasons = append(reg.CloseReasons, RegistryEntry{int(r), r.String()})
+	}
+	return reg
+}
@@ -0,0 +1,26 @@
+package binary
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewRegistry(t *testing.T) {
+	reg := NewRegistry()
+	if reg.ProtocolVersion != ProtocolVersionLatest {
+		t.Errorf("protocol version = %v, wants %v", reg.ProtocolVersion, ProtocolVersionLatest)
+	}
+	if len(reg.ErrorCodes) != int(errorCodeEnd) || len(reg.CloseReasons) != int(closeReasonEnd) {
+		t.Fatalf("entries = (%v, %v), wants (%v, %v)", len(reg.ErrorCodes), len(reg.CloseReasons), errorCodeEnd, closeReasonEnd)
+	}
+	for _, list := range [][]RegistryEntry{reg.ErrorCodes, reg.CloseReasons} {
+		for i, e := range list {
+			if e.Code != i || e.Name == "" || strings.Contains(e.Name, "(") {
+				t.Errorf("entry[%v] = %v", i, e)
+			}
+		}
+	}
+	if reg.ErrorCodes[ErrorCodeBanned].Name != "Banned" {
+		t.Errorf("ErrorCodeBanned = %v", reg.ErrorCodes[ErrorCodeBanned])
+	}
+}
@@ -11,12 +11,17 @@ type RawObj struct {
 
 // UnmarshalRecursive unmarshal all bytes recursive
 //
-// srcの領域はUnmarshal後に参照されるため書き換えてはいけない
+// srcの領域はUnmarshal後に参照されるため書き換えてはいけない.
+// 入れ子の深さと値の総数、デコードした値のバイト数はSetUnmarshalLimitsの上限で制限する.
 func UnmarshalRecursive(src []byte) (interface{}, error) {
+	return newLimitCounter().unmarshalSeq(src, 1)
+}
+
+func (lc *limitCounter) unmarshalSeq(src []byte, depth int) (interface{}, error) {
 	if len(src) == 0 {
 		return nil, xerrors.Errorf("Unmarshal error: empty")
 	}
-	u, n, err := unmarshalRecursive(src)
+	u, n, err := lc.unmarshalRecursive(src, depth)
 	if err != nil {
 		return nil, err
 	}
@@ -27,7 +32,7 @@ func UnmarshalRecursive(src []byte) (interface{}, error) {
 	r := []interface{}{u}
 	src = src[n:]
 	for len(src) > 0 {
-		u, n, err = unmarshalRecursive(src)
+		u, n, err = lc.unmarshalRecursive(src, depth)
 		if err != nil {
 			return nil, err
 		}
@@ -38,7 +43,10 @@ func UnmarshalRecursive(src []byte) (interface{}, error) {
 	return r, nil
 }
 
-func unmarshalRecursive(src []byte) (interface{}, int, error) {
+func (lc *limitCounter) unmarshalRecursive(src []byte, depth int) (interface{}, int, error) {
+	if err := lc.enter(depth); err != nil {
+		return nil, 0, err
+	}
 	u, n, err := Unmarshal(src)
 	if err != nil {
 		return nil, n, err
@@ -52,7 +60,7 @@ func unmarshalRecursive(src []byte) (interface{}, int, error) {
 		}
 		b := v.Body
 		for len(b) > 0 {
-			v, n1, err := unmarshalRecursive(b)
+			v, n1, err := lc.unmarshalRecursive(b, depth+1)
 			if err != nil {
 				return o, n, err
 			}
@@ -63,7 +71,7 @@ func unmarshalRecursive(src []byte) (interface{}, int, error) {
 	case Dict:
 		o := make(map[string]interface{})
 		for k, v := range v {
-			u, err := UnmarshalRecursive(v)
+			u, err := lc.unmarshalSeq(v, depth+1)
 			if err != nil {
 				return nil, n, err
 			}
@@ -73,7 +81,7 @@ func unmarshalRecursive(src []byte) (interface{}, int, error) {
 	case List:
 		o := make([]interface{}, 0)
 		for i := 0; i < len(v); i++ {
-			u, err := UnmarshalRecursive(v[i])
+			u, err := lc.unmarshalSeq(v[i], depth+1)
 			if err != nil {
 				return nil, n, err
 			}
@@ -81,6 +89,9 @@ func unmarshalRecursive(src []byte) (interface{}, int, error) {
 		}
 		return o, n, nil
 	default:
+		if err := lc.decoded(n); err != nil {
+			return nil, n, err
+		}
 		return v, n, nil
 	}
 }
@@ -0,0 +1,491 @@
+package binary
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// 適合性テストベクタ:
+// 他の言語のクライアントSDKのシリアライザをサーバとバイト単位で比較するためのテストベクタ.
+// 各ベクタは Hex をデシリアライズすると Value になり、Value をシリアライズすると Hex になる.
+// ConformanceVectors は決定的なので、生成したファイル (see cmd/wsnet2-vectors) をSDKのCIでそのまま使える.
+
+// Vector : 1つのテストベクタ
+type Vector struct {
+	Name  string      `json:"name"`
+	Hex   string      `json:"hex"`
+	Value VectorValue `json:"value"`
+}
+
+// VectorValue : 型付きの値. Type は Type の名前 (例: "Str8") で、Value の形式は Type による.
+//   - Null: null, True/False: bool
+//   - 整数と浮動小数点数: number. Char は文字コード
+//   - Str8/Str16: string
+//   - Timestamp: unix epochからのミリ秒 (number), UUID: 文字列表記 (string)
+//   - 配列 (Bools, Ints など): 要素の配列
+//   - List: VectorValue の配列, Dict: キーと VectorValue のobject
+//   - Obj: {"class_id": number, "body": VectorValue の配列}
+type VectorValue struct {
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+// vectorObj : VectorValue の Obj の値
+type vectorObj struct {
+	ClassId byte          `json:"class_id"`
+	Body    []VectorValue `json:"body"`
+}
+
+// ConformanceVectors : 各Typeの境界値を含むテストベクタ
+func ConformanceVectors() []Vector {
+	str300 := strings.Repeat("0123456789", 30)
+	body := append(MarshalInt(1), MarshalStr8("a")...)
+	uuid, _ := ParseUUID("123e4567-e89b-12d3-a456-426614174000")
+
+	vs := []struct {
+		name string
+		data []byte
+	}{
+		{"null", MarshalNull()},
+		{"true", MarshalBool(true)},
+		{"false", MarshalBool(false)},
+		{"sbyte_min", MarshalSByte(math.MinInt8)},
+		{"sbyte_max", MarshalSByte(math.MaxInt8)},
+		{"byte_zero", MarshalByte(0)},
+		{"byte_max", MarshalByte(math.MaxUint8)},
+		{"char_ascii", MarshalChar('A')},
+		{"char_hiragana", MarshalChar('あ')},
+		{"short_min", MarshalShort(math.MinInt16)},
+		{"short_max", MarshalShort(math.MaxInt16)},
+		{"ushort_max", MarshalUShort(math.MaxUint16)},
+		{"int_min", MarshalInt(math.MinInt32)},
+		{"int_minus_one", MarshalInt(-1)},
+		{"int_zero", MarshalInt(0)},
+		{"int_max", MarshalInt(math.MaxInt32)},
+		{"uint_max", MarshalUInt(math.MaxUint32)},
+		{"long_min", MarshalLong(math.MinInt64)},
+		{"long_minus_one", MarshalLong(-1)},
+		{"long_max", MarshalLong(math.MaxInt64)},
+		{"ulong_max", MarshalULong(math.MaxUint64)},
+		{"float_zero", MarshalFloat(0)},
+		{"float_negative", MarshalFloat(-1.5)},
+		{"float_max", MarshalFloat(math.MaxFloat32)},
+		{"double_fraction", MarshalDouble(0.1)},
+		{"double_negative_small", MarshalDouble(-2.5e-300)},
+		{"str8_empty", MarshalStr8("")},
+		{"str8_ascii", MarshalStr8("hello")},
+		{"str8_utf8", MarshalStr8("日本語")},
+		{"str16", MarshalStr16(str300)},
+		{"obj", MarshalObj(&Obj{ClassId: 1, Body: body})},
+		{"list_empty", MarshalList(List{})},
+		{"list", MarshalList(List{MarshalNull(), MarshalInt(1), MarshalStr8("x")})},
+		{"strings", MarshalStrings([]string{"a", str300})},
+		{"dict_empty", MarshalDict(Dict{})},
+		// Dictのキーの順序は規定しないので、バイト列が決まるよう1要素にする
+		{"dict", MarshalDict(Dict{"a": MarshalList(List{MarshalInt(1), MarshalBool(true)})})},
+		{"bools", MarshalBools([]bool{true, false, true, true, false, false, true, false, true})},
+		{"sbytes", MarshalSBytes([]int{math.MinInt8, 0, math.MaxInt8})},
+		{"bytes", MarshalBytes([]int{0, 1, math.MaxUint8})},
+		{"chars", MarshalChars([]rune("aあ"))},
+		{"shorts", MarshalShorts([]int{math.MinInt16, 0, math.MaxInt16})},
+		{"ushorts", MarshalUShorts([]int{0, math.MaxUint16})},
+		{"ints", MarshalInts([]int{math.MinInt32, -1, 0, math.MaxInt32})},
+		{"uints", MarshalUInts([]int{0, math.MaxUint32})},
+		{"longs", MarshalLongs([]int64{math.MinInt64, -1, 0, math.MaxInt64})},
+		{"ulongs", MarshalULongs([]uint64{0, math.MaxUint64})},
+		{"floats", MarshalFloats([]float32{-1.5, 0, math.MaxFloat32})},
+		{"doubles", MarshalDoubles([]float64{-2.5e-300, 0, 0.1})},
+		{"timestamp_epoch", MarshalTimestamp(time.UnixMilli(0))},
+		{"timestamp_before_epoch", MarshalTimestamp(time.UnixMilli(-1))},
+		{"timestamp", MarshalTimestamp(time.UnixMilli(1700000000123))},
+		{"uuid_nil", MarshalUUID(UUID{})},
+		{"uuid", MarshalUUID(uuid)},
+	}
+
+	vectors := make([]Vector, 0, len(vs))
+	for _, v := range vs {
+		val, _, err := newVectorValue(v.data)
+		if err != nil {
+			panic(xerrors.Errorf("vector %v: %w", v.name, err))
+		}
+		vectors = append(vectors, Vector{
+			Name:  v.name,
+			Hex:   hex.EncodeToString(v.data),
+			Value: val,
+		})
+	}
+	return vectors
+}
+
+// LoadVectors : JSONのテストベクタを読み込む. 64bit整数の精度を保つため数値は json.Number にする.
+func LoadVectors(r io.Reader) ([]Vector, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var vs []Vector
+	if err := dec.Decode(&vs); err != nil {
+		return nil, xerrors.Errorf("decode vectors: %w", err)
+	}
+	return vs, nil
+}
+
+// VerifyVector : Hex のデシリアライズ結果が Value と一致し、Value のシリアライズ結果が Hex と一致するか検査する.
+// v は LoadVectors で読み込んだものであること.
+func VerifyVector(v Vector) error {
+	data, err := hex.DecodeString(v.Hex)
+	if err != nil {
+		return xerrors.Errorf("%v: invalid hex: %w", v.Name, err)
+	}
+
+	got, n, err := newVectorValue(data)
+	if err != nil {
+		return xerrors.Errorf("%v: unmarshal: %w", v.Name, err)
+	}
+	if n != len(data) {
+		return xerrors.Errorf("%v: unmarshal: %v bytes left", v.Name, len(data)-n)
+	}
+	gj, err := canonicalJSON(got)
+	if err != nil {
+		return xerrors.Errorf("%v: %w", v.Name, err)
+	}
+	wj, err := canonicalJSON(v.Value)
+	if err != nil {
+		return xerrors.Errorf("%v: %w", v.Name, err)
+	}
+	if gj != wj {
+		return xerrors.Errorf("%v: unmarshaled value = %v, wants %v", v.Name, gj, wj)
+	}
+
+	b, err := marshalVectorValue(v.Value.Type, v.Value.Value)
+	if err != nil {
+		return xerrors.Errorf("%v: marshal: %w", v.Name, err)
+	}
+	if !bytes.Equal(b, data) {
+		return xerrors.Errorf("%v: marshaled bytes = %v, wants %v", v.Name, hex.EncodeToString(b), v.Hex)
+	}
+	return nil
+}
+
+// canonicalJSON : 数値の表記を揃えて比較できるようにしたJSON
+func canonicalJSON(v VectorValue) (string, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return "", xerrors.Errorf("json: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.UseNumber()
+	var a any
+	if err := dec.Decode(&a); err != nil {
+		return "", xerrors.Errorf("json: %w", err)
+	}
+	j, err = json.Marshal(a)
+	if err != nil {
+		return "", xerrors.Errorf("json: %w", err)
+	}
+	return string(j), nil
+}
+
+// newVectorValue : シリアライズされた値をVectorValueにする
+func newVectorValue(src []byte) (VectorValue, int, error) {
+	val, n, err := Unmarshal(src)
+	if err != nil {
+		return VectorValue{}, 0, err
+	}
+	vv := VectorValue{Type: Type(src[0]).String(), Value: val}
+	switch v := val.(type) {
+	case time.Time:
+		vv.Value = v.UnixMilli()
+	case UUID:
+		vv.Value = v.String()
+	case *Obj:
+		body, err := newVectorValues(v.Body)
+		if err != nil {
+			return VectorValue{}, 0, xerrors.Errorf("obj body: %w", err)
+		}
+		vv.Value = vectorObj{ClassId: v.ClassId, Body: body}
+	case List:
+		l := make([]VectorValue, len(v))
+		for i, e := range v {
+			if l[i], _, err = newVectorValue(e); err != nil {
+				return VectorValue{}, 0, xerrors.Errorf("list[%v]: %w", i, err)
+			}
+		}
+		vv.Value = l
+	case Dict:
+		d := make(map[string]VectorValue, len(v))
+		for k, e := range v {
+			if d[k], _, err = newVectorValue(e); err != nil {
+				return VectorValue{}, 0, xerrors.Errorf("dict[%v]: %w", k, err)
+			}
+		}
+		vv.Value = d
+	}
+	return vv, n, nil
+}
+
+func newVectorValues(src []byte) ([]VectorValue, error) {
+	vs := []VectorValue{}
+	for len(src) > 0 {
+		v, n, err := newVectorValue(src)
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+		src = src[n:]
+	}
+	return vs, nil
+}
+
+// marshalVectorValue : LoadVectorsで読み込んだ値をシリアライズする
+func marshalVectorValue(typ string, val any) ([]byte, error) {
+	switch typ {
+	case TypeNull.String():
+		return MarshalNull(), nil
+	case TypeTrue.String(), TypeFalse.String():
+		b, ok := val.(bool)
+		if !ok || (typ == TypeTrue.String()) != b {
+			return nil, xerrors.Errorf("%v: invalid value: %v", typ, val)
+		}
+		return MarshalBool(b), nil
+	case TypeStr8.String(), TypeStr16.String():
+		s, ok := val.(string)
+		if !ok {
+			return nil, xerrors.Errorf("%v: invalid value: %v", typ, val)
+		}
+		if typ == TypeStr8.String() {
+			return MarshalStr8(s), nil
+		}
+		return MarshalStr16(s), nil
+	case TypeUUID.String():
+		s, ok := val.(string)
+		if !ok {
+			return nil, xerrors.Errorf("%v: invalid value: %v", typ, val)
+		}
+		u, err := ParseUUID(s)
+		if err != nil {
+			return nil, xerrors.Errorf("%v: %w", typ, err)
+		}
+		return MarshalUUID(u), nil
+	case TypeObj.String():
+		return marshalVectorObj(val)
+	case TypeList.String():
+		elems, ok := val.([]any)
+		if !ok {
+			return nil, xerrors.Errorf("%v: invalid value: %v", typ, val)
+		}
+		list := make(List, len(elems))
+		for i, e := range elems {
+			b, err := marshalVectorElem(e)
+			if err != nil {
+				return nil, xerrors.Errorf("list[%v]: %w", i, err)
+			}
+			list[i] = b
+		}
+		return MarshalList(list), nil
+	case TypeDict.String():
+		m, ok := val.(map[string]any)
+		if !ok {
+			return nil, xerrors.Errorf("%v: invalid value: %v", typ, val)
+		}
+		dict := make(Dict, len(m))
+		for k, e := range m {
+			b, err := marshalVectorElem(e)
+			if err != nil {
+				return nil, xerrors.Errorf("dict[%v]: %w", k, err)
+			}
+			dict[k] = b
+		}
+		return MarshalDict(dict), nil
+	case TypeBools.String():
+		elems, ok := val.([]any)
+		if !ok {
+			return nil, xerrors.Errorf("%v: invalid value: %v", typ, val)
+		}
+		bs := make([]bool, len(elems))
+		for i, e := range elems {
+			if bs[i], ok = e.(bool); !ok {
+				return nil, xerrors.Errorf("%v[%v]: invalid value: %v", typ, i, e)
+			}
+		}
+		return MarshalBools(bs), nil
+	}
+
+	if nums, ok := val.([]any); ok {
+		return marshalVectorNums(typ, nums)
+	}
+	num, ok := val.(json.Number)
+	if !ok {
+		return nil, xerrors.Errorf("%v: invalid value: %v", typ, val)
+	}
+	var err error
+	switch typ {
+	case TypeSByte.String(), TypeByte.String(), TypeChar.String(), TypeShort.String(),
+		TypeUShort.String(), TypeInt.String(), TypeUInt.String(), TypeLong.String(), TypeTimestamp.String():
+		var n int64
+		if n, err = strconv.ParseInt(string(num), 10, 64); err == nil {
+			switch typ {
+			case TypeSByte.String():
+				return MarshalSByte(int(n)), nil
+			case TypeByte.String():
+				return MarshalByte(int(n)), nil
+			case TypeChar.String():
+				return MarshalChar(rune(n)), nil
+			case TypeShort.String():
+				return MarshalShort(int(n)), nil
+			case TypeUShort.String():
+				return MarshalUShort(int(n)), nil
+			case TypeInt.String():
+				return MarshalInt(int(n)), nil
+			case TypeUInt.String():
+				return MarshalUInt(int(n)), nil
+			case TypeTimestamp.String():
+				return MarshalTimestamp(time.UnixMilli(n)), nil
+			}
+			return MarshalLong(n), nil
+		}
+	case TypeULong.String():
+		var n uint64
+		if n, err = strconv.ParseUint(string(num), 10, 64); err == nil {
+			return MarshalULong(n), nil
+		}
+	case TypeFloat.String():
+		var f float64
+		if f, err = strconv.ParseFloat(string(num), 32); err == nil {
+			return MarshalFloat(float32(f)), nil
+		}
+	case TypeDouble.String():
+		var f float64
+		if f, err = strconv.ParseFloat(string(num), 64); err == nil {
+			return MarshalDouble(f), nil
+		}
+	default:
+		return nil, xerrors.Errorf("unknown type: %v", typ)
+	}
+	return nil, xerrors.Errorf("%v: invalid value: %v: %w", typ, num, err)
+}
+
+// marshalVectorElem : List/Dict/Objの要素 ({"type", "value"} のobject) をシリアライズする
+func marshalVectorElem(e any) ([]byte, error) {
+	m, ok := e.(map[string]any)
+	if !ok {
+		return nil, xerrors.Errorf("invalid element: %v", e)
+	}
+	typ, ok := m["type"].(string)
+	if !ok {
+		return nil, xerrors.Errorf("invalid element type: %v", m["type"])
+	}
+	return marshalVectorValue(typ, m["value"])
+}
+
+func marshalVectorObj(val any) ([]byte, error) {
+	m, ok := val.(map[string]any)
+	if !ok {
+		return nil, xerrors.Errorf("Obj: invalid value: %v", val)
+	}
+	num, ok := m["class_id"].(json.Number)
+	if !ok {
+		return nil, xerrors.Errorf("Obj: invalid class_id: %v", m["class_id"])
+	}
+	classId, err := strconv.ParseUint(string(num), 10, 8)
+	if err != nil {
+		return nil, xerrors.Errorf("Obj: invalid class_id: %w", err)
+	}
+	elems, ok := m["body"].([]any)
+	if !ok {
+		return nil, xerrors.Errorf("Obj: invalid body: %v", m["body"])
+	}
+	var body []byte
+	for i, e := range elems {
+		b, err := marshalVectorElem(e)
+		if err != nil {
+			return nil, xerrors.Errorf("obj body[%v]: %w", i, err)
+		}
+		body = append(body, b...)
+	}
+	return MarshalObj(&Obj{ClassId: byte(classId), Body: body}), nil
+}
+
+// marshalVectorNums : 数値の配列をシリアライズする
+func marshalVectorNums(typ string, elems []any) ([]byte, error) {
+	strs := make([]string, len(elems))
+	for i, e := range elems {
+		num, ok := e.(json.Number)
+		if !ok {
+			return nil, xerrors.Errorf("%v[%v]: invalid value: %v", typ, i, e)
+		}
+		strs[i] = string(num)
+	}
+
+	var err error
+	switch typ {
+	case TypeULongs.String():
+		vals := make([]uint64, len(strs))
+		for i, s := range strs {
+			if vals[i], err = strconv.ParseUint(s, 10, 64); err != nil {
+				return nil, xerrors.Errorf("%v[%v]: %w", typ, i, err)
+			}
+		}
+		return MarshalULongs(vals), nil
+	case TypeFloats.String():
+		vals := make([]float32, len(strs))
+		for i, s := range strs {
+			f, err := strconv.ParseFloat(s, 32)
+			if err != nil {
+				return nil, xerrors.Errorf("%v[%v]: %w", typ, i, err)
+			}
+			vals[i] = float32(f)
+		}
+		return MarshalFloats(vals), nil
+	case TypeDoubles.String():
+		vals := make([]float64, len(strs))
+		for i, s := range strs {
+			if vals[i], err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, xerrors.Errorf("%v[%v]: %w", typ, i, err)
+			}
+		}
+		return MarshalDoubles(vals), nil
+	}
+
+	ints := make([]int64, len(strs))
+	for i, s := range strs {
+		if ints[i], err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, xerrors.Errorf("%v[%v]: %w", typ, i, err)
+		}
+	}
+	switch typ {
+	case TypeLongs.String():
+		return MarshalLongs(ints), nil
+	case TypeChars.String():
+		rs := make([]rune, len(ints))
+		for i, n := range ints {
+			rs[i] = rune(n)
+		}
+		return MarshalChars(rs), nil
+	}
+	vals := make([]int, len(ints))
+	for i, n := range ints {
+		vals[i] = int(n)
+	}
+	switch typ {
+	case TypeSBytes.String():
+		return MarshalSBytes(vals), nil
+	case TypeBytes.String():
+		return MarshalBytes(vals), nil
+	case TypeShorts.String():
+		return MarshalShorts(vals), nil
+	case TypeUShorts.String():
+		return MarshalUShorts(vals), nil
+	case TypeInts.String():
+		return MarshalInts(vals), nil
+	case TypeUInts.String():
+		return MarshalUInts(vals), nil
+	}
+	return nil, xerrors.Errorf("unknown type: %v", typ)
+}
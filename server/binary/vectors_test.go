@@ -0,0 +1,102 @@
+package binary
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func loadConformanceVectors(t *testing.T) []Vector {
+	t.Helper()
+	j, err := json.Marshal(ConformanceVectors())
+	if err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	vs, err := LoadVectors(bytes.NewReader(j))
+	if err != nil {
+		t.Fatalf("LoadVectors: %v", err)
+	}
+	return vs
+}
+
+func TestConformanceVectors(t *testing.T) {
+	vs := loadConformanceVectors(t)
+	names := make(map[string]bool)
+	for _, v := range vs {
+		if names[v.Name] {
+			t.Errorf("duplicated name: %v", v.Name)
+		}
+		names[v.Name] = true
+		if err := VerifyVector(v); err != nil {
+			t.Errorf("VerifyVector: %v", err)
+		}
+	}
+
+	// 生成のたびに同じバイト列になること
+	a, _ := json.Marshal(ConformanceVectors())
+	b, _ := json.Marshal(ConformanceVectors())
+	if !bytes.Equal(a, b) {
+		t.Fatalf("ConformanceVectors is not deterministic")
+	}
+}
+
+func TestConformanceVectorsHex(t *testing.T) {
+	tests := map[string]string{
+		"int_max":        "08ffffffff",
+		"long_minus_one": "0a7fffffffffffffff",
+		"float_negative": "0c403fffff",
+		"str8_ascii":     "0f0568656c6c6f",
+		"list":           "12030001000005088000000100030f0178",
+		"timestamp":      "218000018bcfe5687b",
+		"uuid":           "22123e4567e89b12d3a456426614174000",
+	}
+	for _, v := range ConformanceVectors() {
+		if want, ok := tests[v.Name]; ok {
+			if v.Hex != want {
+				t.Errorf("%v: hex = %v, wants %v", v.Name, v.Hex, want)
+			}
+			delete(tests, v.Name)
+		}
+	}
+	if len(tests) > 0 {
+		t.Fatalf("vectors not found: %v", tests)
+	}
+}
+
+func TestVerifyVectorMismatch(t *testing.T) {
+	tests := map[string]string{
+		"hex":   `[{"name":"x","hex":"08ffffffff","value":{"type":"Int","value":1}}]`,
+		"type":  `[{"name":"x","hex":"08ffffffff","value":{"type":"UInt","value":2147483647}}]`,
+		"left":  `[{"name":"x","hex":"0000","value":{"type":"Null","value":null}}]`,
+		"elem":  `[{"name":"x","hex":"120100010001","value":{"type":"List","value":[{"type":"True","value":true}]}}]`,
+		"class": `[{"name":"x","hex":"1102000000","value":{"type":"Obj","value":{"class_id":1,"body":[]}}}]`,
+	}
+	for name, j := range tests {
+		t.Run(name, func(t *testing.T) {
+			vs, err := LoadVectors(strings.NewReader(j))
+			if err != nil {
+				t.Fatalf("LoadVectors: %v", err)
+			}
+			if err := VerifyVector(vs[0]); err == nil {
+				t.Fatalf("VerifyVector must fail")
+			}
+		})
+	}
+}
+
+// TestVectorsFile : コミットされた vectors.json (SDKのテストが読み込む) が最新か. 更新は make vectors
+func TestVectorsFile(t *testing.T) {
+	file, err := os.ReadFile("vectors.json")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	data, err := json.MarshalIndent(ConformanceVectors(), "", "  ")
+	if err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	if !bytes.Equal(file, append(data, '\n')) {
+		t.Fatalf("vectors.json is out of date: run make vectors")
+	}
+}
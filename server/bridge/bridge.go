@@ -0,0 +1,194 @@
+// Package bridge : 部屋のイベントを外部のpub/sub (NATS) に配信し、外部から部屋へのメッセージを受け付ける
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+	"wsnet2/config"
+	"wsnet2/log"
+	"wsnet2/metrics"
+)
+
+const (
+	retryIntervalMin = time.Second
+	retryIntervalMax = time.Minute
+)
+
+// backoff : 再接続の間隔. 接続できたらresetで最小に戻す
+type backoff struct {
+	interval time.Duration
+}
+
+// next : 次の再接続までの間隔. 失敗が続くたびに倍にする
+func (b *backoff) next() time.Duration {
+	if b.interval == 0 {
+		b.interval = retryIntervalMin
+	} else {
+		b.interval *= 2
+		if b.interval > retryIntervalMax {
+			b.interval = retryIntervalMax
+		}
+	}
+	return b.interval
+}
+
+func (b *backoff) reset() {
+	b.interval = 0
+}
+
+// Command : command subjectで受け付ける部屋へのメッセージ (JSON).
+// 部屋にはEvTypeServerMessageとして届く.
+type Command struct {
+	AppId    string `json:"app_id"`
+	RoomId   string `json:"room_id,omitempty"`
+	ClientId string `json:"client_id,omitempty"`
+	// Data : マーシャル済みの値 (JSONではbase64)
+	Data []byte `json:"data"`
+}
+
+// CommandHandler : Commandを部屋に送る
+type CommandHandler func(cmd *Command)
+
+type event struct {
+	subject string
+	payload []byte
+}
+
+// Bridge : 部屋のイベントをNATSに配信する
+type Bridge struct {
+	conf *config.BridgeConf
+	name string
+
+	events map[string]bool
+	apps   map[string]bool
+
+	queue chan event
+}
+
+// New : confからBridgeを作る. NatsURLが空ならnilを返す
+func New(conf *config.BridgeConf, name string) *Bridge {
+	if conf.NatsURL == "" {
+		return nil
+	}
+	b := &Bridge{
+		conf:  conf,
+		name:  name,
+		queue: make(chan event, conf.QueueSize),
+	}
+	if len(conf.Events) > 0 {
+		b.events = make(map[string]bool, len(conf.Events))
+		for _, e := range conf.Events {
+			b.events[e] = true
+		}
+	}
+	if len(conf.Apps) > 0 {
+		b.apps = make(map[string]bool, len(conf.Apps))
+		for _, a := range conf.Apps {
+			b.apps[a] = true
+		}
+	}
+	return b
+}
+
+// eventName : "EvTypeJoined" -> "Joined"
+func eventName(t binary.EvType) string {
+	return strings.TrimPrefix(t.String(), "EvType")
+}
+
+// Publish : 部屋のイベントを配信キューに入れる. キューが溢れていたら捨てる.
+// 部屋のgoroutineから呼ばれるのでブロックしない.
+func (b *Bridge) Publish(appId, roomId string, ev binary.Event) {
+	if b.apps != nil && !b.apps[appId] {
+		return
+	}
+	name := eventName(ev.Type())
+	if b.events != nil && !b.events[name] {
+		return
+	}
+	e := event{
+		subject: fmt.Sprintf("%s.%s.%s.%s", b.conf.Prefix, appId, roomId, name),
+		payload: ev.Payload(),
+	}
+	select {
+	case b.queue <- e:
+	default:
+		metrics.BridgeDropped.Add(1)
+	}
+}
+
+// Serve : NATSに接続してキューのイベントを配信し、command subjectのメッセージをhandlerに渡す.
+// 接続が切れたら再接続する. ctxが終了するまで返らない.
+func (b *Bridge) Serve(ctx context.Context, handler CommandHandler) error {
+	var bo backoff
+	for {
+		err := b.serve(ctx, handler, bo.reset)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		interval := bo.next()
+		log.Errorf("bridge: %+v (retry after %v)", err, interval)
+
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// serve : 接続できたらconnectedを呼び、接続が切れるまで配信する
+func (b *Bridge) serve(ctx context.Context, handler CommandHandler, connected func()) error {
+	conn, err := dialNats(ctx, b.conf.NatsURL, b.name)
+	if err != nil {
+		return xerrors.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+	log.Infof("bridge: connected to %v", b.conf.NatsURL)
+	connected()
+
+	if b.conf.CommandSubject != "" {
+		if err := conn.subscribe(b.conf.CommandSubject, "1"); err != nil {
+			return xerrors.Errorf("subscribe: %w", err)
+		}
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- conn.readLoop(func(subject string, data []byte) {
+			var cmd Command
+			if err := json.Unmarshal(data, &cmd); err != nil {
+				log.Infof("bridge: invalid command: %v: %v", subject, err)
+				return
+			}
+			handler(&cmd)
+		})
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-readErr:
+			return xerrors.Errorf("read: %w", err)
+		case e := <-b.queue:
+			if err := conn.publish(e.subject, e.payload); err != nil {
+				return xerrors.Errorf("publish: %w", err)
+			}
+			// キューが空になるまでまとめて送る
+			if len(b.queue) == 0 {
+				if err := conn.flush(); err != nil {
+					return xerrors.Errorf("flush: %w", err)
+				}
+			}
+		}
+	}
+}
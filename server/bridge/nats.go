@@ -0,0 +1,206 @@
+package bridge
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2"
+)
+
+const (
+	natsDefaultPort = "4222"
+	natsDialTimeout = 5 * time.Second
+	natsMaxLine     = 4096
+)
+
+// natsConn : NATSのクライアントプロトコルの最小限の実装.
+// PUB, SUB とサーバからの MSG, PING だけを扱う.
+// see: https://docs.nats.io/reference/reference-protocols/nats-protocol
+type natsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	muw sync.Mutex
+	w   *bufio.Writer
+}
+
+// dialNats : NATSサーバに接続してCONNECTを送る.
+// URLのuserinfoはuser/passまたはtokenとして送る.
+func dialNats(ctx context.Context, rawurl, name string) (*natsConn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, xerrors.Errorf("parse url: %w", err)
+	}
+	if u.Scheme != "nats" {
+		return nil, xerrors.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), natsDefaultPort)
+	}
+
+	d := net.Dialer{Timeout: natsDialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, xerrors.Errorf("dial: %w", err)
+	}
+	c, err := newNatsConn(conn, u.User, name)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newNatsConn(conn net.Conn, user *url.Userinfo, name string) (*natsConn, error) {
+	c := &natsConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+
+	conn.SetDeadline(time.Now().Add(natsDialTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	line, err := c.readLine()
+	if err != nil {
+		return nil, xerrors.Errorf("read INFO: %w", err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return nil, xerrors.Errorf("unexpected message: %q", line)
+	}
+
+	opts := map[string]any{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     name,
+		"lang":     "go",
+		"version":  wsnet2.Version,
+		"protocol": 0,
+	}
+	if user != nil {
+		if pass, ok := user.Password(); ok {
+			opts["user"] = user.Username()
+			opts["pass"] = pass
+		} else {
+			opts["auth_token"] = user.Username()
+		}
+	}
+	j, err := json.Marshal(opts)
+	if err != nil {
+		return nil, xerrors.Errorf("marshal CONNECT: %w", err)
+	}
+	fmt.Fprintf(c.w, "CONNECT %s\r\nPING\r\n", j)
+	if err := c.w.Flush(); err != nil {
+		return nil, xerrors.Errorf("write CONNECT: %w", err)
+	}
+
+	// 認証に失敗したときは -ERR が返る
+	line, err = c.readLine()
+	if err != nil {
+		return nil, xerrors.Errorf("read PONG: %w", err)
+	}
+	if line != "PONG" {
+		return nil, xerrors.Errorf("connect failed: %q", line)
+	}
+	return c, nil
+}
+
+func (c *natsConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *natsConn) readLine() (string, error) {
+	var line []byte
+	for {
+		l, more, err := c.r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, l...)
+		if len(line) > natsMaxLine {
+			return "", xerrors.Errorf("line too long: %v bytes", len(line))
+		}
+		if !more {
+			return string(line), nil
+		}
+	}
+}
+
+// publish : subjectにdataを送る. 送信はバッファされるのでflushを呼ぶこと
+func (c *natsConn) publish(subject string, data []byte) error {
+	c.muw.Lock()
+	defer c.muw.Unlock()
+	fmt.Fprintf(c.w, "PUB %s %d\r\n", subject, len(data))
+	c.w.Write(data)
+	_, err := c.w.WriteString("\r\n")
+	return err
+}
+
+func (c *natsConn) subscribe(subject, sid string) error {
+	c.muw.Lock()
+	defer c.muw.Unlock()
+	fmt.Fprintf(c.w, "SUB %s %s\r\n", subject, sid)
+	return c.w.Flush()
+}
+
+func (c *natsConn) flush() error {
+	c.muw.Lock()
+	defer c.muw.Unlock()
+	return c.w.Flush()
+}
+
+func (c *natsConn) pong() error {
+	c.muw.Lock()
+	defer c.muw.Unlock()
+	c.w.WriteString("PONG\r\n")
+	return c.w.Flush()
+}
+
+// readLoop : サーバからのメッセージを読み、MSGをhandlerに渡す. 接続が切れるかエラーを受け取るまで返らない
+func (c *natsConn) readLoop(handler func(subject string, data []byte)) error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		op, args, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(op) {
+		case "MSG":
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			f := strings.Fields(args)
+			if len(f) < 3 {
+				return xerrors.Errorf("invalid MSG: %q", line)
+			}
+			n, err := strconv.Atoi(f[len(f)-1])
+			if err != nil || n < 0 {
+				return xerrors.Errorf("invalid MSG size: %q", line)
+			}
+			buf := make([]byte, n+2)
+			if _, err := io.ReadFull(c.r, buf); err != nil {
+				return err
+			}
+			handler(f[0], buf[:n])
+		case "PING":
+			if err := c.pong(); err != nil {
+				return err
+			}
+		case "-ERR":
+			return xerrors.Errorf("nats error: %v", args)
+		case "PONG", "+OK", "INFO":
+		default:
+			return xerrors.Errorf("unknown message: %q", line)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package bridge
+
+import (
+	"bufio"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fakeNatsServer : INFOを送り、CONNECTとPINGにPONGを返す
+func fakeNatsServer(t *testing.T, conn net.Conn) *bufio.Reader {
+	t.Helper()
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+	line, _ := r.ReadString('\n')
+	if !strings.HasPrefix(line, "CONNECT ") || !strings.Contains(line, `"user":"u"`) || !strings.Contains(line, `"pass":"p"`) {
+		t.Errorf("unexpected CONNECT: %q", line)
+	}
+	line, _ = r.ReadString('\n')
+	if line != "PING\r\n" {
+		t.Errorf("unexpected PING: %q", line)
+	}
+	conn.Write([]byte("PONG\r\n"))
+	return r
+}
+
+func TestNatsConn(t *testing.T) {
+	cli, svr := net.Pipe()
+	defer cli.Close()
+	defer svr.Close()
+
+	done := make(chan *bufio.Reader)
+	go func() { done <- fakeNatsServer(t, svr) }()
+
+	c, err := newNatsConn(cli, url.UserPassword("u", "p"), "test")
+	if err != nil {
+		t.Fatalf("newNatsConn: %v", err)
+	}
+	r := <-done
+
+	go func() {
+		c.publish("wsnet2.app.room.Joined", []byte("hello"))
+		c.flush()
+	}()
+	for _, want := range []string{"PUB wsnet2.app.room.Joined 5\r\n", "hello\r\n"} {
+		if line, _ := r.ReadString('\n'); line != want {
+			t.Fatalf("published %q, wants %q", line, want)
+		}
+	}
+
+	type msg struct{ subject, data string }
+	msgs := make(chan msg, 2)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- c.readLoop(func(subject string, data []byte) {
+			msgs <- msg{subject, string(data)}
+		})
+	}()
+
+	svr.Write([]byte("MSG wsnet2.cmd 1 11\r\n{\"a\":\"b c\"}\r\n"))
+	if m := <-msgs; m.subject != "wsnet2.cmd" || m.data != `{"a":"b c"}` {
+		t.Fatalf("msg = %+v", m)
+	}
+	svr.Write([]byte("MSG wsnet2.cmd 1 reply.to 0\r\n\r\n"))
+	if m := <-msgs; m.subject != "wsnet2.cmd" || m.data != "" {
+		t.Fatalf("msg with reply-to = %+v", m)
+	}
+
+	svr.Write([]byte("PING\r\n"))
+	if line, _ := r.ReadString('\n'); line != "PONG\r\n" {
+		t.Fatalf("response to PING = %q", line)
+	}
+
+	svr.Write([]byte("-ERR 'Stale Connection'\r\n"))
+	if err := <-readErr; err == nil || !strings.Contains(err.Error(), "Stale Connection") {
+		t.Fatalf("readLoop error = %v", err)
+	}
+}
+
+func TestNatsConnAuthError(t *testing.T) {
+	cli, svr := net.Pipe()
+	defer cli.Close()
+	defer svr.Close()
+
+	go func() {
+		r := bufio.NewReader(svr)
+		svr.Write([]byte("INFO {}\r\n"))
+		r.ReadString('\n')
+		r.ReadString('\n')
+		svr.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+	}()
+
+	if _, err := newNatsConn(cli, url.UserPassword("u", "p"), "test"); err == nil {
+		t.Fatalf("newNatsConn must fail on auth error")
+	}
+}
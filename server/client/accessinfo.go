@@ -14,6 +14,12 @@ type AccessInfo struct {
 	MACKey    string
 	Bearer    string
 	EncMACKey string
+
+	// Mux : nilでなければ、同じgameサーバの部屋への接続をまとめる (see: DialMux)
+	Mux *Mux
+
+	// Handshake : trueならヘッダの代わりにUpgrade後の先頭フレームで認証する (see: binary/handshake.go)
+	Handshake bool
 }
 
 // GenAccessinfo : AccessInfoを生成
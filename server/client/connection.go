@@ -2,8 +2,6 @@ package client
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha1"
 	"errors"
 	"hash"
 	"net/http"
@@ -55,21 +53,39 @@ func unrecoverable(err error) unrecoverableError {
 type Connection struct {
 	appid  string
 	userid string
+	roomid string
 	url    string
 	bearer string
 
+	// mux : nilでなければwebsocketの代わりにMuxのハンドルで接続する
+	mux *Mux
+
+	// handshake : ヘッダの代わりに先頭フレームで認証する
+	handshake bool
+
 	deadline atomic.Uint32
 
+	// pingInterval : サーバがEvTypePeerReadyで指定したPingの間隔. 0ならdeadlineの1/3
+	pingInterval atomic.Int64
+
+	// noreconnect : 再接続しないcloseコード (JoinedRoomRes.NoReconnectCloseCodes)
+	noreconnect []int
+
 	mumsg  sync.Mutex
 	msgseq int
 	msgbuf *common.RingBuf[marshaledMsg]
 	hmac   hash.Hash
 
-	lastev int
-	evch   chan binary.Event
+	muev     sync.Mutex // websocketとInjectの両方から受け取るため
+	lastev   int
+	evch     chan binary.Event
+	evclosed bool
 
 	sysmsg chan binary.Msg
 
+	connected atomic.Bool
+	statech   chan bool
+
 	done chan msgerr
 }
 
@@ -89,6 +105,30 @@ func (r *Connection) Send(typ binary.MsgType, payload []byte) error {
 	return nil
 }
 
+// ConfirmRejoin : 同じクライアントIDの別の接続からの入室の確認 (EvTypeRejoinRequested) に応答する.
+// acceptなら新しい接続に置き換えられ、EvTypeDisplacedを受け取った後に切断される.
+func (r *Connection) ConfirmRejoin(accept bool) error {
+	return r.Send(binary.MsgTypeConfirmRejoin, binary.MarshalConfirmRejoinPayload(accept))
+}
+
+// SendEncrypted : 暗号化済みのenvelopeをtargets (空なら自分以外の全Player) に送る.
+// サーバはenvelopeを解釈せずに中継する. 受け取ったPlayerにはEvTypeEncryptedで届く.
+func (r *Connection) SendEncrypted(targets []string, envelope []byte) error {
+	return r.Send(binary.MsgTypeEncrypted, binary.MarshalEncryptedPayload(targets, envelope))
+}
+
+// SendKeyExchange : 鍵交換のデータをtargets (空なら自分以外の全Player) に送る.
+// 受け取ったPlayerにはEvTypeKeyExchangeで届く.
+func (r *Connection) SendKeyExchange(targets []string, key []byte) error {
+	return r.Send(binary.MsgTypeKeyExchange, binary.MarshalEncryptedPayload(targets, key))
+}
+
+// SendEventAck : 受信済みの最後のイベントをサーバに通知する.
+// サーバは配信の遅延と未受信のイベント数を計測する. 古いサーバは切断するので、対応しているサーバにだけ送ること.
+func (r *Connection) SendEventAck() error {
+	return r.SendSystemMsg(binary.NewMsgEventAck(r.lastEventSeq()))
+}
+
 // SendSystemMsg : SystemMsg (NonRegularMsg) を送信
 func (r *Connection) SendSystemMsg(msg binary.Msg) error {
 	if _, ok := msg.(binary.RegularMsg); ok {
@@ -107,6 +147,30 @@ func (c *Connection) Events() <-chan binary.Event {
 	return c.evch
 }
 
+// StateChanged : 接続状態 (true: 接続中) が変わると通知されるチャネル
+// 読み出されていない通知は最新の状態で上書きされる.
+func (c *Connection) StateChanged() <-chan bool {
+	return c.statech
+}
+
+// Connected : websocketが接続済みか
+func (c *Connection) Connected() bool {
+	return c.connected.Load()
+}
+
+// setConnected : 接続状態を更新し、変化があればstatechに通知する
+// 接続ごとに true, false の順で呼ばれるので書き込みが競合することはない.
+func (c *Connection) setConnected(connected bool) {
+	if !c.connected.CompareAndSwap(!connected, connected) {
+		return
+	}
+	select {
+	case <-c.statech:
+	default:
+	}
+	c.statech <- connected
+}
+
 // Wait : 接続終了(退室)を待つ
 func (c *Connection) Wait(ctx context.Context) (string, error) {
 	select {
@@ -125,24 +189,48 @@ func newConn(ctx context.Context, accinfo *AccessInfo, joined *pb.JoinedRoomRes,
 		return nil, xerrors.Errorf("bearer: %w", err)
 	}
 
-	mac := hmac.New(sha1.New, []byte(accinfo.MACKey))
+	// 古いサーバはアルゴリズムを返さない
+	alg := joined.MacAlgorithm
+	if alg == "" {
+		alg = auth.MACAlgorithmSHA1
+	}
+	mac, err := auth.NewMsgMAC(alg, accinfo.MACKey)
+	if err != nil {
+		return nil, xerrors.Errorf("mac: %w", err)
+	}
 
 	conn := &Connection{
 		appid:  accinfo.AppId,
 		userid: accinfo.UserId,
+		roomid: joined.RoomInfo.Id,
 		url:    joined.Url,
 		bearer: "Bearer " + bearer,
 
+		handshake: accinfo.Handshake,
+
 		msgbuf: common.NewRingBuf[marshaledMsg](32),
 		hmac:   mac,
 
-		evch:   make(chan binary.Event, 32),
-		sysmsg: make(chan binary.Msg),
-		done:   make(chan msgerr, 1),
+		evch:    make(chan binary.Event, 32),
+		sysmsg:  make(chan binary.Msg),
+		statech: make(chan bool, 1),
+		done:    make(chan msgerr, 1),
 	}
 
 	conn.deadline.Store(joined.Deadline)
 
+	if accinfo.Mux.serves(joined.Url) {
+		conn.mux = accinfo.Mux
+	}
+
+	conn.noreconnect = []int{websocket.CloseNormalClosure, websocket.CloseGoingAway}
+	if len(joined.NoReconnectCloseCodes) > 0 {
+		conn.noreconnect = make([]int, len(joined.NoReconnectCloseCodes))
+		for i, c := range joined.NoReconnectCloseCodes {
+			conn.noreconnect[i] = int(c)
+		}
+	}
+
 	if warn == nil {
 		warn = func(error) {}
 	}
@@ -150,7 +238,10 @@ func newConn(ctx context.Context, accinfo *AccessInfo, joined *pb.JoinedRoomRes,
 	go func() {
 		msg, err := conn.connect(ctx, warn)
 		conn.done <- msgerr{msg, err}
+		conn.muev.Lock()
+		conn.evclosed = true
 		close(conn.evch)
+		conn.muev.Unlock()
 	}()
 
 	return conn, nil
@@ -174,13 +265,7 @@ func (conn *Connection) connect(ctx context.Context, warn func(error)) (string,
 
 		interval := time.NewTimer(reconnectInterval)
 
-		hdr := http.Header{}
-		hdr.Add("Wsnet2-App", conn.appid)
-		hdr.Add("Wsnet2-User", conn.userid)
-		hdr.Add("Wsnet2-LastEventSeq", strconv.Itoa(conn.lastev))
-		hdr.Add("Authorization", conn.bearer)
-
-		ws, res, err := dialer.DialContext(ctx, conn.url, hdr)
+		ws, res, err := conn.dial(ctx)
 		if err != nil {
 			if res != nil && res.StatusCode >= 400 && res.StatusCode < 500 {
 				return "websocket dial failed", xerrors.Errorf("dial: %w", err)
@@ -202,6 +287,7 @@ func (conn *Connection) connect(ctx context.Context, warn func(error)) (string,
 		go func() {
 			done <- conn.receiver(conctx, ws, func(lastmsgseq int) {
 				retrylimit = nil
+				conn.setConnected(true)
 				var mu sync.Mutex
 				wg.Add(3)
 				go func() {
@@ -222,9 +308,19 @@ func (conn *Connection) connect(ctx context.Context, warn func(error)) (string,
 
 		err = <-done
 		cancel()
+		ws.Close()
 		wg.Wait()
-
-		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		conn.setConnected(false)
+
+		if ce, ok := err.(*websocket.CloseError); ok {
+			switch reason, _ := binary.ParseCloseText(ce.Text); {
+			case reason == binary.CloseReasonAttachFailed && conn.mux != nil:
+				return "mux attach failed", xerrors.Errorf("mux attach: %w", err)
+			case reason == binary.CloseReasonHandshakeFailed && conn.handshake:
+				return "handshake failed", xerrors.Errorf("handshake: %w", err)
+			}
+		}
+		if websocket.IsCloseError(err, conn.noreconnect...) {
 			return err.(*websocket.CloseError).Text, nil
 		}
 		if ue := unrecoverable(nil); errors.As(err, &ue) {
@@ -242,7 +338,51 @@ func (conn *Connection) connect(ctx context.Context, warn func(error)) (string,
 	}
 }
 
-func (conn *Connection) receiver(ctx context.Context, ws *websocket.Conn, startsender func(int)) error {
+// dial : websocketを接続する. Muxを使うときは空いているハンドルに部屋を割り当てる
+func (conn *Connection) dial(ctx context.Context) (wsConn, *http.Response, error) {
+	if conn.mux != nil {
+		ch, err := conn.mux.attach(ctx, conn.roomid, conn.userid, conn.bearer[len("Bearer "):], conn.lastEventSeq())
+		if err != nil {
+			return nil, nil, err
+		}
+		return ch, nil, nil
+	}
+
+	if conn.handshake {
+		return conn.dialHandshake(ctx)
+	}
+
+	hdr := http.Header{}
+	hdr.Add("Wsnet2-App", conn.appid)
+	hdr.Add("Wsnet2-User", conn.userid)
+	hdr.Add("Wsnet2-LastEventSeq", strconv.Itoa(conn.lastEventSeq()))
+	hdr.Add(binary.ProtocolVersionHeader, strconv.Itoa(binary.ProtocolVersionDeadlineChanged))
+	hdr.Add("Authorization", conn.bearer)
+
+	ws, res, err := dialer.DialContext(ctx, conn.url, hdr)
+	if err != nil {
+		return nil, res, err
+	}
+	return ws, res, nil
+}
+
+// dialHandshake : ヘッダを付けずに接続し、先頭フレームで認証情報を送る
+func (conn *Connection) dialHandshake(ctx context.Context) (wsConn, *http.Response, error) {
+	ws, res, err := dialer.DialContext(ctx, conn.url, nil)
+	if err != nil {
+		return nil, res, err
+	}
+	hs := binary.MarshalHandshake(conn.appid, conn.userid, conn.lastEventSeq(),
+		conn.bearer[len("Bearer "):], binary.ProtocolVersionDeadlineChanged)
+	ws.SetWriteDeadline(time.Now().Add(time.Second))
+	if err := ws.WriteMessage(websocket.BinaryMessage, hs); err != nil {
+		ws.Close()
+		return nil, nil, xerrors.Errorf("write handshake: %w", err)
+	}
+	return ws, nil, nil
+}
+
+func (conn *Connection) receiver(ctx context.Context, ws wsConn, startsender func(int)) error {
 	for {
 		select {
 		case <-ctx.Done():
@@ -256,52 +396,136 @@ func (conn *Connection) receiver(ctx context.Context, ws *websocket.Conn, starts
 			return err // websocket.IsCloseError()がwrapを考慮してくれないのでこのまま返す
 		}
 
-		ev, seq, err := binary.UnmarshalEvent(data)
+		if len(data) > 0 && binary.EvType(data[0]) == binary.EvTypeBatch {
+			evs, err := binary.UnmarshalBatchPayload(data[1:])
+			if err != nil {
+				return xerrors.Errorf("receiver unmarshal batch: %w", err)
+			}
+			for _, d := range evs {
+				if err := conn.receiveEvent(ctx, d, startsender); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := conn.receiveEvent(ctx, data, startsender); err != nil {
+			return err
+		}
+	}
+}
+
+func (conn *Connection) receiveEvent(ctx context.Context, data []byte, startsender func(int)) error {
+	ev, seq, err := binary.UnmarshalEvent(data)
+	if err != nil {
+		return xerrors.Errorf("receiver unmarshal: %w", err)
+	}
+
+	conn.muev.Lock()
+	defer conn.muev.Unlock()
+	lastev := conn.lastev
+	if _, ok := ev.(*binary.RegularEvent); ok {
+		if seq <= lastev {
+			// Injectで受け取り済み
+			return nil
+		}
+		lastev++
+		if seq != lastev {
+			return xerrors.Errorf("invalid event sequence num: %v wants %v", seq, lastev)
+		}
+	}
+	return conn.dispatchEvent(ctx, ev, lastev, startsender)
+}
+
+// Inject : websocket以外の経路 (see: wsnet2/relay) で受け取ったRegularEventを流す.
+// 次のsequence numberのイベントのみ受け付け、それ以外は無視してfalseを返す.
+// 無視したイベントはwebsocketの再接続時に再送される.
+func (conn *Connection) Inject(ctx context.Context, data []byte) (bool, error) {
+	ev, seq, err := binary.UnmarshalEvent(data)
+	if err != nil {
+		return false, xerrors.Errorf("inject unmarshal: %w", err)
+	}
+	if _, ok := ev.(*binary.RegularEvent); !ok {
+		return false, xerrors.Errorf("inject non-regular event: %v", ev.Type())
+	}
+
+	conn.muev.Lock()
+	defer conn.muev.Unlock()
+	if conn.evclosed || seq != conn.lastev+1 {
+		return false, nil
+	}
+	if err := conn.dispatchEvent(ctx, ev, seq, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (conn *Connection) lastEventSeq() int {
+	conn.muev.Lock()
+	defer conn.muev.Unlock()
+	return conn.lastev
+}
+
+// dispatchEvent : 受け取ったEventを処理してevchに流す. muevをロックして呼ぶこと
+func (conn *Connection) dispatchEvent(ctx context.Context, ev binary.Event, lastev int, startsender func(int)) error {
+	switch ev.Type() {
+	case binary.EvTypePeerReady:
+		pr, err := binary.UnmarshalEvPeerReadyPingPayload(ev.Payload())
 		if err != nil {
-			return xerrors.Errorf("receiver unmarshal: %w", err)
+			return xerrors.Errorf("unmarshal peer-ready payload %v: %w", ev.Type(), err)
+		}
+		if pr.DeadlineMilli != 0 {
+			conn.deadline.Store((pr.DeadlineMilli + 999) / 1000)
 		}
+		conn.pingInterval.Store(int64(pr.PingIntervalMilli) * int64(time.Millisecond))
+		startsender(pr.LastMsgSeq)
 
-		lastev := conn.lastev
-		if _, ok := ev.(*binary.RegularEvent); ok {
-			lastev++
-			if seq != lastev {
-				return xerrors.Errorf("invalid event sequence num: %v wants %v", seq, lastev)
-			}
+	case binary.EvTypeDeadlineChanged:
+		dc, err := binary.UnmarshalEvDeadlineChangedPayload(ev.Payload())
+		if err != nil {
+			return xerrors.Errorf("unmarshal deadline-changed payload %v: %w", ev.Type(), err)
 		}
+		conn.deadline.Store((dc.DeadlineMilli + 999) / 1000)
+		conn.pingInterval.Store(int64(dc.PingIntervalMilli) * int64(time.Millisecond))
 
-		switch ev.Type() {
-		case binary.EvTypePeerReady:
-			msgseq, err := binary.UnmarshalEvPeerReadyPayload(ev.Payload())
-			if err != nil {
-				return xerrors.Errorf("unmarshal peer-ready payload %v: %w", ev.Type(), err)
-			}
-			startsender(msgseq)
+	case binary.EvTypeRoomProp:
+		deadline, err := binary.GetRoomPropClientDeadline(ev.Payload())
+		if err != nil {
+			return xerrors.Errorf("get client deadline: %w", err)
+		}
+		if deadline != 0 {
+			conn.deadline.Store(deadline)
+		}
 
-		case binary.EvTypeRoomProp:
-			deadline, err := binary.GetRoomPropClientDeadline(ev.Payload())
-			if err != nil {
-				return xerrors.Errorf("get client deadline: %w", err)
-			}
-			if deadline != 0 {
-				conn.deadline.Store(deadline)
-			}
+	case binary.EvTypeMasterSwitchRequested:
+		// 応答できていることを示すため、Masterの移譲は自動で受諾する
+		if err := conn.Send(binary.MsgTypeAcceptMaster, nil); err != nil {
+			return xerrors.Errorf("accept master: %w", err)
 		}
+	}
 
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		default:
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case conn.evch <- ev:
-				conn.lastev = lastev
-			}
+		case conn.evch <- ev:
+			conn.lastev = lastev
 		}
 	}
+	return nil
+}
+
+// PingInterval : Pingの間隔. サーバの指定が無ければdeadlineの1/3
+func (conn *Connection) PingInterval() time.Duration {
+	if t := time.Duration(conn.pingInterval.Load()); t > 0 {
+		return t
+	}
+	return time.Duration(conn.deadline.Load()) * time.Second / 3
 }
 
-func (conn *Connection) pinger(ctx context.Context, ws *websocket.Conn, mu *sync.Mutex) error {
+func (conn *Connection) pinger(ctx context.Context, ws wsConn, mu *sync.Mutex) error {
 	for {
 		conn.mumsg.Lock()
 		msg := binary.NewMsgPing(time.Now()).Marshal(conn.hmac)
@@ -315,7 +539,7 @@ func (conn *Connection) pinger(ctx context.Context, ws *websocket.Conn, mu *sync
 			return xerrors.Errorf("pinger: %w", err)
 		}
 
-		t := time.Duration(conn.deadline.Load()) * time.Second / 3
+		t := conn.PingInterval()
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -324,7 +548,7 @@ func (conn *Connection) pinger(ctx context.Context, ws *websocket.Conn, mu *sync
 	}
 }
 
-func (conn *Connection) sender(ctx context.Context, ws *websocket.Conn, mu *sync.Mutex, lastseq int) error {
+func (conn *Connection) sender(ctx context.Context, ws wsConn, mu *sync.Mutex, lastseq int) error {
 	for {
 		msgs, err := conn.msgbuf.Read(lastseq)
 		if err != nil {
@@ -355,7 +579,7 @@ func (conn *Connection) sender(ctx context.Context, ws *websocket.Conn, mu *sync
 	}
 }
 
-func (conn *Connection) systemSender(ctx context.Context, ws *websocket.Conn, mu *sync.Mutex) error {
+func (conn *Connection) systemSender(ctx context.Context, ws wsConn, mu *sync.Mutex) error {
 	// 送信中の投げ込みも受け付けるようcap=1のチャネルを挟む
 	mc := make(chan binary.Msg, 1)
 	// systemSenderが動き始めてからsysmsgへの書き込みを受け付ける (see: conn.SendSystemMsg())
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shiguredo/websocket"
+	"golang.org/x/xerrors"
+
+	"wsnet2/auth"
+	"wsnet2/binary"
+	"wsnet2/game"
+	"wsnet2/pb"
+)
+
+func TestHandshake(t *testing.T) {
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Wsnet2-App") != "" || r.Header.Get("Authorization") != "" {
+			t.Errorf("handshake connection has auth headers: %v", r.Header)
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer ws.Close()
+		// gameサーバと同じく先頭フレームで認証する
+		_, hs, err := game.AcceptHandshake(ws, func(hs *binary.Handshake) (*game.Client, error) {
+			if hs.AppId != "testapp" || hs.ClientId != "user1" {
+				return nil, xerrors.Errorf("unknown client: %v %v", hs.AppId, hs.ClientId)
+			}
+			if _, err := auth.ValidAuthDataHash(hs.AuthData, "authkey", hs.ClientId); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		})
+		if err != nil {
+			return
+		}
+		if hs.ProtocolVersion != binary.ProtocolVersionDeadlineChanged {
+			t.Errorf("protocol version = %v, wants %v", hs.ProtocolVersion, binary.ProtocolVersionDeadlineChanged)
+		}
+		ws.WriteMessage(websocket.BinaryMessage, binary.NewEvPeerReadyWithPing(0, 0, 5000).Marshal())
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(
+			websocket.CloseNormalClosure, binary.FormatCloseText(binary.CloseReasonRemoved, "bye")))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/game/room/room1"
+	connect := func(authKey string) *Connection {
+		conn, err := newConn(ctx, &AccessInfo{AppId: "testapp", UserId: "user1", MACKey: "mackey", Handshake: true},
+			&pb.JoinedRoomRes{
+				RoomInfo: &pb.RoomInfo{Id: "room1"},
+				Url:      url,
+				AuthKey:  authKey,
+				Deadline: 5,
+			}, nil)
+		if err != nil {
+			t.Fatalf("newConn: %v", err)
+		}
+		return conn
+	}
+
+	conn := connect("authkey")
+	if ev := <-conn.Events(); ev.Type() != binary.EvTypePeerReady {
+		t.Fatalf("first event = %v, wants PeerReady", ev.Type())
+	}
+	msg, err := conn.Wait(ctx)
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if want := binary.FormatCloseText(binary.CloseReasonRemoved, "bye"); msg != want {
+		t.Fatalf("Wait = %q, wants %q", msg, want)
+	}
+
+	// 認証に失敗したら再接続せずに終わる
+	conn = connect("wrongkey")
+	if _, err := conn.Wait(ctx); err == nil || !strings.Contains(err.Error(), "handshake") {
+		t.Fatalf("Wait error = %v, wants handshake failure", err)
+	}
+}
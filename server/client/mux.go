@@ -0,0 +1,327 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shiguredo/websocket"
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+)
+
+// muxRecvSize : ハンドル毎の受信済みで読み出されていないフレームの上限. 溢れると他のハンドルの受信も待たされる
+const muxRecvSize = 32
+
+// wsConn : Connectionが送受信に使う接続. *websocket.Conn か muxChannel
+type wsConn interface {
+	ReadMessage() (int, []byte, error)
+	WriteMessage(messageType int, data []byte) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+var _ wsConn = &websocket.Conn{}
+var _ wsConn = &muxChannel{}
+
+// MuxURL : 部屋毎の接続のURL (JoinedRoomRes.Url) から、同じgameサーバの多重化した接続のURLを得る
+func MuxURL(roomURL string) string {
+	i := strings.LastIndex(roomURL, "/room/")
+	if i < 0 {
+		return ""
+	}
+	return roomURL[:i] + "/mux"
+}
+
+// Mux : 同じgameサーバの複数の部屋への接続を1つのwebsocketにまとめる (see: binary/mux.go).
+// AccessInfo.Muxに指定すると、同じgameサーバの部屋への接続はMuxを使う.
+// websocketが切れたときは、各Connectionの再接続で張り直す.
+type Mux struct {
+	url   string
+	appid string
+
+	mu       sync.Mutex
+	ws       *websocket.Conn // 切れていたらnil
+	channels map[byte]*muxChannel
+	closed   bool
+
+	// muWrite : websocketへの書き込みは全ハンドルで排他する
+	muWrite sync.Mutex
+}
+
+// DialMux : url (see: MuxURL) に接続する
+func DialMux(ctx context.Context, url, appid string) (*Mux, error) {
+	m := &Mux{
+		url:      url,
+		appid:    appid,
+		channels: make(map[byte]*muxChannel),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.dial(ctx); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close : websocketを閉じる. 割り当て中のConnectionは再接続できずに終わる
+func (m *Mux) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	if m.ws == nil {
+		return nil
+	}
+	return m.ws.Close()
+}
+
+// dial : websocketを張って受信を始める. muをロックして呼ぶこと
+func (m *Mux) dial(ctx context.Context) error {
+	hdr := http.Header{}
+	hdr.Add("Wsnet2-App", m.appid)
+	hdr.Add(binary.ProtocolVersionHeader, strconv.Itoa(binary.ProtocolVersionDeadlineChanged))
+
+	ws, _, err := dialer.DialContext(ctx, m.url, hdr)
+	if err != nil {
+		return xerrors.Errorf("dial mux: %w", err)
+	}
+	m.ws = ws
+	go m.receiver(ws)
+	return nil
+}
+
+// serves : roomURLの部屋への接続にこのMuxを使えるか
+func (m *Mux) serves(roomURL string) bool {
+	return m != nil && MuxURL(roomURL) == m.url
+}
+
+// attach : 空いているハンドルに部屋を割り当てる.
+// 割り当ての成否はハンドルのEvTypePeerReadyかMuxClosedで届く.
+func (m *Mux) attach(ctx context.Context, roomId, clientId, authData string, lastEvSeq int) (*muxChannel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, xerrors.Errorf("mux closed")
+	}
+	if m.ws == nil {
+		if err := m.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var handle byte
+	for h := 1; h <= 255; h++ {
+		if _, ok := m.channels[byte(h)]; !ok {
+			handle = byte(h)
+			break
+		}
+	}
+	if handle == binary.MuxControlHandle {
+		return nil, xerrors.Errorf("no free mux handle")
+	}
+
+	ch := &muxChannel{
+		mux:    m,
+		ws:     m.ws,
+		handle: handle,
+		recv:   make(chan []byte, muxRecvSize),
+		done:   make(chan struct{}),
+	}
+	if err := m.write(m.ws, binary.MarshalMuxAttach(handle, roomId, clientId, authData, lastEvSeq), time.Time{}); err != nil {
+		return nil, xerrors.Errorf("mux attach: %w", err)
+	}
+	m.channels[handle] = ch
+	return ch, nil
+}
+
+func (m *Mux) write(ws *websocket.Conn, frame []byte, deadline time.Time) error {
+	m.muWrite.Lock()
+	defer m.muWrite.Unlock()
+	if deadline.IsZero() {
+		deadline = time.Now().Add(time.Second)
+	}
+	ws.SetWriteDeadline(deadline)
+	return ws.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// receiver : websocketが切れるまでフレームをハンドルに振り分ける
+func (m *Mux) receiver(ws *websocket.Conn) {
+	err := m.receive(ws)
+	ws.Close()
+
+	m.mu.Lock()
+	if m.ws == ws {
+		m.ws = nil
+	}
+	var channels []*muxChannel
+	for h, ch := range m.channels {
+		if ch.ws == ws {
+			channels = append(channels, ch)
+			delete(m.channels, h)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, ch := range channels {
+		ch.shutdown(err)
+	}
+}
+
+func (m *Mux) receive(ws *websocket.Conn) error {
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		handle, body, err := binary.ParseMuxFrame(data)
+		if err != nil {
+			return err
+		}
+		if handle == binary.MuxControlHandle {
+			if err := m.control(body); err != nil {
+				return err
+			}
+			continue
+		}
+		if ch := m.channel(handle); ch != nil {
+			ch.deliver(body)
+		}
+	}
+}
+
+func (m *Mux) control(body []byte) error {
+	ctrl, payload, err := binary.UnmarshalMuxControl(body)
+	if err != nil {
+		return err
+	}
+	if ctrl != binary.MuxClosed {
+		return xerrors.Errorf("unexpected mux control: %v", ctrl)
+	}
+	h, cm, err := binary.UnmarshalMuxClosedPayload(payload)
+	if err != nil {
+		return err
+	}
+	ch := m.channel(h)
+	if ch == nil {
+		return nil
+	}
+	m.remove(ch)
+	// Close frameと同じくcloseコードと理由を返す
+	cerr := &websocket.CloseError{Code: websocket.CloseNoStatusReceived}
+	if len(cm) >= 2 {
+		cerr.Code = int(cm[0])<<8 | int(cm[1])
+		cerr.Text = string(cm[2:])
+	}
+	ch.shutdown(cerr)
+	return nil
+}
+
+func (m *Mux) channel(h byte) *muxChannel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.channels[h]
+}
+
+func (m *Mux) remove(ch *muxChannel) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.channels[ch.handle] == ch {
+		delete(m.channels, ch.handle)
+	}
+}
+
+// muxChannel : 多重化した接続の1つのハンドル
+type muxChannel struct {
+	mux    *Mux
+	ws     *websocket.Conn
+	handle byte
+
+	recv chan []byte
+
+	// 読み書きの期限. Connectionは読み込みを1つのgoroutineで、書き込みをロックして行う
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// deliver : 受信したフレームを渡す. 閉じていたら捨てる
+func (ch *muxChannel) deliver(data []byte) {
+	select {
+	case ch.recv <- data:
+	case <-ch.done:
+	}
+}
+
+// shutdown : ハンドルを閉じ、ReadMessageにerrを返す
+func (ch *muxChannel) shutdown(err error) {
+	ch.once.Do(func() {
+		ch.err = err
+		close(ch.done)
+	})
+}
+
+func (ch *muxChannel) ReadMessage() (int, []byte, error) {
+	// 閉じる前に受信したフレームを先に読む
+	select {
+	case data := <-ch.recv:
+		return websocket.BinaryMessage, data, nil
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if !ch.readDeadline.IsZero() {
+		t := time.NewTimer(time.Until(ch.readDeadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case data := <-ch.recv:
+		return websocket.BinaryMessage, data, nil
+	case <-ch.done:
+		return 0, nil, ch.err
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (ch *muxChannel) WriteMessage(messageType int, data []byte) error {
+	select {
+	case <-ch.done:
+		return net.ErrClosed
+	default:
+	}
+	return ch.mux.write(ch.ws, binary.MuxFrame(ch.handle, data), ch.writeDeadline)
+}
+
+func (ch *muxChannel) SetReadDeadline(t time.Time) error {
+	ch.readDeadline = t
+	return nil
+}
+
+func (ch *muxChannel) SetWriteDeadline(t time.Time) error {
+	ch.writeDeadline = t
+	return nil
+}
+
+// Close : ハンドルの接続を切る (MuxDetach). 退室はしない
+func (ch *muxChannel) Close() error {
+	select {
+	case <-ch.done:
+		return nil
+	default:
+	}
+	ch.shutdown(net.ErrClosed)
+	// 送り終えるまでハンドルを他の部屋に割り当てないよう、送ってから外す
+	defer ch.mux.remove(ch)
+	return ch.mux.write(ch.ws, binary.MarshalMuxDetach(ch.handle), time.Time{})
+}
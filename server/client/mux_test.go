@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shiguredo/websocket"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+// serveFakeMux : 部屋毎にPeerReadyとroom IDを載せたイベントを送り、
+// Broadcastを受け取ったらそのハンドルをroom IDを理由に閉じる多重化した接続のサーバ
+func serveFakeMux(t *testing.T, ws *websocket.Conn) {
+	rooms := make(map[byte]string)
+	write := func(frame []byte) {
+		if err := ws.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+			t.Errorf("server write: %v", err)
+		}
+	}
+	closeHandle := func(h byte, reason binary.CloseReason, text string) {
+		write(binary.MarshalMuxClosed(h, websocket.FormatCloseMessage(
+			websocket.CloseNormalClosure, binary.FormatCloseText(reason, text))))
+	}
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		h, body, err := binary.ParseMuxFrame(data)
+		if err != nil {
+			t.Errorf("server ParseMuxFrame: %v", err)
+			return
+		}
+		if h != binary.MuxControlHandle {
+			// Pingなどは無視する
+			if binary.MsgType(body[0]) == binary.MsgTypeBroadcast {
+				closeHandle(h, binary.CloseReasonRemoved, rooms[h])
+			}
+			continue
+		}
+		ctrl, payload, _ := binary.UnmarshalMuxControl(body)
+		if ctrl != binary.MuxAttach {
+			continue
+		}
+		p, err := binary.UnmarshalMuxAttachPayload(payload)
+		if err != nil {
+			t.Errorf("server UnmarshalMuxAttachPayload: %v", err)
+			return
+		}
+		if p.RoomId == "unknown" || p.AuthData == "" {
+			closeHandle(p.Handle, binary.CloseReasonAttachFailed, "room not found")
+			continue
+		}
+		rooms[p.Handle] = p.RoomId
+		write(binary.MuxFrame(p.Handle, binary.NewEvPeerReadyWithPing(0, 0, 5000).Marshal()))
+		write(binary.MuxFrame(p.Handle, binary.NewEvMessage("server", []byte(p.RoomId)).Marshal(1)))
+	}
+}
+
+func TestMux(t *testing.T) {
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/game/mux" {
+			http.NotFound(w, r)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer ws.Close()
+		serveFakeMux(t, ws)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	roomURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/game/room/"
+	mux, err := DialMux(ctx, MuxURL(roomURL+"room1"), "testapp")
+	if err != nil {
+		t.Fatalf("DialMux: %v", err)
+	}
+	defer mux.Close()
+
+	accinfo := &AccessInfo{AppId: "testapp", UserId: "user1", MACKey: "mackey", Mux: mux}
+	connect := func(roomId string) *Connection {
+		conn, err := newConn(ctx, accinfo, &pb.JoinedRoomRes{
+			RoomInfo: &pb.RoomInfo{Id: roomId},
+			Url:      roomURL + roomId,
+			AuthKey:  "authkey",
+			Deadline: 5,
+		}, nil)
+		if err != nil {
+			t.Fatalf("newConn(%v): %v", roomId, err)
+		}
+		if conn.mux != mux {
+			t.Fatalf("connection to %v does not use mux", roomId)
+		}
+		return conn
+	}
+
+	// 2つの部屋に1つのwebsocketで接続する
+	conns := map[string]*Connection{"room1": connect("room1"), "room2": connect("room2")}
+	for id, conn := range conns {
+		if ev := <-conn.Events(); ev.Type() != binary.EvTypePeerReady {
+			t.Fatalf("%v: first event = %v, wants PeerReady", id, ev.Type())
+		}
+		ev := <-conn.Events()
+		_, body, err := binary.UnmarshalEvMessage(ev.Payload())
+		if err != nil {
+			t.Fatalf("%v: UnmarshalEvMessage: %v", id, err)
+		}
+		if string(body) != id {
+			t.Fatalf("%v: event body = %q, wants %q", id, body, id)
+		}
+	}
+
+	// 送信は部屋のハンドルで届き、ハンドルを閉じるとその部屋の接続だけが終わる
+	for id, conn := range conns {
+		if err := conn.Send(binary.MsgTypeBroadcast, binary.MarshalStr8("hello")); err != nil {
+			t.Fatalf("%v: Send: %v", id, err)
+		}
+		msg, err := conn.Wait(ctx)
+		if err != nil {
+			t.Fatalf("%v: Wait: %v", id, err)
+		}
+		if want := binary.FormatCloseText(binary.CloseReasonRemoved, id); msg != want {
+			t.Fatalf("%v: Wait = %q, wants %q", id, msg, want)
+		}
+	}
+
+	// 割り当てに失敗したら再接続せずに終わる
+	conn := connect("unknown")
+	if _, err := conn.Wait(ctx); err == nil || !strings.Contains(err.Error(), "room not found") {
+		t.Fatalf("Wait error = %v, wants attach failure", err)
+	}
+
+	// 別のgameサーバの部屋にはMuxを使わない
+	other, err := newConn(ctx, accinfo, &pb.JoinedRoomRes{
+		RoomInfo: &pb.RoomInfo{Id: "room3"},
+		Url:      "ws://other.example.com/game/room/room3",
+		AuthKey:  "authkey",
+		Deadline: 1,
+	}, nil)
+	if err != nil {
+		t.Fatalf("newConn: %v", err)
+	}
+	if other.mux != nil {
+		t.Fatalf("connection to other server uses mux")
+	}
+}
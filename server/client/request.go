@@ -13,15 +13,34 @@ import (
 	"google.golang.org/grpc"
 
 	"wsnet2/auth"
+	"wsnet2/binary"
 	"wsnet2/lobby"
 	"wsnet2/pb"
 )
 
+// Capabilities : このクライアントの対応機能
+func Capabilities() *pb.Capabilities {
+	return &pb.Capabilities{
+		Batch:           true,
+		ProtocolVersion: binary.ProtocolVersionDeadlineChanged,
+		Platform:        "go",
+		MacAlgorithms:   []string{auth.MACAlgorithmSHA256, auth.MACAlgorithmSHA1},
+	}
+}
+
+// withCaps : 対応機能が未設定なら設定する
+func withCaps(clinfo *pb.ClientInfo) *pb.ClientInfo {
+	if clinfo.Caps == nil {
+		clinfo.Caps = Capabilities()
+	}
+	return clinfo
+}
+
 // Create : Roomを作成して入室
 func Create(ctx context.Context, accinfo *AccessInfo, roomopt *pb.RoomOption, clinfo *pb.ClientInfo, warn func(error)) (*Room, *Connection, error) {
 	param := lobby.CreateParam{
 		RoomOption: roomopt,
-		ClientInfo: clinfo,
+		ClientInfo: withCaps(clinfo),
 		EncMACKey:  accinfo.EncMACKey,
 	}
 
@@ -37,7 +56,7 @@ func Create(ctx context.Context, accinfo *AccessInfo, roomopt *pb.RoomOption, cl
 func Join(ctx context.Context, accinfo *AccessInfo, roomid string, query *Query, clinfo *pb.ClientInfo, warn func(error)) (*Room, *Connection, error) {
 	param := lobby.JoinParam{
 		Queries:    []lobby.PropQueries(*query),
-		ClientInfo: clinfo,
+		ClientInfo: withCaps(clinfo),
 		EncMACKey:  accinfo.EncMACKey,
 	}
 
@@ -53,7 +72,7 @@ func Join(ctx context.Context, accinfo *AccessInfo, roomid string, query *Query,
 func JoinByNumber(ctx context.Context, accinfo *AccessInfo, number int32, query *Query, clinfo *pb.ClientInfo, warn func(error)) (*Room, *Connection, error) {
 	param := lobby.JoinParam{
 		Queries:    []lobby.PropQueries(*query),
-		ClientInfo: clinfo,
+		ClientInfo: withCaps(clinfo),
 		EncMACKey:  accinfo.EncMACKey,
 	}
 
@@ -69,7 +88,7 @@ func JoinByNumber(ctx context.Context, accinfo *AccessInfo, number int32, query
 func RandomJoin(ctx context.Context, accinfo *AccessInfo, group uint32, query *Query, clinfo *pb.ClientInfo, warn func(error)) (*Room, *Connection, error) {
 	param := lobby.JoinParam{
 		Queries:    []lobby.PropQueries(*query),
-		ClientInfo: clinfo,
+		ClientInfo: withCaps(clinfo),
 		EncMACKey:  accinfo.EncMACKey,
 	}
 
@@ -89,7 +108,7 @@ func Watch(ctx context.Context, accinfo *AccessInfo, roomid string, query *Query
 	}
 	param := lobby.JoinParam{
 		Queries:    q,
-		ClientInfo: &pb.ClientInfo{Id: accinfo.UserId},
+		ClientInfo: &pb.ClientInfo{Id: accinfo.UserId, Caps: Capabilities()},
 		EncMACKey:  accinfo.EncMACKey,
 	}
 
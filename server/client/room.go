@@ -17,6 +17,7 @@ type Room struct {
 	Watchable      bool
 	SearchGroup    uint32
 	MaxPlayers     uint32
+	MaxWatchers    uint32
 	Watchers       uint32
 	PublicProps    binary.Dict
 	PrivateProps   binary.Dict
@@ -26,11 +27,14 @@ type Room struct {
 	Me             *Player
 	Master         *Player
 	LastMsgTimes   binary.Dict
+	KV             binary.Dict
+	KVOwners       map[string]string
 }
 
 type Player struct {
 	Id    string
 	Props binary.Dict
+	Bot   bool // MasterがMsgTypeAddBotで追加したボット
 }
 
 func newRoom(joined *pb.JoinedRoomRes, myid string) (*Room, error) {
@@ -59,6 +63,7 @@ func newRoom(joined *pb.JoinedRoomRes, myid string) (*Room, error) {
 		players[p.Id] = &Player{
 			Id:    p.Id,
 			Props: props,
+			Bot:   p.Bot,
 		}
 	}
 
@@ -70,6 +75,7 @@ func newRoom(joined *pb.JoinedRoomRes, myid string) (*Room, error) {
 		Watchable:      joined.RoomInfo.Watchable,
 		SearchGroup:    joined.RoomInfo.SearchGroup,
 		MaxPlayers:     joined.RoomInfo.MaxPlayers,
+		MaxWatchers:    joined.RoomInfo.MaxWatchers,
 		Watchers:       joined.RoomInfo.Watchers,
 		PublicProps:    pubProps,
 		PrivateProps:   privProps,
@@ -79,6 +85,8 @@ func newRoom(joined *pb.JoinedRoomRes, myid string) (*Room, error) {
 		Me:             players[myid],
 		Master:         players[joined.MasterId],
 		LastMsgTimes:   make(binary.Dict),
+		KV:             make(binary.Dict),
+		KVOwners:       make(map[string]string),
 	}, nil
 }
 
@@ -99,8 +107,12 @@ func (r *Room) Update(ev binary.Event) error {
 		return r.onEvMasterSwitched(ev)
 	case binary.EvTypeRejoined:
 		return r.onEvRejoined(ev)
+	case binary.EvTypeBotJoined:
+		return r.onEvBotJoined(ev)
 	case binary.EvTypePong:
 		return r.onEvPong(ev)
+	case binary.EvTypeKVUpdated:
+		return r.onEvKVUpdated(ev)
 	}
 	return nil
 }
@@ -189,6 +201,23 @@ func (r *Room) onEvRejoined(ev binary.Event) error {
 	return nil
 }
 
+func (r *Room) onEvBotJoined(ev binary.Event) error {
+	bot, err := binary.UnmarshalEvBotJoinedPayload(ev.Payload())
+	if err != nil {
+		return xerrors.Errorf("Room.onEvBotJoined: payload: %w", err)
+	}
+	props, _, err := binary.UnmarshalNullDict(bot.Props)
+	if err != nil {
+		return xerrors.Errorf("Room.onEvBotJoined: bot(%v) props: %w", bot.Id, err)
+	}
+	r.Players[bot.Id] = &Player{
+		Id:    bot.Id,
+		Props: props,
+		Bot:   true,
+	}
+	return nil
+}
+
 func (r *Room) onEvPong(ev binary.Event) error {
 	p, err := binary.UnmarshalEvPongPayload(ev.Payload())
 	if err != nil {
@@ -198,3 +227,24 @@ func (r *Room) onEvPong(ev binary.Event) error {
 	r.LastMsgTimes = p.LastMsgTimes
 	return nil
 }
+
+func (r *Room) onEvKVUpdated(ev binary.Event) error {
+	p, err := binary.UnmarshalEvKVUpdatedPayload(ev.Payload())
+	if err != nil {
+		return xerrors.Errorf("Room.onEvKVUpdated: payload: %w", err)
+	}
+	for k, v := range p.Values {
+		if len(v) == 0 {
+			delete(r.KV, k)
+			delete(r.KVOwners, k)
+			continue
+		}
+		r.KV[k] = v
+		o, _, err := binary.UnmarshalAs(p.Owners[k], binary.TypeStr8)
+		if err != nil {
+			return xerrors.Errorf("Room.onEvKVUpdated: owner[%v]: %w", k, err)
+		}
+		r.KVOwners[k] = o.(string)
+	}
+	return nil
+}
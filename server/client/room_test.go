@@ -61,6 +61,39 @@ func TestRoom_Update_onEvJoined(t *testing.T) {
 	}
 }
 
+func TestRoom_Update_onEvBotJoined(t *testing.T) {
+	name := "bot1"
+	props := binary.Dict{"level": binary.MarshalInt(3)}
+	ev := binary.NewEvBotJoined(
+		&pb.ClientInfo{
+			Id:    name,
+			Props: binary.MarshalDict(props),
+		})
+
+	room := newRoom()
+	err := room.Update(ev)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	p, ok := room.Players[name]
+	if !ok {
+		t.Fatalf("bot %v not found", name)
+	}
+	if !p.Bot || !reflect.DeepEqual(p.Props, props) {
+		t.Fatalf("bot = %+v, wants props %v", p, props)
+	}
+
+	// 同じIDのPlayerが入室するとボットではなくなる
+	ev = binary.NewEvRejoined(&pb.ClientInfo{Id: name, Props: binary.MarshalDict(props)})
+	if err := room.Update(ev); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if room.Players[name].Bot {
+		t.Fatalf("player %v is still a bot", name)
+	}
+}
+
 func TestRoom_Update_onEvLeft(t *testing.T) {
 	nameleft := "user1"
 	namemaster := "user2"
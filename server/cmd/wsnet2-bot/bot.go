@@ -419,7 +419,7 @@ func (b *bot) EventLoop() {
 				lg.Errorf("Failed to UnmarshalEvLeftPayload: err=%v, payload=% x", err, ev.Payload())
 				break
 			}
-			lg.Debugf("left=%q master=%q", left.ClientId, left.MasterId)
+			lg.Debugf("left=%q master=%q cause=%q kicked=%v reason=%v ban=%v", left.ClientId, left.MasterId, left.Cause, left.Kicked, left.Reason, left.BanDuration)
 		case binary.EvTypePong:
 			pongPayload, err := binary.UnmarshalEvPongPayload(ev.Payload())
 			if err != nil {
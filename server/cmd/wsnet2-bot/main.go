@@ -29,6 +29,7 @@ var cmds = []subcmd{
 	NewStressBot(),
 	NewStaticBot(),
 	NewWatcherBot(),
+	NewSoakBot(),
 }
 
 var lobbyPrefix string = "http://192.168.0.1:3000"
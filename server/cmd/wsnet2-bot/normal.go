@@ -143,7 +143,7 @@ func (cmd *normalBot) Execute(args []string) {
 	go func() {
 		time.Sleep(time.Second * 3)
 		logger.Debug("msg 007")
-		bot.SendMessage(binary.MsgTypeKick, binary.MarshalStr8("99999"))
+		bot.SendMessage(binary.MsgTypeKick, binary.MarshalKickPayload("99999", "kick test", 1, 10))
 		time.Sleep(time.Second)
 		logger.Debug("msg 008")
 		bot.SendMessage(binary.MsgTypeKick, binary.MarshalStr8("00000"))
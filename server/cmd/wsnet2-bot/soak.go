@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	encbin "encoding/binary"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/client"
+	"wsnet2/pb"
+)
+
+// soakBot : client SDKを使った負荷・耐久試験用bot
+//
+// 複数の部屋を並行して作成し、各部屋でPlayerとWatcherを動かす.
+// Playerは送信時刻を埋め込んだメッセージを一定レートでBroadcastし、
+// 受信側で計測した遅延のパーセンタイルを定期的に出力する.
+type soakBot struct {
+	name string
+
+	rooms     int
+	players   int
+	watchers  int
+	rate      float64
+	size      int
+	duration  time.Duration
+	roomLife  time.Duration
+	reconnect time.Duration
+	report    time.Duration
+
+	pid   int
+	stats *latencyStats
+}
+
+func NewSoakBot() *soakBot {
+	return &soakBot{name: "soak"}
+}
+
+func (cmd *soakBot) Name() string {
+	return cmd.name
+}
+
+func (cmd *soakBot) Execute(args []string) {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.IntVar(&cmd.rooms, "rooms", 10, "number of concurrent rooms")
+	fs.IntVar(&cmd.players, "players", 4, "players per room (including master)")
+	fs.IntVar(&cmd.watchers, "watchers", 0, "watchers per room")
+	fs.Float64Var(&cmd.rate, "rate", 5, "messages per second per player")
+	fs.IntVar(&cmd.size, "size", 64, "message payload size in bytes (min 8)")
+	fs.DurationVar(&cmd.duration, "duration", 10*time.Minute, "total duration")
+	fs.DurationVar(&cmd.roomLife, "room-life", 0, "recreate rooms after this duration (0: no room churn)")
+	fs.DurationVar(&cmd.reconnect, "reconnect", 0, "mean interval of player leave/rejoin (0: no reconnect churn)")
+	fs.DurationVar(&cmd.report, "report", 10*time.Second, "report interval")
+	fs.Parse(args)
+
+	if cmd.players < 1 || cmd.rate <= 0 {
+		logger.Errorf("invalid parameter: players=%v rate=%v", cmd.players, cmd.rate)
+		return
+	}
+	if cmd.size < 8 {
+		cmd.size = 8
+	}
+
+	cmd.pid = os.Getpid()
+	cmd.stats = &latencyStats{}
+
+	logger.Infof("soak: rooms=%v players=%v watchers=%v rate=%v size=%v duration=%v room-life=%v reconnect=%v",
+		cmd.rooms, cmd.players, cmd.watchers, cmd.rate, cmd.size, cmd.duration, cmd.roomLife, cmd.reconnect)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cmd.duration)
+	defer cancel()
+
+	go cmd.reporter(ctx)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < cmd.rooms; i++ {
+		wg.Add(1)
+		go func(rno int) {
+			defer wg.Done()
+			time.Sleep(time.Millisecond * time.Duration(rand.Intn(1000)))
+			for seq := 0; ctx.Err() == nil; seq++ {
+				cmd.runRoom(ctx, rno, seq)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	cmd.stats.log()
+	logger.Info("soak bot finished.")
+}
+
+func (cmd *soakBot) reporter(ctx context.Context) {
+	t := time.NewTicker(cmd.report)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			cmd.stats.log()
+		}
+	}
+}
+
+// runRoom : 部屋を作成してPlayerとWatcherを動かす. room-life経過後に全員退室する.
+func (cmd *soakBot) runRoom(ctx context.Context, rno, seq int) {
+	if cmd.roomLife > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.roomLife)
+		defer cancel()
+	}
+
+	masterId := fmt.Sprintf("soak-%d:%03d:%03d-master", cmd.pid, rno, seq)
+	accinfo, err := client.GenAccessInfo(lobbyPrefix, appID, appKey, masterId)
+	if err != nil {
+		logger.Errorf("access info: %v", err)
+		return
+	}
+	roomopt := &pb.RoomOption{
+		Visible:     true,
+		Joinable:    true,
+		Watchable:   true,
+		MaxPlayers:  uint32(cmd.players),
+		SearchGroup: 1,
+	}
+	// 退室メッセージを送れるよう、接続はroom-lifeのctxとは別に管理する
+	cctx, ccancel := context.WithCancel(context.Background())
+	defer ccancel()
+	room, conn, err := client.Create(cctx, accinfo, roomopt, &pb.ClientInfo{Id: masterId}, cmd.warn)
+	if err != nil {
+		if ctx.Err() == nil {
+			cmd.stats.fail()
+			logger.Errorf("create room: %v", err)
+			time.Sleep(time.Second)
+		}
+		return
+	}
+	cmd.stats.room()
+	logger.Debugf("room created: %v", room.Id)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cmd.play(ctx, conn, ccancel, masterId)
+	}()
+	for i := 1; i < cmd.players; i++ {
+		wg.Add(1)
+		go func(cid int) {
+			defer wg.Done()
+			cmd.runPlayer(ctx, room.Id, fmt.Sprintf("soak-%d:%03d:%03d-player-%03d", cmd.pid, rno, seq, cid))
+		}(i)
+	}
+	for i := 0; i < cmd.watchers; i++ {
+		wg.Add(1)
+		go func(cid int) {
+			defer wg.Done()
+			cmd.runWatcher(ctx, room.Id, fmt.Sprintf("soak-%d:%03d:%03d-watcher-%03d", cmd.pid, rno, seq, cid))
+		}(i)
+	}
+	wg.Wait()
+}
+
+// runPlayer : 入室してメッセージを送信する. reconnect指定時は退室と再入室を繰り返す.
+func (cmd *soakBot) runPlayer(ctx context.Context, roomId, userId string) {
+	time.Sleep(time.Millisecond * time.Duration(rand.Intn(100)))
+	for ctx.Err() == nil {
+		accinfo, err := client.GenAccessInfo(lobbyPrefix, appID, appKey, userId)
+		if err != nil {
+			logger.Errorf("access info: %v", err)
+			return
+		}
+		cctx, ccancel := context.WithCancel(context.Background())
+		_, conn, err := client.Join(cctx, accinfo, roomId, client.NewQuery(), &pb.ClientInfo{Id: userId}, cmd.warn)
+		if err != nil {
+			ccancel()
+			if ctx.Err() == nil {
+				cmd.stats.fail()
+				logger.Debugf("join room %v: %v", roomId, err)
+			}
+			return
+		}
+		cmd.stats.join()
+
+		pctx := ctx
+		cancel := func() {}
+		if cmd.reconnect > 0 {
+			life := time.Duration(rand.ExpFloat64() * float64(cmd.reconnect))
+			pctx, cancel = context.WithTimeout(ctx, life)
+		}
+		cmd.play(pctx, conn, ccancel, userId)
+		cancel()
+	}
+}
+
+func (cmd *soakBot) runWatcher(ctx context.Context, roomId, userId string) {
+	time.Sleep(time.Millisecond * time.Duration(rand.Intn(100)))
+	accinfo, err := client.GenAccessInfo(lobbyPrefix, appID, appKey, userId)
+	if err != nil {
+		logger.Errorf("access info: %v", err)
+		return
+	}
+	cctx, ccancel := context.WithCancel(context.Background())
+	defer ccancel()
+	_, conn, err := client.Watch(cctx, accinfo, roomId, nil, cmd.warn)
+	if err != nil {
+		if ctx.Err() == nil {
+			cmd.stats.fail()
+			logger.Debugf("watch room %v: %v", roomId, err)
+		}
+		return
+	}
+	cmd.stats.join()
+
+	done := make(chan struct{})
+	go func() {
+		cmd.receive(conn)
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+		ccancel()
+		<-done
+	case <-done:
+	}
+}
+
+// play : ctxが終了するまでメッセージを送信し、終了したら退室する.
+// closeConnは退室が完了しないときに接続を切断するために使う.
+func (cmd *soakBot) play(ctx context.Context, conn *client.Connection, closeConn func(), userId string) {
+	done := make(chan struct{})
+	go func() {
+		cmd.receive(conn)
+		close(done)
+	}()
+
+	interval := time.Duration(float64(time.Second) / cmd.rate)
+	t := time.NewTimer(time.Duration(rand.Int63n(int64(interval))))
+	defer t.Stop()
+	payload := make([]byte, cmd.size)
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-done:
+			logger.Debugf("%v: connection closed", userId)
+			closeConn()
+			return
+		case <-t.C:
+			encbin.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+			if err := conn.Send(binary.MsgTypeBroadcast, payload); err != nil {
+				logger.Debugf("%v: send: %v", userId, err)
+				cmd.stats.fail()
+			}
+			t.Reset(interval)
+		}
+	}
+
+	conn.Send(binary.MsgTypeLeave, binary.MarshalLeavePayload("soak"))
+	wctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if msg, err := conn.Wait(wctx); err != nil {
+		logger.Debugf("%v: leave: %v %v", userId, msg, err)
+	}
+	closeConn()
+	<-done
+}
+
+// receive : Eventを受信して遅延を記録する.
+func (cmd *soakBot) receive(conn *client.Connection) {
+	for ev := range conn.Events() {
+		if ev.Type() != binary.EvTypeMessage {
+			continue
+		}
+		_, body, err := binary.UnmarshalEvMessage(ev.Payload())
+		if err != nil || len(body) < 8 {
+			continue
+		}
+		sent := int64(encbin.BigEndian.Uint64(body))
+		cmd.stats.add(time.Duration(time.Now().UnixNano() - sent))
+	}
+}
+
+func (cmd *soakBot) warn(err error) {
+	logger.Debugf("warn: %v", err)
+}
+
+// latencyStats : 受信遅延と各種カウンタ
+type latencyStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+
+	rooms  atomic.Int64
+	joins  atomic.Int64
+	errors atomic.Int64
+}
+
+func (s *latencyStats) add(d time.Duration) {
+	s.mu.Lock()
+	s.latencies = append(s.latencies, d)
+	s.mu.Unlock()
+}
+
+func (s *latencyStats) room() { s.rooms.Add(1) }
+func (s *latencyStats) join() { s.joins.Add(1) }
+func (s *latencyStats) fail() { s.errors.Add(1) }
+
+// flush : 区間の遅延記録を取り出してリセットする
+func (s *latencyStats) flush() []time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lat := s.latencies
+	s.latencies = nil
+	return lat
+}
+
+func (s *latencyStats) log() {
+	lat := s.flush()
+	logger.Infof("rooms: %d, joins: %d, errors: %d, received: %d, p50: %v, p90: %v, p99: %v, max: %v",
+		s.rooms.Load(), s.joins.Load(), s.errors.Load(), len(lat),
+		percentile(lat, 50), percentile(lat, 90), percentile(lat, 99), percentile(lat, 100))
+}
+
+// percentile : latをソートしてpパーセンタイル値を返す
+func percentile(lat []time.Duration, p int) time.Duration {
+	if len(lat) == 0 {
+		return 0
+	}
+	if !sort.SliceIsSorted(lat, func(i, j int) bool { return lat[i] < lat[j] }) {
+		sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	}
+	i := (len(lat)*p + 99) / 100
+	if i > 0 {
+		i--
+	}
+	return lat[i]
+}
@@ -0,0 +1,168 @@
+// wsnet2-dump : Msg/Eventのフレームを解釈して表示する、プロトコルのデバッグ用のツール.
+//
+//	wsnet2-dump [flags] watch <room id>   観戦者として入室し、受信したイベントを表示する
+//	wsnet2-dump [flags] read <file>       watch -recordで記録したフレームを表示する ("-"なら標準入力)
+//
+// 記録ファイルは1行1フレームで、"ev <base64>" か "msg <base64>" の形式.
+// msgの行はMACを含むフレームで、-macで指定したアルゴリズムの長さのMACを取り除いて表示する (MACは検証しない).
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/auth"
+	"wsnet2/binary"
+	"wsnet2/client"
+)
+
+var (
+	lobbyURL = flag.String("lobby", "http://localhost:8080", "lobby schema://host:port")
+	appID    = flag.String("app", "testapp", "app id")
+	appKey   = flag.String("key", "testapppkey", "app key")
+	userID   = flag.String("user", "wsnet2-dump", "watcher user id")
+	record   = flag.String("record", "", "record received frames to the file (watch)")
+	macAlg   = flag.String("mac", auth.MACAlgorithmSHA1, "MAC algorithm of msg frames (read)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] watch <room id> | read <file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch args[0] {
+	case "watch":
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		err = watch(ctx, args[1])
+	case "read":
+		err = read(args[1])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(1)
+	}
+}
+
+// watch : 観戦者として入室して受信したイベントを表示する
+func watch(ctx context.Context, roomId string) error {
+	var rec io.Writer
+	if *record != "" {
+		f, err := os.Create(*record)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rec = f
+	}
+
+	accinfo, err := client.GenAccessInfo(*lobbyURL, *appID, *appKey, *userID)
+	if err != nil {
+		return xerrors.Errorf("GenAccessInfo: %w", err)
+	}
+	_, conn, err := client.Watch(ctx, accinfo, roomId, nil, func(err error) {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	})
+	if err != nil {
+		return xerrors.Errorf("watch: %w", err)
+	}
+
+	// Connectionはsequence numberを渡さないので、受信したRegularEventを数える
+	seq := 0
+	for ev := range conn.Events() {
+		var frame []byte
+		switch e := ev.(type) {
+		case *binary.RegularEvent:
+			seq++
+			frame = e.Marshal(seq)
+			fmt.Println(binary.DumpEvent(ev, seq))
+		case *binary.SystemEvent:
+			frame = e.Marshal()
+			fmt.Println(binary.DumpEvent(ev, -1))
+		}
+		if rec != nil && frame != nil {
+			fmt.Fprintf(rec, "ev %s\n", base64.StdEncoding.EncodeToString(frame))
+		}
+	}
+	msg, err := conn.Wait(ctx)
+	fmt.Fprintf(os.Stderr, "closed: %v\n", msg)
+	return err
+}
+
+// read : 記録したフレームを表示する
+func read(file string) error {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	mac, err := auth.NewMsgMAC(*macAlg, "")
+	if err != nil {
+		return err
+	}
+	// MACは取り除いてから解釈する
+	none, _ := auth.NewMsgMAC(auth.MACAlgorithmNone, "")
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dir, b64, ok := strings.Cut(line, " ")
+		if !ok {
+			return xerrors.Errorf("line %d: invalid format", n)
+		}
+		data, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return xerrors.Errorf("line %d: %w", n, err)
+		}
+		switch dir {
+		case "ev":
+			lines, err := binary.DumpEventFrame(data)
+			if err != nil {
+				return xerrors.Errorf("line %d: %w", n, err)
+			}
+			for _, l := range lines {
+				fmt.Println("<", l)
+			}
+		case "msg":
+			if len(data) < mac.Size() {
+				return xerrors.Errorf("line %d: msg too short: %v", n, len(data))
+			}
+			msg, err := binary.UnmarshalMsg(none, data[:len(data)-mac.Size()])
+			if err != nil {
+				return xerrors.Errorf("line %d: %w", n, err)
+			}
+			fmt.Println(">", binary.DumpMsg(msg))
+		default:
+			return xerrors.Errorf("line %d: unknown direction: %q", n, dir)
+		}
+	}
+	return s.Err()
+}
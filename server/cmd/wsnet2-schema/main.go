@@ -0,0 +1,44 @@
+// wsnet2-schema : binaryパッケージのMsg/Eventの種類とpayloadのレイアウトをJSONで出力する.
+// 他の言語のクライアントSDKがデコーダを生成してサーバと同期するために使う.
+// payloadのレイアウトは各定数のdocコメントの "payload:" 以降の行から作るので、コメントの形式を崩さないこと.
+//
+//	wsnet2-schema [-o schema.json] [binary package dir]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	out := flag.String("o", "", "output file (default: stdout)")
+	flag.Parse()
+
+	dir := "binary"
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	s, err := Generate(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "generate: %+v\n", err)
+		os.Exit(1)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "json: %+v\n", err)
+		os.Exit(1)
+	}
+	data = append(data, '\n')
+
+	if *out == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "write: %+v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Schema : binaryパッケージの定数とpayloadのレイアウト
+type Schema struct {
+	// Enums : byteを基底型とする型 (MsgType, EvType, Typeなど) 毎の定数
+	Enums []*Enum `json:"enums"`
+	// Constants : 型の無い整数定数 (regularMsgType, ProtocolVersion1など)
+	Constants []*Value `json:"constants"`
+}
+
+type Enum struct {
+	Name   string   `json:"name"`
+	Values []*Value `json:"values"`
+}
+
+type Value struct {
+	Name    string   `json:"name"`
+	Value   int64    `json:"value"`
+	Doc     string   `json:"doc,omitempty"`
+	Payload []*Field `json:"payload,omitempty"`
+}
+
+// Field : payloadの要素. docコメントの "payload:" 以降の行から作る.
+// "- Type: 説明" の形式でない行はTypeを空にして行全体をDescにする.
+type Field struct {
+	Type string `json:"type,omitempty"`
+	Desc string `json:"desc,omitempty"`
+}
+
+// failImporter : binaryパッケージの定数は他のパッケージに依存しないので、importは解決しない
+type failImporter struct{}
+
+func (failImporter) Import(path string) (*types.Package, error) {
+	return nil, xerrors.Errorf("import %q is not resolved", path)
+}
+
+// Generate : dirのGoソース (テストを除く) からSchemaを作る
+func Generate(dir string) (*Schema, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, xerrors.Errorf("glob: %w", err)
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, p := range paths {
+		if strings.HasSuffix(p, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(p)
+		if err != nil {
+			return nil, xerrors.Errorf("read %v: %w", p, err)
+		}
+		f, err := parser.ParseFile(fset, p, src, parser.ParseComments)
+		if err != nil {
+			return nil, xerrors.Errorf("parse %v: %w", p, err)
+		}
+		if !buildable(f) {
+			continue
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, xerrors.Errorf("no go files in %v", dir)
+	}
+
+	// importできないことによるエラーは無視し、定数の値だけを使う
+	info := &types.Info{Defs: make(map[*ast.Ident]types.Object)}
+	conf := types.Config{Importer: failImporter{}, Error: func(error) {}}
+	pkg, _ := conf.Check(files[0].Name.Name, fset, files, info)
+
+	s := &Schema{Enums: []*Enum{}, Constants: []*Value{}}
+	enums := make(map[string]*Enum)
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				vs := spec.(*ast.ValueSpec)
+				doc := vs.Doc
+				if doc == nil {
+					doc = vs.Comment
+				}
+				if doc == nil && len(gd.Specs) == 1 {
+					doc = gd.Doc
+				}
+				for _, name := range vs.Names {
+					c, ok := info.Defs[name].(*types.Const)
+					if !ok || c.Val().Kind() != constant.Int {
+						continue
+					}
+					v, ok := constant.Int64Val(c.Val())
+					if !ok {
+						continue
+					}
+					val := newValue(name.Name, v, doc)
+
+					if b, ok := c.Type().(*types.Basic); ok && b.Info()&types.IsUntyped != 0 {
+						s.Constants = append(s.Constants, val)
+						continue
+					}
+					named, ok := c.Type().(*types.Named)
+					if !ok || named.Obj().Pkg() != pkg || !name.IsExported() {
+						continue
+					}
+					if b, ok := named.Underlying().(*types.Basic); !ok || b.Kind() != types.Byte {
+						continue
+					}
+					e, ok := enums[named.Obj().Name()]
+					if !ok {
+						e = &Enum{Name: named.Obj().Name()}
+						enums[e.Name] = e
+						s.Enums = append(s.Enums, e)
+					}
+					e.Values = append(e.Values, val)
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+// buildable : ビルドタグのあるファイル (unsafe_string*.go) は定数を持たず、同じ関数を重複して定義しているので除く
+func buildable(f *ast.File) bool {
+	for _, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if strings.HasPrefix(c.Text, "//go:build") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func newValue(name string, v int64, cg *ast.CommentGroup) *Value {
+	val := &Value{Name: name, Value: v}
+	if cg == nil {
+		return val
+	}
+	lines := strings.Split(strings.TrimSpace(cg.Text()), "\n")
+	var doc []string
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "payload:") {
+			doc = append(doc, line)
+			continue
+		}
+		if rest := strings.TrimSpace(strings.TrimPrefix(line, "payload:")); rest != "" {
+			val.Payload = append(val.Payload, &Field{Desc: rest})
+			continue
+		}
+		for i+1 < len(lines) {
+			l := strings.TrimSpace(lines[i+1])
+			if l == "" || (!strings.HasPrefix(l, "-") && !strings.HasPrefix(l, "|")) {
+				break
+			}
+			i++
+			val.Payload = append(val.Payload, parseField(l))
+		}
+	}
+	// "Name : 説明" の形式なら名前を除く
+	summary := strings.Join(doc, "\n")
+	if rest, ok := strings.CutPrefix(summary, name+" :"); ok {
+		summary = strings.TrimSpace(rest)
+	}
+	val.Doc = summary
+	return val
+}
+
+func parseField(line string) *Field {
+	if !strings.HasPrefix(line, "-") {
+		return &Field{Desc: line}
+	}
+	line = strings.TrimSpace(strings.TrimPrefix(line, "-"))
+	typ, desc, ok := strings.Cut(line, ":")
+	if !ok {
+		return &Field{Type: line}
+	}
+	return &Field{Type: strings.TrimSpace(typ), Desc: strings.TrimSpace(desc)}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	s, err := Generate("../../binary")
+	if err != nil {
+		t.Fatalf("Generate: %+v", err)
+	}
+
+	find := func(vals []*Value, name string) *Value {
+		t.Helper()
+		for _, v := range vals {
+			if v.Name == name {
+				return v
+			}
+		}
+		t.Fatalf("%v not found", name)
+		return nil
+	}
+	enum := func(name string) []*Value {
+		t.Helper()
+		for _, e := range s.Enums {
+			if e.Name == name {
+				return e.Values
+			}
+		}
+		t.Fatalf("enum %v not found", name)
+		return nil
+	}
+
+	if v := find(s.Constants, "regularMsgType"); v.Value != 30 {
+		t.Fatalf("regularMsgType = %v, wants 30", v.Value)
+	}
+
+	ping := find(enum("MsgType"), "MsgTypePing")
+	want := &Value{
+		Name:    "MsgTypePing",
+		Value:   1,
+		Doc:     "定期通信.\nタイムアウトしないように",
+		Payload: []*Field{{Type: "64bit-be", Desc: "unix timestamp (milli seconds)"}},
+	}
+	if !reflect.DeepEqual(ping, want) {
+		t.Fatalf("MsgTypePing = %+v, wants %+v", ping, want)
+	}
+	if v := find(enum("MsgType"), "MsgTypeLeave"); v.Value != 30 {
+		t.Fatalf("MsgTypeLeave = %v, wants 30", v.Value)
+	}
+
+	// 行末コメントもdocにする
+	if v := find(enum("Type"), "TypeStr8"); v.Value != 15 || v.Doc == "" {
+		t.Fatalf("TypeStr8 = %+v", v)
+	}
+
+	// 非公開の定数は型付きなら含めない
+	for _, v := range enum("CloseReason") {
+		if v.Name == "closeReasonEnd" {
+			t.Fatalf("unexported enum value is exported: %v", v.Name)
+		}
+	}
+}
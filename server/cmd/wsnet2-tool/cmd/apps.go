@@ -7,9 +7,10 @@ import (
 )
 
 type app struct {
-	Id   string `db:"id"`
-	Name string `db:"name"`
-	Key  string `db:"key"`
+	Id       string `db:"id"`
+	Name     string `db:"name"`
+	Key      string `db:"key"`
+	Disabled bool   `db:"disabled"`
 }
 
 // appsCmd represents the apps command
@@ -18,7 +19,7 @@ var appsCmd = &cobra.Command{
 	Short: "Show applications",
 	Long:  "Show applications registered on the DB",
 	Run: func(cmd *cobra.Command, args []string) {
-		const sql = "SELECT `id`, `key`, `name` FROM `app`"
+		const sql = "SELECT `id`, `key`, `name`, `disabled` FROM `app`"
 
 		var apps []*app
 		err := db.SelectContext(cmd.Context(), &apps, sql)
@@ -28,11 +29,11 @@ var appsCmd = &cobra.Command{
 
 		cmd.SetOut(os.Stdout)
 		if verbose {
-			cmd.Println("id\tkey\tname")
+			cmd.Println("id\tkey\tname\tdisabled")
 		}
 
 		for _, app := range apps {
-			cmd.Printf("%s\t%s\t%q\n", app.Id, app.Key, app.Name)
+			cmd.Printf("%s\t%s\t%q\t%v\n", app.Id, app.Key, app.Name, app.Disabled)
 		}
 	},
 }
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"wsnet2/pb"
+)
+
+var cleanupDryRun bool
+
+// cleanupCmd represents the cleanup command
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Clean up orphaned room rows",
+	Long: `Ask every alive game server to move orphaned rows in the room table to room_history.
+A row is orphaned when the room does not exist on its game server or the heartbeat of the game server has expired`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		const sql = "SELECT " + serverCols + " FROM game_server WHERE status IN (1, 2) AND heartbeat >= ?"
+		var servers []server
+		valid := time.Duration(conf.Lobby.ValidHeartBeat)
+		err := db.SelectContext(cmd.Context(), &servers, sql, time.Now().Add(-valid).Unix())
+		if err != nil {
+			return err
+		}
+
+		cmd.SetOut(os.Stdout)
+		if verbose {
+			cmd.Println("host\trooms")
+		}
+		for _, s := range servers {
+			conn, err := grpc.Dial(fmt.Sprintf("%s:%d", s.HostName, s.GRPCPort),
+				grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				return err
+			}
+			res, err := pb.NewGameClient(conn).CleanupRooms(cmd.Context(), &pb.CleanupRoomsReq{DryRun: cleanupDryRun})
+			conn.Close()
+			if err != nil {
+				return err
+			}
+			cmd.Printf("%v\t%v\n", s.HostName, strings.Join(res.RoomIds, ","))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cleanupCmd)
+
+	cleanupCmd.Flags().BoolVarP(&cleanupDryRun, "dry-run", "n", false, "Show the orphaned rooms without cleaning up")
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"wsnet2/pb"
+
+	"golang.org/x/xerrors"
+
+	"github.com/spf13/cobra"
+)
+
+// closeCmd represents the close command
+var closeCmd = &cobra.Command{
+	Use:   "close <room> [reason]",
+	Short: "Close the room",
+	Long:  `Close the specified room after notifying the reason to all clients`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return xerrors.Errorf("need room")
+		}
+		reason := "closed by admin"
+		if len(args) > 1 {
+			reason = args[1]
+		}
+
+		svrs, err := selectGrpcServers(cmd.Context(), args[0:1])
+		if err != nil {
+			return err
+		}
+		svr, ok := svrs[args[0]]
+		if !ok {
+			return xerrors.Errorf("room not found: %v", args[0])
+		}
+
+		conn, err := svr.Dial()
+		if err != nil {
+			return err
+		}
+
+		_, err = pb.NewGameClient(conn).CloseRoom(cmd.Context(), &pb.CloseRoomReq{
+			AppId:  svr.App,
+			RoomId: svr.Room,
+			Reason: reason,
+		})
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(closeCmd)
+}
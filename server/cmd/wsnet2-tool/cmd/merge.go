@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"wsnet2/pb"
+
+	"golang.org/x/xerrors"
+
+	"github.com/spf13/cobra"
+)
+
+// mergeCmd represents the merge command
+var mergeCmd = &cobra.Command{
+	Use:   "merge <room> <target>",
+	Short: "Merge the room into the target room",
+	Long: `Reserve seats in the target room for the players of the room, send them invite tokens, and close the room.
+The target room must be in the same app, search group and game server`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 {
+			return xerrors.Errorf("need room and target")
+		}
+
+		svrs, err := selectGrpcServers(cmd.Context(), args[0:2])
+		if err != nil {
+			return err
+		}
+		svr, ok := svrs[args[0]]
+		if !ok {
+			return xerrors.Errorf("room not found: %v", args[0])
+		}
+		target, ok := svrs[args[1]]
+		if !ok {
+			return xerrors.Errorf("room not found: %v", args[1])
+		}
+		if svr.App != target.App || svr.Host != target.Host || svr.Port != target.Port {
+			return xerrors.Errorf("rooms must be in the same app and game server: %v@%v, %v@%v",
+				svr.App, svr.Host, target.App, target.Host)
+		}
+
+		conn, err := svr.Dial()
+		if err != nil {
+			return err
+		}
+
+		_, err = pb.NewGameClient(conn).MergeRoom(cmd.Context(), &pb.MergeRoomReq{
+			AppId:    svr.App,
+			RoomId:   svr.Room,
+			TargetId: target.Room,
+		})
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mergeCmd)
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"time"
+
+	"wsnet2/pb"
+
+	"golang.org/x/xerrors"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	netsimLatency time.Duration
+	netsimJitter  time.Duration
+	netsimDrop    float64
+)
+
+// netsimCmd represents the netsim command
+var netsimCmd = &cobra.Command{
+	Use:   "netsim <room>",
+	Short: "Simulate network conditions of the room",
+	Long: `Add latency, jitter and drop to the events sent from the room to its clients.
+Run without flags to stop the simulation. The game server must enable debug_net_sim`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return xerrors.Errorf("need room")
+		}
+
+		svrs, err := selectGrpcServers(cmd.Context(), args[0:1])
+		if err != nil {
+			return err
+		}
+		svr, ok := svrs[args[0]]
+		if !ok {
+			return xerrors.Errorf("room not found: %v", args[0])
+		}
+
+		conn, err := svr.Dial()
+		if err != nil {
+			return err
+		}
+
+		_, err = pb.NewGameClient(conn).SetNetSim(cmd.Context(), &pb.SetNetSimReq{
+			AppId:    svr.App,
+			RoomId:   svr.Room,
+			Latency:  uint32(netsimLatency / time.Millisecond),
+			Jitter:   uint32(netsimJitter / time.Millisecond),
+			DropRate: netsimDrop,
+		})
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(netsimCmd)
+
+	netsimCmd.Flags().DurationVarP(&netsimLatency, "latency", "l", 0, "Latency of the events")
+	netsimCmd.Flags().DurationVarP(&netsimJitter, "jitter", "j", 0, "Jitter of the latency")
+	netsimCmd.Flags().Float64VarP(&netsimDrop, "drop", "d", 0, "Probability to drop an event (0-1)")
+}
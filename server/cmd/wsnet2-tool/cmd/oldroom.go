@@ -32,11 +32,15 @@ type roomHistory struct {
 }
 
 type playerLog struct {
-	ID       int               `db:"id" json:"-"`
-	RoomID   string            `db:"room_id" json:"-"`
-	PlayerID string            `db:"player_id" json:"player_id"`
-	Message  game.PlayerLogMsg `db:"message" json:"message"`
-	Datetime time.Time         `db:"datetime" json:"datetime"`
+	ID         int               `db:"id" json:"-"`
+	AppID      string            `db:"app_id" json:"-"`
+	RoomID     string            `db:"room_id" json:"-"`
+	PlayerID   string            `db:"player_id" json:"player_id"`
+	Message    game.PlayerLogMsg `db:"message" json:"message"`
+	Platform   string            `db:"platform" json:"platform,omitempty"`
+	AppVersion string            `db:"app_version" json:"app_version,omitempty"`
+	Device     string            `db:"device" json:"device,omitempty"`
+	Datetime   time.Time         `db:"datetime" json:"datetime"`
 }
 
 // oldroomCmd represents the oldroom command
@@ -51,7 +51,7 @@ func init() {
 }
 
 func hostMap(ctx context.Context) (map[uint32]*server, error) {
-	const hostsql = "SELECT * FROM game_server"
+	const hostsql = "SELECT " + serverCols + " FROM game_server"
 	var hosts []*server
 	err := db.SelectContext(ctx, &hosts, hostsql)
 	if err != nil {
@@ -130,7 +130,7 @@ func parsePropsSimple(data []byte) (string, error) {
 				return string(out), err
 			}
 			out = fmt.Appendf(out, "%v,", v)
-		case binary.TypeStr8, binary.TypeStr16:
+		case binary.TypeStr8, binary.TypeStr16, binary.TypeTimestamp, binary.TypeUUID:
 			v, _, err := binary.Unmarshal(d)
 			if err != nil {
 				return string(out), err
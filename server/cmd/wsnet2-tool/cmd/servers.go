@@ -15,6 +15,9 @@ var (
 	serverStatusStr = []string{"Starting", "Running", "Closing"}
 )
 
+// serverCols : game_server, hub_server 共通のカラム
+const serverCols = "id, hostname, public_name, grpc_port, ws_port, status, heartbeat"
+
 type server struct {
 	Id            int    `db:"id"`
 	HostName      string `db:"hostname"`
@@ -38,7 +41,7 @@ var serversCmd = &cobra.Command{
 		}
 
 		if !serversHubOnly {
-			const sql = "select * from game_server"
+			const sql = "select " + serverCols + " from game_server"
 			var servers []server
 			err := db.SelectContext(cmd.Context(), &servers, sql)
 			if err != nil {
@@ -49,7 +52,7 @@ var serversCmd = &cobra.Command{
 			}
 		}
 		if !serversGameOnly {
-			const sql = "select * from hub_server"
+			const sql = "select " + serverCols + " from hub_server"
 			var servers []server
 			err := db.SelectContext(cmd.Context(), &servers, sql)
 			if err != nil {
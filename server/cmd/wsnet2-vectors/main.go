@@ -0,0 +1,70 @@
+// wsnet2-vectors : binaryパッケージのシリアライズの適合性テストベクタをJSONで出力する.
+// 他の言語のクライアントSDKはCIでこのファイルを読み込み、各ベクタの hex と value を相互に変換できることを検査する.
+// -verify を指定すると、ファイルのベクタがこのサーバの実装とバイト単位で一致するか検査する.
+//
+//	wsnet2-vectors [-o vectors.json]
+//	wsnet2-vectors -verify vectors.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"wsnet2/binary"
+)
+
+func main() {
+	out := flag.String("o", "", "output file (default: stdout)")
+	verify := flag.String("verify", "", "verify vectors in the file")
+	flag.Parse()
+
+	if *verify != "" {
+		os.Exit(verifyFile(*verify))
+	}
+
+	data, err := json.MarshalIndent(binary.ConformanceVectors(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "json: %+v\n", err)
+		os.Exit(1)
+	}
+	data = append(data, '\n')
+
+	if *out == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "write: %+v\n", err)
+		os.Exit(1)
+	}
+}
+
+// verifyFile : 一致しないベクタを全て表示し、1つでもあれば1を返す
+func verifyFile(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open: %+v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	vs, err := binary.LoadVectors(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load: %+v\n", err)
+		return 1
+	}
+	failed := 0
+	for _, v := range vs {
+		if err := binary.VerifyVector(v); err != nil {
+			fmt.Fprintf(os.Stderr, "NG %v\n", err)
+			failed++
+		}
+	}
+	fmt.Printf("%v vectors, %v failed\n", len(vs), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,82 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockTimer(t *testing.T) {
+	start := time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+
+	t1 := c.NewTimer(3 * time.Second)
+	t2 := c.NewTimer(time.Second)
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-t1.C():
+		t.Fatalf("t1 fired too early")
+	case <-t2.C():
+		t.Fatalf("t2 fired too early")
+	default:
+	}
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case now := <-t2.C():
+		if want := start.Add(time.Second); !now.Equal(want) {
+			t.Fatalf("t2 fired at %v, wants %v", now, want)
+		}
+	default:
+		t.Fatalf("t2 not fired")
+	}
+	if t2.Stop() {
+		t.Fatalf("t2.Stop() = true after fired")
+	}
+
+	if !t1.Reset(time.Second) {
+		t.Fatalf("t1.Reset() = false for active timer")
+	}
+	c.Advance(time.Second)
+	select {
+	case <-t1.C():
+	default:
+		t.Fatalf("t1 not fired after reset")
+	}
+
+	if now, want := c.Now(), start.Add(2*time.Second); !now.Equal(want) {
+		t.Fatalf("Now() = %v, wants %v", now, want)
+	}
+	if n := c.Timers(); n != 0 {
+		t.Fatalf("Timers() = %v, wants 0", n)
+	}
+}
+
+func TestFakeClockAfterFunc(t *testing.T) {
+	c := NewFakeClock(time.Now())
+
+	var fired []int
+	c.AfterFunc(2*time.Second, func() { fired = append(fired, 2) })
+	c.AfterFunc(time.Second, func() {
+		fired = append(fired, 1)
+		// 発火中に登録したタイマーも同じAdvanceの範囲内なら発火する
+		c.AfterFunc(500*time.Millisecond, func() { fired = append(fired, 15) })
+	})
+	stopped := c.AfterFunc(1500*time.Millisecond, func() { fired = append(fired, -1) })
+
+	if !stopped.Stop() {
+		t.Fatalf("Stop() = false for active timer")
+	}
+
+	c.Advance(3 * time.Second)
+
+	want := []int{1, 15, 2}
+	if len(fired) != len(want) {
+		t.Fatalf("fired = %v, wants %v", fired, want)
+	}
+	for i := range want {
+		if fired[i] != want[i] {
+			t.Fatalf("fired = %v, wants %v", fired, want)
+		}
+	}
+}
@@ -19,5 +19,8 @@ func InitProps(props []byte) (binary.Dict, []byte, error) {
 	if !ok {
 		return nil, nil, xerrors.Errorf("type is not Dict: %v", binary.Type(props[0]))
 	}
+	if err := binary.CheckDictLimits(dict); err != nil {
+		return nil, nil, err
+	}
 	return dict, props, nil
 }
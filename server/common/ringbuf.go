@@ -13,6 +13,7 @@ type RingBuf[T any] struct {
 	mu   sync.RWMutex
 	rSeq int
 	wSeq int
+	base int // これより前のデータは存在しない. see Rebase
 
 	hasData chan struct{}
 }
@@ -26,10 +27,11 @@ func NewRingBuf[T any](size int) *RingBuf[T] {
 	}
 }
 
-// Write to buffer from Room.MsgLoop goroutine.
+// Write to buffer.
+// Writers must be serialized by the caller (see Client.Send).
 // It returns an error when buffer is full.
 func (b *RingBuf[T]) Write(data T) error {
-	// MsgLoopは単一goroutineで、wSeqはここでしか書き換えない
+	// 書き込みは呼び出し側で排他されていて、wSeqはここでしか書き換えない
 	// rSeqがwSeqを超えることは無いのでロックし続けなくてよい
 	b.mu.RLock()
 	r, w := b.rSeq, b.wSeq
@@ -55,6 +57,18 @@ func (b *RingBuf[T]) Write(data T) error {
 	return nil
 }
 
+// Len returns the number of unread data.
+func (b *RingBuf[T]) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.wSeq - b.rSeq
+}
+
+// Cap returns the length of buffer.
+func (b *RingBuf[T]) Cap() int {
+	return len(b.buf)
+}
+
 func (b *RingBuf[T]) HasData() <-chan struct{} {
 	return b.hasData
 }
@@ -68,14 +82,15 @@ func (b *RingBuf[T]) Read(seq int) ([]T, error) {
 	r, w := b.rSeq, b.wSeq
 	if seq < r {
 		// rewind read seq num
-		if w-seq >= size {
+		if w-seq >= size || seq < b.base {
 			b.mu.Unlock()
 			return nil, xerrors.Errorf("RingBuf too old seq num: %v, size:%v write:%v", seq, size, w)
 		}
 		b.rSeq = seq
 		r = seq
 	}
-	b.mu.Unlock() // wSeqがrSeqを超えることは無いのでロックし続けなくて良い
+	// Truncateと競合しないよう、読み出し終わるまでロックし続ける
+	defer b.mu.Unlock()
 
 	if r == w {
 		return []T{}, nil
@@ -85,10 +100,83 @@ func (b *RingBuf[T]) Read(seq int) ([]T, error) {
 	for i := 0; i < count; i++ {
 		buf[i] = b.buf[(r+i)%size]
 	}
-
-	b.mu.Lock()
 	b.rSeq = w
-	b.mu.Unlock()
 
 	return buf, nil
 }
+
+// Each calls f for each data kept in this buffer from the oldest.
+// It includes the read data kept for rewinding.
+func (b *RingBuf[T]) Each(f func(T)) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	size := len(b.buf)
+	// Writeはロックせずに最古のデータの位置に書き込むので、その位置は除く
+	start := b.wSeq - size + 1
+	if start < b.base {
+		start = b.base
+	}
+	for i := start; i < b.wSeq; i++ {
+		f(b.buf[i%size])
+	}
+}
+
+// WriteSeq returns the sequence number of the next data to be written.
+func (b *RingBuf[T]) WriteSeq() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.wSeq
+}
+
+// Truncate discards the data written at or after seq.
+// It returns false when the data at seq has already been read.
+// Writers must be serialized with Write by the caller.
+func (b *RingBuf[T]) Truncate(seq int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if seq < b.rSeq || seq >= b.wSeq {
+		return false
+	}
+	var zero T
+	for i := seq; i < b.wSeq; i++ {
+		b.buf[i%len(b.buf)] = zero
+	}
+	b.wSeq = seq
+	return true
+}
+
+// Skip marks the data before seq as read without reading them.
+// It returns false when seq is not between the read and write sequence numbers.
+func (b *RingBuf[T]) Skip(seq int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if seq <= b.rSeq || seq > b.wSeq {
+		return false
+	}
+	b.rSeq = seq
+	return true
+}
+
+// Rebase renumbers the unread data so that the first one has seq.
+// It returns false when some data has already been read.
+// Writers must be serialized with Write by the caller.
+func (b *RingBuf[T]) Rebase(seq int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rSeq != b.base {
+		return false
+	}
+	size := len(b.buf)
+	n := b.wSeq - b.rSeq
+	data := make([]T, n)
+	var zero T
+	for i := 0; i < n; i++ {
+		data[i] = b.buf[(b.rSeq+i)%size]
+		b.buf[(b.rSeq+i)%size] = zero
+	}
+	b.base, b.rSeq, b.wSeq = seq, seq, seq+n
+	for i, d := range data {
+		b.buf[(seq+i)%size] = d
+	}
+	return true
+}
@@ -125,3 +125,145 @@ func TestReadWithRewind(t *testing.T) {
 		t.Fatalf("Read(2) must error")
 	}
 }
+
+func TestTruncate(t *testing.T) {
+	buf := NewEvBuf(5)
+
+	for i := 0; i < 4; i++ {
+		if e := buf.Write(binary.NewRegularEvent(binary.EvType(i), nil)); e != nil {
+			t.Fatalf("Write error: %v", e)
+		}
+	}
+	buf.Read(0)
+	for i := 4; i < 7; i++ {
+		if e := buf.Write(binary.NewRegularEvent(binary.EvType(i), nil)); e != nil {
+			t.Fatalf("Write error: %v", e)
+		}
+	}
+
+	if buf.Truncate(3) {
+		t.Fatalf("Truncate(3) must fail: already read")
+	}
+	if buf.Truncate(7) {
+		t.Fatalf("Truncate(7) must fail: not written")
+	}
+	if !buf.Truncate(5) {
+		t.Fatalf("Truncate(5) failed")
+	}
+	if seq := buf.WriteSeq(); seq != 5 {
+		t.Fatalf("WriteSeq() = %v, wants 5", seq)
+	}
+
+	r, e := buf.Read(4)
+	if e != nil {
+		t.Fatalf("Read(4) error: %v", e)
+	}
+	wants := []*binary.RegularEvent{binary.NewRegularEvent(4, nil)}
+	if !reflect.DeepEqual(r, wants) {
+		t.Fatalf("Read(4) %v, wants %v", r, wants)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	buf := NewEvBuf(5)
+
+	for i := 0; i < 4; i++ {
+		if e := buf.Write(binary.NewRegularEvent(binary.EvType(i), nil)); e != nil {
+			t.Fatalf("Write error: %v", e)
+		}
+	}
+	buf.Read(0)
+	for i := 4; i < 7; i++ {
+		if e := buf.Write(binary.NewRegularEvent(binary.EvType(i), nil)); e != nil {
+			t.Fatalf("Write error: %v", e)
+		}
+	}
+
+	if buf.Skip(4) {
+		t.Fatalf("Skip(4) must fail: already read")
+	}
+	if buf.Skip(8) {
+		t.Fatalf("Skip(8) must fail: not written")
+	}
+	if !buf.Skip(6) {
+		t.Fatalf("Skip(6) failed")
+	}
+
+	r, e := buf.Read(6)
+	if e != nil {
+		t.Fatalf("Read(6) error: %v", e)
+	}
+	wants := []*binary.RegularEvent{binary.NewRegularEvent(6, nil)}
+	if !reflect.DeepEqual(r, wants) {
+		t.Fatalf("Read(6) %v, wants %v", r, wants)
+	}
+}
+
+func TestRebase(t *testing.T) {
+	buf := NewEvBuf(5)
+
+	// 空のバッファは任意の位置から始められる
+	if !buf.Rebase(10) {
+		t.Fatalf("Rebase(10) failed")
+	}
+	for i := 0; i < 2; i++ {
+		if e := buf.Write(binary.NewRegularEvent(binary.EvType(i), nil)); e != nil {
+			t.Fatalf("Write error: %v", e)
+		}
+	}
+	if seq := buf.WriteSeq(); seq != 12 {
+		t.Fatalf("WriteSeq() = %v, wants 12", seq)
+	}
+	if _, e := buf.Read(9); e == nil {
+		t.Fatalf("Read(9) must error: before base")
+	}
+
+	// 未読のデータを付け替える
+	if !buf.Rebase(20) {
+		t.Fatalf("Rebase(20) failed")
+	}
+	r, e := buf.Read(20)
+	if e != nil {
+		t.Fatalf("Read(20) error: %v", e)
+	}
+	wants := []*binary.RegularEvent{binary.NewRegularEvent(0, nil), binary.NewRegularEvent(1, nil)}
+	if !reflect.DeepEqual(r, wants) {
+		t.Fatalf("Read(20) %v, wants %v", r, wants)
+	}
+	if seq := buf.WriteSeq(); seq != 22 {
+		t.Fatalf("WriteSeq() = %v, wants 22", seq)
+	}
+
+	if buf.Rebase(30) {
+		t.Fatalf("Rebase(30) must fail: already read")
+	}
+}
+
+func TestEach(t *testing.T) {
+	buf := NewRingBuf[int](4)
+	each := func() []int {
+		data := []int{}
+		buf.Each(func(d int) { data = append(data, d) })
+		return data
+	}
+
+	for i := 1; i <= 3; i++ {
+		buf.Write(i)
+	}
+	if got, want := each(), []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Each %v, wants %v", got, want)
+	}
+
+	// 読み出し済みのデータも再送用に保持している
+	buf.Read(0)
+	buf.Write(4)
+	buf.Write(5)
+	if got, want := each(), []int{3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Each %v, wants %v", got, want)
+	}
+
+	buf.Truncate(4)
+	if got, want := each(), []int{2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Each after Truncate %v, wants %v", got, want)
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -33,22 +34,142 @@ func TestLoad(t *testing.T) {
 	game := GameConf{
 		Hostname:   "wsnetgame.localhost",
 		PublicName: hostname,
+		Tag:        "canary",
+
+		GRPCKeepaliveTime:    Duration(time.Minute),
+		GRPCKeepaliveTimeout: Duration(10 * time.Second),
+		GRPCKeepaliveMinTime: Duration(5 * time.Second),
+
 		RetryCount: 3,
 		MaxRoomNum: 999999,
 
-		MaxRooms:   123,
-		MaxClients: 1234,
+		RoomNumberAllocator: "sequence",
+
+		MaxRooms:     123,
+		MaxClients:   1234,
+		PrewarmRooms: 8,
 
 		DefaultMaxPlayers: 10,
 		DefaultDeadline:   5,
 		DefaultLoglevel:   2,
 
+		DefaultPingInterval: Duration(time.Second * 2),
+		MinPingInterval:     Duration(time.Millisecond * 500),
+
 		HeartBeatInterval: Duration(time.Second * 10),
 
+		RoomInfoFlushInterval: Duration(time.Second),
+		RoomInfoBatchSize:     100,
+		PlayerLogQueueSize:    10000,
+		DbRetryMaxInterval:    Duration(time.Minute),
+
+		SessionResume:       true,
+		SessionSaveInterval: Duration(time.Second * 2),
+		SessionResumeWindow: Duration(time.Minute),
+		SessionKey:          "testsessionkey",
+
+		MaxWatcherDelay: Duration(time.Minute * 5),
+
+		MaxRoomBandwidth: 1048576,
+		MaxHistorySize:   50,
+
+		SwitchMasterTimeout:  Duration(time.Second * 3),
+		RejoinConfirmTimeout: Duration(time.Second * 2),
+
+		MaxEncryptedSize: 4096,
+		EncryptedRate:    10.5,
+		EncryptedBurst:   60,
+
+		MaxPauseDuration: Duration(time.Minute * 3),
+		MaxRoomRelaySize: 1024,
+		MaxPropsSize:     65536,
+		HeavyRooms:       5,
+
+		UnmarshalMaxCount:  100,
+		UnmarshalMaxDepth:  8,
+		UnmarshalMaxValues: 1000,
+		UnmarshalMaxBytes:  65536,
+
+		MaxRoomLifetime:    Duration(time.Hour * 6),
+		AppMaxRoomLifetime: map[string]Duration{"event": Duration(time.Hour * 24)},
+
+		RoomCleanupInterval:    Duration(time.Second * 30),
+		RoomCleanupHostTimeout: Duration(time.Minute * 5),
+
+		RetentionPurgeInterval:   Duration(time.Minute * 30),
+		PlayerLogRetentionDays:   90,
+		PlayerStatsRetentionDays: 365,
+
+		MaxWaitList:     50,
+		WaitListTimeout: Duration(time.Second * 15),
+
+		AppTLS: map[string]AppTLSConf{
+			"event": {
+				Cert:        "/etc/wsnet2/event.crt",
+				Key:         "/etc/wsnet2/event.key",
+				ServerNames: []string{"event.example.com"},
+			},
+		},
+
+		Bridge: BridgeConf{
+			NatsURL:        "nats://localhost:4222",
+			Prefix:         "wsnet2",
+			Events:         []string{"Joined", "Left"},
+			CommandSubject: "wsnet2.cmd",
+			QueueSize:      10000,
+		},
+
+		Relay: RelayConf{
+			RedisURL:      "redis://localhost:6379/1",
+			ChannelPrefix: "wsnet2:hub:",
+			QueueSize:     10000,
+			Primary:       true,
+			FallbackDelay: Duration(200 * time.Millisecond),
+		},
+
+		Moderation: ModerationConf{
+			URL:       "http://localhost:8088/scan",
+			Kinds:     []string{"Broadcast", "Targets"},
+			Workers:   8,
+			Timeout:   Duration(time.Second * 5),
+			QueueSize: 10000,
+		},
+
+		WebsocketMiddleware: WebsocketMiddlewareConf{
+			SetHeaders:          map[string]string{"X-Wsnet2-Server": "game", "X-Debug": ""},
+			AccessLog:           true,
+			AuthURL:             "http://localhost:8089/auth",
+			AuthHeaders:         []string{"Authorization", "Cookie"},
+			AuthResponseHeaders: []string{"X-User-Id"},
+			AuthTimeout:         Duration(time.Second * 3),
+		},
+
+		Chaos: ChaosConf{
+			Enabled:       true,
+			WriteLatency:  Duration(time.Millisecond * 100),
+			WriteDropRate: 0.01,
+			PeerCloseRate: 0.001,
+			DBErrorRate:   0.1,
+		},
+		DebugNetSim: true,
+
 		ClientConf: ClientConf{
 			EventBufSize:   512,
 			WaitAfterClose: Duration(time.Second * 60),
 			AuthKeyLen:     32,
+
+			NoReconnectCloseCodes: []uint32{1000, 1001, 4000},
+			MACAlgorithms:         []string{"hmac-sha512", "hmac-sha1"},
+			AppMACAlgorithms:      map[string][]string{"proxied": {"none"}},
+			MsgSeqWindow:          32,
+
+			SlowConsumerLatency: Duration(time.Millisecond * 500),
+			SlowConsumerLag:     64,
+			SlowConsumerStrikes: 3,
+			SlowConsumerPolicy:  2,
+
+			SSEAllowOrigins: []string{"https://example.com"},
+			DebugJSONEvents: true,
 		},
 
 		LogConf: LogConf{
@@ -75,6 +196,25 @@ func TestLoad(t *testing.T) {
 		AuthDataExpire: Duration(time.Second * 10),
 		ApiTimeout:     Duration(time.Second * 5),
 		HubMaxWatchers: 10000,
+		IndexedProps: map[string][]string{
+			"testapp": {"mode", "stage"},
+		},
+		MaxInviteExpire:  Duration(time.Hour),
+		InviteURLFormat:  "https://example.com/invite?t=%s",
+		WebsocketProxy:   "wss://wsnet2.example.com",
+		LatencyMargin:    Duration(30 * time.Millisecond),
+		CanaryTag:        "canary",
+		CanaryPercentage: 5,
+		AppCanaryPercentage: map[string]int{
+			"testapp": 50,
+		},
+		AdminKey:           "adminkey",
+		AppKeyGracePeriod:  Duration(2 * time.Hour),
+		PushRate:           10.5,
+		PushBurst:          200,
+		RateLimitRedisURL:  "redis://localhost:6379/2",
+		GameDownRetryAfter: Duration(10 * time.Second),
+		AsyncCreateTimeout: Duration(20 * time.Second),
 		LogConf: LogConf{
 			LogStdoutConsole: false,
 			LogStdoutLevel:   4,
@@ -104,3 +244,76 @@ func TestDbConf_DSN(t *testing.T) {
 		t.Fatalf("DSN = %s, wants %s", dsn, want)
 	}
 }
+
+func TestClientConf_MACAlgorithmsFor(t *testing.T) {
+	c := ClientConf{
+		MACAlgorithms:    []string{"hmac-sha256"},
+		AppMACAlgorithms: map[string][]string{"proxied": {"none"}},
+	}
+	if algs := c.MACAlgorithmsFor("proxied"); !reflect.DeepEqual(algs, []string{"none"}) {
+		t.Fatalf("MACAlgorithmsFor(proxied) = %v, wants [none]", algs)
+	}
+	if algs := c.MACAlgorithmsFor("other"); !reflect.DeepEqual(algs, []string{"hmac-sha256"}) {
+		t.Fatalf("MACAlgorithmsFor(other) = %v, wants [hmac-sha256]", algs)
+	}
+}
+
+func TestGameConf_MaxRoomLifetimeFor(t *testing.T) {
+	c := GameConf{
+		MaxRoomLifetime:    Duration(6 * time.Hour),
+		AppMaxRoomLifetime: map[string]Duration{"event": 0},
+	}
+	if d := c.MaxRoomLifetimeFor("event"); d != 0 {
+		t.Fatalf("MaxRoomLifetimeFor(event) = %v, wants 0", d)
+	}
+	if d := c.MaxRoomLifetimeFor("other"); d != 6*time.Hour {
+		t.Fatalf("MaxRoomLifetimeFor(other) = %v, wants 6h", d)
+	}
+}
+
+func TestLobbyConf_CanaryPercentageFor(t *testing.T) {
+	c := LobbyConf{
+		CanaryPercentage:    5,
+		AppCanaryPercentage: map[string]int{"event": 0},
+	}
+	if p := c.CanaryPercentageFor("event"); p != 0 {
+		t.Fatalf("CanaryPercentageFor(event) = %v, wants 0", p)
+	}
+	if p := c.CanaryPercentageFor("other"); p != 5 {
+		t.Fatalf("CanaryPercentageFor(other) = %v, wants 5", p)
+	}
+}
+
+func TestGameConf_AppForServerName(t *testing.T) {
+	c := GameConf{
+		AppTLS: map[string]AppTLSConf{
+			"event": {ServerNames: []string{"event.example.com", "event2.example.com"}},
+			"other": {},
+		},
+	}
+	tests := map[string]string{
+		"event2.example.com": "event",
+		"EVENT.example.com":  "event",
+		"game.example.com":   "",
+		"":                   "",
+	}
+	for name, want := range tests {
+		if app := c.AppForServerName(name); app != want {
+			t.Errorf("AppForServerName(%q) = %q, wants %q", name, app, want)
+		}
+	}
+}
+
+func TestGameConf_CleanupHostTimeout(t *testing.T) {
+	c := GameConf{
+		RoomCleanupHostTimeout: Duration(time.Minute),
+		SessionResumeWindow:    Duration(time.Minute * 3),
+	}
+	if d := c.CleanupHostTimeout(); d != time.Minute {
+		t.Errorf("CleanupHostTimeout = %v, wants %v", d, time.Minute)
+	}
+	c.SessionResume = true
+	if d := c.CleanupHostTimeout(); d != time.Minute*3 {
+		t.Errorf("CleanupHostTimeout with session_resume = %v, wants %v", d, time.Minute*3)
+	}
+}
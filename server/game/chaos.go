@@ -0,0 +1,96 @@
+package game
+
+import (
+	"io"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/config"
+)
+
+// 障害の注入 (試験用):
+// config.ChaosConf を有効にすると、websocketの送信に遅延、欠落、切断を、DBの書き込みに失敗を確率的に起こす.
+// クライアントの再接続や監視を、制御された障害の下で確かめるためのもので、本番環境では有効にしない.
+// 送信を捨てるとクライアントはイベントの通し番号の欠落を検出して再接続する.
+
+// ErrChaos : 注入した障害のエラー
+var ErrChaos = xerrors.New("chaos injected")
+
+var chaos atomic.Pointer[config.ChaosConf]
+
+// SetChaos : 障害の注入を設定する. サーバの起動時に呼ぶ
+func SetChaos(conf *config.ChaosConf) {
+	if !conf.Enabled {
+		chaos.Store(nil)
+		return
+	}
+	chaos.Store(conf)
+}
+
+func chaosHit(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// chaosDBError : DBの書き込みを失敗させるならErrChaosを返す
+func chaosDBError() error {
+	if c := chaos.Load(); c != nil && chaosHit(c.DBErrorRate) {
+		return xerrors.Errorf("db write: %w", ErrChaos)
+	}
+	return nil
+}
+
+// chaosConn : 送信に障害を注入する接続
+type chaosConn struct {
+	peerConn
+	conf *config.ChaosConf
+}
+
+var _ peerConn = &chaosConn{}
+
+// withChaos : 障害の注入が有効なら送信に障害を注入する接続にする
+func withChaos(conn peerConn) peerConn {
+	if c := chaos.Load(); c != nil {
+		return &chaosConn{conn, c}
+	}
+	return conn
+}
+
+// inject : 送信の前に遅延を入れ、切断するならエラー、捨てるならtrueを返す
+func (c *chaosConn) inject() (bool, error) {
+	if d := time.Duration(c.conf.WriteLatency); d > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(d))))
+	}
+	if chaosHit(c.conf.PeerCloseRate) {
+		c.peerConn.Close()
+		return false, xerrors.Errorf("peer closed: %w", ErrChaos)
+	}
+	return chaosHit(c.conf.WriteDropRate), nil
+}
+
+func (c *chaosConn) WriteMessage(messageType int, data []byte) error {
+	drop, err := c.inject()
+	if err != nil || drop {
+		return err
+	}
+	return c.peerConn.WriteMessage(messageType, data)
+}
+
+func (c *chaosConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	drop, err := c.inject()
+	if err != nil {
+		return nil, err
+	}
+	if drop {
+		return discardWriter{}, nil
+	}
+	return c.peerConn.NextWriter(messageType)
+}
+
+// discardWriter : 捨てた送信のWriter
+type discardWriter struct{}
+
+func (discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (discardWriter) Close() error                { return nil }
@@ -0,0 +1,86 @@
+package game
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/shiguredo/websocket"
+	"golang.org/x/xerrors"
+
+	"wsnet2/config"
+)
+
+type fakePeerConn struct {
+	written [][]byte
+	closed  bool
+}
+
+func (c *fakePeerConn) ReadMessage() (int, []byte, error) { return 0, nil, io.EOF }
+func (c *fakePeerConn) WriteMessage(_ int, data []byte) error {
+	c.written = append(c.written, data)
+	return nil
+}
+func (c *fakePeerConn) NextWriter(int) (io.WriteCloser, error) {
+	return nil, xerrors.New("not implemented")
+}
+func (c *fakePeerConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *fakePeerConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestChaosConn(t *testing.T) {
+	defer SetChaos(&config.ChaosConf{})
+
+	conn := &fakePeerConn{}
+	SetChaos(&config.ChaosConf{})
+	if c := withChaos(conn); c != conn {
+		t.Fatalf("disabled chaos must not wrap the conn: %T", c)
+	}
+
+	SetChaos(&config.ChaosConf{Enabled: true, WriteDropRate: 1})
+	c := withChaos(conn)
+	if err := c.WriteMessage(websocket.BinaryMessage, []byte("a")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	if len(conn.written) != 0 {
+		t.Fatalf("message must be dropped: %v", conn.written)
+	}
+	if w, err := c.NextWriter(websocket.BinaryMessage); err != nil {
+		t.Fatalf("NextWriter: %v", err)
+	} else if _, ok := w.(discardWriter); !ok {
+		t.Fatalf("writer must discard: %T", w)
+	}
+
+	SetChaos(&config.ChaosConf{Enabled: true, PeerCloseRate: 1})
+	c = withChaos(conn)
+	if err := c.WriteMessage(websocket.BinaryMessage, []byte("b")); !xerrors.Is(err, ErrChaos) {
+		t.Fatalf("WriteMessage must fail: %v", err)
+	}
+	if !conn.closed || len(conn.written) != 0 {
+		t.Fatalf("conn must be closed: closed=%v written=%v", conn.closed, conn.written)
+	}
+
+	SetChaos(&config.ChaosConf{Enabled: true, WriteLatency: config.Duration(time.Millisecond)})
+	c = withChaos(conn)
+	if err := c.WriteMessage(websocket.BinaryMessage, []byte("c")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	if len(conn.written) != 1 {
+		t.Fatalf("message must be written: %v", conn.written)
+	}
+}
+
+func TestChaosDBError(t *testing.T) {
+	defer SetChaos(&config.ChaosConf{})
+
+	SetChaos(&config.ChaosConf{Enabled: true})
+	if err := chaosDBError(); err != nil {
+		t.Fatalf("chaosDBError: %v", err)
+	}
+	SetChaos(&config.ChaosConf{Enabled: true, DBErrorRate: 1})
+	if err := chaosDBError(); !xerrors.Is(err, ErrChaos) {
+		t.Fatalf("chaosDBError must fail: %v", err)
+	}
+}
@@ -1,10 +1,9 @@
 package game
 
 import (
-	"crypto/hmac"
-	"crypto/sha1"
 	"hash"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/xerrors"
@@ -14,16 +13,20 @@ import (
 	"wsnet2/binary"
 	"wsnet2/common"
 	"wsnet2/log"
+	"wsnet2/metrics"
 	"wsnet2/pb"
 )
 
+// backpressureRatio : evbufがこの割合まで埋まったらEvTypeBackpressureを送る
+const backpressureRatio = 0.75
+
 type ClientID string
 
 type Client struct {
 	*pb.ClientInfo
 	room IRoom
 
-	isPlayer  bool
+	isPlayer  atomic.Bool // 観戦者からPlayerに昇格することがある. see: room_promote.go
 	nodeCount uint32
 
 	props binary.Dict
@@ -33,7 +36,38 @@ type Client struct {
 	done        chan struct{}
 	newDeadline chan time.Duration
 
-	evbuf *common.RingBuf[*binary.RegularEvent]
+	// paused : 部屋が一時停止中でタイムアウトしない. 変更はpauseChangedでMsgLoopに通知する. see: room_pause.go
+	paused       atomic.Bool
+	pauseChanged chan struct{}
+
+	// deadline : クライアントに伝えたタイムアウト時間. EvTypePeerReadyでクライアントに伝える.
+	// MsgLoopは変更後に次のメッセージが届くまで旧deadlineを使う. see: room_deadline.go
+	deadline atomic.Int64
+
+	evbuf  *common.RingBuf[*binary.RegularEvent]
+	muSend sync.Mutex // evbufへの書き込みは複数goroutineから行われる
+
+	// hubRelay : Hubのクライアントのとき、evbufに書き込んだイベントをwebsocket以外でも中継する
+	hubRelay HubRelay
+
+	lastBackpressure atomic.Int64 // 最後にEvTypeBackpressureを送った時刻 (unixtime nano)
+
+	traffic Traffic
+
+	// stats : Playerとして在室中の統計. 観戦者やボットはnil. see: room_stats.go
+	stats atomic.Pointer[playerStats]
+
+	// delivery : イベントの配信の遅延と再送数. see: delivery.go
+	delivery deliveryStats
+
+	// historyEnd : 入室時点の部屋の履歴の総数. これより前の履歴を取得できる
+	historyEnd int
+
+	// blocked : メッセージを受け取らない送信元. 中継goroutineからも参照する. see: room_blocklist.go
+	blocked atomic.Pointer[map[ClientID]struct{}]
+
+	// encrypted : MsgTypeEncrypted, MsgTypeKeyExchange の送信頻度. 送信者の中継goroutineからのみ参照する. see: room_encrypted.go
+	encrypted tokenBucket
 
 	mu           sync.RWMutex
 	msgSeqNum    int
@@ -43,61 +77,111 @@ type Client struct {
 	connectCount int
 
 	authKey string
+	macKey  string // セッション保存用. see: session.go
+	macAlg  string // Msgの認証に使うHMACアルゴリズム (see auth.MACAlgorithm*)
 	hmac    hash.Hash
 
+	// inviteExpire : 招待で入室したときの招待の期限 (unixtime nano). 最初のpeerを接続するまで確認する. see: room_invite.go
+	inviteExpire atomic.Int64
+
+	// resumed : 再起動前のセッションから復元され、まだ再接続されていない. resumeEvSeqは保存されていたイベント番号
+	resumed     bool
+	resumeEvSeq int
+
+	// requestId : 入室したgRPCリクエストのID (see wsnet2/requestid). 入室後は変わらない
+	requestId string
+
 	logger log.Logger
 
 	evErr chan error
 }
 
 func NewPlayer(info *pb.ClientInfo, macKey string, room IRoom) (*Client, ErrorWithCode) {
-	return newClient(info, macKey, room, true)
+	alg, err := selectMACAlgorithm(info, room)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(info, macKey, alg, room, true)
 }
 
 func NewWatcher(info *pb.ClientInfo, macKey string, room IRoom) (*Client, ErrorWithCode) {
-	return newClient(info, macKey, room, false)
+	alg, err := selectMACAlgorithm(info, room)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(info, macKey, alg, room, false)
+}
+
+// selectMACAlgorithm : クライアントの申告したHMACアルゴリズムから、部屋で使えるものを選ぶ
+func selectMACAlgorithm(info *pb.ClientInfo, room IRoom) (string, ErrorWithCode) {
+	alg, err := auth.SelectMACAlgorithm(info.GetCaps().GetMacAlgorithms(), room.MACAlgorithms())
+	if err != nil {
+		return "", WithCode(xerrors.Errorf("SelectMACAlgorithm: %w", err), codes.InvalidArgument)
+	}
+	return alg, nil
 }
 
-func newClient(info *pb.ClientInfo, macKey string, room IRoom, isPlayer bool) (*Client, ErrorWithCode) {
+func newClient(info *pb.ClientInfo, macKey, macAlg string, room IRoom, isPlayer bool) (*Client, ErrorWithCode) {
+	mac, err := auth.NewMsgMAC(macAlg, macKey)
+	if err != nil {
+		return nil, WithCode(xerrors.Errorf("NewMsgMAC: %w", err), codes.InvalidArgument)
+	}
 	props, iProps, err := common.InitProps(info.Props)
 	if err != nil {
 		return nil, WithCode(
 			xerrors.Errorf("InitProps: %w", err),
 			codes.InvalidArgument)
 	}
+	// 対応機能はMasterなどから参照できるようpropsに載せる. クライアントは直接設定できない
+	_, hasCaps := props[binary.ClientCapsKey]
+	if info.Caps != nil {
+		props[binary.ClientCapsKey] = binary.MarshalCaps(info.Caps)
+	} else {
+		delete(props, binary.ClientCapsKey)
+	}
+	if hasCaps || info.Caps != nil {
+		iProps = binary.MarshalDict(props)
+	}
 	info.Props = iProps
+	// ボットはMsgAddBotでのみ追加する
+	info.Bot = false
 	c := &Client{
 		ClientInfo: info,
 		room:       room,
-		isPlayer:   isPlayer,
 		nodeCount:  1,
 
-		props: props,
+		props: internProps(props),
 
 		removed:     make(chan struct{}),
 		done:        make(chan struct{}),
 		newDeadline: make(chan time.Duration, 1),
 
+		pauseChanged: make(chan struct{}, 1),
+
 		evbuf: common.NewRingBuf[*binary.RegularEvent](room.ClientConf().EventBufSize),
 
 		waitPeer:  make(chan *Peer, 1),
 		renewPeer: make(chan struct{}, 1),
 
 		authKey: RandomHex(room.ClientConf().AuthKeyLen),
-		hmac:    hmac.New(sha1.New, []byte(macKey)),
+		macKey:  macKey,
+		macAlg:  macAlg,
+		hmac:    mac,
 
 		logger: room.Logger().With(log.KeyClient, info.Id),
 
 		evErr: make(chan error),
 	}
+	c.isPlayer.Store(isPlayer)
 	if info.IsHub {
 		c.nodeCount = 0
 	}
 
 	room.WaitGroup().Add(1)
 
-	go c.MsgLoop(room.Deadline())
-	go c.EventLoop()
+	deadline := room.Deadline()
+	room.Go(func() { c.MsgLoop(deadline) })
+	room.Go(c.EventLoop)
 
 	return c, nil
 }
@@ -110,10 +194,22 @@ func (c *Client) RoomID() RoomID {
 	return c.room.ID()
 }
 
+// addTraffic : Peerが送受信したバイト数をClientと部屋に加算する
+func (c *Client) addTraffic(in, out int) {
+	c.traffic.In.Add(int64(in))
+	c.traffic.Out.Add(int64(out))
+	c.room.AddTraffic(in, out)
+}
+
 func (c *Client) AuthKey() string {
 	return c.authKey
 }
 
+// MACAlgorithm : Msgの認証に使うHMACアルゴリズム
+func (c *Client) MACAlgorithm() string {
+	return c.macAlg
+}
+
 func (c *Client) NodeCount() uint32 {
 	return c.nodeCount
 }
@@ -122,21 +218,44 @@ func (c *Client) Logger() log.Logger {
 	return c.logger
 }
 
+// setRequestId : 入室したリクエストのIDをログとplayer_logに付ける. 部屋のgoroutineから入室時に呼ぶ
+func (c *Client) setRequestId(id string) {
+	if id == "" {
+		return
+	}
+	c.requestId = id
+	c.logger = c.logger.With(log.KeyRequestId, id)
+}
+
 func (c *Client) ValidAuthData(authData string) error {
 	// clientのtimestampは信用できないのでhashだけ検証
-	_, err := auth.ValidAuthDataHash(authData, c.authKey, c.Id)
-	return err
+	if _, err := auth.ValidAuthDataHash(authData, c.authKey, c.Id); err != nil {
+		return err
+	}
+	if exp := c.inviteExpire.Load(); exp != 0 && c.room.Clock().Now().UnixNano() > exp {
+		return xerrors.Errorf("invite expired before attach: %v", time.Unix(0, exp))
+	}
+	return nil
 }
 
 // MsgLoop goroutine.
 func (c *Client) MsgLoop(deadline time.Duration) {
+	c.deadline.Store(int64(deadline))
+	dt := deadlineTransition{current: deadline}
 	var peerMsgCh <-chan binary.Msg
 	var curPeer *Peer
-	t := time.NewTimer(deadline)
+	t := c.room.Clock().NewTimer(deadline)
+	paused := false
+	// stopTimer : 一時停止中はタイマーを止めてあるので何もしない
+	stopTimer := func() {
+		if !paused && !t.Stop() {
+			<-t.C()
+		}
+	}
 loop:
 	for {
 		select {
-		case <-t.C:
+		case <-t.C():
 			if c.connectCount == 0 {
 				// lobbyに繋がるがgameに繋げないのは何かある
 				c.logger.Errorf("client timeout: %v connectCount=%v", c.Id, c.connectCount)
@@ -148,43 +267,58 @@ loop:
 
 		case <-c.room.Done():
 			c.logger.Debugf("client room done: %v", c.Id)
-			curPeer.Close("room closed")
-			if !t.Stop() {
-				<-t.C
-			}
+			curPeer.Close(binary.CloseReasonRoomClosed, "room closed")
+			stopTimer()
 			break loop
 
 		case <-c.removed:
 			c.logger.Debugf("client removed: %v", c.Id)
-			if !t.Stop() {
-				<-t.C
-			}
+			stopTimer()
 			break loop
 
 		case newDeadline := <-c.newDeadline:
-			if !t.Stop() {
-				<-t.C
-			}
 			// 突然短くされてもclientが把握できないので
-			// 変更直後だけ旧deadline分の猶予をもたせる.
-			t.Reset(deadline + newDeadline)
-			deadline = newDeadline
+			// 通知して次のメッセージが届くまでは旧deadlineでタイムアウトを判定する.
+			dt.change(newDeadline)
+			c.deadline.Store(int64(newDeadline))
+			interval := clientPingInterval(c.room.PingInterval(), newDeadline)
+			c.logger.Debugf("client deadline changed: %v deadline=%v ping=%v", c.Id, newDeadline, interval)
+			c.SendSystemEvent(binary.NewEvDeadlineChanged(uint32(interval/time.Millisecond), uint32(newDeadline/time.Millisecond)))
+
+		case <-c.pauseChanged:
+			p := c.paused.Load()
+			if p == paused {
+				continue
+			}
+			c.logger.Debugf("client pause changed: %v paused=%v", c.Id, p)
+			if p {
+				stopTimer()
+				paused = true
+			} else {
+				// 再開直後に通信できていなくてもタイムアウトしないよう、deadline分の猶予をもたせる
+				paused = false
+				t.Reset(dt.current)
+			}
 
 		case <-c.renewPeer:
-			go c.drainMsg(peerMsgCh)
+			ch := peerMsgCh
+			c.room.Go(func() { c.drainMsg(ch) })
 			c.mu.Lock()
 			if c.peer == nil {
 				peerMsgCh = nil
 				curPeer = nil
-				if c.isPlayer {
+				if c.isPlayer.Load() {
 					c.room.Repo().PlayerLog(c, PlayerLogDetach)
 				}
+				if st := c.stats.Load(); st != nil {
+					st.disconnects.Add(1)
+				}
 			} else {
 				c.connectCount++
 				c.logger.Infof("new peer attached: %v peer=%p", c.Id, c.peer)
 				peerMsgCh = c.peer.MsgCh()
 				curPeer = c.peer
-				if c.isPlayer {
+				if c.isPlayer.Load() {
 					c.room.Repo().PlayerLog(c, PlayerLogAttach)
 				}
 				// つなげて切るだけのクライアントをタイムアウトさせるため、t.Resetしない
@@ -204,6 +338,7 @@ loop:
 			if err != nil {
 				// おかしなデータを送ってくるクライアントは遮断する
 				c.logger.Errorf("client invalid msg: %v %+v", c.Id, err)
+				curPeer.SendSystemEvent(binary.NewEvError(binary.ErrorCodeSchemaViolation, err.Error()))
 				c.room.SendMessage(
 					&MsgClientError{
 						Sender: c,
@@ -216,25 +351,36 @@ loop:
 
 				c.mu.Lock()
 				cSeq := c.msgSeqNum
-				valid := seq == cSeq+1
-				if valid {
+				rejected := checkMsgSeq(seq, cSeq, c.room.ClientConf().MsgSeqWindow)
+				if rejected == "" {
 					c.msgSeqNum = seq
 				}
 				c.mu.Unlock()
 
-				if !valid {
+				if rejected != "" {
+					metrics.MsgSeqRejected.Add(rejected, 1)
+				}
+				if rejected == msgSeqDuplicate {
+					c.logger.Debugf("duplicated msg: %v seq=%d, last=%d", c.Id, seq, cSeq)
+					continue
+				}
+				if rejected != "" {
 					// 再接続時の再送に期待して切断
-					err := xerrors.Errorf("invalid sequence num: %d, wants %d", seq, cSeq+1)
+					err := xerrors.Errorf("invalid sequence num (%v): %d, wants %d", rejected, seq, cSeq+1)
 					c.logger.Warnf("client msg: %v %+v", c.Id, err)
 					c.DetachAndClosePeer(curPeer, err)
 					continue
 				}
+				if st := c.stats.Load(); st != nil {
+					st.msgSent.Add(1)
+				}
 			}
-			if !t.Stop() {
-				<-t.C
-			}
+			stopTimer()
 			c.room.SendMessage(msg)
-			t.Reset(deadline)
+			next := dt.received()
+			if !paused {
+				t.Reset(next)
+			}
 
 		case err := <-c.evErr:
 			c.room.SendMessage(
@@ -257,6 +403,27 @@ loop:
 	c.room.WaitGroup().Done()
 }
 
+// checkMsgSeq の拒否理由 (metrics.MsgSeqRejected のキー)
+const (
+	msgSeqDuplicate = "duplicate"
+	msgSeqTooOld    = "too_old"
+	msgSeqGap       = "gap"
+)
+
+// checkMsgSeq : 受信したMsgの通し番号seqを検査し、受理しないときは理由を返す.
+// cSeqは受理済みの最後の番号. cSeqからwindow以内の番号は再送による重複とみなす.
+func checkMsgSeq(seq, cSeq, window int) string {
+	switch {
+	case seq == cSeq+1:
+		return ""
+	case seq > cSeq+1:
+		return msgSeqGap
+	case seq > cSeq-window:
+		return msgSeqDuplicate
+	}
+	return msgSeqTooOld
+}
+
 func (c *Client) drainMsg(msgCh <-chan binary.Msg) {
 	if msgCh == nil {
 		return
@@ -265,25 +432,118 @@ func (c *Client) drainMsg(msgCh <-chan binary.Msg) {
 	}
 }
 
+// setPaused : 部屋の一時停止中はタイムアウトさせない.
+// MsgLoopの終了後に呼ばれてもブロックしない.
+func (c *Client) setPaused(paused bool) {
+	c.paused.Store(paused)
+	select {
+	case c.pauseChanged <- struct{}{}:
+	default:
+	}
+}
+
 // RoomのMsgLoopから呼ばれる
 func (c *Client) Removed(cause string) {
+	if p := c.remove(cause); p != nil {
+		go p.Close(binary.CloseReasonRemoved, cause)
+	}
+}
+
+// Displaced : 同じクライアントIDの別の接続に置き換えられて退室した.
+// EvDisplacedで理由を通知してからwebsocketを閉じる. RoomのMsgLoopから呼ばれる
+func (c *Client) Displaced(reason binary.DisplacedReason, cause string) {
+	if p := c.remove(cause); p != nil {
+		go func() {
+			p.SendSystemEvent(binary.NewEvDisplaced(reason))
+			p.Close(binary.CloseReasonDisplaced, cause)
+		}()
+	}
+}
+
+// remove : 退室済みにして、接続中のPeerを返す
+func (c *Client) remove(cause string) *Peer {
 	close(c.removed)
 	c.removeCause = cause
 
 	c.mu.RLock()
-	p := c.peer
-	c.mu.RUnlock()
-	if p != nil {
-		go p.Close(c.removeCause)
-	}
+	defer c.mu.RUnlock()
+	return c.peer
 }
 
-// RoomのMsgLoopから呼ばれる
+// canConfirmRejoin : 接続中で、別の接続からの入室の確認(EvRejoinRequested)に応答できるか
+func (c *Client) canConfirmRejoin() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.peer != nil && c.peer.rejoinConfirm
+}
+
+// canReceiveRedaction : 接続中で、EvTypeRedactedを受け取れるか
+func (c *Client) canReceiveRedaction() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.peer != nil && c.peer.redaction
+}
+
+// connected : Peerが接続中か
+func (c *Client) connected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.peer != nil
+}
+
+// RoomのMsgLoopと中継goroutineから呼ばれる
 func (c *Client) Send(e *binary.RegularEvent) error {
-	return c.evbuf.Write(e)
+	_, err := c.sendSeq(e)
+	return err
+}
+
+// sendSeq : Sendと同じ. 書き込んだevbuf上の位置を返す.
+func (c *Client) sendSeq(e *binary.RegularEvent) (int, error) {
+	c.muSend.Lock()
+	defer c.muSend.Unlock()
+	seq := c.evbuf.WriteSeq()
+	if err := c.evbuf.Write(e); err != nil {
+		return 0, err
+	}
+	if st := c.stats.Load(); st != nil {
+		st.evReceived.Add(1)
+	}
+	if c.hubRelay != nil {
+		// sequence numberはevbuf上の位置+1 (see: Peer.SendEvents)
+		c.hubRelay.Relay(c.Id, seq+1, e)
+	}
+	if float64(c.evbuf.Len()) >= float64(c.evbuf.Cap())*backpressureRatio {
+		c.backpressure(binary.BackpressureEventQueue)
+	}
+	return seq, nil
+}
+
+// withdraw : evbufのseq以降のイベントをPeerに渡す前に取り下げる. 渡し済みならfalse.
+// 退室したClientへの未送信イベントを他のClientへ送り直すときに使う.
+func (c *Client) withdraw(seq int) bool {
+	c.muSend.Lock()
+	defer c.muSend.Unlock()
+	return c.evbuf.Truncate(seq)
+}
+
+// backpressure : 送信頻度を下げるようクライアントに通知する.
+// 通知は BackpressureInterval あたり1回まで. BackpressureInterval が0なら通知しない.
+func (c *Client) backpressure(src binary.BackpressureSource) {
+	interval := time.Duration(c.room.ClientConf().BackpressureInterval)
+	if interval <= 0 {
+		return
+	}
+	now := c.room.Clock().Now().UnixNano()
+	last := c.lastBackpressure.Load()
+	if now-last < int64(interval) || !c.lastBackpressure.CompareAndSwap(last, now) {
+		return
+	}
+	c.logger.Infof("backpressure (%v): src=%v", c.Id, src)
+	metrics.Backpressure.Add(1)
+	c.SendSystemEvent(binary.NewEvBackpressure(uint32(interval/time.Millisecond), src))
 }
 
-// RoomのMsgLoopから呼ばれる.
+// RoomのMsgLoopなどから呼ばれる.
 func (c *Client) SendSystemEvent(e *binary.SystemEvent) {
 	c.mu.RLock()
 	p := c.peer
@@ -311,6 +571,18 @@ func (c *Client) AttachPeer(p *Peer, lastEvSeq int) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.resumed {
+		// 保存後に送ったイベントもクライアントが受信済みなら、その続きから番号を振る.
+		// 受信していないイベントは失われているので、SendEventsで復帰不能になる.
+		c.resumed = false
+		if lastEvSeq > c.resumeEvSeq {
+			c.muSend.Lock()
+			c.evbuf.Rebase(lastEvSeq)
+			c.muSend.Unlock()
+		}
+	}
+	c.delivery.reattached(lastEvSeq)
+
 	// 未読Eventを再送. client終了後でも送信する.
 	if err := p.SendEvents(c.evbuf); err != nil {
 		return xerrors.Errorf("SendEvents: %w", err)
@@ -332,9 +604,10 @@ func (c *Client) AttachPeer(p *Peer, lastEvSeq int) error {
 	if c.peer == nil {
 		c.waitPeer <- p
 	} else {
-		c.peer.Close("new peer attached")
+		c.peer.Close(binary.CloseReasonPeerReplaced, "new peer attached")
 	}
 	c.peer = p
+	c.inviteExpire.Store(0)
 	c.sendRenewPeer()
 	return nil
 }
@@ -351,7 +624,8 @@ func (c *Client) DetachPeer(p *Peer) {
 	}
 	c.logger.Infof("detach peer: %v peer=%p", c.Id, p)
 	c.peer.Detached()
-	go c.drainMsg(c.peer.MsgCh())
+	peerMsgCh := c.peer.MsgCh()
+	c.room.Go(func() { c.drainMsg(peerMsgCh) })
 
 	select {
 	case <-c.done:
@@ -378,7 +652,8 @@ func (c *Client) DetachAndClosePeer(p *Peer, err error) {
 	c.logger.Infof("detach+close peer: %v", c.Id)
 	p.CloseWithClientError(err)
 	c.peer.Detached()
-	go c.drainMsg(c.peer.MsgCh())
+	peerMsgCh := c.peer.MsgCh()
+	c.room.Go(func() { c.drainMsg(peerMsgCh) })
 
 	select {
 	case <-c.done:
@@ -409,6 +684,32 @@ loop:
 		case <-c.evbuf.HasData():
 		}
 
+		// hubRelayはイベントをevbufに書き込む前に設定されている
+		if c.hubRelay != nil {
+			if d := c.hubRelay.FallbackDelay(); d > 0 {
+				// 中継で届いたイベントはHubがEventAckで通知してくるので、待っている間に送信済みになる
+				t := c.room.Clock().NewTimer(d)
+				select {
+				case <-c.done:
+					t.Stop()
+					break loop
+				case <-t.C():
+				}
+			}
+		}
+
+		if sim := c.room.NetSim(); sim != nil {
+			if d := sim.delay(); d > 0 {
+				t := c.room.Clock().NewTimer(d)
+				select {
+				case <-c.done:
+					t.Stop()
+					break loop
+				case <-t.C():
+				}
+			}
+		}
+
 		peer, wait := c.getWritePeer()
 		if peer == nil {
 			// peerがattachされるまで待つ
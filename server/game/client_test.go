@@ -0,0 +1,23 @@
+package game
+
+import "testing"
+
+func TestCheckMsgSeq(t *testing.T) {
+	tests := []struct {
+		seq, cSeq, window int
+		want              string
+	}{
+		{11, 10, 0, ""},
+		{11, 10, 4, ""},
+		{12, 10, 4, msgSeqGap},
+		{10, 10, 0, msgSeqTooOld},
+		{10, 10, 4, msgSeqDuplicate},
+		{7, 10, 4, msgSeqDuplicate},
+		{6, 10, 4, msgSeqTooOld},
+	}
+	for _, tt := range tests {
+		if got := checkMsgSeq(tt.seq, tt.cSeq, tt.window); got != tt.want {
+			t.Errorf("checkMsgSeq(%v, %v, %v) = %q, wants %q", tt.seq, tt.cSeq, tt.window, got, tt.want)
+		}
+	}
+}
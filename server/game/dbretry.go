@@ -0,0 +1,57 @@
+package game
+
+import (
+	"time"
+
+	"wsnet2/log"
+	"wsnet2/metrics"
+)
+
+// dbRetryIntervalMin : DB書き込みに失敗したときの最初のリトライ間隔
+const dbRetryIntervalMin = time.Second
+
+// dbRetry : DBへの書き込みに失敗したときのリトライ間隔と障害状態.
+// DBが使えない間もログが溢れないよう、エラーは障害の始まりと復旧時だけ記録する.
+type dbRetry struct {
+	name        string
+	maxInterval time.Duration
+
+	interval time.Duration // 0なら正常
+}
+
+func newDBRetry(name string, maxInterval time.Duration) *dbRetry {
+	if maxInterval < dbRetryIntervalMin {
+		maxInterval = dbRetryIntervalMin
+	}
+	return &dbRetry{
+		name:        name,
+		maxInterval: maxInterval,
+	}
+}
+
+// failed : 書き込みの失敗を記録し、次のリトライまでの間隔を返す
+func (d *dbRetry) failed(err error) time.Duration {
+	metrics.DBWriteErrors.Add(1)
+	if d.interval == 0 {
+		log.Errorf("%v: db write failed, retrying: %+v", d.name, err)
+		metrics.DBDegraded.Add(1)
+		d.interval = dbRetryIntervalMin
+		return d.interval
+	}
+	log.Debugf("%v: db write failed (retry after %v): %v", d.name, d.interval, err)
+	d.interval *= 2
+	if d.interval > d.maxInterval {
+		d.interval = d.maxInterval
+	}
+	return d.interval
+}
+
+// succeeded : 書き込みの成功を記録する. 障害中だったら復旧したことをログに残す
+func (d *dbRetry) succeeded() {
+	if d.interval == 0 {
+		return
+	}
+	log.Infof("%v: db write recovered", d.name)
+	metrics.DBDegraded.Add(-1)
+	d.interval = 0
+}
@@ -0,0 +1,129 @@
+package game
+
+import (
+	"sync/atomic"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/metrics"
+)
+
+// maxSentCheckpoints : Peerが送信時刻を覚えておくフレームの数. これより古いフレームのEventAckでは遅延を測らない
+const maxSentCheckpoints = 64
+
+// sentCheckpoint : 送信したフレームの最後のイベントのsequence numberと送信時刻
+type sentCheckpoint struct {
+	seq int
+	at  time.Time
+}
+
+// deliveryTracker : Peerが送信したフレームの送信時刻を記録し、クライアントのEventAckから配信の遅延を測る.
+// Peer.muWriteのロック中に使う
+type deliveryTracker struct {
+	frames []sentCheckpoint // 送信順
+	floor  int              // これ以下のsequence numberは確認済みか記録から捨てたので測らない
+}
+
+// sent : seqまでのイベントをatに送信した
+func (t *deliveryTracker) sent(seq int, at time.Time) {
+	if len(t.frames) >= maxSentCheckpoints {
+		if t.frames[0].seq > t.floor {
+			t.floor = t.frames[0].seq
+		}
+		t.frames = t.frames[1:]
+	}
+	t.frames = append(t.frames, sentCheckpoint{seq, at})
+}
+
+// ack : クライアントがseqまで受信した. seqを含むフレームの送信からnowまでの時間を返す.
+// 記録に無いときはfalseを返す.
+func (t *deliveryTracker) ack(seq int, now time.Time) (time.Duration, bool) {
+	if seq <= t.floor {
+		return 0, false
+	}
+	for i, cp := range t.frames {
+		if cp.seq >= seq {
+			if cp.seq == seq {
+				i++
+			}
+			t.frames = t.frames[i:]
+			t.floor = seq
+			return now.Sub(cp.at), true
+		}
+	}
+	return 0, false
+}
+
+// deliveryStats : クライアントへのイベントの配信状況. 部屋の状態の取得 (GetRoomInfo) で返す
+type deliveryStats struct {
+	// latency : EventAckで測った配信の遅延の平滑値 (nanosecond). EventAckを送らないクライアントは0
+	latency atomic.Int64
+	// sentSeq : 送信済みの最後のイベントのsequence number
+	sentSeq atomic.Int64
+	// lost : 送信したがクライアントが受信できず、再接続で再送したイベント数
+	lost atomic.Int64
+}
+
+// observeLatency : 遅延を平滑化して記録する (TCPのSRTTと同じく新しい値の重みは1/8)
+func (s *deliveryStats) observeLatency(d time.Duration) {
+	old := s.latency.Load()
+	if old == 0 {
+		s.latency.Store(int64(d))
+		return
+	}
+	s.latency.Store(old + (int64(d)-old)/8)
+}
+
+// sent : seqまでのイベントを送信した
+func (s *deliveryStats) sent(seq int) {
+	if int64(seq) > s.sentSeq.Load() {
+		s.sentSeq.Store(int64(seq))
+	}
+}
+
+// reattached : 新しいPeerがlastEvSeqまで受信済みとして接続した. 送信済みでそれ以降のイベントは失われている
+func (s *deliveryStats) reattached(lastEvSeq int) {
+	if n := s.sentSeq.Load() - int64(lastEvSeq); n > 0 {
+		s.lost.Add(n)
+		metrics.EventsLost.Add(n)
+	}
+}
+
+// eventAck : クライアントからのMsgTypeEventAckを処理する. Peer.MsgLoopから呼ばれる.
+// 遅延と未受信のイベント数は受信の遅れの検出にも使う.
+func (p *Peer) eventAck(payload []byte) error {
+	seq, err := binary.UnmarshalEventAckPayload(payload)
+	if err != nil {
+		return err
+	}
+	metrics.EventAcks.Add(1)
+
+	p.muWrite.Lock()
+	defer p.muWrite.Unlock()
+	if p.closed {
+		return nil
+	}
+	if seq > p.evSeqNum {
+		p.skipRelayed(seq)
+		return nil
+	}
+	latency, ok := p.delivery.ack(seq, time.Now())
+	if !ok {
+		return nil
+	}
+	p.client.delivery.observeLatency(latency)
+	p.checkSlowConsumer(p.evSeqNum-seq, latency)
+	return nil
+}
+
+// skipRelayed : 中継が主経路のHubがseqまで受け取ったと通知してきたら、websocketでは送らずに送信済みとする.
+// muWriteをロックして呼ぶこと
+func (p *Peer) skipRelayed(seq int) {
+	r := p.client.hubRelay
+	if r == nil || r.FallbackDelay() <= 0 || !p.client.evbuf.Skip(seq) {
+		return
+	}
+	metrics.RelaySkipped.Add(int64(seq - p.evSeqNum))
+	p.evSeqNum = seq
+	p.client.delivery.sent(seq)
+}
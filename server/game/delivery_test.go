@@ -0,0 +1,111 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+)
+
+func TestDeliveryTracker(t *testing.T) {
+	now := time.Now()
+	var tr deliveryTracker
+	tr.sent(3, now)
+	tr.sent(5, now.Add(time.Second))
+	tr.sent(9, now.Add(2*time.Second))
+
+	tests := []struct {
+		seq  int
+		want time.Duration
+		ok   bool
+	}{
+		// seq 4はseq 5までのフレームで送った
+		{4, 2 * time.Second, true},
+		{5, 2 * time.Second, true},
+		// 確認済みのフレームは捨てている
+		{5, 0, false},
+		{9, time.Second, true},
+		{10, 0, false},
+	}
+	for _, tc := range tests {
+		d, ok := tr.ack(tc.seq, now.Add(3*time.Second))
+		if d != tc.want || ok != tc.ok {
+			t.Fatalf("ack(%v) = (%v, %v), wants (%v, %v)", tc.seq, d, ok, tc.want, tc.ok)
+		}
+	}
+
+	// 記録から捨てたフレームでは測らない
+	for i := 1; i <= maxSentCheckpoints+1; i++ {
+		tr.sent(10+i, now)
+	}
+	if _, ok := tr.ack(11, now); ok {
+		t.Fatalf("ack of dropped frame must be false")
+	}
+	if _, ok := tr.ack(12, now); !ok {
+		t.Fatalf("ack of recorded frame must be true")
+	}
+}
+
+func TestDeliveryStats(t *testing.T) {
+	var s deliveryStats
+	s.observeLatency(80 * time.Millisecond)
+	s.observeLatency(160 * time.Millisecond)
+	if d := time.Duration(s.latency.Load()); d != 90*time.Millisecond {
+		t.Fatalf("latency = %v, wants 90ms", d)
+	}
+
+	s.sent(10)
+	s.sent(8)
+	s.reattached(10)
+	s.reattached(7)
+	if n := s.lost.Load(); n != 3 {
+		t.Fatalf("lost = %v, wants 3", n)
+	}
+}
+
+type fakeHubRelay struct {
+	delay time.Duration
+}
+
+func (r fakeHubRelay) Relay(string, int, *binary.RegularEvent) {}
+func (r fakeHubRelay) FallbackDelay() time.Duration            { return r.delay }
+
+func TestEventAckSkipsRelayed(t *testing.T) {
+	tests := map[string]struct {
+		relay HubRelay
+		ack   int
+		want  int
+	}{
+		"no relay":      {nil, 3, 0},
+		"relay backup":  {fakeHubRelay{0}, 3, 0},
+		"relay primary": {fakeHubRelay{time.Second}, 3, 3},
+		"beyond evbuf":  {fakeHubRelay{time.Second}, 6, 0},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &Client{
+				evbuf:    common.NewRingBuf[*binary.RegularEvent](8),
+				hubRelay: tc.relay,
+			}
+			for i := 0; i < 5; i++ {
+				c.evbuf.Write(binary.NewEvMessage("", []byte{byte(i)}))
+			}
+			p := &Peer{client: c}
+			if err := p.eventAck(binary.NewMsgEventAck(tc.ack).Payload()); err != nil {
+				t.Fatalf("eventAck: %v", err)
+			}
+			if p.evSeqNum != tc.want {
+				t.Fatalf("evSeqNum = %v, wants %v", p.evSeqNum, tc.want)
+			}
+			// 送信済みとしたイベントはwebsocketでは送らない
+			evs, err := c.evbuf.Read(p.evSeqNum)
+			if err != nil {
+				t.Fatalf("evbuf.Read: %v", err)
+			}
+			if len(evs) != 5-tc.want {
+				t.Fatalf("unsent events = %v, wants %v", len(evs), 5-tc.want)
+			}
+		})
+	}
+}
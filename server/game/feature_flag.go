@@ -0,0 +1,116 @@
+package game
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+
+	"wsnet2/common"
+	"wsnet2/log"
+)
+
+// 機能フラグ:
+// app毎の機能フラグをapp_feature_flagテーブルに置き、部屋の作成時に部屋で有効にするフラグを決める.
+// percentageは有効にする部屋の割合(%)で、部屋IDとフラグ名のハッシュで決めるので、同じ部屋なら常に同じ結果になる.
+// 部屋で有効なフラグはJoinedRoomResでクライアントに伝え、サーバ側の機能もRoom.Featureで切り替える.
+// 部屋の作成後にフラグを変更しても既存の部屋には反映しない. セッション再開で復元した部屋は復元時のフラグで決め直す.
+
+// featureFlagExpire : app_feature_flagを読み直す間隔
+const featureFlagExpire = 10 * time.Second
+
+// featureFlag : app_feature_flagテーブルの行
+type featureFlag struct {
+	Name       string `db:"name"`
+	Percentage uint32 `db:"percentage"`
+}
+
+// featureFlagCache : appの機能フラグ. 変更を再起動なしに反映するため定期的に読み直す.
+type featureFlagCache struct {
+	sync.Mutex
+	db     *sqlx.DB
+	appId  string
+	expire time.Duration
+	clock  common.Clock
+
+	flags       []featureFlag
+	lastUpdated time.Time
+}
+
+func newFeatureFlagCache(db *sqlx.DB, appId string) *featureFlagCache {
+	return &featureFlagCache{
+		db:     db,
+		appId:  appId,
+		expire: featureFlagExpire,
+		clock:  common.RealClock,
+	}
+}
+
+func (c *featureFlagCache) update() error {
+	if !c.lastUpdated.IsZero() && c.clock.Now().Sub(c.lastUpdated) <= c.expire {
+		return nil
+	}
+	var flags []featureFlag
+	err := c.db.Select(&flags, "SELECT name, percentage FROM app_feature_flag WHERE app_id = ?", c.appId)
+	if err != nil {
+		return xerrors.Errorf("select app_feature_flag: %w", err)
+	}
+	c.flags = flags
+	c.lastUpdated = c.clock.Now()
+	return nil
+}
+
+// get : 現在の機能フラグ. 読み直しに失敗したときは古いフラグを使う.
+func (c *featureFlagCache) get() []featureFlag {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.update(); err != nil {
+		log.Errorf("featureFlagCache(%v): %+v", c.appId, err)
+	}
+	return c.flags
+}
+
+// featureEnabled : 部屋でフラグを有効にするか
+func featureEnabled(roomId string, f featureFlag) bool {
+	if f.Percentage >= 100 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(f.Name))
+	h.Write([]byte{0})
+	h.Write([]byte(roomId))
+	return h.Sum32()%100 < f.Percentage
+}
+
+// roomFeatures : 部屋で有効にするフラグ名の一覧 (名前順)
+func roomFeatures(roomId string, flags []featureFlag) []string {
+	var features []string
+	for _, f := range flags {
+		if featureEnabled(roomId, f) {
+			features = append(features, f.Name)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+// roomFeatures : 部屋で有効にするフラグ名の一覧
+func (repo *Repository) roomFeatures(roomId string) []string {
+	if repo.features == nil {
+		return nil
+	}
+	return roomFeatures(roomId, repo.features.get())
+}
+
+// Feature : 部屋でフラグが有効か
+func (r *Room) Feature(name string) bool {
+	for _, f := range r.features {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package game
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"wsnet2/common"
+)
+
+func TestFeatureEnabled(t *testing.T) {
+	half := featureFlag{"half", 50}
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("room%04d", i)
+		e := featureEnabled(id, half)
+		if e != featureEnabled(id, half) {
+			t.Fatalf("featureEnabled(%v) must be stable", id)
+		}
+		if e {
+			enabled++
+		}
+		if featureEnabled(id, featureFlag{"off", 0}) {
+			t.Fatalf("0%% flag must be disabled: %v", id)
+		}
+		if !featureEnabled(id, featureFlag{"on", 100}) {
+			t.Fatalf("100%% flag must be enabled: %v", id)
+		}
+	}
+	if enabled < 400 || enabled > 600 {
+		t.Fatalf("50%% flag is enabled in %v/1000 rooms", enabled)
+	}
+
+	flags := []featureFlag{{"zeta", 100}, {"alpha", 100}, {"off", 0}}
+	if got, want := roomFeatures("room", flags), []string{"alpha", "zeta"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("roomFeatures = %v, wants %v", got, want)
+	}
+}
+
+func TestFeatureFlagCache(t *testing.T) {
+	db, mock := newDbMock(t)
+	clock := common.NewFakeClock(time.Now())
+	c := newFeatureFlagCache(db, "app1")
+	c.clock = clock
+
+	query := regexp.QuoteMeta("SELECT name, percentage FROM app_feature_flag WHERE app_id = ?")
+	mock.ExpectQuery(query).WithArgs("app1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "percentage"}).AddRow("compression", 100))
+	want := []featureFlag{{"compression", 100}}
+	if got := c.get(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("flags = %v, wants %v", got, want)
+	}
+
+	// 有効期限内は読み直さない
+	clock.Advance(featureFlagExpire)
+	if got := c.get(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("flags = %v, wants %v", got, want)
+	}
+
+	clock.Advance(time.Second)
+	mock.ExpectQuery(query).WithArgs("app1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "percentage"}))
+	if got := c.get(); len(got) != 0 {
+		t.Fatalf("flags = %v, wants empty", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,50 @@
+package game
+
+import (
+	"time"
+
+	"github.com/shiguredo/websocket"
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+)
+
+// HandshakeTimeout : Upgrade後に先頭フレームの認証情報を待つ時間. see binary/handshake.go
+const HandshakeTimeout = 5 * time.Second
+
+// HandshakeResolver : 先頭フレームの認証情報から認証済みのClientを得る
+type HandshakeResolver func(hs *binary.Handshake) (*Client, error)
+
+// AcceptHandshake : 先頭フレームの認証情報でクライアントを認証する.
+// 認証できなければCloseReasonHandshakeFailedで接続を閉じる.
+func AcceptHandshake(conn *websocket.Conn, resolve HandshakeResolver) (*Client, *binary.Handshake, error) {
+	conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	typ, data, err := conn.ReadMessage()
+	if err != nil {
+		conn.Close()
+		return nil, nil, xerrors.Errorf("read handshake: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if typ != websocket.BinaryMessage {
+		err = xerrors.Errorf("handshake is not a binary frame: %v", typ)
+		closeHandshake(conn, err)
+		return nil, nil, err
+	}
+	hs, err := binary.UnmarshalHandshake(data)
+	if err != nil {
+		closeHandshake(conn, err)
+		return nil, nil, err
+	}
+	cli, err := resolve(hs)
+	if err != nil {
+		closeHandshake(conn, err)
+		return nil, hs, xerrors.Errorf("handshake: app=%v client=%v: %w", hs.AppId, hs.ClientId, err)
+	}
+	return cli, hs, nil
+}
+
+func closeHandshake(conn *websocket.Conn, err error) {
+	writeMessage(conn, websocket.CloseMessage, formatCloseMessage(websocket.CloseNormalClosure, binary.CloseReasonHandshakeFailed, err.Error()))
+	conn.Close()
+}
@@ -0,0 +1,73 @@
+package game
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shiguredo/websocket"
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestAcceptHandshake(t *testing.T) {
+	cli := &Client{ClientInfo: &pb.ClientInfo{Id: "client"}}
+	res := make(chan *binary.Handshake, 1)
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		c, hs, err := AcceptHandshake(conn, func(hs *binary.Handshake) (*Client, error) {
+			if hs.AuthData != "auth" {
+				return nil, xerrors.Errorf("invalid auth data")
+			}
+			return cli, nil
+		})
+		if err == nil && c != cli {
+			t.Errorf("client = %v, wants %v", c, cli)
+		}
+		res <- hs
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+	if err := ws.WriteMessage(websocket.BinaryMessage, binary.MarshalHandshake("app", "client", 3, "auth", binary.ProtocolVersionLatest)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if hs := <-res; hs == nil || hs.AppId != "app" || hs.LastEvSeq != 3 || hs.ProtocolVersion != binary.ProtocolVersionLatest {
+		t.Fatalf("handshake = %+v", hs)
+	}
+
+	// 認証できなければ理由を付けて閉じる
+	ws2, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws2.Close()
+	if err := ws2.WriteMessage(websocket.BinaryMessage, binary.MarshalHandshake("app", "client", 0, "wrong", binary.ProtocolVersionLatest)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	<-res
+	_, _, err = ws2.ReadMessage()
+	var ce *websocket.CloseError
+	if !xerrors.As(err, &ce) {
+		t.Fatalf("read: %v, wants close error", err)
+	}
+	if reason, _ := binary.ParseCloseText(ce.Text); reason != binary.CloseReasonHandshakeFailed {
+		t.Fatalf("close reason = %v, wants %v", reason, binary.CloseReasonHandshakeFailed)
+	}
+}
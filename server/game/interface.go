@@ -4,8 +4,12 @@ package game
 import (
 	"sync"
 	"time"
+	"wsnet2/binary"
+	"wsnet2/common"
 	"wsnet2/config"
 	"wsnet2/log"
+	"wsnet2/moderation"
+	"wsnet2/pb"
 )
 
 type RoomID string
@@ -15,18 +19,51 @@ type IRoom interface {
 	Repo() IRepo
 
 	ClientConf() *config.ClientConf
+	// MACAlgorithms : クライアントのMsgの認証に使えるHMACアルゴリズム (see config.ClientConf.MACAlgorithmsFor)
+	MACAlgorithms() []string
 
 	Deadline() time.Duration
 	WaitGroup() *sync.WaitGroup
+	// Go : fを部屋のgoroutineとして数えて実行する
+	Go(f func())
 	Logger() log.Logger
+	Clock() common.Clock
 
 	// Done returns a channel which cloased when room is done.
 	Done() <-chan struct{}
 
 	SendMessage(msg Msg)
+
+	// AddTraffic : クライアントとの送受信バイト数を加算する
+	AddTraffic(in, out int)
+
+	// SlowConsumerPolicy : 受信が遅れ続けるクライアントの扱い
+	SlowConsumerPolicy() pb.SlowConsumerPolicy
+	// PingInterval : クライアントのPingの間隔. 0はクライアントが決める
+	PingInterval() time.Duration
+	// NetSim : イベントの送信に加える遅延と欠落. nilなら模擬しない
+	NetSim() *NetSim
 }
 
 type IRepo interface {
 	RemoveClient(c *Client)
 	PlayerLog(c *Client, msg PlayerLogMsg)
 }
+
+// EventPublisher : 部屋でbroadcastされたイベントを外部に配信する (see: wsnet2/bridge)
+type EventPublisher interface {
+	Publish(appId, roomId string, ev binary.Event)
+}
+
+// HubRelay : Hubのクライアントに送るイベントをwebsocket以外の経路でも中継する (see: wsnet2/relay)
+type HubRelay interface {
+	Relay(clientId string, seq int, ev *binary.RegularEvent)
+	// FallbackDelay : 0より大きければ中継を主経路とし、websocketではこの時間待ってからHubが受信を通知していないイベントを送る
+	FallbackDelay() time.Duration
+}
+
+// MessageScanner : 中継したメッセージを非同期に審査する (see: wsnet2/moderation)
+type MessageScanner interface {
+	Selects(appId, kind string) bool
+	Scan(req *moderation.Request, apply moderation.VerdictFunc)
+}
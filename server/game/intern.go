@@ -0,0 +1,82 @@
+package game
+
+import (
+	"bytes"
+	"container/list"
+	"strings"
+	"sync"
+
+	"wsnet2/binary"
+)
+
+// 辞書のキーの共有:
+// クライアントプロパティのキーはどの部屋、どのクライアントでも同じものが繰り返し使われるので、
+// 保持するキーをinternKeyで1つの文字列に揃えてメモリを節約する.
+// Unmarshalした辞書のキーと値は受信したメッセージのバッファを参照しているので、コピーして保持しバッファを解放できるようにする.
+
+const (
+	// maxInternKeys : 共有するキーの数の上限. 超えたら最も長く使われていないキーを表から外す
+	maxInternKeys = 10000
+	// maxInternKeyLen : 共有するキーの長さの上限. 長いキーは繰り返し使われないものとしてコピーするだけにする
+	maxInternKeyLen = 64
+)
+
+// internTable : 共有するキーの表. 悪意のあるクライアントがキーを増やし続けても膨らまないよう、LRUで上限を保つ
+type internTable struct {
+	sync.Mutex
+	max  int
+	keys map[string]*list.Element
+	lru  *list.List // 先頭ほど最近使われたキー
+}
+
+func newInternTable(max int) *internTable {
+	return &internTable{
+		max:  max,
+		keys: make(map[string]*list.Element),
+		lru:  list.New(),
+	}
+}
+
+var keyTable = newInternTable(maxInternKeys)
+
+// intern : kと同じ内容の共有された文字列. kの参照する領域は保持しない
+func (t *internTable) intern(k string) string {
+	if len(k) > maxInternKeyLen {
+		return strings.Clone(k)
+	}
+	t.Lock()
+	defer t.Unlock()
+	if e, ok := t.keys[k]; ok {
+		t.lru.MoveToFront(e)
+		return e.Value.(string)
+	}
+	s := strings.Clone(k)
+	t.keys[s] = t.lru.PushFront(s)
+	if t.lru.Len() > t.max {
+		e := t.lru.Back()
+		t.lru.Remove(e)
+		delete(t.keys, e.Value.(string))
+	}
+	return s
+}
+
+// len : 表にあるキーの数
+func (t *internTable) len() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.keys)
+}
+
+// internKey : kと同じ内容の共有された文字列. kの参照する領域は保持しない
+func internKey(k string) string {
+	return keyTable.intern(k)
+}
+
+// internProps : キーを共有し、値をコピーしたprops
+func internProps(props binary.Dict) binary.Dict {
+	d := make(binary.Dict, len(props))
+	for k, v := range props {
+		d[internKey(k)] = bytes.Clone(v)
+	}
+	return d
+}
@@ -0,0 +1,59 @@
+package game
+
+import (
+	"testing"
+	"unsafe"
+
+	"wsnet2/binary"
+)
+
+func TestInternKey(t *testing.T) {
+	buf := []byte("score")
+	k1 := internKey(string(buf))
+	k2 := internKey("score")
+	if k1 != "score" || unsafe.StringData(k1) != unsafe.StringData(k2) {
+		t.Fatalf("internKey must return the shared string")
+	}
+
+	long := string(make([]byte, maxInternKeyLen+1))
+	if internKey(long) != long {
+		t.Fatalf("internKey(long) must keep the content")
+	}
+	keyTable.Lock()
+	_, ok := keyTable.keys[long]
+	keyTable.Unlock()
+	if ok {
+		t.Fatalf("long key must not be interned")
+	}
+
+	src := []byte{1, 2}
+	props := internProps(binary.Dict{"score": src})
+	src[0] = 9
+	if v := props["score"]; v[0] != 1 {
+		t.Fatalf("internProps must copy the values: %v", v)
+	}
+}
+
+func TestInternTableEviction(t *testing.T) {
+	tbl := newInternTable(2)
+	a := tbl.intern("a")
+	tbl.intern("b")
+	tbl.intern("a") // bが最も古くなる
+	tbl.intern("c")
+
+	if n := tbl.len(); n != 2 {
+		t.Fatalf("table size = %v, wants 2", n)
+	}
+	if _, ok := tbl.keys["b"]; ok {
+		t.Fatalf("least recently used key must be evicted")
+	}
+	if a2 := tbl.intern("a"); unsafe.StringData(a2) != unsafe.StringData(a) {
+		t.Fatalf("recently used key must stay shared")
+	}
+	if b := tbl.intern("b"); b != "b" {
+		t.Fatalf("evicted key must be interned again: %v", b)
+	}
+	if n := tbl.len(); n != 2 {
+		t.Fatalf("table size = %v, wants 2", n)
+	}
+}
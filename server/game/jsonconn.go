@@ -0,0 +1,131 @@
+package game
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/shiguredo/websocket"
+
+	"wsnet2/binary"
+)
+
+// JSONでのイベント配信 (開発用):
+// websocketのサブプロトコルにSubprotocolJSONを指定して接続すると、イベントをbinaryの代わりにJSONのtextフレームで受け取る.
+// 汎用のツールで通信内容を確認するためのもので、サーバがdebug_json_eventsを有効にしたときだけ使える.
+// Msgはbinaryのまま送る.
+
+// SubprotocolJSON : イベントをJSONで配信するwebsocketのサブプロトコル
+const SubprotocolJSON = "wsnet2.json"
+
+// jsonEvent : JSONで配信するイベント.
+// PayloadはRawをbinary.UnmarshalRecursiveで解釈したもので、解釈できなければ省略する.
+type jsonEvent struct {
+	Type    string `json:"type"`
+	Seq     *int   `json:"seq,omitempty"`
+	Payload any    `json:"payload,omitempty"`
+	Raw     []byte `json:"raw"`
+}
+
+// jsonConn : イベントのフレームをJSONに変換して送る接続
+type jsonConn struct {
+	*websocket.Conn
+}
+
+var _ peerConn = &jsonConn{}
+
+// withJSONEvents : SubprotocolJSONで接続していればイベントをJSONで送る接続にする
+func withJSONEvents(conn peerConn) peerConn {
+	if c, ok := conn.(*websocket.Conn); ok && c.Subprotocol() == SubprotocolJSON {
+		return &jsonConn{c}
+	}
+	return conn
+}
+
+func (c *jsonConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != websocket.BinaryMessage {
+		return c.Conn.WriteMessage(messageType, data)
+	}
+	frames, err := marshalJSONEvents(data)
+	if err != nil {
+		return err
+	}
+	for _, f := range frames {
+		if err := c.Conn.WriteMessage(websocket.TextMessage, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *jsonConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	return &jsonWriter{conn: c, messageType: messageType}, nil
+}
+
+// marshalJSONEvents : イベントのフレームをイベント毎のJSONにする. EvTypeBatchはイベント毎に分ける
+func marshalJSONEvents(data []byte) ([][]byte, error) {
+	ev, seq, err := binary.UnmarshalEvent(data)
+	if err != nil {
+		return nil, err
+	}
+	if ev.Type() != binary.EvTypeBatch {
+		f, err := marshalJSONEvent(ev, seq)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{f}, nil
+	}
+	entries, err := binary.UnmarshalBatchPayload(ev.Payload())
+	if err != nil {
+		return nil, err
+	}
+	frames := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		ev, seq, err := binary.UnmarshalEvent(e)
+		if err != nil {
+			return nil, err
+		}
+		f, err := marshalJSONEvent(ev, seq)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, f)
+	}
+	return frames, nil
+}
+
+func marshalJSONEvent(ev binary.Event, seq int) ([]byte, error) {
+	je := jsonEvent{
+		Type: ev.Type().String(),
+		Raw:  ev.Payload(),
+	}
+	if _, ok := ev.(*binary.RegularEvent); ok {
+		je.Seq = &seq
+	}
+	if len(je.Raw) > 0 {
+		if p, err := binary.UnmarshalRecursive(je.Raw); err == nil {
+			je.Payload = p
+		}
+	}
+	f, err := json.Marshal(&je)
+	if err != nil && je.Payload != nil {
+		// NaNなどJSONにできない値を含むときはRawだけ送る
+		je.Payload = nil
+		f, err = json.Marshal(&je)
+	}
+	return f, err
+}
+
+type jsonWriter struct {
+	conn        *jsonConn
+	messageType int
+	buf         bytes.Buffer
+}
+
+func (w *jsonWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *jsonWriter) Close() error {
+	return w.conn.WriteMessage(w.messageType, w.buf.Bytes())
+}
@@ -0,0 +1,49 @@
+package game
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"wsnet2/binary"
+)
+
+func TestMarshalJSONEvents(t *testing.T) {
+	msg := binary.NewEvMessage("player", binary.MarshalStr8("hello"))
+
+	frames, err := marshalJSONEvents(msg.Marshal(3))
+	if err != nil {
+		t.Fatalf("marshalJSONEvents: %v", err)
+	}
+	if len(frames) != 1 {
+		t.Fatalf("frames = %q, wants 1 frame", frames)
+	}
+	var ev map[string]any
+	if err := json.Unmarshal(frames[0], &ev); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if ev["type"] != "EvTypeMessage" || ev["seq"] != float64(3) {
+		t.Fatalf("event = %v", ev)
+	}
+	if !reflect.DeepEqual(ev["payload"], []any{"player", "hello"}) {
+		t.Fatalf("payload = %#v, wants [player hello]", ev["payload"])
+	}
+
+	// Batchはイベント毎に分ける
+	frames, err = marshalJSONEvents(binary.MarshalBatch([]*binary.RegularEvent{msg, msg}, 4))
+	if err != nil || len(frames) != 2 {
+		t.Fatalf("marshalJSONEvents(batch) = (%q, %v), wants 2 frames", frames, err)
+	}
+	json.Unmarshal(frames[1], &ev)
+	if ev["seq"] != float64(5) {
+		t.Fatalf("seq = %v, wants 5", ev["seq"])
+	}
+
+	// SystemEventにはseqが無い
+	frames, _ = marshalJSONEvents(binary.NewEvPeerReady(10).Marshal())
+	ev = nil
+	json.Unmarshal(frames[0], &ev)
+	if _, ok := ev["seq"]; ok || ev["type"] != "EvTypePeerReady" {
+		t.Fatalf("event = %v", ev)
+	}
+}
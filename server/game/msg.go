@@ -6,6 +6,7 @@ import (
 	"golang.org/x/xerrors"
 
 	"wsnet2/binary"
+	"wsnet2/moderation"
 	"wsnet2/pb"
 )
 
@@ -23,8 +24,41 @@ var _ Msg = &MsgLeave{}
 var _ Msg = &MsgRoomProp{}
 var _ Msg = &MsgClientProp{}
 var _ Msg = &MsgBroadcast{}
+var _ Msg = &MsgEncrypted{}
 var _ Msg = &MsgSwitchMaster{}
+var _ Msg = &MsgAcceptMaster{}
+var _ Msg = &MsgConfirmRejoin{}
+var _ Msg = &MsgBlocklist{}
+var _ Msg = &MsgPauseRoom{}
+var _ Msg = &MsgCreateSuccessor{}
+var _ Msg = &MsgRelayToParent{}
+var _ Msg = &MsgAddBot{}
+var _ Msg = &MsgRemoveBot{}
+var _ Msg = &MsgBotMessage{}
+var _ Msg = &MsgPromoteWatcher{}
+var _ Msg = &MsgMergeRoom{}
+var _ Msg = &MsgClientDeadline{}
 var _ Msg = &MsgKick{}
+var _ Msg = &MsgKVSet{}
+var _ Msg = &MsgKVDelete{}
+var _ Msg = &MsgKVCompareAndSwap{}
+var _ Msg = &MsgRoles{}
+var _ Msg = &MsgToRole{}
+var _ Msg = &MsgStartVote{}
+var _ Msg = &MsgCastVote{}
+var _ Msg = &MsgVoteTimeout{}
+var _ Msg = &MsgSwitchMasterTimeout{}
+var _ Msg = &MsgRejoinTimeout{}
+var _ Msg = &MsgPauseTimeout{}
+var _ Msg = &MsgStaleCheck{}
+var _ Msg = &MsgSuccessorCreated{}
+var _ Msg = &MsgInheritRoles{}
+var _ Msg = &MsgReserveSeats{}
+var _ Msg = &MsgMergeReserved{}
+var _ Msg = &MsgReserveInvite{}
+var _ Msg = &MsgChildRelay{}
+var _ Msg = &MsgModerationVerdict{}
+var _ Msg = &MsgClientPropFlush{}
 var _ Msg = &MsgClientError{}
 var _ Msg = &MsgClientTimeout{}
 
@@ -46,6 +80,9 @@ type MsgCreate struct {
 	MACKey string
 	Joined chan<- *JoinedInfo
 	Err    chan<- ErrorWithCode
+
+	// RequestId : gRPCリクエストのID. クライアントのログとplayer_logに付ける (see wsnet2/requestid)
+	RequestId string
 }
 
 func (*MsgCreate) msg() {}
@@ -61,6 +98,12 @@ type MsgJoin struct {
 	MACKey string
 	Joined chan<- *JoinedInfo
 	Err    chan<- ErrorWithCode
+
+	// RequestId : gRPCリクエストのID. クライアントのログとplayer_logに付ける (see wsnet2/requestid)
+	RequestId string
+
+	// InviteExpire : 招待で入室するときの招待の期限. see: room_invite.go
+	InviteExpire time.Time
 }
 
 func (*MsgJoin) msg() {}
@@ -76,6 +119,9 @@ type MsgWatch struct {
 	MACKey string
 	Joined chan<- *JoinedInfo
 	Err    chan<- ErrorWithCode
+
+	// RequestId : gRPCリクエストのID. クライアントのログとplayer_logに付ける (see wsnet2/requestid)
+	RequestId string
 }
 
 func (*MsgWatch) msg() {}
@@ -154,6 +200,99 @@ func (m *MsgAdminKick) SenderID() ClientID {
 	return adminClientID
 }
 
+// MsgAdminMessage : 管理者メッセージを全員に送る
+// gRPCから実行される
+type MsgAdminMessage struct {
+	Message string
+}
+
+func (*MsgAdminMessage) msg() {}
+func (m *MsgAdminMessage) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgServerMessage : appのサーバからのメッセージを送る
+// Targetが空なら部屋の全員に送る. gRPCから実行される
+type MsgServerMessage struct {
+	Target ClientID
+	Data   []byte
+	Res    chan<- error
+}
+
+func (*MsgServerMessage) msg() {}
+func (m *MsgServerMessage) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgAdminClose : 部屋を閉じる
+// gRPCから実行される
+type MsgAdminClose struct {
+	Reason string
+	Res    chan<- error
+}
+
+func (*MsgAdminClose) msg() {}
+func (m *MsgAdminClose) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgAdminMerge : 部屋を同じgameサーバの別の部屋に統合する
+// gRPCから実行される
+type MsgAdminMerge struct {
+	Target string
+	Res    chan<- error
+}
+
+func (*MsgAdminMerge) msg() {}
+func (m *MsgAdminMerge) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgWaitSeat : 満室の部屋の順番待ちの列に並ぶ、または順番を問い合わせる
+// gRPCリクエストよりwsnet内で発生
+type MsgWaitSeat struct {
+	ClientId ClientID
+	Leave    bool // 列から外れる
+	Res      chan<- WaitSeatStatus
+	Err      chan<- ErrorWithCode
+}
+
+func (*MsgWaitSeat) msg() {}
+func (m *MsgWaitSeat) SenderID() ClientID {
+	return m.ClientId
+}
+
+// MsgReserveInvite : 招待されたユーザの席を確保する
+// gRPCリクエストよりwsnet内で発生
+type MsgReserveInvite struct {
+	ClientId ClientID
+	Expire   time.Time
+	Err      chan<- ErrorWithCode
+}
+
+func (*MsgReserveInvite) msg() {}
+func (m *MsgReserveInvite) SenderID() ClientID {
+	return m.ClientId
+}
+
+// MsgCloseRoom : 全クライアントを退室させて部屋を終了する（内部で発生）
+type MsgCloseRoom struct {
+	Cause string
+}
+
+func (*MsgCloseRoom) msg() {}
+func (m *MsgCloseRoom) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgRoomExpired : 部屋の寿命切れ（内部で発生）
+type MsgRoomExpired struct{}
+
+func (*MsgRoomExpired) msg() {}
+func (m *MsgRoomExpired) SenderID() ClientID {
+	return adminClientID
+}
+
 // MsgLeave : 退室メッセージ
 // クライアントの自発的な退室リクエスト
 type MsgLeave struct {
@@ -245,6 +384,33 @@ func (m *MsgTargets) SenderID() ClientID {
 	return m.Sender.ID()
 }
 
+// MsgEncrypted : 暗号化されたメッセージ (MsgTypeEncrypted) と鍵交換 (MsgTypeKeyExchange)
+type MsgEncrypted struct {
+	binary.RegularMsg
+	Sender   *Client
+	Targets  []string
+	Envelope []byte
+}
+
+func (*MsgEncrypted) msg() {}
+
+func (m *MsgEncrypted) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgEncrypted(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	targets, envelope, err := binary.UnmarshalEncryptedPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgEncrypted{
+		RegularMsg: msg,
+		Sender:     sender,
+		Targets:    targets,
+		Envelope:   envelope,
+	}, nil
+}
+
 func msgTargets(sender *Client, msg binary.RegularMsg) (Msg, error) {
 	targets, data, err := binary.UnmarshalTargetsAndData(msg.Payload())
 	if err != nil {
@@ -330,9 +496,12 @@ func msgSwitchMaster(sender *Client, msg binary.RegularMsg) (Msg, error) {
 // MasterClientからのみ受け付ける.
 type MsgKick struct {
 	binary.RegularMsg
-	Sender  *Client
-	Target  ClientID
-	Message string
+	Sender      *Client
+	Target      ClientID
+	Message     string
+	Reason      binary.KickReason
+	BanDuration time.Duration
+	Demote      bool
 }
 
 func (*MsgKick) msg() {}
@@ -342,18 +511,676 @@ func (m *MsgKick) SenderID() ClientID {
 }
 
 func msgKick(sender *Client, msg binary.RegularMsg) (Msg, error) {
-	target, message, err := binary.UnmarshalKickPayload(msg.Payload())
+	kp, err := binary.UnmarshalKickPayload(msg.Payload())
 	if err != nil {
 		return nil, err
 	}
 	return &MsgKick{
+		RegularMsg:  msg,
+		Sender:      sender,
+		Target:      ClientID(kp.ClientId),
+		Message:     kp.Message,
+		Reason:      kp.Reason,
+		BanDuration: time.Duration(kp.BanDuration) * time.Second,
+		Demote:      kp.Demote,
+	}, nil
+}
+
+// MsgKVSet : 部屋KVストアへの書き込み
+type MsgKVSet struct {
+	binary.RegularMsg
+	*binary.MsgKVSetPayload
+	Sender *Client
+}
+
+func (*MsgKVSet) msg() {}
+
+func (m *MsgKVSet) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgKVSet(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	kvp, err := binary.UnmarshalKVSetPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgKVSet{
+		RegularMsg:      msg,
+		MsgKVSetPayload: kvp,
+		Sender:          sender,
+	}, nil
+}
+
+// MsgKVDelete : 部屋KVストアからの削除
+type MsgKVDelete struct {
+	binary.RegularMsg
+	Sender *Client
+	Key    string
+}
+
+func (*MsgKVDelete) msg() {}
+
+func (m *MsgKVDelete) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgKVDelete(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	key, err := binary.UnmarshalKVDeletePayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgKVDelete{
+		RegularMsg: msg,
+		Sender:     sender,
+		Key:        key,
+	}, nil
+}
+
+// MsgKVCompareAndSwap : 部屋KVストアの値を比較して書き換え
+type MsgKVCompareAndSwap struct {
+	binary.RegularMsg
+	*binary.MsgKVCompareAndSwapPayload
+	Sender *Client
+}
+
+func (*MsgKVCompareAndSwap) msg() {}
+
+func (m *MsgKVCompareAndSwap) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgKVCompareAndSwap(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	kvp, err := binary.UnmarshalKVCompareAndSwapPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgKVCompareAndSwap{
+		RegularMsg:                 msg,
+		MsgKVCompareAndSwapPayload: kvp,
+		Sender:                     sender,
+	}, nil
+}
+
+// MsgRoles : 自身のロールの登録
+type MsgRoles struct {
+	binary.RegularMsg
+	Sender *Client
+	Roles  []string
+}
+
+func (*MsgRoles) msg() {}
+
+func (m *MsgRoles) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgRoles(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	roles, err := binary.UnmarshalRolesPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgRoles{
+		RegularMsg: msg,
+		Sender:     sender,
+		Roles:      roles,
+	}, nil
+}
+
+// MsgToRole : 指定ロールを持つプレイヤーに送る
+type MsgToRole struct {
+	binary.RegularMsg
+	Sender *Client
+	Role   string
+	Data   []byte
+}
+
+func (*MsgToRole) msg() {}
+
+func (m *MsgToRole) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgToRole(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	role, data, err := binary.UnmarshalToRolePayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgToRole{
+		RegularMsg: msg,
+		Sender:     sender,
+		Role:       role,
+		Data:       data,
+	}, nil
+}
+
+// MsgStartVote : 投票の開始
+type MsgStartVote struct {
+	binary.RegularMsg
+	*binary.MsgStartVotePayload
+	Sender *Client
+}
+
+func (*MsgStartVote) msg() {}
+
+func (m *MsgStartVote) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgStartVote(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	svp, err := binary.UnmarshalStartVotePayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgStartVote{
+		RegularMsg:          msg,
+		MsgStartVotePayload: svp,
+		Sender:              sender,
+	}, nil
+}
+
+// MsgCastVote : 投票
+type MsgCastVote struct {
+	binary.RegularMsg
+	Sender *Client
+	VoteId string
+	Option int
+}
+
+func (*MsgCastVote) msg() {}
+
+func (m *MsgCastVote) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgCastVote(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	id, opt, err := binary.UnmarshalCastVotePayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgCastVote{
+		RegularMsg: msg,
+		Sender:     sender,
+		VoteId:     id,
+		Option:     opt,
+	}, nil
+}
+
+// MsgFetchHistory : 入室前にbroadcastされたメッセージの取得
+type MsgFetchHistory struct {
+	binary.RegularMsg
+	Sender *Client
+	Count  int
+}
+
+func (*MsgFetchHistory) msg() {}
+
+func (m *MsgFetchHistory) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgFetchHistory(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	n, err := binary.UnmarshalFetchHistoryPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgFetchHistory{
+		RegularMsg: msg,
+		Sender:     sender,
+		Count:      n,
+	}, nil
+}
+
+// MsgAcceptMaster : Masterの移譲の受諾
+// 移譲先のPlayerからのみ受け付ける.
+type MsgAcceptMaster struct {
+	binary.RegularMsg
+	Sender *Client
+}
+
+func (*MsgAcceptMaster) msg() {}
+
+func (m *MsgAcceptMaster) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgAcceptMaster(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	return &MsgAcceptMaster{
+		RegularMsg: msg,
+		Sender:     sender,
+	}, nil
+}
+
+// MsgConfirmRejoin : 同じクライアントIDの別の接続からの入室への応答
+// 確認を求められたPlayerからのみ受け付ける.
+type MsgConfirmRejoin struct {
+	binary.RegularMsg
+	Sender *Client
+	Accept bool
+}
+
+func (*MsgConfirmRejoin) msg() {}
+
+func (m *MsgConfirmRejoin) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgConfirmRejoin(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	accept, err := binary.UnmarshalConfirmRejoinPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgConfirmRejoin{
+		RegularMsg: msg,
+		Sender:     sender,
+		Accept:     accept,
+	}, nil
+}
+
+// MsgBlocklist : 自身へのメッセージを受け取らない送信元の登録
+type MsgBlocklist struct {
+	binary.RegularMsg
+	Sender  *Client
+	Blocked []string
+}
+
+func (*MsgBlocklist) msg() {}
+
+func (m *MsgBlocklist) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgBlocklist(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	ids, err := binary.UnmarshalBlocklistPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgBlocklist{
+		RegularMsg: msg,
+		Sender:     sender,
+		Blocked:    ids,
+	}, nil
+}
+
+// MsgPauseRoom : 部屋の一時停止と再開
+// Masterからのみ受け付ける.
+type MsgPauseRoom struct {
+	binary.RegularMsg
+	Sender *Client
+	Pause  bool
+}
+
+func (*MsgPauseRoom) msg() {}
+
+func (m *MsgPauseRoom) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgPauseRoom(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	pause, err := binary.UnmarshalPauseRoomPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgPauseRoom{
+		RegularMsg: msg,
+		Sender:     sender,
+		Pause:      pause,
+	}, nil
+}
+
+// MsgCreateSuccessor : 後継の部屋の作成
+// Masterからのみ受け付ける.
+type MsgCreateSuccessor struct {
+	binary.RegularMsg
+	Sender *Client
+	Props  binary.Dict
+}
+
+func (*MsgCreateSuccessor) msg() {}
+
+func (m *MsgCreateSuccessor) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgCreateSuccessor(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	props, err := binary.UnmarshalCreateSuccessorPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgCreateSuccessor{
+		RegularMsg: msg,
+		Sender:     sender,
+		Props:      props,
+	}, nil
+}
+
+// MsgRelayToParent : 子の部屋からロビー部屋への中継
+type MsgRelayToParent struct {
+	binary.RegularMsg
+	*binary.MsgRelayToParentPayload
+	Sender *Client
+}
+
+func (*MsgRelayToParent) msg() {}
+
+func (m *MsgRelayToParent) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgRelayToParent(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	payload, err := binary.UnmarshalRelayToParentPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgRelayToParent{
+		RegularMsg:              msg,
+		MsgRelayToParentPayload: payload,
+		Sender:                  sender,
+	}, nil
+}
+
+// MsgAddBot : ボットのPlayerの追加
+// Masterからのみ受け付ける.
+type MsgAddBot struct {
+	binary.RegularMsg
+	*binary.MsgAddBotPayload
+	Sender *Client
+}
+
+func (*MsgAddBot) msg() {}
+
+func (m *MsgAddBot) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgAddBot(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	payload, err := binary.UnmarshalAddBotPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgAddBot{
+		RegularMsg:       msg,
+		MsgAddBotPayload: payload,
+		Sender:           sender,
+	}, nil
+}
+
+// MsgRemoveBot : ボットのPlayerの退室
+// Masterからのみ受け付ける.
+type MsgRemoveBot struct {
+	binary.RegularMsg
+	Sender *Client
+	BotId  ClientID
+}
+
+func (*MsgRemoveBot) msg() {}
+
+func (m *MsgRemoveBot) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgRemoveBot(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	id, err := binary.UnmarshalRemoveBotPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgRemoveBot{
+		RegularMsg: msg,
+		Sender:     sender,
+		BotId:      ClientID(id),
+	}, nil
+}
+
+// MsgBotMessage : ボットを送信者とする全員への送信
+// Masterからのみ受け付ける.
+type MsgBotMessage struct {
+	binary.RegularMsg
+	*binary.MsgBotMessagePayload
+	Sender *Client
+}
+
+func (*MsgBotMessage) msg() {}
+
+func (m *MsgBotMessage) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgBotMessage(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	payload, err := binary.UnmarshalBotMessagePayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgBotMessage{
+		RegularMsg:           msg,
+		MsgBotMessagePayload: payload,
+		Sender:               sender,
+	}, nil
+}
+
+// MsgPromoteWatcher : 観戦者のPlayerへの昇格
+// Masterからは昇格、観戦者からは昇格の申請.
+type MsgPromoteWatcher struct {
+	binary.RegularMsg
+	Sender  *Client
+	Watcher ClientID
+}
+
+func (*MsgPromoteWatcher) msg() {}
+
+func (m *MsgPromoteWatcher) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgPromoteWatcher(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	id, err := binary.UnmarshalPromoteWatcherPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgPromoteWatcher{
+		RegularMsg: msg,
+		Sender:     sender,
+		Watcher:    ClientID(id),
+	}, nil
+}
+
+// MsgMergeRoom : 部屋を同じgameサーバの別の部屋に統合する (Masterのみ)
+type MsgMergeRoom struct {
+	binary.RegularMsg
+	Sender *Client
+	Target string
+}
+
+func (*MsgMergeRoom) msg() {}
+
+func (m *MsgMergeRoom) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgMergeRoom(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	target, err := binary.UnmarshalMergeRoomPayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgMergeRoom{
+		RegularMsg: msg,
+		Sender:     sender,
+		Target:     target,
+	}, nil
+}
+
+// MsgClientDeadline : Player毎にClientDeadlineを指定する (Masterのみ)
+type MsgClientDeadline struct {
+	binary.RegularMsg
+	Sender   *Client
+	Target   ClientID
+	Deadline time.Duration // 0なら部屋のClientDeadlineに戻す
+}
+
+func (*MsgClientDeadline) msg() {}
+
+func (m *MsgClientDeadline) SenderID() ClientID {
+	return m.Sender.ID()
+}
+
+func msgClientDeadline(sender *Client, msg binary.RegularMsg) (Msg, error) {
+	target, deadline, err := binary.UnmarshalClientDeadlinePayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return &MsgClientDeadline{
 		RegularMsg: msg,
 		Sender:     sender,
 		Target:     ClientID(target),
-		Message:    message,
+		Deadline:   time.Duration(deadline) * time.Second,
 	}, nil
 }
 
+// MsgVoteTimeout : 投票期限切れ（内部で発生）
+type MsgVoteTimeout struct {
+	Vote *vote
+}
+
+func (*MsgVoteTimeout) msg() {}
+
+func (m *MsgVoteTimeout) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgSwitchMasterTimeout : Masterの移譲の受諾待ちの期限切れ（内部で発生）
+type MsgSwitchMasterTimeout struct {
+	Switch *masterSwitch
+}
+
+func (*MsgSwitchMasterTimeout) msg() {}
+
+func (m *MsgSwitchMasterTimeout) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgRejoinTimeout : 同じクライアントIDの別の接続からの入室の確認待ちの期限切れ（内部で発生）
+type MsgRejoinTimeout struct {
+	Request *rejoinRequest
+}
+
+func (*MsgRejoinTimeout) msg() {}
+
+func (m *MsgRejoinTimeout) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgSuccessorCreated : 後継の部屋の作成結果（内部で発生）
+type MsgSuccessorCreated struct {
+	Request *MsgCreateSuccessor
+	Room    *pb.RoomInfo // nilなら作成失敗
+}
+
+func (*MsgSuccessorCreated) msg() {}
+
+func (m *MsgSuccessorCreated) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgInheritRoles : 後継の部屋が前の部屋から引き継ぐPlayerのロール（内部で発生）
+type MsgInheritRoles struct {
+	Roles map[ClientID][]string
+}
+
+func (*MsgInheritRoles) msg() {}
+
+func (m *MsgInheritRoles) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgReserveSeats : 統合元の部屋のPlayerの席を確保する（統合元の部屋で発生）
+type MsgReserveSeats struct {
+	Source      string
+	SearchGroup uint32
+	Clients     []ClientID
+	Expire      time.Time
+	Res         chan<- error
+}
+
+func (*MsgReserveSeats) msg() {}
+
+func (m *MsgReserveSeats) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgMergeReserved : 統合先の部屋での席の確保の結果（内部で発生）
+type MsgMergeReserved struct {
+	Request Msg // *MsgMergeRoom か *MsgAdminMerge
+	Target  string
+	Clients []ClientID
+	Expire  time.Time
+	Err     error // nilなら確保できた
+}
+
+func (*MsgMergeReserved) msg() {}
+
+func (m *MsgMergeReserved) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgChildRelay : 子の部屋から中継されたメッセージ（子の部屋で発生）
+type MsgChildRelay struct {
+	Child  string
+	Sender string
+	Kind   binary.RoomRelayKind
+	Target ClientID
+	Data   []byte
+}
+
+func (*MsgChildRelay) msg() {}
+
+func (m *MsgChildRelay) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgPauseTimeout : 部屋の一時停止の上限時間切れ（内部で発生）
+type MsgPauseTimeout struct {
+	Pause *roomPause
+}
+
+func (*MsgPauseTimeout) msg() {}
+
+func (m *MsgPauseTimeout) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgStaleCheck : Playerの最後のメッセージからの経過時間の判定（内部で発生）
+type MsgStaleCheck struct{}
+
+func (*MsgStaleCheck) msg() {}
+
+func (m *MsgStaleCheck) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgModerationVerdict : 中継したメッセージの審査結果（内部で発生）
+type MsgModerationVerdict struct {
+	Sender     *Client
+	Recipients []ClientID // nilなら部屋全体に送ったメッセージ
+	Event      *binary.RegularEvent
+	Data       []byte
+	Verdict    *moderation.Verdict
+}
+
+func (*MsgModerationVerdict) msg() {}
+
+func (m *MsgModerationVerdict) SenderID() ClientID {
+	return adminClientID
+}
+
+// MsgClientPropFlush : 保留中のクライアントプロパティ変更の通知（内部で発生）
+type MsgClientPropFlush struct {
+	pending *pendingClientProp
+}
+
+func (*MsgClientPropFlush) msg() {}
+
+func (m *MsgClientPropFlush) SenderID() ClientID {
+	return adminClientID
+}
+
 // MsgClientError : Client内部エラー（内部で発生）
 type MsgClientError struct {
 	Sender *Client
@@ -395,10 +1222,52 @@ func ConstructMsg(cli *Client, m binary.Msg) (msg Msg, err error) {
 		return msgToMaster(cli, m.(binary.RegularMsg))
 	case binary.MsgTypeBroadcast:
 		return msgBroadcast(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeEncrypted, binary.MsgTypeKeyExchange:
+		return msgEncrypted(cli, m.(binary.RegularMsg))
 	case binary.MsgTypeSwitchMaster:
 		return msgSwitchMaster(cli, m.(binary.RegularMsg))
 	case binary.MsgTypeKick:
 		return msgKick(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeKVSet:
+		return msgKVSet(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeKVDelete:
+		return msgKVDelete(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeKVCompareAndSwap:
+		return msgKVCompareAndSwap(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeRoles:
+		return msgRoles(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeToRole:
+		return msgToRole(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeStartVote:
+		return msgStartVote(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeCastVote:
+		return msgCastVote(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeFetchHistory:
+		return msgFetchHistory(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeAcceptMaster:
+		return msgAcceptMaster(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeConfirmRejoin:
+		return msgConfirmRejoin(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeBlocklist:
+		return msgBlocklist(cli, m.(binary.RegularMsg))
+	case binary.MsgTypePauseRoom:
+		return msgPauseRoom(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeCreateSuccessor:
+		return msgCreateSuccessor(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeRelayToParent:
+		return msgRelayToParent(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeAddBot:
+		return msgAddBot(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeRemoveBot:
+		return msgRemoveBot(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeBotMessage:
+		return msgBotMessage(cli, m.(binary.RegularMsg))
+	case binary.MsgTypePromoteWatcher:
+		return msgPromoteWatcher(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeMergeRoom:
+		return msgMergeRoom(cli, m.(binary.RegularMsg))
+	case binary.MsgTypeClientDeadline:
+		return msgClientDeadline(cli, m.(binary.RegularMsg))
 	}
 	return nil, xerrors.Errorf("unknown msg type: %T %v", m, m)
 }
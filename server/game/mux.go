@@ -0,0 +1,327 @@
+package game
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shiguredo/websocket"
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+	"wsnet2/log"
+)
+
+// 多重化した接続:
+// 1つのwebsocket接続を部屋毎のハンドルに分け、ハンドル毎にPeerを作る. フレームの形式は binary/mux.go.
+// Peerからは部屋毎のwebsocket接続と同じに見えるので、再接続やイベントの再送はハンドル毎に行われる.
+// 受信したフレームは順にハンドルのPeerへ渡すので、1つの部屋が詰まると他の部屋の受信も待たされる.
+
+// peerConn : Peerが送受信に使う接続. *websocket.Conn か muxChannel
+type peerConn interface {
+	ReadMessage() (int, []byte, error)
+	WriteMessage(messageType int, data []byte) error
+	NextWriter(messageType int) (io.WriteCloser, error)
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+var _ peerConn = &websocket.Conn{}
+var _ peerConn = &muxChannel{}
+
+// MuxResolver : MuxAttachの部屋IDとクライアントIDから認証済みのClientを得る
+type MuxResolver func(roomId, clientId, authData string) (*Client, error)
+
+// Mux : 複数の部屋のPeerを多重化したwebsocket接続
+type Mux struct {
+	conn            *websocket.Conn
+	protocolVersion int
+	logger          log.Logger
+
+	// muWrite : websocketへの書き込みは全ハンドルで排他する
+	muWrite sync.Mutex
+	closed  bool
+
+	mu       sync.Mutex
+	channels map[byte]*muxChannel
+}
+
+func NewMux(conn *websocket.Conn, protocolVersion int, logger log.Logger) *Mux {
+	return &Mux{
+		conn:            conn,
+		protocolVersion: protocolVersion,
+		logger:          logger,
+		channels:        make(map[byte]*muxChannel),
+	}
+}
+
+// Serve : websocketが閉じるまでフレームを受信してハンドル毎のPeerに渡す.
+// 終了時には全てのハンドルのPeerを切り離す.
+func (m *Mux) Serve(ctx context.Context, resolve MuxResolver) {
+	defer m.closeAll()
+	for {
+		_, data, err := m.conn.ReadMessage()
+		if err != nil {
+			if !m.isClosed() && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseAbnormalClosure, websocket.CloseGoingAway) {
+				m.logger.Errorf("mux read error: %T %+v", err, err)
+			}
+			return
+		}
+
+		handle, body, err := binary.ParseMuxFrame(data)
+		if err == nil && handle == binary.MuxControlHandle {
+			err = m.control(ctx, body, resolve)
+		}
+		if err != nil {
+			m.logger.Errorf("mux invalid frame: %+v", err)
+			m.closeWithMessage(websocket.CloseInvalidFramePayloadData, binary.CloseReasonInvalidMessage, err.Error())
+			return
+		}
+		if handle == binary.MuxControlHandle {
+			continue
+		}
+
+		ch := m.channel(handle)
+		if ch == nil {
+			// 閉じたハンドルへの送信は行き違いなので捨てる
+			m.logger.Debugf("mux handle %v is not attached", handle)
+			continue
+		}
+		ch.deliver(body)
+	}
+}
+
+func (m *Mux) control(ctx context.Context, body []byte, resolve MuxResolver) error {
+	ctrl, payload, err := binary.UnmarshalMuxControl(body)
+	if err != nil {
+		return err
+	}
+	switch ctrl {
+	case binary.MuxAttach:
+		p, err := binary.UnmarshalMuxAttachPayload(payload)
+		if err != nil {
+			return err
+		}
+		m.attach(ctx, p, resolve)
+	case binary.MuxDetach:
+		h, err := binary.UnmarshalMuxDetachPayload(payload)
+		if err != nil {
+			return err
+		}
+		if ch := m.channel(h); ch != nil {
+			m.logger.Infof("mux detach: handle=%v", h)
+			ch.shutdown(&websocket.CloseError{Code: websocket.CloseNormalClosure, Text: "detached"})
+		}
+	default:
+		return xerrors.Errorf("unknown mux control: %v", ctrl)
+	}
+	return nil
+}
+
+func (m *Mux) attach(ctx context.Context, p *binary.MuxAttachPayload, resolve MuxResolver) {
+	m.mu.Lock()
+	if _, ok := m.channels[p.Handle]; ok {
+		m.mu.Unlock()
+		m.logger.Infof("mux attach: handle %v is in use", p.Handle)
+		m.writeClosed(p.Handle, formatCloseMessage(websocket.CloseNormalClosure, binary.CloseReasonAttachFailed, "handle is in use"))
+		return
+	}
+	ch := &muxChannel{
+		mux:    m,
+		handle: p.Handle,
+		recv:   make(chan []byte),
+		done:   make(chan struct{}),
+	}
+	m.channels[p.Handle] = ch
+	m.mu.Unlock()
+
+	cli, err := resolve(p.RoomId, p.ClientId, p.AuthData)
+	if err != nil {
+		m.logger.Infof("mux attach: room=%v client=%v: %+v", p.RoomId, p.ClientId, err)
+		ch.WriteMessage(websocket.CloseMessage, formatCloseMessage(websocket.CloseNormalClosure, binary.CloseReasonAttachFailed, err.Error()))
+		ch.Close()
+		return
+	}
+	m.logger.Infof("mux attach: handle=%v room=%v client=%v", p.Handle, p.RoomId, p.ClientId)
+
+	peer, err := NewPeer(ctx, cli, ch, p.LastEvSeq, m.protocolVersion)
+	if err != nil {
+		m.logger.Warnf("mux attach: NewPeer: %+v", err)
+		ch.Close()
+		return
+	}
+	go func() {
+		<-peer.Done()
+		ch.Close()
+	}()
+}
+
+func (m *Mux) channel(h byte) *muxChannel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.channels[h]
+}
+
+func (m *Mux) remove(ch *muxChannel) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.channels[ch.handle] == ch {
+		delete(m.channels, ch.handle)
+	}
+}
+
+func (m *Mux) closeAll() {
+	m.mu.Lock()
+	channels := m.channels
+	m.channels = make(map[byte]*muxChannel)
+	m.mu.Unlock()
+
+	for _, ch := range channels {
+		ch.shutdown(&websocket.CloseError{Code: websocket.CloseAbnormalClosure, Text: "mux closed"})
+	}
+	m.muWrite.Lock()
+	defer m.muWrite.Unlock()
+	m.closed = true
+	m.conn.Close()
+}
+
+func (m *Mux) isClosed() bool {
+	m.muWrite.Lock()
+	defer m.muWrite.Unlock()
+	return m.closed
+}
+
+func (m *Mux) closeWithMessage(code int, reason binary.CloseReason, msg string) {
+	m.muWrite.Lock()
+	defer m.muWrite.Unlock()
+	if m.closed {
+		return
+	}
+	writeMessage(m.conn, websocket.CloseMessage, formatCloseMessage(code, reason, msg))
+	m.closed = true
+	m.conn.Close()
+}
+
+// writeClosed : ハンドルが閉じたことを通知する
+func (m *Mux) writeClosed(h byte, closeMsg []byte) error {
+	m.muWrite.Lock()
+	defer m.muWrite.Unlock()
+	if m.closed {
+		return net.ErrClosed
+	}
+	return writeMessage(m.conn, websocket.BinaryMessage, binary.MarshalMuxClosed(h, closeMsg))
+}
+
+// muxChannel : 多重化した接続の1つのハンドル
+type muxChannel struct {
+	mux    *Mux
+	handle byte
+
+	recv chan []byte
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// deliver : 受信したフレームをPeerに渡す. Peerが閉じていたら捨てる
+func (ch *muxChannel) deliver(data []byte) {
+	select {
+	case ch.recv <- data:
+	case <-ch.done:
+	}
+}
+
+// shutdown : ハンドルを閉じ、ReadMessageにerrを返す
+func (ch *muxChannel) shutdown(err error) {
+	ch.once.Do(func() {
+		ch.err = err
+		close(ch.done)
+	})
+	ch.mux.remove(ch)
+}
+
+func (ch *muxChannel) isDone() bool {
+	select {
+	case <-ch.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ch *muxChannel) ReadMessage() (int, []byte, error) {
+	select {
+	case data := <-ch.recv:
+		return websocket.BinaryMessage, data, nil
+	case <-ch.done:
+		return 0, nil, ch.err
+	}
+}
+
+// WriteMessage : CloseMessageはハンドルのMuxClosedとして送る
+func (ch *muxChannel) WriteMessage(messageType int, data []byte) error {
+	if messageType == websocket.CloseMessage {
+		if ch.isDone() {
+			return net.ErrClosed
+		}
+		return ch.mux.writeClosed(ch.handle, data)
+	}
+	w, err := ch.NextWriter(messageType)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// NextWriter : 返したwriterをCloseするまで他のハンドルの書き込みを待たせる
+func (ch *muxChannel) NextWriter(messageType int) (io.WriteCloser, error) {
+	m := ch.mux
+	m.muWrite.Lock()
+	if m.closed || ch.isDone() {
+		m.muWrite.Unlock()
+		return nil, net.ErrClosed
+	}
+	m.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	w, err := m.conn.NextWriter(messageType)
+	if err != nil {
+		m.muWrite.Unlock()
+		return nil, err
+	}
+	if _, err := w.Write([]byte{ch.handle}); err != nil {
+		w.Close()
+		m.muWrite.Unlock()
+		return nil, err
+	}
+	return &muxWriter{w: w, mu: &m.muWrite}, nil
+}
+
+// SetWriteDeadline : 書き込みのタイムアウトはMuxが設定する
+func (ch *muxChannel) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (ch *muxChannel) Close() error {
+	ch.shutdown(net.ErrClosed)
+	return nil
+}
+
+type muxWriter struct {
+	w  io.WriteCloser
+	mu *sync.Mutex
+}
+
+func (w *muxWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w *muxWriter) Close() error {
+	defer w.mu.Unlock()
+	return w.w.Close()
+}
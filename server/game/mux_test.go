@@ -0,0 +1,71 @@
+package game
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shiguredo/websocket"
+	"go.uber.org/zap"
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+)
+
+func TestMuxAttachFailed(t *testing.T) {
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		m := NewMux(conn, binary.ProtocolVersion1, zap.NewNop().Sugar())
+		m.Serve(context.Background(), func(roomId, clientId, authData string) (*Client, error) {
+			return nil, xerrors.Errorf("room not found: %v", roomId)
+		})
+	}))
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+
+	// 割り当てていないハンドルへの送信は捨てられる
+	if err := ws.WriteMessage(websocket.BinaryMessage, binary.MuxFrame(2, []byte("msg"))); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// 割り当てに失敗したらMuxClosedで通知される
+	if err := ws.WriteMessage(websocket.BinaryMessage, binary.MarshalMuxAttach(1, "room", "client", "auth", 0)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	handle, body, err := binary.ParseMuxFrame(data)
+	if err != nil || handle != binary.MuxControlHandle {
+		t.Fatalf("ParseMuxFrame = (%v, %v), wants control frame", handle, err)
+	}
+	ctrl, payload, _ := binary.UnmarshalMuxControl(body)
+	if ctrl != binary.MuxClosed || payload[1] != 1 {
+		t.Fatalf("control = %v %v, wants MuxClosed for handle 1", ctrl, payload)
+	}
+	if reason, _ := binary.ParseCloseText(string(payload[4:])); reason != binary.CloseReasonAttachFailed {
+		t.Fatalf("close reason = %v, wants %v", reason, binary.CloseReasonAttachFailed)
+	}
+
+	// 不正な制御フレームでは接続ごと閉じる
+	if err := ws.WriteMessage(websocket.BinaryMessage, []byte{binary.MuxControlHandle, 0xff}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_, _, err = ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseInvalidFramePayloadData) {
+		t.Fatalf("read error = %v, wants close %v", err, websocket.CloseInvalidFramePayloadData)
+	}
+}
@@ -17,6 +17,9 @@ import (
 
 const (
 	writeTimeout = 3 * time.Second
+
+	// maxBatchSize : EvTypeBatchの1フレームにまとめるイベントの合計サイズの上限
+	maxBatchSize = 64 * 1024
 )
 
 // Peer : websocketの接続
@@ -26,7 +29,7 @@ const (
 //   - (1001) CloseGoingAway (C#: WebsocketCloseStatus.EndpointUnavailable)
 type Peer struct {
 	client *Client
-	conn   *websocket.Conn
+	conn   peerConn
 	msgCh  chan binary.Msg
 
 	done     chan struct{}
@@ -36,13 +39,47 @@ type Peer struct {
 	closed  bool
 
 	evSeqNum int
+
+	// batch : 複数のRegularEventをEvTypeBatchにまとめて送る
+	batch bool
+	// hubStatus : EvTypeHubStatusを受け取れる
+	hubStatus bool
+	// rejoinConfirm : EvTypeRejoinRequestedとEvTypeDisplacedを受け取れる
+	rejoinConfirm bool
+	// redaction : EvTypeRedactedを受け取れる
+	redaction bool
+	// errorEvent : EvTypeErrorを受け取れる
+	errorEvent bool
+	// slowConsumerEvent : EvTypeSlowConsumerを受け取れる
+	slowConsumerEvent bool
+	// pingInterval : EvTypePeerReadyでPingの間隔を受け取れる
+	pingInterval bool
+	// deadlineChanged : EvTypeDeadlineChangedを受け取れる
+	deadlineChanged bool
+
+	// slow : 受信の遅れの検出. see: slow_consumer.go
+	slow slowConsumerDetector
+	// delivery : 送信したフレームの送信時刻. see: delivery.go
+	delivery deliveryTracker
 }
 
-func NewPeer(ctx context.Context, cli *Client, conn *websocket.Conn, lastEvSeq int) (*Peer, error) {
+// NewPeer : Peerを生成してClientに紐付ける.
+// protocolVersion はクライアントが対応するプロトコルバージョン (see binary.ProtocolVersionHeader).
+// connは部屋毎のwebsocket接続か、多重化した接続のハンドル (see mux.go).
+func NewPeer(ctx context.Context, cli *Client, conn peerConn, lastEvSeq, protocolVersion int) (*Peer, error) {
 	p := &Peer{
-		client: cli,
-		conn:   conn,
-		msgCh:  make(chan binary.Msg),
+		client:        cli,
+		conn:          withChaos(withJSONEvents(conn)),
+		msgCh:         make(chan binary.Msg),
+		batch:         protocolVersion >= binary.ProtocolVersionBatch,
+		hubStatus:     protocolVersion >= binary.ProtocolVersionHubStatus,
+		rejoinConfirm: protocolVersion >= binary.ProtocolVersionRejoinConfirm,
+		redaction:     protocolVersion >= binary.ProtocolVersionRedaction,
+		errorEvent:    protocolVersion >= binary.ProtocolVersionErrorEvent,
+
+		slowConsumerEvent: protocolVersion >= binary.ProtocolVersionSlowConsumer,
+		pingInterval:      protocolVersion >= binary.ProtocolVersionPingInterval,
+		deadlineChanged:   protocolVersion >= binary.ProtocolVersionDeadlineChanged,
 
 		done:     make(chan struct{}),
 		detached: make(chan struct{}),
@@ -51,10 +88,10 @@ func NewPeer(ctx context.Context, cli *Client, conn *websocket.Conn, lastEvSeq i
 	}
 	err := cli.AttachPeer(p, lastEvSeq)
 	if err != nil {
-		p.closeWithMessage(websocket.CloseGoingAway, err.Error())
+		p.closeWithMessage(websocket.CloseGoingAway, binary.CloseReasonEventLost, err.Error())
 		return nil, xerrors.Errorf("AttachPeer (%v, peer=%p): %w", cli.Id, p, err)
 	}
-	go p.MsgLoop(ctx)
+	cli.room.Go(func() { p.MsgLoop(ctx) })
 	return p, nil
 }
 
@@ -78,9 +115,22 @@ func (p *Peer) SendReady(lastMsgSeq int) error {
 	if p.closed {
 		return xerrors.New("peer closed")
 	}
-	p.client.logger.Infof("peer ready (%v, peer=%p): lastMsg=%v", p.client.Id, p, lastMsgSeq)
-	ev := binary.NewEvPeerReady(lastMsgSeq)
-	return writeMessage(p.conn, websocket.BinaryMessage, ev.Marshal())
+	var ev *binary.SystemEvent
+	if p.pingInterval {
+		deadline := time.Duration(p.client.deadline.Load())
+		interval := clientPingInterval(p.client.room.PingInterval(), deadline)
+		p.client.logger.Infof("peer ready (%v, peer=%p): lastMsg=%v ping=%v deadline=%v", p.client.Id, p, lastMsgSeq, interval, deadline)
+		ev = binary.NewEvPeerReadyWithPing(lastMsgSeq, uint32(interval/time.Millisecond), uint32(deadline/time.Millisecond))
+	} else {
+		p.client.logger.Infof("peer ready (%v, peer=%p): lastMsg=%v", p.client.Id, p, lastMsgSeq)
+		ev = binary.NewEvPeerReady(lastMsgSeq)
+	}
+	data := ev.Marshal()
+	if err := writeMessage(p.conn, websocket.BinaryMessage, data); err != nil {
+		return err
+	}
+	p.client.addTraffic(0, len(data))
+	return nil
 }
 
 // SendSystemEvent : SystemEventを送信する.
@@ -92,12 +142,37 @@ func (p *Peer) SendSystemEvent(ev *binary.SystemEvent) {
 	if p.closed {
 		return
 	}
+	switch ev.Type() {
+	case binary.EvTypeHubStatus:
+		if !p.hubStatus {
+			return
+		}
+	case binary.EvTypeRejoinRequested, binary.EvTypeDisplaced:
+		if !p.rejoinConfirm {
+			return
+		}
+	case binary.EvTypeError:
+		if !p.errorEvent {
+			return
+		}
+	case binary.EvTypeSlowConsumer:
+		if !p.slowConsumerEvent {
+			return
+		}
+	case binary.EvTypeDeadlineChanged:
+		if !p.deadlineChanged {
+			return
+		}
+	}
 	metrics.MessageSent.Add(1)
-	err := writeMessage(p.conn, websocket.BinaryMessage, ev.Marshal())
-	if err != nil {
+	data := ev.Marshal()
+	err := writeMessage(p.conn, websocket.BinaryMessage, data)
+	if err == nil {
+		p.client.addTraffic(0, len(data))
+	} else {
 		p.client.logger.Warnf("peer send %v (%v, peer=%p): %+v", ev.Type(), p.client.Id, p, err)
 		writeMessage(p.conn, websocket.CloseMessage,
-			formatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+			formatCloseMessage(websocket.CloseInternalServerErr, binary.CloseReasonServerError, err.Error()))
 		p.closed = true
 		p.conn.Close()
 	}
@@ -119,36 +194,61 @@ func (p *Peer) SendEvents(evbuf *common.RingBuf[*binary.RegularEvent]) error {
 		// 頻発するようならevbufのサイズ(ClientConf.EventBufSize)を拡張したほうがよいかも
 		p.client.logger.Errorf("peer evbuf.Read (%v, %p): %+v", p.client.Id, p, err)
 		writeMessage(p.conn, websocket.CloseMessage,
-			formatCloseMessage(websocket.CloseGoingAway, err.Error()))
+			formatCloseMessage(websocket.CloseGoingAway, binary.CloseReasonEventLost, err.Error()))
 		p.closed = true
 		p.conn.Close()
 		return err
 	}
 
+	lag := len(evs)
+	start := time.Now()
 	seqNum := p.evSeqNum
-	for _, ev := range evs {
-		seqNum++
-		buf := ev.Marshal(seqNum)
-		err := writeMessage(p.conn, websocket.BinaryMessage, buf)
+	sim := p.client.room.NetSim()
+	for len(evs) > 0 {
+		n := 1
+		if p.batch {
+			n = batchLen(evs)
+		}
+		if sim != nil && sim.drop() {
+			// 送ったことにして捨てる. see: room_netsim.go
+			seqNum += n
+			evs = evs[n:]
+			continue
+		}
+		var size int
+		var err error
+		if n == 1 {
+			size, err = writeEvent(p.conn, evs[0], seqNum+1)
+		} else {
+			size, err = writeBatch(p.conn, evs[:n], seqNum+1)
+		}
 		if err != nil {
 			// 新しいpeerで復帰できるかもしれない
-			p.client.logger.Warnf("peer send %v (%v, %p): %+v", ev.Type(), p.client.Id, p, err)
+			p.client.logger.Warnf("peer send %v (%v, %p): %+v", evs[0].Type(), p.client.Id, p, err)
 			writeMessage(p.conn, websocket.CloseMessage,
-				formatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+				formatCloseMessage(websocket.CloseInternalServerErr, binary.CloseReasonServerError, err.Error()))
 			p.closed = true
 			p.conn.Close()
 			return nil
 		}
+		p.client.addTraffic(0, size)
+		seqNum += n
+		evs = evs[n:]
+		p.delivery.sent(seqNum, time.Now())
 	}
 	p.evSeqNum = seqNum
+	p.client.delivery.sent(seqNum)
+	if lag > 0 {
+		p.checkSlowConsumer(lag, time.Since(start))
+	}
 	return nil
 }
 
-func (p *Peer) Close(msg string) {
+func (p *Peer) Close(reason binary.CloseReason, msg string) {
 	if p == nil {
 		return
 	}
-	p.closeWithMessage(websocket.CloseNormalClosure, msg)
+	p.closeWithMessage(websocket.CloseNormalClosure, reason, msg)
 }
 
 // Detached from Client (called by Client)
@@ -162,16 +262,31 @@ func (p *Peer) Detached() {
 // CloseWithClientError : クライアントエラーによってwebsocketを切断する.
 // Clientのgoroutineから呼ばれる.
 func (p *Peer) CloseWithClientError(err error) {
-	p.closeWithMessage(websocket.CloseInternalServerErr, err.Error())
+	p.closeWithMessage(websocket.CloseInternalServerErr, binary.CloseReasonClientError, err.Error())
 }
 
-func (p *Peer) closeWithMessage(code int, msg string) {
+func (p *Peer) closeWithMessage(code int, reason binary.CloseReason, msg string) {
 	p.muWrite.Lock()
 	defer p.muWrite.Unlock()
 	if p.closed {
 		return
 	}
-	writeMessage(p.conn, websocket.CloseMessage, formatCloseMessage(code, msg))
+	writeMessage(p.conn, websocket.CloseMessage, formatCloseMessage(code, reason, msg))
+	p.closed = true
+	p.conn.Close()
+}
+
+// closeWithError : EvTypeErrorでエラーの種類を通知してからwebsocketを切断する
+func (p *Peer) closeWithError(code int, reason binary.CloseReason, errCode binary.ErrorCode, msg string) {
+	p.muWrite.Lock()
+	defer p.muWrite.Unlock()
+	if p.closed {
+		return
+	}
+	if p.errorEvent {
+		writeMessage(p.conn, websocket.BinaryMessage, binary.NewEvError(errCode, msg).Marshal())
+	}
+	writeMessage(p.conn, websocket.CloseMessage, formatCloseMessage(code, reason, msg))
 	p.closed = true
 	p.conn.Close()
 }
@@ -190,19 +305,30 @@ loop:
 			} else {
 				p.client.logger.Errorf("peer read error (%v, %p): %T %+v", p.client.Id, p, err, err)
 				if !errors.Is(err, net.ErrClosed) {
-					p.closeWithMessage(websocket.CloseInternalServerErr, err.Error())
+					p.closeWithMessage(websocket.CloseInternalServerErr, binary.CloseReasonServerError, err.Error())
 				}
 			}
 			break loop
 		}
 		metrics.MessageRecv.Add(1)
+		metrics.BytesRecv.Add(int64(len(data)))
+		p.client.addTraffic(len(data), 0)
 
 		msg, err := binary.UnmarshalMsg(p.client.hmac, data)
 		if err != nil {
 			p.client.logger.Errorf("peer UnmarshalMsg (%v, %p): %+v", p.client.Id, p, err)
-			p.closeWithMessage(websocket.CloseInvalidFramePayloadData, err.Error())
+			p.closeWithError(websocket.CloseInvalidFramePayloadData, binary.CloseReasonInvalidMessage, binary.ErrorCodeSchemaViolation, err.Error())
 			break loop
 		}
+		if msg.Type() == binary.MsgTypeEventAck {
+			// 送信時刻を知っているPeerで処理し、部屋には渡さない
+			if err := p.eventAck(msg.Payload()); err != nil {
+				p.client.logger.Errorf("peer eventAck (%v, %p): %+v", p.client.Id, p, err)
+				p.closeWithError(websocket.CloseInvalidFramePayloadData, binary.CloseReasonInvalidMessage, binary.ErrorCodeSchemaViolation, err.Error())
+				break loop
+			}
+			continue
+		}
 
 		select {
 		case <-ctx.Done():
@@ -220,13 +346,84 @@ loop:
 	close(p.done)
 }
 
-func writeMessage(conn *websocket.Conn, messageType int, data []byte) error {
+func writeMessage(conn peerConn, messageType int, data []byte) error {
 	metrics.MessageSent.Add(1)
+	metrics.BytesSent.Add(int64(len(data)))
 	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
 	return conn.WriteMessage(messageType, data)
 }
 
-func formatCloseMessage(closeCode int, text string) []byte {
+// writeEvent : ヘッダとpayloadを1つのメッセージとして書き込み、書き込んだバイト数を返す.
+// broadcastでは同じeventが全peerに送られるため、payloadを複製せずに送信する.
+func writeEvent(conn peerConn, ev *binary.RegularEvent, seqNum int) (int, error) {
+	size := binary.RegularEventHeaderSize + len(ev.Payload())
+	metrics.MessageSent.Add(1)
+	metrics.BytesSent.Add(int64(size))
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	w, err := conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return 0, err
+	}
+	var hdr [binary.RegularEventHeaderSize]byte
+	ev.PutHeader(hdr[:], seqNum)
+	if _, err := w.Write(hdr[:]); err != nil {
+		w.Close()
+		return 0, err
+	}
+	if _, err := w.Write(ev.Payload()); err != nil {
+		w.Close()
+		return 0, err
+	}
+	return size, w.Close()
+}
+
+// batchLen : 先頭から1フレームにまとめるイベントの数. 少なくとも1つは含める.
+func batchLen(evs []*binary.RegularEvent) int {
+	size := binary.BatchEntryHeaderSize + len(evs[0].Payload())
+	n := 1
+	for ; n < len(evs); n++ {
+		size += binary.BatchEntryHeaderSize + len(evs[n].Payload())
+		if size > maxBatchSize {
+			break
+		}
+	}
+	return n
+}
+
+// writeBatch : evsをEvTypeBatchの1つのメッセージとして書き込み、書き込んだバイト数を返す.
+// seqNumは最初のイベントのsequence number.
+func writeBatch(conn peerConn, evs []*binary.RegularEvent, seqNum int) (int, error) {
+	metrics.MessageSent.Add(1)
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	w, err := conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.Write([]byte{byte(binary.EvTypeBatch)}); err != nil {
+		w.Close()
+		return 0, err
+	}
+	size := 1
+	for i, ev := range evs {
+		var hdr [binary.BatchEntryHeaderSize]byte
+		ev.PutBatchEntryHeader(hdr[:], seqNum+i)
+		if _, err := w.Write(hdr[:]); err != nil {
+			w.Close()
+			return 0, err
+		}
+		if _, err := w.Write(ev.Payload()); err != nil {
+			w.Close()
+			return 0, err
+		}
+		size += len(hdr) + len(ev.Payload())
+	}
+	metrics.BytesSent.Add(int64(size))
+	return size, w.Close()
+}
+
+// formatCloseMessage : 理由を付与したClose frameを作る. see binary.FormatCloseText
+func formatCloseMessage(closeCode int, reason binary.CloseReason, text string) []byte {
+	text = binary.FormatCloseText(reason, text)
 	if len(text) > 123 {
 		text = text[:123]
 	}
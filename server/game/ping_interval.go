@@ -0,0 +1,31 @@
+package game
+
+import (
+	"time"
+)
+
+// roomPingInterval : 部屋のPingの間隔. RoomOptionの指定(ミリ秒)はminより短くできない. 0はクライアントが決める
+func roomPingInterval(opt uint32, min time.Duration) time.Duration {
+	d := time.Duration(opt) * time.Millisecond
+	if d > 0 && d < min {
+		return min
+	}
+	return d
+}
+
+// clientPingInterval : クライアントに指定するPingの間隔.
+// 1回Pingを落としてもタイムアウトしないよう、deadlineの1/2を上限とする. 0ならdeadlineの1/3
+func clientPingInterval(interval, deadline time.Duration) time.Duration {
+	if interval == 0 {
+		return deadline / 3
+	}
+	if interval > deadline/2 {
+		return deadline / 2
+	}
+	return interval
+}
+
+// PingInterval : クライアントのPingの間隔 (IRoom実装). 0はクライアントが決める
+func (r *Room) PingInterval() time.Duration {
+	return r.pingInterval
+}
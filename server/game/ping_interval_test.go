@@ -0,0 +1,41 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoomPingInterval(t *testing.T) {
+	tests := []struct {
+		opt  uint32
+		min  time.Duration
+		want time.Duration
+	}{
+		{0, time.Second, 0},
+		{500, time.Second, time.Second},
+		{5000, time.Second, 5 * time.Second},
+		{500, 0, 500 * time.Millisecond},
+	}
+	for _, tc := range tests {
+		if got := roomPingInterval(tc.opt, tc.min); got != tc.want {
+			t.Errorf("roomPingInterval(%v, %v) = %v, wants %v", tc.opt, tc.min, got, tc.want)
+		}
+	}
+}
+
+func TestClientPingInterval(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		deadline time.Duration
+		want     time.Duration
+	}{
+		{0, 30 * time.Second, 10 * time.Second},
+		{5 * time.Second, 30 * time.Second, 5 * time.Second},
+		{20 * time.Second, 30 * time.Second, 15 * time.Second},
+	}
+	for _, tc := range tests {
+		if got := clientPingInterval(tc.interval, tc.deadline); got != tc.want {
+			t.Errorf("clientPingInterval(%v, %v) = %v, wants %v", tc.interval, tc.deadline, got, tc.want)
+		}
+	}
+}
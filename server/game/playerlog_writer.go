@@ -0,0 +1,94 @@
+package game
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+
+	"wsnet2/metrics"
+)
+
+// playerLogBatchSize : 1つのINSERTで書き込むプレイヤーログの件数の上限
+const playerLogBatchSize = 100
+
+const playerLogInsertQuery = "INSERT INTO player_log (`app_id`, `room_id`, `player_id`, `message`, `platform`, `app_version`, `device`, `request_id`, `datetime`) " +
+	"VALUES (:app_id, :room_id, :player_id, :message, :platform, :app_version, :device, :request_id, :datetime)"
+
+type playerLog struct {
+	AppID      string       `db:"app_id"`
+	RoomID     RoomID       `db:"room_id"`
+	PlayerID   ClientID     `db:"player_id"`
+	Message    PlayerLogMsg `db:"message"`
+	Platform   string       `db:"platform"`
+	AppVersion string       `db:"app_version"`
+	Device     string       `db:"device"`
+	RequestID  string       `db:"request_id"`
+	Datetime   time.Time    `db:"datetime"`
+}
+
+// playerLogWriter : プレイヤーログをキューに溜めてまとめてDBに書き込む.
+// DBが使えない間はキューに溜めたままリトライし、キューが溢れたら新しいログを捨てる.
+type playerLogWriter struct {
+	db    *sqlx.DB
+	queue chan *playerLog
+	retry *dbRetry
+}
+
+func newPlayerLogWriter(db *sqlx.DB, queueSize int, retryMax time.Duration) *playerLogWriter {
+	return &playerLogWriter{
+		db:    db,
+		queue: make(chan *playerLog, queueSize),
+		retry: newDBRetry("playerLogWriter", retryMax),
+	}
+}
+
+// add : ログをキューに入れる. 部屋のgoroutineから呼ばれるのでブロックしない.
+func (w *playerLogWriter) add(l *playerLog) {
+	select {
+	case w.queue <- l:
+	default:
+		metrics.PlayerLogDropped.Add(1)
+	}
+}
+
+// run : キューのログを書き込む. Repositoryと同じくプロセス終了まで動き続ける.
+func (w *playerLogWriter) run() {
+	batch := make([]*playerLog, 0, playerLogBatchSize)
+	for {
+		if len(batch) == 0 {
+			batch = append(batch, <-w.queue)
+		}
+		batch = w.fill(batch)
+
+		if err := w.write(batch); err != nil {
+			time.Sleep(w.retry.failed(err))
+			continue
+		}
+		w.retry.succeeded()
+		batch = batch[:0]
+	}
+}
+
+// fill : キューに溜まっているログをplayerLogBatchSizeまでbatchに追加する
+func (w *playerLogWriter) fill(batch []*playerLog) []*playerLog {
+	for len(batch) < playerLogBatchSize {
+		select {
+		case l := <-w.queue:
+			batch = append(batch, l)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (w *playerLogWriter) write(batch []*playerLog) error {
+	if err := chaosDBError(); err != nil {
+		return err
+	}
+	if _, err := w.db.NamedExec(playerLogInsertQuery, batch); err != nil {
+		return xerrors.Errorf("insert player_log (%v rows): %w", len(batch), err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package game
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"wsnet2/metrics"
+)
+
+func TestPlayerLogWriter(t *testing.T) {
+	db, mock := newDbMock(t)
+	w := newPlayerLogWriter(db, 3, time.Second)
+
+	dropped := metrics.PlayerLogDropped.Value()
+	for i := 0; i < 4; i++ {
+		w.add(&playerLog{AppID: "app", RoomID: "room", PlayerID: "player", Message: PlayerLogJoin})
+	}
+	if d := metrics.PlayerLogDropped.Value() - dropped; d != 1 {
+		t.Fatalf("dropped = %v, wants 1", d)
+	}
+
+	// 溜まっているログを1つのINSERTで書き込む
+	batch := w.fill(nil)
+	if len(batch) != 3 {
+		t.Fatalf("batch = %v, wants 3 logs", len(batch))
+	}
+	q := regexp.QuoteMeta("INSERT INTO player_log ") + `.* VALUES \(.+\),\(.+\),\(.+\)$`
+	mock.ExpectExec(q).WillReturnResult(sqlmock.NewResult(0, 3))
+	if err := w.write(batch); err != nil {
+		t.Fatalf("write: %+v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -18,20 +18,28 @@ import (
 	"golang.org/x/xerrors"
 	"google.golang.org/grpc/codes"
 
+	"wsnet2/binary"
+	"wsnet2/common"
 	"wsnet2/config"
 	"wsnet2/log"
 	"wsnet2/pb"
+	"wsnet2/requestid"
 )
 
 const (
 	// RoomID文字列長
 	lenId = 16
+
+	// 全gameサーバで共有する部屋番号シーケンスを進める. see config.RoomNumberSequence
+	roomNumberSeqQuery = "UPDATE `room_number_seq` SET `seq`=LAST_INSERT_ID(`seq`+1) WHERE `id`=1"
 )
 
 var (
 	roomInsertQuery        string
-	roomUpdateQuery        string
+	roomUpdateQuery        string   // 事前確保した部屋の行を使うときの更新. see: roomPool
+	roomUpdateCols         []string // 部屋情報の更新で書き込むroomテーブルのカラム. see: roomInfoWriter
 	roomHistoryInsertQuery string
+	playerStatsInsertQuery string
 
 	randsrc *rand.Rand
 )
@@ -59,9 +67,11 @@ func initQueries() {
 		roomInsertQuery = fmt.Sprintf("INSERT INTO room (%s) VALUES (:%s)",
 			strings.Join(cols, ","), strings.Join(cols, ",:"))
 
-		var sets []string
+		roomUpdateCols = nil
+		sets := make([]string, 0, len(cols))
 		for _, c := range cols {
 			if c != "id" {
+				roomUpdateCols = append(roomUpdateCols, c)
 				sets = append(sets, c+"=:"+c)
 			}
 		}
@@ -74,6 +84,13 @@ func initQueries() {
 		roomHistoryInsertQuery = fmt.Sprintf("INSERT INTO room_history (%s) VALUES (:%s)",
 			strings.Join(cols, ","), strings.Join(cols, ",:"))
 	}
+
+	// player_stats
+	{
+		cols := dbCols(reflect.TypeOf(playerStatsRow{}))
+		playerStatsInsertQuery = fmt.Sprintf("INSERT INTO player_stats (%s) VALUES (:%s)",
+			strings.Join(cols, ","), strings.Join(cols, ",:"))
+	}
 }
 
 func RandomHex(n int) string {
@@ -89,41 +106,129 @@ type Repository struct {
 	conf *config.GameConf
 	db   *sqlx.DB
 
+	clock common.Clock // Room, Clientの時刻とタイマー. テストではFakeClockに差し替える
+
+	publisher EventPublisher // nilならイベントを外部に配信しない
+	hubRelay  HubRelay       // nilならHubへのイベントを中継しない
+	scanner   MessageScanner // nilならメッセージを審査しない
+
+	roomWriter      *roomInfoWriter   // nilなら部屋情報の更新をDBに書き込まない (テスト用)
+	playerLogWriter *playerLogWriter  // nilならプレイヤーログを書き込まない (テスト用)
+	sessions        *sessionWriter    // nilならセッション状態を保存しない. see: session.go
+	pool            *roomPool         // nilなら部屋を事前確保しない. see: room_prewarm.go
+	features        *featureFlagCache // nilなら機能フラグを使わない (テスト用). see: feature_flag.go
+
 	mu      sync.RWMutex
 	rooms   map[RoomID]*Room
 	clients map[ClientID]map[RoomID]*Client
 }
 
 func NewRepos(db *sqlx.DB, conf *config.GameConf, hostId uint32) (map[pb.AppId]*Repository, error) {
-	if _, err := db.Exec("INSERT INTO room_history (room_id, app_id, host_id, number, search_group, max_players, public_props, created, closed) "+
-		"SELECT id, app_id, host_id, number, search_group, max_players, props, created, now() FROM room WHERE host_id=?", hostId); err != nil {
+	var resumes []*resumableRoom
+	if conf.SessionResume {
+		if conf.SessionKey == "" {
+			return nil, xerrors.Errorf("session_key is required for session_resume")
+		}
+		var err error
+		resumes, err = loadSessions(db, conf.SessionKey, hostId, time.Duration(conf.SessionResumeWindow))
+		if err != nil {
+			return nil, xerrors.Errorf("load sessions: %w", err)
+		}
+	}
+	// 復元する部屋以外は前回の起動時の部屋を片付ける. (sqlx.Inは空のスライスを受け付けないので""を入れておく)
+	keep := []string{""}
+	for _, rr := range resumes {
+		keep = append(keep, rr.info.Id)
+	}
+	q, args, err := sqlx.In("INSERT INTO room_history (room_id, app_id, host_id, number, search_group, max_players, public_props, created, closed) "+
+		"SELECT id, app_id, host_id, number, search_group, max_players, props, created, now() FROM room WHERE host_id=? AND id NOT IN (?)", hostId, keep)
+	if err != nil {
+		return nil, xerrors.Errorf("sqlx.In: %w", err)
+	}
+	if _, err := db.Exec(q, args...); err != nil {
 		return nil, xerrors.Errorf("room to history: %w", err)
 	}
-	if _, err := db.Exec("DELETE FROM `room` WHERE host_id=?", hostId); err != nil {
+	q, args, err = sqlx.In("DELETE FROM `room` WHERE host_id=? AND id NOT IN (?)", hostId, keep)
+	if err != nil {
+		return nil, xerrors.Errorf("sqlx.In: %w", err)
+	}
+	if _, err := db.Exec(q, args...); err != nil {
 		return nil, xerrors.Errorf("delete rooms: %w", err)
 	}
-	query := "SELECT id, `key` FROM app"
+	query := "SELECT id, `key` FROM app WHERE disabled = 0"
 	var apps []*pb.App
-	err := db.Select(&apps, query)
+	err = db.Select(&apps, query)
 	if err != nil {
 		return nil, xerrors.Errorf("select apps: %w", err)
 	}
 	log.Debugf("new repos: apps=%v", apps)
 	repos := make(map[pb.AppId]*Repository, len(apps))
 	for _, app := range apps {
-		repos[app.Id] = &Repository{
-			hostId: hostId,
-			app:    app,
-			conf:   conf,
-			db:     db,
-
-			rooms:   make(map[RoomID]*Room),
-			clients: make(map[ClientID]map[RoomID]*Client),
+		repos[app.Id] = newRepository(db, conf, hostId, app)
+	}
+	for _, rr := range resumes {
+		if repo, ok := repos[rr.info.AppId]; ok {
+			repo.resumeRoom(rr)
 		}
 	}
 	return repos, nil
 }
 
+// NewRepo : 起動後に登録されたappのRepositoryを作る
+func NewRepo(db *sqlx.DB, conf *config.GameConf, hostId uint32, appId pb.AppId) (*Repository, error) {
+	var app pb.App
+	err := db.Get(&app, "SELECT id, `key` FROM app WHERE id = ? AND disabled = 0", appId)
+	if err != nil {
+		return nil, xerrors.Errorf("select app (id=%v): %w", appId, err)
+	}
+	log.Debugf("new repo: app=%v", app.Id)
+	return newRepository(db, conf, hostId, &app), nil
+}
+
+func newRepository(db *sqlx.DB, conf *config.GameConf, hostId uint32, app *pb.App) *Repository {
+	repo := &Repository{
+		hostId: hostId,
+		app:    app,
+		conf:   conf,
+		db:     db,
+		clock:  common.RealClock,
+
+		roomWriter: newRoomInfoWriter(db, time.Duration(conf.RoomInfoFlushInterval), conf.RoomInfoBatchSize,
+			time.Duration(conf.DbRetryMaxInterval)),
+		playerLogWriter: newPlayerLogWriter(db, conf.PlayerLogQueueSize, time.Duration(conf.DbRetryMaxInterval)),
+		features:        newFeatureFlagCache(db, app.Id),
+
+		rooms:   make(map[RoomID]*Room),
+		clients: make(map[ClientID]map[RoomID]*Client),
+	}
+	go repo.roomWriter.run()
+	go repo.playerLogWriter.run()
+	if conf.SessionResume {
+		repo.sessions = newSessionWriter(db, conf.SessionKey, time.Duration(conf.SessionSaveInterval), time.Duration(conf.DbRetryMaxInterval))
+		go repo.sessions.run()
+	}
+	if conf.PrewarmRooms > 0 {
+		repo.pool = newRoomPool(repo, conf.PrewarmRooms)
+		go repo.pool.run()
+	}
+	return repo
+}
+
+// SetEventPublisher : broadcastされたイベントをpに配信する. 部屋を作る前に呼ぶこと
+func (repo *Repository) SetEventPublisher(p EventPublisher) {
+	repo.publisher = p
+}
+
+// SetHubRelay : Hubのクライアントに送るイベントをrにも渡す. 部屋を作る前に呼ぶこと
+func (repo *Repository) SetHubRelay(r HubRelay) {
+	repo.hubRelay = r
+}
+
+// SetMessageScanner : 中継したメッセージをsに審査させる. 部屋を作る前に呼ぶこと
+func (repo *Repository) SetMessageScanner(s MessageScanner) {
+	repo.scanner = s
+}
+
 func (repo *Repository) CreateRoom(ctx context.Context, op *pb.RoomOption, master *pb.ClientInfo, macKey string) (*pb.JoinedRoomRes, ErrorWithCode) {
 	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
@@ -141,27 +246,73 @@ func (repo *Repository) CreateRoom(ctx context.Context, op *pb.RoomOption, maste
 			xerrors.Errorf("reached to the max_clients"), codes.ResourceExhausted)
 	}
 
+	if d := time.Duration(op.WatcherDelay) * time.Second; d > time.Duration(repo.conf.MaxWatcherDelay) {
+		return nil, WithCode(
+			xerrors.Errorf("watcher_delay exceeds max_watcher_delay: %v", d), codes.InvalidArgument)
+	}
+	if int(op.HistorySize) > repo.conf.MaxHistorySize {
+		return nil, WithCode(
+			xerrors.Errorf("history_size exceeds max_history_size: %v", op.HistorySize), codes.InvalidArgument)
+	}
+	if op.RejoinPolicy > pb.RejoinPolicyConfirm {
+		return nil, WithCode(
+			xerrors.Errorf("invalid rejoin_policy: %v", op.RejoinPolicy), codes.InvalidArgument)
+	}
+	players := uint32(1)
+	if master == nil {
+		// 観戦専用の部屋. see: room_watchonly.go
+		if op.MaxPlayers != 0 {
+			return nil, WithCode(
+				xerrors.Errorf("watch-only room must have max_players=0: %v", op.MaxPlayers), codes.InvalidArgument)
+		}
+		op.Joinable = false
+		players = 0
+	}
+	if op.ParentRoom != "" {
+		// ロビー部屋の子の部屋. see: room_lobby.go
+		if op.LobbyRoom {
+			return nil, WithCode(
+				xerrors.Errorf("lobby room can not have parent: %v", op.ParentRoom), codes.InvalidArgument)
+		}
+		parent, err := repo.GetRoom(op.ParentRoom)
+		if err != nil {
+			return nil, WithCode(xerrors.Errorf("parent room: %w", err), codes.NotFound)
+		}
+		if !parent.lobbyRoom {
+			return nil, WithCode(
+				xerrors.Errorf("parent room is not a lobby room: %v", op.ParentRoom), codes.InvalidArgument)
+		}
+	}
+
 	tx, err := repo.db.Beginx()
 	if err != nil {
 		return nil, WithCode(xerrors.Errorf("db.Beginx: %w", err), codes.Internal)
 	}
 
-	info, ewc := repo.newRoomInfo(ctx, tx, op)
-	if ewc != nil {
-		tx.Rollback()
-		return nil, ewc
+	info, prewarmed := repo.prewarmedRoomInfo(ctx, tx, op, players)
+	if info == nil {
+		var ewc ErrorWithCode
+		info, ewc = repo.newRoomInfo(ctx, tx, op, players)
+		if ewc != nil {
+			tx.Rollback()
+			return nil, ewc
+		}
 	}
 
 	loglevel := log.CurrentLevel()
 	if op.LogLevel > 0 {
 		loglevel = log.Level(op.LogLevel)
 	}
-	logger := log.Get(loglevel).With(log.KeyApp, repo.app.Id, log.KeyRoom, info.Id)
-	logger.Infof("new room: %v, num=%v, master=%v", info.Id, info.Number.Number, master.Id)
+	logger := log.Get(loglevel).With(log.KeyApp, repo.app.Id, log.KeyRoom, info.Id, log.KeyRoomRequestId, requestid.FromContext(ctx))
+	logger.Infof("new room: %v, num=%v, master=%v", info.Id, info.Number.Number, master.GetId())
 
-	room, joined, ewc := NewRoom(ctx, repo, info, master, macKey, op.ClientDeadline, repo.conf, logger)
+	room, joined, ewc := NewRoom(ctx, repo, info, master, macKey, op, repo.conf, logger)
 	if ewc != nil {
 		tx.Rollback()
+		if prewarmed != nil {
+			// rollbackで確保済みの行に戻っている
+			repo.pool.put(*prewarmed)
+		}
 		return nil, WithCode(xerrors.Errorf("NewRoom: %w", ewc), ewc.Code())
 	}
 
@@ -187,6 +338,13 @@ func (repo *Repository) CreateRoom(ctx context.Context, op *pb.RoomOption, maste
 	}
 
 	repo.rooms[room.ID()] = room
+	if cli == nil {
+		return &pb.JoinedRoomRes{
+			RoomInfo:     joined.Room,
+			Deadline:     uint32(joined.Deadline / time.Second),
+			FeatureFlags: room.features,
+		}, nil
+	}
 	if _, ok := repo.clients[cli.ID()]; !ok {
 		repo.clients[cli.ID()] = make(map[RoomID]*Client)
 	}
@@ -198,18 +356,23 @@ func (repo *Repository) CreateRoom(ctx context.Context, op *pb.RoomOption, maste
 		AuthKey:  cli.authKey,
 		MasterId: string(joined.MasterId),
 		Deadline: uint32(joined.Deadline / time.Second),
+
+		NoReconnectCloseCodes: repo.conf.NoReconnectCloseCodes,
+		MacAlgorithm:          cli.macAlg,
+		FeatureFlags:          room.features,
 	}, nil
 }
 
-func (repo *Repository) JoinRoom(ctx context.Context, id string, client *pb.ClientInfo, macKey string) (*pb.JoinedRoomRes, ErrorWithCode) {
-	return repo.joinRoom(ctx, id, client, macKey, true)
+// JoinRoom : 部屋に入室する. inviteは招待で入室するときの招待トークン. see: room_invite.go
+func (repo *Repository) JoinRoom(ctx context.Context, id string, client *pb.ClientInfo, macKey, invite string) (*pb.JoinedRoomRes, ErrorWithCode) {
+	return repo.joinRoom(ctx, id, client, macKey, invite, true)
 }
 
 func (repo *Repository) WatchRoom(ctx context.Context, id string, client *pb.ClientInfo, macKey string) (*pb.JoinedRoomRes, ErrorWithCode) {
-	return repo.joinRoom(ctx, id, client, macKey, false)
+	return repo.joinRoom(ctx, id, client, macKey, "", false)
 }
 
-func (repo *Repository) joinRoom(ctx context.Context, id string, client *pb.ClientInfo, macKey string, isPlayer bool) (*pb.JoinedRoomRes, ErrorWithCode) {
+func (repo *Repository) joinRoom(ctx context.Context, id string, client *pb.ClientInfo, macKey, invite string, isPlayer bool) (*pb.JoinedRoomRes, ErrorWithCode) {
 	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
@@ -226,13 +389,22 @@ func (repo *Repository) joinRoom(ctx context.Context, id string, client *pb.Clie
 		return nil, WithCode(xerrors.Errorf("repo.GetRoom: %w", err), codes.NotFound)
 	}
 
+	var inviteExpire time.Time
+	if invite != "" {
+		var ewc ErrorWithCode
+		inviteExpire, ewc = checkInvite(invite, id, client.GetId(), repo.clock.Now())
+		if ewc != nil {
+			return nil, ewc
+		}
+	}
+
 	jch := make(chan *JoinedInfo, 1)
 	errch := make(chan ErrorWithCode, 1)
 	var msg Msg
 	if isPlayer {
-		msg = &MsgJoin{client, macKey, jch, errch}
+		msg = &MsgJoin{Info: client, MACKey: macKey, Joined: jch, Err: errch, RequestId: requestid.FromContext(ctx), InviteExpire: inviteExpire}
 	} else {
-		msg = &MsgWatch{client, macKey, jch, errch}
+		msg = &MsgWatch{Info: client, MACKey: macKey, Joined: jch, Err: errch, RequestId: requestid.FromContext(ctx)}
 	}
 
 	select {
@@ -269,10 +441,15 @@ func (repo *Repository) joinRoom(ctx context.Context, id string, client *pb.Clie
 		AuthKey:  cli.authKey,
 		MasterId: string(joined.MasterId),
 		Deadline: uint32(joined.Deadline / time.Second),
+
+		NoReconnectCloseCodes: repo.conf.NoReconnectCloseCodes,
+		MacAlgorithm:          cli.macAlg,
+		FeatureFlags:          room.features,
 	}, nil
 }
 
-func (repo *Repository) newRoomInfo(ctx context.Context, tx *sqlx.Tx, op *pb.RoomOption) (*pb.RoomInfo, ErrorWithCode) {
+// roomInfoFromOption : opの部屋のRoomInfo. IdとNumberは呼び出し側で決める
+func (repo *Repository) roomInfoFromOption(op *pb.RoomOption, players uint32) *pb.RoomInfo {
 	ri := &pb.RoomInfo{
 		AppId:        repo.app.Id,
 		HostId:       repo.hostId,
@@ -282,13 +459,18 @@ func (repo *Repository) newRoomInfo(ctx context.Context, tx *sqlx.Tx, op *pb.Roo
 		Number:       &pb.RoomNumber{},
 		SearchGroup:  op.SearchGroup,
 		MaxPlayers:   op.MaxPlayers,
-		Players:      1,
+		MaxWatchers:  op.MaxWatchers,
+		Players:      players,
 		PublicProps:  op.PublicProps,
 		PrivateProps: op.PrivateProps,
 	}
 	ri.SetCreated(time.Now())
+	return ri
+}
+
+func (repo *Repository) newRoomInfo(ctx context.Context, tx *sqlx.Tx, op *pb.RoomOption, players uint32) (*pb.RoomInfo, ErrorWithCode) {
+	ri := repo.roomInfoFromOption(op, players)
 
-	maxNumber := int32(repo.conf.MaxRoomNum)
 	retryCount := repo.conf.RetryCount
 	var err error
 	for n := 0; n < retryCount; n++ {
@@ -300,7 +482,10 @@ func (repo *Repository) newRoomInfo(ctx context.Context, tx *sqlx.Tx, op *pb.Roo
 
 		ri.Id = RandomHex(lenId)
 		if op.WithNumber {
-			ri.Number.Number = randsrc.Int31n(maxNumber) + 1 // [1..maxNumber]
+			ri.Number.Number, err = repo.nextRoomNumber(ctx)
+			if err != nil {
+				return nil, WithCode(xerrors.Errorf("nextRoomNumber: %w", err), codes.Internal)
+			}
 		}
 
 		_, err = tx.NamedExecContext(ctx, roomInsertQuery, ri)
@@ -312,17 +497,24 @@ func (repo *Repository) newRoomInfo(ctx context.Context, tx *sqlx.Tx, op *pb.Roo
 	return nil, WithCode(xerrors.Errorf("NewRoomInfo try %d times: %w", retryCount, err), codes.Internal)
 }
 
-func (repo *Repository) updateRoomInfo(ri *pb.RoomInfo, conn *sqlx.Conn, logger log.Logger) {
-	// DBへの反映は遅延して良い
-	q, args, err := sqlx.Named(roomUpdateQuery, ri)
-	if err != nil {
-		logger.Errorf("update roominfo query: q=%v, ri=%v, err=%+v", roomUpdateQuery, ri, err)
-		return
+// nextRoomNumber : 部屋番号の候補 [1..MaxRoomNum] を返す.
+// RoomNumberSequence のときは一周して使用中の番号に当たることがあるので、呼び出し側で重複時にリトライする.
+func (repo *Repository) nextRoomNumber(ctx context.Context) (int32, error) {
+	maxNumber := int64(repo.conf.MaxRoomNum)
+	if repo.conf.RoomNumberAllocator != config.RoomNumberSequence {
+		return randsrc.Int31n(int32(maxNumber)) + 1, nil
 	}
 
-	if _, err := conn.ExecContext(context.Background(), q, args...); err != nil {
-		logger.Errorf("update roominfo: %v %+v", ri.Id, err)
+	// 部屋作成のトランザクションとは別にすることで、シーケンスの行ロックを長く持たない
+	res, err := repo.db.ExecContext(ctx, roomNumberSeqQuery)
+	if err != nil {
+		return 0, xerrors.Errorf("update room_number_seq: %w", err)
 	}
+	seq, err := res.LastInsertId()
+	if err != nil {
+		return 0, xerrors.Errorf("room_number_seq last insert id: %w", err)
+	}
+	return int32((seq-1)%maxNumber) + 1, nil
 }
 
 type roomHistory struct {
@@ -339,6 +531,13 @@ type roomHistory struct {
 }
 
 func (repo *Repository) deleteRoom(room *Room) {
+	if repo.roomWriter != nil {
+		repo.roomWriter.remove(room.Id)
+	}
+	if repo.sessions != nil {
+		repo.sessions.remove(room.Id)
+	}
+
 	var err error
 	_, err = repo.db.Exec("DELETE FROM room WHERE id=?", room.Id)
 	if err != nil {
@@ -370,6 +569,14 @@ func (repo *Repository) deleteRoom(room *Room) {
 	if err != nil {
 		room.logger.Errorf("insert to room_history: %+v", err)
 	}
+
+	// player_stats テーブルに 入室したPlayer毎の統計を保存する
+	if stats := room.playerStatsRows(room.clock.Now()); len(stats) > 0 {
+		_, err = repo.db.NamedExec(playerStatsInsertQuery, stats)
+		if err != nil {
+			room.logger.Errorf("insert to player_stats: %+v", err)
+		}
+	}
 }
 
 func (repo *Repository) RemoveRoom(room *Room) {
@@ -427,6 +634,46 @@ func (repo *Repository) GetRoomCount() int {
 	return len(repo.rooms)
 }
 
+// ClientCount : 部屋に入室しているプレイヤーと観戦者の数
+func (repo *Repository) ClientCount() (players, watchers int) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	for _, cs := range repo.clients {
+		for _, c := range cs {
+			if c.isPlayer.Load() {
+				players++
+			} else {
+				watchers++
+			}
+		}
+	}
+	return players, watchers
+}
+
+// MsgQueueDepth : 部屋のMsgキューに溜まっているMsgの数
+func (repo *Repository) MsgQueueDepth() int {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	n := 0
+	for _, r := range repo.rooms {
+		n += r.msgQueueDepth()
+	}
+	return n
+}
+
+// EventQueueDepth : クライアントに未送信のEventの数
+func (repo *Repository) EventQueueDepth() int {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	n := 0
+	for _, cs := range repo.clients {
+		for _, c := range cs {
+			n += c.evbuf.Len()
+		}
+	}
+	return n
+}
+
 func (repo *Repository) GetRoomInfo(ctx context.Context, id string) (*pb.GetRoomInfoRes, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
@@ -458,6 +705,15 @@ func (repo *Repository) GetRoomInfo(ctx context.Context, id string) (*pb.GetRoom
 	return res, nil
 }
 
+// SubscribeRoomInfo : 部屋情報の変更を購読する
+func (repo *Repository) SubscribeRoomInfo(id string) (*RoomInfoSubscription, ErrorWithCode) {
+	room, err := repo.GetRoom(id)
+	if err != nil {
+		return nil, WithCode(xerrors.Errorf("SubscribeRoomInfo: %w", err), codes.NotFound)
+	}
+	return room.subscribeRoomInfo(), nil
+}
+
 func (repo *Repository) AdminKick(ctx context.Context, roomID, userID string, logger log.Logger) error {
 	if roomID != "" {
 		room, err := repo.GetRoom(roomID)
@@ -512,6 +768,232 @@ func (repo *Repository) adminKickRoom(room *Room, userID string) error {
 	}
 }
 
+// AdminMessage : 全ての部屋に管理者メッセージを送る
+// 送信できた部屋の数を返す
+func (repo *Repository) AdminMessage(ctx context.Context, message string, logger log.Logger) int {
+	repo.mu.RLock()
+	rooms := make([]*Room, 0, len(repo.rooms))
+	for _, room := range repo.rooms {
+		rooms = append(rooms, room)
+	}
+	repo.mu.RUnlock()
+
+	n := 0
+	for _, room := range rooms {
+		msg := &MsgAdminMessage{
+			Message: message,
+		}
+		select {
+		case <-ctx.Done():
+			logger.Errorf("Repository.AdminMessage: context done: sent=%v/%v", n, len(rooms))
+			return n
+		case <-room.Done():
+		case room.msgCh <- msg:
+			n++
+		}
+	}
+	return n
+}
+
+// ServerMessage : appのサーバからのメッセージを部屋に送る.
+// clientIDを指定したときはそのクライアントだけに送り、roomIDが空のときはクライアントが入室している全ての部屋で送る.
+// 送信できた部屋の数を返す.
+func (repo *Repository) ServerMessage(ctx context.Context, roomID, clientID string, data []byte, logger log.Logger) (int, ErrorWithCode) {
+	if _, _, err := binary.Unmarshal(data); err != nil {
+		return 0, WithCode(xerrors.Errorf("ServerMessage: invalid data: %w", err), codes.InvalidArgument)
+	}
+
+	if roomID != "" {
+		room, err := repo.GetRoom(roomID)
+		if err != nil {
+			return 0, WithCode(xerrors.Errorf("ServerMessage: can not find room %q; %w", roomID, err), codes.NotFound)
+		}
+		if err := repo.serverMessageRoom(ctx, room, clientID, data); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	if clientID == "" {
+		return 0, WithCode(xerrors.Errorf("ServerMessage: room or client must be specified"), codes.InvalidArgument)
+	}
+
+	repo.mu.RLock()
+	rooms := make([]*Room, 0, len(repo.clients[ClientID(clientID)]))
+	for rid := range repo.clients[ClientID(clientID)] {
+		if room, ok := repo.rooms[rid]; ok {
+			rooms = append(rooms, room)
+		}
+	}
+	repo.mu.RUnlock()
+
+	n := 0
+	for _, room := range rooms {
+		if err := repo.serverMessageRoom(ctx, room, clientID, data); err != nil {
+			logger.Infof("Repository.ServerMessage: client=%q room=%q err=%+v", clientID, room.Id, err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (repo *Repository) serverMessageRoom(ctx context.Context, room *Room, clientID string, data []byte) ErrorWithCode {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	ch := make(chan error, 1)
+	msg := &MsgServerMessage{
+		Target: ClientID(clientID),
+		Data:   data,
+		Res:    ch,
+	}
+	select {
+	case <-ctx.Done():
+		return WithCode(
+			xerrors.Errorf("ServerMessage write msg timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case <-room.Done():
+		return WithCode(xerrors.Errorf("ServerMessage: room closed: room=%q", room.Id), codes.NotFound)
+	case room.msgCh <- msg:
+	}
+
+	select {
+	case <-ctx.Done():
+		return WithCode(
+			xerrors.Errorf("ServerMessage response timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case err := <-ch:
+		if err != nil {
+			return WithCode(xerrors.Errorf("ServerMessage: %w", err), codes.NotFound)
+		}
+		return nil
+	}
+}
+
+// AdminCloseRoom : 部屋を閉じる
+func (repo *Repository) AdminCloseRoom(ctx context.Context, roomID, reason string) ErrorWithCode {
+	room, err := repo.GetRoom(roomID)
+	if err != nil {
+		return WithCode(xerrors.Errorf("AdminCloseRoom: can not find room %q; %w", roomID, err), codes.NotFound)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	ch := make(chan error, 1)
+	msg := &MsgAdminClose{
+		Reason: reason,
+		Res:    ch,
+	}
+	select {
+	case <-ctx.Done():
+		return WithCode(
+			xerrors.Errorf("AdminCloseRoom write msg timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case room.msgCh <- msg:
+	}
+
+	select {
+	case <-ctx.Done():
+		return WithCode(
+			xerrors.Errorf("AdminCloseRoom response timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case err := <-ch:
+		if err != nil {
+			return WithCode(xerrors.Errorf("AdminCloseRoom: %w", err), codes.FailedPrecondition)
+		}
+		return nil
+	}
+}
+
+// AdminMergeRoom : 部屋を同じgameサーバの別の部屋に統合する. 統合先の部屋に席を確保するまで待つ.
+func (repo *Repository) AdminMergeRoom(ctx context.Context, roomID, targetID string) ErrorWithCode {
+	room, err := repo.GetRoom(roomID)
+	if err != nil {
+		return WithCode(xerrors.Errorf("AdminMergeRoom: can not find room %q; %w", roomID, err), codes.NotFound)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	ch := make(chan error, 1)
+	msg := &MsgAdminMerge{
+		Target: targetID,
+		Res:    ch,
+	}
+	select {
+	case <-ctx.Done():
+		return WithCode(
+			xerrors.Errorf("AdminMergeRoom write msg timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case room.msgCh <- msg:
+	}
+
+	select {
+	case <-ctx.Done():
+		return WithCode(
+			xerrors.Errorf("AdminMergeRoom response timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case err := <-ch:
+		if err != nil {
+			return WithCode(xerrors.Errorf("AdminMergeRoom: %w", err), codes.FailedPrecondition)
+		}
+		return nil
+	}
+}
+
+// AdminSetNetSim : 部屋の通信状況の模擬を設定する. 何も模擬しなければ解除する. see: room_netsim.go
+func (repo *Repository) AdminSetNetSim(roomID string, sim *NetSim) ErrorWithCode {
+	if !repo.conf.DebugNetSim {
+		return WithCode(xerrors.Errorf("AdminSetNetSim: debug_net_sim is disabled"), codes.FailedPrecondition)
+	}
+	room, err := repo.GetRoom(roomID)
+	if err != nil {
+		return WithCode(xerrors.Errorf("AdminSetNetSim: can not find room %q; %w", roomID, err), codes.NotFound)
+	}
+	room.setNetSim(sim)
+	return nil
+}
+
+// WaitSeat : 満室の部屋の順番待ちの列に並び、順番を返す. leaveなら列から外れる. see: room_waitlist.go
+func (repo *Repository) WaitSeat(ctx context.Context, roomID, clientID string, leave bool) (WaitSeatStatus, ErrorWithCode) {
+	room, err := repo.GetRoom(roomID)
+	if err != nil {
+		return WaitSeatStatus{}, WithCode(xerrors.Errorf("WaitSeat: can not find room %q; %w", roomID, err), codes.NotFound)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	ch := make(chan WaitSeatStatus, 1)
+	errCh := make(chan ErrorWithCode, 1)
+	msg := &MsgWaitSeat{
+		ClientId: ClientID(clientID),
+		Leave:    leave,
+		Res:      ch,
+		Err:      errCh,
+	}
+	select {
+	case <-ctx.Done():
+		return WaitSeatStatus{}, WithCode(
+			xerrors.Errorf("WaitSeat write msg timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case room.msgCh <- msg:
+	}
+
+	select {
+	case <-ctx.Done():
+		return WaitSeatStatus{}, WithCode(
+			xerrors.Errorf("WaitSeat response timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case st := <-ch:
+		return st, nil
+	case err := <-errCh:
+		return WaitSeatStatus{}, err
+	}
+}
+
 type PlayerLogMsg string
 
 const (
@@ -521,22 +1003,48 @@ const (
 	PlayerLogLeave  PlayerLogMsg = "Leave"
 	PlayerLogAttach PlayerLogMsg = "Attach"
 	PlayerLogDetach PlayerLogMsg = "Detach"
+	PlayerLogKick   PlayerLogMsg = "Kick"
+	PlayerLogDemote PlayerLogMsg = "Demote"
 )
 
-func (repo *Repository) PlayerLog(c *Client, msg PlayerLogMsg) {
-	const q = "INSERT INTO player_log (`room_id`, `player_id`, `message`, `datetime`) VALUES (:room_id, :player_id, :message, :datetime)"
+// playerLogKicked : Kickの理由とban期間(秒)を付加したログメッセージ
+func playerLogKicked(reason binary.KickReason, ban time.Duration) PlayerLogMsg {
+	return PlayerLogMsg(fmt.Sprintf("%s:%d:%d", PlayerLogKick, reason, ban/time.Second))
+}
 
-	param := map[string]any{
-		"room_id":   c.RoomID(),
-		"player_id": c.ID(),
-		"message":   msg,
-		"datetime":  time.Now(),
-	}
+// player_logの環境情報のカラムの長さ
+const (
+	playerLogPlatformLen   = 32
+	playerLogAppVersionLen = 32
+	playerLogDeviceLen     = 64
+)
 
-	go func() {
-		_, err := repo.db.NamedExec(q, param)
-		if err != nil {
-			c.logger.Errorf("Repository.PlayerLog(%v, %v, %v): %+v", c.RoomID(), c.ID(), msg, err)
+// clipString : 先頭からn文字までに切り詰める
+func clipString(s string, n int) string {
+	i := 0
+	for p := range s {
+		if i == n {
+			return s[:p]
 		}
-	}()
+		i++
+	}
+	return s
+}
+
+// PlayerLog : プレイヤーの入退室などを記録する. DBへの書き込みは非同期にまとめて行う
+func (repo *Repository) PlayerLog(c *Client, msg PlayerLogMsg) {
+	if repo.playerLogWriter == nil {
+		return
+	}
+	repo.playerLogWriter.add(&playerLog{
+		AppID:      repo.app.Id,
+		RoomID:     c.RoomID(),
+		PlayerID:   c.ID(),
+		Message:    msg,
+		Platform:   clipString(c.GetCaps().GetPlatform(), playerLogPlatformLen),
+		AppVersion: clipString(c.AppVersion, playerLogAppVersionLen),
+		Device:     clipString(c.Device, playerLogDeviceLen),
+		RequestID:  c.requestId,
+		Datetime:   time.Now(),
+	})
 }
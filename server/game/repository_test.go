@@ -88,7 +88,7 @@ func TestNewRoomInfo(t *testing.T) {
 
 	randsrc.Seed(seed)
 	tx, _ := db.Beginx()
-	ri, err := repo.newRoomInfo(ctx, tx, op)
+	ri, err := repo.newRoomInfo(ctx, tx, op, 1)
 	if err != nil {
 		t.Fatalf("NewRoomInfo fail: %v", err)
 	}
@@ -108,7 +108,7 @@ func TestNewRoomInfo(t *testing.T) {
 	for i := 0; i < retryCount; i++ {
 		mock.ExpectExec(insQuery).WillReturnError(dupErr)
 	}
-	_, err = repo.newRoomInfo(ctx, tx, op)
+	_, err = repo.newRoomInfo(ctx, tx, op, 1)
 	if !errors.Is(err, dupErr) {
 		t.Fatalf("NewRoomInfo error: %v wants %v", err, dupErr)
 	}
@@ -116,3 +116,59 @@ func TestNewRoomInfo(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+func TestNewRoomInfoSequence(t *testing.T) {
+	ctx := context.Background()
+	db, mock := newDbMock(t)
+	maxNumber := 999
+
+	repo := &Repository{
+		app: &pb.App{Id: "testing"},
+		conf: &config.GameConf{
+			RetryCount:          3,
+			MaxRoomNum:          maxNumber,
+			RoomNumberAllocator: config.RoomNumberSequence,
+		},
+		db: db,
+	}
+	op := &pb.RoomOption{WithNumber: true}
+
+	seqQuery := regexp.QuoteMeta(roomNumberSeqQuery)
+	insQuery := "INSERT INTO room "
+	mock.ExpectBegin()
+	// seq=999は999番, seq=1000は一周して1番になる
+	mock.ExpectExec(seqQuery).WillReturnResult(sqlmock.NewResult(999, 1))
+	mock.ExpectExec(insQuery).WillReturnError(xerrors.Errorf("Duplicate entry"))
+	mock.ExpectExec(seqQuery).WillReturnResult(sqlmock.NewResult(1000, 1))
+	mock.ExpectExec(insQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	tx, _ := db.Beginx()
+	ri, err := repo.newRoomInfo(ctx, tx, op, 1)
+	if err != nil {
+		t.Fatalf("NewRoomInfo fail: %v", err)
+	}
+	if ri.Number.Number != 1 {
+		t.Fatalf("ri.Number = %v, wants %v", ri.Number.Number, 1)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestClipString(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"", 3, ""},
+		{"1.2.3", 5, "1.2.3"},
+		{"1.2.3-beta", 5, "1.2.3"},
+		{"ゲーム端末", 3, "ゲーム"},
+	}
+	for _, tc := range tests {
+		if got := clipString(tc.s, tc.n); got != tc.want {
+			t.Fatalf("clipString(%q, %v) = %q, wants %q", tc.s, tc.n, got, tc.want)
+		}
+	}
+}
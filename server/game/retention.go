@@ -0,0 +1,58 @@
+package game
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+
+	"wsnet2/config"
+)
+
+// 保持期間を過ぎたログの削除:
+// player_log, player_stats, room_history は書き込むだけで増え続けるので、保持期間(日)を過ぎた行を定期的に削除する.
+// 複数のgameサーバが同時に削除しても構わない. ロックを長く取らないよう retentionPurgeLimit 行ずつ削除する.
+// リプレイや監査ログはDBに保存していないので対象外.
+
+const retentionPurgeLimit = 1000
+
+type retentionTarget struct {
+	table  string
+	column string // 保持期間を判定する日時のカラム
+	days   int
+}
+
+func retentionTargets(conf *config.GameConf) []retentionTarget {
+	return []retentionTarget{
+		{"player_log", "datetime", conf.PlayerLogRetentionDays},
+		{"player_stats", "closed", conf.PlayerStatsRetentionDays},
+		{"room_history", "created", conf.RoomHistoryRetentionDays},
+	}
+}
+
+// PurgeExpiredLogs : 保持期間を過ぎた行を削除し、テーブル毎に削除した行数を返す. 保持期間が0のテーブルは削除しない.
+func PurgeExpiredLogs(ctx context.Context, db *sqlx.DB, conf *config.GameConf, now time.Time) (map[string]int64, error) {
+	purged := make(map[string]int64)
+	for _, t := range retentionTargets(conf) {
+		if t.days <= 0 {
+			continue
+		}
+		before := now.AddDate(0, 0, -t.days)
+		for {
+			res, err := db.ExecContext(ctx, "DELETE FROM "+t.table+" WHERE "+t.column+" < ? LIMIT ?", before, retentionPurgeLimit)
+			if err != nil {
+				return purged, xerrors.Errorf("delete %v: %w", t.table, err)
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return purged, xerrors.Errorf("delete %v: %w", t.table, err)
+			}
+			purged[t.table] += n
+			if n < retentionPurgeLimit {
+				break
+			}
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,46 @@
+package game
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"wsnet2/config"
+)
+
+func TestPurgeExpiredLogs(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	db, mock := newDbMock(t)
+	conf := &config.GameConf{
+		PlayerLogRetentionDays:   30,
+		RoomHistoryRetentionDays: 7,
+	}
+
+	// 上限まで削除できたら続きを削除する
+	playerLogQ := regexp.QuoteMeta("DELETE FROM player_log WHERE datetime < ? LIMIT ?")
+	mock.ExpectExec(playerLogQ).WithArgs(now.AddDate(0, 0, -30), retentionPurgeLimit).
+		WillReturnResult(sqlmock.NewResult(0, retentionPurgeLimit))
+	mock.ExpectExec(playerLogQ).WithArgs(now.AddDate(0, 0, -30), retentionPurgeLimit).
+		WillReturnResult(sqlmock.NewResult(0, 10))
+	// 保持期間が0のplayer_statsは削除しない
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM room_history WHERE created < ? LIMIT ?")).
+		WithArgs(now.AddDate(0, 0, -7), retentionPurgeLimit).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	purged, err := PurgeExpiredLogs(ctx, db, conf, now)
+	if err != nil {
+		t.Fatalf("PurgeExpiredLogs: %+v", err)
+	}
+	want := map[string]int64{"player_log": retentionPurgeLimit + 10, "room_history": 0}
+	if !reflect.DeepEqual(purged, want) {
+		t.Fatalf("purged = %v, wants %v", purged, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -1,8 +1,10 @@
 package game
 
 import (
+	"bytes"
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/xerrors"
@@ -13,12 +15,17 @@ import (
 	"wsnet2/config"
 	"wsnet2/log"
 	"wsnet2/metrics"
+	"wsnet2/moderation"
 	"wsnet2/pb"
+	"wsnet2/requestid"
 )
 
 const (
 	// RoomMsgChSize : Msgチャネルのバッファサイズ
 	RoomMsgChSize = 10
+
+	// RoomCloseWait : 部屋を閉じる前にイベントの送信を待つ時間
+	RoomCloseWait = time.Second
 )
 
 type Room struct {
@@ -29,65 +36,174 @@ type Room struct {
 
 	deadline time.Duration
 
-	publicProps  binary.Dict
-	privateProps binary.Dict
+	// 観戦者へのイベントの遅延. see: room_delay.go
+	watcherDelay time.Duration
+	muDelay      sync.Mutex
+	delayQueue   []delayedEvent
+	delayTimer   common.Timer
+
+	kv map[string]*kvEntry
+
+	roles map[string]map[ClientID]struct{} // map[role]members
+	votes map[string]*vote
+
+	pendingProps map[ClientID]*pendingClientProp
+
+	banned map[ClientID]time.Time // map[clientID]ban期限
+
+	// broadcastされたメッセージの履歴 (nilなら保持しない). see: room_history.go
+	history *msgHistory
+
+	// broadcastされたイベントの外部への配信先 (nilなら配信しない). see: wsnet2/bridge
+	publisher EventPublisher
+	hubRelay  HubRelay
+
+	// 中継したメッセージの審査 (nilなら審査しない). see: room_moderation.go
+	scanner MessageScanner
+
+	// クライアントとの送受信バイト数と帯域上限. see: room_bandwidth.go
+	traffic   Traffic
+	limiter   *bandwidthLimiter // nilなら無制限
+	throttled bool
+
+	clock common.Clock
+
+	closing bool
 
 	msgCh    chan Msg
 	done     chan struct{}
 	wgClient sync.WaitGroup
 
+	// 部屋とクライアントのgoroutineの数とプロパティの合計サイズ. see: room_usage.go
+	goroutines atomic.Int32
+	propsBytes atomic.Int64
+
 	muClients   sync.RWMutex
 	players     map[ClientID]*Client
 	master      *Client
 	masterOrder []ClientID
 	watchers    map[ClientID]*Client
 
+	// Targets/ToMaster/Broadcast/Encryptedは中継goroutineで処理する. see: room_relay.go
+	clients      atomic.Pointer[clientsView]
+	relayCh      []chan Msg
+	relayPending sync.WaitGroup
+
+	// MsgToMasterの配送先と受諾待ちのMasterの移譲. see: room_master.go
+	toMaster  masterInbox
+	switching *masterSwitch
+
+	// 同じクライアントIDでの入室の扱いと確認待ちの入室. see: room_rejoin.go
+	rejoinPolicy pb.RejoinPolicy
+	rejoining    map[ClientID]*rejoinRequest
+
+	// 一時停止中の状態 (nilなら停止していない). see: room_pause.go
+	paused *roomPause
+
 	lastMsg binary.Dict // map[clientID]unixtime_millisec
 
+	// MasterがMsgAddBotで追加したボットのPlayer. see: room_bot.go
+	bots map[ClientID]*pb.ClientInfo
+
 	logger log.Logger
 
-	chRoomInfo   chan struct{}
-	mRoomInfo    sync.Mutex // used by updateRoomInfo
+	roomWriter   *roomInfoWriter // nilならDBに書き込まない
+	mRoomInfo    sync.Mutex      // used by updateRoomInfo
 	lastRoomInfo *pb.RoomInfo
+	roomInfoSubs map[*RoomInfoSubscription]struct{} // guarded by mRoomInfo
+
+	// セッション状態の保存先 (nilなら保存しない) と保存に使う部屋作成時のオプション. see: session.go
+	sessions     *sessionWriter
+	maxBandwidth uint32
+	historySize  uint32
+	logLevel     uint32
+
+	// 部屋の寿命 (0なら無制限). see: room_lifetime.go
+	lifetime time.Duration
+
+	// Masterが退室したら、Masterを交代せずに部屋を閉じる
+	closeOnMasterLeave bool
+
+	// 受信が遅れ続けるクライアントの扱い (RoomOptionの指定). see: slow_consumer.go
+	slowConsumer pb.SlowConsumerPolicy
+
+	// クライアントのPingの間隔 (0ならクライアントが決める). see: ping_interval.go
+	pingInterval time.Duration
+
+	// 最後のメッセージからこの時間を過ぎたPlayerをMasterに通知する (0なら通知しない). see: room_stale.go
+	staleThreshold time.Duration
+	stale          *staleWatch
+
+	// 昇格を申請した観戦者を席が空き次第Playerにする. see: room_promote.go
+	autoPromoteWatchers bool
+	promoteQueue        []ClientID
+
+	// Playerの居ない観戦専用の部屋. see: room_watchonly.go
+	watchOnly bool
+
+	// 作成した後継の部屋のIDと、前の部屋から引き継いだ未入室のPlayerのロール. see: room_successor.go
+	successor         string
+	creatingSuccessor bool
+	inheritedRoles    map[ClientID][]string
+
+	// 別の部屋への統合の準備中と、統合元の部屋のPlayerに確保した席. see: room_merge.go
+	merging  bool
+	reserved map[ClientID]time.Time
+
+	// 満室の部屋の順番待ちの列. see: room_waitlist.go
+	waitList []waitingClient
+
+	// Masterが指定したPlayer毎のClientDeadline. see: room_deadline.go
+	clientDeadlines map[ClientID]time.Duration
+
+	// 入室したPlayer毎の統計. 退室したPlayerの分も部屋を閉じるまで残す. see: room_stats.go
+	playerStats map[ClientID]*playerStats
+
+	// 管理者が設定した通信状況の模擬 (nilなら模擬しない). see: room_netsim.go
+	netSim atomic.Pointer[NetSim]
+
+	// 部屋で有効な機能フラグ. 作成後は変更しない. see: feature_flag.go
+	features []string
+
+	// ロビー部屋か、ロビー部屋の子の部屋ならその親の部屋のID. see: room_lobby.go
+	lobbyRoom bool
+	parent    string
 }
 
-func NewRoom(ctx context.Context, repo *Repository, info *pb.RoomInfo, masterInfo *pb.ClientInfo, macKey string, deadlineSec uint32, conf *config.GameConf, logger log.Logger) (*Room, *JoinedInfo, ErrorWithCode) {
-	pubProps, iProps, err := common.InitProps(info.PublicProps)
+func NewRoom(ctx context.Context, repo *Repository, info *pb.RoomInfo, masterInfo *pb.ClientInfo, macKey string, op *pb.RoomOption, conf *config.GameConf, logger log.Logger) (*Room, *JoinedInfo, ErrorWithCode) {
+	_, iProps, err := common.InitProps(info.PublicProps)
 	if err != nil {
 		return nil, nil, WithCode(xerrors.Errorf("PublicProps unmarshal error: %w", err), codes.InvalidArgument)
 	}
 	info.PublicProps = iProps
-	privProps, iProps, err := common.InitProps(info.PrivateProps)
+	_, iProps, err = common.InitProps(info.PrivateProps)
 	if err != nil {
 		return nil, nil, WithCode(xerrors.Errorf("PrivateProps unmarshal error: %w", err), codes.InvalidArgument)
 	}
 	info.PrivateProps = iProps
 
-	r := &Room{
-		RoomInfo: info,
-		repo:     repo,
-		conf:     conf,
-		deadline: time.Duration(deadlineSec) * time.Second,
-
-		publicProps:  pubProps,
-		privateProps: privProps,
-
-		msgCh: make(chan Msg, RoomMsgChSize),
-		done:  make(chan struct{}),
-
-		players:     make(map[ClientID]*Client),
-		masterOrder: []ClientID{},
-		watchers:    make(map[ClientID]*Client),
-		lastMsg:     make(binary.Dict),
-
-		logger: logger,
-
-		chRoomInfo:   make(chan struct{}, 1),
-		lastRoomInfo: info.Clone(),
+	r := newRoom(repo, info, op.ClientDeadline, op.WatcherDelay, op.MaxBandwidth, op.HistorySize, op.RejoinPolicy, op.LogLevel, conf, logger)
+	r.lifetime = roomLifetime(op.Lifetime, conf.MaxRoomLifetimeFor(info.AppId))
+	r.closeOnMasterLeave = op.CloseOnMasterLeave
+	r.slowConsumer = op.SlowConsumerPolicy
+	r.pingInterval = roomPingInterval(op.PingInterval, time.Duration(conf.MinPingInterval))
+	r.staleThreshold = time.Duration(op.StaleThreshold) * time.Millisecond
+	r.autoPromoteWatchers = op.AutoPromoteWatchers
+	r.watchOnly = masterInfo == nil
+	r.lobbyRoom = op.LobbyRoom
+	r.parent = op.ParentRoom
+
+	r.publishClients()
+	r.startRelay(RoomRelayShards)
+
+	if r.watchOnly {
+		// Masterが居ないのでMsgCreateは送らない
+		joined := &JoinedInfo{Room: info.Clone(), Deadline: r.deadline}
+		r.Go(r.MsgLoop)
+		return r, joined, nil
 	}
 
-	go r.MsgLoop()
-	go r.roomInfoUpdater()
+	r.Go(r.MsgLoop)
 
 	jch := make(chan *JoinedInfo, 1)
 	ech := make(chan ErrorWithCode, 1)
@@ -97,7 +213,7 @@ func NewRoom(ctx context.Context, repo *Repository, info *pb.RoomInfo, masterInf
 		return nil, nil, WithCode(
 			xerrors.Errorf("write msg timeout or context done: room=%v client=%v", r.Id, masterInfo.Id),
 			codes.DeadlineExceeded)
-	case r.msgCh <- &MsgCreate{masterInfo, macKey, jch, ech}:
+	case r.msgCh <- &MsgCreate{Info: masterInfo, MACKey: macKey, Joined: jch, Err: ech, RequestId: requestid.FromContext(ctx)}:
 	}
 
 	select {
@@ -113,6 +229,67 @@ func NewRoom(ctx context.Context, repo *Repository, info *pb.RoomInfo, masterInf
 	}
 }
 
+// newRoom : Roomを作る. MsgLoopは呼び出し側で開始する
+func newRoom(repo *Repository, info *pb.RoomInfo, deadlineSec, watcherDelaySec, maxBandwidth, historySize uint32, rejoinPolicy pb.RejoinPolicy, logLevel uint32, conf *config.GameConf, logger log.Logger) *Room {
+	clock := repo.clock
+	if clock == nil {
+		clock = common.RealClock
+	}
+
+	r := &Room{
+		RoomInfo: info,
+		repo:     repo,
+		conf:     conf,
+		deadline: time.Duration(deadlineSec) * time.Second,
+
+		watcherDelay: time.Duration(watcherDelaySec) * time.Second,
+
+		history:   newMsgHistory(int(historySize)),
+		features:  repo.roomFeatures(info.Id),
+		publisher: repo.publisher,
+		hubRelay:  repo.hubRelay,
+		scanner:   repo.scanner,
+
+		kv:    make(map[string]*kvEntry),
+		roles: make(map[string]map[ClientID]struct{}),
+		votes: make(map[string]*vote),
+
+		pendingProps: make(map[ClientID]*pendingClientProp),
+
+		banned: make(map[ClientID]time.Time),
+
+		rejoinPolicy: rejoinPolicy,
+		rejoining:    make(map[ClientID]*rejoinRequest),
+
+		clock: clock,
+
+		msgCh: make(chan Msg, RoomMsgChSize),
+		done:  make(chan struct{}),
+
+		players:     make(map[ClientID]*Client),
+		masterOrder: []ClientID{},
+		watchers:    make(map[ClientID]*Client),
+		lastMsg:     make(binary.Dict),
+		bots:        make(map[ClientID]*pb.ClientInfo),
+
+		logger: logger,
+
+		roomWriter:   repo.roomWriter,
+		lastRoomInfo: info.Clone(),
+
+		maxBandwidth: maxBandwidth,
+		historySize:  historySize,
+		logLevel:     logLevel,
+		sessions:     repo.sessions,
+	}
+
+	if rate := roomBandwidth(maxBandwidth, conf.MaxRoomBandwidth); rate > 0 {
+		r.limiter = newBandwidthLimiter(rate, clock.Now())
+	}
+
+	return r
+}
+
 func (r *Room) ID() RoomID {
 	return RoomID(r.Id)
 }
@@ -121,21 +298,53 @@ func (r *Room) ClientConf() *config.ClientConf {
 	return &r.conf.ClientConf
 }
 
+func (r *Room) MACAlgorithms() []string {
+	return r.conf.MACAlgorithmsFor(r.AppId)
+}
+
 // MsgLoop goroutine dispatch messages.
+// 中継メッセージは中継goroutineに振り分け、それ以外はこのgoroutineで処理する.
 func (r *Room) MsgLoop() {
-	metrics.Rooms.Add(1)
-	defer metrics.Rooms.Add(-1)
+	metrics.AddAppRooms(r.AppId, 1)
+	defer metrics.AddAppRooms(r.AppId, -1)
+
+	var sessionTimer common.Timer
+	var saveSession <-chan time.Time
+	if r.sessions != nil {
+		sessionTimer = r.clock.NewTimer(r.sessions.interval)
+		defer sessionTimer.Stop()
+		saveSession = sessionTimer.C()
+	}
+	if t := r.startLifetime(); t != nil {
+		defer t.Stop()
+	}
+	r.startStaleWatch()
+	defer r.stopStaleWatch()
 Loop:
 	for {
 		select {
 		case <-r.Done():
 			r.logger.Infof("room closed: %v", r.Id)
 			break Loop
+		case <-saveSession:
+			r.waitRelay()
+			r.saveSession()
+			sessionTimer.Reset(r.sessions.interval)
 		case msg := <-r.msgCh:
+			r.throttle()
 			r.updateLastMsg(msg.SenderID())
+			if r.rejectWatchOnly(msg) {
+				continue
+			}
+			if isRelayMsg(msg) {
+				r.relay(msg)
+				continue
+			}
+			r.waitRelay()
 			r.dispatch(msg)
 		}
 	}
+	r.notifyParentClosed()
 	r.repo.RemoveRoom(r)
 	r.drainMsg()
 }
@@ -165,12 +374,13 @@ func (r *Room) Done() <-chan struct{} {
 }
 
 func (r *Room) writeLastMsg(cid ClientID) {
-	millisec := uint64(time.Now().UnixNano()) / 1000000
+	millisec := uint64(r.clock.Now().UnixNano()) / 1000000
 	r.lastMsg[string(cid)] = binary.MarshalULong(millisec)
 }
 
 func (r *Room) removeLastMsg(cid ClientID) {
 	delete(r.lastMsg, string(cid))
+	r.forgetStale(cid)
 }
 
 // UpdateLastMsg : PlayerがMsgを受信したとき更新する.
@@ -179,20 +389,30 @@ func (r *Room) updateLastMsg(cid ClientID) {
 	id := string(cid)
 	if _, ok := r.lastMsg[id]; ok {
 		r.writeLastMsg(cid)
+		r.recoverStale(cid)
 	}
 }
 
 // removeClient :  Player/Watcherを退室させる.
 // muClients のロックを取得してから呼び出す.
 func (r *Room) removeClient(c *Client, cause string) {
-	if c.isPlayer {
-		r.removePlayer(c, cause)
+	if c.isPlayer.Load() {
+		r.removePlayer(c, cause, nil)
 	} else {
 		r.removeWatcher(c, cause)
 	}
 }
 
-func (r *Room) removePlayer(c *Client, cause string) {
+// kickInfo : Kickによる退室の理由
+type kickInfo struct {
+	reason      binary.KickReason
+	banDuration time.Duration
+	demote      bool // 切断せず観戦者にする. see: room_demote.go
+}
+
+// removePlayer : Playerを退室させる.
+// Kickによる退室のときは kick に理由を渡す.
+func (r *Room) removePlayer(c *Client, cause string, kick *kickInfo) {
 	cid := c.ID()
 
 	if r.players[cid] != c {
@@ -201,6 +421,9 @@ func (r *Room) removePlayer(c *Client, cause string) {
 	}
 
 	delete(r.players, cid)
+	delete(r.clientDeadlines, cid)
+	r.updatePropsBytes()
+	r.stopPlayerStats(c)
 
 	for i, id := range r.masterOrder {
 		if id == cid {
@@ -209,72 +432,80 @@ func (r *Room) removePlayer(c *Client, cause string) {
 		}
 	}
 
+	if kick != nil {
+		r.repo.PlayerLog(c, playerLogKicked(kick.reason, kick.banDuration))
+	}
 	r.repo.PlayerLog(c, PlayerLogLeave)
 
-	c.logger.Infof("player left: %v: %v", cid, cause)
-	c.Removed(cause)
+	demoted := kick != nil && kick.demote
+	if demoted {
+		c.logger.Infof("player demoted to watcher: %v: %v", cid, cause)
+		r.demote(c)
+	} else {
+		c.logger.Infof("player left: %v: %v", cid, cause)
+		c.Removed(cause)
+	}
 
 	if len(r.players) == 0 {
+		r.cancelRejoin(c)
 		close(r.done)
 		return
 	}
 
-	if r.master.ID() == cid {
+	// 新Masterには、EvLeftの後、新しいMsgToMasterより前に未送信のイベントを送り直す
+	// closeOnMasterLeaveの部屋では交代せず、退室したMasterのまま部屋を閉じる
+	r.toMaster.mu.Lock()
+	masterLeft := r.master.ID() == cid
+	if masterLeft && !r.closeOnMasterLeave {
 		r.master = r.players[r.masterOrder[0]]
 		r.logger.Infof("master switched: %v -> %v", cid, r.master.ID())
 	}
+	r.publishClients()
 
-	r.RoomInfo.Players = uint32(len(r.players))
+	r.RoomInfo.Players = r.playerCount()
 	r.updateRoomInfo()
 
-	r.broadcast(binary.NewEvLeft(string(cid), r.master.Id, cause))
+	if kick != nil {
+		r.broadcast(binary.NewEvLeftByKick(string(cid), r.master.Id, cause, kick.reason, uint32(kick.banDuration/time.Second)))
+	} else {
+		r.broadcast(binary.NewEvLeft(string(cid), r.master.Id, cause))
+	}
+	if demoted {
+		r.notifyDemoted(cid)
+	}
+	if r.master != c {
+		r.redeliverToMaster(c)
+	}
+	r.toMaster.mu.Unlock()
+	r.cancelSwitch(c)
+	r.cancelRejoin(c)
+	if ev := r.releaseKV(cid); ev != nil {
+		r.broadcast(ev)
+	}
+	r.removeRoles(cid)
+	r.checkVotes()
+	r.dropPendingClientProp(cid)
 
 	r.removeLastMsg(cid)
-}
 
-func (r *Room) roomInfoUpdater() {
-	for {
-		select {
-		case <-r.done:
-			return
-		case <-r.chRoomInfo:
-			for {
-				// mRoomInfo.Lock() はすぐにロック取れるので、先にDB接続を確保する
-				t1 := time.Now()
-				conn, err := r.repo.db.Connx(context.Background())
-				if err != nil {
-					r.logger.Errorf("roomInfoUpdater: conn: %+v", err)
-					time.Sleep(time.Second)
-					continue
-				}
-				if d := time.Since(t1); d > time.Second {
-					r.logger.Warnf("roomInfoUpdater: took %v to get a db conn", d)
-				}
-
-				r.mRoomInfo.Lock()
-				ri := r.lastRoomInfo
-				select {
-				case <-r.chRoomInfo:
-				default:
-				}
-				r.mRoomInfo.Unlock()
-
-				r.repo.updateRoomInfo(ri, conn, r.logger)
-				conn.Close()
-				break
-			}
-		}
+	if masterLeft && r.closeOnMasterLeave && !r.closing {
+		r.logger.Infof("room closing: master left: %v", cid)
+		r.startClosing(binary.RoomClosedMasterLeft)
+		return
 	}
+	r.autoPromote()
+	r.grantWaitingSeats()
 }
 
 func (r *Room) updateRoomInfo() {
 	r.mRoomInfo.Lock()
 	defer r.mRoomInfo.Unlock()
 	r.lastRoomInfo = r.RoomInfo.Clone()
+	r.notifyRoomInfo(r.lastRoomInfo)
 
-	select {
-	case r.chRoomInfo <- struct{}{}:
-	default:
+	// DBへの反映は遅延して良いので他の部屋とまとめて書き込む
+	if r.roomWriter != nil {
+		r.roomWriter.update(r.lastRoomInfo)
 	}
 }
 
@@ -287,6 +518,7 @@ func (r *Room) removeWatcher(c *Client, cause string) {
 	}
 
 	delete(r.watchers, cid)
+	r.publishClients()
 	c.logger.Infof("watcher left: %v: %v", cid, cause)
 
 	r.RoomInfo.Watchers -= c.nodeCount
@@ -312,18 +544,92 @@ func (r *Room) dispatch(msg Msg) {
 		r.msgRoomProp(m)
 	case *MsgClientProp:
 		r.msgClientProp(m)
-	case *MsgTargets:
-		r.msgTargets(m)
-	case *MsgToMaster:
-		r.msgToMaster(m)
-	case *MsgBroadcast:
-		r.msgBroadcast(m)
 	case *MsgSwitchMaster:
 		r.msgSwitchMaster(m)
+	case *MsgAcceptMaster:
+		r.msgAcceptMaster(m)
+	case *MsgConfirmRejoin:
+		r.msgConfirmRejoin(m)
+	case *MsgBlocklist:
+		r.msgBlocklist(m)
+	case *MsgPauseRoom:
+		r.msgPauseRoom(m)
+	case *MsgCreateSuccessor:
+		r.msgCreateSuccessor(m)
+	case *MsgAddBot:
+		r.msgAddBot(m)
+	case *MsgRemoveBot:
+		r.msgRemoveBot(m)
+	case *MsgBotMessage:
+		r.msgBotMessage(m)
+	case *MsgPromoteWatcher:
+		r.msgPromoteWatcher(m)
+	case *MsgMergeRoom:
+		r.msgMergeRoom(m)
+	case *MsgClientDeadline:
+		r.msgClientDeadline(m)
+	case *MsgRelayToParent:
+		r.msgRelayToParent(m)
 	case *MsgKick:
 		r.msgKick(m)
+	case *MsgKVSet:
+		r.msgKVSet(m)
+	case *MsgKVDelete:
+		r.msgKVDelete(m)
+	case *MsgKVCompareAndSwap:
+		r.msgKVCompareAndSwap(m)
+	case *MsgRoles:
+		r.msgRoles(m)
+	case *MsgToRole:
+		r.msgToRole(m)
+	case *MsgStartVote:
+		r.msgStartVote(m)
+	case *MsgCastVote:
+		r.msgCastVote(m)
+	case *MsgFetchHistory:
+		r.msgFetchHistory(m)
+	case *MsgVoteTimeout:
+		r.msgVoteTimeout(m)
+	case *MsgSwitchMasterTimeout:
+		r.msgSwitchMasterTimeout(m)
+	case *MsgRejoinTimeout:
+		r.msgRejoinTimeout(m)
+	case *MsgPauseTimeout:
+		r.msgPauseTimeout(m)
+	case *MsgSuccessorCreated:
+		r.msgSuccessorCreated(m)
+	case *MsgInheritRoles:
+		r.msgInheritRoles(m)
+	case *MsgReserveSeats:
+		r.msgReserveSeats(m)
+	case *MsgMergeReserved:
+		r.msgMergeReserved(m)
+	case *MsgChildRelay:
+		r.msgChildRelay(m)
+	case *MsgModerationVerdict:
+		r.msgModerationVerdict(m)
+	case *MsgClientPropFlush:
+		r.msgClientPropFlush(m)
 	case *MsgAdminKick:
 		r.msgAdminKick(m)
+	case *MsgAdminMessage:
+		r.msgAdminMessage(m)
+	case *MsgServerMessage:
+		r.msgServerMessage(m)
+	case *MsgAdminClose:
+		r.msgAdminClose(m)
+	case *MsgAdminMerge:
+		r.msgAdminMerge(m)
+	case *MsgWaitSeat:
+		r.msgWaitSeat(m)
+	case *MsgReserveInvite:
+		r.msgReserveInvite(m)
+	case *MsgCloseRoom:
+		r.msgCloseRoom(m)
+	case *MsgRoomExpired:
+		r.msgRoomExpired(m)
+	case *MsgStaleCheck:
+		r.msgStaleCheck(m)
 	case *MsgGetRoomInfo:
 		r.msgGetRoomInfo(m)
 	case *MsgClientError:
@@ -336,9 +642,17 @@ func (r *Room) dispatch(msg Msg) {
 }
 
 // sendTo : 特定クライアントに送信.
-// muClients のロックを取得してから呼び出す.
+// muClients のロックを取得してから呼び出す. 中継goroutineからはロックせずに呼ばれる.
 // 送信できない場合続行不能なので退室させる.
 func (r *Room) sendTo(c *Client, ev *binary.RegularEvent) {
+	if !c.isPlayer.Load() && r.watcherDelay > 0 {
+		r.sendDelayed(c, ev)
+		return
+	}
+	r.sendNow(c, ev)
+}
+
+func (r *Room) sendNow(c *Client, ev *binary.RegularEvent) {
 	err := c.Send(ev)
 	if err != nil {
 		c.logger.Infof("sendTo %v: %v", c.Id, err.Error())
@@ -354,6 +668,7 @@ func (r *Room) sendTo(c *Client, ev *binary.RegularEvent) {
 // broadcast : 全員に送信.
 // muClients のロックを取得してから呼び出すこと
 func (r *Room) broadcast(ev *binary.RegularEvent) {
+	r.publish(ev)
 	for _, c := range r.players {
 		r.sendTo(c, ev)
 	}
@@ -362,6 +677,13 @@ func (r *Room) broadcast(ev *binary.RegularEvent) {
 	}
 }
 
+// publish : broadcastするイベントを外部に配信する
+func (r *Room) publish(ev *binary.RegularEvent) {
+	if r.publisher != nil {
+		r.publisher.Publish(r.AppId, r.Id, ev)
+	}
+}
+
 func (r *Room) msgCreate(msg *MsgCreate) {
 	r.muClients.Lock()
 	defer r.muClients.Unlock()
@@ -375,11 +697,15 @@ func (r *Room) msgCreate(msg *MsgCreate) {
 		msg.Err <- err
 		return
 	}
+	master.setRequestId(msg.RequestId)
 	master.logger.Infof("new player: %v", master.Id)
 
 	r.master = master
 	r.players[master.ID()] = master
+	r.updatePropsBytes()
+	r.startPlayerStats(master)
 	r.masterOrder = append(r.masterOrder, master.ID())
+	r.publishClients()
 	r.repo.PlayerLog(master, PlayerLogCreate)
 
 	rinfo := r.RoomInfo.Clone()
@@ -392,6 +718,16 @@ func (r *Room) msgCreate(msg *MsgCreate) {
 }
 
 func (r *Room) msgJoin(msg *MsgJoin) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	r.join(msg, false)
+}
+
+// join : Playerを入室させる.
+// confirmed は入室済みの接続が置き換えを受諾済みか. see: room_rejoin.go
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) join(msg *MsgJoin, confirmed bool) {
 	if !r.Joinable {
 		err := xerrors.Errorf("Room is not joinable. room=%v, client=%v", r.ID(), msg.Info.Id)
 		r.logger.Info(err.Error())
@@ -399,9 +735,6 @@ func (r *Room) msgJoin(msg *MsgJoin) {
 		return
 	}
 
-	r.muClients.Lock()
-	defer r.muClients.Unlock()
-
 	// Timeout前の再入室はclientを差し替え、EvJoinedではなくEvRejoinedを通知
 	oldp, rejoin := r.players[msg.SenderID()]
 	// 観戦しながらの入室は不許可（ただしhub経由で観戦している場合は考慮しない）
@@ -412,7 +745,28 @@ func (r *Room) msgJoin(msg *MsgJoin) {
 		return
 	}
 
-	if !rejoin && r.MaxPlayers <= uint32(len(r.players)) {
+	if until, ok := r.banned[msg.SenderID()]; ok {
+		if r.clock.Now().Before(until) {
+			err := xerrors.Errorf("Client is banned. room=%v, client=%v, until=%v", r.ID(), msg.SenderID(), until)
+			r.logger.Info(err.Error())
+			msg.Err <- WithCode(err, codes.PermissionDenied)
+			return
+		}
+		delete(r.banned, msg.SenderID())
+	}
+
+	displaced := binary.DisplacedByRejoin
+	if rejoin {
+		if confirmed {
+			displaced = binary.DisplacedConfirmed
+		} else if !r.acceptRejoin(msg, oldp) {
+			return
+		}
+	}
+
+	// 同じクライアントIDのボットが居れば席を引き継ぐ. see: room_bot.go
+	_, overBot := r.bots[msg.SenderID()]
+	if !rejoin && !overBot && r.MaxPlayers <= r.playerCount()+r.reservedSeats(msg.SenderID()) {
 		err := xerrors.Errorf("Room full. room=%v max=%v, client=%v", r.ID(), r.MaxPlayers, msg.Info.Id)
 		r.logger.Info(err.Error())
 		msg.Err <- WithCode(err, codes.ResourceExhausted)
@@ -428,21 +782,48 @@ func (r *Room) msgJoin(msg *MsgJoin) {
 		msg.Err <- err
 		return
 	}
+	client.setRequestId(msg.RequestId)
+	if !msg.InviteExpire.IsZero() {
+		client.inviteExpire.Store(msg.InviteExpire.UnixNano())
+	}
 	r.players[client.ID()] = client
+	r.updatePropsBytes()
+	r.startPlayerStats(client)
+	delete(r.reserved, client.ID())
+	r.removeWaiting(client.ID())
+	r.applyInheritedRoles(client.ID())
+	if overBot {
+		r.takeOverBot(client.ID())
+	}
+	deadline := r.clientDeadline(client.ID())
+	if deadline != r.deadline {
+		client.updateDeadline(deadline)
+	}
 	if rejoin {
-		oldp.Removed("client rejoined as a new client")
+		client.historyEnd = oldp.historyEnd
+		oldp.Displaced(displaced, "client rejoined as a new client")
 		if r.master == oldp {
 			r.master = client
 		}
 		r.repo.PlayerLog(client, PlayerLogRejoin)
 		client.logger.Infof("rejoin player: %v", client.Id)
 	} else {
+		client.historyEnd = r.history.total()
 		r.masterOrder = append(r.masterOrder, client.ID())
 		r.repo.PlayerLog(client, PlayerLogJoin)
-		r.RoomInfo.Players = uint32(len(r.players))
+		r.RoomInfo.Players = r.playerCount()
 		r.updateRoomInfo()
 		client.logger.Infof("new player: %v", client.Id)
 	}
+	r.toMaster.mu.Lock()
+	r.publishClients()
+	if rejoin {
+		r.redeliverToMaster(oldp)
+	}
+	r.toMaster.mu.Unlock()
+	if rejoin {
+		r.cancelSwitch(oldp)
+	}
 
 	rinfo := r.RoomInfo.Clone()
 	cinfo := client.ClientInfo.Clone()
@@ -450,12 +831,17 @@ func (r *Room) msgJoin(msg *MsgJoin) {
 	for _, c := range r.players {
 		players = append(players, c.ClientInfo.Clone())
 	}
-	msg.Joined <- &JoinedInfo{rinfo, players, client, r.master.ID(), r.deadline}
-	if rejoin {
+	players = r.appendBotInfos(players)
+	msg.Joined <- &JoinedInfo{rinfo, players, client, r.master.ID(), deadline}
+	if rejoin || overBot {
 		r.broadcast(binary.NewEvRejoined(cinfo))
 	} else {
 		r.broadcast(binary.NewEvJoined(cinfo))
 	}
+	if len(r.kv) > 0 {
+		r.sendTo(client, r.kvSnapshot())
+	}
+	r.notifyPaused(client)
 
 	r.writeLastMsg(client.ID())
 }
@@ -479,6 +865,20 @@ func (r *Room) msgWatch(msg *MsgWatch) {
 		return
 	}
 
+	// 観戦者数はHub経由の観戦者を含む. 満員のときはHubの接続も断る
+	if r.MaxWatchers > 0 {
+		watchers := r.RoomInfo.Watchers
+		if oldc, ok := r.watchers[msg.SenderID()]; ok {
+			watchers -= oldc.nodeCount
+		}
+		if watchers >= r.MaxWatchers {
+			err := xerrors.Errorf("Watchers full. room=%v max=%v, client=%v", r.ID(), r.MaxWatchers, msg.Info.Id)
+			r.logger.Info(err.Error())
+			msg.Err <- WithCode(err, codes.ResourceExhausted)
+			return
+		}
+	}
+
 	client, err := NewWatcher(msg.Info, msg.MACKey, r)
 	if err != nil {
 		err = WithCode(
@@ -488,8 +888,13 @@ func (r *Room) msgWatch(msg *MsgWatch) {
 		msg.Err <- err
 		return
 	}
+	client.setRequestId(msg.RequestId)
+	if client.IsHub {
+		client.hubRelay = r.hubRelay
+	}
 	oldc, rejoin := r.watchers[client.ID()]
 	r.watchers[client.ID()] = client
+	r.publishClients()
 	if rejoin {
 		oldc.Removed("client rejoined as a new client")
 		r.RoomInfo.Watchers -= oldc.nodeCount
@@ -505,14 +910,19 @@ func (r *Room) msgWatch(msg *MsgWatch) {
 	for _, c := range r.players {
 		players = append(players, c.ClientInfo.Clone())
 	}
+	players = r.appendBotInfos(players)
 
-	msg.Joined <- &JoinedInfo{rinfo, players, client, r.master.ID(), r.deadline}
+	msg.Joined <- &JoinedInfo{rinfo, players, client, r.masterID(), r.deadline}
+	if len(r.kv) > 0 {
+		r.sendTo(client, r.kvSnapshot())
+	}
+	r.notifyPaused(client)
 }
 
 func (r *Room) msgPing(msg *MsgPing) {
 	r.muClients.RLock()
 	defer r.muClients.RUnlock()
-	if msg.Sender.isPlayer {
+	if msg.Sender.isPlayer.Load() {
 		if r.players[msg.SenderID()] != msg.Sender {
 			return
 		}
@@ -539,6 +949,10 @@ func (r *Room) msgNodeCount(msg *MsgNodeCount) {
 	}
 	r.RoomInfo.Watchers = (r.RoomInfo.Watchers - c.nodeCount) + msg.Count
 	c.logger.Debugf("nodeCount %v: %v -> %v (total=%v)", c.Id, c.nodeCount, msg.Count, r.RoomInfo.Watchers)
+	if r.MaxWatchers > 0 && r.RoomInfo.Watchers > r.MaxWatchers {
+		// Hubは通知済みの観戦者数で入室を制限するので、通知間隔の間に少し超えることがある
+		c.logger.Infof("watchers exceed max_watchers: %v > %v", r.RoomInfo.Watchers, r.MaxWatchers)
+	}
 	c.nodeCount = msg.Count
 	r.updateRoomInfo()
 }
@@ -562,6 +976,26 @@ func (r *Room) msgRoomProp(msg *MsgRoomProp) {
 	msg.Sender.logger.Debugf("update room props: v=%v j=%v w=%v group=%v maxp=%v deadline=%v public=%v private=%v",
 		msg.Visible, msg.Joinable, msg.Watchable, msg.SearchGroup, msg.MaxPlayer, msg.ClientDeadline, msg.PublicProps, msg.PrivateProps)
 
+	publicProps, err := mergeProps(r.RoomInfo.PublicProps, msg.PublicProps)
+	if err != nil {
+		msg.Sender.logger.Warnf("msgRoomProp: public props: %+v", err)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	privateProps, err := mergeProps(r.RoomInfo.PrivateProps, msg.PrivateProps)
+	if err != nil {
+		msg.Sender.logger.Warnf("msgRoomProp: private props: %+v", err)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	cur := r.propsSize()
+	next := cur - len(r.RoomInfo.PublicProps) - len(r.RoomInfo.PrivateProps) + len(publicProps) + len(privateProps)
+	if err := r.checkPropsSize(cur, next); err != nil {
+		msg.Sender.logger.Warnf("msgRoomProp: %+v", err)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
 	outputlog := r.RoomInfo.Visible != msg.Visible ||
 		r.RoomInfo.Joinable != msg.Joinable ||
 		r.RoomInfo.Watchable != msg.Watchable ||
@@ -574,27 +1008,9 @@ func (r *Room) msgRoomProp(msg *MsgRoomProp) {
 	r.RoomInfo.SearchGroup = msg.SearchGroup
 	r.RoomInfo.MaxPlayers = msg.MaxPlayer
 
-	if len(msg.PublicProps) > 0 {
-		for k, v := range msg.PublicProps {
-			if _, ok := r.publicProps[k]; ok && len(v) == 0 {
-				delete(r.publicProps, k)
-			} else {
-				r.publicProps[k] = v
-			}
-		}
-		r.RoomInfo.PublicProps = binary.MarshalDict(r.publicProps)
-	}
-
-	if len(msg.PrivateProps) > 0 {
-		for k, v := range msg.PrivateProps {
-			if _, ok := r.privateProps[k]; ok && len(v) == 0 {
-				delete(r.privateProps, k)
-			} else {
-				r.privateProps[k] = v
-			}
-		}
-		r.RoomInfo.PrivateProps = binary.MarshalDict(r.privateProps)
-	}
+	r.RoomInfo.PublicProps = publicProps
+	r.RoomInfo.PrivateProps = privateProps
+	r.updatePropsBytes()
 
 	r.updateRoomInfo()
 
@@ -602,8 +1018,10 @@ func (r *Room) msgRoomProp(msg *MsgRoomProp) {
 		deadline := time.Duration(msg.ClientDeadline) * time.Second
 		if deadline != r.deadline {
 			r.deadline = deadline
-			for _, c := range r.players {
-				c.newDeadline <- deadline
+			for id, c := range r.players {
+				if _, ok := r.clientDeadlines[id]; !ok {
+					c.updateDeadline(deadline)
+				}
 			}
 			outputlog = true
 		}
@@ -622,7 +1040,7 @@ func (r *Room) msgClientProp(msg *MsgClientProp) {
 	r.muClients.RLock()
 	defer r.muClients.RUnlock()
 
-	if !msg.Sender.isPlayer {
+	if !msg.Sender.isPlayer.Load() {
 		msg.Sender.logger.Warnf("sender %q is not a player", msg.Sender.Id)
 		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
 		return
@@ -630,64 +1048,104 @@ func (r *Room) msgClientProp(msg *MsgClientProp) {
 	if r.players[msg.Sender.ID()] != msg.Sender {
 		return
 	}
+	if _, ok := msg.Props[binary.ClientCapsKey]; ok {
+		msg.Sender.logger.Warnf("client prop %q is reserved", binary.ClientCapsKey)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
 
 	msg.Sender.logger.Debugf("update client prop: %v", msg.Props)
 
 	if len(msg.Props) > 0 {
 		c := msg.Sender
+		props := make(binary.Dict, len(c.props)+len(msg.Props))
+		for k, v := range c.props {
+			props[k] = v
+		}
 		for k, v := range msg.Props {
-			if _, ok := c.props[k]; ok && len(v) == 0 {
-				delete(c.props, k)
+			if _, ok := props[k]; ok && len(v) == 0 {
+				delete(props, k)
 			} else {
-				c.props[k] = v
+				props[internKey(k)] = bytes.Clone(v)
 			}
 		}
-		c.ClientInfo.Props = binary.MarshalDict(c.props)
+		marshaled := binary.MarshalDict(props)
+		cur := r.propsSize()
+		if err := r.checkPropsSize(cur, cur-len(c.ClientInfo.Props)+len(marshaled)); err != nil {
+			msg.Sender.logger.Warnf("msgClientProp: %+v", err)
+			r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+			return
+		}
+		c.props = props
+		c.ClientInfo.Props = marshaled
+		r.updatePropsBytes()
 	}
 
 	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
-	r.broadcast(binary.NewEvClientProp(msg.Sender.Id, msg.Payload()))
+	r.notifyClientProp(msg.Sender, msg.Props, msg.Payload())
 }
 
-func (r *Room) msgTargets(msg *MsgTargets) {
-	r.muClients.RLock()
-	defer r.muClients.RUnlock()
-	if msg.Sender.isPlayer {
-		if r.players[msg.SenderID()] != msg.Sender {
-			return
-		}
-	} else {
-		if r.watchers[msg.SenderID()] != msg.Sender {
-			return
+// mergeProps : marshal済みのpropsに変更を適用する. 値が空のキーは削除する.
+// 変更が無いときはpropsをそのまま返す.
+func mergeProps(props []byte, modified binary.Dict) ([]byte, error) {
+	if len(modified) == 0 {
+		return props, nil
+	}
+	v, err := binary.NewDictView(props)
+	if err != nil {
+		return nil, err
+	}
+	size := len(props)
+	for k, val := range modified {
+		size += 1 + len(k) + 2 + len(val)
+	}
+	return binary.MergeDict(make([]byte, 0, size), v, modified)
+}
+
+// removeRoles : Playerのロール登録を解除する.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) removeRoles(cid ClientID) {
+	for role, members := range r.roles {
+		delete(members, cid)
+		if len(members) == 0 {
+			delete(r.roles, role)
 		}
 	}
+}
 
-	msg.Sender.logger.Debugf("message to targets: %v, %v", msg.Targets, msg.Data)
+func (r *Room) msgRoles(msg *MsgRoles) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
 
-	ev := binary.NewEvMessage(msg.Sender.Id, msg.Data)
+	if !msg.Sender.isPlayer.Load() {
+		msg.Sender.logger.Warnf("sender %q is not a player", msg.Sender.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if r.players[msg.SenderID()] != msg.Sender {
+		return
+	}
 
-	absent := make([]string, 0, len(r.players))
+	msg.Sender.logger.Debugf("update roles: %v", msg.Roles)
 
-	for _, t := range msg.Targets {
-		c, ok := r.players[ClientID(t)]
+	cid := msg.SenderID()
+	r.removeRoles(cid)
+	for _, role := range msg.Roles {
+		members, ok := r.roles[role]
 		if !ok {
-			msg.Sender.logger.Infof("target %s is absent", t)
-			absent = append(absent, t)
-			continue
+			members = make(map[ClientID]struct{})
+			r.roles[role] = members
 		}
-		r.sendTo(c, ev)
+		members[cid] = struct{}{}
 	}
 
-	// 居なかった人を通知
-	if len(absent) > 0 {
-		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, absent))
-	}
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
 }
 
-func (r *Room) msgToMaster(msg *MsgToMaster) {
+func (r *Room) msgToRole(msg *MsgToRole) {
 	r.muClients.RLock()
 	defer r.muClients.RUnlock()
-	if msg.Sender.isPlayer {
+	if msg.Sender.isPlayer.Load() {
 		if r.players[msg.SenderID()] != msg.Sender {
 			return
 		}
@@ -697,27 +1155,24 @@ func (r *Room) msgToMaster(msg *MsgToMaster) {
 		}
 	}
 
-	msg.Sender.logger.Debugf("message to master: %v", msg.Data)
+	msg.Sender.logger.Debugf("message to role: %v, %v", msg.Role, msg.Data)
 
-	r.sendTo(r.master, binary.NewEvMessage(msg.Sender.Id, msg.Data))
-}
+	members := r.roles[msg.Role]
+	if len(members) == 0 {
+		msg.Sender.logger.Infof("role %s has no member", msg.Role)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{msg.Role}))
+		return
+	}
 
-func (r *Room) msgBroadcast(msg *MsgBroadcast) {
-	r.muClients.RLock()
-	defer r.muClients.RUnlock()
-	if msg.Sender.isPlayer {
-		if r.players[msg.SenderID()] != msg.Sender {
-			return
-		}
-	} else {
-		if r.watchers[msg.SenderID()] != msg.Sender {
-			return
+	ev := binary.NewEvMessage(msg.Sender.Id, msg.Data)
+	sent := make([]ClientID, 0, len(members))
+	for id := range members {
+		if c, ok := r.players[id]; ok && !c.blocks(msg.SenderID()) {
+			r.sendTo(c, ev)
+			sent = append(sent, id)
 		}
 	}
-
-	msg.Sender.logger.Debugf("message to all: %v", msg.Data)
-
-	r.broadcast(binary.NewEvMessage(msg.Sender.Id, msg.Data))
+	r.scan(moderation.KindToRole, msg.Sender, sent, ev, msg.Data)
 }
 
 func (r *Room) msgSwitchMaster(msg *MsgSwitchMaster) {
@@ -727,6 +1182,12 @@ func (r *Room) msgSwitchMaster(msg *MsgSwitchMaster) {
 	if msg.Sender != r.master {
 		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.master.Id)
 		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if r.switching != nil {
+		msg.Sender.logger.Infof("master switch to %v is in progress", r.switching.target.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
 	}
 
 	target, found := r.players[msg.Target]
@@ -736,12 +1197,12 @@ func (r *Room) msgSwitchMaster(msg *MsgSwitchMaster) {
 		return
 	}
 
-	r.master = target
-
-	msg.Sender.logger.Infof("master switched: %v -> %v", msg.Sender.ID(), r.master.Id)
-
-	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
-	r.broadcast(binary.NewEvMasterSwitched(msg.Sender.Id, r.master.Id))
+	timeout := time.Duration(r.conf.SwitchMasterTimeout)
+	if target == msg.Sender || timeout <= 0 {
+		r.switchMaster(msg, target)
+		return
+	}
+	r.requestSwitch(msg, target, timeout)
 }
 
 func (r *Room) msgKick(msg *MsgKick) {
@@ -761,10 +1222,17 @@ func (r *Room) msgKick(msg *MsgKick) {
 		return
 	}
 
-	r.logger.Infof("kick: %v", target.Id)
+	demote := msg.Demote && r.canDemote(target)
+	if msg.Demote && !demote {
+		r.logger.Infof("kick: cannot demote %v to watcher", target.Id)
+	}
+	r.logger.Infof("kick: %v reason=%v ban=%v demote=%v", target.Id, msg.Reason, msg.BanDuration, demote)
 	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
 
-	r.removeClient(target, msg.Message)
+	if msg.BanDuration > 0 {
+		r.banned[target.ID()] = r.clock.Now().Add(msg.BanDuration)
+	}
+	r.removePlayer(target, msg.Message, &kickInfo{msg.Reason, msg.BanDuration, demote})
 }
 
 func (r *Room) msgAdminKick(msg *MsgAdminKick) {
@@ -780,6 +1248,98 @@ func (r *Room) msgAdminKick(msg *MsgAdminKick) {
 	msg.Res <- nil
 }
 
+func (r *Room) msgAdminMessage(msg *MsgAdminMessage) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	r.logger.Infof("admin message: %q", msg.Message)
+	r.broadcast(binary.NewEvAdminMessage(msg.Message))
+}
+
+func (r *Room) msgServerMessage(msg *MsgServerMessage) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	ev := binary.NewEvServerMessage(string(msg.Target), msg.Data)
+	if msg.Target == "" {
+		r.logger.Debugf("server message: %v bytes", len(msg.Data))
+		r.broadcast(ev)
+		msg.Res <- nil
+		return
+	}
+
+	target, ok := r.players[msg.Target]
+	if !ok {
+		target, ok = r.watchers[msg.Target]
+	}
+	if !ok {
+		msg.Res <- xerrors.Errorf("client not found: target=%v", msg.Target)
+		return
+	}
+	r.logger.Debugf("server message to %v: %v bytes", msg.Target, len(msg.Data))
+	r.sendTo(target, ev)
+	msg.Res <- nil
+}
+
+func (r *Room) msgAdminClose(msg *MsgAdminClose) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if r.closing {
+		msg.Res <- xerrors.Errorf("room is already closing: room=%v", r.Id)
+		return
+	}
+
+	r.logger.Infof("room closing by admin: %q", msg.Reason)
+	r.startClosing(msg.Reason)
+	msg.Res <- nil
+}
+
+// startClosing : 部屋を検索・入室できなくし、EvRoomClosedを送ってから閉じる.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) startClosing(reason string) {
+	r.closing = true
+
+	r.RoomInfo.Visible = false
+	r.RoomInfo.Joinable = false
+	r.RoomInfo.Watchable = false
+	r.updateRoomInfo()
+
+	r.broadcast(binary.NewEvRoomClosed(reason))
+
+	// EvRoomClosedが送信されるのを待ってから終了する
+	r.clock.AfterFunc(RoomCloseWait, func() {
+		r.SendMessage(&MsgCloseRoom{reason})
+	})
+}
+
+func (r *Room) msgCloseRoom(msg *MsgCloseRoom) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	// 待っている間に全員退室して終了していることがある
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+
+	for _, c := range r.watchers {
+		c.logger.Infof("watcher left: %v: %v", c.Id, msg.Cause)
+		c.Removed(msg.Cause)
+	}
+	for _, c := range r.players {
+		r.repo.PlayerLog(c, PlayerLogLeave)
+		c.logger.Infof("player left: %v: %v", c.Id, msg.Cause)
+		c.Removed(msg.Cause)
+	}
+	r.watchers = make(map[ClientID]*Client)
+	r.players = make(map[ClientID]*Client)
+	r.masterOrder = []ClientID{}
+
+	close(r.done)
+}
+
 func (r *Room) msgGetRoomInfo(msg *MsgGetRoomInfo) {
 	ri := r.RoomInfo.Clone()
 
@@ -789,6 +1349,7 @@ func (r *Room) msgGetRoomInfo(msg *MsgGetRoomInfo) {
 	for _, id := range r.masterOrder {
 		cis = append(cis, r.players[id].ClientInfo.Clone())
 	}
+	cis = r.appendBotInfos(cis)
 	lmt := make(map[string]uint64)
 	for p, d := range r.lastMsg {
 		t, _, err := binary.UnmarshalAs(d, binary.TypeULong)
@@ -797,12 +1358,35 @@ func (r *Room) msgGetRoomInfo(msg *MsgGetRoomInfo) {
 		}
 		lmt[p] = t.(uint64)
 	}
+	cin := make(map[string]uint64, len(r.players)+len(r.watchers))
+	cout := make(map[string]uint64, len(r.players)+len(r.watchers))
+	latency := make(map[string]uint32)
+	lost := make(map[string]uint64)
+	for _, cs := range []map[ClientID]*Client{r.players, r.watchers} {
+		for id, c := range cs {
+			cin[string(id)] = uint64(c.traffic.In.Load())
+			cout[string(id)] = uint64(c.traffic.Out.Load())
+			if d := c.delivery.latency.Load(); d > 0 {
+				latency[string(id)] = uint32(time.Duration(d) / time.Millisecond)
+			}
+			if n := c.delivery.lost.Load(); n > 0 {
+				lost[string(id)] = uint64(n)
+			}
+		}
+	}
 
 	msg.Res <- &pb.GetRoomInfoRes{
-		RoomInfo:     ri,
-		ClientInfos:  cis,
-		MasterId:     r.master.Id,
-		LastMsgTimes: lmt,
+		RoomInfo:       ri,
+		ClientInfos:    cis,
+		MasterId:       string(r.masterID()),
+		LastMsgTimes:   lmt,
+		BytesIn:        uint64(r.traffic.In.Load()),
+		BytesOut:       uint64(r.traffic.Out.Load()),
+		ClientBytesIn:  cin,
+		ClientBytesOut: cout,
+
+		ClientDeliveryLatency: latency,
+		ClientEventsLost:      lost,
 	}
 }
 
@@ -832,7 +1416,22 @@ func (r *Room) Logger() log.Logger {
 	return r.logger
 }
 
+func (r *Room) Clock() common.Clock {
+	return r.clock
+}
+
 func (r *Room) SendMessage(msg Msg) {
+	select {
+	case r.msgCh <- msg:
+		return
+	default:
+	}
+
+	// msgChが詰まっているので送信元に送信頻度を下げてもらう
+	if c := r.clients.Load().get(msg.SenderID()); c != nil {
+		c.backpressure(binary.BackpressureMsgQueue)
+	}
+
 	select {
 	case <-r.done:
 	case r.msgCh <- msg:
@@ -0,0 +1,89 @@
+package game
+
+import (
+	"sync/atomic"
+	"time"
+
+	"wsnet2/metrics"
+)
+
+// Traffic : クライアントとの送受信バイト数
+type Traffic struct {
+	In  atomic.Int64
+	Out atomic.Int64
+}
+
+// Total : 送受信の合計バイト数
+func (t *Traffic) Total() int64 {
+	return t.In.Load() + t.Out.Load()
+}
+
+// roomBandwidth : 部屋の帯域上限(bytes/sec). RoomOptionの指定はGameConf.MaxRoomBandwidthを超えられない. 0は無制限
+func roomBandwidth(opt uint32, max int64) int64 {
+	if opt == 0 || (max > 0 && int64(opt) > max) {
+		return max
+	}
+	return int64(opt)
+}
+
+// bandwidthLimiter : 部屋の送受信量がrate(bytes/sec)を超えないよう、Msgの処理を遅らせる時間を求める.
+// 1秒分のバーストを許容するtoken bucket.
+type bandwidthLimiter struct {
+	rate      int64
+	tokens    float64
+	last      time.Time
+	lastBytes int64
+}
+
+func newBandwidthLimiter(rate int64, now time.Time) *bandwidthLimiter {
+	return &bandwidthLimiter{
+		rate:   rate,
+		tokens: float64(rate),
+		last:   now,
+	}
+}
+
+// delay : 累計の送受信バイト数がbytesになったとき、次のMsgの処理まで待つ時間
+func (l *bandwidthLimiter) delay(now time.Time, bytes int64) time.Duration {
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(bytes - l.lastBytes)
+	l.lastBytes = bytes
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+}
+
+// throttle : 帯域上限を超えているとき、上限内に収まるまでMsgLoopを止める.
+// 止めている間はクライアントからのMsgを読まないので、送信も受信も抑えられる.
+func (r *Room) throttle() {
+	if r.limiter == nil {
+		return
+	}
+	d := r.limiter.delay(r.clock.Now(), r.traffic.Total())
+	if d <= 0 {
+		r.throttled = false
+		return
+	}
+	if !r.throttled {
+		r.throttled = true
+		r.logger.Infof("room bandwidth exceeded: limit=%v bytes/sec, delay=%v", r.limiter.rate, d)
+	}
+	t := r.clock.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-r.Done():
+	case <-t.C():
+	}
+}
+
+// AddTraffic : クライアントとの送受信バイト数を加算する (Clientから呼ばれる)
+func (r *Room) AddTraffic(in, out int) {
+	metrics.AddAppTraffic(r.AppId, in, out)
+	r.traffic.In.Add(int64(in))
+	r.traffic.Out.Add(int64(out))
+}
@@ -0,0 +1,49 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoomBandwidth(t *testing.T) {
+	tests := []struct {
+		opt  uint32
+		max  int64
+		want int64
+	}{
+		{0, 0, 0},
+		{0, 1000, 1000},
+		{500, 0, 500},
+		{500, 1000, 500},
+		{2000, 1000, 1000},
+	}
+	for _, tc := range tests {
+		if r := roomBandwidth(tc.opt, tc.max); r != tc.want {
+			t.Fatalf("roomBandwidth(%v, %v) = %v, wants %v", tc.opt, tc.max, r, tc.want)
+		}
+	}
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	now := time.Now()
+	l := newBandwidthLimiter(1000, now)
+
+	// 1秒分のバーストは待たない
+	if d := l.delay(now, 1000); d != 0 {
+		t.Fatalf("delay within burst = %v, wants 0", d)
+	}
+	// 超過分を消化するまで待つ
+	if d := l.delay(now, 1500); d != 500*time.Millisecond {
+		t.Fatalf("delay over limit = %v, wants %v", d, 500*time.Millisecond)
+	}
+	// 待った後は回復している
+	now = now.Add(500 * time.Millisecond)
+	if d := l.delay(now, 1500); d != 0 {
+		t.Fatalf("delay after wait = %v, wants 0", d)
+	}
+	// 長時間空いてもバーストは1秒分まで
+	now = now.Add(10 * time.Second)
+	if d := l.delay(now, 3500); d != time.Second {
+		t.Fatalf("delay after idle = %v, wants %v", d, time.Second)
+	}
+}
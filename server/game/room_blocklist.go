@@ -0,0 +1,67 @@
+package game
+
+import (
+	"wsnet2/binary"
+)
+
+// blocks : senderからのEvTypeMessageを受け取らないか.
+// 中継goroutineから呼ばれるのでロックを取らない.
+func (c *Client) blocks(sender ClientID) bool {
+	b := c.blocked.Load()
+	if b == nil {
+		return false
+	}
+	_, ok := (*b)[sender]
+	return ok
+}
+
+// filterBlocked : ブロックしている送信元からのEvTypeMessageを除く
+func (c *Client) filterBlocked(evs []*binary.RegularEvent) []*binary.RegularEvent {
+	b := c.blocked.Load()
+	if b == nil || len(evs) == 0 {
+		return evs
+	}
+	filtered := make([]*binary.RegularEvent, 0, len(evs))
+	for _, ev := range evs {
+		if ev.Type() == binary.EvTypeMessage {
+			if sender, _, err := binary.UnmarshalEvMessage(ev.Payload()); err == nil {
+				if _, ok := (*b)[ClientID(sender)]; ok {
+					continue
+				}
+			}
+		}
+		filtered = append(filtered, ev)
+	}
+	return filtered
+}
+
+// msgBlocklist : ブロックリストを置き換える.
+// 以降、ブロックした送信元からのBroadcast, Targets, ToRoleのメッセージは届かない.
+// ToMasterのメッセージはゲームの進行に必要なのでブロックしない.
+func (r *Room) msgBlocklist(msg *MsgBlocklist) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	if !msg.Sender.isPlayer.Load() {
+		msg.Sender.logger.Warnf("sender %q is not a player", msg.Sender.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if r.players[msg.SenderID()] != msg.Sender {
+		return
+	}
+
+	msg.Sender.logger.Debugf("update blocklist: %v", msg.Blocked)
+
+	if len(msg.Blocked) == 0 {
+		msg.Sender.blocked.Store(nil)
+	} else {
+		blocked := make(map[ClientID]struct{}, len(msg.Blocked))
+		for _, id := range msg.Blocked {
+			blocked[ClientID(id)] = struct{}{}
+		}
+		msg.Sender.blocked.Store(&blocked)
+	}
+
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+}
@@ -0,0 +1,84 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+
+	"wsnet2/binary"
+)
+
+// messageSenders : 前回以降にcが受け取ったEvMessageの送信元
+func messageSenders(t *testing.T, c *Client, seq *int) []string {
+	t.Helper()
+	evs, _ := c.evbuf.Read(*seq)
+	*seq += len(evs)
+	senders := []string{}
+	for _, ev := range evs {
+		if ev.Type() != binary.EvTypeMessage {
+			continue
+		}
+		sender, _, err := binary.UnmarshalEvMessage(ev.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvMessage: %v", err)
+		}
+		senders = append(senders, sender)
+	}
+	return senders
+}
+
+func TestBlocklist(t *testing.T) {
+	r, clients := newRelayRoom(t, 3, RoomRelayShards)
+	blocker, muted, other := clients[0], clients[1], clients[2]
+	var blockerSeq, otherSeq int
+
+	r.msgBlocklist(newTestMsg(t, blocker, binary.MsgTypeBlocklist, binary.MarshalBlocklistPayload([]string{muted.Id})).(*MsgBlocklist))
+	if types := eventTypes(blocker, &blockerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeSucceeded}) {
+		t.Fatalf("blocklist events %v, wants [Succeeded]", types)
+	}
+
+	send := func() {
+		for _, c := range []*Client{muted, other} {
+			r.relay(&MsgBroadcast{Sender: c, Data: binary.MarshalInt(0)})
+			r.relay(&MsgTargets{Sender: c, Targets: []string{blocker.Id}, Data: binary.MarshalInt(1)})
+			r.waitRelay()
+		}
+	}
+
+	// ブロックした送信元からのメッセージだけ届かない. 送信元にはTargetNotFoundを返さない
+	send()
+	if s := messageSenders(t, blocker, &blockerSeq); !reflect.DeepEqual(s, []string{other.Id, other.Id}) {
+		t.Fatalf("blocker received from %v, wants [%v %v]", s, other.Id, other.Id)
+	}
+	if s := messageSenders(t, other, &otherSeq); !reflect.DeepEqual(s, []string{muted.Id, other.Id}) {
+		t.Fatalf("other received from %v, wants [%v %v]", s, muted.Id, other.Id)
+	}
+	if types := eventTypes(muted, new(int)); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeMessage, binary.EvTypeMessage}) {
+		t.Fatalf("muted sender events %v, wants only messages", types)
+	}
+
+	// 空のリストで解除する
+	r.msgBlocklist(newTestMsg(t, blocker, binary.MsgTypeBlocklist, binary.MarshalBlocklistPayload(nil)).(*MsgBlocklist))
+	eventTypes(blocker, &blockerSeq)
+	send()
+	if s := messageSenders(t, blocker, &blockerSeq); len(s) != 4 {
+		t.Fatalf("blocker received from %v, wants 4 messages", s)
+	}
+}
+
+func TestFilterBlocked(t *testing.T) {
+	c := &Client{}
+	evs := []*binary.RegularEvent{
+		binary.NewEvMessage("a", []byte("1")),
+		binary.NewEvMessage("b", []byte("2")),
+		binary.NewEvMessage("a", []byte("3")),
+	}
+	if got := c.filterBlocked(evs); len(got) != 3 {
+		t.Fatalf("filterBlocked without blocklist = %v, wants 3 events", len(got))
+	}
+
+	c.blocked.Store(&map[ClientID]struct{}{"a": {}})
+	got := c.filterBlocked(evs)
+	if len(got) != 1 || got[0] != evs[1] {
+		t.Fatalf("filterBlocked = %v, wants [%v]", got, evs[1])
+	}
+}
@@ -0,0 +1,155 @@
+package game
+
+import (
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+	"wsnet2/moderation"
+	"wsnet2/pb"
+)
+
+// ボットのPlayer (Room.bots).
+//
+// Masterが MsgAddBot で追加する仮想のPlayer. 接続は持たず、MaxPlayersとRoomInfo.Playersに数える.
+// 入室時のPlayer一覧には ClientInfo.Bot を立てて含める.
+// Masterは MsgBotMessage でボットを送信者として全員に送信できる.
+// 同じクライアントIDのPlayerが入室すると、席を引き継いでボットから置き換わる (EvTypeRejoinedを通知).
+// Masterにはならず、Playerが全員退室したら部屋は閉じる.
+
+// causeBotRemoved : MsgRemoveBotで退室させたときのEvTypeLeftのcause
+const causeBotRemoved = "bot removed"
+
+// playerCount : ボットを含めたPlayerの数.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) playerCount() uint32 {
+	return uint32(len(r.players) + len(r.bots))
+}
+
+// appendBotInfos : Player一覧にボットを加える.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) appendBotInfos(players []*pb.ClientInfo) []*pb.ClientInfo {
+	for _, b := range r.bots {
+		players = append(players, b.Clone())
+	}
+	return players
+}
+
+// takeOverBot : 入室するPlayerと同じクライアントIDのボットを取り除く. ボットが居ればtrueを返す.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) takeOverBot(cid ClientID) bool {
+	if _, ok := r.bots[cid]; !ok {
+		return false
+	}
+	delete(r.bots, cid)
+	r.logger.Infof("bot taken over by player: %v", cid)
+	return true
+}
+
+// checkBotMaster : MasterからのボットのMsgか確認する. 違えばEvPermissionDeniedを返す.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) checkBotMaster(sender *Client, msg binary.RegularMsg) bool {
+	if sender != r.master {
+		sender.logger.Warnf("sender %q is not master %q", sender.Id, r.masterID())
+		r.sendTo(sender, binary.NewEvPermissionDenied(msg))
+		return false
+	}
+	return true
+}
+
+func (r *Room) msgAddBot(msg *MsgAddBot) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if !r.checkBotMaster(msg.Sender, msg) {
+		return
+	}
+
+	id := ClientID(msg.BotId)
+	var err error
+	if id == "" {
+		err = xerrors.Errorf("empty bot id")
+	} else if _, ok := r.players[id]; ok {
+		err = xerrors.Errorf("player already exists: %v", id)
+	} else if _, ok := r.watchers[id]; ok {
+		err = xerrors.Errorf("watcher already exists: %v", id)
+	} else if _, ok := r.bots[id]; ok {
+		err = xerrors.Errorf("bot already exists: %v", id)
+	} else if r.MaxPlayers <= r.playerCount()+r.reservedSeats("") {
+		err = xerrors.Errorf("room full: max=%v", r.MaxPlayers)
+	}
+	if err != nil {
+		msg.Sender.logger.Infof("add bot: %v", err)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	_, props, e := common.InitProps(msg.Props)
+	if e != nil {
+		msg.Sender.logger.Warnf("add bot: props: %+v", e)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	bot := &pb.ClientInfo{Id: string(id), Bot: true, Props: props}
+	r.bots[id] = bot
+	r.logger.Infof("bot added by %v: %v", msg.Sender.Id, id)
+
+	r.RoomInfo.Players = r.playerCount()
+	r.updateRoomInfo()
+
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+	r.broadcast(binary.NewEvBotJoined(bot))
+}
+
+func (r *Room) msgRemoveBot(msg *MsgRemoveBot) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if !r.checkBotMaster(msg.Sender, msg) {
+		return
+	}
+	if _, ok := r.bots[msg.BotId]; !ok {
+		msg.Sender.logger.Infof("bot not found: %v", msg.BotId)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{string(msg.BotId)}))
+		return
+	}
+	delete(r.bots, msg.BotId)
+	r.logger.Infof("bot removed by %v: %v", msg.Sender.Id, msg.BotId)
+
+	r.RoomInfo.Players = r.playerCount()
+	r.updateRoomInfo()
+
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+	r.broadcast(binary.NewEvLeft(string(msg.BotId), r.master.Id, causeBotRemoved))
+	r.autoPromote()
+	r.grantWaitingSeats()
+}
+
+// msgBotMessage : ボットを送信者として全員に送る. 審査ではMasterを送信者として扱う.
+func (r *Room) msgBotMessage(msg *MsgBotMessage) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	if !r.checkBotMaster(msg.Sender, msg) {
+		return
+	}
+	id := ClientID(msg.BotId)
+	if _, ok := r.bots[id]; !ok {
+		msg.Sender.logger.Infof("bot not found: %v", id)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{msg.BotId}))
+		return
+	}
+
+	ev := binary.NewEvMessage(msg.BotId, msg.Data)
+	r.history.add(ev)
+	r.publish(ev)
+	for _, c := range r.players {
+		if !c.blocks(id) {
+			r.sendTo(c, ev)
+		}
+	}
+	for _, c := range r.watchers {
+		r.sendTo(c, ev)
+	}
+	r.scan(moderation.KindBroadcast, msg.Sender, nil, ev, msg.Data)
+}
@@ -0,0 +1,87 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestBotSeats(t *testing.T) {
+	r, clients, _ := newSwitchRoom(t, 2)
+	master, player := clients[0], clients[1]
+	r.bots = make(map[ClientID]*pb.ClientInfo)
+	r.MaxPlayers = 3
+	var masterSeq, playerSeq int
+
+	// Master以外は追加できない
+	r.dispatch(newTestMsg(t, player, binary.MsgTypeAddBot, binary.MarshalAddBotPayload("bot1", nil)))
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("player events %v, wants [PermissionDenied]", types)
+	}
+
+	// 既存のPlayerと同じIDは追加できない
+	r.dispatch(newTestMsg(t, master, binary.MsgTypeAddBot, binary.MarshalAddBotPayload(player.Id, nil)))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("master events %v, wants [PermissionDenied]", types)
+	}
+
+	r.dispatch(newTestMsg(t, master, binary.MsgTypeAddBot, binary.MarshalAddBotPayload("bot1", binary.Dict{"level": binary.MarshalInt(3)})))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeSucceeded, binary.EvTypeBotJoined}) {
+		t.Fatalf("master events %v, wants [Succeeded BotJoined]", types)
+	}
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeBotJoined}) {
+		t.Fatalf("player events %v, wants [BotJoined]", types)
+	}
+	if r.RoomInfo.Players != 3 {
+		t.Fatalf("players = %v, wants 3", r.RoomInfo.Players)
+	}
+
+	// ボットもMaxPlayersに数える
+	r.dispatch(newTestMsg(t, master, binary.MsgTypeAddBot, binary.MarshalAddBotPayload("bot2", nil)))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("master events %v, wants [PermissionDenied]", types)
+	}
+
+	data := binary.MarshalStr8("hello")
+	r.dispatch(newTestMsg(t, master, binary.MsgTypeBotMessage, binary.MarshalBotMessagePayload("bot1", data)))
+	evs, _ := player.evbuf.Read(playerSeq)
+	playerSeq += len(evs)
+	if len(evs) != 1 || evs[0].Type() != binary.EvTypeMessage {
+		t.Fatalf("player events %v, wants [Message]", evs)
+	}
+	sender, body, _ := binary.UnmarshalEvMessage(evs[0].Payload())
+	if sender != "bot1" || !reflect.DeepEqual(body, data) {
+		t.Fatalf("message = (%q, %v), wants (%q, %v)", sender, body, "bot1", data)
+	}
+	eventTypes(master, &masterSeq)
+
+	r.dispatch(newTestMsg(t, master, binary.MsgTypeRemoveBot, binary.MarshalStr8("bot2")))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeTargetNotFound}) {
+		t.Fatalf("master events %v, wants [TargetNotFound]", types)
+	}
+	r.dispatch(newTestMsg(t, master, binary.MsgTypeRemoveBot, binary.MarshalStr8("bot1")))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeSucceeded, binary.EvTypeLeft}) {
+		t.Fatalf("master events %v, wants [Succeeded Left]", types)
+	}
+	if len(r.bots) != 0 || r.RoomInfo.Players != 2 {
+		t.Fatalf("bots = %v, players = %v", r.bots, r.RoomInfo.Players)
+	}
+}
+
+func TestTakeOverBot(t *testing.T) {
+	r, _, _ := newSwitchRoom(t, 1)
+	r.bots = map[ClientID]*pb.ClientInfo{"bot1": {Id: "bot1", Bot: true}}
+
+	players := r.appendBotInfos(nil)
+	if len(players) != 1 || !players[0].Bot {
+		t.Fatalf("players = %v", players)
+	}
+	if r.takeOverBot("bot2") {
+		t.Fatalf("takeOverBot(bot2) must be false")
+	}
+	if !r.takeOverBot("bot1") || len(r.bots) != 0 {
+		t.Fatalf("bot1 must be taken over: %v", r.bots)
+	}
+}
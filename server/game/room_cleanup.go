@@ -0,0 +1,86 @@
+package game
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+)
+
+// 残骸になった部屋の片付け:
+// gameサーバがクラッシュするとroomテーブルに行が残り、lobbyのSearchが存在しない部屋を返してしまう.
+// 次の行をroom_historyに移して削除する.
+//   - このホストの行で、メモリ上に部屋がないもの
+//   - heartbeatがhostTimeout以上途絶えたgameサーバ、または登録されていないgameサーバの行
+//
+// 作成中の部屋 (DBに書き込んでからRepositoryに登録するまで) を消さないよう、作成から roomCleanupGrace 経っていない行は対象にしない.
+
+const (
+	roomCleanupGrace = time.Minute
+	roomCleanupLimit = 1000
+)
+
+const roomCleanupQuery = "" +
+	"SELECT r.id, r.host_id FROM room r LEFT JOIN game_server g ON g.id = r.host_id " +
+	"WHERE r.created < ? AND (r.host_id = ? OR g.id IS NULL OR g.heartbeat IS NULL OR g.heartbeat < ?) LIMIT ?"
+
+type orphanRoom struct {
+	Id     string `db:"id"`
+	HostId uint32 `db:"host_id"`
+}
+
+// CleanupRooms : 残骸になった部屋の行を片付け、片付けた部屋のIDを返す.
+// existsはこのホストのメモリ上に部屋があるかを返す. dryRunなら対象の部屋のIDだけを返す.
+func CleanupRooms(ctx context.Context, db *sqlx.DB, hostId uint32, exists func(roomId string) bool, hostTimeout time.Duration, now time.Time, dryRun bool) ([]string, error) {
+	before := now.Add(-roomCleanupGrace)
+	var rows []orphanRoom
+	err := db.SelectContext(ctx, &rows, roomCleanupQuery,
+		before, hostId, now.Add(-hostTimeout).Unix(), roomCleanupLimit)
+	if err != nil {
+		return nil, xerrors.Errorf("select orphan rooms: %w", err)
+	}
+
+	ids := []string{}
+	for _, r := range rows {
+		if r.HostId == hostId && exists(r.Id) {
+			continue
+		}
+		ids = append(ids, r.Id)
+	}
+	if len(ids) == 0 || dryRun {
+		return ids, nil
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 選んだ後に事前確保した行が使われることがあるので、createdも確認する. see: room_prewarm.go
+	q, args, err := sqlx.In("INSERT INTO room_history (room_id, app_id, host_id, number, search_group, max_players, public_props, created, closed) "+
+		"SELECT id, app_id, host_id, number, search_group, max_players, props, created, now() FROM room WHERE id IN (?) AND created < ?", ids, before)
+	if err != nil {
+		return nil, xerrors.Errorf("sqlx.In: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, q, args...); err != nil {
+		return nil, xerrors.Errorf("room to history: %w", err)
+	}
+	q, args, err = sqlx.In("DELETE FROM room WHERE id IN (?) AND created < ?", ids, before)
+	if err != nil {
+		return nil, xerrors.Errorf("sqlx.In: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, q, args...); err != nil {
+		return nil, xerrors.Errorf("delete rooms: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, xerrors.Errorf("commit: %w", err)
+	}
+
+	// 復元されない部屋のセッションも消しておく
+	if err := deleteSessions(db, ids); err != nil {
+		return ids, xerrors.Errorf("delete sessions: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,80 @@
+package game
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCleanupRooms(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	exists := func(id string) bool { return id == "live" }
+
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "host_id"}).
+			AddRow("live", 1).   // このホストの動いている部屋
+			AddRow("ghost", 1).  // このホストのメモリにない部屋
+			AddRow("crashed", 2) // heartbeatの途絶えたホストの部屋
+	}
+	selectQ := regexp.QuoteMeta(roomCleanupQuery)
+
+	t.Run("dry run", func(t *testing.T) {
+		db, mock := newDbMock(t)
+		mock.ExpectQuery(selectQ).
+			WithArgs(now.Add(-roomCleanupGrace), 1, now.Add(-time.Minute).Unix(), roomCleanupLimit).
+			WillReturnRows(rows())
+		ids, err := CleanupRooms(ctx, db, 1, exists, time.Minute, now, true)
+		if err != nil {
+			t.Fatalf("CleanupRooms: %+v", err)
+		}
+		if want := []string{"ghost", "crashed"}; !reflect.DeepEqual(ids, want) {
+			t.Fatalf("ids = %v, wants %v", ids, want)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("cleanup", func(t *testing.T) {
+		db, mock := newDbMock(t)
+		mock.ExpectQuery(selectQ).WillReturnRows(rows())
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO room_history ")).
+			WithArgs("ghost", "crashed", now.Add(-roomCleanupGrace)).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM room WHERE id IN (?, ?) AND created < ?")).
+			WithArgs("ghost", "crashed", now.Add(-roomCleanupGrace)).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM client_session WHERE room_id IN (?, ?)")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM room_session WHERE room_id IN (?, ?)")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		ids, err := CleanupRooms(ctx, db, 1, exists, time.Minute, now, false)
+		if err != nil {
+			t.Fatalf("CleanupRooms: %+v", err)
+		}
+		if len(ids) != 2 {
+			t.Fatalf("ids = %v, wants 2 rooms", ids)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("nothing to clean", func(t *testing.T) {
+		db, mock := newDbMock(t)
+		mock.ExpectQuery(selectQ).WillReturnRows(sqlmock.NewRows([]string{"id", "host_id"}).AddRow("live", 1))
+		ids, err := CleanupRooms(ctx, db, 1, exists, time.Minute, now, false)
+		if err != nil || len(ids) != 0 {
+			t.Fatalf("CleanupRooms = (%v, %+v), wants no rooms", ids, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+
+	"wsnet2/binary"
+)
+
+func TestCloseOnMasterLeave(t *testing.T) {
+	for _, closeOnLeave := range []bool{false, true} {
+		r, clients, _ := newSwitchRoom(t, 3)
+		r.repo = &Repository{}
+		r.closeOnMasterLeave = closeOnLeave
+		for _, c := range clients {
+			c.removed = make(chan struct{})
+		}
+		master, player := clients[0], clients[1]
+
+		r.removePlayer(master, "leave", nil)
+
+		var seq int
+		evs, _ := player.evbuf.Read(0)
+		types := eventTypes(player, &seq)
+		left, err := binary.UnmarshalEvLeftPayload(evs[0].Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvLeftPayload: %v", err)
+		}
+
+		if !closeOnLeave {
+			if r.master == master || r.closing {
+				t.Fatalf("master must be switched: master=%v closing=%v", r.master.Id, r.closing)
+			}
+			if !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeLeft}) {
+				t.Fatalf("events %v, wants [Left]", types)
+			}
+			continue
+		}
+
+		// Masterを交代せずに部屋を閉じる
+		if r.master != master || !r.closing {
+			t.Fatalf("room must be closing without switching master: master=%v closing=%v", r.master.Id, r.closing)
+		}
+		if left.MasterId != master.Id {
+			t.Fatalf("EvLeft master = %v, wants %v", left.MasterId, master.Id)
+		}
+		if !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeLeft, binary.EvTypeRoomClosed}) {
+			t.Fatalf("events %v, wants [Left RoomClosed]", types)
+		}
+		if reason, _ := binary.UnmarshalEvRoomClosedPayload(evs[1].Payload()); reason != binary.RoomClosedMasterLeft {
+			t.Fatalf("reason = %q, wants %q", reason, binary.RoomClosedMasterLeft)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package game
+
+import (
+	"time"
+
+	"wsnet2/binary"
+)
+
+// Player毎のClientDeadline:
+// MasterはMsgClientDeadlineで特定のPlayerのClientDeadlineを部屋の値と別に指定できる.
+// 指定したPlayerはMsgRoomPropで部屋のClientDeadlineが変更されても指定した値を使い続け、
+// 0を指定すると部屋のClientDeadlineに戻る. 指定は退室するまで (再入室しても) 有効.
+// EvTypeDeadlineChangedを受け取れないPlayerには変更を知らせられず、古いdeadlineのままPingを送って
+// タイムアウトしてしまうので、指定できない (TargetNotFoundを返す).
+//
+// ClientDeadlineの変更はEvTypeDeadlineChangedでクライアントに通知し、
+// クライアントは旧deadlineに合わせてPingを送っているので、次のメッセージが届いてから適用する.
+
+func (r *Room) msgClientDeadline(msg *MsgClientDeadline) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	if msg.Sender != r.master {
+		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.masterID())
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	target, ok := r.players[msg.Target]
+	if !ok {
+		msg.Sender.logger.Infof("client deadline: player not found: %v", msg.Target)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{string(msg.Target)}))
+		return
+	}
+	if !target.canReceiveDeadlineChanged() {
+		msg.Sender.logger.Infof("client deadline: player cannot receive EvDeadlineChanged: %v", msg.Target)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{string(msg.Target)}))
+		return
+	}
+
+	if msg.Deadline == 0 {
+		delete(r.clientDeadlines, msg.Target)
+	} else {
+		if r.clientDeadlines == nil {
+			r.clientDeadlines = make(map[ClientID]time.Duration)
+		}
+		r.clientDeadlines[msg.Target] = msg.Deadline
+	}
+	deadline := r.clientDeadline(msg.Target)
+	target.updateDeadline(deadline)
+	msg.Sender.logger.Infof("client deadline: %v deadline=%v", msg.Target, deadline)
+
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+}
+
+// canReceiveDeadlineChanged : EvTypeDeadlineChangedを受け取れるか.
+// 再接続でバージョンが変わらないよう、接続ではなく入室時のCapabilitiesで判断する.
+func (c *Client) canReceiveDeadlineChanged() bool {
+	return c.GetCaps().GetProtocolVersion() >= binary.ProtocolVersionDeadlineChanged
+}
+
+// clientDeadline : PlayerのClientDeadline. 指定が無ければ部屋のClientDeadline
+func (r *Room) clientDeadline(id ClientID) time.Duration {
+	if d, ok := r.clientDeadlines[id]; ok {
+		return d
+	}
+	return r.deadline
+}
+
+// updateDeadline : MsgLoopにdeadlineの変更を通知する.
+// 未処理の変更があれば新しい値で置き換える.
+func (c *Client) updateDeadline(deadline time.Duration) {
+	for {
+		select {
+		case c.newDeadline <- deadline:
+			return
+		default:
+		}
+		select {
+		case <-c.newDeadline:
+		default:
+		}
+	}
+}
+
+// deadlineTransition : MsgLoopが使うタイムアウト時間.
+// 変更はすぐには適用せず、クライアントから次のメッセージが届いたときに適用する.
+type deadlineTransition struct {
+	current time.Duration
+	pending time.Duration // 0なら変更なし
+}
+
+// change : 新しいdeadlineを次のメッセージまで保留する
+func (d *deadlineTransition) change(deadline time.Duration) {
+	if deadline == d.current {
+		d.pending = 0
+		return
+	}
+	d.pending = deadline
+}
+
+// received : クライアントからメッセージが届いた. 保留中の変更を適用し、次のタイムアウト時間を返す
+func (d *deadlineTransition) received() time.Duration {
+	if d.pending != 0 {
+		d.current = d.pending
+		d.pending = 0
+	}
+	return d.current
+}
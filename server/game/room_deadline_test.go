@@ -0,0 +1,186 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestClientDeadline(t *testing.T) {
+	r, clients, _ := newSwitchRoom(t, 3)
+	master, player, legacy := clients[0], clients[1], clients[2]
+	for _, c := range clients {
+		c.newDeadline = make(chan time.Duration, 1)
+	}
+	for _, c := range []*Client{master, player} {
+		c.Caps = &pb.Capabilities{ProtocolVersion: binary.ProtocolVersionDeadlineChanged}
+	}
+	r.deadline = 30 * time.Second
+	var masterSeq, playerSeq int
+
+	received := func(c *Client) time.Duration {
+		t.Helper()
+		select {
+		case d := <-c.newDeadline:
+			return d
+		default:
+			return 0
+		}
+	}
+
+	// Master以外は指定できない
+	r.dispatch(newTestMsg(t, player, binary.MsgTypeClientDeadline, binary.MarshalClientDeadlinePayload(string(master.ID()), 10)))
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("player events %v, wants [PermissionDenied]", types)
+	}
+	r.dispatch(newTestMsg(t, master, binary.MsgTypeClientDeadline, binary.MarshalClientDeadlinePayload("nobody", 10)))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeTargetNotFound}) {
+		t.Fatalf("master events %v, wants [TargetNotFound]", types)
+	}
+
+	// EvTypeDeadlineChangedを受け取れないPlayerには指定できない
+	r.dispatch(newTestMsg(t, master, binary.MsgTypeClientDeadline, binary.MarshalClientDeadlinePayload(string(legacy.ID()), 10)))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeTargetNotFound}) {
+		t.Fatalf("master events %v, wants [TargetNotFound]", types)
+	}
+	if d := received(legacy); d != 0 {
+		t.Fatalf("legacy player must not receive deadline: %v", d)
+	}
+	if _, ok := r.clientDeadlines[legacy.ID()]; ok {
+		t.Fatalf("clientDeadlines must not have %v: %v", legacy.ID(), r.clientDeadlines)
+	}
+
+	r.dispatch(newTestMsg(t, master, binary.MsgTypeClientDeadline, binary.MarshalClientDeadlinePayload(string(player.ID()), 10)))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeSucceeded}) {
+		t.Fatalf("master events %v, wants [Succeeded]", types)
+	}
+	if d := received(player); d != 10*time.Second {
+		t.Fatalf("player deadline = %v, wants 10s", d)
+	}
+	if d := r.clientDeadline(player.ID()); d != 10*time.Second {
+		t.Fatalf("clientDeadline = %v, wants 10s", d)
+	}
+
+	// 未処理の変更は新しい値で置き換える
+	player.updateDeadline(20 * time.Second)
+	player.updateDeadline(40 * time.Second)
+	if d := received(player); d != 40*time.Second {
+		t.Fatalf("player deadline = %v, wants 40s", d)
+	}
+
+	// 0なら部屋のClientDeadlineに戻す
+	r.dispatch(newTestMsg(t, master, binary.MsgTypeClientDeadline, binary.MarshalClientDeadlinePayload(string(player.ID()), 0)))
+	if d := received(player); d != 30*time.Second {
+		t.Fatalf("player deadline = %v, wants 30s", d)
+	}
+	if _, ok := r.clientDeadlines[player.ID()]; ok {
+		t.Fatalf("clientDeadlines must not have %v: %v", player.ID(), r.clientDeadlines)
+	}
+	if d := received(master); d != 0 {
+		t.Fatalf("master must not receive deadline: %v", d)
+	}
+}
+
+func TestMsgLoopDeadline(t *testing.T) {
+	r, clients, clock := newSwitchRoom(t, 1)
+	r.repo = &Repository{}
+	c := clients[0]
+	c.removed = make(chan struct{})
+	c.done = make(chan struct{})
+	c.newDeadline = make(chan time.Duration, 1)
+	c.pauseChanged = make(chan struct{}, 1)
+	c.renewPeer = make(chan struct{}, 1)
+	c.evErr = make(chan error)
+
+	// 送信しないPeerからPingを受け取る
+	peer := &Peer{closed: true, msgCh: make(chan binary.Msg)}
+	c.peer = peer
+	c.renewPeer <- struct{}{}
+
+	r.wgClient.Add(1)
+	go c.MsgLoop(30 * time.Second)
+
+	// waitTimer : MsgLoopがタイマーを設定し直すのを待つ
+	waitTimer := func() {
+		t.Helper()
+		for i := 0; clock.Timers() != 1; i++ {
+			if i > 1000 {
+				t.Fatalf("timer not reset")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	ping := func() {
+		t.Helper()
+		peer.msgCh <- binary.NewMsgPing(clock.Now())
+		if msg := <-r.msgCh; reflect.TypeOf(msg) != reflect.TypeOf(&MsgPing{}) {
+			t.Fatalf("room message = %T, wants *MsgPing", msg)
+		}
+		waitTimer()
+	}
+
+	clock.Advance(5 * time.Second)
+	ping()
+
+	c.updateDeadline(10 * time.Second)
+	for i := 0; time.Duration(c.deadline.Load()) != 10*time.Second; i++ {
+		if i > 1000 {
+			t.Fatalf("deadline not changed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 次のメッセージが届くまでは旧deadline (30s) で判定する
+	clock.Advance(20 * time.Second)
+	if n := clock.Timers(); n != 1 {
+		t.Fatalf("client timed out with the new deadline before the next message")
+	}
+
+	// メッセージが届いたら新しいdeadline (10s) で判定する
+	ping()
+	clock.Advance(9 * time.Second)
+	if n := clock.Timers(); n != 1 {
+		t.Fatalf("client timed out before the new deadline")
+	}
+	clock.Advance(time.Second)
+	if msg := <-r.msgCh; reflect.TypeOf(msg) != reflect.TypeOf(&MsgClientTimeout{}) {
+		t.Fatalf("room message = %T, wants *MsgClientTimeout", msg)
+	}
+	<-c.done
+}
+
+func TestDeadlineTransition(t *testing.T) {
+	dt := deadlineTransition{current: 30 * time.Second}
+
+	// 変更は次のメッセージが届くまで適用しない
+	dt.change(10 * time.Second)
+	if dt.current != 30*time.Second {
+		t.Fatalf("current = %v, wants 30s", dt.current)
+	}
+	if d := dt.received(); d != 10*time.Second {
+		t.Fatalf("received = %v, wants 10s", d)
+	}
+	if d := dt.received(); d != 10*time.Second {
+		t.Fatalf("received = %v, wants 10s", d)
+	}
+
+	// 続けて変更されたら最後の値を適用する
+	dt.change(20 * time.Second)
+	dt.change(40 * time.Second)
+	if d := dt.received(); d != 40*time.Second {
+		t.Fatalf("received = %v, wants 40s", d)
+	}
+
+	// 適用前に元に戻されたら変更しない
+	dt.change(5 * time.Second)
+	dt.change(40 * time.Second)
+	if dt.pending != 0 {
+		t.Fatalf("pending = %v, wants 0", dt.pending)
+	}
+	if d := dt.received(); d != 40*time.Second {
+		t.Fatalf("received = %v, wants 40s", d)
+	}
+}
@@ -0,0 +1,79 @@
+package game
+
+import (
+	"time"
+
+	"wsnet2/binary"
+)
+
+// delayedEvent : 観戦者への送信を遅延しているイベント
+type delayedEvent struct {
+	client *Client
+	ev     *binary.RegularEvent
+	at     time.Time
+}
+
+// sendDelayed : 観戦者へのイベントをwatcherDelay後に送信する.
+// 送信順は呼び出し順のまま保たれる.
+func (r *Room) sendDelayed(c *Client, ev *binary.RegularEvent) {
+	r.muDelay.Lock()
+	defer r.muDelay.Unlock()
+
+	r.delayQueue = append(r.delayQueue, delayedEvent{c, ev, r.clock.Now().Add(r.watcherDelay)})
+	if r.delayTimer == nil {
+		r.delayTimer = r.clock.AfterFunc(r.watcherDelay, r.releaseDelayed)
+	}
+}
+
+// releaseDelayed : 期限の来たイベントを観戦者に送信する
+func (r *Room) releaseDelayed() {
+	r.muDelay.Lock()
+	defer r.muDelay.Unlock()
+
+	select {
+	case <-r.done:
+		r.delayQueue = nil
+		r.delayTimer = nil
+		return
+	default:
+	}
+
+	now := r.clock.Now()
+	n := 0
+	for ; n < len(r.delayQueue); n++ {
+		d := r.delayQueue[n]
+		if d.at.After(now) {
+			break
+		}
+		r.sendNow(d.client, d.ev)
+		r.delayQueue[n] = delayedEvent{}
+	}
+	r.delayQueue = r.delayQueue[n:]
+
+	if len(r.delayQueue) == 0 {
+		r.delayQueue = nil
+		r.delayTimer = nil
+		return
+	}
+	r.delayTimer = r.clock.AfterFunc(r.delayQueue[0].at.Sub(now), r.releaseDelayed)
+}
+
+// flushDelayed : cへの遅延中のイベントを直ちに送信する. 観戦者がPlayerに昇格するときに使う.
+// 他の観戦者へのイベントは遅延したまま残す.
+func (r *Room) flushDelayed(c *Client) {
+	r.muDelay.Lock()
+	defer r.muDelay.Unlock()
+
+	rest := r.delayQueue[:0]
+	for _, d := range r.delayQueue {
+		if d.client == c {
+			r.sendNow(d.client, d.ev)
+			continue
+		}
+		rest = append(rest, d)
+	}
+	for i := len(rest); i < len(r.delayQueue); i++ {
+		r.delayQueue[i] = delayedEvent{}
+	}
+	r.delayQueue = rest
+}
@@ -0,0 +1,60 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+	"wsnet2/pb"
+)
+
+func TestWatcherDelay(t *testing.T) {
+	r, clients := newRelayRoom(t, 1, 1)
+	clock := common.NewFakeClock(time.Now())
+	r.clock = clock
+	r.watcherDelay = time.Minute
+	player := clients[0]
+	watcher := &Client{
+		ClientInfo: &pb.ClientInfo{Id: "watcher"},
+		evbuf:      common.NewRingBuf[*binary.RegularEvent](16),
+		logger:     r.logger,
+	}
+
+	count := func(c *Client) int {
+		evs, err := c.evbuf.Read(0)
+		if err != nil {
+			t.Fatalf("evbuf.Read: %v", err)
+		}
+		return len(evs)
+	}
+
+	ev := binary.NewEvMessage(player.Id, binary.MarshalInt(1))
+	r.sendTo(player, ev)
+	r.sendTo(watcher, ev)
+	if n := count(player); n != 1 {
+		t.Fatalf("player events = %v, wants 1", n)
+	}
+	if n := count(watcher); n != 0 {
+		t.Fatalf("watcher events before delay = %v, wants 0", n)
+	}
+
+	clock.Advance(30 * time.Second)
+	r.sendTo(watcher, ev)
+	if n := count(watcher); n != 0 {
+		t.Fatalf("watcher events at 30s = %v, wants 0", n)
+	}
+
+	clock.Advance(30 * time.Second)
+	if n := count(watcher); n != 1 {
+		t.Fatalf("watcher events at 60s = %v, wants 1", n)
+	}
+
+	clock.Advance(30 * time.Second)
+	if n := count(watcher); n != 2 {
+		t.Fatalf("watcher events at 90s = %v, wants 2", n)
+	}
+	if n := clock.Timers(); n != 0 {
+		t.Fatalf("timers = %v, wants 0", n)
+	}
+}
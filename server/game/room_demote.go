@@ -0,0 +1,40 @@
+package game
+
+import (
+	"wsnet2/binary"
+)
+
+// Playerの観戦者への降格.
+//
+// MasterがMsgKickのdemoteを指定すると、Kickしたプレイヤーを切断せずに観戦者にする.
+// 離席したプレイヤーを退室させても観戦は続けられるようにするため.
+// 他のクライアントにはKickと同じEvTypeLeftの後にEvTypePlayerDemotedが届く.
+// 観戦できない部屋や観戦者が満員のときは通常のKickになる.
+
+// canDemote : Kickしたプレイヤーを観戦者にできるか.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) canDemote(c *Client) bool {
+	if !r.Watchable || r.closing || r.watchOnly {
+		return false
+	}
+	if r.MaxWatchers > 0 && r.RoomInfo.Watchers+c.nodeCount > r.MaxWatchers {
+		return false
+	}
+	_, exists := r.watchers[c.ID()]
+	return !exists
+}
+
+// demote : 退室させたPlayerを観戦者にする. removePlayerから呼ばれる.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) demote(c *Client) {
+	c.isPlayer.Store(false)
+	r.watchers[c.ID()] = c
+	r.RoomInfo.Watchers += c.nodeCount
+	r.repo.PlayerLog(c, PlayerLogDemote)
+}
+
+// notifyDemoted : EvTypeLeftの後に観戦者になったことを全員に通知する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) notifyDemoted(cid ClientID) {
+	r.broadcast(binary.NewEvPlayerDemoted(string(cid)))
+}
@@ -0,0 +1,52 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+
+	"wsnet2/binary"
+)
+
+func TestKickDemote(t *testing.T) {
+	r, clients, _, _ := newPromoteRoom(t, 3)
+	master, afk, other := clients[0], clients[1], clients[2]
+	r.RoomInfo.Watchable = true
+	var masterSeq, afkSeq int
+
+	r.dispatch(newTestMsg(t, master, binary.MsgTypeKick, binary.MarshalDemotePayload(afk.Id, "afk", 1)))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeSucceeded, binary.EvTypeLeft, binary.EvTypePlayerDemoted}) {
+		t.Fatalf("master events %v, wants [Succeeded Left PlayerDemoted]", types)
+	}
+	if types := eventTypes(afk, &afkSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeLeft, binary.EvTypePlayerDemoted}) {
+		t.Fatalf("demoted events %v, wants [Left PlayerDemoted]", types)
+	}
+	if afk.isPlayer.Load() || r.players[afk.ID()] != nil || r.watchers[afk.ID()] != afk {
+		t.Fatalf("player must be demoted: players=%v watchers=%v", r.players, r.watchers)
+	}
+	select {
+	case <-afk.removed:
+		t.Fatalf("demoted client must not be removed")
+	default:
+	}
+	if r.RoomInfo.Players != 2 || r.RoomInfo.Watchers != 2 {
+		t.Fatalf("players=%v watchers=%v, wants 2, 2", r.RoomInfo.Players, r.RoomInfo.Watchers)
+	}
+	if _, ok := r.lastMsgTime(afk.ID()); ok {
+		t.Fatalf("lastMsg of demoted player must be removed")
+	}
+
+	// 観戦できない部屋では通常のKickになる
+	r.RoomInfo.Watchable = false
+	r.dispatch(newTestMsg(t, master, binary.MsgTypeKick, binary.MarshalDemotePayload(other.Id, "afk", 1)))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeSucceeded, binary.EvTypeLeft}) {
+		t.Fatalf("master events %v, wants [Succeeded Left]", types)
+	}
+	select {
+	case <-other.removed:
+	default:
+		t.Fatalf("kicked client must be removed")
+	}
+	if r.watchers[other.ID()] != nil {
+		t.Fatalf("kicked client must not be a watcher")
+	}
+}
@@ -0,0 +1,113 @@
+package game
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+	"wsnet2/metrics"
+)
+
+// tokenBucket : 送信頻度の制限
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take : 送信を許可するならtokenを1つ消費してtrueを返す. rateが0以下なら制限しない
+func (b *tokenBucket) take(rate float64, burst int, now time.Time) bool {
+	if rate <= 0 {
+		return true
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// canReceiveEncrypted : EvTypeEncryptedとEvTypeKeyExchangeを受け取れるか.
+// 切断中もイベントは溜めておくので、接続ではなく入室時のCapabilitiesで判断する.
+func (c *Client) canReceiveEncrypted() bool {
+	return c.GetCaps().GetProtocolVersion() >= binary.ProtocolVersionEncryption
+}
+
+// checkEncrypted : envelopeのサイズと送信頻度を検査する. 中継goroutineから呼ばれる
+func (r *Room) checkEncrypted(msg *MsgEncrypted) error {
+	if limit := r.conf.MaxEncryptedSize; limit > 0 && len(msg.Envelope) > limit {
+		return xerrors.Errorf("envelope too large: %v > %v", len(msg.Envelope), limit)
+	}
+	if !msg.Sender.encrypted.take(r.conf.EncryptedRate, r.conf.EncryptedBurst, r.clock.Now()) {
+		return xerrors.Errorf("rate limit exceeded: %v/sec", r.conf.EncryptedRate)
+	}
+	return nil
+}
+
+// msgEncrypted : 暗号化されたメッセージと鍵交換をPlayerへ中継する.
+// サーバはenvelopeを解釈しないので、履歴への追加や外部への配信、審査はしない.
+// 対応していないクライアントへは送らず、あて先として指定されていたら居ないものとして通知する.
+func (r *Room) msgEncrypted(msg *MsgEncrypted) {
+	v := r.clients.Load()
+	if !v.isCurrent(msg.Sender) {
+		return
+	}
+	if !msg.Sender.isPlayer.Load() {
+		msg.Sender.logger.Warnf("sender %q is not a player", msg.Sender.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if err := r.checkEncrypted(msg); err != nil {
+		metrics.EncryptedRejected.Add(1)
+		msg.Sender.logger.Infof("encrypted message rejected: %v", err)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	msg.Sender.logger.Debugf("encrypted message (%v) to %v: %v bytes", msg.Type(), msg.Targets, len(msg.Envelope))
+
+	var ev *binary.RegularEvent
+	if msg.Type() == binary.MsgTypeKeyExchange {
+		ev = binary.NewEvKeyExchange(msg.Sender.Id, msg.Envelope)
+	} else {
+		ev = binary.NewEvEncrypted(msg.Sender.Id, msg.Envelope)
+	}
+
+	if len(msg.Targets) == 0 {
+		for _, c := range v.players {
+			if c != msg.Sender && c.canReceiveEncrypted() && !c.blocks(msg.SenderID()) {
+				r.sendTo(c, ev)
+			}
+		}
+		return
+	}
+
+	absent := make([]string, 0, len(msg.Targets))
+	for _, t := range msg.Targets {
+		c, ok := v.players[ClientID(t)]
+		if !ok || !c.canReceiveEncrypted() {
+			msg.Sender.logger.Infof("target %s is absent or does not support encryption", t)
+			absent = append(absent, t)
+			continue
+		}
+		if c.blocks(msg.SenderID()) {
+			continue
+		}
+		r.sendTo(c, ev)
+	}
+	if len(absent) > 0 {
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, absent))
+	}
+}
@@ -0,0 +1,87 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/metrics"
+	"wsnet2/pb"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var b tokenBucket
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if !b.take(1, 2, now) {
+			t.Fatalf("take #%v must be allowed within burst", i)
+		}
+	}
+	if b.take(1, 2, now) {
+		t.Fatalf("take must be denied after burst")
+	}
+	if !b.take(1, 2, now.Add(time.Second)) {
+		t.Fatalf("take must be allowed after refill")
+	}
+	if !b.take(0, 0, now) {
+		t.Fatalf("take must be allowed without rate limit")
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	r, clients, clock := newSwitchRoom(t, 3)
+	sender, capable, legacy := clients[0], clients[1], clients[2]
+	for _, c := range []*Client{sender, capable} {
+		c.Caps = &pb.Capabilities{ProtocolVersion: binary.ProtocolVersionEncryption}
+	}
+	r.conf.MaxEncryptedSize = 8
+	r.conf.EncryptedRate = 1
+	r.conf.EncryptedBurst = 2
+
+	var senderSeq, capableSeq, legacySeq int
+	send := func(typ binary.MsgType, targets []string, envelope []byte) {
+		r.relay(newTestMsg(t, sender, typ, binary.MarshalEncryptedPayload(targets, envelope)).(*MsgEncrypted))
+		r.waitRelay()
+	}
+
+	// 対応しているクライアントにだけ届き、送信者には届かない
+	send(binary.MsgTypeEncrypted, nil, []byte("secret"))
+	if types := eventTypes(capable, &capableSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeEncrypted}) {
+		t.Fatalf("capable events %v, wants [Encrypted]", types)
+	}
+	evs, _ := capable.evbuf.Read(capableSeq - 1)
+	if from, envelope, _ := binary.UnmarshalEvEncryptedPayload(evs[0].Payload()); from != sender.Id || string(envelope) != "secret" {
+		t.Fatalf("payload = (%q, %q), wants (%q, %q)", from, envelope, sender.Id, "secret")
+	}
+	if types := eventTypes(legacy, &legacySeq); len(types) != 0 {
+		t.Fatalf("legacy events %v, wants none", types)
+	}
+	if types := eventTypes(sender, &senderSeq); len(types) != 0 {
+		t.Fatalf("sender events %v, wants none", types)
+	}
+
+	// 対応していないあて先は居ないものとして通知する
+	send(binary.MsgTypeKeyExchange, []string{legacy.Id, capable.Id}, []byte("pubkey"))
+	if types := eventTypes(capable, &capableSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeKeyExchange}) {
+		t.Fatalf("capable events %v, wants [KeyExchange]", types)
+	}
+	if types := eventTypes(sender, &senderSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeTargetNotFound}) {
+		t.Fatalf("sender events %v, wants [TargetNotFound]", types)
+	}
+
+	// 頻度とサイズの上限を超えたら中継しない
+	rejected := metrics.EncryptedRejected.Value()
+	send(binary.MsgTypeEncrypted, nil, []byte("burst"))
+	clock.Advance(time.Second)
+	send(binary.MsgTypeEncrypted, nil, []byte("too large envelope"))
+	if types := eventTypes(sender, &senderSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied, binary.EvTypePermissionDenied}) {
+		t.Fatalf("sender events %v, wants 2 PermissionDenied", types)
+	}
+	if types := eventTypes(capable, &capableSeq); len(types) != 0 {
+		t.Fatalf("capable events %v, wants none", types)
+	}
+	if n := metrics.EncryptedRejected.Value() - rejected; n != 2 {
+		t.Fatalf("rejected = %v, wants 2", n)
+	}
+}
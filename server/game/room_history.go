@@ -0,0 +1,124 @@
+package game
+
+import (
+	"sync"
+
+	"wsnet2/binary"
+)
+
+// msgHistory : 部屋でbroadcastされたEvTypeMessageを直近size件保持する.
+// 中継goroutineから追加され、部屋のgoroutineから読まれる.
+type msgHistory struct {
+	mu    sync.Mutex
+	evs   []*binary.RegularEvent // リングバッファ
+	count int                    // これまでに追加した総数
+}
+
+func newMsgHistory(size int) *msgHistory {
+	if size <= 0 {
+		return nil
+	}
+	return &msgHistory{
+		evs: make([]*binary.RegularEvent, size),
+	}
+}
+
+func (h *msgHistory) add(ev *binary.RegularEvent) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.evs[h.count%len(h.evs)] = ev
+	h.count++
+}
+
+// redact : evを履歴から除く
+func (h *msgHistory) redact(ev *binary.RegularEvent) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, e := range h.evs {
+		if e == ev {
+			h.evs[i] = nil
+			return
+		}
+	}
+}
+
+// total : これまでに追加した総数
+func (h *msgHistory) total() int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// bytes : 保持しているメッセージのバイト数
+func (h *msgHistory) bytes() int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, ev := range h.evs {
+		if ev != nil {
+			n += len(ev.Payload())
+		}
+	}
+	return n
+}
+
+// before : 総数がendだった時点までのうち、保持している最新のn件を古い順に返す. nが0なら保持しているすべて.
+func (h *msgHistory) before(end, n int) []*binary.RegularEvent {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if end > h.count {
+		end = h.count
+	}
+	start := h.count - len(h.evs)
+	if start < 0 {
+		start = 0
+	}
+	if n > 0 && end-n > start {
+		start = end - n
+	}
+	if start >= end {
+		return nil
+	}
+	evs := make([]*binary.RegularEvent, 0, end-start)
+	for i := start; i < end; i++ {
+		if ev := h.evs[i%len(h.evs)]; ev != nil {
+			evs = append(evs, ev)
+		}
+	}
+	return evs
+}
+
+// msgFetchHistory : 入室前にbroadcastされたメッセージを送る.
+// 入室後のメッセージは受信済みなので含めない.
+func (r *Room) msgFetchHistory(msg *MsgFetchHistory) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	if !msg.Sender.isPlayer.Load() {
+		msg.Sender.logger.Warnf("sender %q is not a player", msg.Sender.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if r.players[msg.SenderID()] != msg.Sender {
+		return
+	}
+
+	evs := msg.Sender.filterBlocked(r.history.before(msg.Sender.historyEnd, msg.Count))
+	msg.Sender.logger.Debugf("fetch history: count=%v, found=%v", msg.Count, len(evs))
+	r.sendTo(msg.Sender, binary.NewEvHistory(evs))
+}
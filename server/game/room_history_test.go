@@ -0,0 +1,87 @@
+package game
+
+import (
+	"testing"
+
+	"wsnet2/binary"
+)
+
+func TestRoomHistoryBefore(t *testing.T) {
+	h := newMsgHistory(3)
+	for i := 0; i < 5; i++ {
+		h.add(binary.NewEvMessage("p", binary.MarshalInt(i)))
+	}
+	tests := []struct {
+		end, n int
+		want   []int
+	}{
+		{5, 0, []int{2, 3, 4}},
+		{5, 2, []int{3, 4}},
+		{4, 0, []int{2, 3}},
+		{4, 1, []int{3}},
+		{2, 0, nil}, // 既に捨てられている
+		{10, 0, []int{2, 3, 4}},
+	}
+	for _, tc := range tests {
+		evs := h.before(tc.end, tc.n)
+		var got []int
+		for _, ev := range evs {
+			_, body, _ := binary.UnmarshalEvMessage(ev.Payload())
+			d, _, _ := binary.UnmarshalAs(body, binary.TypeInt)
+			got = append(got, d.(int))
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("before(%v, %v) = %v, wants %v", tc.end, tc.n, got, tc.want)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Fatalf("before(%v, %v) = %v, wants %v", tc.end, tc.n, got, tc.want)
+			}
+		}
+	}
+
+	if evs := newMsgHistory(0).before(5, 0); evs != nil {
+		t.Fatalf("disabled history must be empty: %v", evs)
+	}
+}
+
+func TestMsgFetchHistory(t *testing.T) {
+	r, clients := newRelayRoom(t, 2, 1)
+	r.history = newMsgHistory(10)
+	late := clients[1]
+
+	for i := 0; i < 3; i++ {
+		r.relay(&MsgBroadcast{Sender: clients[0], Data: binary.MarshalInt(i)})
+	}
+	r.waitRelay()
+	// 3件目の後に入室した
+	late.historyEnd = 2
+
+	r.msgFetchHistory(&MsgFetchHistory{Sender: late})
+
+	evs, err := late.evbuf.Read(0)
+	if err != nil {
+		t.Fatalf("evbuf.Read: %v", err)
+	}
+	ev := evs[len(evs)-1]
+	if ev.Type() != binary.EvTypeHistory {
+		t.Fatalf("event type = %v, wants %v", ev.Type(), binary.EvTypeHistory)
+	}
+	msgs, err := binary.UnmarshalEvHistoryPayload(ev.Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvHistoryPayload: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("len(msgs) = %v, wants 2", len(msgs))
+	}
+	for i, m := range msgs {
+		sender, body, err := binary.UnmarshalEvMessage(m)
+		if err != nil {
+			t.Fatalf("UnmarshalEvMessage: %v", err)
+		}
+		d, _, _ := binary.UnmarshalAs(body, binary.TypeInt)
+		if sender != clients[0].Id || d.(int) != i {
+			t.Fatalf("msgs[%v] = (%v, %v), wants (%v, %v)", i, sender, d, clients[0].Id, i)
+		}
+	}
+}
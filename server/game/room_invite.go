@@ -0,0 +1,117 @@
+package game
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc/codes"
+
+	"wsnet2/auth"
+)
+
+// 招待による入室:
+// Lobbyが発行した招待トークン (see: auth/invite.go) で入室するクライアントは、gRPC Joinでトークンをgameサーバに渡す.
+// 署名はLobbyで検証済みなので、gameサーバでは部屋とユーザ、期限だけを確認する.
+// 招待で入室したクライアントは、招待の期限までに最初のpeerを接続しないと認証に失敗する (see: Client.ValidAuthData).
+// ユーザを指定した招待は、gRPC ReserveSeatで招待の期限まで席を確保できる (see: room_merge.go reserved).
+
+// checkInvite : tokenがroomIdの部屋のclientIdへの有効な招待か確認し、招待の期限を返す
+func checkInvite(token, roomId, clientId string, now time.Time) (time.Time, ErrorWithCode) {
+	rid, uid, expire, err := auth.ParseInviteToken(token)
+	if err != nil {
+		return time.Time{}, WithCode(xerrors.Errorf("invalid invite token: %w", err), codes.InvalidArgument)
+	}
+	if rid != roomId {
+		return time.Time{}, WithCode(
+			xerrors.Errorf("invite is for other room: room=%v invite=%v", roomId, rid), codes.InvalidArgument)
+	}
+	if uid != "" && uid != clientId {
+		return time.Time{}, WithCode(
+			xerrors.Errorf("invite is for other user: client=%v invitee=%v", clientId, uid), codes.InvalidArgument)
+	}
+	if now.After(expire) {
+		return time.Time{}, WithCode(xerrors.Errorf("invite expired: %v", expire), codes.InvalidArgument)
+	}
+	return expire, nil
+}
+
+// ReserveSeat : 招待されたユーザの席を招待の期限まで確保する
+func (repo *Repository) ReserveSeat(ctx context.Context, roomID, token string) ErrorWithCode {
+	room, err := repo.GetRoom(roomID)
+	if err != nil {
+		return WithCode(xerrors.Errorf("ReserveSeat: can not find room %q; %w", roomID, err), codes.NotFound)
+	}
+	_, invitee, _, err := auth.ParseInviteToken(token)
+	if err != nil {
+		return WithCode(xerrors.Errorf("invalid invite token: %w", err), codes.InvalidArgument)
+	}
+	if invitee == "" {
+		return WithCode(xerrors.Errorf("invite has no user: room=%v", roomID), codes.InvalidArgument)
+	}
+	expire, ewc := checkInvite(token, roomID, invitee, repo.clock.Now())
+	if ewc != nil {
+		return ewc
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	errCh := make(chan ErrorWithCode, 1)
+	msg := &MsgReserveInvite{
+		ClientId: ClientID(invitee),
+		Expire:   expire,
+		Err:      errCh,
+	}
+	select {
+	case <-ctx.Done():
+		return WithCode(
+			xerrors.Errorf("ReserveSeat write msg timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case room.msgCh <- msg:
+	}
+
+	select {
+	case <-ctx.Done():
+		return WithCode(
+			xerrors.Errorf("ReserveSeat response timeout or context done: room=%q", room.Id),
+			codes.DeadlineExceeded)
+	case err := <-errCh:
+		return err
+	}
+}
+
+// msgReserveInvite : 招待されたユーザの席を確保する. 確保済みなら期限を延ばす.
+func (r *Room) msgReserveInvite(msg *MsgReserveInvite) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	id := msg.ClientId
+	if r.closing || r.merging || !r.Joinable || r.watchOnly {
+		msg.Err <- WithCode(xerrors.Errorf("room is not joinable: room=%v", r.Id), codes.FailedPrecondition)
+		return
+	}
+	if _, ok := r.players[id]; ok {
+		msg.Err <- WithCode(xerrors.Errorf("already joined: room=%v client=%v", r.Id, id), codes.AlreadyExists)
+		return
+	}
+	if until, ok := r.banned[id]; ok && r.clock.Now().Before(until) {
+		msg.Err <- WithCode(xerrors.Errorf("client is banned: room=%v client=%v until=%v", r.Id, id, until), codes.PermissionDenied)
+		return
+	}
+
+	seats := r.reservedSeats("") // 期限切れの席を取り除く
+	expire, reserved := r.reserved[id]
+	if !reserved && r.playerCount()+seats >= r.MaxPlayers {
+		msg.Err <- WithCode(xerrors.Errorf("room full: room=%v max=%v client=%v", r.Id, r.MaxPlayers, id), codes.ResourceExhausted)
+		return
+	}
+	if expire.Before(msg.Expire) {
+		if r.reserved == nil {
+			r.reserved = make(map[ClientID]time.Time)
+		}
+		r.reserved[id] = msg.Expire
+	}
+	r.logger.Infof("seat reserved for invite: %v until=%v", id, msg.Expire)
+	msg.Err <- nil
+}
@@ -0,0 +1,114 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"wsnet2/auth"
+)
+
+func TestCheckInvite(t *testing.T) {
+	now := time.Now()
+	expire := time.Unix(now.Add(time.Hour).Unix(), 0)
+	token, err := auth.GenerateInviteToken("key", "room1", "user1", expire)
+	if err != nil {
+		t.Fatalf("GenerateInviteToken: %+v", err)
+	}
+
+	exp, ewc := checkInvite(token, "room1", "user1", now)
+	if ewc != nil {
+		t.Fatalf("checkInvite: %+v", ewc)
+	}
+	if !exp.Equal(expire) {
+		t.Fatalf("expire = %v, wants %v", exp, expire)
+	}
+
+	tests := map[string]struct {
+		room, user string
+		now        time.Time
+	}{
+		"other room": {"room2", "user1", now},
+		"other user": {"room1", "user2", now},
+		"expired":    {"room1", "user1", expire.Add(time.Second)},
+	}
+	for name, tc := range tests {
+		if _, ewc := checkInvite(token, tc.room, tc.user, tc.now); ewc == nil || ewc.Code() != codes.InvalidArgument {
+			t.Fatalf("%v: checkInvite = %v, wants InvalidArgument", name, ewc)
+		}
+	}
+}
+
+func TestReserveInviteSeat(t *testing.T) {
+	r, clients, clock := newSwitchRoom(t, 1)
+	r.RoomInfo.Joinable = true
+	r.RoomInfo.MaxPlayers = 2
+
+	reserve := func(id ClientID, expire time.Time) ErrorWithCode {
+		t.Helper()
+		errCh := make(chan ErrorWithCode, 1)
+		r.msgReserveInvite(&MsgReserveInvite{ClientId: id, Expire: expire, Err: errCh})
+		return <-errCh
+	}
+
+	expire := clock.Now().Add(10 * time.Second)
+	if err := reserve("a", expire); err != nil {
+		t.Fatalf("reserve a: %v", err)
+	}
+	if err := reserve("b", expire); err == nil || err.Code() != codes.ResourceExhausted {
+		t.Fatalf("reserve b must fail with room full: %v", err)
+	}
+	if err := reserve(clients[0].ID(), expire); err == nil || err.Code() != codes.AlreadyExists {
+		t.Fatalf("reserve for player must fail: %v", err)
+	}
+
+	// 確保済みなら期限を延ばす
+	if err := reserve("a", expire.Add(10*time.Second)); err != nil {
+		t.Fatalf("reserve a again: %v", err)
+	}
+	clock.Advance(15 * time.Second)
+	if n := r.reservedSeats(""); n != 1 {
+		t.Fatalf("reservedSeats = %v, wants 1", n)
+	}
+	if n := r.reservedSeats("a"); n != 0 {
+		t.Fatalf("reservedSeats(a) = %v, wants 0", n)
+	}
+
+	// 期限が過ぎたら席を解放する
+	clock.Advance(10 * time.Second)
+	if err := reserve("b", clock.Now().Add(10*time.Second)); err != nil {
+		t.Fatalf("reserve b after expire: %v", err)
+	}
+
+	r.RoomInfo.Joinable = false
+	if err := reserve("c", clock.Now().Add(10*time.Second)); err == nil || err.Code() != codes.FailedPrecondition {
+		t.Fatalf("reserve for non-joinable room must fail: %v", err)
+	}
+}
+
+func TestValidAuthDataInviteExpire(t *testing.T) {
+	_, clients, clock := newSwitchRoom(t, 1)
+	c := clients[0]
+	c.authKey = "authkey"
+	authData, err := auth.GenerateAuthData(c.authKey, c.Id, clock.Now())
+	if err != nil {
+		t.Fatalf("GenerateAuthData: %+v", err)
+	}
+
+	c.inviteExpire.Store(clock.Now().Add(time.Second).UnixNano())
+	if err := c.ValidAuthData(authData); err != nil {
+		t.Fatalf("ValidAuthData before invite expire: %+v", err)
+	}
+
+	clock.Advance(2 * time.Second)
+	if err := c.ValidAuthData(authData); err == nil {
+		t.Fatalf("ValidAuthData after invite expire must fail")
+	}
+
+	// 最初のpeerを接続した後は確認しない
+	c.inviteExpire.Store(0)
+	if err := c.ValidAuthData(authData); err != nil {
+		t.Fatalf("ValidAuthData after attach: %+v", err)
+	}
+}
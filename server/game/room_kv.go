@@ -0,0 +1,159 @@
+package game
+
+import (
+	"bytes"
+
+	"wsnet2/binary"
+)
+
+// kvEntry : 部屋KVストアの値
+type kvEntry struct {
+	value     []byte // marshaled data
+	owner     ClientID
+	ownership binary.KVOwnership
+}
+
+// kvWritable : senderがkeyを書き換え可能か
+// 他のPlayerが所有するキーは書き換えられない
+func (r *Room) kvWritable(sender *Client, key string) bool {
+	e, ok := r.kv[key]
+	return !ok || e.ownership == binary.KVOwnerNone || e.owner == sender.ID()
+}
+
+// kvPlayerSender : senderが入室中のPlayerか確認する
+// muClients のロックを取得してから呼び出す.
+func (r *Room) kvPlayerSender(msg binary.RegularMsg, sender *Client) bool {
+	if !sender.isPlayer.Load() {
+		sender.logger.Warnf("sender %q is not a player", sender.Id)
+		r.sendTo(sender, binary.NewEvPermissionDenied(msg))
+		return false
+	}
+	return r.players[sender.ID()] == sender
+}
+
+func (r *Room) kvStore(sender *Client, key string, ownership binary.KVOwnership, value []byte) *binary.RegularEvent {
+	e := &kvEntry{
+		value:     value,
+		ownership: ownership,
+	}
+	if ownership != binary.KVOwnerNone {
+		e.owner = sender.ID()
+	}
+	r.kv[key] = e
+
+	return binary.NewEvKVUpdated(sender.Id,
+		binary.Dict{key: value},
+		binary.Dict{key: binary.MarshalStr8(string(e.owner))})
+}
+
+// kvSnapshot : KVストア全体を通知するイベント. 入室時に送る.
+func (r *Room) kvSnapshot() *binary.RegularEvent {
+	values := make(binary.Dict, len(r.kv))
+	owners := make(binary.Dict, len(r.kv))
+	for k, e := range r.kv {
+		values[k] = e.value
+		owners[k] = binary.MarshalStr8(string(e.owner))
+	}
+	return binary.NewEvKVUpdated("", values, owners)
+}
+
+// releaseKV : 退室したPlayerが所有するキーを削除またはMasterへ移譲する.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) releaseKV(cid ClientID) *binary.RegularEvent {
+	values := binary.Dict{}
+	owners := binary.Dict{}
+	for k, e := range r.kv {
+		if e.ownership == binary.KVOwnerNone || e.owner != cid {
+			continue
+		}
+		if e.ownership == binary.KVOwnerTransferOnLeave {
+			e.owner = r.master.ID()
+			values[k] = e.value
+			owners[k] = binary.MarshalStr8(string(e.owner))
+		} else {
+			delete(r.kv, k)
+			values[k] = []byte{}
+			owners[k] = binary.MarshalStr8("")
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	r.logger.Debugf("release kv of %v: %v", cid, values)
+	return binary.NewEvKVUpdated("", values, owners)
+}
+
+func (r *Room) msgKVSet(msg *MsgKVSet) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if !r.kvPlayerSender(msg, msg.Sender) {
+		return
+	}
+	if !r.kvWritable(msg.Sender, msg.Key) {
+		msg.Sender.logger.Warnf("kv key %q is owned by %q", msg.Key, r.kv[msg.Key].owner)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	msg.Sender.logger.Debugf("kv set: %q=%v (%v)", msg.Key, msg.Value, msg.Ownership)
+
+	ev := r.kvStore(msg.Sender, msg.Key, msg.Ownership, msg.Value)
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+	r.broadcast(ev)
+}
+
+func (r *Room) msgKVDelete(msg *MsgKVDelete) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if !r.kvPlayerSender(msg, msg.Sender) {
+		return
+	}
+	if !r.kvWritable(msg.Sender, msg.Key) {
+		msg.Sender.logger.Warnf("kv key %q is owned by %q", msg.Key, r.kv[msg.Key].owner)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	msg.Sender.logger.Debugf("kv delete: %q", msg.Key)
+
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+	if _, ok := r.kv[msg.Key]; !ok {
+		return
+	}
+	delete(r.kv, msg.Key)
+	r.broadcast(binary.NewEvKVUpdated(msg.Sender.Id,
+		binary.Dict{msg.Key: []byte{}},
+		binary.Dict{msg.Key: binary.MarshalStr8("")}))
+}
+
+func (r *Room) msgKVCompareAndSwap(msg *MsgKVCompareAndSwap) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if !r.kvPlayerSender(msg, msg.Sender) {
+		return
+	}
+	if !r.kvWritable(msg.Sender, msg.Key) {
+		msg.Sender.logger.Warnf("kv key %q is owned by %q", msg.Key, r.kv[msg.Key].owner)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	var current []byte
+	if e, ok := r.kv[msg.Key]; ok {
+		current = e.value
+	}
+	if !bytes.Equal(current, msg.Expected) {
+		msg.Sender.logger.Debugf("kv cas conflict: %q=%v, expected=%v", msg.Key, current, msg.Expected)
+		r.sendTo(msg.Sender, binary.NewEvKVConflict(msg, current))
+		return
+	}
+
+	msg.Sender.logger.Debugf("kv cas: %q=%v (%v)", msg.Key, msg.Value, msg.Ownership)
+
+	ev := r.kvStore(msg.Sender, msg.Key, msg.Ownership, msg.Value)
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+	r.broadcast(ev)
+}
@@ -0,0 +1,43 @@
+package game
+
+import (
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+)
+
+// roomLifetime : 部屋の寿命. RoomOptionの指定(秒)はGameConf.MaxRoomLifetimeForを超えられない. 0は無制限
+func roomLifetime(opt uint32, limit time.Duration) time.Duration {
+	d := time.Duration(opt) * time.Second
+	if d == 0 || (limit > 0 && d > limit) {
+		return limit
+	}
+	return d
+}
+
+// startLifetime : 部屋の作成から寿命が過ぎたら閉じるタイマーを開始する. 寿命が無制限ならnilを返す.
+// 再起動後に復元した部屋も作成時刻から数える.
+func (r *Room) startLifetime() common.Timer {
+	if r.lifetime <= 0 {
+		return nil
+	}
+	d := r.lifetime
+	if r.Created != nil {
+		d = r.Created.Time().Add(r.lifetime).Sub(r.clock.Now())
+	}
+	return r.clock.AfterFunc(d, func() {
+		r.SendMessage(&MsgRoomExpired{})
+	})
+}
+
+func (r *Room) msgRoomExpired(msg *MsgRoomExpired) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if r.closing {
+		return
+	}
+	r.logger.Infof("room expired: lifetime=%v", r.lifetime)
+	r.startClosing(binary.RoomClosedExpired)
+}
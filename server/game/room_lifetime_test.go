@@ -0,0 +1,62 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+)
+
+func TestRoomLifetime(t *testing.T) {
+	tests := []struct {
+		opt   uint32
+		limit time.Duration
+		want  time.Duration
+	}{
+		{0, 0, 0},
+		{60, 0, time.Minute},
+		{0, time.Hour, time.Hour},
+		{60, time.Hour, time.Minute},
+		{7200, time.Hour, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := roomLifetime(tt.opt, tt.limit); got != tt.want {
+			t.Errorf("roomLifetime(%v, %v) = %v, wants %v", tt.opt, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestRoomExpired(t *testing.T) {
+	r, clients, clock := newSwitchRoom(t, 1)
+	r.lifetime = time.Hour
+	// 復元した部屋は作成時刻から数える
+	r.SetCreated(clock.Now().Add(-30 * time.Minute))
+
+	timer := r.startLifetime()
+	defer timer.Stop()
+
+	clock.Advance(29 * time.Minute)
+	if len(r.msgCh) != 0 {
+		t.Fatalf("room expired before lifetime: %T", <-r.msgCh)
+	}
+	clock.Advance(time.Minute)
+	r.dispatch(<-r.msgCh)
+
+	if !r.closing || r.Joinable {
+		t.Fatalf("room must be closing: closing=%v joinable=%v", r.closing, r.Joinable)
+	}
+	var seq int
+	if types := eventTypes(clients[0], &seq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeRoomClosed}) {
+		t.Fatalf("events %v, wants [RoomClosed]", types)
+	}
+	evs, _ := clients[0].evbuf.Read(0)
+	if reason, _ := binary.UnmarshalEvRoomClosedPayload(evs[0].Payload()); reason != binary.RoomClosedExpired {
+		t.Fatalf("reason = %q, wants %q", reason, binary.RoomClosedExpired)
+	}
+
+	clock.Advance(RoomCloseWait)
+	if _, ok := (<-r.msgCh).(*MsgCloseRoom); !ok {
+		t.Fatalf("MsgCloseRoom must be sent after RoomCloseWait")
+	}
+}
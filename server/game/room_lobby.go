@@ -0,0 +1,122 @@
+package game
+
+import (
+	"wsnet2/binary"
+)
+
+// ロビー部屋と子の部屋:
+// RoomOption.LobbyRoomで作った部屋には、RoomOption.ParentRoomを指定して同じgameサーバに子の部屋を作れる.
+// 子の部屋のPlayerはMsgRelayToParentで招待と対戦状況の要約だけをロビー部屋に中継でき、
+// ロビー部屋のクライアントにはEvChildRoomMessageとして届く. 子の部屋が閉じたときもサーバから通知する.
+// クライアントは子の部屋に入室したままロビー部屋に接続し続ける必要はない.
+
+func (r *Room) msgRelayToParent(msg *MsgRelayToParent) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	if !msg.Sender.isPlayer.Load() {
+		msg.Sender.logger.Warnf("sender %q is not a player", msg.Sender.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if r.players[msg.SenderID()] != msg.Sender {
+		return
+	}
+	if r.parent == "" {
+		msg.Sender.logger.Warnf("room has no parent: %v", r.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	var target ClientID
+	switch msg.Kind {
+	case binary.RoomRelayInvite:
+		if msg.Target == "" {
+			msg.Sender.logger.Warnf("invite without target")
+			r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+			return
+		}
+		target = ClientID(msg.Target)
+	case binary.RoomRelaySummary:
+		if msg.Sender != r.master {
+			msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.masterID())
+			r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+			return
+		}
+	default:
+		msg.Sender.logger.Warnf("invalid relay kind: %v", msg.Kind)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if limit := r.conf.MaxRoomRelaySize; limit > 0 && len(msg.Data) > limit {
+		msg.Sender.logger.Infof("relay data too large: %v > %v", len(msg.Data), limit)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	parent, err := r.repo.GetRoom(r.parent)
+	if err != nil {
+		msg.Sender.logger.Infof("parent room is absent: %v", err)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{r.parent}))
+		return
+	}
+
+	msg.Sender.logger.Debugf("relay to parent %v: kind=%v target=%v", r.parent, msg.Kind, target)
+	parent.SendMessage(&MsgChildRelay{
+		Child:  r.Id,
+		Sender: msg.Sender.Id,
+		Kind:   msg.Kind,
+		Target: target,
+		Data:   msg.Data,
+	})
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+}
+
+// notifyParentClosed : 子の部屋が閉じたことをロビー部屋に通知する. MsgLoopの終了時に呼ぶ
+func (r *Room) notifyParentClosed() {
+	if r.parent == "" {
+		return
+	}
+	parent, err := r.repo.GetRoom(r.parent)
+	if err != nil {
+		return
+	}
+	parent.SendMessage(&MsgChildRelay{Child: r.Id, Kind: binary.RoomRelayClosed})
+}
+
+// msgChildRelay : 子の部屋から中継されたメッセージをロビー部屋のクライアントに送る.
+// 招待はあて先が居なければ捨てる. 送信者をブロックしているクライアントには送らない.
+func (r *Room) msgChildRelay(msg *MsgChildRelay) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	ev := binary.NewEvChildRoomMessage(msg.Child, msg.Sender, msg.Kind, msg.Data)
+	sender := ClientID(msg.Sender)
+	if msg.Target == "" {
+		r.logger.Debugf("child room message from %v: kind=%v", msg.Child, msg.Kind)
+		r.publish(ev)
+		for _, c := range r.players {
+			if !c.blocks(sender) {
+				r.sendTo(c, ev)
+			}
+		}
+		for _, c := range r.watchers {
+			r.sendTo(c, ev)
+		}
+		return
+	}
+
+	target, ok := r.players[msg.Target]
+	if !ok {
+		target, ok = r.watchers[msg.Target]
+	}
+	if !ok {
+		r.logger.Infof("child room message target is absent: child=%v target=%v", msg.Child, msg.Target)
+		return
+	}
+	if target.blocks(sender) {
+		return
+	}
+	r.logger.Debugf("child room message from %v to %v: kind=%v", msg.Child, msg.Target, msg.Kind)
+	r.sendTo(target, ev)
+}
@@ -0,0 +1,81 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+
+	"wsnet2/binary"
+)
+
+func TestRelayToParent(t *testing.T) {
+	lobby, members, _ := newSwitchRoom(t, 3)
+	lobby.Id = "lobby"
+	lobby.lobbyRoom = true
+	child, players, _ := newSwitchRoom(t, 2)
+	child.Id = "child"
+	child.parent = lobby.Id
+	child.conf.MaxRoomRelaySize = 8
+	child.repo = &Repository{rooms: map[RoomID]*Room{RoomID(lobby.Id): lobby}}
+
+	master, player := players[0], players[1]
+	var masterSeq, playerSeq int
+
+	// Master以外は要約を送れない. 招待はあて先が必要. サイズの上限を超えたら中継しない
+	child.msgRelayToParent(newTestMsg(t, player, binary.MsgTypeRelayToParent, binary.MarshalRelayToParentPayload(binary.RoomRelaySummary, "", []byte("score"))).(*MsgRelayToParent))
+	child.msgRelayToParent(newTestMsg(t, player, binary.MsgTypeRelayToParent, binary.MarshalRelayToParentPayload(binary.RoomRelayInvite, "", []byte("come"))).(*MsgRelayToParent))
+	child.msgRelayToParent(newTestMsg(t, master, binary.MsgTypeRelayToParent, binary.MarshalRelayToParentPayload(binary.RoomRelaySummary, "", []byte("too large summary"))).(*MsgRelayToParent))
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied, binary.EvTypePermissionDenied}) {
+		t.Fatalf("player events %v, wants 2 PermissionDenied", types)
+	}
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("master events %v, wants [PermissionDenied]", types)
+	}
+	if n := len(lobby.msgCh); n != 0 {
+		t.Fatalf("lobby received %v messages, wants 0", n)
+	}
+
+	seqs := make([]int, len(members))
+	check := func(wants ...bool) {
+		t.Helper()
+		for i, c := range members {
+			types := eventTypes(c, &seqs[i])
+			if !wants[i] {
+				if len(types) != 0 {
+					t.Fatalf("member%d events %v, wants none", i, types)
+				}
+				continue
+			}
+			if !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeChildRoomMessage}) {
+				t.Fatalf("member%d events %v, wants [ChildRoomMessage]", i, types)
+			}
+		}
+	}
+
+	// 招待はあて先にだけ届く
+	child.msgRelayToParent(newTestMsg(t, player, binary.MsgTypeRelayToParent, binary.MarshalRelayToParentPayload(binary.RoomRelayInvite, members[2].Id, []byte("come"))).(*MsgRelayToParent))
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeSucceeded}) {
+		t.Fatalf("player events %v, wants [Succeeded]", types)
+	}
+	lobby.dispatch(<-lobby.msgCh)
+	check(false, false, true)
+	evs, _ := members[2].evbuf.Read(seqs[2] - 1)
+	p, err := binary.UnmarshalEvChildRoomMessagePayload(evs[0].Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvChildRoomMessagePayload: %v", err)
+	}
+	want := &binary.EvChildRoomMessagePayload{RoomId: child.Id, Sender: player.Id, Kind: binary.RoomRelayInvite, Data: []byte("come")}
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("payload = %+v, wants %+v", p, want)
+	}
+
+	// 要約は送信者をブロックしていないクライアント全員に届く
+	members[1].blocked.Store(&map[ClientID]struct{}{master.ID(): {}})
+	child.msgRelayToParent(newTestMsg(t, master, binary.MsgTypeRelayToParent, binary.MarshalRelayToParentPayload(binary.RoomRelaySummary, "", []byte("score"))).(*MsgRelayToParent))
+	lobby.dispatch(<-lobby.msgCh)
+	check(true, false, true)
+
+	// 子の部屋が閉じたら通知する
+	child.notifyParentClosed()
+	lobby.dispatch(<-lobby.msgCh)
+	check(true, true, true)
+}
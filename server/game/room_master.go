@@ -0,0 +1,187 @@
+package game
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+)
+
+// masterInbox : MsgToMasterの配送先と、Masterへ送ったイベントの記録.
+//
+// MsgToMasterのイベントは、中継した時点のMasterにだけ届ける.
+// 旧Masterへのイベントは旧MasterのEvMasterSwitched(またはEvLeft)より前に、
+// 新Masterへのイベントは新MasterのEvMasterSwitched(またはEvLeft)より後に届く.
+// 退室や再入室で旧Masterが受け取れなくなったとき、まだPeerに渡していないイベントは
+// 旧Masterから取り下げて新Masterへ順番通りに送り直す. 重複して届くことはない.
+type masterInbox struct {
+	// Master交代中はロックを保持し、その間のMsgToMasterを待たせる
+	mu     sync.Mutex
+	master *Client
+	evs    []masterEvent
+}
+
+// masterEvent : Masterへ送ったイベントとevbuf上の位置
+type masterEvent struct {
+	seq int
+	ev  *binary.RegularEvent
+}
+
+// sendToMaster : Masterにイベントを送って記録する.
+// r.toMaster.mu をロックしてから呼び出すこと
+func (r *Room) sendToMaster(master *Client, ev *binary.RegularEvent) {
+	in := &r.toMaster
+	if in.master != master {
+		in.master = master
+		in.evs = nil
+	}
+
+	seq, err := master.sendSeq(ev)
+	if err != nil {
+		master.logger.Infof("sendToMaster %v: %v", master.Id, err.Error())
+		go func() {
+			r.muClients.Lock()
+			r.removeClient(master, err.Error())
+			r.muClients.Unlock()
+		}()
+		return
+	}
+
+	// evbufから溢れた位置は取り下げられないので捨てる
+	for len(in.evs) > 0 && seq-in.evs[0].seq >= master.evbuf.Cap() {
+		in.evs = in.evs[1:]
+	}
+	in.evs = append(in.evs, masterEvent{seq, ev})
+}
+
+// redeliverToMaster : 受け取れなくなった旧Masterへの未送信のイベントを、現在のMasterへ送り直す.
+// r.master を変更してpublishClientsした後、r.toMaster.mu をロックしたまま呼び出すこと
+func (r *Room) redeliverToMaster(old *Client) {
+	in := &r.toMaster
+	if in.master != old {
+		return
+	}
+	evs := in.evs
+	in.master = nil
+	in.evs = nil
+
+	// 先頭から順に、最初に取り下げられた位置以降は未送信
+	for i, e := range evs {
+		if !old.withdraw(e.seq) {
+			continue
+		}
+		evs = evs[i:]
+		r.logger.Infof("redeliver %v messages to master: %v -> %v", len(evs), old.Id, r.master.Id)
+		for _, e := range evs {
+			r.sendToMaster(r.master, e.ev)
+		}
+		return
+	}
+}
+
+// masterSwitch : 移譲先の受諾を待っているMasterの移譲.
+//
+// 移譲先にEvMasterSwitchRequestedを送り、MsgAcceptMasterで受諾されたらMasterを交代する.
+// SwitchMasterTimeoutまでに受諾されなかったときは、その間に移譲先から通信があれば交代し、
+// 無ければ応答のない移譲先がMasterになるのを避けるため取り消す.
+type masterSwitch struct {
+	req     *MsgSwitchMaster
+	target  *Client
+	lastMsg []byte // 打診時点の移譲先のlastMsg
+	timer   common.Timer
+}
+
+// requestSwitch : 移譲先に受諾を打診する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) requestSwitch(msg *MsgSwitchMaster, target *Client, timeout time.Duration) {
+	s := &masterSwitch{
+		req:     msg,
+		target:  target,
+		lastMsg: r.lastMsg[string(target.ID())],
+	}
+	s.timer = r.clock.AfterFunc(timeout, func() {
+		r.SendMessage(&MsgSwitchMasterTimeout{s})
+	})
+	r.switching = s
+
+	msg.Sender.logger.Infof("master switch requested: %v -> %v", msg.Sender.ID(), target.Id)
+	r.sendTo(target, binary.NewEvMasterSwitchRequested(msg.Sender.Id))
+}
+
+// switchMaster : Masterを交代して通知する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) switchMaster(msg *MsgSwitchMaster, target *Client) {
+	// 旧Masterへのイベントは全てEvMasterSwitchedより前に届く.
+	// 交代が終わるまでMsgToMasterを待たせ、新MasterにはEvMasterSwitchedの後に届ける.
+	r.toMaster.mu.Lock()
+	defer r.toMaster.mu.Unlock()
+
+	r.master = target
+	r.publishClients()
+
+	msg.Sender.logger.Infof("master switched: %v -> %v", msg.Sender.ID(), r.master.Id)
+
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+	r.broadcast(binary.NewEvMasterSwitched(msg.Sender.Id, r.master.Id))
+}
+
+// finishSwitch : 受諾待ちの移譲を終える. acceptedなら交代し、そうでなければ取り消す.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) finishSwitch(s *masterSwitch, accepted bool) {
+	s.timer.Stop()
+	r.switching = nil
+
+	sender := s.req.Sender
+	if r.master != sender {
+		// 旧Masterが退室した
+		return
+	}
+	if r.players[s.target.ID()] != s.target {
+		sender.logger.Infof("master switch target %v left", s.target.Id)
+		r.sendTo(sender, binary.NewEvTargetNotFound(s.req, []string{s.target.Id}))
+		return
+	}
+	if !accepted {
+		sender.logger.Infof("master switch target %v is not responding", s.target.Id)
+		r.sendTo(sender, binary.NewEvTargetNotFound(s.req, []string{s.target.Id}))
+		return
+	}
+	r.switchMaster(s.req, s.target)
+}
+
+// cancelSwitch : 退室したPlayerが旧Masterか移譲先の移譲を取り消す.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) cancelSwitch(c *Client) {
+	s := r.switching
+	if s == nil || (s.req.Sender != c && s.target != c) {
+		return
+	}
+	r.finishSwitch(s, false)
+}
+
+func (r *Room) msgAcceptMaster(msg *MsgAcceptMaster) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	s := r.switching
+	if s == nil || s.target != msg.Sender {
+		msg.Sender.logger.Infof("no master switch to %v", msg.Sender.Id)
+		return
+	}
+	r.finishSwitch(s, true)
+}
+
+func (r *Room) msgSwitchMasterTimeout(msg *MsgSwitchMasterTimeout) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	s := msg.Switch
+	if r.switching != s {
+		return
+	}
+	// 受諾がなくても、打診後に通信があれば応答できる状態とみなして交代する
+	responded := !bytes.Equal(s.lastMsg, r.lastMsg[string(s.target.ID())])
+	r.finishSwitch(s, responded)
+}
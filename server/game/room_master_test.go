@@ -0,0 +1,268 @@
+package game
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+	"wsnet2/config"
+)
+
+// toMasterValues : evsのうちEvMessageの値. EvMessage以外はtypesに記録する.
+func toMasterValues(t *testing.T, evs []*binary.RegularEvent) (values []int, types []binary.EvType) {
+	t.Helper()
+	for _, ev := range evs {
+		if ev.Type() != binary.EvTypeMessage {
+			types = append(types, ev.Type())
+			continue
+		}
+		_, data, err := binary.UnmarshalEvMessage(ev.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvMessage: %v", err)
+		}
+		v, _, err := binary.UnmarshalAs(data, binary.TypeInt)
+		if err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		values = append(values, v.(int))
+	}
+	return values, types
+}
+
+func seqValues(from, to int) []int {
+	vs := make([]int, 0, to-from)
+	for i := from; i < to; i++ {
+		vs = append(vs, i)
+	}
+	return vs
+}
+
+// newSwitchRoom : Masterの移譲を受諾待ちにする部屋
+func newSwitchRoom(t *testing.T, players int) (*Room, []*Client, *common.FakeClock) {
+	r, clients := newRelayRoom(t, players, RoomRelayShards)
+	clock := common.NewFakeClock(time.Now())
+	r.clock = clock
+	r.conf = &config.GameConf{SwitchMasterTimeout: config.Duration(5 * time.Second)}
+	r.msgCh = make(chan Msg, RoomMsgChSize)
+	r.lastMsg = make(binary.Dict)
+	for _, c := range clients {
+		r.writeLastMsg(c.ID())
+	}
+	return r, clients, clock
+}
+
+// eventTypes : 前回以降にcが受け取ったイベントの種類
+func eventTypes(c *Client, seq *int) []binary.EvType {
+	evs, _ := c.evbuf.Read(*seq)
+	*seq += len(evs)
+	types := make([]binary.EvType, len(evs))
+	for i, ev := range evs {
+		types[i] = ev.Type()
+	}
+	return types
+}
+
+func TestSwitchMasterHandshake(t *testing.T) {
+	const (
+		accept = iota
+		respond
+		silent
+		leave
+	)
+	tests := map[string]struct {
+		finish     int
+		master     int
+		oldEvs     []binary.EvType
+		requestEvs []binary.EvType
+	}{
+		"accepted": {accept, 1,
+			[]binary.EvType{binary.EvTypeSucceeded, binary.EvTypeMasterSwitched},
+			[]binary.EvType{binary.EvTypeMasterSwitchRequested, binary.EvTypeMasterSwitched}},
+		"responding target": {respond, 1,
+			[]binary.EvType{binary.EvTypeSucceeded, binary.EvTypeMasterSwitched},
+			[]binary.EvType{binary.EvTypeMasterSwitchRequested, binary.EvTypeMasterSwitched}},
+		"unresponsive target": {silent, 0,
+			[]binary.EvType{binary.EvTypeTargetNotFound},
+			[]binary.EvType{binary.EvTypeMasterSwitchRequested}},
+		"target left": {leave, 0,
+			[]binary.EvType{binary.EvTypeTargetNotFound},
+			[]binary.EvType{binary.EvTypeMasterSwitchRequested}},
+	}
+	for name, tc := range tests {
+		r, clients, clock := newSwitchRoom(t, 3)
+		oldm, target, other := clients[0], clients[1], clients[2]
+		var oldSeq, targetSeq int
+
+		// Master以外からの移譲は拒否する
+		r.msgSwitchMaster(newTestMsg(t, other, binary.MsgTypeSwitchMaster, binary.MarshalSwitchMasterPayload(target.Id)).(*MsgSwitchMaster))
+		if types := eventTypes(other, new(int)); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+			t.Fatalf("%v: non-master events %v, wants [PermissionDenied]", name, types)
+		}
+		if r.switching != nil {
+			t.Fatalf("%v: switch started by non-master", name)
+		}
+
+		r.msgSwitchMaster(newTestMsg(t, oldm, binary.MsgTypeSwitchMaster, binary.MarshalSwitchMasterPayload(target.Id)).(*MsgSwitchMaster))
+		if r.master != oldm {
+			t.Fatalf("%v: master switched before accepted", name)
+		}
+		// 受諾待ちの間の移譲は拒否する
+		r.msgSwitchMaster(newTestMsg(t, oldm, binary.MsgTypeSwitchMaster, binary.MarshalSwitchMasterPayload(other.Id)).(*MsgSwitchMaster))
+		if types := eventTypes(oldm, &oldSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+			t.Fatalf("%v: second switch events %v, wants [PermissionDenied]", name, types)
+		}
+
+		switch tc.finish {
+		case accept:
+			r.msgAcceptMaster(&MsgAcceptMaster{Sender: target})
+		case respond:
+			clock.Advance(time.Second)
+			r.updateLastMsg(target.ID())
+			clock.Advance(4 * time.Second)
+		case silent:
+			clock.Advance(5 * time.Second)
+		case leave:
+			delete(r.players, target.ID())
+			r.cancelSwitch(target)
+		}
+		select {
+		case msg := <-r.msgCh:
+			r.dispatch(msg)
+		default:
+		}
+
+		if r.switching != nil {
+			t.Fatalf("%v: switch is still in progress", name)
+		}
+		if r.master != clients[tc.master] {
+			t.Fatalf("%v: master = %v, wants %v", name, r.master.Id, clients[tc.master].Id)
+		}
+		if types := eventTypes(oldm, &oldSeq); !reflect.DeepEqual(types, tc.oldEvs) {
+			t.Fatalf("%v: old master events %v, wants %v", name, types, tc.oldEvs)
+		}
+		if types := eventTypes(target, &targetSeq); !reflect.DeepEqual(types, tc.requestEvs) {
+			t.Fatalf("%v: target events %v, wants %v", name, types, tc.requestEvs)
+		}
+		if n := clock.Timers(); n != 0 {
+			t.Fatalf("%v: %v timers remain", name, n)
+		}
+	}
+}
+
+func TestToMasterAcrossSwitch(t *testing.T) {
+	r, clients, _ := newSwitchRoom(t, 3)
+	oldm, newm, sender := clients[0], clients[1], clients[2]
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		r.relay(&MsgToMaster{Sender: sender, Data: binary.MarshalInt(i)})
+	}
+
+	r.waitRelay()
+	r.msgSwitchMaster(newTestMsg(t, oldm, binary.MsgTypeSwitchMaster, binary.MarshalSwitchMasterPayload(newm.Id)).(*MsgSwitchMaster))
+
+	// 受諾までは旧Masterに届く
+	r.relay(&MsgToMaster{Sender: sender, Data: binary.MarshalInt(n)})
+	r.waitRelay()
+	r.msgAcceptMaster(&MsgAcceptMaster{Sender: newm})
+
+	for i := n + 1; i < 2*n; i++ {
+		r.relay(&MsgToMaster{Sender: sender, Data: binary.MarshalInt(i)})
+	}
+	r.waitRelay()
+
+	evs, _ := oldm.evbuf.Read(0)
+	values, types := toMasterValues(t, evs)
+	if !reflect.DeepEqual(values, seqValues(0, n+1)) {
+		t.Fatalf("old master received %v, wants %v", values, seqValues(0, n+1))
+	}
+	if wants := []binary.EvType{binary.EvTypeSucceeded, binary.EvTypeMasterSwitched}; !reflect.DeepEqual(types, wants) {
+		t.Fatalf("old master events %v, wants %v", types, wants)
+	}
+	if ev := evs[len(evs)-1]; ev.Type() != binary.EvTypeMasterSwitched {
+		t.Fatalf("old master last event %v, wants %v", ev.Type(), binary.EvTypeMasterSwitched)
+	}
+
+	evs, _ = newm.evbuf.Read(0)
+	if evs[0].Type() != binary.EvTypeMasterSwitchRequested {
+		t.Fatalf("new master first event %v, wants %v", evs[0].Type(), binary.EvTypeMasterSwitchRequested)
+	}
+	evs = evs[1:]
+	if evs[0].Type() != binary.EvTypeMasterSwitched {
+		t.Fatalf("new master first event %v, wants %v", evs[0].Type(), binary.EvTypeMasterSwitched)
+	}
+	values, _ = toMasterValues(t, evs)
+	if !reflect.DeepEqual(values, seqValues(n+1, 2*n)) {
+		t.Fatalf("new master received %v, wants %v", values, seqValues(n+1, 2*n))
+	}
+}
+
+func TestToMasterRedeliver(t *testing.T) {
+	const n = 1000
+	for _, handoverAt := range []int{0, 1, n / 2, n} {
+		r, clients := newRelayRoom(t, 3, RoomRelayShards)
+		oldm, newm, sender := clients[0], clients[1], clients[2]
+
+		// 旧MasterのPeer: 退室までに読み出したものは旧Masterに届いたとみなす
+		var oldEvs []*binary.RegularEvent
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				evs, _ := oldm.evbuf.Read(len(oldEvs))
+				oldEvs = append(oldEvs, evs...)
+				select {
+				case <-stop:
+					return
+				default:
+				}
+			}
+		}()
+
+		handover := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < n; i++ {
+				if i == handoverAt {
+					close(handover)
+				}
+				r.relay(&MsgToMaster{Sender: sender, Data: binary.MarshalInt(i)})
+			}
+			if handoverAt == n {
+				close(handover)
+			}
+			close(done)
+		}()
+
+		// 中継と並行して旧Masterを退室させる (removePlayerと同じ手順)
+		<-handover
+		r.muClients.Lock()
+		delete(r.players, oldm.ID())
+		r.toMaster.mu.Lock()
+		r.master = newm
+		r.publishClients()
+		r.redeliverToMaster(oldm)
+		r.toMaster.mu.Unlock()
+		r.muClients.Unlock()
+
+		<-done
+		r.waitRelay()
+		close(stop)
+		wg.Wait()
+
+		oldValues, _ := toMasterValues(t, oldEvs)
+		newEvs, _ := newm.evbuf.Read(0)
+		newValues, _ := toMasterValues(t, newEvs)
+		if got := append(oldValues, newValues...); !reflect.DeepEqual(got, seqValues(0, n)) {
+			t.Fatalf("handoverAt=%v: old master %v + new master %v, wants 0..%v", handoverAt, oldValues, newValues, n-1)
+		}
+		if rest, _ := oldm.evbuf.Read(len(oldEvs)); len(rest) != 0 {
+			t.Fatalf("handoverAt=%v: old master has %v withdrawn events", handoverAt, len(rest))
+		}
+	}
+}
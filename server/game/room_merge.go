@@ -0,0 +1,189 @@
+package game
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/auth"
+	"wsnet2/binary"
+)
+
+// 部屋の統合:
+// MasterのMsgMergeRoomか管理者のgRPC (MergeRoom) で、Playerの少ない部屋を同じgameサーバの別の部屋に統合する.
+// 統合先は同じappの同じSearchGroupの部屋で、統合元のPlayerの人数分の席をClientDeadlineの間確保する.
+// 確保できたら統合元のPlayerにEvRoomMergedでLobbyの招待トークンを送り、統合元の部屋を閉じる.
+// 統合の準備中に入室したPlayerの席は確保しないので、トークンは届かない.
+
+// startMerge : 統合先の部屋に席の確保を依頼する. 結果はMsgMergeReservedで届く.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) startMerge(req Msg, target string) error {
+	if target == r.Id {
+		return xerrors.Errorf("cannot merge into itself: %v", target)
+	}
+	if r.closing || r.merging || r.watchOnly {
+		return xerrors.Errorf("room is closing or merging: room=%v", r.Id)
+	}
+	clients := make([]ClientID, 0, len(r.players))
+	for id := range r.players {
+		clients = append(clients, id)
+	}
+	r.merging = true
+	expire := r.clock.Now().Add(r.deadline)
+	r.logger.Infof("merge room: %v -> %v players=%v", r.Id, target, len(clients))
+	go r.repo.reserveMergeSeats(r, req, target, r.SearchGroup, clients, expire)
+	return nil
+}
+
+// reserveMergeSeats : 統合先の部屋に席を確保し、結果をMsgMergeReservedで統合元の部屋に通知する.
+// 統合先の部屋の応答を待つので、統合元の部屋のMsgLoopとは別のgoroutineで呼ぶ.
+func (repo *Repository) reserveMergeSeats(r *Room, req Msg, targetID string, searchGroup uint32, clients []ClientID, expire time.Time) {
+	res := &MsgMergeReserved{Request: req, Target: targetID, Clients: clients, Expire: expire}
+	defer r.SendMessage(res)
+
+	target, err := repo.GetRoom(targetID)
+	if err != nil {
+		res.Err = xerrors.Errorf("target room not found: %w", err)
+		return
+	}
+	ch := make(chan error, 1)
+	target.SendMessage(&MsgReserveSeats{
+		Source:      r.Id,
+		SearchGroup: searchGroup,
+		Clients:     clients,
+		Expire:      expire,
+		Res:         ch,
+	})
+	select {
+	case res.Err = <-ch:
+	case <-target.Done():
+		res.Err = xerrors.Errorf("target room closed: %v", targetID)
+	}
+}
+
+// reservedSeats : 統合元の部屋のPlayerに確保している席の数. exceptの席は数えない.
+// 期限の過ぎた席は取り除く. muClients のロックを取得してから呼び出すこと
+func (r *Room) reservedSeats(except ClientID) uint32 {
+	now := r.clock.Now()
+	var n uint32
+	for id, expire := range r.reserved {
+		if !now.Before(expire) {
+			delete(r.reserved, id)
+			continue
+		}
+		if id != except {
+			n++
+		}
+	}
+	return n
+}
+
+// replyMerge : 統合の要求元に結果を返す.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) replyMerge(req Msg, err error) {
+	switch m := req.(type) {
+	case *MsgMergeRoom:
+		if r.players[m.SenderID()] != m.Sender {
+			return
+		}
+		if err != nil {
+			r.sendTo(m.Sender, binary.NewEvPermissionDenied(m))
+			return
+		}
+		r.sendTo(m.Sender, binary.NewEvSucceeded(m))
+	case *MsgAdminMerge:
+		m.Res <- err
+	}
+}
+
+func (r *Room) msgMergeRoom(msg *MsgMergeRoom) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if msg.Sender != r.master {
+		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.masterID())
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if err := r.startMerge(msg, msg.Target); err != nil {
+		msg.Sender.logger.Infof("merge room: %v", err)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+	}
+}
+
+func (r *Room) msgAdminMerge(msg *MsgAdminMerge) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if err := r.startMerge(msg, msg.Target); err != nil {
+		msg.Res <- err
+	}
+}
+
+// msgReserveSeats : 統合先の部屋で統合元のPlayerの席を確保する. 既に入室しているPlayerの席は確保しない.
+func (r *Room) msgReserveSeats(msg *MsgReserveSeats) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if r.closing || r.merging || r.watchOnly {
+		msg.Res <- xerrors.Errorf("target room is closing or merging: room=%v", r.Id)
+		return
+	}
+	if r.SearchGroup != msg.SearchGroup {
+		msg.Res <- xerrors.Errorf("search group mismatch: room=%v group=%v, source=%v group=%v",
+			r.Id, r.SearchGroup, msg.Source, msg.SearchGroup)
+		return
+	}
+	seats := make([]ClientID, 0, len(msg.Clients))
+	for _, id := range msg.Clients {
+		if _, ok := r.players[id]; !ok {
+			seats = append(seats, id)
+		}
+	}
+	if r.playerCount()+r.reservedSeats("")+uint32(len(seats)) > r.MaxPlayers {
+		msg.Res <- xerrors.Errorf("target room full: room=%v max=%v, seats=%v", r.Id, r.MaxPlayers, len(seats))
+		return
+	}
+	if r.reserved == nil {
+		r.reserved = make(map[ClientID]time.Time)
+	}
+	for _, id := range seats {
+		r.reserved[id] = msg.Expire
+	}
+	r.logger.Infof("seats reserved for merge: %v -> %v seats=%v", msg.Source, r.Id, len(seats))
+	msg.Res <- nil
+}
+
+// msgMergeReserved : 席を確保できたら統合元のPlayerに統合先の部屋への招待トークンを送り、部屋を閉じる.
+func (r *Room) msgMergeReserved(msg *MsgMergeReserved) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	r.merging = false
+	if msg.Err == nil && r.closing {
+		msg.Err = xerrors.Errorf("room closed while merging: room=%v", r.Id)
+	}
+	if msg.Err != nil {
+		r.logger.Infof("merge room: %v -> %v: %+v", r.Id, msg.Target, msg.Err)
+		r.replyMerge(msg.Request, msg.Err)
+		return
+	}
+
+	key := r.repo.app.GetKey()
+	for _, id := range msg.Clients {
+		c, ok := r.players[id]
+		if !ok {
+			continue
+		}
+		token, err := auth.GenerateInviteToken(key, msg.Target, string(id), msg.Expire)
+		if err != nil {
+			c.logger.Errorf("merge token: %+v", err)
+			continue
+		}
+		r.sendTo(c, binary.NewEvRoomMerged(msg.Target, token, uint64(msg.Expire.Unix())))
+	}
+	r.replyMerge(msg.Request, nil)
+
+	r.logger.Infof("room merged: %v -> %v", r.Id, msg.Target)
+	r.startClosing(binary.RoomClosedMerged)
+}
@@ -0,0 +1,106 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/auth"
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestMergeReserved(t *testing.T) {
+	r, clients, clock := newSwitchRoom(t, 2)
+	master, player := clients[0], clients[1]
+	r.repo = &Repository{app: &pb.App{Id: "app", Key: "appkey"}}
+	r.RoomInfo.Id = "room1"
+	r.deadline = 30 * time.Second
+	var masterSeq, playerSeq int
+
+	// Master以外は統合できない
+	r.dispatch(newTestMsg(t, player, binary.MsgTypeMergeRoom, binary.MarshalStr8("room2")))
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("player events %v, wants [PermissionDenied]", types)
+	}
+	// 自身には統合できない
+	r.dispatch(newTestMsg(t, master, binary.MsgTypeMergeRoom, binary.MarshalStr8("room1")))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("master events %v, wants [PermissionDenied]", types)
+	}
+
+	req := newTestMsg(t, master, binary.MsgTypeMergeRoom, binary.MarshalStr8("room2")).(*MsgMergeRoom)
+	expire := clock.Now().Add(r.deadline)
+	clientIDs := []ClientID{master.ID(), player.ID()}
+
+	// 席を確保できなければ部屋は閉じない
+	r.merging = true
+	r.msgMergeReserved(&MsgMergeReserved{Request: req, Target: "room2", Clients: clientIDs, Expire: expire, Err: xerrors.Errorf("room full")})
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("master events %v, wants [PermissionDenied]", types)
+	}
+	if r.merging || r.closing {
+		t.Fatalf("merging=%v closing=%v, wants false, false", r.merging, r.closing)
+	}
+
+	r.merging = true
+	r.msgMergeReserved(&MsgMergeReserved{Request: req, Target: "room2", Clients: clientIDs, Expire: expire})
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeRoomMerged, binary.EvTypeSucceeded, binary.EvTypeRoomClosed}) {
+		t.Fatalf("master events %v, wants [RoomMerged Succeeded RoomClosed]", types)
+	}
+	evs, _ := player.evbuf.Read(playerSeq)
+	if len(evs) != 2 || evs[0].Type() != binary.EvTypeRoomMerged || evs[1].Type() != binary.EvTypeRoomClosed {
+		t.Fatalf("player events %v, wants [RoomMerged RoomClosed]", evs)
+	}
+	p, err := binary.UnmarshalEvRoomSuccessorPayload(evs[0].Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvRoomSuccessorPayload: %v", err)
+	}
+	roomId, userId, err := auth.ValidInviteToken(p.Token, "appkey", clock.Now())
+	if err != nil || roomId != "room2" || userId != player.Id {
+		t.Fatalf("ValidInviteToken = (%q, %q, %v), wants (room2, %q, nil)", roomId, userId, err, player.Id)
+	}
+	if !r.closing {
+		t.Fatalf("merged room must be closing")
+	}
+}
+
+func TestReserveSeats(t *testing.T) {
+	r, clients, clock := newSwitchRoom(t, 2)
+	r.RoomInfo.MaxPlayers = 4
+	r.RoomInfo.SearchGroup = 1
+	expire := clock.Now().Add(10 * time.Second)
+
+	reserve := func(group uint32, ids ...ClientID) error {
+		ch := make(chan error, 1)
+		r.msgReserveSeats(&MsgReserveSeats{Source: "src", SearchGroup: group, Clients: ids, Expire: expire, Res: ch})
+		return <-ch
+	}
+
+	if err := reserve(2, "a"); err == nil {
+		t.Fatalf("search group mismatch must be an error")
+	}
+	// 入室済みのPlayerの席は確保しない
+	if err := reserve(1, clients[1].ID(), "a", "b"); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	if len(r.reserved) != 2 {
+		t.Fatalf("reserved = %v, wants a and b", r.reserved)
+	}
+	if err := reserve(1, "c"); err == nil {
+		t.Fatalf("reserve over max players must be an error")
+	}
+	if n := r.reservedSeats("a"); n != 1 {
+		t.Fatalf("reservedSeats(a) = %v, wants 1", n)
+	}
+
+	clock.Advance(10 * time.Second)
+	if n := r.reservedSeats(""); n != 0 || len(r.reserved) != 0 {
+		t.Fatalf("expired seats must be released: %v", r.reserved)
+	}
+	if err := reserve(1, "c"); err != nil {
+		t.Fatalf("reserve after expire: %v", err)
+	}
+}
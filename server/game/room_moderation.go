@@ -0,0 +1,82 @@
+package game
+
+import (
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/moderation"
+)
+
+// scan : 中継したメッセージを審査サービスに送る.
+// 中継した後に呼ぶので、審査を待たずにメッセージは届く. 審査結果は MsgModerationVerdict で部屋に反映する.
+// recipientsはメッセージを送った相手 (nilなら部屋全体).
+func (r *Room) scan(kind string, sender *Client, recipients []ClientID, ev *binary.RegularEvent, data []byte) {
+	if r.scanner == nil || !r.scanner.Selects(r.AppId, kind) {
+		return
+	}
+	req := &moderation.Request{
+		AppId:    r.AppId,
+		RoomId:   r.Id,
+		ClientId: sender.Id,
+		Kind:     kind,
+		Data:     data,
+	}
+	r.scanner.Scan(req, func(v *moderation.Verdict) {
+		r.SendMessage(&MsgModerationVerdict{
+			Sender:     sender,
+			Recipients: recipients,
+			Event:      ev,
+			Data:       data,
+			Verdict:    v,
+		})
+	})
+}
+
+// msgModerationVerdict : 審査結果を反映する.
+//   - Redact: 受信者にEvTypeRedactedを送り、履歴から除く. 古いプロトコルのクライアントには送らない.
+//   - Kick: 送信者を退室させる. BanDurationの間は再入室させない.
+func (r *Room) msgModerationVerdict(msg *MsgModerationVerdict) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	v := msg.Verdict
+	sender := msg.Sender
+	sender.logger.Infof("moderation verdict: redact=%v kick=%v ban=%v reason=%v", v.Redact, v.Kick, v.BanDuration, v.Reason)
+
+	if v.Redact {
+		r.history.redact(msg.Event)
+
+		ev := binary.NewEvRedacted(sender.Id, msg.Data)
+		send := func(c *Client) {
+			if c.canReceiveRedaction() {
+				r.sendTo(c, ev)
+			}
+		}
+		if msg.Recipients == nil {
+			for _, c := range r.players {
+				send(c)
+			}
+			for _, c := range r.watchers {
+				send(c)
+			}
+		} else {
+			for _, id := range msg.Recipients {
+				if c, ok := r.players[id]; ok {
+					send(c)
+				}
+			}
+		}
+	}
+
+	if v.Kick {
+		ban := time.Duration(v.BanDuration) * time.Second
+		if ban > 0 {
+			r.banned[sender.ID()] = r.clock.Now().Add(ban)
+		}
+		// 審査中に再入室していても同じクライアントIDなら退室させる
+		if target, ok := r.players[sender.ID()]; ok {
+			r.logger.Infof("kick by moderation: %v reason=%v ban=%v", target.Id, v.Reason, ban)
+			r.removePlayer(target, v.Message, &kickInfo{binary.KickReason(v.Reason), ban, false})
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package game
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/moderation"
+)
+
+type scanned struct {
+	req   *moderation.Request
+	apply moderation.VerdictFunc
+}
+
+// fakeScanner : kindsのメッセージを審査待ちとしてchに積む
+type fakeScanner struct {
+	kinds map[string]bool
+	ch    chan scanned
+}
+
+func (s *fakeScanner) Selects(appId, kind string) bool {
+	return s.kinds[kind]
+}
+
+func (s *fakeScanner) Scan(req *moderation.Request, apply moderation.VerdictFunc) {
+	s.ch <- scanned{req, apply}
+}
+
+func TestModerationVerdict(t *testing.T) {
+	r, clients, clock := newSwitchRoom(t, 3)
+	sender, capable, legacy := clients[0], clients[1], clients[2]
+	sender.peer = &Peer{closed: true, redaction: true}
+	capable.peer = &Peer{closed: true, redaction: true}
+	legacy.peer = &Peer{closed: true}
+	r.history = newMsgHistory(10)
+	r.banned = make(map[ClientID]time.Time)
+
+	s := &fakeScanner{kinds: map[string]bool{moderation.KindBroadcast: true}, ch: make(chan scanned, 10)}
+	r.scanner = s
+
+	data := binary.MarshalStr8("bad word")
+	r.relay(&MsgBroadcast{Sender: sender, Data: data})
+	r.relay(&MsgTargets{Sender: sender, Targets: []string{capable.Id}, Data: data})
+	r.waitRelay()
+
+	// 審査対象のBroadcastだけ審査し、審査を待たずに中継する
+	if n := len(s.ch); n != 1 {
+		t.Fatalf("scanned %v messages, wants 1", n)
+	}
+	sc := <-s.ch
+	if sc.req.Kind != moderation.KindBroadcast || sc.req.ClientId != sender.Id || !bytes.Equal(sc.req.Data, data) {
+		t.Fatalf("scan request = %+v", sc.req)
+	}
+	var capableSeq, legacySeq int
+	if types := eventTypes(capable, &capableSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeMessage, binary.EvTypeMessage}) {
+		t.Fatalf("capable events %v, wants 2 messages", types)
+	}
+	eventTypes(legacy, &legacySeq)
+
+	sc.apply(&moderation.Verdict{Redact: true})
+	r.dispatch(<-r.msgCh)
+
+	if types := eventTypes(capable, &capableSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeRedacted}) {
+		t.Fatalf("capable events %v, wants [Redacted]", types)
+	}
+	if types := eventTypes(legacy, &legacySeq); len(types) != 0 {
+		t.Fatalf("legacy events %v, wants none", types)
+	}
+	if evs := r.history.before(r.history.total(), 0); len(evs) != 0 {
+		t.Fatalf("history has %v events after redaction", len(evs))
+	}
+
+	// 退室済みでもbanする
+	delete(r.players, sender.ID())
+	sc.apply(&moderation.Verdict{Kick: true, BanDuration: 60})
+	r.dispatch(<-r.msgCh)
+	if until, ok := r.banned[sender.ID()]; !ok || !until.After(clock.Now()) {
+		t.Fatalf("sender is not banned: %v", r.banned)
+	}
+}
@@ -0,0 +1,57 @@
+package game
+
+import (
+	"math/rand"
+	"time"
+)
+
+// 通信状況の模擬 (開発用):
+// 管理者が部屋毎に送信の遅延、揺らぎ、欠落を設定し、ゲームの予測やロールバックを実際のwsnet2サーバで試せるようにする.
+// 遅延はClientのEventLoopがPeerに送信を依頼する前に入れる. 遅延中に溜まったイベントはまとめて送る.
+// 欠落したイベントは送ったことにして捨てる. クライアントは通し番号の欠落を検出して再接続し、再送を受け取る.
+// gameサーバの debug_net_sim を有効にしたときだけ設定できる.
+
+// NetSim : 部屋のイベントの送信に加える遅延と欠落
+type NetSim struct {
+	Latency  time.Duration // 送信の遅延
+	Jitter   time.Duration // 遅延の揺らぎ. Latency±Jitterの一様分布
+	DropRate float64       // イベントを捨てる確率 (0〜1)
+}
+
+// enabled : 何か模擬するか
+func (s *NetSim) enabled() bool {
+	return s.Latency > 0 || s.Jitter > 0 || s.DropRate > 0
+}
+
+// delay : 送信前に待つ時間
+func (s *NetSim) delay() time.Duration {
+	d := s.Latency
+	if s.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(s.Jitter)*2+1)) - s.Jitter
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// drop : イベントを捨てるか
+func (s *NetSim) drop() bool {
+	return s.DropRate > 0 && rand.Float64() < s.DropRate
+}
+
+// NetSim : 部屋の通信状況の模擬 (IRoom実装). nilなら模擬しない
+func (r *Room) NetSim() *NetSim {
+	return r.netSim.Load()
+}
+
+// setNetSim : 通信状況の模擬を設定する. 何も模擬しなければ解除する
+func (r *Room) setNetSim(s *NetSim) {
+	if s == nil || !s.enabled() {
+		r.netSim.Store(nil)
+		r.logger.Infof("net sim disabled")
+		return
+	}
+	r.netSim.Store(s)
+	r.logger.Infof("net sim enabled: latency=%v jitter=%v drop=%v", s.Latency, s.Jitter, s.DropRate)
+}
@@ -0,0 +1,33 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNetSim(t *testing.T) {
+	if (&NetSim{}).enabled() {
+		t.Fatalf("zero NetSim must be disabled")
+	}
+
+	s := &NetSim{Latency: 100 * time.Millisecond, Jitter: 30 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		if d := s.delay(); d < 70*time.Millisecond || d > 130*time.Millisecond {
+			t.Fatalf("delay = %v, wants 70ms..130ms", d)
+		}
+	}
+	s = &NetSim{Latency: 10 * time.Millisecond, Jitter: 50 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		if d := s.delay(); d < 0 || d > 60*time.Millisecond {
+			t.Fatalf("delay = %v, wants 0..60ms", d)
+		}
+	}
+
+	if s.drop() {
+		t.Fatalf("drop must be false when DropRate is 0")
+	}
+	s = &NetSim{DropRate: 1}
+	if !s.enabled() || !s.drop() {
+		t.Fatalf("drop must be true when DropRate is 1")
+	}
+}
@@ -0,0 +1,117 @@
+package game
+
+import (
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+)
+
+// roomPause : 部屋の一時停止.
+//
+// Masterが MsgPauseRoom で一時停止すると、再開するまでクライアントのタイムアウトと投票の締め切りを止める.
+// メッセージの中継やPing、切断したクライアントの再接続はそのまま受け付ける.
+// 一時停止中にMasterが退室しても停止したままで、新しいMasterが再開できる.
+// クライアントがタイムアウトせず部屋が残り続けないよう、MaxPauseDurationを過ぎたら再開する.
+type roomPause struct {
+	by    ClientID
+	since time.Time
+	timer common.Timer
+}
+
+// notifyPaused : 一時停止中に入室したクライアントもタイムアウトさせず、停止中であることを通知する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) notifyPaused(c *Client) {
+	if r.paused == nil {
+		return
+	}
+	c.setPaused(true)
+	r.sendTo(c, binary.NewEvRoomPaused(string(r.paused.by)))
+}
+
+// setClientsPaused : 全クライアントのタイムアウトを止める/再開する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) setClientsPaused(paused bool) {
+	for _, c := range r.players {
+		c.setPaused(paused)
+	}
+	for _, c := range r.watchers {
+		c.setPaused(paused)
+	}
+}
+
+// pauseRoom : 部屋を一時停止する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) pauseRoom(by *Client) {
+	p := &roomPause{
+		by:    by.ID(),
+		since: r.clock.Now(),
+	}
+	p.timer = r.clock.AfterFunc(time.Duration(r.conf.MaxPauseDuration), func() {
+		r.SendMessage(&MsgPauseTimeout{p})
+	})
+	r.paused = p
+
+	r.setClientsPaused(true)
+	for _, v := range r.votes {
+		r.pauseVoteTimer(v)
+	}
+
+	r.logger.Infof("room paused by %v", by.Id)
+	r.broadcast(binary.NewEvRoomPaused(by.Id))
+}
+
+// resumeRoom : 一時停止を解除する. byは解除したクライアント (上限時間切れのときは空文字列).
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) resumeRoom(by string) {
+	p := r.paused
+	p.timer.Stop()
+	r.paused = nil
+
+	r.setClientsPaused(false)
+	for _, v := range r.votes {
+		r.startVoteTimer(v, v.remain)
+	}
+
+	d := r.clock.Now().Sub(p.since)
+	r.logger.Infof("room resumed by %q: paused %v", by, d)
+	r.broadcast(binary.NewEvRoomResumed(by, uint32(d/time.Millisecond)))
+}
+
+func (r *Room) msgPauseRoom(msg *MsgPauseRoom) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if msg.Sender != r.master {
+		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.master.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if msg.Pause && r.conf.MaxPauseDuration <= 0 {
+		msg.Sender.logger.Warnf("pausing room is disabled")
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	// 既に一時停止中(再開済み)なら何もしない
+	if msg.Pause != (r.paused != nil) {
+		if msg.Pause {
+			r.pauseRoom(msg.Sender)
+		} else {
+			r.resumeRoom(msg.Sender.Id)
+		}
+	}
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+}
+
+func (r *Room) msgPauseTimeout(msg *MsgPauseTimeout) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	// 再開済みの一時停止は無視する
+	if r.paused != msg.Pause {
+		return
+	}
+	r.logger.Infof("pause timeout: %v", time.Duration(r.conf.MaxPauseDuration))
+	r.resumeRoom("")
+}
@@ -0,0 +1,79 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/config"
+)
+
+func TestPauseRoom(t *testing.T) {
+	r, clients, clock := newSwitchRoom(t, 2)
+	master, player := clients[0], clients[1]
+	r.conf = &config.GameConf{MaxPauseDuration: config.Duration(time.Minute)}
+	r.votes = make(map[string]*vote)
+
+	v := &vote{id: "v", options: []string{"a", "b"}, votes: make(map[ClientID]int)}
+	r.startVoteTimer(v, 10*time.Second)
+	r.votes[v.id] = v
+
+	var masterSeq, playerSeq int
+
+	// Master以外は一時停止できない
+	r.msgPauseRoom(newTestMsg(t, player, binary.MsgTypePauseRoom, binary.MarshalPauseRoomPayload(true)).(*MsgPauseRoom))
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("player events %v, wants [PermissionDenied]", types)
+	}
+
+	r.msgPauseRoom(newTestMsg(t, master, binary.MsgTypePauseRoom, binary.MarshalPauseRoomPayload(true)).(*MsgPauseRoom))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeRoomPaused, binary.EvTypeSucceeded}) {
+		t.Fatalf("master events %v, wants [RoomPaused Succeeded]", types)
+	}
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeRoomPaused}) {
+		t.Fatalf("player events %v, wants [RoomPaused]", types)
+	}
+	if !player.paused.Load() {
+		t.Fatalf("player must be paused")
+	}
+
+	// 一時停止中は投票を締め切らない
+	clock.Advance(30 * time.Second)
+	if len(r.msgCh) != 0 {
+		t.Fatalf("unexpected msg while paused: %T", <-r.msgCh)
+	}
+
+	r.msgPauseRoom(newTestMsg(t, master, binary.MsgTypePauseRoom, binary.MarshalPauseRoomPayload(false)).(*MsgPauseRoom))
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeRoomResumed}) {
+		t.Fatalf("player events %v, wants [RoomResumed]", types)
+	}
+	evs, _ := player.evbuf.Read(playerSeq - 1)
+	p, err := binary.UnmarshalEvRoomResumedPayload(evs[0].Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvRoomResumedPayload: %v", err)
+	}
+	if want := (binary.EvRoomResumedPayload{ClientId: master.Id, PausedMilli: 30000}); *p != want {
+		t.Fatalf("resumed = %+v, wants %+v", *p, want)
+	}
+	if player.paused.Load() {
+		t.Fatalf("player must be resumed")
+	}
+
+	// 再開すると残り時間で締め切る
+	clock.Advance(10 * time.Second)
+	if m, ok := (<-r.msgCh).(*MsgVoteTimeout); !ok || m.Vote != v {
+		t.Fatalf("vote timeout is not fired: %T", m)
+	}
+
+	// 上限時間を過ぎたら再開する
+	r.msgPauseRoom(newTestMsg(t, master, binary.MsgTypePauseRoom, binary.MarshalPauseRoomPayload(true)).(*MsgPauseRoom))
+	clock.Advance(time.Minute)
+	r.dispatch(<-r.msgCh)
+	if r.paused != nil {
+		t.Fatalf("room must be resumed after MaxPauseDuration")
+	}
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeRoomPaused, binary.EvTypeRoomResumed}) {
+		t.Fatalf("player events %v, wants [RoomPaused RoomResumed]", types)
+	}
+}
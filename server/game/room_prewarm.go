@@ -0,0 +1,230 @@
+package game
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+
+	"wsnet2/log"
+	"wsnet2/pb"
+)
+
+// 部屋の事前確保:
+// マッチングで部屋の作成が集中したときのために、app毎にprewarm_rooms個の部屋IDと部屋番号を確保し、roomテーブルに行を作っておく.
+// CreateRoomは確保済みの行をRoomOptionの内容で更新するだけで済み、部屋番号の採番とID・番号の重複によるリトライを省ける.
+// 確保済みの行はvisible, joinable, watchableが0なのでlobbyの検索や入室の対象にならない.
+// WithNumberでない部屋に使うと、確保した部屋番号は解放される.
+// 使った分はバックグラウンドで補充する.
+// 部屋のgoroutineはRoomOptionとmasterが決まるまで作れないので、事前には起動しない.
+
+const (
+	// prewarmRetryInterval : 確保に失敗したときに再試行する間隔
+	prewarmRetryInterval = 10 * time.Second
+	prewarmTimeout       = 5 * time.Second
+)
+
+// prewarmedRoom : 確保済みの部屋IDと部屋番号
+type prewarmedRoom struct {
+	id     string
+	number int32
+}
+
+type roomPool struct {
+	repo *Repository
+	size int
+
+	mu     sync.Mutex
+	rooms  []prewarmedRoom
+	closed bool
+
+	refill chan struct{}
+}
+
+func newRoomPool(repo *Repository, size int) *roomPool {
+	return &roomPool{
+		repo:   repo,
+		size:   size,
+		rooms:  make([]prewarmedRoom, 0, size),
+		refill: make(chan struct{}, 1),
+	}
+}
+
+func (p *roomPool) run() {
+	t := time.NewTicker(prewarmRetryInterval)
+	defer t.Stop()
+	for p.fill() {
+		select {
+		case <-p.refill:
+		case <-t.C:
+		}
+	}
+}
+
+// fill : sizeになるまで部屋を確保する. closeされていたらfalseを返す
+func (p *roomPool) fill() bool {
+	for {
+		p.mu.Lock()
+		closed, n := p.closed, len(p.rooms)
+		p.mu.Unlock()
+		if closed {
+			return false
+		}
+		if n >= p.size {
+			return true
+		}
+
+		r, err := p.reserve()
+		if err != nil {
+			log.Errorf("prewarm room: app=%v: %+v", p.repo.app.Id, err)
+			return true
+		}
+		if !p.put(r) {
+			return false
+		}
+	}
+}
+
+// reserve : 部屋IDと部屋番号を確保してroomテーブルに行を作る
+func (p *roomPool) reserve() (prewarmedRoom, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), prewarmTimeout)
+	defer cancel()
+
+	ri := &pb.RoomInfo{
+		AppId:  p.repo.app.Id,
+		HostId: p.repo.hostId,
+		Number: &pb.RoomNumber{},
+	}
+	ri.SetCreated(time.Now())
+
+	var err error
+	for n := 0; n < p.repo.conf.RetryCount; n++ {
+		ri.Id = RandomHex(lenId)
+		ri.Number.Number, err = p.repo.nextRoomNumber(ctx)
+		if err != nil {
+			return prewarmedRoom{}, xerrors.Errorf("nextRoomNumber: %w", err)
+		}
+		_, err = p.repo.db.NamedExecContext(ctx, roomInsertQuery, ri)
+		if err == nil {
+			return prewarmedRoom{id: ri.Id, number: ri.Number.Number}, nil
+		}
+	}
+	return prewarmedRoom{}, xerrors.Errorf("try %d times: %w", p.repo.conf.RetryCount, err)
+}
+
+// take : 確保済みの部屋を1つ取り出し、補充を依頼する
+func (p *roomPool) take() (prewarmedRoom, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.rooms) == 0 {
+		return prewarmedRoom{}, false
+	}
+	r := p.rooms[len(p.rooms)-1]
+	p.rooms = p.rooms[:len(p.rooms)-1]
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+	return r, true
+}
+
+// put : 確保済みの部屋を加える. closeされていたら行を消してfalseを返す
+func (p *roomPool) put(r prewarmedRoom) bool {
+	p.mu.Lock()
+	if !p.closed {
+		p.rooms = append(p.rooms, r)
+		p.mu.Unlock()
+		return true
+	}
+	p.mu.Unlock()
+
+	if _, err := p.repo.db.Exec("DELETE FROM room WHERE id=?", r.id); err != nil {
+		log.Errorf("delete prewarmed room (%v): %+v", r.id, err)
+	}
+	return false
+}
+
+// has : 確保済みの部屋の行か
+func (p *roomPool) has(roomId string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range p.rooms {
+		if r.id == roomId {
+			return true
+		}
+	}
+	return false
+}
+
+// close : 補充を止めて確保済みの部屋の行を消す
+func (p *roomPool) close() error {
+	p.mu.Lock()
+	rooms := p.rooms
+	p.rooms = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+	if len(rooms) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(rooms))
+	for _, r := range rooms {
+		ids = append(ids, r.id)
+	}
+	q, args, err := sqlx.In("DELETE FROM room WHERE id IN (?)", ids)
+	if err != nil {
+		return xerrors.Errorf("sqlx.In: %w", err)
+	}
+	if _, err := p.repo.db.Exec(q, args...); err != nil {
+		return xerrors.Errorf("delete prewarmed rooms: %w", err)
+	}
+	return nil
+}
+
+// prewarmedRoomInfo : 確保済みの部屋の行をopの内容で更新して使う.
+// 確保済みの部屋が無いか、行が消されていたらnilを返すので、newRoomInfoで作る.
+func (repo *Repository) prewarmedRoomInfo(ctx context.Context, tx *sqlx.Tx, op *pb.RoomOption, players uint32) (*pb.RoomInfo, *prewarmedRoom) {
+	if repo.pool == nil {
+		return nil, nil
+	}
+	r, ok := repo.pool.take()
+	if !ok {
+		return nil, nil
+	}
+
+	ri := repo.roomInfoFromOption(op, players)
+	ri.Id = r.id
+	if op.WithNumber {
+		ri.Number.Number = r.number
+	}
+	res, err := tx.NamedExecContext(ctx, roomUpdateQuery, ri)
+	if err != nil {
+		log.Errorf("use prewarmed room (%v): %+v", r.id, err)
+		return nil, nil
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		// cleanupなどで行が消されていた
+		log.Infof("prewarmed room is not found: %v", r.id)
+		return nil, nil
+	}
+	return ri, &r
+}
+
+// IsPrewarmed : roomIdが事前確保した部屋の行か
+func (repo *Repository) IsPrewarmed(roomId string) bool {
+	return repo.pool != nil && repo.pool.has(roomId)
+}
+
+// ReleasePrewarmed : 事前確保した部屋の行を消して、以降は事前確保しない. graceful shutdownで呼ぶ
+func (repo *Repository) ReleasePrewarmed() error {
+	if repo.pool == nil {
+		return nil
+	}
+	return repo.pool.close()
+}
@@ -0,0 +1,107 @@
+package game
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/xerrors"
+
+	"wsnet2/config"
+	"wsnet2/log"
+	"wsnet2/pb"
+)
+
+func TestRoomPool(t *testing.T) {
+	defer log.SetLevel(log.SetLevel(log.NOLOG))
+
+	ctx := context.Background()
+	db, mock := newDbMock(t)
+	repo := &Repository{
+		app:    &pb.App{Id: "testing"},
+		hostId: 1,
+		conf: &config.GameConf{
+			RetryCount: 3,
+			MaxRoomNum: 999,
+		},
+		db: db,
+	}
+	p := newRoomPool(repo, 2)
+	repo.pool = p
+
+	insQuery := "INSERT INTO room "
+	mock.ExpectExec(insQuery).WillReturnError(xerrors.Errorf("Duplicate entry"))
+	mock.ExpectExec(insQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(insQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+	if !p.fill() {
+		t.Fatalf("fill must return true")
+	}
+	if len(p.rooms) != 2 {
+		t.Fatalf("len(rooms) = %v, wants 2", len(p.rooms))
+	}
+	r1, r2 := p.rooms[0], p.rooms[1]
+	if !repo.IsPrewarmed(r1.id) || !repo.IsPrewarmed(r2.id) || repo.IsPrewarmed("other") {
+		t.Fatalf("IsPrewarmed: %v", p.rooms)
+	}
+
+	// 確保済みの行を使う
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE room SET ")).WillReturnResult(sqlmock.NewResult(0, 1))
+	tx, _ := db.Beginx()
+	op := &pb.RoomOption{Visible: true, Joinable: true, WithNumber: true, MaxPlayers: 4}
+	ri, used := repo.prewarmedRoomInfo(ctx, tx, op, 1)
+	if ri == nil || used == nil {
+		t.Fatalf("prewarmedRoomInfo must use prewarmed room")
+	}
+	if ri.Id != r2.id || ri.Number.Number != r2.number {
+		t.Fatalf("room = %v/%v, wants %v/%v", ri.Id, ri.Number.Number, r2.id, r2.number)
+	}
+	if !ri.Visible || !ri.Joinable || ri.MaxPlayers != 4 || ri.Players != 1 {
+		t.Fatalf("room info is not from option: %v", ri)
+	}
+	if repo.IsPrewarmed(r2.id) {
+		t.Fatalf("used room must be removed from the pool")
+	}
+	select {
+	case <-p.refill:
+	default:
+		t.Fatalf("refill must be requested")
+	}
+
+	// 行が消されていたら使わない. WithNumberでなければ部屋番号は使わない
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE room SET ")).WillReturnResult(sqlmock.NewResult(0, 0))
+	ri, used = repo.prewarmedRoomInfo(ctx, tx, &pb.RoomOption{}, 1)
+	if ri != nil || used != nil {
+		t.Fatalf("prewarmedRoomInfo must not use deleted room: %v", ri)
+	}
+	ri, used = repo.prewarmedRoomInfo(ctx, tx, &pb.RoomOption{}, 1)
+	if ri != nil || used != nil {
+		t.Fatalf("prewarmedRoomInfo must return nil for empty pool: %v", ri)
+	}
+
+	// rollbackしたら戻す
+	if !p.put(r1) {
+		t.Fatalf("put must return true")
+	}
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM room WHERE id IN (?)")).
+		WithArgs(r1.id).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := repo.ReleasePrewarmed(); err != nil {
+		t.Fatalf("ReleasePrewarmed: %+v", err)
+	}
+	if p.fill() {
+		t.Fatalf("fill must return false after close")
+	}
+	if _, ok := p.take(); ok {
+		t.Fatalf("take must fail after close")
+	}
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM room WHERE id=?")).
+		WithArgs(r2.id).WillReturnResult(sqlmock.NewResult(0, 1))
+	if p.put(r2) {
+		t.Fatalf("put must return false after close")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
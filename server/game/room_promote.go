@@ -0,0 +1,162 @@
+package game
+
+import (
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+)
+
+// 観戦者のPlayerへの昇格.
+//
+// Masterが MsgPromoteWatcher で指定した観戦者を、再接続させずにPlayerにする.
+// 観戦者が自身のIDで MsgPromoteWatcher を送ると昇格の申請になり、Masterに EvTypePromoteRequested が届く.
+// RoomOptionの auto_promote_watchers を指定した部屋では、申請した観戦者を席が空き次第申請順に昇格する.
+//
+// Hub経由の観戦者はgameサーバに接続していないので昇格できない.
+// 申請はHubから転送されてMasterに届き、承認するとHubから EvTypePromoteRedirect が届くので、Playerとして入室し直す.
+
+// promote : 観戦者cをPlayerにする. 昇格できないときはエラーを返す.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) promote(c *Client) error {
+	id := c.ID()
+	if r.watchers[id] != c || c.IsHub {
+		return xerrors.Errorf("not a watcher: %v", id)
+	}
+	if until, ok := r.banned[id]; ok && r.clock.Now().Before(until) {
+		return xerrors.Errorf("banned until %v: %v", until, id)
+	}
+	// 同じクライアントIDのボットが居れば席を引き継ぐ. see: room_bot.go
+	_, overBot := r.bots[id]
+	if !overBot && r.MaxPlayers <= r.playerCount()+r.reservedSeats(id) {
+		return xerrors.Errorf("room full: max=%v", r.MaxPlayers)
+	}
+
+	// 観戦者として遅延していたイベントを先に送り、以降はPlayerとして遅延なく送る
+	r.flushDelayed(c)
+
+	delete(r.watchers, id)
+	r.RoomInfo.Watchers -= c.nodeCount
+	c.isPlayer.Store(true)
+	r.players[id] = c
+	r.updatePropsBytes()
+	r.startPlayerStats(c)
+	delete(r.reserved, id)
+	r.masterOrder = append(r.masterOrder, id)
+	r.applyInheritedRoles(id)
+	if overBot {
+		r.takeOverBot(id)
+	}
+	r.repo.PlayerLog(c, PlayerLogJoin)
+	c.logger.Infof("watcher promoted: %v", id)
+
+	r.RoomInfo.Players = r.playerCount()
+	r.updateRoomInfo()
+	r.toMaster.mu.Lock()
+	r.publishClients()
+	r.toMaster.mu.Unlock()
+
+	cinfo := c.ClientInfo.Clone()
+	if overBot {
+		r.broadcast(binary.NewEvRejoined(cinfo))
+	} else {
+		r.broadcast(binary.NewEvJoined(cinfo))
+	}
+	r.writeLastMsg(id)
+	return nil
+}
+
+// redirectHubWatcher : Hub経由の観戦者にPlayerとして入室し直すよう伝える.
+// どのHubに居るか分からないので全てのHubに送る. Hubが無ければfalseを返す.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) redirectHubWatcher(id ClientID) bool {
+	ev := binary.NewEvPromoteRedirect(string(id))
+	sent := false
+	for _, c := range r.watchers {
+		if c.IsHub {
+			r.sendNow(c, ev)
+			sent = true
+		}
+	}
+	return sent
+}
+
+// autoPromote : 席が空いていれば申請した観戦者を申請順に昇格する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) autoPromote() {
+	for len(r.promoteQueue) > 0 && r.Joinable && !r.closing && r.MaxPlayers > r.playerCount()+r.reservedSeats("") {
+		id := r.promoteQueue[0]
+		r.promoteQueue = r.promoteQueue[1:]
+		c, ok := r.watchers[id]
+		if !ok {
+			continue
+		}
+		if err := r.promote(c); err != nil {
+			c.logger.Infof("auto promote: %v", err)
+		}
+	}
+}
+
+// requestPromote : 観戦者からの昇格の申請.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) requestPromote(msg *MsgPromoteWatcher) {
+	sender := msg.Sender
+	// Hub経由の観戦者の申請は自動では昇格できないので常にMasterに送る
+	if !sender.IsHub && msg.Watcher != sender.ID() {
+		sender.logger.Warnf("watcher %q requests promotion of %q", sender.Id, msg.Watcher)
+		r.sendNow(sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if r.autoPromoteWatchers && !sender.IsHub {
+		for _, id := range r.promoteQueue {
+			if id == sender.ID() {
+				r.sendNow(sender, binary.NewEvSucceeded(msg))
+				return
+			}
+		}
+		sender.logger.Infof("promotion requested: %v", sender.Id)
+		r.promoteQueue = append(r.promoteQueue, sender.ID())
+		r.sendNow(sender, binary.NewEvSucceeded(msg))
+		r.autoPromote()
+		return
+	}
+	if r.master == nil {
+		r.sendNow(sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	sender.logger.Infof("promotion requested: %v", msg.Watcher)
+	r.sendTo(r.master, binary.NewEvPromoteRequested(string(msg.Watcher)))
+	r.sendNow(sender, binary.NewEvSucceeded(msg))
+}
+
+func (r *Room) msgPromoteWatcher(msg *MsgPromoteWatcher) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if !msg.Sender.isPlayer.Load() {
+		r.requestPromote(msg)
+		return
+	}
+	if msg.Sender != r.master {
+		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.master.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	c, ok := r.watchers[msg.Watcher]
+	if !ok {
+		if _, isPlayer := r.players[msg.Watcher]; !isPlayer && r.redirectHubWatcher(msg.Watcher) {
+			r.logger.Infof("promotion redirected by %v: %v", msg.Sender.Id, msg.Watcher)
+			r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+			return
+		}
+		msg.Sender.logger.Infof("watcher not found: %v", msg.Watcher)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{string(msg.Watcher)}))
+		return
+	}
+	if err := r.promote(c); err != nil {
+		msg.Sender.logger.Infof("promote watcher: %v", err)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+}
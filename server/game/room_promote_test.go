@@ -0,0 +1,153 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+	"wsnet2/pb"
+)
+
+func newPromoteRoom(t *testing.T, players int) (*Room, []*Client, *Client, *common.FakeClock) {
+	t.Helper()
+	r, clients, clock := newSwitchRoom(t, players)
+	r.repo = &Repository{}
+	r.RoomInfo.Joinable = true
+	r.RoomInfo.MaxPlayers = uint32(players + 1)
+	for _, c := range clients {
+		c.removed = make(chan struct{})
+	}
+	w := &Client{
+		ClientInfo: &pb.ClientInfo{Id: "watcher"},
+		evbuf:      common.NewRingBuf[*binary.RegularEvent](1024),
+		logger:     r.logger,
+		nodeCount:  1,
+	}
+	r.watchers[w.ID()] = w
+	r.RoomInfo.Watchers = 1
+	r.publishClients()
+	return r, clients, w, clock
+}
+
+func TestPromoteWatcher(t *testing.T) {
+	r, clients, w, _ := newPromoteRoom(t, 2)
+	master, player := clients[0], clients[1]
+	var masterSeq, playerSeq, watcherSeq int
+
+	// 観戦者が自身のIDで送ると申請になる
+	r.dispatch(newTestMsg(t, w, binary.MsgTypePromoteWatcher, binary.MarshalStr8(w.Id)))
+	if types := eventTypes(w, &watcherSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeSucceeded}) {
+		t.Fatalf("watcher events %v, wants [Succeeded]", types)
+	}
+	evs, _ := master.evbuf.Read(masterSeq)
+	masterSeq += len(evs)
+	if len(evs) != 1 || evs[0].Type() != binary.EvTypePromoteRequested {
+		t.Fatalf("master events %v, wants [PromoteRequested]", evs)
+	}
+	if id, _ := binary.UnmarshalEvPromotePayload(evs[0].Payload()); id != w.Id {
+		t.Fatalf("requested = %q, wants %q", id, w.Id)
+	}
+
+	// 他の観戦者の申請はできない
+	r.dispatch(newTestMsg(t, w, binary.MsgTypePromoteWatcher, binary.MarshalStr8(player.Id)))
+	if types := eventTypes(w, &watcherSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("watcher events %v, wants [PermissionDenied]", types)
+	}
+
+	// Master以外のPlayerは昇格できない
+	r.dispatch(newTestMsg(t, player, binary.MsgTypePromoteWatcher, binary.MarshalStr8(w.Id)))
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("player events %v, wants [PermissionDenied]", types)
+	}
+
+	r.dispatch(newTestMsg(t, master, binary.MsgTypePromoteWatcher, binary.MarshalStr8("nobody")))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeTargetNotFound}) {
+		t.Fatalf("master events %v, wants [TargetNotFound]", types)
+	}
+
+	// 観戦者として遅延中のイベントは昇格時に先に届く
+	r.watcherDelay = time.Second
+	r.sendTo(w, binary.NewEvMessage(master.Id, binary.MarshalStr8("delayed")))
+	if types := eventTypes(w, &watcherSeq); len(types) != 0 {
+		t.Fatalf("watcher events %v, wants none before delay", types)
+	}
+
+	r.dispatch(newTestMsg(t, master, binary.MsgTypePromoteWatcher, binary.MarshalStr8(w.Id)))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeJoined, binary.EvTypeSucceeded}) {
+		t.Fatalf("master events %v, wants [Joined Succeeded]", types)
+	}
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeJoined}) {
+		t.Fatalf("player events %v, wants [Joined]", types)
+	}
+	if types := eventTypes(w, &watcherSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeMessage, binary.EvTypeJoined}) {
+		t.Fatalf("promoted events %v, wants [Message Joined]", types)
+	}
+	if !w.isPlayer.Load() || r.players[w.ID()] != w || r.watchers[w.ID()] != nil {
+		t.Fatalf("watcher must be promoted: players=%v watchers=%v", r.players, r.watchers)
+	}
+	if r.RoomInfo.Players != 3 || r.RoomInfo.Watchers != 0 {
+		t.Fatalf("players=%v watchers=%v, wants 3, 0", r.RoomInfo.Players, r.RoomInfo.Watchers)
+	}
+	if len(r.delayQueue) != 0 {
+		t.Fatalf("delayQueue = %v, wants empty", r.delayQueue)
+	}
+	if _, ok := r.lastMsgTime(w.ID()); !ok {
+		t.Fatalf("lastMsg of promoted player must be set")
+	}
+}
+
+func TestPromoteWatcherRoomFull(t *testing.T) {
+	r, clients, w, _ := newPromoteRoom(t, 2)
+	master := clients[0]
+	r.RoomInfo.MaxPlayers = 2
+	var masterSeq int
+
+	r.dispatch(newTestMsg(t, master, binary.MsgTypePromoteWatcher, binary.MarshalStr8(w.Id)))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("master events %v, wants [PermissionDenied]", types)
+	}
+
+	// 同じIDのボットが居れば席を引き継ぐ
+	r.bots = map[ClientID]*pb.ClientInfo{w.ID(): {Id: w.Id, Bot: true}}
+	r.RoomInfo.MaxPlayers = 3
+	r.dispatch(newTestMsg(t, master, binary.MsgTypePromoteWatcher, binary.MarshalStr8(w.Id)))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeRejoined, binary.EvTypeSucceeded}) {
+		t.Fatalf("master events %v, wants [Rejoined Succeeded]", types)
+	}
+	if len(r.bots) != 0 || r.RoomInfo.Players != 3 {
+		t.Fatalf("bots=%v players=%v", r.bots, r.RoomInfo.Players)
+	}
+}
+
+func TestAutoPromoteWatchers(t *testing.T) {
+	r, clients, w, _ := newPromoteRoom(t, 2)
+	master, player := clients[0], clients[1]
+	r.RoomInfo.MaxPlayers = 2
+	r.autoPromoteWatchers = true
+	var masterSeq, watcherSeq int
+
+	// 席が無ければ順番待ちになり、Masterには通知しない
+	r.dispatch(newTestMsg(t, w, binary.MsgTypePromoteWatcher, binary.MarshalStr8(w.Id)))
+	if types := eventTypes(w, &watcherSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeSucceeded}) {
+		t.Fatalf("watcher events %v, wants [Succeeded]", types)
+	}
+	if types := eventTypes(master, &masterSeq); len(types) != 0 {
+		t.Fatalf("master events %v, wants none", types)
+	}
+	if !reflect.DeepEqual(r.promoteQueue, []ClientID{w.ID()}) {
+		t.Fatalf("promoteQueue = %v", r.promoteQueue)
+	}
+
+	r.muClients.Lock()
+	r.removePlayer(player, "leave", nil)
+	r.muClients.Unlock()
+
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeLeft, binary.EvTypeJoined}) {
+		t.Fatalf("master events %v, wants [Left Joined]", types)
+	}
+	if r.players[w.ID()] != w || len(r.promoteQueue) != 0 {
+		t.Fatalf("watcher must be promoted: players=%v queue=%v", r.players, r.promoteQueue)
+	}
+}
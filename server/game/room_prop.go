@@ -0,0 +1,81 @@
+package game
+
+import (
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+)
+
+// pendingClientProp : 通知を保留しているクライアントプロパティの変更.
+// 最初の変更はすぐに通知し、その後 ClientPropCoalesce の間の変更はまとめて1つのEvClientPropで通知する.
+type pendingClientProp struct {
+	client *Client
+	props  binary.Dict // 変更されたキーのみ. 削除されたキーは空の値
+	timer  common.Timer
+}
+
+// clientPropCoalesce : クライアントプロパティの変更をまとめる期間. 0のときはまとめない.
+func (r *Room) clientPropCoalesce() time.Duration {
+	return time.Duration(r.conf.ClientPropCoalesce)
+}
+
+// notifyClientProp : クライアントプロパティの変更を通知する.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) notifyClientProp(c *Client, props binary.Dict, payload []byte) {
+	window := r.clientPropCoalesce()
+	if window <= 0 {
+		r.broadcast(binary.NewEvClientProp(c.Id, payload))
+		return
+	}
+
+	if p, ok := r.pendingProps[c.ID()]; ok && p.client == c {
+		for k, v := range props {
+			p.props[k] = v
+		}
+		return
+	}
+
+	r.broadcast(binary.NewEvClientProp(c.Id, payload))
+	p := &pendingClientProp{
+		client: c,
+		props:  make(binary.Dict),
+	}
+	p.timer = r.clock.AfterFunc(window, func() {
+		r.SendMessage(&MsgClientPropFlush{p})
+	})
+	r.pendingProps[c.ID()] = p
+}
+
+// dropPendingClientProp : 退室したクライアントの保留中の変更を破棄する.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) dropPendingClientProp(cid ClientID) {
+	if p, ok := r.pendingProps[cid]; ok {
+		p.timer.Stop()
+		delete(r.pendingProps, cid)
+	}
+}
+
+func (r *Room) msgClientPropFlush(msg *MsgClientPropFlush) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	p := msg.pending
+	cid := p.client.ID()
+	if r.pendingProps[cid] != p {
+		return
+	}
+	if r.players[cid] != p.client || len(p.props) == 0 {
+		delete(r.pendingProps, cid)
+		return
+	}
+
+	p.client.logger.Debugf("flush client prop: %v", p.props)
+	r.broadcast(binary.NewEvClientProp(p.client.Id, binary.MarshalDict(p.props)))
+
+	// 続けて変更が来る場合に備えて次の期間もまとめる
+	p.props = make(binary.Dict)
+	p.timer = r.clock.AfterFunc(r.clientPropCoalesce(), func() {
+		r.SendMessage(&MsgClientPropFlush{p})
+	})
+}
@@ -0,0 +1,109 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+	"wsnet2/config"
+	"wsnet2/pb"
+)
+
+func TestClientPropCoalesce(t *testing.T) {
+	r, clients := newRelayRoom(t, 2, 1)
+	clock := common.NewFakeClock(time.Now())
+	r.clock = clock
+	r.conf = &config.GameConf{ClientPropCoalesce: config.Duration(100 * time.Millisecond)}
+	r.msgCh = make(chan Msg, RoomMsgChSize)
+	r.pendingProps = make(map[ClientID]*pendingClientProp)
+	sender, other := clients[0], clients[1]
+	sender.props = make(binary.Dict)
+
+	setProp := func(props binary.Dict) {
+		r.dispatch(newTestMsg(t, sender, binary.MsgTypeClientProp, binary.MarshalClientPropPayload(props)))
+	}
+	flush := func() {
+		select {
+		case msg := <-r.msgCh:
+			r.dispatch(msg)
+		default:
+		}
+	}
+	evSeq := 0
+	received := func() []binary.Dict {
+		evs, err := other.evbuf.Read(evSeq)
+		if err != nil {
+			t.Fatalf("evbuf.Read: %v", err)
+		}
+		evSeq += len(evs)
+		var props []binary.Dict
+		for _, ev := range evs {
+			p, err := binary.UnmarshalEvClientPropPayload(ev.Payload())
+			if err != nil {
+				t.Fatalf("UnmarshalEvClientPropPayload: %v", err)
+			}
+			props = append(props, p.Props)
+		}
+		return props
+	}
+
+	// 最初の変更はすぐに通知される
+	setProp(binary.Dict{"x": binary.MarshalInt(1)})
+	if props := received(); len(props) != 1 {
+		t.Fatalf("first update: %v events, wants 1", len(props))
+	}
+
+	// 期間内の変更はまとめられる
+	setProp(binary.Dict{"x": binary.MarshalInt(2), "y": binary.MarshalInt(1)})
+	setProp(binary.Dict{"x": binary.MarshalInt(3)})
+	if props := received(); len(props) != 0 {
+		t.Fatalf("coalesced updates: %v events before flush, wants 0", len(props))
+	}
+	clock.Advance(100 * time.Millisecond)
+	flush()
+	props := received()
+	if len(props) != 1 {
+		t.Fatalf("coalesced updates: %v events after flush, wants 1", len(props))
+	}
+	want := binary.Dict{"x": binary.MarshalInt(3), "y": binary.MarshalInt(1)}
+	if len(props[0]) != len(want) {
+		t.Fatalf("flushed props = %v, wants %v", props[0], want)
+	}
+	for k, v := range want {
+		if string(props[0][k]) != string(v) {
+			t.Fatalf("flushed props[%v] = %v, wants %v", k, props[0][k], v)
+		}
+	}
+
+	// 変更が無いまま期間が過ぎたら、次の変更はすぐに通知される
+	clock.Advance(100 * time.Millisecond)
+	flush()
+	if len(r.pendingProps) != 0 {
+		t.Fatalf("pendingProps remains: %v", r.pendingProps)
+	}
+	setProp(binary.Dict{"x": binary.MarshalInt(4)})
+	if props := received(); len(props) != 1 {
+		t.Fatalf("update after window: %v events, wants 1", len(props))
+	}
+}
+
+func TestClientPropCapsReserved(t *testing.T) {
+	r, clients := newRelayRoom(t, 2, 1)
+	r.conf = &config.GameConf{}
+	sender := clients[0]
+	caps := binary.MarshalCaps(&pb.Capabilities{Batch: true, Platform: "go"})
+	sender.props = binary.Dict{binary.ClientCapsKey: caps}
+
+	r.dispatch(newTestMsg(t, sender, binary.MsgTypeClientProp, binary.MarshalClientPropPayload(binary.Dict{
+		binary.ClientCapsKey: binary.MarshalNull(),
+	})))
+
+	evs, _ := sender.evbuf.Read(0)
+	if len(evs) != 1 || evs[0].Type() != binary.EvTypePermissionDenied {
+		t.Fatalf("sender events %v, wants [PermissionDenied]", evs)
+	}
+	if string(sender.props[binary.ClientCapsKey]) != string(caps) {
+		t.Fatalf("caps modified: %v", sender.props[binary.ClientCapsKey])
+	}
+}
@@ -0,0 +1,33 @@
+package game
+
+import (
+	"golang.org/x/xerrors"
+
+	"wsnet2/metrics"
+)
+
+// 部屋のプロパティのサイズの上限:
+// 部屋の公開/非公開プロパティとプレイヤーのプロパティは部屋が閉じるまで保持するので、
+// キーを増やし続けるクライアントで部屋のメモリが膨らまないよう合計サイズ(marshal後)に上限(max_props_size)を設ける.
+// 上限を超える変更はPermissionDeniedで拒否する. 上限を下げた後などに、サイズが減る変更は上限を超えていても受け付ける.
+// 入室時のプロパティは拒否せずに合計に含める.
+
+// propsSize : 部屋のプロパティと全プレイヤーのプロパティの合計サイズ.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) propsSize() int {
+	size := len(r.RoomInfo.PublicProps) + len(r.RoomInfo.PrivateProps)
+	for _, c := range r.players {
+		size += len(c.ClientInfo.Props)
+	}
+	return size
+}
+
+// checkPropsSize : プロパティの変更で合計サイズがcurからnextになってもよいか
+func (r *Room) checkPropsSize(cur, next int) error {
+	max := r.conf.MaxPropsSize
+	if max <= 0 || next <= max || next <= cur {
+		return nil
+	}
+	metrics.PropsRejected.Add(1)
+	return xerrors.Errorf("props size exceeds the limit: %v > %v", next, max)
+}
@@ -0,0 +1,43 @@
+package game
+
+import (
+	"testing"
+
+	"wsnet2/config"
+	"wsnet2/metrics"
+	"wsnet2/pb"
+)
+
+func TestPropsSize(t *testing.T) {
+	r := &Room{
+		RoomInfo: &pb.RoomInfo{PublicProps: make([]byte, 10), PrivateProps: make([]byte, 20)},
+		conf:     &config.GameConf{MaxPropsSize: 100},
+		players: map[ClientID]*Client{
+			"p1": {ClientInfo: &pb.ClientInfo{Id: "p1", Props: make([]byte, 30)}},
+			"p2": {ClientInfo: &pb.ClientInfo{Id: "p2", Props: make([]byte, 5)}},
+		},
+	}
+	if s := r.propsSize(); s != 65 {
+		t.Fatalf("propsSize = %v, wants 65", s)
+	}
+
+	rejected := metrics.PropsRejected.Value()
+	if err := r.checkPropsSize(65, 100); err != nil {
+		t.Fatalf("checkPropsSize(65, 100): %v", err)
+	}
+	if err := r.checkPropsSize(65, 101); err == nil {
+		t.Fatalf("checkPropsSize(65, 101) must be an error")
+	}
+	// 上限を超えていても減る変更は受け付ける
+	if err := r.checkPropsSize(200, 150); err != nil {
+		t.Fatalf("checkPropsSize(200, 150): %v", err)
+	}
+	if n := metrics.PropsRejected.Value() - rejected; n != 1 {
+		t.Fatalf("PropsRejected = %v, wants 1", n)
+	}
+
+	r.conf.MaxPropsSize = 0
+	if err := r.checkPropsSize(65, 1000); err != nil {
+		t.Fatalf("checkPropsSize must not limit when MaxPropsSize is 0: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package game
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc/codes"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+	"wsnet2/pb"
+)
+
+// maxRejoinConfirmTimeout : 確認を待つ時間の上限. 入室リクエストのタイムアウト(5秒)より短くする
+const maxRejoinConfirmTimeout = 4 * time.Second
+
+// rejoinRequest : 入室済みの接続の確認を待っている、同じクライアントIDの別の接続からの入室.
+//
+// RejoinPolicyConfirmの部屋では、入室済みのPlayerにEvRejoinRequestedを送って入室リクエストを保留する.
+// MsgConfirmRejoinで受諾されたら置き換え、拒否されたら入室を拒否する.
+// RejoinConfirmTimeoutまでに応答が無いときは、その間に切断していれば置き換え、接続したままなら入室を拒否する.
+// 確認待ちの間に入室済みのPlayerが退室したときは、通常の入室として扱う.
+type rejoinRequest struct {
+	join  *MsgJoin
+	old   *Client
+	timer common.Timer
+}
+
+// acceptRejoin : 入室済みのPlayer oldと同じクライアントIDでの入室を、すぐに置き換えてよいか.
+// falseのときは入室を拒否したか確認待ちにしたので、呼び出し側では何もしない.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) acceptRejoin(msg *MsgJoin, old *Client) bool {
+	if _, ok := r.rejoining[old.ID()]; ok {
+		err := xerrors.Errorf("Another rejoin is waiting for confirmation. room=%v, client=%v", r.ID(), old.Id)
+		r.logger.Info(err.Error())
+		msg.Err <- WithCode(err, codes.AlreadyExists)
+		return false
+	}
+	if !old.connected() {
+		// 接続していない入室済みの接続は置き換える
+		return true
+	}
+
+	switch r.rejoinPolicy {
+	case pb.RejoinPolicyReject:
+		err := xerrors.Errorf("Player is already connected. room=%v, client=%v", r.ID(), old.Id)
+		r.logger.Info(err.Error())
+		msg.Err <- WithCode(err, codes.AlreadyExists)
+		return false
+
+	case pb.RejoinPolicyConfirm:
+		timeout := time.Duration(r.conf.RejoinConfirmTimeout)
+		if timeout > maxRejoinConfirmTimeout {
+			timeout = maxRejoinConfirmTimeout
+		}
+		if timeout <= 0 || !old.canConfirmRejoin() {
+			return true
+		}
+		r.requestRejoin(msg, old, timeout)
+		return false
+	}
+
+	return true
+}
+
+// requestRejoin : 入室済みの接続に確認を求め、入室リクエストを保留する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) requestRejoin(msg *MsgJoin, old *Client, timeout time.Duration) {
+	req := &rejoinRequest{
+		join: msg,
+		old:  old,
+	}
+	req.timer = r.clock.AfterFunc(timeout, func() {
+		r.SendMessage(&MsgRejoinTimeout{req})
+	})
+	r.rejoining[old.ID()] = req
+
+	old.logger.Infof("rejoin requested: %v", old.Id)
+	old.SendSystemEvent(binary.NewEvRejoinRequested(uint32(timeout / time.Millisecond)))
+}
+
+// finishRejoin : 確認待ちの入室を終える. acceptedなら入室済みの接続を置き換え、そうでなければ入室を拒否する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) finishRejoin(req *rejoinRequest, accepted bool) {
+	req.timer.Stop()
+	delete(r.rejoining, req.old.ID())
+
+	if r.players[req.old.ID()] != req.old {
+		if len(r.players) == 0 {
+			err := xerrors.Errorf("Room closed while waiting for rejoin confirmation. room=%v, client=%v", r.ID(), req.old.Id)
+			r.logger.Info(err.Error())
+			req.join.Err <- WithCode(err, codes.NotFound)
+			return
+		}
+		// 入室済みのPlayerが退室した
+		r.join(req.join, false)
+		return
+	}
+	if !accepted {
+		err := xerrors.Errorf("Rejoin is not accepted by the current session. room=%v, client=%v", r.ID(), req.old.Id)
+		r.logger.Info(err.Error())
+		req.join.Err <- WithCode(err, codes.AlreadyExists)
+		return
+	}
+	req.old.logger.Infof("rejoin accepted: %v", req.old.Id)
+	r.join(req.join, true)
+}
+
+// cancelRejoin : 退室したPlayerへの確認を取り下げ、保留していた入室を通常の入室として扱う.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) cancelRejoin(c *Client) {
+	req := r.rejoining[c.ID()]
+	if req == nil || req.old != c {
+		return
+	}
+	r.finishRejoin(req, false)
+}
+
+func (r *Room) msgConfirmRejoin(msg *MsgConfirmRejoin) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	req := r.rejoining[msg.Sender.ID()]
+	if req == nil || req.old != msg.Sender {
+		msg.Sender.logger.Infof("no rejoin request to %v", msg.Sender.Id)
+		return
+	}
+	r.finishRejoin(req, msg.Accept)
+}
+
+func (r *Room) msgRejoinTimeout(msg *MsgRejoinTimeout) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	req := msg.Request
+	if r.rejoining[req.old.ID()] != req {
+		return
+	}
+	req.old.logger.Infof("rejoin confirmation timeout: %v", req.old.Id)
+	r.finishRejoin(req, !req.old.connected())
+}
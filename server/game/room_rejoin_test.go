@@ -0,0 +1,111 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"wsnet2/common"
+	"wsnet2/config"
+	"wsnet2/pb"
+)
+
+// newRejoinRoom : 同じクライアントIDでの入室の扱いがpolicyの部屋
+func newRejoinRoom(t *testing.T, policy pb.RejoinPolicy) (*Room, []*Client, *common.FakeClock) {
+	r, clients, clock := newSwitchRoom(t, 2)
+	r.conf.RejoinConfirmTimeout = config.Duration(3 * time.Second)
+	r.rejoinPolicy = policy
+	r.rejoining = make(map[ClientID]*rejoinRequest)
+	return r, clients, clock
+}
+
+func newRejoinMsg(c *Client) (*MsgJoin, chan ErrorWithCode) {
+	errch := make(chan ErrorWithCode, 1)
+	return &MsgJoin{Info: &pb.ClientInfo{Id: c.Id}, Joined: make(chan *JoinedInfo, 1), Err: errch}, errch
+}
+
+func TestAcceptRejoin(t *testing.T) {
+	tests := map[string]struct {
+		policy    pb.RejoinPolicy
+		connected bool
+		capable   bool
+		accept    bool
+		pending   bool
+	}{
+		"replace":               {pb.RejoinPolicyReplace, true, true, true, false},
+		"reject":                {pb.RejoinPolicyReject, true, true, false, false},
+		"reject disconnected":   {pb.RejoinPolicyReject, false, false, true, false},
+		"confirm":               {pb.RejoinPolicyConfirm, true, true, false, true},
+		"confirm not supported": {pb.RejoinPolicyConfirm, true, false, true, false},
+		"confirm disconnected":  {pb.RejoinPolicyConfirm, false, false, true, false},
+	}
+	for name, tc := range tests {
+		r, clients, _ := newRejoinRoom(t, tc.policy)
+		old := clients[1]
+		if tc.connected {
+			old.peer = &Peer{closed: true, rejoinConfirm: tc.capable}
+		}
+
+		msg, errch := newRejoinMsg(old)
+		if accept := r.acceptRejoin(msg, old); accept != tc.accept {
+			t.Fatalf("%v: accept = %v, wants %v", name, accept, tc.accept)
+		}
+		if _, pending := r.rejoining[old.ID()]; pending != tc.pending {
+			t.Fatalf("%v: pending = %v, wants %v", name, pending, tc.pending)
+		}
+		if !tc.accept && !tc.pending {
+			if err := <-errch; err.Code() != codes.AlreadyExists {
+				t.Fatalf("%v: error code = %v, wants AlreadyExists", name, err.Code())
+			}
+		}
+	}
+}
+
+func TestRejoinConfirmation(t *testing.T) {
+	for name, decline := range map[string]bool{"declined": true, "unresponsive": false} {
+		r, clients, clock := newRejoinRoom(t, pb.RejoinPolicyConfirm)
+		old := clients[1]
+		old.peer = &Peer{closed: true, rejoinConfirm: true}
+
+		msg, errch := newRejoinMsg(old)
+		if r.acceptRejoin(msg, old) {
+			t.Fatalf("%v: rejoin accepted without confirmation", name)
+		}
+
+		// 確認待ちの間の入室は拒否する
+		msg2, errch2 := newRejoinMsg(old)
+		if r.acceptRejoin(msg2, old) {
+			t.Fatalf("%v: second rejoin accepted", name)
+		}
+		if err := <-errch2; err.Code() != codes.AlreadyExists {
+			t.Fatalf("%v: second rejoin error code = %v, wants AlreadyExists", name, err.Code())
+		}
+
+		if decline {
+			r.msgConfirmRejoin(&MsgConfirmRejoin{Sender: old, Accept: false})
+		} else {
+			clock.Advance(3 * time.Second)
+		}
+		select {
+		case msg := <-r.msgCh:
+			r.dispatch(msg)
+		default:
+		}
+
+		if len(r.rejoining) != 0 {
+			t.Fatalf("%v: rejoin is still pending", name)
+		}
+		select {
+		case err := <-errch:
+			if err.Code() != codes.AlreadyExists {
+				t.Fatalf("%v: error code = %v, wants AlreadyExists", name, err.Code())
+			}
+		default:
+			t.Fatalf("%v: rejoin is not rejected", name)
+		}
+		if n := clock.Timers(); n != 0 {
+			t.Fatalf("%v: %v timers remain", name, n)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package game
+
+import (
+	"hash/fnv"
+
+	"wsnet2/binary"
+	"wsnet2/moderation"
+)
+
+// RoomRelayShards : 中継メッセージを処理するgoroutineの数
+const RoomRelayShards = 4
+
+// clientsView : 中継goroutineから参照するPlayer/Watcherのスナップショット.
+// muClients のロック中に publishClients で差し替え、参照側はロックを取らない.
+type clientsView struct {
+	players  map[ClientID]*Client
+	watchers map[ClientID]*Client
+	master   *Client
+}
+
+// publishClients : 現在のPlayer/Watcherを中継goroutineに公開する.
+// players, watchers, master を変更したら muClients のロック中に呼び出す.
+func (r *Room) publishClients() {
+	v := &clientsView{
+		players:  make(map[ClientID]*Client, len(r.players)),
+		watchers: make(map[ClientID]*Client, len(r.watchers)),
+		master:   r.master,
+	}
+	for id, c := range r.players {
+		v.players[id] = c
+	}
+	for id, c := range r.watchers {
+		v.watchers[id] = c
+	}
+	r.clients.Store(v)
+}
+
+// get : Player/Watcherを探す. 居なければnil.
+func (v *clientsView) get(id ClientID) *Client {
+	if c, ok := v.players[id]; ok {
+		return c
+	}
+	return v.watchers[id]
+}
+
+// msgQueueDepth : msgChと中継goroutineのキューに溜まっているMsgの数
+func (r *Room) msgQueueDepth() int {
+	n := len(r.msgCh)
+	for _, ch := range r.relayCh {
+		n += len(ch)
+	}
+	return n
+}
+
+// startRelay : 中継goroutineをn個起動する.
+func (r *Room) startRelay(n int) {
+	r.relayCh = make([]chan Msg, n)
+	for i := range r.relayCh {
+		r.relayCh[i] = make(chan Msg, RoomMsgChSize)
+		ch := r.relayCh[i]
+		r.Go(func() { r.relayLoop(ch) })
+	}
+}
+
+// isRelayMsg : 部屋の状態を変更せず、他のクライアントへ中継するだけのメッセージ.
+func isRelayMsg(msg Msg) bool {
+	switch msg.(type) {
+	case *MsgTargets, *MsgToMaster, *MsgBroadcast, *MsgEncrypted:
+		return true
+	}
+	return false
+}
+
+// relay : 中継メッセージを送信者ごとの中継goroutineに振り分ける.
+// 同じ送信者からのメッセージの順序は保たれる.
+func (r *Room) relay(msg Msg) {
+	h := fnv.New32a()
+	h.Write([]byte(msg.SenderID()))
+	ch := r.relayCh[h.Sum32()%uint32(len(r.relayCh))]
+
+	r.relayPending.Add(1)
+	select {
+	case <-r.done:
+		r.relayPending.Done()
+	case ch <- msg:
+	}
+}
+
+// waitRelay : 振り分け済みの中継メッセージの処理完了を待つ.
+// 制御メッセージは中継メッセージとの前後関係を保つため、処理前にこれを呼ぶ.
+func (r *Room) waitRelay() {
+	r.relayPending.Wait()
+}
+
+func (r *Room) relayLoop(ch <-chan Msg) {
+	for {
+		select {
+		case <-r.done:
+			return
+		case msg := <-ch:
+			r.dispatchRelay(msg)
+			r.relayPending.Done()
+		}
+	}
+}
+
+func (r *Room) dispatchRelay(msg Msg) {
+	switch m := msg.(type) {
+	case *MsgTargets:
+		r.msgTargets(m)
+	case *MsgToMaster:
+		r.msgToMaster(m)
+	case *MsgBroadcast:
+		r.msgBroadcast(m)
+	case *MsgEncrypted:
+		r.msgEncrypted(m)
+	default:
+		r.logger.Errorf("unknown relay msg type (%T): %v", m, m)
+	}
+}
+
+// isCurrent : senderが退室済みや再接続前の古いClientでないか
+func (v *clientsView) isCurrent(sender *Client) bool {
+	if sender.isPlayer.Load() {
+		return v.players[sender.ID()] == sender
+	}
+	return v.watchers[sender.ID()] == sender
+}
+
+func (r *Room) msgTargets(msg *MsgTargets) {
+	v := r.clients.Load()
+	if !v.isCurrent(msg.Sender) {
+		return
+	}
+
+	msg.Sender.logger.Debugf("message to targets: %v, %v", msg.Targets, msg.Data)
+
+	ev := binary.NewEvMessage(msg.Sender.Id, msg.Data)
+
+	absent := make([]string, 0, len(v.players))
+	sent := make([]ClientID, 0, len(msg.Targets))
+
+	for _, t := range msg.Targets {
+		c, ok := v.players[ClientID(t)]
+		if !ok {
+			msg.Sender.logger.Infof("target %s is absent", t)
+			absent = append(absent, t)
+			continue
+		}
+		// ブロックされていることは送信元に知らせない
+		if c.blocks(msg.SenderID()) {
+			continue
+		}
+		r.sendTo(c, ev)
+		sent = append(sent, c.ID())
+	}
+	r.scan(moderation.KindTargets, msg.Sender, sent, ev, msg.Data)
+
+	// 居なかった人を通知
+	if len(absent) > 0 {
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, absent))
+	}
+}
+
+func (r *Room) msgToMaster(msg *MsgToMaster) {
+	// Master交代中は交代が終わるまで待つ. see: room_master.go
+	r.toMaster.mu.Lock()
+	defer r.toMaster.mu.Unlock()
+
+	v := r.clients.Load()
+	if !v.isCurrent(msg.Sender) {
+		return
+	}
+
+	msg.Sender.logger.Debugf("message to master: %v", msg.Data)
+
+	ev := binary.NewEvMessage(msg.Sender.Id, msg.Data)
+	r.sendToMaster(v.master, ev)
+	r.scan(moderation.KindToMaster, msg.Sender, []ClientID{v.master.ID()}, ev, msg.Data)
+}
+
+func (r *Room) msgBroadcast(msg *MsgBroadcast) {
+	v := r.clients.Load()
+	if !v.isCurrent(msg.Sender) {
+		return
+	}
+
+	msg.Sender.logger.Debugf("message to all: %v", msg.Data)
+
+	ev := binary.NewEvMessage(msg.Sender.Id, msg.Data)
+	r.history.add(ev)
+	r.publish(ev)
+	for _, c := range v.players {
+		if !c.blocks(msg.SenderID()) {
+			r.sendTo(c, ev)
+		}
+	}
+	for _, c := range v.watchers {
+		r.sendTo(c, ev)
+	}
+	r.scan(moderation.KindBroadcast, msg.Sender, nil, ev, msg.Data)
+}
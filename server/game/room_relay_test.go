@@ -0,0 +1,129 @@
+package game
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+	"wsnet2/config"
+	"wsnet2/pb"
+)
+
+func newRelayRoom(tb testing.TB, players, shards int) (*Room, []*Client) {
+	r := &Room{
+		RoomInfo: &pb.RoomInfo{Id: "relay"},
+		done:     make(chan struct{}),
+		players:  make(map[ClientID]*Client),
+		watchers: make(map[ClientID]*Client),
+		conf:     &config.GameConf{},
+		clock:    common.RealClock,
+		logger:   zap.NewNop().Sugar(),
+	}
+	clients := make([]*Client, players)
+	for i := range clients {
+		c := &Client{
+			ClientInfo: &pb.ClientInfo{Id: fmt.Sprintf("player%d", i)},
+			room:       r,
+			nodeCount:  1,
+			evbuf:      common.NewRingBuf[*binary.RegularEvent](1024),
+			logger:     r.logger,
+		}
+		c.isPlayer.Store(true)
+		clients[i] = c
+		r.players[c.ID()] = c
+		r.masterOrder = append(r.masterOrder, c.ID())
+	}
+	r.master = clients[0]
+	r.publishClients()
+	r.startRelay(shards)
+	tb.Cleanup(func() { close(r.done) })
+	return r, clients
+}
+
+// newTestMsg : senderが送ったmsgTypeのMsgを作る
+func newTestMsg(tb testing.TB, sender *Client, msgType binary.MsgType, payload []byte) Msg {
+	tb.Helper()
+	mac := hmac.New(sha1.New, []byte("key"))
+	m, err := binary.UnmarshalMsg(mac, binary.BuildRegularMsgFrame(msgType, 1, payload, mac))
+	if err != nil {
+		tb.Fatalf("UnmarshalMsg: %v", err)
+	}
+	msg, err := ConstructMsg(sender, m)
+	if err != nil {
+		tb.Fatalf("ConstructMsg: %v", err)
+	}
+	return msg
+}
+
+func TestRelayOrder(t *testing.T) {
+	r, clients := newRelayRoom(t, 8, RoomRelayShards)
+
+	const n = 100
+	for i := 0; i < n; i++ {
+		for _, c := range clients {
+			r.relay(&MsgToMaster{Sender: c, Data: binary.MarshalInt(i)})
+		}
+	}
+	r.waitRelay()
+
+	evs, err := clients[0].evbuf.Read(0)
+	if err != nil {
+		t.Fatalf("evbuf.Read: %v", err)
+	}
+	if len(evs) != n*len(clients) {
+		t.Fatalf("len(evs) = %v, wants %v", len(evs), n*len(clients))
+	}
+	next := make(map[string]int)
+	for _, ev := range evs {
+		sender, data, err := binary.UnmarshalEvMessage(ev.Payload())
+		if err != nil {
+			t.Fatalf("UnmarshalEvMessage: %v", err)
+		}
+		v, _, err := binary.Unmarshal(data)
+		if err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if v != next[sender] {
+			t.Fatalf("message from %v = %v, wants %v", sender, v, next[sender])
+		}
+		next[sender]++
+	}
+}
+
+// 送信者ごとに振り分けて中継する場合と、1goroutineで中継する場合の比較
+func BenchmarkRelayBroadcast(b *testing.B) {
+	for _, players := range []int{32, 64} {
+		for _, shards := range []int{1, RoomRelayShards} {
+			b.Run(fmt.Sprintf("players=%d/shards=%d", players, shards), func(b *testing.B) {
+				benchmarkRelayBroadcast(b, players, shards)
+			})
+		}
+	}
+}
+
+func benchmarkRelayBroadcast(b *testing.B, players, shards int) {
+	r, clients := newRelayRoom(b, players, shards)
+	data := make([]byte, 64)
+	seq := make([]int, players)
+
+	// evbufが溢れないように一定数ごとに読み出す
+	const batch = 128
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.relay(&MsgBroadcast{Sender: clients[i%players], Data: data})
+		if i%batch == batch-1 || i == b.N-1 {
+			r.waitRelay()
+			for j, c := range clients {
+				evs, _ := c.evbuf.Read(seq[j])
+				seq[j] += len(evs)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package game
+
+import (
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+)
+
+// staleWatch : 最後のメッセージからstaleThresholdを過ぎたPlayerをMasterに通知する.
+//
+// ClientDeadlineによる退室より前に知らせ、Masterが一時停止やAIへの置き換えをできるようにする.
+// 通知したPlayerからメッセージが届いたら EvTypePlayerRecovered で知らせる.
+// Masterが交代したら、新しいMasterに改めて通知する.
+// 一時停止中はタイムアウトしないので判定しない.
+type staleWatch struct {
+	master ClientID              // 通知したMaster
+	stale  map[ClientID]struct{} // 通知済みのPlayer
+	timer  common.Timer
+}
+
+// startStaleWatch : 閾値が指定されていれば判定のタイマーを開始する.
+// MsgLoopのgoroutineから呼ぶ.
+func (r *Room) startStaleWatch() {
+	if r.staleThreshold <= 0 {
+		return
+	}
+	r.stale = &staleWatch{stale: make(map[ClientID]struct{})}
+	r.scheduleStaleCheck(r.staleThreshold)
+}
+
+// stopStaleWatch : 判定のタイマーを止める. MsgLoopのgoroutineから呼ぶ.
+func (r *Room) stopStaleWatch() {
+	if r.stale != nil && r.stale.timer != nil {
+		r.stale.timer.Stop()
+	}
+}
+
+func (r *Room) scheduleStaleCheck(d time.Duration) {
+	r.stale.timer = r.clock.AfterFunc(d, func() {
+		r.SendMessage(&MsgStaleCheck{})
+	})
+}
+
+// lastMsgTime : PlayerのlastMsgの時刻
+func (r *Room) lastMsgTime(cid ClientID) (time.Time, bool) {
+	d, ok := r.lastMsg[string(cid)]
+	if !ok {
+		return time.Time{}, false
+	}
+	v, _, err := binary.UnmarshalAs(d, binary.TypeULong)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(int64(v.(uint64))), true
+}
+
+// checkStale : 閾値を過ぎたPlayerをMasterに通知し、次に閾値を過ぎうる時刻に再び判定する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) checkStale() {
+	next := r.staleThreshold
+	defer func() { r.scheduleStaleCheck(next) }()
+
+	if r.closing || r.paused != nil || r.master == nil {
+		return
+	}
+	s := r.stale
+	if s.master != r.master.ID() {
+		s.master = r.master.ID()
+		s.stale = make(map[ClientID]struct{})
+	}
+
+	now := r.clock.Now()
+	for id, c := range r.players {
+		if c == r.master {
+			continue
+		}
+		if _, ok := s.stale[id]; ok {
+			continue
+		}
+		last, ok := r.lastMsgTime(id)
+		if !ok {
+			continue
+		}
+		elapsed := now.Sub(last)
+		if remain := r.staleThreshold - elapsed; remain > 0 {
+			if remain < next {
+				next = remain
+			}
+			continue
+		}
+		s.stale[id] = struct{}{}
+		r.logger.Infof("player stale: %v elapsed=%v", id, elapsed)
+		r.sendTo(r.master, binary.NewEvPlayerStale(string(id), uint32(elapsed/time.Millisecond)))
+	}
+}
+
+// recoverStale : 通知済みのPlayerからメッセージが届いたらMasterに通知する.
+// MsgLoopのgoroutineから呼ぶ. 閾値を指定した部屋だけmuClientsのロックを取る.
+func (r *Room) recoverStale(cid ClientID) {
+	if r.stale == nil {
+		return
+	}
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+	if _, ok := r.stale.stale[cid]; !ok {
+		return
+	}
+	delete(r.stale.stale, cid)
+	if r.master == nil || r.master.ID() != r.stale.master {
+		return
+	}
+	r.logger.Infof("player recovered: %v", cid)
+	r.sendTo(r.master, binary.NewEvPlayerRecovered(string(cid)))
+}
+
+// forgetStale : 退室したPlayerを通知済みから外す.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) forgetStale(cid ClientID) {
+	if r.stale != nil {
+		delete(r.stale.stale, cid)
+	}
+}
+
+func (r *Room) msgStaleCheck(msg *MsgStaleCheck) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	r.checkStale()
+}
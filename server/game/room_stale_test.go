@@ -0,0 +1,67 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"wsnet2/binary"
+)
+
+func TestStaleWatch(t *testing.T) {
+	r, clients, clock := newSwitchRoom(t, 3)
+	master, p1, p2 := clients[0], clients[1], clients[2]
+	r.staleThreshold = 3 * time.Second
+	r.startStaleWatch()
+	defer r.stopStaleWatch()
+
+	var seq int
+	clock.Advance(2 * time.Second)
+	r.updateLastMsg(p2.ID())
+	clock.Advance(time.Second)
+	r.dispatch(<-r.msgCh)
+	if types := eventTypes(master, &seq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePlayerStale}) {
+		t.Fatalf("master events %v, wants [PlayerStale]", types)
+	}
+	evs, _ := master.evbuf.Read(seq - 1)
+	p, _ := binary.UnmarshalEvPlayerStalePayload(evs[0].Payload())
+	if want := (binary.EvPlayerStalePayload{ClientId: p1.Id, ElapsedMilli: 3000}); *p != want {
+		t.Fatalf("payload = %+v, wants %+v", *p, want)
+	}
+	if types := eventTypes(p1, new(int)); len(types) != 0 {
+		t.Fatalf("player events %v, wants none", types)
+	}
+
+	// 次の判定はp2が閾値を過ぎるとき. 通知済みのp1は再び通知しない
+	clock.Advance(2 * time.Second)
+	r.dispatch(<-r.msgCh)
+	if types := eventTypes(master, &seq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePlayerStale}) {
+		t.Fatalf("master events %v, wants [PlayerStale]", types)
+	}
+
+	r.updateLastMsg(p1.ID())
+	if types := eventTypes(master, &seq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePlayerRecovered}) {
+		t.Fatalf("master events %v, wants [PlayerRecovered]", types)
+	}
+	r.updateLastMsg(p1.ID())
+	if types := eventTypes(master, &seq); len(types) != 0 {
+		t.Fatalf("master events %v, wants none", types)
+	}
+
+	// 一時停止中は判定しない
+	r.paused = &roomPause{}
+	clock.Advance(3 * time.Second)
+	r.dispatch(<-r.msgCh)
+	if types := eventTypes(master, &seq); len(types) != 0 {
+		t.Fatalf("master events while paused %v, wants none", types)
+	}
+	r.paused = nil
+
+	// Masterが交代したら改めて通知する
+	r.master = p1
+	clock.Advance(3 * time.Second)
+	r.dispatch(<-r.msgCh)
+	if types := eventTypes(p1, new(int)); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePlayerStale, binary.EvTypePlayerStale}) {
+		t.Fatalf("new master events %v, wants [PlayerStale PlayerStale]", types)
+	}
+}
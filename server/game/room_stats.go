@@ -0,0 +1,86 @@
+package game
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// プレイヤーの統計:
+// 入室したPlayer毎に在室時間、送信したメッセージ数、受け取ったイベント数、切断回数を数え、
+// 部屋を閉じるときに部屋の存続時間と合わせてplayer_statsに書き込む.
+// 途中で退室したPlayerや再入室したPlayerの分も部屋を閉じるときにまとめて書き込む.
+
+type playerStats struct {
+	joined time.Time     // 在室中なら最後に入室した時刻. 退室中はゼロ値
+	stay   time.Duration // 退室済みの在室時間の合計
+
+	// ClientのMsgLoopや中継goroutineからも加算する
+	msgSent     atomic.Int64
+	evReceived  atomic.Int64
+	disconnects atomic.Int64
+}
+
+// playerStatsRow : player_statsの1行
+type playerStatsRow struct {
+	AppID        string    `db:"app_id"`
+	RoomID       RoomID    `db:"room_id"`
+	PlayerID     ClientID  `db:"player_id"`
+	RoomDuration uint32    `db:"room_duration"` // 部屋の存続時間(秒)
+	Stay         uint32    `db:"stay"`          // 在室時間(秒)
+	MsgSent      int64     `db:"msg_sent"`
+	EvReceived   int64     `db:"ev_received"`
+	Disconnects  int64     `db:"disconnects"`
+	Closed       time.Time `db:"closed"`
+}
+
+// startPlayerStats : Playerの統計を開始する. 再入室なら前回の統計に加算する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) startPlayerStats(c *Client) {
+	if r.playerStats == nil {
+		r.playerStats = make(map[ClientID]*playerStats)
+	}
+	st, ok := r.playerStats[c.ID()]
+	if !ok {
+		st = &playerStats{}
+		r.playerStats[c.ID()] = st
+	}
+	if st.joined.IsZero() {
+		st.joined = r.clock.Now()
+	}
+	c.stats.Store(st)
+}
+
+// stopPlayerStats : 退室したPlayerの在室時間を加算する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) stopPlayerStats(c *Client) {
+	st := c.stats.Swap(nil)
+	if st == nil || st.joined.IsZero() {
+		return
+	}
+	st.stay += r.clock.Now().Sub(st.joined)
+	st.joined = time.Time{}
+}
+
+// playerStatsRows : 部屋を閉じるときに書き込む統計
+func (r *Room) playerStatsRows(closed time.Time) []*playerStatsRow {
+	rows := make([]*playerStatsRow, 0, len(r.playerStats))
+	duration := closed.Sub(r.Created.Time())
+	for id, st := range r.playerStats {
+		stay := st.stay
+		if !st.joined.IsZero() {
+			stay += closed.Sub(st.joined)
+		}
+		rows = append(rows, &playerStatsRow{
+			AppID:        r.AppId,
+			RoomID:       r.ID(),
+			PlayerID:     id,
+			RoomDuration: uint32(duration / time.Second),
+			Stay:         uint32(stay / time.Second),
+			MsgSent:      st.msgSent.Load(),
+			EvReceived:   st.evReceived.Load(),
+			Disconnects:  st.disconnects.Load(),
+			Closed:       closed,
+		})
+	}
+	return rows
+}
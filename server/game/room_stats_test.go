@@ -0,0 +1,63 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestPlayerStats(t *testing.T) {
+	r, clients, clock := newSwitchRoom(t, 2)
+	master, player := clients[0], clients[1]
+	r.RoomInfo.Created = &pb.Timestamp{Timestamp: timestamppb.New(clock.Now())}
+	for _, c := range clients {
+		r.startPlayerStats(c)
+	}
+
+	clock.Advance(10 * time.Second)
+	if err := player.Send(binary.NewEvMessage("a", []byte("msg"))); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	player.stats.Load().msgSent.Add(2)
+	player.stats.Load().disconnects.Add(1)
+
+	// 退室した後の在室時間は数えない
+	r.stopPlayerStats(player)
+	if player.stats.Load() != nil {
+		t.Fatalf("stats must be cleared after leave")
+	}
+	clock.Advance(20 * time.Second)
+
+	// 再入室すると前回の統計に加算する
+	r.startPlayerStats(player)
+	clock.Advance(5 * time.Second)
+	if err := player.Send(binary.NewEvMessage("a", []byte("msg"))); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	rows := r.playerStatsRows(clock.Now())
+	if len(rows) != 2 {
+		t.Fatalf("rows = %v, wants 2 rows", len(rows))
+	}
+	for _, row := range rows {
+		if row.RoomDuration != 35 {
+			t.Fatalf("room duration of %v = %v, wants 35", row.PlayerID, row.RoomDuration)
+		}
+		switch row.PlayerID {
+		case master.ID():
+			if row.Stay != 35 || row.MsgSent != 0 || row.EvReceived != 0 || row.Disconnects != 0 {
+				t.Fatalf("master stats = %+v", row)
+			}
+		case player.ID():
+			if row.Stay != 15 || row.MsgSent != 2 || row.EvReceived != 2 || row.Disconnects != 1 {
+				t.Fatalf("player stats = %+v", row)
+			}
+		default:
+			t.Fatalf("unknown player: %v", row.PlayerID)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package game
+
+import (
+	"wsnet2/pb"
+)
+
+// RoomInfoSubscription : 部屋情報の変更の購読.
+// 部屋に入室せずに人数やプロパティの変化を知りたい外部サービス向け.
+type RoomInfoSubscription struct {
+	room *Room
+	ch   chan *pb.RoomInfo
+}
+
+// C : 変更後の部屋情報. 受信が遅れたときは最新のものだけが残る.
+// 受信したRoomInfoは他の購読者と共有しているので書き換えてはいけない.
+func (s *RoomInfoSubscription) C() <-chan *pb.RoomInfo {
+	return s.ch
+}
+
+// Done : 部屋が閉じたらcloseされる
+func (s *RoomInfoSubscription) Done() <-chan struct{} {
+	return s.room.done
+}
+
+// Close : 購読をやめる
+func (s *RoomInfoSubscription) Close() {
+	s.room.mRoomInfo.Lock()
+	defer s.room.mRoomInfo.Unlock()
+	delete(s.room.roomInfoSubs, s)
+}
+
+// subscribeRoomInfo : 購読を開始する. 最初に現在の部屋情報が届く.
+func (r *Room) subscribeRoomInfo() *RoomInfoSubscription {
+	s := &RoomInfoSubscription{
+		room: r,
+		ch:   make(chan *pb.RoomInfo, 1),
+	}
+
+	r.mRoomInfo.Lock()
+	defer r.mRoomInfo.Unlock()
+	if r.roomInfoSubs == nil {
+		r.roomInfoSubs = make(map[*RoomInfoSubscription]struct{})
+	}
+	r.roomInfoSubs[s] = struct{}{}
+	s.ch <- r.lastRoomInfo
+	return s
+}
+
+// notifyRoomInfo : 購読者に部屋情報を届ける.
+// mRoomInfo のロック中に呼ぶ. 送信するのはここだけなので、古いものを捨てれば必ず送信できる.
+func (r *Room) notifyRoomInfo(ri *pb.RoomInfo) {
+	for s := range r.roomInfoSubs {
+		select {
+		case <-s.ch:
+		default:
+		}
+		s.ch <- ri
+	}
+}
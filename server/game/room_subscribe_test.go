@@ -0,0 +1,60 @@
+package game
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+
+	"wsnet2/pb"
+)
+
+func TestSubscribeRoomInfo(t *testing.T) {
+	r := &Room{
+		RoomInfo: &pb.RoomInfo{Id: "sub", Players: 1},
+		done:     make(chan struct{}),
+	}
+	r.lastRoomInfo = r.RoomInfo.Clone()
+
+	sub := r.subscribeRoomInfo()
+	if ri := <-sub.C(); ri.Players != 1 {
+		t.Fatalf("initial Players = %v, wants 1", ri.Players)
+	}
+
+	// 受信が遅れたら最新のものだけが残る
+	for i := uint32(2); i <= 4; i++ {
+		r.RoomInfo.Players = i
+		r.updateRoomInfo()
+	}
+	if ri := <-sub.C(); ri.Players != 4 {
+		t.Fatalf("Players = %v, wants 4", ri.Players)
+	}
+	select {
+	case ri := <-sub.C():
+		t.Fatalf("unexpected RoomInfo: %v", ri)
+	default:
+	}
+
+	sub.Close()
+	r.RoomInfo.Players = 5
+	r.updateRoomInfo()
+	select {
+	case ri := <-sub.C():
+		t.Fatalf("RoomInfo after Close: %v", ri)
+	default:
+	}
+
+	close(r.done)
+	select {
+	case <-sub.Done():
+	default:
+		t.Fatalf("Done must be closed")
+	}
+}
+
+func TestSubscribeRoomInfoNotFound(t *testing.T) {
+	repo := &Repository{rooms: make(map[RoomID]*Room)}
+	_, err := repo.SubscribeRoomInfo("nosuchroom")
+	if err == nil || err.Code() != codes.NotFound {
+		t.Fatalf("SubscribeRoomInfo error = %v, wants NotFound", err)
+	}
+}
@@ -0,0 +1,178 @@
+package game
+
+import (
+	"context"
+	"time"
+
+	"wsnet2/auth"
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+// 後継の部屋 (再戦など):
+// MasterがMsgCreateSuccessorを送ると、同じgameサーバに部屋の設定とプロパティ、Playerのロールを引き継いだ部屋を作る.
+// 後継の部屋はMasterが入室した状態で作られ、前の部屋のPlayerにEvRoomSuccessorでLobbyの招待トークンを送る.
+// Masterも含めて各自がトークンで入室する (Masterは未接続の入室済みクライアントと置き換わる).
+// MasterがClientDeadlineまでに入室しないと、他に誰も居なければ後継の部屋は閉じる.
+// 前の部屋と後継の部屋の対応はroom_successorテーブルに記録する.
+
+// successorOption : 後継の部屋のRoomOption.
+// 招待トークンで入室できるようにJoinableにする.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) successorOption(publicProps []byte) *pb.RoomOption {
+	return &pb.RoomOption{
+		Visible:             r.Visible,
+		Joinable:            true,
+		Watchable:           r.Watchable,
+		WithNumber:          r.Number.GetNumber() != 0,
+		SearchGroup:         r.SearchGroup,
+		ClientDeadline:      uint32(r.deadline / time.Second),
+		MaxPlayers:          r.MaxPlayers,
+		PublicProps:         publicProps,
+		PrivateProps:        r.PrivateProps,
+		LogLevel:            r.logLevel,
+		WatcherDelay:        uint32(r.watcherDelay / time.Second),
+		MaxWatchers:         r.MaxWatchers,
+		MaxBandwidth:        r.maxBandwidth,
+		HistorySize:         r.historySize,
+		RejoinPolicy:        r.rejoinPolicy,
+		Lifetime:            uint32(r.lifetime / time.Second),
+		CloseOnMasterLeave:  r.closeOnMasterLeave,
+		SlowConsumerPolicy:  r.slowConsumer,
+		PingInterval:        uint32(r.pingInterval / time.Millisecond),
+		StaleThreshold:      uint32(r.staleThreshold / time.Millisecond),
+		AutoPromoteWatchers: r.autoPromoteWatchers,
+		LobbyRoom:           r.lobbyRoom,
+		ParentRoom:          r.parent,
+	}
+}
+
+// playerRoles : Player毎の登録しているロール. muClients のロックを取得してから呼び出す.
+func (r *Room) playerRoles() map[ClientID][]string {
+	roles := make(map[ClientID][]string)
+	for role, members := range r.roles {
+		for id := range members {
+			roles[id] = append(roles[id], role)
+		}
+	}
+	return roles
+}
+
+func (r *Room) msgCreateSuccessor(msg *MsgCreateSuccessor) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if msg.Sender != r.master {
+		msg.Sender.logger.Warnf("sender %q is not master %q", msg.Sender.Id, r.masterID())
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if r.closing || r.creatingSuccessor || r.successor != "" {
+		msg.Sender.logger.Infof("successor is already created or room is closing: successor=%q", r.successor)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	publicProps, err := mergeProps(r.PublicProps, msg.Props)
+	if err != nil {
+		msg.Sender.logger.Warnf("msgCreateSuccessor: public props: %+v", err)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	msg.Sender.logger.Infof("create successor: props=%v", msg.Props)
+	r.creatingSuccessor = true
+	go r.repo.createSuccessor(r, msg, r.successorOption(publicProps), msg.Sender.ClientInfo.Clone(), msg.Sender.macKey, r.playerRoles())
+}
+
+// createSuccessor : 後継の部屋を作り、結果をMsgSuccessorCreatedで前の部屋に通知する.
+// 部屋を作り終えるまで待つので、前の部屋のMsgLoopとは別のgoroutineで呼ぶ.
+func (repo *Repository) createSuccessor(r *Room, msg *MsgCreateSuccessor, op *pb.RoomOption, master *pb.ClientInfo, macKey string, roles map[ClientID][]string) {
+	res, ewc := repo.CreateRoom(context.Background(), op, master, macKey)
+	if ewc != nil {
+		r.logger.Errorf("create successor: %+v", ewc)
+		r.SendMessage(&MsgSuccessorCreated{Request: msg})
+		return
+	}
+	succ := res.RoomInfo
+
+	if len(roles) > 0 {
+		if s, err := repo.GetRoom(succ.Id); err == nil {
+			s.SendMessage(&MsgInheritRoles{Roles: roles})
+		}
+	}
+
+	_, err := repo.db.Exec("INSERT INTO room_successor (room_id, successor_id, app_id, created) VALUES (?, ?, ?, ?)",
+		r.Id, succ.Id, repo.app.Id, time.Now())
+	if err != nil {
+		r.logger.Errorf("insert room_successor (%v -> %v): %+v", r.Id, succ.Id, err)
+	}
+
+	r.SendMessage(&MsgSuccessorCreated{Request: msg, Room: succ})
+}
+
+// msgSuccessorCreated : 前の部屋に残っているPlayerに後継の部屋への招待トークンを送る.
+// トークンはPlayer毎に発行し、有効期間はClientDeadlineとする.
+func (r *Room) msgSuccessorCreated(msg *MsgSuccessorCreated) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	r.creatingSuccessor = false
+	req := msg.Request
+	sender := r.players[req.SenderID()]
+	if sender != req.Sender {
+		sender = nil
+	}
+
+	if msg.Room == nil {
+		if sender != nil {
+			r.sendTo(sender, binary.NewEvPermissionDenied(req))
+		}
+		return
+	}
+
+	r.successor = msg.Room.Id
+	r.logger.Infof("successor created: %v -> %v", r.Id, r.successor)
+
+	expire := r.clock.Now().Add(r.deadline)
+	key := r.repo.app.GetKey()
+	for id, c := range r.players {
+		token, err := auth.GenerateInviteToken(key, r.successor, string(id), expire)
+		if err != nil {
+			c.logger.Errorf("successor token: %+v", err)
+			continue
+		}
+		r.sendTo(c, binary.NewEvRoomSuccessor(r.successor, token, uint64(expire.Unix())))
+	}
+	if sender != nil {
+		r.sendTo(sender, binary.NewEvSucceeded(req))
+	}
+}
+
+// msgInheritRoles : 前の部屋のロールを入室済みのPlayerに登録し、未入室のPlayerの分は入室時に登録する.
+func (r *Room) msgInheritRoles(msg *MsgInheritRoles) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	r.inheritedRoles = msg.Roles
+	for id := range r.players {
+		r.applyInheritedRoles(id)
+	}
+}
+
+// applyInheritedRoles : 前の部屋から引き継いだロールを登録する.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) applyInheritedRoles(cid ClientID) {
+	roles, ok := r.inheritedRoles[cid]
+	if !ok {
+		return
+	}
+	delete(r.inheritedRoles, cid)
+	for _, role := range roles {
+		members, ok := r.roles[role]
+		if !ok {
+			members = make(map[ClientID]struct{})
+			r.roles[role] = members
+		}
+		members[cid] = struct{}{}
+	}
+}
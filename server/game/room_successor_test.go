@@ -0,0 +1,93 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"wsnet2/auth"
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestSuccessorCreated(t *testing.T) {
+	r, clients, clock := newSwitchRoom(t, 2)
+	master, player := clients[0], clients[1]
+	r.repo = &Repository{app: &pb.App{Id: "app", Key: "appkey"}}
+	r.deadline = 30 * time.Second
+
+	var masterSeq, playerSeq int
+
+	// Master以外は作れない
+	r.msgCreateSuccessor(newTestMsg(t, player, binary.MsgTypeCreateSuccessor, binary.MarshalCreateSuccessorPayload(nil)).(*MsgCreateSuccessor))
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("player events %v, wants [PermissionDenied]", types)
+	}
+
+	req := newTestMsg(t, master, binary.MsgTypeCreateSuccessor, binary.MarshalCreateSuccessorPayload(nil)).(*MsgCreateSuccessor)
+	r.msgSuccessorCreated(&MsgSuccessorCreated{Request: req, Room: &pb.RoomInfo{Id: "room2"}})
+	if r.successor != "room2" {
+		t.Fatalf("successor = %q, wants room2", r.successor)
+	}
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeRoomSuccessor, binary.EvTypeSucceeded}) {
+		t.Fatalf("master events %v, wants [RoomSuccessor Succeeded]", types)
+	}
+	if types := eventTypes(player, &playerSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypeRoomSuccessor}) {
+		t.Fatalf("player events %v, wants [RoomSuccessor]", types)
+	}
+
+	// トークンはPlayer毎にLobbyの招待として使える
+	evs, _ := player.evbuf.Read(playerSeq - 1)
+	p, err := binary.UnmarshalEvRoomSuccessorPayload(evs[0].Payload())
+	if err != nil {
+		t.Fatalf("UnmarshalEvRoomSuccessorPayload: %v", err)
+	}
+	if p.RoomId != "room2" || p.Expire != uint64(clock.Now().Add(r.deadline).Unix()) {
+		t.Fatalf("payload = %+v", p)
+	}
+	roomId, userId, err := auth.ValidInviteToken(p.Token, "appkey", clock.Now())
+	if err != nil || roomId != "room2" || userId != player.Id {
+		t.Fatalf("ValidInviteToken = (%q, %q, %v), wants (room2, %q, nil)", roomId, userId, err, player.Id)
+	}
+
+	// 後継の部屋は1つだけ
+	r.msgCreateSuccessor(newTestMsg(t, master, binary.MsgTypeCreateSuccessor, binary.MarshalCreateSuccessorPayload(nil)).(*MsgCreateSuccessor))
+	if types := eventTypes(master, &masterSeq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied}) {
+		t.Fatalf("master events %v, wants [PermissionDenied]", types)
+	}
+}
+
+func TestInheritRoles(t *testing.T) {
+	r, clients, _ := newSwitchRoom(t, 2)
+	master, player := clients[0], clients[1]
+	r.roles = map[string]map[ClientID]struct{}{
+		"red":    {master.ID(): {}},
+		"leader": {master.ID(): {}},
+		"blue":   {player.ID(): {}},
+	}
+	roles := r.playerRoles()
+	if n := len(roles[master.ID()]); n != 2 {
+		t.Fatalf("master roles = %v, wants 2 roles", roles[master.ID()])
+	}
+
+	// 入室済みのPlayerはすぐに、未入室のPlayerは入室時に登録する
+	succ := &Room{
+		players: map[ClientID]*Client{master.ID(): master},
+		roles:   make(map[string]map[ClientID]struct{}),
+	}
+	succ.msgInheritRoles(&MsgInheritRoles{Roles: roles})
+	if _, ok := succ.roles["red"][master.ID()]; !ok {
+		t.Fatalf("master must have role red: %v", succ.roles)
+	}
+	if _, ok := succ.roles["blue"]; ok {
+		t.Fatalf("player must not have role blue before joining: %v", succ.roles)
+	}
+
+	succ.applyInheritedRoles(player.ID())
+	if _, ok := succ.roles["blue"][player.ID()]; !ok {
+		t.Fatalf("player must have role blue: %v", succ.roles)
+	}
+	if len(succ.inheritedRoles) != 0 {
+		t.Fatalf("inherited roles must be consumed: %v", succ.inheritedRoles)
+	}
+}
@@ -0,0 +1,81 @@
+package game
+
+import (
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+// 部屋毎のリソース使用量:
+// クライアントのイベントバッファ (再送用に保持しているものを含む)、プロパティ、メッセージ履歴のバイト数と
+// 部屋とクライアントのgoroutineの数を部屋毎に数える. メモリはペイロードの合計で、実際の使用量の概算.
+// gameサーバのホスト全体のグラフだけでなく、どのapp/部屋が使っているかを調べるために使う.
+
+// Go : fを部屋のgoroutineとして数えて実行する
+func (r *Room) Go(f func()) {
+	r.goroutines.Add(1)
+	go func() {
+		defer r.goroutines.Add(-1)
+		f()
+	}()
+}
+
+// updatePropsBytes : usageで返すプロパティの合計サイズを更新する.
+// プロパティとプレイヤーを変更するのは部屋のgoroutineだけなので、変更した箇所で呼ぶ.
+func (r *Room) updatePropsBytes() {
+	r.propsBytes.Store(int64(r.propsSize()))
+}
+
+// usage : 部屋のリソース使用量
+func (r *Room) usage() *pb.RoomUsage {
+	u := &pb.RoomUsage{
+		AppId:        r.AppId,
+		RoomId:       r.Id,
+		PropsBytes:   uint64(r.propsBytes.Load()),
+		HistoryBytes: uint64(r.history.bytes()),
+		Goroutines:   uint32(r.goroutines.Load()),
+	}
+	if v := r.clients.Load(); v != nil {
+		for _, cs := range []map[ClientID]*Client{v.players, v.watchers} {
+			for _, c := range cs {
+				u.EvbufBytes += uint64(c.evbufBytes())
+			}
+		}
+	}
+	return u
+}
+
+// evbufBytes : イベントバッファに保持しているイベントのバイト数
+func (c *Client) evbufBytes() int {
+	n := 0
+	c.evbuf.Each(func(ev *binary.RegularEvent) {
+		if ev != nil {
+			n += len(ev.Payload())
+		}
+	})
+	return n
+}
+
+// RoomUsages : 全ての部屋のリソース使用量
+func (repo *Repository) RoomUsages() []*pb.RoomUsage {
+	repo.mu.RLock()
+	rooms := make([]*Room, 0, len(repo.rooms))
+	for _, r := range repo.rooms {
+		rooms = append(rooms, r)
+	}
+	repo.mu.RUnlock()
+
+	usages := make([]*pb.RoomUsage, 0, len(rooms))
+	for _, r := range rooms {
+		usages = append(usages, r.usage())
+	}
+	return usages
+}
+
+// HeavyRooms : メモリの使用量が多い順にn件の部屋を返す. 同じならgoroutineが多い順.
+func HeavyRooms(usages []*pb.RoomUsage, n int) []*pb.RoomUsage {
+	pb.SortRoomUsages(usages)
+	if len(usages) > n {
+		usages = usages[:n]
+	}
+	return usages
+}
@@ -0,0 +1,84 @@
+package game
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestRoomUsage(t *testing.T) {
+	r, clients := newRelayRoom(t, 2, 2)
+	r.AppId = "app1"
+	r.history = newMsgHistory(10)
+
+	clients[0].evbuf.Write(binary.NewRegularEvent(binary.EvTypeMessage, make([]byte, 100)))
+	clients[1].evbuf.Write(binary.NewRegularEvent(binary.EvTypeMessage, make([]byte, 50)))
+	r.history.add(binary.NewRegularEvent(binary.EvTypeMessage, make([]byte, 30)))
+	r.propsBytes.Store(20)
+
+	stop := make(chan struct{})
+	r.Go(func() { <-stop })
+
+	u := r.usage()
+	got := []any{u.AppId, u.RoomId, u.EvbufBytes, u.PropsBytes, u.HistoryBytes, u.Goroutines}
+	want := []any{"app1", "relay", uint64(150), uint64(20), uint64(30), uint32(3)} // 中継goroutine x2 + 1
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("usage = %v, wants %v", got, want)
+	}
+	if b := u.Bytes(); b != 200 {
+		t.Fatalf("Bytes = %v, wants 200", b)
+	}
+
+	close(stop)
+	for r.goroutines.Load() != 2 {
+		runtime.Gosched()
+	}
+}
+
+func TestHeavyRooms(t *testing.T) {
+	usages := []*pb.RoomUsage{
+		{RoomId: "a", PropsBytes: 10},
+		{RoomId: "b", EvbufBytes: 300},
+		{RoomId: "c", HistoryBytes: 10, Goroutines: 5},
+		{RoomId: "d", EvbufBytes: 100, PropsBytes: 100},
+	}
+	heavy := HeavyRooms(usages, 3)
+	ids := make([]string, len(heavy))
+	for i, u := range heavy {
+		ids[i] = u.RoomId
+	}
+	if !reflect.DeepEqual(ids, []string{"b", "d", "c"}) {
+		t.Fatalf("HeavyRooms = %v, wants [b d c]", ids)
+	}
+	if heavy := HeavyRooms(usages, 10); len(heavy) != 4 {
+		t.Fatalf("HeavyRooms(10) = %v rooms, wants 4", len(heavy))
+	}
+}
+
+func TestPropsBytes(t *testing.T) {
+	r, clients, _ := newSwitchRoom(t, 2)
+	r.repo = &Repository{}
+	sender, other := clients[0], clients[1]
+	sender.props = make(binary.Dict)
+	other.ClientInfo.Props = make([]byte, 10)
+	other.removed = make(chan struct{})
+
+	// プロパティの変更と退室で更新する
+	r.dispatch(newTestMsg(t, sender, binary.MsgTypeClientProp, binary.MarshalClientPropPayload(binary.Dict{
+		"key": binary.MarshalInt(1),
+	})))
+	want := int64(len(sender.ClientInfo.Props) + 10)
+	if n := r.propsBytes.Load(); n != want {
+		t.Fatalf("propsBytes = %v, wants %v", n, want)
+	}
+	r.muClients.Lock()
+	r.removePlayer(other, "leave", nil)
+	r.muClients.Unlock()
+	want -= 10
+	if n := r.propsBytes.Load(); n != want {
+		t.Fatalf("propsBytes after leave = %v, wants %v", n, want)
+	}
+}
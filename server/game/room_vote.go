@@ -0,0 +1,163 @@
+package game
+
+import (
+	"time"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+)
+
+// vote : 部屋内の投票
+type vote struct {
+	id      string
+	options []string
+	votes   map[ClientID]int
+	timer   common.Timer // 部屋の一時停止中はnil
+
+	end    time.Time     // 締め切り時刻
+	remain time.Duration // 一時停止したときの残り時間
+}
+
+// startVoteTimer : dの後に締め切る
+func (r *Room) startVoteTimer(v *vote, d time.Duration) {
+	v.end = r.clock.Now().Add(d)
+	v.timer = r.clock.AfterFunc(d, func() {
+		r.SendMessage(&MsgVoteTimeout{v})
+	})
+}
+
+// pauseVoteTimer : 部屋の一時停止中は締め切りを止め、残り時間を覚えておく
+func (r *Room) pauseVoteTimer(v *vote) {
+	if v.timer == nil {
+		return
+	}
+	v.timer.Stop()
+	v.timer = nil
+	v.remain = v.end.Sub(r.clock.Now())
+	if v.remain < 0 {
+		v.remain = 0
+	}
+}
+
+func (v *vote) result() *binary.RegularEvent {
+	counts := make([]int, len(v.options))
+	votes := make(binary.Dict, len(v.votes))
+	for id, o := range v.votes {
+		counts[o]++
+		votes[string(id)] = binary.MarshalByte(o)
+	}
+	return binary.NewEvVoteResult(v.id, counts, votes)
+}
+
+// finishVote : 投票を締め切り結果を通知する.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) finishVote(v *vote) {
+	if v.timer != nil {
+		v.timer.Stop()
+	}
+	delete(r.votes, v.id)
+	r.logger.Infof("vote finished: %v %v", v.id, v.votes)
+	r.broadcast(v.result())
+}
+
+// checkVotes : 全Playerが投票済みの投票を締め切る.
+// muClients のロックを取得してから呼び出す.
+func (r *Room) checkVotes() {
+	for _, v := range r.votes {
+		voted := true
+		for id := range r.players {
+			if _, ok := v.votes[id]; !ok {
+				voted = false
+				break
+			}
+		}
+		if voted {
+			r.finishVote(v)
+		}
+	}
+}
+
+func (r *Room) msgStartVote(msg *MsgStartVote) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if !msg.Sender.isPlayer.Load() {
+		msg.Sender.logger.Warnf("sender %q is not a player", msg.Sender.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if r.players[msg.SenderID()] != msg.Sender {
+		return
+	}
+	if _, ok := r.votes[msg.VoteId]; ok {
+		msg.Sender.logger.Warnf("vote %q is already started", msg.VoteId)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if len(msg.Options) == 0 || msg.Duration == 0 {
+		msg.Sender.logger.Warnf("invalid vote: options=%v duration=%v", msg.Options, msg.Duration)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	v := &vote{
+		id:      msg.VoteId,
+		options: msg.Options,
+		votes:   make(map[ClientID]int),
+	}
+	if d := time.Duration(msg.Duration) * time.Second; r.paused != nil {
+		v.remain = d
+	} else {
+		r.startVoteTimer(v, d)
+	}
+	r.votes[v.id] = v
+
+	msg.Sender.logger.Infof("vote started: %v %v (%vs)", v.id, v.options, msg.Duration)
+
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+	r.broadcast(binary.NewEvVoteStarted(msg.Sender.Id, msg.MsgStartVotePayload))
+}
+
+func (r *Room) msgCastVote(msg *MsgCastVote) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if !msg.Sender.isPlayer.Load() {
+		msg.Sender.logger.Warnf("sender %q is not a player", msg.Sender.Id)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+	if r.players[msg.SenderID()] != msg.Sender {
+		return
+	}
+
+	v, ok := r.votes[msg.VoteId]
+	if !ok {
+		msg.Sender.logger.Infof("vote %s is not found", msg.VoteId)
+		r.sendTo(msg.Sender, binary.NewEvTargetNotFound(msg, []string{msg.VoteId}))
+		return
+	}
+	if msg.Option >= len(v.options) {
+		msg.Sender.logger.Warnf("vote %v: invalid option %v", v.id, msg.Option)
+		r.sendTo(msg.Sender, binary.NewEvPermissionDenied(msg))
+		return
+	}
+
+	msg.Sender.logger.Debugf("vote %v: %v", v.id, msg.Option)
+
+	v.votes[msg.SenderID()] = msg.Option
+	r.sendTo(msg.Sender, binary.NewEvSucceeded(msg))
+
+	r.checkVotes()
+}
+
+func (r *Room) msgVoteTimeout(msg *MsgVoteTimeout) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	// 締め切り済みの投票は無視する
+	if r.votes[msg.Vote.id] != msg.Vote {
+		return
+	}
+	r.finishVote(msg.Vote)
+}
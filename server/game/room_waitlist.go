@@ -0,0 +1,131 @@
+package game
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc/codes"
+)
+
+// 満室の部屋の順番待ち:
+// 満室で入室できなかったクライアントは、Lobby経由 (gRPC WaitSeat) で部屋の順番待ちの列に並べる.
+// 席が空くと列の先頭から順に wait_list_timeout の間席を確保する (see: room_merge.go reserved).
+// 席を確保されたクライアントは通常のJoinで入室する.
+// 列に並んだクライアントは wait_list_timeout 以内に問い合わせを繰り返さないと列から外れる.
+
+type waitingClient struct {
+	id     ClientID
+	expire time.Time
+}
+
+// WaitSeatStatus : 順番待ちの状態. Positionが0なら席を確保済みで、Expireまでに入室する
+type WaitSeatStatus struct {
+	Position uint32
+	Expire   time.Time
+}
+
+func (r *Room) msgWaitSeat(msg *MsgWaitSeat) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	id := msg.ClientId
+	if msg.Leave {
+		r.leaveWaitList(id)
+		msg.Res <- WaitSeatStatus{}
+		return
+	}
+
+	if r.closing || !r.Joinable || r.watchOnly {
+		msg.Err <- WithCode(xerrors.Errorf("room is not joinable: room=%v", r.Id), codes.FailedPrecondition)
+		return
+	}
+	if _, ok := r.players[id]; ok {
+		msg.Err <- WithCode(xerrors.Errorf("already joined: room=%v client=%v", r.Id, id), codes.AlreadyExists)
+		return
+	}
+	if until, ok := r.banned[id]; ok && r.clock.Now().Before(until) {
+		msg.Err <- WithCode(xerrors.Errorf("client is banned: room=%v client=%v until=%v", r.Id, id, until), codes.PermissionDenied)
+		return
+	}
+
+	r.grantWaitingSeats()
+
+	// 確保済み
+	if expire, ok := r.reserved[id]; ok && r.clock.Now().Before(expire) {
+		msg.Res <- WaitSeatStatus{Expire: expire}
+		return
+	}
+
+	expire := r.clock.Now().Add(time.Duration(r.conf.WaitListTimeout))
+	for i := range r.waitList {
+		if r.waitList[i].id == id {
+			r.waitList[i].expire = expire
+			msg.Res <- WaitSeatStatus{Position: uint32(i + 1), Expire: expire}
+			return
+		}
+	}
+	if len(r.waitList) >= r.conf.MaxWaitList {
+		msg.Err <- WithCode(xerrors.Errorf("wait list is full: room=%v max=%v", r.Id, r.conf.MaxWaitList), codes.ResourceExhausted)
+		return
+	}
+	r.waitList = append(r.waitList, waitingClient{id, expire})
+	r.logger.Infof("wait list: %v position=%v", id, len(r.waitList))
+
+	// 席が空いていれば並んだ時点で確保する
+	r.grantWaitingSeats()
+	if expire, ok := r.reserved[id]; ok {
+		msg.Res <- WaitSeatStatus{Expire: expire}
+		return
+	}
+	msg.Res <- WaitSeatStatus{Position: uint32(len(r.waitList)), Expire: expire}
+}
+
+// leaveWaitList : 順番待ちの列から外し、確保した席があれば解放する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) leaveWaitList(id ClientID) {
+	r.removeWaiting(id)
+	if _, ok := r.reserved[id]; ok {
+		delete(r.reserved, id)
+		r.grantWaitingSeats()
+	}
+}
+
+// grantWaitingSeats : 期限切れのクライアントを列から外し、空いている席を列の先頭から順に確保する.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) grantWaitingSeats() {
+	if len(r.waitList) == 0 {
+		return
+	}
+	now := r.clock.Now()
+	list := r.waitList[:0]
+	for _, w := range r.waitList {
+		if now.Before(w.expire) {
+			list = append(list, w)
+		}
+	}
+	r.waitList = list
+
+	for len(r.waitList) > 0 && r.Joinable && !r.closing && r.MaxPlayers > r.playerCount()+r.reservedSeats("") {
+		w := r.waitList[0]
+		r.waitList = r.waitList[1:]
+		if _, ok := r.players[w.id]; ok {
+			continue
+		}
+		if r.reserved == nil {
+			r.reserved = make(map[ClientID]time.Time)
+		}
+		r.reserved[w.id] = now.Add(time.Duration(r.conf.WaitListTimeout))
+		r.logger.Infof("wait list: seat granted: %v", w.id)
+	}
+}
+
+// removeWaiting : 入室したクライアントを列から外す.
+// muClients のロックを取得してから呼び出すこと
+func (r *Room) removeWaiting(id ClientID) {
+	for i := range r.waitList {
+		if r.waitList[i].id == id {
+			r.waitList = append(r.waitList[:i], r.waitList[i+1:]...)
+			return
+		}
+	}
+}
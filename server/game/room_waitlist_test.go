@@ -0,0 +1,86 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"wsnet2/config"
+)
+
+func TestWaitSeat(t *testing.T) {
+	r, clients, clock := newSwitchRoom(t, 2)
+	r.RoomInfo.Joinable = true
+	r.RoomInfo.MaxPlayers = 2
+	r.conf.MaxWaitList = 2
+	r.conf.WaitListTimeout = config.Duration(10 * time.Second)
+
+	wait := func(id ClientID, leave bool) (WaitSeatStatus, ErrorWithCode) {
+		t.Helper()
+		ch := make(chan WaitSeatStatus, 1)
+		errCh := make(chan ErrorWithCode, 1)
+		r.msgWaitSeat(&MsgWaitSeat{ClientId: id, Leave: leave, Res: ch, Err: errCh})
+		select {
+		case st := <-ch:
+			return st, nil
+		case err := <-errCh:
+			return WaitSeatStatus{}, err
+		}
+	}
+	position := func(id ClientID, want uint32) {
+		t.Helper()
+		st, err := wait(id, false)
+		if err != nil {
+			t.Fatalf("wait %v: %v", id, err)
+		}
+		if st.Position != want {
+			t.Fatalf("position of %v = %v, wants %v", id, st.Position, want)
+		}
+	}
+
+	if _, err := wait(clients[0].ID(), false); err == nil || err.Code() != codes.AlreadyExists {
+		t.Fatalf("player must not wait: %v", err)
+	}
+
+	position("a", 1)
+	position("b", 2)
+	position("a", 1)
+	if _, err := wait("c", false); err == nil || err.Code() != codes.ResourceExhausted {
+		t.Fatalf("wait list must be full: %v", err)
+	}
+
+	// 席が空いたら先頭に確保する
+	delete(r.players, clients[1].ID())
+	r.grantWaitingSeats()
+	position("a", 0)
+	position("b", 1)
+	if n := r.reservedSeats("a"); n != 0 {
+		t.Fatalf("reservedSeats(a) = %v, wants 0", n)
+	}
+	if n := r.reservedSeats(""); n != 1 {
+		t.Fatalf("reservedSeats = %v, wants 1", n)
+	}
+
+	// 確保した席を手放すと次に回る
+	if _, err := wait("a", true); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if _, ok := r.reserved["b"]; !ok {
+		t.Fatalf("seat must be granted to b: %v", r.reserved)
+	}
+	position("b", 0)
+
+	// 問い合わせが途絶えたら列から外れ、確保した席も解放する
+	position("c", 1)
+	clock.Advance(10 * time.Second)
+	position("d", 0)
+	if len(r.waitList) != 0 {
+		t.Fatalf("wait list must be empty: %v", r.waitList)
+	}
+
+	r.RoomInfo.Joinable = false
+	if _, err := wait("e", false); err == nil || err.Code() != codes.FailedPrecondition {
+		t.Fatalf("wait for non-joinable room must fail: %v", err)
+	}
+}
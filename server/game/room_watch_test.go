@@ -0,0 +1,59 @@
+package game
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+
+	"wsnet2/auth"
+	"wsnet2/pb"
+)
+
+func TestMsgWatchMaxWatchers(t *testing.T) {
+	r, _ := newRelayRoom(t, 1, 1)
+	r.RoomInfo.Watchable = true
+	r.RoomInfo.MaxWatchers = 3
+	// Hub経由の観戦者を含めて満員
+	r.RoomInfo.Watchers = 3
+
+	errCh := make(chan ErrorWithCode, 1)
+	r.msgWatch(&MsgWatch{
+		Info:   &pb.ClientInfo{Id: "watcher"},
+		Joined: make(chan *JoinedInfo, 1),
+		Err:    errCh,
+	})
+	select {
+	case err := <-errCh:
+		if err.Code() != codes.ResourceExhausted {
+			t.Fatalf("error code = %v, wants %v", err.Code(), codes.ResourceExhausted)
+		}
+	default:
+		t.Fatalf("msgWatch must fail when watchers are full")
+	}
+}
+
+func TestMsgWatchRequestId(t *testing.T) {
+	r, _ := newRelayRoom(t, 1, 1)
+	r.RoomInfo.Watchable = true
+	r.RoomInfo.MaxWatchers = 3
+	r.conf.MACAlgorithms = []string{auth.MACAlgorithmSHA1}
+
+	joined := make(chan *JoinedInfo, 1)
+	errCh := make(chan ErrorWithCode, 1)
+	r.msgWatch(&MsgWatch{
+		Info:      &pb.ClientInfo{Id: "watcher"},
+		Joined:    joined,
+		Err:       errCh,
+		RequestId: "req-1",
+	})
+	select {
+	case j := <-joined:
+		if j.Client.requestId != "req-1" {
+			t.Fatalf("requestId = %q, wants %q", j.Client.requestId, "req-1")
+		}
+	case err := <-errCh:
+		t.Fatalf("msgWatch failed: %v", err)
+	default:
+		t.Fatalf("msgWatch must reply")
+	}
+}
@@ -0,0 +1,42 @@
+package game
+
+import (
+	"wsnet2/binary"
+)
+
+// 観戦専用の部屋: RoomOption.MaxPlayersを0にしてMasterを指定せずに作る.
+// Playerは入室できず、イベントはappのサーバからpush APIで送る (EvTypeServerMessage).
+// クライアントは全て観戦者で、通常の観戦と同じように再接続できる.
+// 観戦者が居なくなっても部屋は閉じないので、AdminCloseか寿命で閉じる.
+
+// masterID : MasterのクライアントID. 観戦専用の部屋では空
+func (r *Room) masterID() ClientID {
+	if r.master == nil {
+		return ""
+	}
+	return r.master.ID()
+}
+
+// rejectWatchOnly : 観戦専用の部屋では退室と履歴の取得以外のクライアントからのメッセージを拒否する.
+// 拒否したらtrueを返す. RoomのMsgLoopから呼ばれる
+func (r *Room) rejectWatchOnly(msg Msg) bool {
+	if !r.watchOnly {
+		return false
+	}
+	switch msg.(type) {
+	case *MsgLeave, *MsgFetchHistory:
+		return false
+	}
+	rm, ok := msg.(binary.RegularMsg)
+	if !ok {
+		return false
+	}
+	sender, ok := r.clients.Load().watchers[msg.SenderID()]
+	if !ok {
+		return true
+	}
+	sender.logger.Infof("watch-only room rejects msg: %v", rm.Type())
+	// 送信への応答なのでwatcherDelayで遅らせない
+	r.sendNow(sender, binary.NewEvPermissionDenied(rm))
+	return true
+}
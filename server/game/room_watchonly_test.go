@@ -0,0 +1,49 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+
+	"wsnet2/binary"
+)
+
+func TestWatchOnlyRejectsMsg(t *testing.T) {
+	r, clients, _ := newSwitchRoom(t, 1)
+	w := clients[0]
+	delete(r.players, w.ID())
+	w.isPlayer.Store(false)
+	r.watchers[w.ID()] = w
+	r.master = nil
+	r.watchOnly = true
+	r.publishClients()
+
+	if id := r.masterID(); id != "" {
+		t.Fatalf("masterID = %q, wants empty", id)
+	}
+
+	tests := []struct {
+		msg  Msg
+		want bool
+	}{
+		{newTestMsg(t, w, binary.MsgTypeBroadcast, binary.MarshalStr8("hello")), true},
+		{newTestMsg(t, w, binary.MsgTypeToMaster, binary.MarshalStr8("hello")), true},
+		{newTestMsg(t, w, binary.MsgTypeLeave, binary.MarshalLeavePayload("bye")), false},
+		{&MsgPing{Sender: w}, false},
+	}
+	for _, tt := range tests {
+		if got := r.rejectWatchOnly(tt.msg); got != tt.want {
+			t.Errorf("rejectWatchOnly(%T) = %v, wants %v", tt.msg, got, tt.want)
+		}
+	}
+
+	var seq int
+	if types := eventTypes(w, &seq); !reflect.DeepEqual(types, []binary.EvType{binary.EvTypePermissionDenied, binary.EvTypePermissionDenied}) {
+		t.Fatalf("watcher events %v, wants 2 PermissionDenied", types)
+	}
+
+	// 通常の部屋では拒否しない
+	r.watchOnly = false
+	if r.rejectWatchOnly(tests[0].msg) {
+		t.Fatalf("rejectWatchOnly must be false in a normal room")
+	}
+}
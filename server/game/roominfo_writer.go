@@ -0,0 +1,194 @@
+package game
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+
+	"wsnet2/log"
+	"wsnet2/pb"
+)
+
+// roomInfoWriter : 部屋情報のroomテーブルへの書き込みをRepository単位でまとめる.
+//
+// 部屋ごとに最新の部屋情報だけを保持し、flushInterval毎 (溜まった部屋数がbatchSizeに達したらすぐ) に
+// 複数の部屋をまとめて1つのUPDATEで書き込む. DBへの反映は最大flushInterval (+書き込み時間) 遅れる.
+// flushIntervalが0なら待たずに書き込む (書き込み中に溜まった分はまとめる).
+//
+// 書き込みに失敗した部屋情報は書き込み待ちに戻し、間隔をあけてリトライする.
+// 部屋ごとに最新の情報だけを保持するので、DBが使えない間も溜まるのは部屋数までに収まる.
+type roomInfoWriter struct {
+	db            *sqlx.DB
+	flushInterval time.Duration
+	batchSize     int
+	retry         *dbRetry
+
+	mu      sync.Mutex
+	pending map[string]*pb.RoomInfo
+
+	full chan struct{}
+}
+
+func newRoomInfoWriter(db *sqlx.DB, flushInterval time.Duration, batchSize int, retryMax time.Duration) *roomInfoWriter {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	return &roomInfoWriter{
+		db:            db,
+		flushInterval: flushInterval,
+		batchSize:     batchSize,
+		retry:         newDBRetry("roomInfoWriter", retryMax),
+		pending:       make(map[string]*pb.RoomInfo),
+		full:          make(chan struct{}, 1),
+	}
+}
+
+// update : 部屋情報を書き込み待ちにする. 書き込み前の同じ部屋の情報は置き換える.
+func (w *roomInfoWriter) update(ri *pb.RoomInfo) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending[ri.Id] = ri
+	if len(w.pending) >= w.batchSize || w.flushInterval <= 0 {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// remove : 削除する部屋の書き込み待ちの情報を捨てる
+func (w *roomInfoWriter) remove(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.pending, id)
+}
+
+// run : 書き込み待ちの部屋情報を定期的に書き込む. Repositoryと同じくプロセス終了まで動き続ける.
+func (w *roomInfoWriter) run() {
+	var tick <-chan time.Time
+	if w.flushInterval > 0 {
+		t := time.NewTicker(w.flushInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-tick:
+		case <-w.full:
+		}
+		// 失敗したら書き込めるまでリトライする. その間の更新も書き込み待ちに溜まる
+		for {
+			err := w.flush(context.Background())
+			if err == nil {
+				w.retry.succeeded()
+				break
+			}
+			time.Sleep(w.retry.failed(err))
+		}
+	}
+}
+
+// requeue : 書き込めなかった部屋情報を書き込み待ちに戻す. 新しい情報があればそちらを残す
+func (w *roomInfoWriter) requeue(ris []*pb.RoomInfo) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ri := range ris {
+		if _, ok := w.pending[ri.Id]; !ok {
+			w.pending[ri.Id] = ri
+		}
+	}
+}
+
+// flush : 書き込み待ちの部屋情報をbatchSize毎にまとめて書き込む.
+// 失敗したら残りは書き込まずに書き込み待ちに戻してエラーを返す.
+func (w *roomInfoWriter) flush(ctx context.Context) error {
+	w.mu.Lock()
+	if len(w.pending) == 0 {
+		w.mu.Unlock()
+		return nil
+	}
+	ris := make([]*pb.RoomInfo, 0, len(w.pending))
+	for _, ri := range w.pending {
+		ris = append(ris, ri)
+	}
+	w.pending = make(map[string]*pb.RoomInfo)
+	w.mu.Unlock()
+
+	t1 := time.Now()
+	for len(ris) > 0 {
+		n := len(ris)
+		if n > w.batchSize {
+			n = w.batchSize
+		}
+		if err := w.write(ctx, ris[:n]); err != nil {
+			w.requeue(ris)
+			return xerrors.Errorf("update roominfo (%v rooms): %w", n, err)
+		}
+		ris = ris[n:]
+	}
+	if d := time.Since(t1); d > time.Second {
+		log.Infof("roomInfoWriter: took %v to write roominfo", d)
+	}
+	return nil
+}
+
+func (w *roomInfoWriter) write(ctx context.Context, ris []*pb.RoomInfo) error {
+	if err := chaosDBError(); err != nil {
+		return err
+	}
+	q, args, err := buildRoomUpdateQuery(w.db.Mapper.FieldByName, ris)
+	if err != nil {
+		return xerrors.Errorf("build query: %w", err)
+	}
+	if _, err := w.db.ExecContext(ctx, q, args...); err != nil {
+		return xerrors.Errorf("exec: %w", err)
+	}
+	return nil
+}
+
+// buildRoomUpdateQuery : 複数の部屋を更新するUPDATE文を作る.
+//
+//	UPDATE room SET col=CASE id WHEN ? THEN ? ... END, ... WHERE id IN (?, ...)
+//
+// 削除済みの部屋を作り直さないよう INSERT ... ON DUPLICATE KEY UPDATE は使わない.
+func buildRoomUpdateQuery(field func(reflect.Value, string) reflect.Value, ris []*pb.RoomInfo) (string, []any, error) {
+	if len(ris) == 0 {
+		return "", nil, xerrors.Errorf("no rooms")
+	}
+	vals := make([]reflect.Value, len(ris))
+	for i, ri := range ris {
+		vals[i] = reflect.ValueOf(ri).Elem()
+	}
+
+	var q strings.Builder
+	args := make([]any, 0, len(ris)*(2*len(roomUpdateCols)+1))
+	q.WriteString("UPDATE room SET ")
+	for i, col := range roomUpdateCols {
+		if i > 0 {
+			q.WriteString(",")
+		}
+		q.WriteString(col)
+		q.WriteString("=CASE id")
+		for j, v := range vals {
+			f := field(v, col)
+			if !f.IsValid() {
+				return "", nil, xerrors.Errorf("no field for column %q", col)
+			}
+			q.WriteString(" WHEN ? THEN ?")
+			args = append(args, ris[j].Id, f.Interface())
+		}
+		q.WriteString(" END")
+	}
+	q.WriteString(" WHERE id IN (?")
+	q.WriteString(strings.Repeat(",?", len(ris)-1))
+	q.WriteString(")")
+	for _, ri := range ris {
+		args = append(args, ri.Id)
+	}
+	return q.String(), args, nil
+}
@@ -0,0 +1,125 @@
+package game
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/xerrors"
+
+	"wsnet2/pb"
+)
+
+func TestBuildRoomUpdateQuery(t *testing.T) {
+	db, _ := newDbMock(t)
+	ris := []*pb.RoomInfo{
+		{Id: "room1", Players: 1},
+		{Id: "room2", Players: 2},
+	}
+
+	q, args, err := buildRoomUpdateQuery(db.Mapper.FieldByName, ris)
+	if err != nil {
+		t.Fatalf("buildRoomUpdateQuery: %+v", err)
+	}
+	if !strings.HasPrefix(q, "UPDATE room SET ") || !strings.HasSuffix(q, " WHERE id IN (?,?)") {
+		t.Fatalf("query = %v", q)
+	}
+	if !strings.Contains(q, "players=CASE id WHEN ? THEN ? WHEN ? THEN ? END") {
+		t.Fatalf("query does not contain players: %v", q)
+	}
+	if strings.Contains(q, " id=CASE") {
+		t.Fatalf("query must not update id: %v", q)
+	}
+	if n := strings.Count(q, "?"); n != len(args) {
+		t.Fatalf("placeholders = %v, args = %v", n, len(args))
+	}
+
+	var players []any
+	for i, c := range roomUpdateCols {
+		if c == "players" {
+			players = args[i*4 : i*4+4]
+		}
+	}
+	want := []any{"room1", uint32(1), "room2", uint32(2)}
+	for i := range want {
+		if players[i] != want[i] {
+			t.Fatalf("players args = %v, wants %v", players, want)
+		}
+	}
+	if ids := args[len(args)-2:]; ids[0] != "room1" || ids[1] != "room2" {
+		t.Fatalf("where args = %v", ids)
+	}
+}
+
+func TestRoomInfoWriter(t *testing.T) {
+	db, mock := newDbMock(t)
+	w := newRoomInfoWriter(db, 0, 2, time.Second)
+
+	// 同じ部屋の更新はまとめられ、削除した部屋は書き込まない
+	w.update(&pb.RoomInfo{Id: "room1", Players: 1})
+	w.update(&pb.RoomInfo{Id: "room2", Players: 1})
+	w.update(&pb.RoomInfo{Id: "room1", Players: 2})
+	w.update(&pb.RoomInfo{Id: "room3", Players: 1})
+	w.update(&pb.RoomInfo{Id: "room4", Players: 1})
+	w.remove("room4")
+
+	// batchSize毎に分けて書き込む
+	q := regexp.QuoteMeta("UPDATE room SET ")
+	mock.ExpectExec(q + ".* WHERE id IN \\(\\?,\\?\\)").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(q + ".* WHERE id IN \\(\\?\\)").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := w.flush(context.Background()); err != nil {
+		t.Fatalf("flush: %+v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+	if len(w.pending) != 0 {
+		t.Fatalf("pending = %v", w.pending)
+	}
+
+	// 書き込み待ちが無ければ何もしない
+	if err := w.flush(context.Background()); err != nil {
+		t.Fatalf("flush: %+v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRoomInfoWriterRequeue(t *testing.T) {
+	db, mock := newDbMock(t)
+	w := newRoomInfoWriter(db, 0, 1, time.Second)
+
+	w.update(&pb.RoomInfo{Id: "room1", Players: 1})
+	w.update(&pb.RoomInfo{Id: "room2", Players: 1})
+
+	// 失敗したら残りも含めて書き込み待ちに戻す
+	mock.ExpectExec("UPDATE room SET ").WillReturnError(xerrors.Errorf("connection refused"))
+	if err := w.flush(context.Background()); err == nil {
+		t.Fatalf("flush must fail")
+	}
+	if len(w.pending) != 2 {
+		t.Fatalf("pending = %v, wants 2 rooms", w.pending)
+	}
+
+	// 失敗中に更新された部屋は新しい情報が残る
+	w.update(&pb.RoomInfo{Id: "room1", Players: 2})
+	mock.ExpectExec("UPDATE room SET ").WillReturnError(xerrors.Errorf("connection refused"))
+	w.flush(context.Background())
+	if ri := w.pending["room1"]; ri == nil || ri.Players != 2 {
+		t.Fatalf("pending room1 = %v, wants Players=2", ri)
+	}
+
+	mock.ExpectExec("UPDATE room SET ").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE room SET ").WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := w.flush(context.Background()); err != nil {
+		t.Fatalf("flush: %+v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+}
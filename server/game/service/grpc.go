@@ -9,10 +9,16 @@ import (
 	"golang.org/x/xerrors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 
+	"wsnet2/game"
 	"wsnet2/log"
+	"wsnet2/metrics"
 	"wsnet2/pb"
+	"wsnet2/requestid"
 )
 
 func (sv *GameService) serveGRPC(ctx context.Context) <-chan error {
@@ -20,17 +26,40 @@ func (sv *GameService) serveGRPC(ctx context.Context) <-chan error {
 
 	sv.preparation.Add(1)
 	go func() {
-		laddr := fmt.Sprintf(":%d", sv.conf.GRPCPort)
-		log.Infof("game grpc: %#v", laddr)
-
-		listenPort, err := net.Listen("tcp", laddr)
-		if err != nil {
-			errCh <- xerrors.Errorf("listen error: %w", err)
-			return
+		listenPort := sv.opts.GRPCListener
+		if listenPort == nil {
+			laddr := fmt.Sprintf(":%d", sv.conf.GRPCPort)
+			log.Infof("game grpc: %#v", laddr)
+
+			var err error
+			listenPort, err = net.Listen("tcp", laddr)
+			if err != nil {
+				errCh <- xerrors.Errorf("listen error: %w", err)
+				return
+			}
+		} else {
+			log.Infof("game grpc: %v", listenPort.Addr())
 		}
 
-		server := grpc.NewServer()
+		opts := []grpc.ServerOption{
+			grpc.KeepaliveParams(keepalive.ServerParameters{
+				Time:    time.Duration(sv.conf.GRPCKeepaliveTime),
+				Timeout: time.Duration(sv.conf.GRPCKeepaliveTimeout),
+			}),
+			grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+				MinTime:             time.Duration(sv.conf.GRPCKeepaliveMinTime),
+				PermitWithoutStream: true,
+			}),
+			grpc.ChainUnaryInterceptor(requestid.UnaryServerInterceptor),
+			grpc.ChainStreamInterceptor(requestid.StreamServerInterceptor),
+		}
+		server := grpc.NewServer(append(opts, sv.opts.GRPCServerOptions...)...)
 		pb.RegisterGameServer(server, sv)
+		// grpcurlやロードバランサのヘルスチェックなど標準のツールから使えるようにする
+		healthpb.RegisterHealthServer(server, sv.health)
+		reflection.Register(server)
+		sv.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+		sv.health.SetServingStatus(pb.Game_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
 
 		c := make(chan error)
 		go func() {
@@ -54,13 +83,14 @@ func (sv *GameService) Create(ctx context.Context, in *pb.CreateRoomReq) (*pb.Jo
 	logger := log.GetLoggerWith(
 		log.KeyHandler, "grpc:Create",
 		log.KeyApp, in.AppId,
-		log.KeyClient, in.MasterInfo.Id,
+		log.KeyClient, in.GetMasterInfo().GetId(),
 		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
 	)
-	sv.fillRoomOption(in.RoomOption)
+	sv.fillRoomOption(in.RoomOption, in.MasterInfo == nil)
 	logger.Debugf("gRPC Create: %v %v", in.RoomOption, in.MasterInfo)
 
-	repo, ok := sv.repos[in.AppId]
+	repo, ok := sv.repo(in.AppId)
 	if !ok {
 		logger.Errorf("invalid app_id: %v", in.AppId)
 		return nil, status.Errorf(codes.NotFound, "Invalid app_id: %v", in.AppId)
@@ -79,16 +109,20 @@ func (sv *GameService) Create(ctx context.Context, in *pb.CreateRoomReq) (*pb.Jo
 	return res, nil
 }
 
-func (sv *GameService) fillRoomOption(op *pb.RoomOption) {
+// fillRoomOption : 省略された値を既定値で埋める. 観戦専用の部屋 (watchOnly) はMaxPlayersを0のままにする
+func (sv *GameService) fillRoomOption(op *pb.RoomOption, watchOnly bool) {
 	if op.ClientDeadline == 0 {
 		op.ClientDeadline = sv.conf.DefaultDeadline
 	}
-	if op.MaxPlayers == 0 {
+	if op.MaxPlayers == 0 && !watchOnly {
 		op.MaxPlayers = sv.conf.DefaultMaxPlayers
 	}
 	if op.LogLevel == 0 {
 		op.LogLevel = sv.conf.DefaultLoglevel
 	}
+	if op.PingInterval == 0 {
+		op.PingInterval = uint32(time.Duration(sv.conf.DefaultPingInterval) / time.Millisecond)
+	}
 }
 
 func (sv *GameService) Join(ctx context.Context, in *pb.JoinRoomReq) (*pb.JoinedRoomRes, error) {
@@ -98,16 +132,17 @@ func (sv *GameService) Join(ctx context.Context, in *pb.JoinRoomReq) (*pb.Joined
 		log.KeyClient, in.ClientInfo.Id,
 		log.KeyRoom, in.RoomId,
 		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
 	)
 	logger.Debugf("gRPC Join: %v %v", in.RoomId, in.ClientInfo)
 
-	repo, ok := sv.repos[in.AppId]
+	repo, ok := sv.repo(in.AppId)
 	if !ok {
 		logger.Errorf("invalid app_id: %v", in.AppId)
 		return nil, status.Errorf(codes.Internal, "Invalid app_id: %v", in.AppId)
 	}
 
-	res, err := repo.JoinRoom(ctx, in.RoomId, in.ClientInfo, in.MacKey)
+	res, err := repo.JoinRoom(ctx, in.RoomId, in.ClientInfo, in.MacKey, in.InviteToken)
 	if err != nil {
 		logger.Errorf("repo.JoinRoom: %+v", err)
 		return nil, status.Errorf(err.Code(), "JoinRoom failed: %s", err)
@@ -127,10 +162,11 @@ func (sv *GameService) Watch(ctx context.Context, in *pb.JoinRoomReq) (*pb.Joine
 		log.KeyClient, in.ClientInfo.Id,
 		log.KeyRoom, in.RoomId,
 		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
 	)
 	logger.Debugf("gRPC Watch: %v %v", in.RoomId, in.ClientInfo)
 
-	repo, ok := sv.repos[in.AppId]
+	repo, ok := sv.repo(in.AppId)
 	if !ok {
 		logger.Errorf("invalid app_id: %v", in.AppId)
 		return nil, status.Errorf(codes.Internal, "Invalid app_id: %v", in.AppId)
@@ -155,9 +191,10 @@ func (sv *GameService) GetRoomInfo(ctx context.Context, in *pb.GetRoomInfoReq) (
 		log.KeyApp, in.AppId,
 		log.KeyRoom, in.RoomId,
 		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
 	)
 	logger.Debugf("gRPC GetRoomInfo: %v", in.RoomId)
-	repo, ok := sv.repos[in.AppId]
+	repo, ok := sv.repo(in.AppId)
 	if !ok {
 		logger.Errorf("invalid app_id: %v", in.AppId)
 		return nil, status.Errorf(codes.Internal, "Invalid app_id: %v", in.AppId)
@@ -173,6 +210,44 @@ func (sv *GameService) GetRoomInfo(ctx context.Context, in *pb.GetRoomInfoReq) (
 	return res, err
 }
 
+func (sv *GameService) SubscribeRoomInfo(in *pb.GetRoomInfoReq, stream pb.Game_SubscribeRoomInfoServer) error {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "grpc:SubscribeRoomInfo",
+		log.KeyApp, in.AppId,
+		log.KeyRoom, in.RoomId,
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(stream.Context()),
+	)
+	logger.Debugf("gRPC SubscribeRoomInfo: %v", in.RoomId)
+	repo, ok := sv.repo(in.AppId)
+	if !ok {
+		logger.Errorf("invalid app_id: %v", in.AppId)
+		return status.Errorf(codes.Internal, "Invalid app_id: %v", in.AppId)
+	}
+	sub, err := repo.SubscribeRoomInfo(in.RoomId)
+	if err != nil {
+		logger.Infof("repo.SubscribeRoomInfo: %+v", err)
+		return status.Errorf(err.Code(), "SubscribeRoomInfo failed: %s", err)
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			logger.Debugf("gRPC SubscribeRoomInfo canceled")
+			return nil
+		case <-sub.Done():
+			logger.Infof("gRPC SubscribeRoomInfo: room closed")
+			return nil
+		case ri := <-sub.C():
+			if err := stream.Send(ri); err != nil {
+				logger.Infof("gRPC SubscribeRoomInfo send: %+v", err)
+				return err
+			}
+		}
+	}
+}
+
 func (sv *GameService) Kick(ctx context.Context, in *pb.KickReq) (*pb.Empty, error) {
 	logger := log.GetLoggerWith(
 		log.KeyHandler, "grcp:Kick",
@@ -180,9 +255,10 @@ func (sv *GameService) Kick(ctx context.Context, in *pb.KickReq) (*pb.Empty, err
 		log.KeyRoom, in.RoomId,
 		log.KeyClient, in.ClientId,
 		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
 	)
 	logger.Debugf("gRPC Kick: %v %v", in.RoomId, in.ClientId)
-	repo, ok := sv.repos[in.AppId]
+	repo, ok := sv.repo(in.AppId)
 	if !ok {
 		logger.Errorf("invalid app_id: %v", in.AppId)
 		return nil, status.Errorf(codes.Internal, "Invalid app_id: %v", in.AppId)
@@ -197,3 +273,268 @@ func (sv *GameService) Kick(ctx context.Context, in *pb.KickReq) (*pb.Empty, err
 
 	return &pb.Empty{}, nil
 }
+
+func (sv *GameService) CloseRoom(ctx context.Context, in *pb.CloseRoomReq) (*pb.Empty, error) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "grpc:CloseRoom",
+		log.KeyApp, in.AppId,
+		log.KeyRoom, in.RoomId,
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
+	)
+	logger.Debugf("gRPC CloseRoom: %v %q", in.RoomId, in.Reason)
+	repo, ok := sv.repo(in.AppId)
+	if !ok {
+		logger.Errorf("invalid app_id: %v", in.AppId)
+		return nil, status.Errorf(codes.Internal, "Invalid app_id: %v", in.AppId)
+	}
+	err := repo.AdminCloseRoom(ctx, in.RoomId, in.Reason)
+	if err != nil {
+		logger.Errorf("repo.AdminCloseRoom: %+v", err)
+		return nil, status.Errorf(err.Code(), "CloseRoom failed: %s", err)
+	}
+
+	logger.Infof("gRPC CloseRoom OK: room=%q reason=%q", in.RoomId, in.Reason)
+
+	return &pb.Empty{}, nil
+}
+
+// MergeRoom : 部屋を同じgameサーバの別の部屋に統合する
+func (sv *GameService) MergeRoom(ctx context.Context, in *pb.MergeRoomReq) (*pb.Empty, error) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "grpc:MergeRoom",
+		log.KeyApp, in.AppId,
+		log.KeyRoom, in.RoomId,
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
+	)
+	logger.Debugf("gRPC MergeRoom: %v -> %v", in.RoomId, in.TargetId)
+	repo, ok := sv.repo(in.AppId)
+	if !ok {
+		logger.Errorf("invalid app_id: %v", in.AppId)
+		return nil, status.Errorf(codes.Internal, "Invalid app_id: %v", in.AppId)
+	}
+	err := repo.AdminMergeRoom(ctx, in.RoomId, in.TargetId)
+	if err != nil {
+		logger.Errorf("repo.AdminMergeRoom: %+v", err)
+		return nil, status.Errorf(err.Code(), "MergeRoom failed: %s", err)
+	}
+
+	logger.Infof("gRPC MergeRoom OK: room=%q target=%q", in.RoomId, in.TargetId)
+
+	return &pb.Empty{}, nil
+}
+
+// SetNetSim : 部屋のイベントの送信に遅延と欠落を加える (開発用)
+func (sv *GameService) SetNetSim(ctx context.Context, in *pb.SetNetSimReq) (*pb.Empty, error) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "grpc:SetNetSim",
+		log.KeyApp, in.AppId,
+		log.KeyRoom, in.RoomId,
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
+	)
+	logger.Debugf("gRPC SetNetSim: %v latency=%v jitter=%v drop=%v", in.RoomId, in.Latency, in.Jitter, in.DropRate)
+	repo, ok := sv.repo(in.AppId)
+	if !ok {
+		logger.Errorf("invalid app_id: %v", in.AppId)
+		return nil, status.Errorf(codes.Internal, "Invalid app_id: %v", in.AppId)
+	}
+	if in.DropRate < 0 || in.DropRate > 1 {
+		logger.Errorf("invalid drop_rate: %v", in.DropRate)
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid drop_rate: %v", in.DropRate)
+	}
+	err := repo.AdminSetNetSim(in.RoomId, &game.NetSim{
+		Latency:  time.Duration(in.Latency) * time.Millisecond,
+		Jitter:   time.Duration(in.Jitter) * time.Millisecond,
+		DropRate: in.DropRate,
+	})
+	if err != nil {
+		logger.Errorf("repo.AdminSetNetSim: %+v", err)
+		return nil, status.Errorf(err.Code(), "SetNetSim failed: %s", err)
+	}
+
+	logger.Infof("gRPC SetNetSim OK: room=%q latency=%v jitter=%v drop=%v", in.RoomId, in.Latency, in.Jitter, in.DropRate)
+
+	return &pb.Empty{}, nil
+}
+
+// ServerMessage : appのサーバからのメッセージを部屋またはクライアントに送る
+func (sv *GameService) ServerMessage(ctx context.Context, in *pb.ServerMessageReq) (*pb.ServerMessageRes, error) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "grpc:ServerMessage",
+		log.KeyApp, in.AppId,
+		log.KeyRoom, in.RoomId,
+		log.KeyClient, in.ClientId,
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
+	)
+	logger.Debugf("gRPC ServerMessage: room=%q client=%q %v bytes", in.RoomId, in.ClientId, len(in.Data))
+
+	// 部屋が無いappは読み込まない
+	repo, ok := sv.loadedRepo(in.AppId)
+	if !ok {
+		if in.RoomId != "" {
+			return nil, status.Errorf(codes.NotFound, "room not found: %v", in.RoomId)
+		}
+		return &pb.ServerMessageRes{}, nil
+	}
+
+	rooms, err := repo.ServerMessage(ctx, in.RoomId, in.ClientId, in.Data, logger)
+	if err != nil {
+		logger.Infof("repo.ServerMessage: %+v", err)
+		return nil, status.Errorf(err.Code(), "ServerMessage failed: %s", err)
+	}
+
+	logger.Infof("gRPC ServerMessage OK: rooms=%v", rooms)
+
+	return &pb.ServerMessageRes{Rooms: uint32(rooms)}, nil
+}
+
+// WaitSeat : 満室の部屋の順番待ちの列に並び、順番を返す
+func (sv *GameService) WaitSeat(ctx context.Context, in *pb.WaitSeatReq) (*pb.WaitSeatRes, error) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "grpc:WaitSeat",
+		log.KeyApp, in.AppId,
+		log.KeyRoom, in.RoomId,
+		log.KeyClient, in.ClientId,
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
+	)
+	logger.Debugf("gRPC WaitSeat: %v %v leave=%v", in.RoomId, in.ClientId, in.Leave)
+	repo, ok := sv.repo(in.AppId)
+	if !ok {
+		logger.Errorf("invalid app_id: %v", in.AppId)
+		return nil, status.Errorf(codes.Internal, "Invalid app_id: %v", in.AppId)
+	}
+	st, err := repo.WaitSeat(ctx, in.RoomId, in.ClientId, in.Leave)
+	if err != nil {
+		logger.Infof("repo.WaitSeat: %+v", err)
+		return nil, status.Errorf(err.Code(), "WaitSeat failed: %s", err)
+	}
+
+	logger.Infof("gRPC WaitSeat OK: room=%q user=%q position=%v", in.RoomId, in.ClientId, st.Position)
+
+	res := &pb.WaitSeatRes{Position: st.Position}
+	if !st.Expire.IsZero() {
+		res.Expire = st.Expire.Unix()
+	}
+	return res, nil
+}
+
+// ReserveSeat : 招待されたユーザの席を招待の期限まで確保する
+func (sv *GameService) ReserveSeat(ctx context.Context, in *pb.ReserveSeatReq) (*pb.Empty, error) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "grpc:ReserveSeat",
+		log.KeyApp, in.AppId,
+		log.KeyRoom, in.RoomId,
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
+	)
+	logger.Debugf("gRPC ReserveSeat: %v", in.RoomId)
+	repo, ok := sv.repo(in.AppId)
+	if !ok {
+		logger.Errorf("invalid app_id: %v", in.AppId)
+		return nil, status.Errorf(codes.Internal, "Invalid app_id: %v", in.AppId)
+	}
+	if err := repo.ReserveSeat(ctx, in.RoomId, in.InviteToken); err != nil {
+		logger.Infof("repo.ReserveSeat: %+v", err)
+		return nil, status.Errorf(err.Code(), "ReserveSeat failed: %s", err)
+	}
+
+	logger.Infof("gRPC ReserveSeat OK: room=%q", in.RoomId)
+	return &pb.Empty{}, nil
+}
+
+// CleanupRooms : 残骸になったroomテーブルの行を片付ける
+func (sv *GameService) CleanupRooms(ctx context.Context, in *pb.CleanupRoomsReq) (*pb.CleanupRoomsRes, error) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "grpc:CleanupRooms",
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
+	)
+	logger.Debugf("gRPC CleanupRooms: dry_run=%v", in.DryRun)
+
+	ids, err := sv.cleanupRooms(ctx, in.DryRun)
+	if err != nil {
+		logger.Errorf("cleanupRooms: %+v", err)
+		return nil, status.Errorf(codes.Internal, "CleanupRooms failed: %s", err)
+	}
+
+	logger.Infof("gRPC CleanupRooms OK: dry_run=%v rooms=%v", in.DryRun, ids)
+
+	return &pb.CleanupRoomsRes{HostId: uint32(sv.HostId), RoomIds: ids}, nil
+}
+
+// GetAppStats : appの部屋数、プレイヤー数、メッセージ数などを返す
+func (sv *GameService) GetAppStats(ctx context.Context, in *pb.AppStatsReq) (*pb.AppStatsRes, error) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "grpc:GetAppStats",
+		log.KeyApp, in.AppId,
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
+	)
+	logger.Debugf("gRPC GetAppStats")
+
+	res := &pb.AppStatsRes{HostId: uint32(sv.HostId)}
+
+	// まだ部屋が作られていないappは読み込まずに0を返す
+	if repo, ok := sv.loadedRepo(in.AppId); ok {
+		players, watchers := repo.ClientCount()
+		res.Rooms = uint32(repo.GetRoomCount())
+		res.Players = uint32(players)
+		res.Watchers = uint32(watchers)
+
+		usages := repo.RoomUsages()
+		for _, u := range usages {
+			res.RoomBytes += u.Bytes()
+			res.RoomGoroutines += u.Goroutines
+		}
+		res.HeavyRooms = game.HeavyRooms(usages, sv.conf.HeavyRooms)
+	}
+
+	stat := metrics.GetAppStat(in.AppId)
+	res.Conns = uint32(stat.Conns)
+	res.MessageRecv = uint64(stat.MessageRecv)
+	res.MessageSent = uint64(stat.MessageSent)
+	res.BytesIn = uint64(stat.BytesRecv)
+	res.BytesOut = uint64(stat.BytesSent)
+	res.MessageRecvRate = stat.MessageRecvRate
+	res.MessageSentRate = stat.MessageSentRate
+
+	logger.Debugf("gRPC GetAppStats OK: %v", res)
+
+	return res, nil
+}
+
+func (sv *GameService) AdminMessage(ctx context.Context, in *pb.AdminMessageReq) (*pb.AdminMessageRes, error) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "grpc:AdminMessage",
+		log.KeyApp, in.AppId,
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
+	)
+	logger.Debugf("gRPC AdminMessage: %q", in.Message)
+
+	// app_idが空のときは全appに送る
+	var repos []*game.Repository
+	if in.AppId != "" {
+		repo, ok := sv.repo(in.AppId)
+		if !ok {
+			logger.Errorf("invalid app_id: %v", in.AppId)
+			return nil, status.Errorf(codes.NotFound, "Invalid app_id: %v", in.AppId)
+		}
+		repos = []*game.Repository{repo}
+	} else {
+		repos = sv.allRepos()
+	}
+
+	var rooms int
+	for _, repo := range repos {
+		rooms += repo.AdminMessage(ctx, in.Message, logger)
+	}
+
+	logger.Infof("gRPC AdminMessage OK: rooms=%v message=%q", rooms, in.Message)
+
+	return &pb.AdminMessageRes{Rooms: uint32(rooms)}, nil
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"net"
+
+	"google.golang.org/grpc"
+
+	"wsnet2/config"
+	"wsnet2/middleware"
+)
+
+// Options : gameサーバを他のプロセスに組み込むときの設定. see NewWithOptions
+type Options struct {
+	// GRPCListener : gRPCの待ち受け. nilならconf.GRPCPortで待ち受ける
+	GRPCListener net.Listener
+	// WebsocketListener : websocketの待ち受け. nilならconf.WebsocketPortで待ち受ける.
+	// conf.TLSCertが設定されていればTLSで包む
+	WebsocketListener net.Listener
+	// GRPCServerOptions : gRPCサーバに追加するオプション (grpc.ChainUnaryInterceptorなど)
+	GRPCServerOptions []grpc.ServerOption
+	// WebsocketMiddlewares : websocket接続 (/room, /mux) のUpgrade前に適用するmiddleware.
+	// 設定のwebsocket_middlewareの後に適用する
+	WebsocketMiddlewares []middleware.Middleware
+}
+
+// applyListenerPorts : 待ち受けを渡されていてポートが未設定なら、待ち受けのポートをconfに設定する.
+// Lobbyやクライアントに通知するポートを ":0" で待ち受けたときにも合わせるため.
+func (o *Options) applyListenerPorts(conf *config.GameConf) {
+	if conf.GRPCPort == 0 {
+		if p := listenerPort(o.GRPCListener); p != 0 {
+			conf.GRPCPort = p
+		}
+	}
+	if conf.WebsocketPort == 0 {
+		if p := listenerPort(o.WebsocketListener); p != 0 {
+			conf.WebsocketPort = p
+		}
+	}
+}
+
+func listenerPort(l net.Listener) int {
+	if l == nil {
+		return 0
+	}
+	if addr, ok := l.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
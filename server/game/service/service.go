@@ -2,22 +2,34 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
 
+	"wsnet2/binary"
+	"wsnet2/bridge"
 	"wsnet2/common"
 	"wsnet2/config"
 	"wsnet2/game"
 	"wsnet2/log"
+	"wsnet2/metrics"
+	"wsnet2/moderation"
 	"wsnet2/pb"
+	"wsnet2/relay"
 )
 
 const (
+	// heavyRoomsInterval : メトリクスのheavy_roomsを更新する間隔. 取得のたびに全部屋を調べないようにする
+	heavyRoomsInterval = 10 * time.Second
+
 	registerQuery = "" +
-		"INSERT INTO `game_server` (`hostname`, `public_name`, `grpc_port`, `ws_port`, `status`) VALUES (:hostname, :public_name, :grpc_port, :ws_port, :status) " +
-		"ON DUPLICATE KEY UPDATE `public_name`=:public_name, `grpc_port`=:grpc_port, `ws_port`=:ws_port, `status`=:status, id=last_insert_id(id)"
+		"INSERT INTO `game_server` (`hostname`, `public_name`, `grpc_port`, `ws_port`, `ws_url`, `status`, `tag`) VALUES (:hostname, :public_name, :grpc_port, :ws_port, :ws_url, :status, :tag) " +
+		"ON DUPLICATE KEY UPDATE `public_name`=:public_name, `grpc_port`=:grpc_port, `ws_port`=:ws_port, `ws_url`=:ws_url, `status`=:status, `tag`=:tag, id=last_insert_id(id)"
 	heartbeatQuery = "" +
 		"UPDATE `game_server` SET `status`=:status, heartbeat=:now WHERE `id`=:hostid"
 )
@@ -27,36 +39,80 @@ type GameService struct {
 
 	HostId int64
 
-	conf  *config.GameConf
-	repos map[pb.AppId]*game.Repository
+	conf    *config.GameConf
+	repos   map[pb.AppId]*game.Repository
+	muRepos sync.RWMutex
 
 	db          *sqlx.DB
 	preparation sync.WaitGroup
 
 	wsURLFormat string
 
+	bridge    *bridge.Bridge        // nilならイベントを外部に配信しない
+	relay     *relay.Publisher      // nilならHubへのイベントを中継しない
+	moderator *moderation.Moderator // nilならメッセージを審査しない
+
+	// health : gRPCのヘルスチェック. Shutdown中はNOT_SERVINGを返す
+	health *health.Server
+
+	// heavy : heavyRoomsIntervalごとに更新するheavy_rooms ([]*pb.RoomUsage)
+	heavy atomic.Value
+
+	opts Options
+
 	shutdownChan chan struct{}
 	done         chan error
 }
 
 func New(db *sqlx.DB, conf *config.GameConf) (*GameService, error) {
+	return NewWithOptions(db, conf, Options{})
+}
+
+// NewWithOptions : 待ち受けやgRPCのinterceptorを指定してGameServiceを作る.
+// 他のプロセスに組み込むときに使い、Serveの終了やShutdownは呼び出し側で管理する.
+func NewWithOptions(db *sqlx.DB, conf *config.GameConf, opts Options) (*GameService, error) {
+	opts.applyListenerPorts(conf)
 	hostId, err := registerHost(db, conf)
 	if err != nil {
 		return nil, err
 	}
+	binary.SetUnmarshalLimits(binary.UnmarshalLimits{
+		MaxCount:  conf.UnmarshalMaxCount,
+		MaxDepth:  conf.UnmarshalMaxDepth,
+		MaxValues: conf.UnmarshalMaxValues,
+		MaxBytes:  conf.UnmarshalMaxBytes,
+	})
+	if conf.Chaos.Enabled {
+		log.Infof("chaos injection is enabled: %+v", conf.Chaos)
+	}
+	game.SetChaos(&conf.Chaos)
 	repos, err := game.NewRepos(db, conf, uint32(hostId))
 	if err != nil {
 		return nil, err
 	}
-	return &GameService{
+	s := &GameService{
 		HostId: hostId,
 		conf:   conf,
 		repos:  repos,
 		db:     db,
 
+		bridge:    bridge.New(&conf.Bridge, fmt.Sprintf("wsnet2-game-%d", hostId)),
+		relay:     relay.NewPublisher(&conf.Relay),
+		moderator: moderation.New(&conf.Moderation),
+		health:    health.NewServer(),
+		opts:      opts,
+
 		shutdownChan: make(chan struct{}),
 		done:         make(chan error),
-	}, nil
+	}
+	for _, repo := range repos {
+		s.setupRepo(repo)
+	}
+	metrics.SetQueueDepth(s.msgQueueDepth, s.eventQueueDepth)
+	s.heavy.Store([]*pb.RoomUsage{})
+	metrics.SetHeavyRooms(s.heavyRooms)
+	metrics.ServerTag.Set(conf.Tag)
+	return s, nil
 }
 
 func (s *GameService) Serve(ctx context.Context) error {
@@ -69,7 +125,13 @@ func (s *GameService) Serve(ctx context.Context) error {
 	case err = <-s.serveGRPC(ctx):
 	case err = <-s.serveWebSocket(ctx):
 	case err = <-s.servePprof(ctx):
+	case err = <-s.serveBridge(ctx):
+	case err = <-s.serveRelay(ctx):
+	case err = <-s.serveModeration(ctx):
 	case err = <-s.heartbeat(ctx):
+	case err = <-s.serveRoomCleanup(ctx):
+	case err = <-s.serveRetentionPurge(ctx):
+	case err = <-s.serveHeavyRooms(ctx):
 	case err = <-s.done:
 	}
 	return err
@@ -81,7 +143,9 @@ func registerHost(db *sqlx.DB, conf *config.GameConf) (int64, error) {
 		"public_name": conf.PublicName,
 		"grpc_port":   conf.GRPCPort,
 		"ws_port":     conf.WebsocketPort,
+		"ws_url":      roomURLPrefix(conf),
 		"status":      common.HostStatusRunning,
+		"tag":         conf.Tag,
 	}
 	res, err := sqlx.NamedExec(db, registerQuery, bind)
 	if err != nil {
@@ -90,6 +154,16 @@ func registerHost(db *sqlx.DB, conf *config.GameConf) (int64, error) {
 	return res.LastInsertId()
 }
 
+// roomURLPrefix : 部屋IDを後ろに付けると部屋のwebsocket URLになる文字列.
+// lobbyが部屋番号から接続先を引いたりwebsocketを中継したりできるようgame_server.ws_urlにも登録する.
+func roomURLPrefix(conf *config.GameConf) string {
+	scheme := "ws"
+	if conf.TLSCert != "" {
+		scheme = "wss"
+	}
+	return fmt.Sprintf("%s://%s:%d/room/", scheme, conf.PublicName, conf.WebsocketPort)
+}
+
 func (s *GameService) shutdownRequested() bool {
 	select {
 	case <-s.shutdownChan:
@@ -121,6 +195,8 @@ func (s *GameService) heartbeat(ctx context.Context) <-chan error {
 			"hostid": s.HostId,
 			"status": common.HostStatusRunning,
 		}
+		// DB障害中も部屋は動かし続ける. heartbeatが途切れるのでlobbyは新しい部屋を割り当てなくなる
+		failing := false
 		for {
 			select {
 			case <-ctx.Done():
@@ -136,8 +212,18 @@ func (s *GameService) heartbeat(ctx context.Context) <-chan error {
 			}
 
 			if _, err := sqlx.NamedExec(s.db, heartbeatQuery, bind); err != nil {
-				errCh <- err
-				return
+				metrics.DBWriteErrors.Add(1)
+				if !failing {
+					log.Errorf("heartbeat failed, retrying: %+v", err)
+					metrics.DBDegraded.Add(1)
+					failing = true
+				}
+				continue
+			}
+			if failing {
+				log.Infof("heartbeat recovered")
+				metrics.DBDegraded.Add(-1)
+				failing = false
 			}
 		}
 	}()
@@ -154,6 +240,7 @@ func (s *GameService) Shutdown(ctx context.Context) {
 	}
 	close(s.shutdownChan)
 	defer close(s.done)
+	s.health.Shutdown()
 
 	// Immediately execute a heartbeat query in order not to miss the status update
 	bind := map[string]interface{}{
@@ -166,6 +253,12 @@ func (s *GameService) Shutdown(ctx context.Context) {
 		return
 	}
 
+	for _, repo := range s.allRepos() {
+		if err := repo.ReleasePrewarmed(); err != nil {
+			log.Errorf("release prewarmed rooms: %+v", err)
+		}
+	}
+
 	// Wait for all the rooms to be closed
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
@@ -185,9 +278,249 @@ func (s *GameService) Shutdown(ctx context.Context) {
 	}
 }
 
+// loadedRepo : 読み込み済みのappのRepository
+func (s *GameService) loadedRepo(appId pb.AppId) (*game.Repository, bool) {
+	s.muRepos.RLock()
+	defer s.muRepos.RUnlock()
+	repo, ok := s.repos[appId]
+	return repo, ok
+}
+
+// repo : appのRepository. 起動後に登録されたappはDBから読み込む
+func (s *GameService) repo(appId pb.AppId) (*game.Repository, bool) {
+	if repo, ok := s.loadedRepo(appId); ok {
+		return repo, true
+	}
+
+	s.muRepos.Lock()
+	defer s.muRepos.Unlock()
+	if repo, ok := s.repos[appId]; ok {
+		return repo, true
+	}
+	repo, err := game.NewRepo(s.db, s.conf, uint32(s.HostId), appId)
+	if err != nil {
+		log.Infof("load app %v: %+v", appId, err)
+		return nil, false
+	}
+	s.setupRepo(repo)
+	s.repos[appId] = repo
+	return repo, true
+}
+
+// setupRepo : 部屋を作る前にRepositoryを設定する
+func (s *GameService) setupRepo(repo *game.Repository) {
+	if s.bridge != nil {
+		repo.SetEventPublisher(s.bridge)
+	}
+	if s.relay != nil {
+		repo.SetHubRelay(s.relay)
+	}
+	if s.moderator != nil {
+		repo.SetMessageScanner(s.moderator)
+	}
+}
+
+// serveRelay : Hubへのイベントを中継する
+func (s *GameService) serveRelay(ctx context.Context) <-chan error {
+	if s.relay == nil {
+		return nil
+	}
+	errCh := make(chan error)
+	go func() {
+		errCh <- s.relay.Serve(ctx)
+	}()
+	return errCh
+}
+
+// serveModeration : 中継したメッセージを審査サービスに送る
+func (s *GameService) serveModeration(ctx context.Context) <-chan error {
+	if s.moderator == nil {
+		return nil
+	}
+	errCh := make(chan error)
+	go func() {
+		errCh <- s.moderator.Serve(ctx)
+	}()
+	return errCh
+}
+
+// serveBridge : bridgeでイベントを配信し、command subjectのメッセージを部屋に送る
+func (s *GameService) serveBridge(ctx context.Context) <-chan error {
+	if s.bridge == nil {
+		return nil
+	}
+	errCh := make(chan error)
+	go func() {
+		errCh <- s.bridge.Serve(ctx, s.handleBridgeCommand)
+	}()
+	return errCh
+}
+
+// handleBridgeCommand : bridgeで受け取ったメッセージを部屋に送る.
+// 全gameサーバに届くので、このサーバに無い部屋やappは無視する.
+func (s *GameService) handleBridgeCommand(cmd *bridge.Command) {
+	repo, ok := s.loadedRepo(cmd.AppId)
+	if !ok {
+		return
+	}
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "bridge:command",
+		log.KeyApp, cmd.AppId,
+		log.KeyRoom, cmd.RoomId,
+		log.KeyClient, cmd.ClientId,
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rooms, err := repo.ServerMessage(ctx, cmd.RoomId, cmd.ClientId, cmd.Data, logger)
+	if err != nil {
+		if err.Code() == codes.NotFound {
+			logger.Debugf("bridge command: %v", err)
+		} else {
+			logger.Infof("bridge command: %+v", err)
+		}
+		return
+	}
+	logger.Debugf("bridge command: sent to %v rooms", rooms)
+}
+
+// serveRoomCleanup : 残骸になったroomテーブルの行を定期的に片付ける. see game.CleanupRooms
+func (s *GameService) serveRoomCleanup(ctx context.Context) <-chan error {
+	interval := time.Duration(s.conf.RoomCleanupInterval)
+	if interval <= 0 {
+		return nil
+	}
+	errCh := make(chan error)
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			ids, err := s.cleanupRooms(ctx, false)
+			if err != nil {
+				log.Errorf("cleanup rooms: %+v", err)
+			}
+			if len(ids) > 0 {
+				log.Infof("cleanup rooms: %v", ids)
+			}
+		}
+	}()
+	return errCh
+}
+
+// serveRetentionPurge : 保持期間を過ぎたログを定期的に削除する. see game.PurgeExpiredLogs
+func (s *GameService) serveRetentionPurge(ctx context.Context) <-chan error {
+	interval := time.Duration(s.conf.RetentionPurgeInterval)
+	if interval <= 0 {
+		return nil
+	}
+	errCh := make(chan error)
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			purged, err := game.PurgeExpiredLogs(ctx, s.db, s.conf, time.Now())
+			if err != nil {
+				log.Errorf("purge expired logs: %+v", err)
+			}
+			if len(purged) > 0 {
+				log.Infof("purge expired logs: %v", purged)
+			}
+		}
+	}()
+	return errCh
+}
+
+func (s *GameService) cleanupRooms(ctx context.Context, dryRun bool) ([]string, error) {
+	return game.CleanupRooms(ctx, s.db, uint32(s.HostId), s.roomExists, s.conf.CleanupHostTimeout(), time.Now(), dryRun)
+}
+
+// roomExists : このサーバのいずれかのappに部屋があるか. 事前確保した部屋の行も含む
+func (s *GameService) roomExists(roomId string) bool {
+	for _, repo := range s.allRepos() {
+		if _, err := repo.GetRoom(roomId); err == nil {
+			return true
+		}
+		if repo.IsPrewarmed(roomId) {
+			return true
+		}
+	}
+	return false
+}
+
+// allRepos : 全appのRepository
+func (s *GameService) allRepos() []*game.Repository {
+	s.muRepos.RLock()
+	defer s.muRepos.RUnlock()
+	repos := make([]*game.Repository, 0, len(s.repos))
+	for _, repo := range s.repos {
+		repos = append(repos, repo)
+	}
+	return repos
+}
+
+func (s *GameService) msgQueueDepth() int {
+	n := 0
+	for _, repo := range s.allRepos() {
+		n += repo.MsgQueueDepth()
+	}
+	return n
+}
+
+func (s *GameService) eventQueueDepth() int {
+	n := 0
+	for _, repo := range s.allRepos() {
+		n += repo.EventQueueDepth()
+	}
+	return n
+}
+
+// heavyRooms : 最後に更新したheavy_rooms
+func (s *GameService) heavyRooms() any {
+	return s.heavy.Load()
+}
+
+// updateHeavyRooms : 全appの部屋からメモリの使用量が多い順にheavy_rooms件を求める
+func (s *GameService) updateHeavyRooms() {
+	var usages []*pb.RoomUsage
+	for _, repo := range s.allRepos() {
+		usages = append(usages, repo.RoomUsages()...)
+	}
+	s.heavy.Store(game.HeavyRooms(usages, s.conf.HeavyRooms))
+}
+
+// serveHeavyRooms : heavy_roomsを定期的に更新する
+func (s *GameService) serveHeavyRooms(ctx context.Context) <-chan error {
+	if s.conf.HeavyRooms <= 0 {
+		return nil
+	}
+	errCh := make(chan error)
+	go func() {
+		t := time.NewTicker(heavyRoomsInterval)
+		defer t.Stop()
+		for {
+			s.updateHeavyRooms()
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return errCh
+}
+
 func (s *GameService) numRooms() int {
 	numRooms := 0
-	for _, repo := range s.repos {
+	for _, repo := range s.allRepos() {
 		numRooms += repo.GetRoomCount()
 	}
 	return numRooms
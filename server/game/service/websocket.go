@@ -15,9 +15,13 @@ import (
 	"github.com/shiguredo/websocket"
 	"golang.org/x/xerrors"
 
+	"wsnet2/binary"
+	"wsnet2/config"
 	"wsnet2/game"
 	"wsnet2/log"
 	"wsnet2/metrics"
+	"wsnet2/middleware"
+	"wsnet2/requestid"
 )
 
 const (
@@ -35,6 +39,9 @@ var (
 
 type WSHandler struct {
 	*GameService
+
+	// sseTickets : SSEの接続チケット. see game/sse.go
+	sseTickets *game.SSETickets
 }
 
 func (sv *GameService) serveWebSocket(ctx context.Context) <-chan error {
@@ -42,37 +49,49 @@ func (sv *GameService) serveWebSocket(ctx context.Context) <-chan error {
 
 	sv.preparation.Add(1)
 	go func() {
-		laddr := fmt.Sprintf(":%d", sv.conf.WebsocketPort)
-		log.Infof("game websocket: %#v", laddr)
+		listener := sv.opts.WebsocketListener
+		if listener == nil {
+			laddr := fmt.Sprintf(":%d", sv.conf.WebsocketPort)
+			log.Infof("game websocket: %#v", laddr)
 
-		lc := net.ListenConfig{}
-		listener, err := lc.Listen(ctx, "tcp", laddr)
-		if err != nil {
-			errCh <- xerrors.Errorf("listen failed: %w", err)
-			return
+			lc := net.ListenConfig{}
+			var err error
+			listener, err = lc.Listen(ctx, "tcp", laddr)
+			if err != nil {
+				errCh <- xerrors.Errorf("listen failed: %w", err)
+				return
+			}
+		} else {
+			log.Infof("game websocket: %v", listener.Addr())
 		}
 
-		scheme := "ws"
-		if cert, key := sv.conf.TLSCert, sv.conf.TLSKey; cert != "" {
-			scheme = "wss"
-			log.Infof("loading tls key: %#v", cert)
-			cert, err := tls.LoadX509KeyPair(cert, key)
+		if sv.conf.TLSCert != "" {
+			tlsConf, err := loadTLSConfig(sv.conf)
 			if err != nil {
-				errCh <- xerrors.Errorf("x509 load error: %w", err)
+				errCh <- err
 				return
 			}
-			tlsConf := &tls.Config{
-				Certificates: []tls.Certificate{cert},
-			}
 			listener = tls.NewListener(listener, tlsConf)
 		}
 
-		ws := &WSHandler{sv}
+		if sv.conf.DebugJSONEvents {
+			log.Infof("game websocket: debug_json_events is enabled")
+			upgrader.Subprotocols = append(upgrader.Subprotocols, game.SubprotocolJSON)
+		}
+
+		ws := &WSHandler{sv, game.NewSSETickets()}
 		r := chi.NewMux()
-		r.Get("/room/{id:[0-9a-f]+}", ws.HandleRoom)
+		mws := []middleware.Middleware{requestid.Middleware}
+		mws = append(mws, middleware.FromConfig(&sv.conf.WebsocketMiddleware, "game:websocket")...)
+		mws = append(mws, sv.opts.WebsocketMiddlewares...)
+		r.With(mws...).Get("/room/{id:[0-9a-f]+}", ws.HandleRoom)
+		r.With(mws...).Get("/mux", ws.HandleMux)
+		r.With(mws...).Get("/room/{id:[0-9a-f]+}/events", ws.HandleSSE)
+		r.With(mws...).Post("/room/{id:[0-9a-f]+}/events/ticket", ws.HandleSSETicket)
+		r.With(mws...).Options("/room/{id:[0-9a-f]+}/events/ticket", ws.HandleSSEPreflight)
+		r.Get("/ping", handlePing)
 
-		sv.wsURLFormat = fmt.Sprintf("%s://%s:%d/room/%%s",
-			scheme, sv.conf.PublicName, sv.conf.WebsocketPort)
+		sv.wsURLFormat = roomURLPrefix(sv.conf) + "%s"
 
 		svr := &http.Server{
 			Handler:      r,
@@ -86,9 +105,61 @@ func (sv *GameService) serveWebSocket(ctx context.Context) <-chan error {
 	return errCh
 }
 
+// loadTLSConfig : TLSCertとapp毎の証明書を読み込む. app毎の証明書はSNIで選ぶ
+func loadTLSConfig(conf *config.GameConf) (*tls.Config, error) {
+	log.Infof("loading tls key: %#v", conf.TLSCert)
+	cert, err := tls.LoadX509KeyPair(conf.TLSCert, conf.TLSKey)
+	if err != nil {
+		return nil, xerrors.Errorf("x509 load error: %w", err)
+	}
+	certs := make(map[string]*tls.Certificate)
+	for appId, t := range conf.AppTLS {
+		log.Infof("loading tls key for app %v: %#v", appId, t.Cert)
+		c, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, xerrors.Errorf("x509 load error: app=%v: %w", appId, err)
+		}
+		for _, name := range t.ServerNames {
+			certs[strings.ToLower(name)] = &c
+		}
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			// nilを返すとCertificatesの証明書を使う
+			return certs[strings.ToLower(hello.ServerName)], nil
+		},
+	}, nil
+}
+
+// serverApp : 接続のSNIに割り当てたapp
+func (s *WSHandler) serverApp(r *http.Request) string {
+	if r.TLS == nil {
+		return ""
+	}
+	return s.conf.AppForServerName(r.TLS.ServerName)
+}
+
+// upgradeError : Upgrade前の検査のエラーをErrorCodeを付けて返す
+func upgradeError(w http.ResponseWriter, err *game.UpgradeError) {
+	w.Header().Set(binary.ErrorCodeHeader, strconv.Itoa(int(err.Code)))
+	http.Error(w, http.StatusText(err.Status), err.Status)
+}
+
+// handlePing : クライアントがRTTを計測するためのエンドポイント
+func handlePing(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("pong\n"))
+}
+
 func (s *WSHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
 	roomId := chi.URLParam(r, "id")
 	appId := r.Header.Get("Wsnet2-App")
+	if appId == "" {
+		s.handleRoomHandshake(w, r, roomId)
+		return
+	}
 	clientId := r.Header.Get("Wsnet2-User")
 	logger := log.GetLoggerWith(
 		log.KeyHandler, "ws:room",
@@ -96,6 +167,7 @@ func (s *WSHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
 		log.KeyApp, appId,
 		log.KeyClient, clientId,
 		log.KeyRequestedAt, float64(time.Now().UnixNano()/1000000)/1000,
+		log.KeyRequestId, requestid.FromContext(r.Context()),
 	)
 	lastEvSeq, err := strconv.Atoi(r.Header.Get("Wsnet2-LastEventSeq"))
 	if err != nil {
@@ -103,18 +175,20 @@ func (s *WSHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
-
-	repo, ok := s.repos[appId]
-	if !ok {
-		logger.Infof("websocket: invalid appId: %v", appId)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
-		return
+	protoVer := binary.ProtocolVersion1
+	if v := r.Header.Get(binary.ProtocolVersionHeader); v != "" {
+		protoVer, err = strconv.Atoi(v)
+		if err != nil {
+			logger.Infof("websocket: invalid header: ProtocolVersion=%v, %+v", v, err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
 	}
 
-	cli, err := repo.GetClient(roomId, clientId)
-	if err != nil {
-		logger.Infof("websocket: repo.GetClient: %v", err)
-		http.Error(w, "Not Found", http.StatusNotFound)
+	cli, uerr := game.UpgradeClient(s.allRepos(), appId, s.serverApp(r), roomId, clientId)
+	if uerr != nil {
+		logger.Infof("websocket: %v: %v", uerr.Code, uerr)
+		upgradeError(w, uerr)
 		return
 	}
 	logger.Infof("websocket: room=%v client=%v", roomId, clientId)
@@ -132,16 +206,16 @@ func (s *WSHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(w, r, requestid.UpgradeHeader(r.Context()))
 	if err != nil {
 		breq, _ := httputil.DumpRequest(r, false)
 		logger.Errorf("websocket: upgrade: %+v\nrequest: %v", err, string(breq))
 		return
 	}
-	metrics.Conns.Add(1)
-	defer metrics.Conns.Add(-1)
+	metrics.AddAppConns(appId, 1)
+	defer metrics.AddAppConns(appId, -1)
 
-	peer, err := game.NewPeer(ctx, cli, conn, lastEvSeq)
+	peer, err := game.NewPeer(ctx, cli, conn, lastEvSeq, protoVer)
 	if err != nil {
 		logger.Warnf("websocket: NewPeer: %+v", err)
 		return
@@ -149,3 +223,185 @@ func (s *WSHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
 	<-peer.Done()
 	logger.Debugf("websocket: finish: room=%v client=%v peer=%p", roomId, clientId, peer)
 }
+
+// handleRoomHandshake : ヘッダの代わりにUpgrade後の先頭フレームで認証する. see binary/handshake.go
+func (s *WSHandler) handleRoomHandshake(w http.ResponseWriter, r *http.Request, roomId string) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "ws:room",
+		log.KeyRoom, roomId,
+		log.KeyRequestedAt, float64(time.Now().UnixNano()/1000000)/1000,
+		log.KeyRequestId, requestid.FromContext(r.Context()),
+	)
+	serverApp := s.serverApp(r)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	conn, err := upgrader.Upgrade(w, r, requestid.UpgradeHeader(r.Context()))
+	if err != nil {
+		breq, _ := httputil.DumpRequest(r, false)
+		logger.Errorf("websocket: upgrade: %+v\nrequest: %v", err, string(breq))
+		return
+	}
+
+	cli, hs, err := game.AcceptHandshake(conn, func(hs *binary.Handshake) (*game.Client, error) {
+		cli, uerr := game.UpgradeClient(s.allRepos(), hs.AppId, serverApp, roomId, hs.ClientId)
+		if uerr != nil {
+			return nil, uerr
+		}
+		if err := cli.ValidAuthData(hs.AuthData); err != nil {
+			return nil, xerrors.Errorf("Authorization: %w", err)
+		}
+		return cli, nil
+	})
+	if err != nil {
+		logger.Infof("websocket: %+v", err)
+		return
+	}
+	logger = logger.With(log.KeyApp, hs.AppId, log.KeyClient, hs.ClientId)
+	logger.Infof("websocket: handshake: room=%v client=%v", roomId, hs.ClientId)
+	metrics.AddAppConns(hs.AppId, 1)
+	defer metrics.AddAppConns(hs.AppId, -1)
+
+	peer, err := game.NewPeer(ctx, cli, conn, hs.LastEvSeq, hs.ProtocolVersion)
+	if err != nil {
+		logger.Warnf("websocket: NewPeer: %+v", err)
+		return
+	}
+	<-peer.Done()
+	logger.Debugf("websocket: finish: room=%v client=%v peer=%p", roomId, hs.ClientId, peer)
+}
+
+// HandleSSE : websocketを使えない観戦者にServer-Sent Eventsでイベントを配信する. see game/sse.go
+func (s *WSHandler) HandleSSE(w http.ResponseWriter, r *http.Request) {
+	roomId := chi.URLParam(r, "id")
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "sse:room",
+		log.KeyRoom, roomId,
+		log.KeyRequestedAt, float64(time.Now().UnixNano()/1000000)/1000,
+		log.KeyRequestId, requestid.FromContext(r.Context()),
+	)
+	game.SSEAllowOrigin(w, r, s.conf.SSEAllowOrigins)
+	hs, err := game.SSERequest(r, roomId, s.sseTickets)
+	if err != nil {
+		logger.Infof("sse: invalid request: %+v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	logger = logger.With(log.KeyApp, hs.AppId, log.KeyClient, hs.ClientId)
+
+	cli, uerr := game.UpgradeClient(s.allRepos(), hs.AppId, s.serverApp(r), roomId, hs.ClientId)
+	if uerr != nil {
+		logger.Infof("sse: %v: %v", uerr.Code, uerr)
+		upgradeError(w, uerr)
+		return
+	}
+	if err := cli.ValidAuthData(hs.AuthData); err != nil {
+		logger.Infof("sse: Authorization: %+v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logger.Infof("sse: room=%v client=%v", roomId, hs.ClientId)
+	metrics.AddAppConns(hs.AppId, 1)
+	defer metrics.AddAppConns(hs.AppId, -1)
+
+	if err := game.ServeSSE(r.Context(), w, cli, hs.LastEvSeq, hs.ProtocolVersion); err != nil {
+		logger.Infof("sse: %+v", err)
+		return
+	}
+	logger.Debugf("sse: finish: room=%v client=%v", roomId, hs.ClientId)
+}
+
+// HandleSSETicket : ヘッダで認証した観戦者に、SSEの接続に使う一度きりのチケットを発行する. see game/sse.go
+func (s *WSHandler) HandleSSETicket(w http.ResponseWriter, r *http.Request) {
+	roomId := chi.URLParam(r, "id")
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "sse:ticket",
+		log.KeyRoom, roomId,
+		log.KeyRequestedAt, float64(time.Now().UnixNano()/1000000)/1000,
+		log.KeyRequestId, requestid.FromContext(r.Context()),
+	)
+	game.SSEAllowOrigin(w, r, s.conf.SSEAllowOrigins)
+	hs, err := game.SSETicketRequest(r)
+	if err != nil {
+		logger.Infof("sse: invalid request: %+v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	logger = logger.With(log.KeyApp, hs.AppId, log.KeyClient, hs.ClientId)
+
+	cli, uerr := game.UpgradeClient(s.allRepos(), hs.AppId, s.serverApp(r), roomId, hs.ClientId)
+	if uerr != nil {
+		logger.Infof("sse: %v: %v", uerr.Code, uerr)
+		upgradeError(w, uerr)
+		return
+	}
+	if err := cli.ValidAuthData(hs.AuthData); err != nil {
+		logger.Infof("sse: Authorization: %+v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(s.sseTickets.Issue(roomId, hs)))
+}
+
+// HandleSSEPreflight : チケットの発行のCORSのpreflightに応える
+func (s *WSHandler) HandleSSEPreflight(w http.ResponseWriter, r *http.Request) {
+	game.SSEAllowOrigin(w, r, s.conf.SSEAllowOrigins)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleMux : 1つのwebsocket接続で複数の部屋に接続する. see game/mux.go
+// 部屋毎の認証はMuxAttachで行う.
+func (s *WSHandler) HandleMux(w http.ResponseWriter, r *http.Request) {
+	appId := r.Header.Get("Wsnet2-App")
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "ws:mux",
+		log.KeyApp, appId,
+		log.KeyRequestedAt, float64(time.Now().UnixNano()/1000000)/1000,
+		log.KeyRequestId, requestid.FromContext(r.Context()),
+	)
+	protoVer := binary.ProtocolVersion1
+	if v := r.Header.Get(binary.ProtocolVersionHeader); v != "" {
+		var err error
+		protoVer, err = strconv.Atoi(v)
+		if err != nil {
+			logger.Infof("websocket: invalid header: ProtocolVersion=%v, %+v", v, err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	serverApp := s.serverApp(r)
+	if _, uerr := game.UpgradeRepo(s.allRepos(), appId, serverApp); uerr != nil {
+		logger.Infof("websocket: %v: %v", uerr.Code, uerr)
+		upgradeError(w, uerr)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	conn, err := upgrader.Upgrade(w, r, requestid.UpgradeHeader(r.Context()))
+	if err != nil {
+		breq, _ := httputil.DumpRequest(r, false)
+		logger.Errorf("websocket: upgrade: %+v\nrequest: %v", err, string(breq))
+		return
+	}
+	metrics.AddAppConns(appId, 1)
+	defer metrics.AddAppConns(appId, -1)
+
+	mux := game.NewMux(conn, protoVer, logger)
+	mux.Serve(ctx, func(roomId, clientId, authData string) (*game.Client, error) {
+		cli, uerr := game.UpgradeClient(s.allRepos(), appId, serverApp, roomId, clientId)
+		if uerr != nil {
+			return nil, uerr
+		}
+		if err := cli.ValidAuthData(authData); err != nil {
+			return nil, xerrors.Errorf("Authorization: %w", err)
+		}
+		return cli, nil
+	})
+	logger.Debugf("websocket: finish mux")
+}
@@ -0,0 +1,587 @@
+package game
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+
+	"wsnet2/auth"
+	"wsnet2/binary"
+	"wsnet2/log"
+	"wsnet2/pb"
+)
+
+// セッション再開 (config.GameConf.SessionResume)
+//
+// 部屋とクライアントの状態をSessionSaveInterval毎にDBに保存し、
+// gameサーバの再起動時にSessionResumeWindow以内に保存された部屋を復元する.
+// 復元したクライアントは再起動前と同じAuthKeyとMACKeyで再接続でき、
+// (AuthKeyとMACKeyはSessionKeyで暗号化して保存する)
+// X-Wsnet-LastEventSeqで申告された受信済みのイベントの続きから通信を再開する.
+//
+// 保存していない状態 (イベントの再送バッファ、KVストア、ロールなど) は復元しない.
+// 保存後に送ったイベントを受信していないクライアントは復帰できない (CloseReasonEventLost).
+// 保存後に処理したメッセージはクライアントから再送されるので二重に処理されることがある.
+
+// sessionBatchSize : 1つのINSERTで書き込むセッションの件数の上限
+const sessionBatchSize = 100
+
+var (
+	roomSessionUpsertQuery   string
+	clientSessionUpsertQuery string
+)
+
+func init() {
+	roomSessionUpsertQuery = upsertQuery("room_session", reflect.TypeOf(roomSession{}))
+	clientSessionUpsertQuery = upsertQuery("client_session", reflect.TypeOf(clientSession{}))
+}
+
+func upsertQuery(table string, t reflect.Type) string {
+	cols := dbCols(t)
+	updates := make([]string, 0, len(cols))
+	for _, c := range cols {
+		updates = append(updates, fmt.Sprintf("%s=VALUES(%s)", c, c))
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (:%s) ON DUPLICATE KEY UPDATE %s",
+		table, strings.Join(cols, ","), strings.Join(cols, ",:"), strings.Join(updates, ","))
+}
+
+// roomSession : room_sessionテーブルの行. 部屋の復元に必要でroomテーブルに無い情報
+type roomSession struct {
+	RoomID              string    `db:"room_id"`
+	HostID              uint32    `db:"host_id"`
+	MasterID            string    `db:"master_id"`
+	Deadline            uint32    `db:"deadline"`
+	WatcherDelay        uint32    `db:"watcher_delay"`
+	MaxBandwidth        uint32    `db:"max_bandwidth"`
+	HistorySize         uint32    `db:"history_size"`
+	RejoinPolicy        uint32    `db:"rejoin_policy"`
+	LogLevel            uint32    `db:"log_level"`
+	Lifetime            uint32    `db:"lifetime"`
+	CloseOnMasterLeave  bool      `db:"close_on_master_leave"`
+	SlowConsumerPolicy  uint32    `db:"slow_consumer_policy"`
+	PingInterval        uint32    `db:"ping_interval"`
+	StaleThreshold      uint32    `db:"stale_threshold"`
+	AutoPromoteWatchers bool      `db:"auto_promote_watchers"`
+	WatchOnly           bool      `db:"watch_only"`
+	LobbyRoom           bool      `db:"lobby_room"`
+	ParentRoom          string    `db:"parent_room"`
+	PrivateProps        []byte    `db:"private_props"`
+	Updated             time.Time `db:"updated"`
+}
+
+// clientSession : client_sessionテーブルの行
+type clientSession struct {
+	RoomID   string `db:"room_id"`
+	ClientID string `db:"client_id"`
+	IsPlayer bool   `db:"is_player"`
+	IsHub    bool   `db:"is_hub"`
+	IsBot    bool   `db:"is_bot"`
+	Props    []byte `db:"props"`
+	MACKey   string `db:"mac_key"` // DBではSessionKeyで暗号化する
+	MACAlg   string `db:"mac_algorithm"`
+	AuthKey  string `db:"auth_key"` // DBではSessionKeyで暗号化する
+	EvSeq    int    `db:"ev_seq"`
+	MsgSeq   int    `db:"msg_seq"`
+}
+
+type sessionSnapshot struct {
+	room    *roomSession
+	clients []*clientSession
+}
+
+// sessionWriter : 部屋ごとの最新のセッション状態を溜めてまとめてDBに書き込む
+type sessionWriter struct {
+	db       *sqlx.DB
+	key      string
+	interval time.Duration
+	retry    *dbRetry
+
+	mu      sync.Mutex
+	pending map[string]*sessionSnapshot
+	removed map[string]struct{}
+
+	// written : 書き込み済みのクライアントのセッション. flushからのみ触る
+	written map[string]map[string]*clientSession
+}
+
+func newSessionWriter(db *sqlx.DB, key string, interval, retryMax time.Duration) *sessionWriter {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &sessionWriter{
+		db:       db,
+		key:      key,
+		interval: interval,
+		retry:    newDBRetry("sessionWriter", retryMax),
+		pending:  make(map[string]*sessionSnapshot),
+		removed:  make(map[string]struct{}),
+		written:  make(map[string]map[string]*clientSession),
+	}
+}
+
+// update : 部屋のセッション状態を書き込み待ちにする. 書き込み前の同じ部屋の状態は置き換える
+func (w *sessionWriter) update(s *sessionSnapshot) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending[s.room.RoomID] = s
+}
+
+// remove : 閉じた部屋のセッション状態を削除する
+func (w *sessionWriter) remove(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.pending, id)
+	w.removed[id] = struct{}{}
+}
+
+// run : 書き込み待ちのセッション状態を定期的に書き込む. Repositoryと同じくプロセス終了まで動き続ける.
+func (w *sessionWriter) run() {
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for range t.C {
+		for {
+			err := w.flush()
+			if err == nil {
+				w.retry.succeeded()
+				break
+			}
+			time.Sleep(w.retry.failed(err))
+		}
+	}
+}
+
+// requeue : 書き込めなかった状態を書き込み待ちに戻す. 新しい状態があればそちらを残す
+func (w *sessionWriter) requeue(snaps map[string]*sessionSnapshot, removed map[string]struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id := range removed {
+		w.removed[id] = struct{}{}
+	}
+	for id, s := range snaps {
+		if _, ok := w.removed[id]; ok {
+			continue
+		}
+		if _, ok := w.pending[id]; !ok {
+			w.pending[id] = s
+		}
+	}
+}
+
+// flush : 書き込み待ちの状態を書き込む. 前回から変わっていないクライアントは書き込まない.
+func (w *sessionWriter) flush() error {
+	w.mu.Lock()
+	snaps, removed := w.pending, w.removed
+	w.pending = make(map[string]*sessionSnapshot)
+	w.removed = make(map[string]struct{})
+	w.mu.Unlock()
+
+	if len(snaps) == 0 && len(removed) == 0 {
+		return nil
+	}
+	if err := w.write(snaps, removed); err != nil {
+		w.requeue(snaps, removed)
+		return err
+	}
+	return nil
+}
+
+func (w *sessionWriter) write(snaps map[string]*sessionSnapshot, removed map[string]struct{}) error {
+	if err := chaosDBError(); err != nil {
+		return err
+	}
+	rooms := make([]*roomSession, 0, len(snaps))
+	var clients []*clientSession
+	left := make(map[string][]string) // 部屋から居なくなったクライアント
+	for id, s := range snaps {
+		rooms = append(rooms, s.room)
+		prev := w.written[id]
+		cur := make(map[string]struct{}, len(s.clients))
+		for _, c := range s.clients {
+			cur[c.ClientID] = struct{}{}
+			if p, ok := prev[c.ClientID]; ok && p.EvSeq == c.EvSeq && p.MsgSeq == c.MsgSeq &&
+				p.AuthKey == c.AuthKey && bytes.Equal(p.Props, c.Props) {
+				continue
+			}
+			sc, err := c.seal(w.key)
+			if err != nil {
+				return xerrors.Errorf("seal client_session (%v): %w", c.ClientID, err)
+			}
+			clients = append(clients, sc)
+		}
+		for cid := range prev {
+			if _, ok := cur[cid]; !ok {
+				left[id] = append(left[id], cid)
+			}
+		}
+	}
+
+	for len(rooms) > 0 {
+		n := len(rooms)
+		if n > sessionBatchSize {
+			n = sessionBatchSize
+		}
+		if _, err := w.db.NamedExec(roomSessionUpsertQuery, rooms[:n]); err != nil {
+			return xerrors.Errorf("upsert room_session (%v rows): %w", n, err)
+		}
+		rooms = rooms[n:]
+	}
+	for len(clients) > 0 {
+		n := len(clients)
+		if n > sessionBatchSize {
+			n = sessionBatchSize
+		}
+		if _, err := w.db.NamedExec(clientSessionUpsertQuery, clients[:n]); err != nil {
+			return xerrors.Errorf("upsert client_session (%v rows): %w", n, err)
+		}
+		clients = clients[n:]
+	}
+	for rid, cids := range left {
+		q, args, err := sqlx.In("DELETE FROM client_session WHERE room_id=? AND client_id IN (?)", rid, cids)
+		if err != nil {
+			return xerrors.Errorf("sqlx.In: %w", err)
+		}
+		if _, err := w.db.Exec(q, args...); err != nil {
+			return xerrors.Errorf("delete client_session (room=%v): %w", rid, err)
+		}
+	}
+	if len(removed) > 0 {
+		ids := make([]string, 0, len(removed))
+		for id := range removed {
+			ids = append(ids, id)
+		}
+		if err := deleteSessions(w.db, ids); err != nil {
+			return err
+		}
+	}
+
+	// 書き込めたら状態を記録する
+	for id, s := range snaps {
+		cur := make(map[string]*clientSession, len(s.clients))
+		for _, c := range s.clients {
+			cur[c.ClientID] = c
+		}
+		w.written[id] = cur
+	}
+	for id := range removed {
+		delete(w.written, id)
+	}
+	return nil
+}
+
+func deleteSessions(db *sqlx.DB, roomIds []string) error {
+	q, args, err := sqlx.In("DELETE FROM client_session WHERE room_id IN (?)", roomIds)
+	if err != nil {
+		return xerrors.Errorf("sqlx.In: %w", err)
+	}
+	if _, err := db.Exec(q, args...); err != nil {
+		return xerrors.Errorf("delete client_session: %w", err)
+	}
+	q, args, err = sqlx.In("DELETE FROM room_session WHERE room_id IN (?)", roomIds)
+	if err != nil {
+		return xerrors.Errorf("sqlx.In: %w", err)
+	}
+	if _, err := db.Exec(q, args...); err != nil {
+		return xerrors.Errorf("delete room_session: %w", err)
+	}
+	return nil
+}
+
+// saveSession : 部屋とクライアントの状態を保存する. RoomのMsgLoopから呼ばれる
+func (r *Room) saveSession() {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	rs := &roomSession{
+		RoomID:              r.Id,
+		HostID:              r.HostId,
+		Deadline:            uint32(r.deadline / time.Second),
+		WatcherDelay:        uint32(r.watcherDelay / time.Second),
+		MaxBandwidth:        r.maxBandwidth,
+		HistorySize:         r.historySize,
+		RejoinPolicy:        r.rejoinPolicy,
+		LogLevel:            r.logLevel,
+		Lifetime:            uint32(r.lifetime / time.Second),
+		CloseOnMasterLeave:  r.closeOnMasterLeave,
+		SlowConsumerPolicy:  r.slowConsumer,
+		PingInterval:        uint32(r.pingInterval / time.Millisecond),
+		StaleThreshold:      uint32(r.staleThreshold / time.Millisecond),
+		AutoPromoteWatchers: r.autoPromoteWatchers,
+		WatchOnly:           r.watchOnly,
+		LobbyRoom:           r.lobbyRoom,
+		ParentRoom:          r.parent,
+		PrivateProps:        r.PrivateProps,
+		Updated:             time.Now(), // SessionResumeWindowの判定に使うので実時間
+	}
+	if r.master != nil {
+		rs.MasterID = r.master.Id
+	}
+	clients := make([]*clientSession, 0, len(r.players)+len(r.watchers))
+	for _, c := range r.players {
+		clients = append(clients, c.session())
+	}
+	for _, c := range r.watchers {
+		clients = append(clients, c.session())
+	}
+	for _, b := range r.bots {
+		clients = append(clients, &clientSession{
+			RoomID:   r.Id,
+			ClientID: b.Id,
+			IsPlayer: true,
+			IsBot:    true,
+			Props:    b.Props,
+		})
+	}
+	r.sessions.update(&sessionSnapshot{rs, clients})
+}
+
+func (c *Client) session() *clientSession {
+	c.mu.RLock()
+	msgSeq := c.msgSeqNum
+	c.mu.RUnlock()
+	return &clientSession{
+		RoomID:   string(c.room.ID()),
+		ClientID: c.Id,
+		IsPlayer: c.isPlayer.Load(),
+		IsHub:    c.IsHub,
+		Props:    c.Props,
+		MACKey:   c.macKey,
+		MACAlg:   c.macAlg,
+		AuthKey:  c.authKey,
+		EvSeq:    c.evbuf.WriteSeq(),
+		MsgSeq:   msgSeq,
+	}
+}
+
+// seal : MACKeyとAuthKeyをkeyで暗号化したコピーを返す
+func (cs *clientSession) seal(key string) (*clientSession, error) {
+	sc := *cs
+	var err error
+	if sc.MACKey, err = auth.SealSecret(key, cs.MACKey); err != nil {
+		return nil, xerrors.Errorf("mac_key: %w", err)
+	}
+	if sc.AuthKey, err = auth.SealSecret(key, cs.AuthKey); err != nil {
+		return nil, xerrors.Errorf("auth_key: %w", err)
+	}
+	return &sc, nil
+}
+
+// open : sealで暗号化したMACKeyとAuthKeyを復号する
+func (cs *clientSession) open(key string) error {
+	mk, err := auth.OpenSecret(key, cs.MACKey)
+	if err != nil {
+		return xerrors.Errorf("mac_key: %w", err)
+	}
+	ak, err := auth.OpenSecret(key, cs.AuthKey)
+	if err != nil {
+		return xerrors.Errorf("auth_key: %w", err)
+	}
+	cs.MACKey, cs.AuthKey = mk, ak
+	return nil
+}
+
+// resumableRoom : 復元する部屋の保存された状態
+type resumableRoom struct {
+	info    *pb.RoomInfo
+	session *roomSession
+	clients []*clientSession
+}
+
+// loadSessions : hostIdで保存された部屋のうちwindow以内に保存された状態を読み込む.
+// それより古い状態は削除する. keyで復号できないクライアントは復元しない.
+func loadSessions(db *sqlx.DB, key string, hostId uint32, window time.Duration) ([]*resumableRoom, error) {
+	var sessions []*roomSession
+	err := db.Select(&sessions,
+		"SELECT s.* FROM room_session s JOIN room r ON r.id = s.room_id JOIN app a ON a.id = r.app_id AND a.disabled = 0 "+
+			"WHERE s.host_id = ? AND s.updated >= ?", hostId, time.Now().Add(-window))
+	if err != nil {
+		return nil, xerrors.Errorf("select room_session: %w", err)
+	}
+
+	resumable := make(map[string]*resumableRoom, len(sessions))
+	for _, s := range sessions {
+		resumable[s.RoomID] = &resumableRoom{session: s}
+	}
+
+	var saved []string
+	if err := db.Select(&saved, "SELECT room_id FROM room_session WHERE host_id = ?", hostId); err != nil {
+		return nil, xerrors.Errorf("select room_session ids: %w", err)
+	}
+	var stale []string
+	for _, id := range saved {
+		if _, ok := resumable[id]; !ok {
+			stale = append(stale, id)
+		}
+	}
+	if len(stale) > 0 {
+		log.Infof("discard %v stale sessions", len(stale))
+		if err := deleteSessions(db, stale); err != nil {
+			return nil, err
+		}
+	}
+	if len(sessions) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(sessions))
+	for _, s := range sessions {
+		ids = append(ids, s.RoomID)
+	}
+
+	q, args, err := sqlx.In("SELECT * FROM room WHERE id IN (?)", ids)
+	if err != nil {
+		return nil, xerrors.Errorf("sqlx.In: %w", err)
+	}
+	var infos []*pb.RoomInfo
+	if err := db.Select(&infos, q, args...); err != nil {
+		return nil, xerrors.Errorf("select room: %w", err)
+	}
+	for _, ri := range infos {
+		resumable[ri.Id].info = ri
+	}
+
+	q, args, err = sqlx.In("SELECT * FROM client_session WHERE room_id IN (?)", ids)
+	if err != nil {
+		return nil, xerrors.Errorf("sqlx.In: %w", err)
+	}
+	var clients []*clientSession
+	if err := db.Select(&clients, q, args...); err != nil {
+		return nil, xerrors.Errorf("select client_session: %w", err)
+	}
+	for _, c := range clients {
+		if err := c.open(key); err != nil {
+			log.Errorf("discard client session (room=%v, client=%v): %+v", c.RoomID, c.ClientID, err)
+			continue
+		}
+		if rr, ok := resumable[c.RoomID]; ok {
+			rr.clients = append(rr.clients, c)
+		}
+	}
+
+	rooms := make([]*resumableRoom, 0, len(resumable))
+	for _, rr := range resumable {
+		if rr.info != nil {
+			rooms = append(rooms, rr)
+		}
+	}
+	return rooms, nil
+}
+
+// resumeRoom : 保存された状態から部屋とクライアントを復元する.
+// プレイヤーが居ない部屋は観戦専用の部屋を除いて復元せずに削除する.
+func (repo *Repository) resumeRoom(rr *resumableRoom) {
+	info, rs := rr.info, rr.session
+	info.PrivateProps = rs.PrivateProps
+
+	loglevel := log.CurrentLevel()
+	if rs.LogLevel > 0 {
+		loglevel = log.Level(rs.LogLevel)
+	}
+	logger := log.Get(loglevel).With(log.KeyApp, repo.app.Id, log.KeyRoom, info.Id)
+
+	r := newRoom(repo, info, rs.Deadline, rs.WatcherDelay, rs.MaxBandwidth, rs.HistorySize, rs.RejoinPolicy, rs.LogLevel, repo.conf, logger)
+	r.lifetime = roomLifetime(rs.Lifetime, repo.conf.MaxRoomLifetimeFor(info.AppId))
+	r.closeOnMasterLeave = rs.CloseOnMasterLeave
+	r.slowConsumer = rs.SlowConsumerPolicy
+	r.pingInterval = roomPingInterval(rs.PingInterval, time.Duration(repo.conf.MinPingInterval))
+	r.staleThreshold = time.Duration(rs.StaleThreshold) * time.Millisecond
+	r.autoPromoteWatchers = rs.AutoPromoteWatchers
+	r.watchOnly = rs.WatchOnly
+	r.lobbyRoom = rs.LobbyRoom
+	r.parent = rs.ParentRoom
+
+	clients := make([]*Client, 0, len(rr.clients))
+	for _, cs := range rr.clients {
+		if cs.IsBot {
+			r.bots[ClientID(cs.ClientID)] = &pb.ClientInfo{Id: cs.ClientID, Bot: true, Props: cs.Props}
+			continue
+		}
+		c, err := r.resumeClient(cs)
+		if err != nil {
+			logger.Errorf("resume client (%v): %+v", cs.ClientID, err)
+			continue
+		}
+		clients = append(clients, c)
+		if c.isPlayer.Load() {
+			r.players[c.ID()] = c
+			r.startPlayerStats(c)
+		} else {
+			r.watchers[c.ID()] = c
+		}
+	}
+
+	r.master = r.players[ClientID(rs.MasterID)]
+	if r.master != nil {
+		r.masterOrder = append(r.masterOrder, r.master.ID())
+	}
+	for id, c := range r.players {
+		if r.master == nil {
+			r.master = c
+		}
+		if c != r.master {
+			r.masterOrder = append(r.masterOrder, id)
+		}
+	}
+	r.updatePropsBytes()
+	if r.master == nil && !r.watchOnly {
+		logger.Infof("discard room without players: %v", info.Id)
+		close(r.done) // 復元したクライアントを終了させる
+		repo.deleteRoom(r)
+		return
+	}
+	r.RoomInfo.Players = r.playerCount()
+	r.updateRoomInfo()
+
+	r.publishClients()
+	r.startRelay(RoomRelayShards)
+	r.Go(r.MsgLoop)
+
+	repo.mu.Lock()
+	repo.rooms[r.ID()] = r
+	for _, c := range clients {
+		if _, ok := repo.clients[c.ID()]; !ok {
+			repo.clients[c.ID()] = make(map[RoomID]*Client)
+		}
+		repo.clients[c.ID()][r.ID()] = c
+	}
+	repo.mu.Unlock()
+
+	logger.Infof("room resumed: %v, players=%v, watchers=%v", info.Id, len(r.players), len(r.watchers))
+}
+
+// resumeClient : 保存された状態からクライアントを復元する. MsgLoopの開始前に呼ぶ
+func (r *Room) resumeClient(cs *clientSession) (*Client, error) {
+	info := &pb.ClientInfo{
+		Id:    cs.ClientID,
+		IsHub: cs.IsHub,
+		Props: cs.Props,
+	}
+	caps, err := binary.UnmarshalCaps(cs.Props)
+	if err != nil {
+		return nil, xerrors.Errorf("caps: %w", err)
+	}
+	info.Caps = caps
+
+	// 設定が変わっていても、再起動前と同じアルゴリズムで再接続できるようにする
+	c, ewc := newClient(info, cs.MACKey, cs.MACAlg, r, cs.IsPlayer)
+	if ewc != nil {
+		return nil, xerrors.Errorf("newClient: %w", ewc)
+	}
+	c.mu.Lock()
+	c.authKey = cs.AuthKey
+	c.msgSeqNum = cs.MsgSeq
+	c.resumeEvSeq = cs.EvSeq
+	c.resumed = true
+	c.mu.Unlock()
+	c.evbuf.Rebase(cs.EvSeq)
+	if c.IsHub {
+		c.hubRelay = r.hubRelay
+	}
+	return c, nil
+}
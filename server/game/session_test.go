@@ -0,0 +1,130 @@
+package game
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/xerrors"
+)
+
+func TestSessionWriter(t *testing.T) {
+	db, mock := newDbMock(t)
+	w := newSessionWriter(db, "sessionkey", time.Second, time.Second)
+
+	snap := func(clients ...*clientSession) *sessionSnapshot {
+		return &sessionSnapshot{&roomSession{RoomID: "room1", MasterID: "p1"}, clients}
+	}
+	roomQ := regexp.QuoteMeta("INSERT INTO room_session ")
+	clientQ := regexp.QuoteMeta("INSERT INTO client_session ")
+
+	// 全てのクライアントを書き込む
+	w.update(snap(
+		&clientSession{RoomID: "room1", ClientID: "p1", EvSeq: 3, MsgSeq: 1},
+		&clientSession{RoomID: "room1", ClientID: "p2", EvSeq: 2, MsgSeq: 0}))
+	mock.ExpectExec(roomQ + `.* ON DUPLICATE KEY UPDATE `).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(clientQ + `.* VALUES \(.+\),\(.+\) ON DUPLICATE KEY UPDATE `).WillReturnResult(sqlmock.NewResult(0, 2))
+	if err := w.flush(); err != nil {
+		t.Fatalf("flush: %+v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+
+	// 変わったクライアントだけ書き込み、居なくなったクライアントは削除する
+	w.update(snap(&clientSession{RoomID: "room1", ClientID: "p1", EvSeq: 5, MsgSeq: 1}))
+	mock.ExpectExec(roomQ).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(clientQ + `.* VALUES \([^)]+\) ON DUPLICATE KEY UPDATE `).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM client_session WHERE room_id=? AND client_id IN (?)")).
+		WithArgs("room1", "p2").WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := w.flush(); err != nil {
+		t.Fatalf("flush: %+v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+
+	// 失敗したら書き込み待ちに戻す
+	w.update(snap(&clientSession{RoomID: "room1", ClientID: "p1", EvSeq: 6, MsgSeq: 1}))
+	mock.ExpectExec(roomQ).WillReturnError(xerrors.Errorf("connection refused"))
+	if err := w.flush(); err == nil {
+		t.Fatalf("flush must fail")
+	}
+	if len(w.pending) != 1 {
+		t.Fatalf("pending = %v, wants 1 room", w.pending)
+	}
+
+	// 閉じた部屋は書き込まずに削除する
+	w.remove("room1")
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM client_session WHERE room_id IN (?)")).
+		WithArgs("room1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM room_session WHERE room_id IN (?)")).
+		WithArgs("room1").WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := w.flush(); err != nil {
+		t.Fatalf("flush: %+v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+	if len(w.written) != 0 {
+		t.Fatalf("written = %v", w.written)
+	}
+}
+
+// notSecret : MACKeyとAuthKeyが平文で書き込まれていないことを確かめる
+type notSecret []string
+
+func (n notSecret) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	if !ok {
+		return true
+	}
+	for _, secret := range n {
+		if strings.Contains(s, secret) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSessionWriterSealKeys(t *testing.T) {
+	db, mock := newDbMock(t)
+	w := newSessionWriter(db, "sessionkey", time.Second, time.Second)
+
+	cs := &clientSession{RoomID: "room1", ClientID: "p1", MACKey: "mackey1", AuthKey: "authkey1", EvSeq: 3}
+	w.update(&sessionSnapshot{&roomSession{RoomID: "room1", MasterID: "p1"}, []*clientSession{cs}})
+
+	args := make([]driver.Value, len(dbCols(reflect.TypeOf(clientSession{}))))
+	for i := range args {
+		args[i] = notSecret{"mackey1", "authkey1"}
+	}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO room_session ")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO client_session ")).WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := w.flush(); err != nil {
+		t.Fatalf("flush: %+v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+
+	sealed, err := cs.seal("sessionkey")
+	if err != nil {
+		t.Fatalf("seal: %+v", err)
+	}
+	if cs.MACKey != "mackey1" || cs.AuthKey != "authkey1" {
+		t.Fatalf("seal modified the original: %#v", cs)
+	}
+	if err := sealed.open("otherkey"); err == nil {
+		t.Fatalf("open with other key must fail")
+	}
+	if err := sealed.open("sessionkey"); err != nil {
+		t.Fatalf("open: %+v", err)
+	}
+	if sealed.MACKey != "mackey1" || sealed.AuthKey != "authkey1" {
+		t.Fatalf("opened = %q, %q", sealed.MACKey, sealed.AuthKey)
+	}
+}
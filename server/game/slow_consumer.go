@@ -0,0 +1,90 @@
+package game
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shiguredo/websocket"
+
+	"wsnet2/binary"
+	"wsnet2/config"
+	"wsnet2/metrics"
+	"wsnet2/pb"
+)
+
+// slowConsumerDetector : Peerへの送信の遅れを数える. Peer.muWriteのロック中に使う
+type slowConsumerDetector struct {
+	strikes int // 閾値を超えた送信が連続した回数
+}
+
+// observe : 1回の送信で溜まっていたイベント数lagと送信にかかった時間latencyを記録する.
+// 閾値を超えた送信がconf.SlowConsumerStrikes回続いたらtrueを返し、数え直す.
+func (d *slowConsumerDetector) observe(conf *config.ClientConf, lag int, latency time.Duration) bool {
+	slow := (conf.SlowConsumerLag > 0 && lag >= conf.SlowConsumerLag) ||
+		(conf.SlowConsumerLatency > 0 && latency >= time.Duration(conf.SlowConsumerLatency))
+	if !slow {
+		d.strikes = 0
+		return false
+	}
+	d.strikes++
+	if d.strikes < conf.SlowConsumerStrikes {
+		return false
+	}
+	d.strikes = 0
+	return true
+}
+
+// evictSlowConsumer : policyに従って受信の遅いクライアントを切断するか.
+// Hubを切断すると配下の観戦者も遅れるので切断しない.
+func evictSlowConsumer(policy pb.SlowConsumerPolicy, isPlayer, isHub bool) bool {
+	if isHub {
+		return false
+	}
+	switch policy {
+	case pb.SlowConsumerPolicyDisconnect:
+		return true
+	case pb.SlowConsumerPolicyDisconnectWatchers:
+		return !isPlayer
+	}
+	return false
+}
+
+// slowConsumerPolicy : RoomOptionの指定が無ければgameサーバの設定に従う
+func slowConsumerPolicy(opt pb.SlowConsumerPolicy, conf *config.ClientConf) pb.SlowConsumerPolicy {
+	if opt == pb.SlowConsumerPolicyDefault {
+		return conf.SlowConsumerPolicy
+	}
+	return opt
+}
+
+// SlowConsumerPolicy : 受信が遅れ続けるクライアントの扱い (IRoom実装)
+func (r *Room) SlowConsumerPolicy() pb.SlowConsumerPolicy {
+	return slowConsumerPolicy(r.slowConsumer, &r.conf.ClientConf)
+}
+
+// checkSlowConsumer : SendEventsで送信した後に呼ばれ、受信が遅れ続けていたら警告し、部屋の設定によっては切断する.
+// muWriteのロック中に呼ぶこと.
+func (p *Peer) checkSlowConsumer(lag int, latency time.Duration) {
+	cli := p.client
+	if !p.slow.observe(cli.room.ClientConf(), lag, latency) {
+		return
+	}
+	if p.slowConsumerEvent {
+		data := binary.NewEvSlowConsumer(lag, uint32(latency/time.Millisecond)).Marshal()
+		if err := writeMessage(p.conn, websocket.BinaryMessage, data); err == nil {
+			cli.addTraffic(0, len(data))
+		}
+	}
+	if !evictSlowConsumer(cli.room.SlowConsumerPolicy(), cli.isPlayer.Load(), cli.IsHub) {
+		cli.logger.Infof("slow consumer (%v, peer=%p): lag=%v latency=%v", cli.Id, p, lag, latency)
+		metrics.SlowConsumers.Add("warn", 1)
+		return
+	}
+	cli.logger.Warnf("slow consumer disconnected (%v, peer=%p): lag=%v latency=%v", cli.Id, p, lag, latency)
+	metrics.SlowConsumers.Add("disconnect", 1)
+	writeMessage(p.conn, websocket.CloseMessage,
+		formatCloseMessage(binary.CloseCodeSlowConsumer, binary.CloseReasonSlowConsumer,
+			fmt.Sprintf("lag=%v latency=%v", lag, latency)))
+	p.closed = true
+	p.conn.Close()
+}
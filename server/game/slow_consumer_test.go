@@ -0,0 +1,75 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"wsnet2/config"
+	"wsnet2/pb"
+)
+
+func TestSlowConsumerDetector(t *testing.T) {
+	conf := &config.ClientConf{
+		SlowConsumerLatency: config.Duration(time.Second),
+		SlowConsumerLag:     10,
+		SlowConsumerStrikes: 3,
+	}
+	tests := []struct {
+		lag     int
+		latency time.Duration
+		want    bool
+	}{
+		{10, 0, false},
+		{1, 2 * time.Second, false},
+		// 遅れていない送信があれば数え直す
+		{1, time.Millisecond, false},
+		{10, 0, false},
+		{20, 0, false},
+		{1, time.Second, true},
+		// 警告した後も数え直す
+		{20, 0, false},
+	}
+	var d slowConsumerDetector
+	for i, tc := range tests {
+		if got := d.observe(conf, tc.lag, tc.latency); got != tc.want {
+			t.Fatalf("#%v observe(%v, %v) = %v, wants %v", i, tc.lag, tc.latency, got, tc.want)
+		}
+	}
+
+	// 閾値が0なら判定しない
+	var d2 slowConsumerDetector
+	for i := 0; i < 5; i++ {
+		if d2.observe(&config.ClientConf{SlowConsumerStrikes: 1}, 1000, time.Minute) {
+			t.Fatalf("observe() = true without thresholds")
+		}
+	}
+}
+
+func TestEvictSlowConsumer(t *testing.T) {
+	tests := []struct {
+		policy   pb.SlowConsumerPolicy
+		isPlayer bool
+		isHub    bool
+		want     bool
+	}{
+		{pb.SlowConsumerPolicyWarn, false, false, false},
+		{pb.SlowConsumerPolicyDisconnectWatchers, true, false, false},
+		{pb.SlowConsumerPolicyDisconnectWatchers, false, false, true},
+		{pb.SlowConsumerPolicyDisconnectWatchers, false, true, false},
+		{pb.SlowConsumerPolicyDisconnect, true, false, true},
+		{pb.SlowConsumerPolicyDisconnect, false, true, false},
+	}
+	for _, tc := range tests {
+		if got := evictSlowConsumer(tc.policy, tc.isPlayer, tc.isHub); got != tc.want {
+			t.Errorf("evictSlowConsumer(%v, %v, %v) = %v, wants %v", tc.policy, tc.isPlayer, tc.isHub, got, tc.want)
+		}
+	}
+
+	conf := &config.ClientConf{SlowConsumerPolicy: pb.SlowConsumerPolicyDisconnectWatchers}
+	if p := slowConsumerPolicy(pb.SlowConsumerPolicyDefault, conf); p != pb.SlowConsumerPolicyDisconnectWatchers {
+		t.Errorf("slowConsumerPolicy(default) = %v, wants %v", p, pb.SlowConsumerPolicyDisconnectWatchers)
+	}
+	if p := slowConsumerPolicy(pb.SlowConsumerPolicyWarn, conf); p != pb.SlowConsumerPolicyWarn {
+		t.Errorf("slowConsumerPolicy(warn) = %v, wants %v", p, pb.SlowConsumerPolicyWarn)
+	}
+}
@@ -0,0 +1,365 @@
+package game
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shiguredo/websocket"
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+)
+
+// Server-Sent Eventsでの観戦:
+// websocketを使えないブラウザなどの観戦者に、読み取り専用のHTTPストリームでイベントを配信する.
+// websocketの1フレームのイベントを1つのSSEのメッセージにする. EvTypeBatchはイベント毎に分ける.
+//   - id: RegularEventのsequence number (再接続時にLast-Event-IDで届く)
+//   - event: EvTypeの名前 (例: "EvTypeMessage"). Close frameは "close"
+//   - data: イベントのフレーム (Close frameはpayload) をbase64にしたもの
+//
+// クライアントからMsgを送れないので、タイムアウトしないようにサーバがクライアントの代わりにPingを送る.
+// PingへのEvTypePongは配信しない.
+
+// SSEEventClose : Close frameを配信するときのSSEのevent名
+const SSEEventClose = "close"
+
+// SSETicketTTL : SSEの接続チケットの有効期間
+const SSETicketTTL = 30 * time.Second
+
+// SSERequest : SSEの接続の認証情報. EventSourceはヘッダを付けられないので、
+// 先に SSETicketRequest で発行した一度きりのチケットをクエリで受け取る.
+// ヘッダを付けられるクライアントは、チケットの代わりにAuthorizationヘッダで認証できる.
+//   - ticket: チケット. 無ければ app, user クエリと Authorization ヘッダ
+//   - seq: 最後に受け取ったイベントのsequence number. EventSourceが再接続時に付けるLast-Event-IDヘッダを優先する
+//   - protocol_version: 省略時はbinary.ProtocolVersion1
+//
+// チケットは一度しか使えないので、EventSourceが自動で再接続すると失敗する.
+// 切れたらチケットを発行し直し、seqを付けて接続し直すこと.
+func SSERequest(r *http.Request, roomId string, tickets *SSETickets) (*binary.Handshake, error) {
+	q := r.URL.Query()
+	var hs *binary.Handshake
+	if t := q.Get("ticket"); t != "" {
+		var err error
+		if hs, err = tickets.take(roomId, t); err != nil {
+			return nil, err
+		}
+	} else {
+		hs = &binary.Handshake{
+			AppId:    q.Get("app"),
+			ClientId: q.Get("user"),
+			AuthData: bearer(r),
+		}
+	}
+	hs.ProtocolVersion = binary.ProtocolVersion1
+	seq := q.Get("seq")
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		seq = id
+	}
+	if seq != "" {
+		n, err := strconv.Atoi(seq)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid seq: %v", seq)
+		}
+		hs.LastEvSeq = n
+	}
+	if v := q.Get("protocol_version"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid protocol_version: %v", v)
+		}
+		hs.ProtocolVersion = n
+	}
+	if hs.AppId == "" || hs.ClientId == "" || hs.AuthData == "" {
+		return nil, xerrors.Errorf("app, user and authorization are required")
+	}
+	return hs, nil
+}
+
+// SSETicketRequest : チケットを発行するリクエストの認証情報. websocketと同じく Wsnet2-App, Wsnet2-User, Authorization ヘッダで受け取る
+func SSETicketRequest(r *http.Request) (*binary.Handshake, error) {
+	hs := &binary.Handshake{
+		AppId:    r.Header.Get("Wsnet2-App"),
+		ClientId: r.Header.Get("Wsnet2-User"),
+		AuthData: bearer(r),
+	}
+	if hs.AppId == "" || hs.ClientId == "" || hs.AuthData == "" {
+		return nil, xerrors.Errorf("Wsnet2-App, Wsnet2-User and Authorization are required")
+	}
+	return hs, nil
+}
+
+func bearer(r *http.Request) string {
+	if ad := r.Header.Get("Authorization"); strings.HasPrefix(ad, "Bearer ") {
+		return ad[len("Bearer "):]
+	}
+	return ""
+}
+
+// SSETickets : 認証済みのクライアントに発行したSSEの接続チケット
+type SSETickets struct {
+	mu      sync.Mutex
+	tickets map[string]*sseTicket
+}
+
+type sseTicket struct {
+	roomId string
+	hs     binary.Handshake
+	expire time.Time
+}
+
+func NewSSETickets() *SSETickets {
+	return &SSETickets{tickets: make(map[string]*sseTicket)}
+}
+
+// Issue : 認証済みの hs で roomId の部屋に接続するチケットを発行する
+func (t *SSETickets) Issue(roomId string, hs *binary.Handshake) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	ticket := hex.EncodeToString(b)
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, v := range t.tickets {
+		if now.After(v.expire) {
+			delete(t.tickets, k)
+		}
+	}
+	t.tickets[ticket] = &sseTicket{roomId: roomId, hs: *hs, expire: now.Add(SSETicketTTL)}
+	return ticket
+}
+
+// take : チケットを使う. 使ったチケットは消える
+func (t *SSETickets) take(roomId, ticket string) (*binary.Handshake, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, ok := t.tickets[ticket]
+	if !ok {
+		return nil, xerrors.Errorf("unknown ticket")
+	}
+	delete(t.tickets, ticket)
+	if time.Now().After(v.expire) {
+		return nil, xerrors.Errorf("ticket expired")
+	}
+	if v.roomId != roomId {
+		return nil, xerrors.Errorf("ticket for another room: %v", v.roomId)
+	}
+	hs := v.hs
+	return &hs, nil
+}
+
+// SSEAllowOrigin : リクエストのOriginが origins (see config.ClientConf.SSEAllowOrigins) にあればCORSのヘッダを付ける.
+// チケットの発行はヘッダを付けるのでpreflightも許可する.
+func SSEAllowOrigin(w http.ResponseWriter, r *http.Request, origins []string) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	for _, o := range origins {
+		if o == "*" || o == origin {
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", o)
+			h.Set("Access-Control-Allow-Headers", "Authorization, Wsnet2-App, Wsnet2-User, Last-Event-ID")
+			h.Set("Access-Control-Allow-Methods", "GET, POST")
+			h.Add("Vary", "Origin")
+			return
+		}
+	}
+}
+
+// ServeSSE : 観戦者にServer-Sent Eventsでイベントを配信する. 接続が切れるまで戻らない.
+// Playerは受け付けずに403を返す.
+func ServeSSE(ctx context.Context, w http.ResponseWriter, cli *Client, lastEvSeq, protocolVersion int) error {
+	if cli.isPlayer.Load() {
+		w.Header().Set(binary.ErrorCodeHeader, strconv.Itoa(int(binary.ErrorCodePermissionDenied)))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return xerrors.Errorf("client is not a watcher: %v", cli.Id)
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return xerrors.Errorf("streaming unsupported")
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	conn := newSSEConn(ctx, w, cli)
+	defer conn.Close()
+
+	peer, err := NewPeer(ctx, cli, conn, lastEvSeq, protocolVersion)
+	if err != nil {
+		return err
+	}
+	<-peer.Done()
+	return nil
+}
+
+// sseConn : PeerからはwebsocketのConnに見えるSSEのストリーム
+type sseConn struct {
+	ctx    context.Context
+	client *Client
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+var _ peerConn = &sseConn{}
+
+func newSSEConn(ctx context.Context, w http.ResponseWriter, cli *Client) *sseConn {
+	return &sseConn{
+		ctx:    ctx,
+		client: cli,
+		w:      w,
+		rc:     http.NewResponseController(w),
+		done:   make(chan struct{}),
+	}
+}
+
+// pingInterval : クライアントの代わりにPingを送る間隔
+func (c *sseConn) pingInterval() time.Duration {
+	d := time.Duration(c.client.deadline.Load()) / 3
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// ReadMessage : クライアントの代わりのPingを定期的に返す. 合わせてSSEのコメントを送り、中継するproxyの切断を防ぐ.
+func (c *sseConn) ReadMessage() (int, []byte, error) {
+	t := time.NewTimer(c.pingInterval())
+	defer t.Stop()
+	select {
+	case <-c.ctx.Done():
+		return 0, nil, &websocket.CloseError{Code: websocket.CloseGoingAway, Text: "sse stream closed"}
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	case <-t.C:
+	}
+	c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := c.write([]byte(":\n\n")); err != nil {
+		return 0, nil, err
+	}
+	return websocket.BinaryMessage, binary.NewMsgPing(time.Now()).Marshal(c.client.hmac), nil
+}
+
+func (c *sseConn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case websocket.CloseMessage:
+		err := c.write(sseMessage("", SSEEventClose, data))
+		c.Close()
+		return err
+	case websocket.BinaryMessage:
+		return c.writeFrame(data)
+	}
+	return nil
+}
+
+func (c *sseConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	return &sseWriter{conn: c, messageType: messageType}, nil
+}
+
+func (c *sseConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return c.rc.SetWriteDeadline(t)
+}
+
+func (c *sseConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	return nil
+}
+
+// writeFrame : websocketのフレームをイベント毎のSSEのメッセージにして送る
+func (c *sseConn) writeFrame(data []byte) error {
+	ev, seq, err := binary.UnmarshalEvent(data)
+	if err != nil {
+		return err
+	}
+	switch ev.Type() {
+	case binary.EvTypePong:
+		// クライアントの代わりに送ったPingの応答
+		return nil
+	case binary.EvTypeBatch:
+		entries, err := binary.UnmarshalBatchPayload(ev.Payload())
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		for _, e := range entries {
+			ev, seq, err := binary.UnmarshalEvent(e)
+			if err != nil {
+				return err
+			}
+			buf.Write(sseMessage(strconv.Itoa(seq), ev.Type().String(), e))
+		}
+		return c.write(buf.Bytes())
+	}
+	var id string
+	if _, ok := ev.(*binary.RegularEvent); ok {
+		id = strconv.Itoa(seq)
+	}
+	return c.write(sseMessage(id, ev.Type().String(), data))
+}
+
+func (c *sseConn) write(p []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if _, err := c.w.Write(p); err != nil {
+		return err
+	}
+	return c.rc.Flush()
+}
+
+func sseMessage(id, event string, data []byte) []byte {
+	var b bytes.Buffer
+	if id != "" {
+		b.WriteString("id: " + id + "\n")
+	}
+	b.WriteString("event: " + event + "\n")
+	b.WriteString("data: " + base64.StdEncoding.EncodeToString(data) + "\n\n")
+	return b.Bytes()
+}
+
+type sseWriter struct {
+	conn        *sseConn
+	messageType int
+	buf         bytes.Buffer
+}
+
+func (w *sseWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *sseWriter) Close() error {
+	return w.conn.WriteMessage(w.messageType, w.buf.Bytes())
+}
@@ -0,0 +1,148 @@
+package game
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shiguredo/websocket"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestSSEConn(t *testing.T) {
+	w := httptest.NewRecorder()
+	cli := &Client{ClientInfo: &pb.ClientInfo{Id: "watcher"}}
+	conn := newSSEConn(context.Background(), w, cli)
+
+	msg := binary.NewEvMessage("player", binary.MarshalStr8("hello"))
+	if err := conn.WriteMessage(websocket.BinaryMessage, msg.Marshal(3)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	// Pingの応答は配信しない
+	if err := conn.WriteMessage(websocket.BinaryMessage, binary.NewEvPong(0, 1, nil).Marshal()); err != nil {
+		t.Fatalf("write pong: %v", err)
+	}
+	// Batchはイベント毎に分ける
+	batch := binary.MarshalBatch([]*binary.RegularEvent{msg, msg}, 4)
+	wr, _ := conn.NextWriter(websocket.BinaryMessage)
+	wr.Write(batch)
+	if err := wr.Close(); err != nil {
+		t.Fatalf("write batch: %v", err)
+	}
+	if err := conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye")); err != nil {
+		t.Fatalf("write close: %v", err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, msg.Marshal(6)); err == nil {
+		t.Fatalf("write after close must fail")
+	}
+
+	msgs := strings.Split(strings.TrimSuffix(w.Body.String(), "\n\n"), "\n\n")
+	if len(msgs) != 4 {
+		t.Fatalf("messages = %q, wants 4 messages", msgs)
+	}
+	for i, seq := range []string{"3", "4", "5"} {
+		lines := strings.Split(msgs[i], "\n")
+		if len(lines) != 3 || lines[0] != "id: "+seq || lines[1] != "event: EvTypeMessage" {
+			t.Fatalf("message[%d] = %q", i, msgs[i])
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(lines[2], "data: "))
+		if err != nil {
+			t.Fatalf("data[%d]: %v", i, err)
+		}
+		ev, s, err := binary.UnmarshalEvent(data)
+		if err != nil || ev.Type() != binary.EvTypeMessage || s != i+3 {
+			t.Fatalf("event[%d] = (%v, %v, %v)", i, ev, s, err)
+		}
+	}
+	if !strings.HasPrefix(msgs[3], "event: "+SSEEventClose+"\n") {
+		t.Fatalf("close message = %q", msgs[3])
+	}
+
+	// 閉じたら読み込みも終わる
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Fatalf("read after close must fail")
+	}
+}
+
+func TestSSERequest(t *testing.T) {
+	// Authorizationヘッダで認証する
+	r := httptest.NewRequest("GET", "/room/1234/events?app=app&user=watcher&seq=10&protocol_version=5", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	hs, err := SSERequest(r, "1234", NewSSETickets())
+	if err != nil {
+		t.Fatalf("SSERequest: %v", err)
+	}
+	want := binary.Handshake{AppId: "app", ClientId: "watcher", AuthData: "token", LastEvSeq: 10, ProtocolVersion: 5}
+	if *hs != want {
+		t.Fatalf("request = %+v, wants %+v", hs, want)
+	}
+
+	// 再接続時はLast-Event-IDから再開する
+	r.Header.Set("Last-Event-ID", "20")
+	if hs, _ := SSERequest(r, "1234", NewSSETickets()); hs.LastEvSeq != 20 {
+		t.Fatalf("LastEvSeq = %v, wants 20", hs.LastEvSeq)
+	}
+
+	// クエリの認証データは受け付けない
+	r = httptest.NewRequest("GET", "/room/1234/events?app=app&user=watcher&auth=token", nil)
+	if _, err := SSERequest(r, "1234", NewSSETickets()); err == nil {
+		t.Fatalf("SSERequest without Authorization must fail")
+	}
+}
+
+func TestSSETicket(t *testing.T) {
+	tickets := NewSSETickets()
+	r := httptest.NewRequest("POST", "/room/1234/events/ticket", nil)
+	r.Header.Set("Wsnet2-App", "app")
+	r.Header.Set("Wsnet2-User", "watcher")
+	r.Header.Set("Authorization", "Bearer token")
+	hs, err := SSETicketRequest(r)
+	if err != nil {
+		t.Fatalf("SSETicketRequest: %v", err)
+	}
+	ticket := tickets.Issue("1234", hs)
+	other := tickets.Issue("1234", hs)
+
+	r = httptest.NewRequest("GET", "/room/1234/events?seq=10&ticket="+ticket, nil)
+	hs, err = SSERequest(r, "1234", tickets)
+	if err != nil {
+		t.Fatalf("SSERequest: %v", err)
+	}
+	want := binary.Handshake{AppId: "app", ClientId: "watcher", AuthData: "token", LastEvSeq: 10, ProtocolVersion: binary.ProtocolVersion1}
+	if *hs != want {
+		t.Fatalf("request = %+v, wants %+v", hs, want)
+	}
+
+	// チケットは一度しか使えない
+	if _, err := SSERequest(r, "1234", tickets); err == nil {
+		t.Fatalf("SSERequest with used ticket must fail")
+	}
+	// 他の部屋には使えない
+	r = httptest.NewRequest("GET", "/room/5678/events?ticket="+other, nil)
+	if _, err := SSERequest(r, "5678", tickets); err == nil {
+		t.Fatalf("SSERequest with ticket for another room must fail")
+	}
+}
+
+func TestSSEAllowOrigin(t *testing.T) {
+	origins := []string{"https://example.com"}
+	for origin, want := range map[string]string{
+		"https://example.com": "https://example.com",
+		"https://evil.com":    "",
+		"":                    "",
+	} {
+		r := httptest.NewRequest("GET", "/room/1234/events", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		SSEAllowOrigin(w, r, origins)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != want {
+			t.Fatalf("origin %q: Access-Control-Allow-Origin = %q, wants %q", origin, got, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package game
+
+import (
+	"net/http"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+)
+
+// UpgradeError : websocketのUpgrade前の検査で接続を拒否するときのHTTPステータスとErrorCode.
+// ErrorCodeはbinary.ErrorCodeHeaderでクライアントに通知する.
+type UpgradeError struct {
+	error
+	Status int
+	Code   binary.ErrorCode
+}
+
+func upgradeError(status int, code binary.ErrorCode, err error) *UpgradeError {
+	return &UpgradeError{err, status, code}
+}
+
+func (e *UpgradeError) Unwrap() error {
+	return e.error
+}
+
+// UpgradeRepo : websocket接続のWsnet2-AppヘッダのappのRepository.
+// serverAppはSNIに割り当てたapp (see config.GameConf.AppForServerName) で、空でなければappIdと一致しなければならない.
+func UpgradeRepo(repos []*Repository, appId, serverApp string) (*Repository, *UpgradeError) {
+	if serverApp != "" && serverApp != appId {
+		return nil, upgradeError(http.StatusForbidden, binary.ErrorCodeAppMismatch,
+			xerrors.Errorf("app mismatch: app=%v, server name is for app=%v", appId, serverApp))
+	}
+	for _, repo := range repos {
+		if repo.app.Id == appId {
+			return repo, nil
+		}
+	}
+	// 部屋の作成や入室(gRPC)の時点でappは読み込まれている
+	return nil, upgradeError(http.StatusBadRequest, binary.ErrorCodeInvalidArgument,
+		xerrors.Errorf("invalid appId: %v", appId))
+}
+
+// UpgradeClient : websocket接続のクライアント.
+// 部屋が別のappのものならErrorCodeAppMismatchで拒否し、他のappの部屋に接続できないようにする.
+func UpgradeClient(repos []*Repository, appId, serverApp, roomId, clientId string) (*Client, *UpgradeError) {
+	repo, uerr := UpgradeRepo(repos, appId, serverApp)
+	if uerr != nil {
+		return nil, uerr
+	}
+	cli, err := repo.GetClient(roomId, clientId)
+	if err == nil {
+		return cli, nil
+	}
+	for _, other := range repos {
+		if other == repo {
+			continue
+		}
+		if _, e := other.GetRoom(roomId); e == nil {
+			return nil, upgradeError(http.StatusForbidden, binary.ErrorCodeAppMismatch,
+				xerrors.Errorf("app mismatch: room=%v is not in app=%v", roomId, appId))
+		}
+	}
+	return nil, upgradeError(http.StatusNotFound, binary.ErrorCodeRoomNotFound, err)
+}
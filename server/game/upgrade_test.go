@@ -0,0 +1,55 @@
+package game
+
+import (
+	"net/http"
+	"testing"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestUpgradeClient(t *testing.T) {
+	cli := &Client{ClientInfo: &pb.ClientInfo{Id: "user1"}}
+	app1 := &Repository{
+		app:     &pb.App{Id: "app1"},
+		rooms:   map[RoomID]*Room{"room1": {}},
+		clients: map[ClientID]map[RoomID]*Client{"user1": {"room1": cli}},
+	}
+	app2 := &Repository{
+		app:     &pb.App{Id: "app2"},
+		rooms:   map[RoomID]*Room{},
+		clients: map[ClientID]map[RoomID]*Client{},
+	}
+	repos := []*Repository{app1, app2}
+
+	c, err := UpgradeClient(repos, "app1", "", "room1", "user1")
+	if err != nil || c != cli {
+		t.Fatalf("UpgradeClient = (%v, %v), wants (%v, nil)", c, err, cli)
+	}
+	c, err = UpgradeClient(repos, "app1", "app1", "room1", "user1")
+	if err != nil || c != cli {
+		t.Fatalf("UpgradeClient with server name = (%v, %v), wants (%v, nil)", c, err, cli)
+	}
+
+	tests := map[string]struct {
+		appId, serverApp, roomId string
+		status                   int
+		code                     binary.ErrorCode
+	}{
+		"unknown app":          {"app3", "", "room1", http.StatusBadRequest, binary.ErrorCodeInvalidArgument},
+		"room of other app":    {"app2", "", "room1", http.StatusForbidden, binary.ErrorCodeAppMismatch},
+		"server name mismatch": {"app1", "app2", "room1", http.StatusForbidden, binary.ErrorCodeAppMismatch},
+		"room not found":       {"app1", "", "room2", http.StatusNotFound, binary.ErrorCodeRoomNotFound},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, err := UpgradeClient(repos, tc.appId, tc.serverApp, tc.roomId, "user1")
+			if err == nil {
+				t.Fatalf("UpgradeClient = %v, wants error", c)
+			}
+			if err.Status != tc.status || err.Code != tc.code {
+				t.Fatalf("error = (%v, %v), wants (%v, %v): %v", err.Status, err.Code, tc.status, tc.code, err)
+			}
+		})
+	}
+}
@@ -14,12 +14,18 @@ import (
 
 	"wsnet2/binary"
 	"wsnet2/client"
+	"wsnet2/common"
 	"wsnet2/config"
 	"wsnet2/game"
 	"wsnet2/log"
+	"wsnet2/metrics"
 	"wsnet2/pb"
+	"wsnet2/relay"
 )
 
+// relayChSize : relayで受け取ってまだConnectionに渡していないイベントの上限
+const relayChSize = 256
+
 type Hub struct {
 	repo     *Repository
 	hubPK    int64
@@ -36,8 +42,13 @@ type Hub struct {
 	watchers map[ClientID]*game.Client
 	wgClient sync.WaitGroup
 
+	// degraded : gameへの接続が切れて再接続中.
+	// この間もwatcherの接続は維持し、再接続後にLastEventSeq以降のイベントを中継する.
+	// relayが有効なら再接続を待たずにrelayで受け取ったイベントを中継する.
+	degraded bool
+
 	// game に通知した直近の nodeCount
-	lastNodeCount    uint32
+	lastNodeCount    atomic.Uint32
 	nodeCount        atomic.Uint32
 	nodeCountUpdated chan struct{}
 
@@ -94,6 +105,9 @@ func NewHub(repo *Repository, pk int64, appid AppID, roomid RoomID, grpc *grpc.C
 
 	go hub.ProcessLoop()
 	go hub.nodeCountUpdater()
+	if repo.relay != nil {
+		go hub.relayLoop(repo.relay)
+	}
 
 	return hub, nil
 }
@@ -106,6 +120,10 @@ func (h *Hub) ClientConf() *config.ClientConf {
 	return &h.repo.conf.ClientConf
 }
 
+func (h *Hub) MACAlgorithms() []string {
+	return h.repo.conf.MACAlgorithmsFor(string(h.appId))
+}
+
 func (h *Hub) Repo() game.IRepo {
 	return h.repo
 }
@@ -122,6 +140,14 @@ func (h *Hub) Logger() log.Logger {
 	return h.logger
 }
 
+func (h *Hub) Clock() common.Clock {
+	return common.RealClock
+}
+
+func (h *Hub) Go(f func()) {
+	go f()
+}
+
 func (h *Hub) Done() <-chan struct{} {
 	return h.done
 }
@@ -133,6 +159,26 @@ func (h *Hub) SendMessage(msg game.Msg) {
 	}
 }
 
+// SlowConsumerPolicy : 部屋のRoomOptionは分からないのでHubの設定に従う
+func (h *Hub) SlowConsumerPolicy() pb.SlowConsumerPolicy {
+	return h.repo.conf.SlowConsumerPolicy
+}
+
+// PingInterval : gameサーバから指定された間隔を観戦者にも使う
+func (h *Hub) PingInterval() time.Duration {
+	return h.conn.PingInterval()
+}
+
+// NetSim : Hubでは通信状況を模擬しない
+func (h *Hub) NetSim() *game.NetSim {
+	return nil
+}
+
+// AddTraffic : watcherとの送受信バイト数をapp毎の集計に加算する
+func (h *Hub) AddTraffic(in, out int) {
+	metrics.AddAppTraffic(h.appId, in, out)
+}
+
 func (h *Hub) removeWatcher(cid game.ClientID, cause string) {
 	c, ok := h.watchers[cid]
 	if !ok {
@@ -170,7 +216,7 @@ func (h *Hub) nodeCountUpdater() {
 		}
 
 		count := h.nodeCount.Load()
-		if count == h.lastNodeCount {
+		if count == h.lastNodeCount.Load() {
 			continue
 		}
 
@@ -184,7 +230,7 @@ func (h *Hub) nodeCountUpdater() {
 			default:
 			}
 		} else {
-			h.lastNodeCount = count
+			h.lastNodeCount.Store(count)
 		}
 
 		select {
@@ -195,6 +241,50 @@ func (h *Hub) nodeCountUpdater() {
 	}
 }
 
+// relayLoop : relayで受け取ったイベントをConnectionに渡す.
+// websocketで受け取り済みのものや順番が飛んだものはConnectionが無視する.
+// relayが主経路なら、受け取ったところまでをEventAckでgameに通知する.
+func (h *Hub) relayLoop(s *relay.Subscriber) {
+	ch := make(chan []byte, relayChSize)
+	s.Subscribe(h.clientId, func(data []byte) {
+		select {
+		case ch <- data:
+		default:
+			// 溢れた分はwebsocketで受け取る
+		}
+	})
+	defer s.Unsubscribe(h.clientId)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-h.done
+		cancel()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-ch:
+			ok, err := h.conn.Inject(ctx, data)
+			if err != nil {
+				h.logger.Warnf("relay inject: %+v", err)
+				continue
+			}
+			if ok {
+				metrics.RelayedEvents.Add(1)
+				if s.Primary() && len(ch) == 0 {
+					// gameにwebsocketで送らなくてよいことを知らせる. 失敗してもwebsocketで届くだけ
+					if err := h.conn.SendEventAck(); err != nil {
+						h.logger.Debugf("relay ack: %v", err)
+					}
+				}
+			}
+		}
+	}
+}
+
 // ProcessLoop goroutine dispatch messages and events.
 func (h *Hub) ProcessLoop() {
 Loop:
@@ -210,16 +300,49 @@ Loop:
 			if err := h.room.Update(ev); err != nil {
 				h.logger.Errorf("room update: %+v", err)
 			}
-			if binary.IsRegularEvent(ev) {
+			if ev.Type() == binary.EvTypePromoteRedirect {
+				h.redirectPromoted(ev.(*binary.RegularEvent))
+				continue
+			}
+			// 観戦者はEvTypeRedactedに対応していないことがあるので送らない
+			if binary.IsRegularEvent(ev) && ev.Type() != binary.EvTypeRedacted {
 				h.logger.Debugf("broadcast: %v", ev.Type())
 				h.broadcast(ev.(*binary.RegularEvent))
 			}
+		case connected := <-h.conn.StateChanged():
+			h.setDegraded(!connected)
 		}
 	}
+	if h.degraded {
+		metrics.DegradedHubs.Add(-1)
+	}
 	h.drainMsg()
 	h.logger.Debug("Hub.ProcessLoop() finish")
 }
 
+// setDegraded : gameとの接続状態の変化をwatcherに通知する.
+func (h *Hub) setDegraded(degraded bool) {
+	if h.degraded == degraded {
+		return
+	}
+	h.degraded = degraded
+
+	status := binary.HubStatusConnected
+	if degraded {
+		h.logger.Warnf("connection to game lost, reconnecting: room=%v", h.roomId)
+		metrics.DegradedHubs.Add(1)
+		status = binary.HubStatusDegraded
+	} else {
+		h.logger.Infof("connection to game recovered: room=%v", h.roomId)
+		metrics.DegradedHubs.Add(-1)
+	}
+
+	ev := binary.NewEvHubStatus(status)
+	for _, c := range h.watchers {
+		c.SendSystemEvent(ev)
+	}
+}
+
 // drainMsg drain msgCh until all clients closed.
 // clientのgoroutineがmsgChに書き込むところで停止するのを防ぐ
 func (h *Hub) drainMsg() {
@@ -262,6 +385,11 @@ func (h *Hub) dispatchMsg(msg game.Msg) {
 	case *game.MsgBroadcast:
 		m.Sender.Logger().Debugf("message to all: %v", m.Data)
 		h.proxyMessage(m.RegularMsg)
+	case *game.MsgToRole:
+		m.Sender.Logger().Debugf("message to role: %v, %v", m.Role, m.Data)
+		h.proxyMessage(m.RegularMsg)
+	case *game.MsgPromoteWatcher:
+		h.msgPromoteWatcher(m)
 
 	default:
 		h.logger.Errorf("unknown msg type: %T %v", m, m)
@@ -298,6 +426,13 @@ func (h *Hub) msgWatch(msg *game.MsgWatch) {
 		return
 	}
 
+	if _, rejoin := h.watchers[msg.SenderID()]; !rejoin && h.room.MaxWatchers > 0 && h.estimatedWatchers() >= h.room.MaxWatchers {
+		err := xerrors.Errorf("Watchers full. room=%v max=%v, client=%v", h.ID(), h.room.MaxWatchers, msg.Info.Id)
+		h.logger.Info(err.Error())
+		msg.Err <- game.WithCode(err, codes.ResourceExhausted)
+		return
+	}
+
 	client, err := game.NewWatcher(msg.Info, msg.MACKey, h)
 	if err != nil {
 		err = game.WithCode(
@@ -327,6 +462,7 @@ func (h *Hub) msgWatch(msg *game.MsgWatch) {
 		Number:       &pb.RoomNumber{Number: *h.room.Number},
 		SearchGroup:  h.room.SearchGroup,
 		MaxPlayers:   h.room.MaxPlayers,
+		MaxWatchers:  h.room.MaxWatchers,
 		Players:      uint32(len(h.room.Players)),
 		Watchers:     h.room.Watchers,
 		PublicProps:  binary.MarshalDict(h.room.PublicProps),
@@ -342,13 +478,39 @@ func (h *Hub) msgWatch(msg *game.MsgWatch) {
 		})
 	}
 
+	// 観戦専用の部屋にはMasterが居ない
+	var masterId game.ClientID
+	if h.room.Master != nil {
+		masterId = game.ClientID(h.room.Master.Id)
+	}
+
 	msg.Joined <- &game.JoinedInfo{
 		Room:     rinfo,
 		Players:  players,
 		Client:   client,
-		MasterId: game.ClientID(h.room.Master.Id),
+		MasterId: masterId,
 		Deadline: h.Deadline(),
 	}
+
+	if len(h.room.KV) > 0 {
+		owners := make(binary.Dict, len(h.room.KVOwners))
+		for k, o := range h.room.KVOwners {
+			owners[k] = binary.MarshalStr8(o)
+		}
+		if err := client.Send(binary.NewEvKVUpdated("", h.room.KV, owners)); err != nil {
+			h.removeWatcher(client.ID(), err.Error())
+		}
+	}
+}
+
+// estimatedWatchers : 部屋全体の観戦者数.
+// Gameから届いた観戦者数に、このHubでまだGameに通知していない増加分を加える.
+func (h *Hub) estimatedWatchers() uint32 {
+	w := h.room.Watchers
+	if n, last := h.nodeCount.Load(), h.lastNodeCount.Load(); n > last {
+		w += n - last
+	}
+	return w
 }
 
 func (h *Hub) msgLeave(msg *game.MsgLeave) {
@@ -362,6 +524,10 @@ func (h *Hub) msgPing(msg *game.MsgPing) {
 	msg.Sender.Logger().Debugf("ping %v: %v", msg.Sender.Id, msg.Timestamp)
 	ev := binary.NewEvPong(msg.Timestamp, h.room.Watchers, h.room.LastMsgTimes)
 	msg.Sender.SendSystemEvent(ev)
+	if h.degraded {
+		// 再接続中に観戦を始めたwatcherにも伝わるようpingごとに通知する
+		msg.Sender.SendSystemEvent(binary.NewEvHubStatus(binary.HubStatusDegraded))
+	}
 }
 
 func (h *Hub) msgClientError(msg *game.MsgClientError) {
@@ -372,6 +538,35 @@ func (h *Hub) msgClientTimeout(msg *game.MsgClientTimeout) {
 	h.removeWatcher(msg.Sender.ID(), "timeout")
 }
 
+// msgPromoteWatcher : 観戦者からの昇格の申請をgameに転送する. gameからMasterにEvTypePromoteRequestedが届く.
+// Hubが送信者になるので、他の観戦者の昇格の申請は転送しない
+func (h *Hub) msgPromoteWatcher(msg *game.MsgPromoteWatcher) {
+	if msg.Watcher != msg.Sender.ID() {
+		msg.Sender.Logger().Warnf("watcher %q requests promotion of %q", msg.Sender.ID(), msg.Watcher)
+		return
+	}
+	msg.Sender.Logger().Infof("promotion requested: %v", msg.Watcher)
+	h.proxyMessage(msg.RegularMsg)
+}
+
+// redirectPromoted : Masterが昇格を承認した観戦者にだけEvTypePromoteRedirectを送る.
+// 受け取った観戦者はHubから退室し、gameにPlayerとして入室し直す
+func (h *Hub) redirectPromoted(ev *binary.RegularEvent) {
+	id, err := binary.UnmarshalEvPromotePayload(ev.Payload())
+	if err != nil {
+		h.logger.Errorf("promote redirect: %+v", err)
+		return
+	}
+	c, ok := h.watchers[game.ClientID(id)]
+	if !ok {
+		return
+	}
+	c.Logger().Infof("promotion redirected: %v", id)
+	if err := c.Send(ev); err != nil {
+		h.removeWatcher(c.ID(), err.Error())
+	}
+}
+
 // clientから受け取った RegularMsg を gameサーバーに転送する
 func (h *Hub) proxyMessage(msg binary.RegularMsg) {
 	err := h.conn.Send(msg.Type(), msg.Payload())
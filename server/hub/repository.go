@@ -17,6 +17,7 @@ import (
 	"wsnet2/log"
 	"wsnet2/metrics"
 	"wsnet2/pb"
+	"wsnet2/relay"
 )
 
 type AppID = pb.AppId
@@ -29,6 +30,7 @@ type Repository struct {
 	conf     *config.HubConf
 	db       *sqlx.DB
 	grpcPool *common.GrpcPool
+	relay    *relay.Subscriber // nilならgameからのイベントをwebsocketでのみ受け取る
 
 	muhubs sync.RWMutex
 	hubs   map[RoomID]*Hub
@@ -55,6 +57,11 @@ func NewRepository(db *sqlx.DB, conf *config.HubConf, hostId uint32) (*Repositor
 	return repo, nil
 }
 
+// SetRelay : gameからのイベントをsからも受け取る. Hubを作る前に呼ぶこと
+func (r *Repository) SetRelay(s *relay.Subscriber) {
+	r.relay = s
+}
+
 func (r *Repository) insertHub(ctx context.Context, tx sqlx.ExecerContext, roomId RoomID) (int64, error) {
 	res, err := tx.ExecContext(ctx,
 		"INSERT INTO `hub` (`host_id`, `room_id`, `watchers`, `created`) VALUES (?,?,?,?)",
@@ -195,6 +202,9 @@ func (r *Repository) WatchRoom(ctx context.Context, appId AppID, roomId RoomID,
 		AuthKey:  cli.AuthKey(),
 		MasterId: string(joined.MasterId),
 		Deadline: uint32(joined.Deadline / time.Second),
+
+		NoReconnectCloseCodes: r.conf.NoReconnectCloseCodes,
+		MacAlgorithm:          cli.MACAlgorithm(),
 	}, nil
 }
 
@@ -233,4 +243,15 @@ func (r *Repository) GetHubCount() int {
 	return len(r.hubs)
 }
 
+// GetWatcherCount : このHubサーバに接続している観戦者数
+func (r *Repository) GetWatcherCount() int {
+	r.muhubs.RLock()
+	defer r.muhubs.RUnlock()
+	count := 0
+	for _, h := range r.hubs {
+		count += int(h.nodeCount.Load())
+	}
+	return count
+}
+
 func (r *Repository) PlayerLog(c *game.Client, msg game.PlayerLogMsg) {}
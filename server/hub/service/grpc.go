@@ -13,6 +13,7 @@ import (
 	"wsnet2/hub"
 	"wsnet2/log"
 	"wsnet2/pb"
+	"wsnet2/requestid"
 )
 
 func (sv *HubService) serveGRPC(ctx context.Context) <-chan error {
@@ -29,7 +30,9 @@ func (sv *HubService) serveGRPC(ctx context.Context) <-chan error {
 			return
 		}
 
-		server := grpc.NewServer()
+		server := grpc.NewServer(
+			grpc.ChainUnaryInterceptor(requestid.UnaryServerInterceptor),
+		)
 		pb.RegisterGameServer(server, sv)
 
 		c := make(chan error)
@@ -57,6 +60,7 @@ func (sv *HubService) Watch(ctx context.Context, in *pb.JoinRoomReq) (*pb.Joined
 		log.KeyClient, in.ClientInfo.Id,
 		log.KeyRoom, in.RoomId,
 		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(ctx),
 	)
 	logger.Debugf("gRPC Watch: %v %v", in.RoomId, in.ClientInfo)
 
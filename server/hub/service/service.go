@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -11,15 +12,19 @@ import (
 	"wsnet2/config"
 	"wsnet2/hub"
 	"wsnet2/log"
+	"wsnet2/metrics"
+	"wsnet2/middleware"
 	"wsnet2/pb"
+	"wsnet2/relay"
 )
 
 const (
 	registerQuery = "" +
-		"INSERT INTO `hub_server` (`hostname`, `public_name`, `grpc_port`, `ws_port`, `status`) VALUES (:hostname, :public_name, :grpc_port, :ws_port, :status) " +
-		"ON DUPLICATE KEY UPDATE `public_name`=:public_name, `grpc_port`=:grpc_port, `ws_port`=:ws_port, `status`=:status, id=last_insert_id(id)"
+		"INSERT INTO `hub_server` (`hostname`, `public_name`, `grpc_port`, `ws_port`, `ws_url`, `status`) VALUES (:hostname, :public_name, :grpc_port, :ws_port, :ws_url, :status) " +
+		"ON DUPLICATE KEY UPDATE `public_name`=:public_name, `grpc_port`=:grpc_port, `ws_port`=:ws_port, `ws_url`=:ws_url, `status`=:status, id=last_insert_id(id)"
 	heartbeatQuery = "" +
-		"UPDATE `hub_server` SET `status`=:status, heartbeat=:now WHERE `id`=:hostid"
+		"UPDATE `hub_server` SET `status`=:status, heartbeat=:now, " +
+		"`watchers`=:watchers, `rooms`=:rooms, `bandwidth`=:bandwidth WHERE `id`=:hostid"
 )
 
 type HubService struct {
@@ -27,19 +32,38 @@ type HubService struct {
 
 	HostId int64
 
-	conf *config.HubConf
-	repo *hub.Repository
+	conf  *config.HubConf
+	repo  *hub.Repository
+	relay *relay.Subscriber // nilならgameからのイベントをwebsocketでのみ受け取る
 
 	db          *sqlx.DB
 	preparation sync.WaitGroup
 
 	wsURLFormat string
 
+	opts Options
+
 	shutdownChan chan struct{}
 	done         chan error
+
+	// bandwidth算出用. heartbeatのgoroutineでのみ使う
+	lastBytesSent int64
+	lastLoadTime  time.Time
+}
+
+// Options : hubサーバを他のプロセスに組み込むときの設定. see NewWithOptions
+type Options struct {
+	// WebsocketMiddlewares : websocket接続のUpgrade前に適用するmiddleware.
+	// 設定のwebsocket_middlewareの後に適用する
+	WebsocketMiddlewares []middleware.Middleware
 }
 
 func New(db *sqlx.DB, conf *config.HubConf) (*HubService, error) {
+	return NewWithOptions(db, conf, Options{})
+}
+
+// NewWithOptions : websocketのmiddlewareなどを指定してHubServiceを作る
+func NewWithOptions(db *sqlx.DB, conf *config.HubConf, opts Options) (*HubService, error) {
 	hostId, err := registerHost(db, conf)
 	if err != nil {
 		return nil, err
@@ -50,12 +74,19 @@ func New(db *sqlx.DB, conf *config.HubConf) (*HubService, error) {
 		return nil, err
 	}
 
+	sub := relay.NewSubscriber(&conf.Relay)
+	if sub != nil {
+		repo.SetRelay(sub)
+	}
+
 	return &HubService{
 		HostId:       hostId,
 		conf:         conf,
 		repo:         repo,
+		relay:        sub,
 		db:           db,
 		preparation:  sync.WaitGroup{},
+		opts:         opts,
 		shutdownChan: make(chan struct{}),
 		done:         make(chan error),
 	}, nil
@@ -67,6 +98,7 @@ func registerHost(db *sqlx.DB, conf *config.HubConf) (int64, error) {
 		"public_name": conf.PublicName,
 		"grpc_port":   conf.GRPCPort,
 		"ws_port":     conf.WebsocketPort,
+		"ws_url":      roomURLPrefix(conf),
 		"status":      common.HostStatusRunning,
 	}
 	res, err := sqlx.NamedExec(db, registerQuery, bind)
@@ -76,6 +108,16 @@ func registerHost(db *sqlx.DB, conf *config.HubConf) (int64, error) {
 	return res.LastInsertId()
 }
 
+// roomURLPrefix : 部屋IDを後ろに付けると部屋のwebsocket URLになる文字列.
+// lobbyがwebsocketを中継するときに使えるようhub_server.ws_urlにも登録する.
+func roomURLPrefix(conf *config.HubConf) string {
+	scheme := "ws"
+	if conf.TLSCert != "" {
+		scheme = "wss"
+	}
+	return fmt.Sprintf("%s://%s:%d/room/", scheme, conf.PublicName, conf.WebsocketPort)
+}
+
 func (s *HubService) shutdownRequested() bool {
 	select {
 	case <-s.shutdownChan:
@@ -96,11 +138,24 @@ func (s *HubService) Serve(ctx context.Context) error {
 	case err = <-s.servePprof(ctx):
 	case err = <-s.serveGRPC(ctx):
 	case err = <-s.serveWebSocket(ctx):
+	case err = <-s.serveRelay(ctx):
 	case err = <-s.done:
 	}
 	return err
 }
 
+// serveRelay : gameからのイベントをRedisから受け取る
+func (s *HubService) serveRelay(ctx context.Context) <-chan error {
+	if s.relay == nil {
+		return nil
+	}
+	errCh := make(chan error)
+	go func() {
+		errCh <- s.relay.Serve(ctx)
+	}()
+	return errCh
+}
+
 // heartbeat :
 func (s *HubService) heartbeat(ctx context.Context) <-chan error {
 	wait := make(chan struct{})
@@ -130,7 +185,9 @@ func (s *HubService) heartbeat(ctx context.Context) <-chan error {
 			case <-t.C:
 			}
 
-			bind["now"] = time.Now().Unix()
+			now := time.Now()
+			bind["now"] = now.Unix()
+			s.fillLoad(bind, now)
 			if s.shutdownRequested() {
 				bind["status"] = common.HostStatusClosing
 			}
@@ -144,6 +201,21 @@ func (s *HubService) heartbeat(ctx context.Context) <-chan error {
 	return errCh
 }
 
+// fillLoad : Lobbyが観戦者をHubに割り当てるときに使う負荷情報をbindに設定する
+func (s *HubService) fillLoad(bind map[string]interface{}, now time.Time) {
+	bytes := metrics.BytesSent.Value()
+	var bandwidth int64
+	if d := now.Sub(s.lastLoadTime); !s.lastLoadTime.IsZero() && d > 0 {
+		bandwidth = int64(float64(bytes-s.lastBytesSent) / d.Seconds())
+	}
+	s.lastBytesSent = bytes
+	s.lastLoadTime = now
+
+	bind["watchers"] = s.repo.GetWatcherCount()
+	bind["rooms"] = s.repo.GetHubCount()
+	bind["bandwidth"] = bandwidth
+}
+
 // Shutdown requests the termination of the HubService and waits for the serving hubs to be closed.
 func (s *HubService) Shutdown(ctx context.Context) {
 	log.Infof("HubService %v is gracefully shutting down", s.HostId)
@@ -156,9 +228,12 @@ func (s *HubService) Shutdown(ctx context.Context) {
 
 	// Immediately execute a heartbeat query in order not to miss the status update
 	bind := map[string]interface{}{
-		"now":    time.Now().Unix(),
-		"hostid": s.HostId,
-		"status": common.HostStatusClosing,
+		"now":       time.Now().Unix(),
+		"hostid":    s.HostId,
+		"status":    common.HostStatusClosing,
+		"watchers":  s.repo.GetWatcherCount(),
+		"rooms":     s.repo.GetHubCount(),
+		"bandwidth": 0,
 	}
 	if _, err := sqlx.NamedExec(s.db, heartbeatQuery, bind); err != nil {
 		s.done <- err
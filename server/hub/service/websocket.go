@@ -15,9 +15,12 @@ import (
 	"github.com/shiguredo/websocket"
 	"golang.org/x/xerrors"
 
+	"wsnet2/binary"
 	"wsnet2/game"
 	"wsnet2/log"
 	"wsnet2/metrics"
+	"wsnet2/middleware"
+	"wsnet2/requestid"
 )
 
 const (
@@ -35,6 +38,9 @@ var (
 
 type WSHandler struct {
 	*HubService
+
+	// sseTickets : SSEの接続チケット. see game/sse.go
+	sseTickets *game.SSETickets
 }
 
 func (sv *HubService) serveWebSocket(ctx context.Context) <-chan error {
@@ -52,9 +58,7 @@ func (sv *HubService) serveWebSocket(ctx context.Context) <-chan error {
 			return
 		}
 
-		scheme := "ws"
 		if cert, key := sv.conf.TLSCert, sv.conf.TLSKey; cert != "" {
-			scheme = "wss"
 			log.Infof("loading tls key: %#v", cert)
 			cert, err := tls.LoadX509KeyPair(cert, key)
 			if err != nil {
@@ -67,12 +71,22 @@ func (sv *HubService) serveWebSocket(ctx context.Context) <-chan error {
 			listener = tls.NewListener(listener, tlsConf)
 		}
 
-		ws := &WSHandler{sv}
+		if sv.conf.DebugJSONEvents {
+			log.Infof("hub websocket: debug_json_events is enabled")
+			upgrader.Subprotocols = append(upgrader.Subprotocols, game.SubprotocolJSON)
+		}
+
+		ws := &WSHandler{sv, game.NewSSETickets()}
 		r := chi.NewMux()
-		r.Get("/room/{id:[0-9a-f]+}", ws.HandleRoom)
+		mws := []middleware.Middleware{requestid.Middleware}
+		mws = append(mws, middleware.FromConfig(&sv.conf.WebsocketMiddleware, "hub:websocket")...)
+		mws = append(mws, sv.opts.WebsocketMiddlewares...)
+		r.With(mws...).Get("/room/{id:[0-9a-f]+}", ws.HandleRoom)
+		r.With(mws...).Get("/room/{id:[0-9a-f]+}/events", ws.HandleSSE)
+		r.With(mws...).Post("/room/{id:[0-9a-f]+}/events/ticket", ws.HandleSSETicket)
+		r.With(mws...).Options("/room/{id:[0-9a-f]+}/events/ticket", ws.HandleSSEPreflight)
 
-		sv.wsURLFormat = fmt.Sprintf("%s://%s:%d/room/%%s",
-			scheme, sv.conf.PublicName, sv.conf.WebsocketPort)
+		sv.wsURLFormat = roomURLPrefix(sv.conf) + "%s"
 
 		svr := &http.Server{
 			Handler:      r,
@@ -89,6 +103,10 @@ func (sv *HubService) serveWebSocket(ctx context.Context) <-chan error {
 func (s *WSHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
 	roomId := chi.URLParam(r, "id")
 	appId := r.Header.Get("Wsnet2-App")
+	if appId == "" {
+		s.handleRoomHandshake(w, r, roomId)
+		return
+	}
 	clientId := r.Header.Get("Wsnet2-User")
 	logger := log.GetLoggerWith(
 		log.KeyHandler, "ws:room",
@@ -96,6 +114,7 @@ func (s *WSHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
 		log.KeyApp, appId,
 		log.KeyClient, clientId,
 		log.KeyRequestedAt, float64(time.Now().UnixNano()/1000000)/1000,
+		log.KeyRequestId, requestid.FromContext(r.Context()),
 	)
 	lastEvSeq, err := strconv.Atoi(r.Header.Get("Wsnet2-LastEventSeq"))
 	if err != nil {
@@ -103,6 +122,15 @@ func (s *WSHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
+	protoVer := binary.ProtocolVersion1
+	if v := r.Header.Get(binary.ProtocolVersionHeader); v != "" {
+		protoVer, err = strconv.Atoi(v)
+		if err != nil {
+			logger.Infof("websocket: invalid header: ProtocolVersion=%v, %+v", v, err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+	}
 
 	cli, err := s.repo.GetClient(roomId, clientId)
 	if err != nil {
@@ -125,16 +153,16 @@ func (s *WSHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(w, r, requestid.UpgradeHeader(r.Context()))
 	if err != nil {
 		breq, _ := httputil.DumpRequest(r, false)
 		logger.Errorf("websocket: upgrade: %+v\nrequest: %v", err, string(breq))
 		return
 	}
-	metrics.Conns.Add(1)
-	defer metrics.Conns.Add(-1)
+	metrics.AddAppConns(appId, 1)
+	defer metrics.AddAppConns(appId, -1)
 
-	peer, err := game.NewPeer(ctx, cli, conn, lastEvSeq)
+	peer, err := game.NewPeer(ctx, cli, conn, lastEvSeq, protoVer)
 	if err != nil {
 		logger.Warnf("websocket: new peer: %+v", err)
 		return
@@ -142,3 +170,130 @@ func (s *WSHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
 	<-peer.Done()
 	logger.Debugf("websocket: finish: room=%v client=%v peer=%p", roomId, clientId, peer)
 }
+
+// handleRoomHandshake : ヘッダの代わりにUpgrade後の先頭フレームで認証する. see binary/handshake.go
+func (s *WSHandler) handleRoomHandshake(w http.ResponseWriter, r *http.Request, roomId string) {
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "ws:room",
+		log.KeyRoom, roomId,
+		log.KeyRequestedAt, float64(time.Now().UnixNano()/1000000)/1000,
+		log.KeyRequestId, requestid.FromContext(r.Context()),
+	)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	conn, err := upgrader.Upgrade(w, r, requestid.UpgradeHeader(r.Context()))
+	if err != nil {
+		breq, _ := httputil.DumpRequest(r, false)
+		logger.Errorf("websocket: upgrade: %+v\nrequest: %v", err, string(breq))
+		return
+	}
+
+	cli, hs, err := game.AcceptHandshake(conn, func(hs *binary.Handshake) (*game.Client, error) {
+		cli, err := s.repo.GetClient(roomId, hs.ClientId)
+		if err != nil {
+			return nil, err
+		}
+		if err := cli.ValidAuthData(hs.AuthData); err != nil {
+			return nil, xerrors.Errorf("Authorization: %w", err)
+		}
+		return cli, nil
+	})
+	if err != nil {
+		logger.Infof("websocket: %+v", err)
+		return
+	}
+	logger = logger.With(log.KeyApp, hs.AppId, log.KeyClient, hs.ClientId)
+	logger.Infof("websocket: handshake: room=%v client=%v", roomId, hs.ClientId)
+	metrics.AddAppConns(hs.AppId, 1)
+	defer metrics.AddAppConns(hs.AppId, -1)
+
+	peer, err := game.NewPeer(ctx, cli, conn, hs.LastEvSeq, hs.ProtocolVersion)
+	if err != nil {
+		logger.Warnf("websocket: new peer: %+v", err)
+		return
+	}
+	<-peer.Done()
+	logger.Debugf("websocket: finish: room=%v client=%v peer=%p", roomId, hs.ClientId, peer)
+}
+
+// HandleSSE : websocketを使えない観戦者にServer-Sent Eventsでイベントを配信する. see game/sse.go
+func (s *WSHandler) HandleSSE(w http.ResponseWriter, r *http.Request) {
+	roomId := chi.URLParam(r, "id")
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "sse:room",
+		log.KeyRoom, roomId,
+		log.KeyRequestedAt, float64(time.Now().UnixNano()/1000000)/1000,
+		log.KeyRequestId, requestid.FromContext(r.Context()),
+	)
+	game.SSEAllowOrigin(w, r, s.conf.SSEAllowOrigins)
+	hs, err := game.SSERequest(r, roomId, s.sseTickets)
+	if err != nil {
+		logger.Infof("sse: invalid request: %+v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	logger = logger.With(log.KeyApp, hs.AppId, log.KeyClient, hs.ClientId)
+
+	cli, err := s.repo.GetClient(roomId, hs.ClientId)
+	if err != nil {
+		logger.Infof("sse: repo.GetClient: %v", err)
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err := cli.ValidAuthData(hs.AuthData); err != nil {
+		logger.Infof("sse: Authorization: %+v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logger.Infof("sse: room=%v client=%v", roomId, hs.ClientId)
+	metrics.AddAppConns(hs.AppId, 1)
+	defer metrics.AddAppConns(hs.AppId, -1)
+
+	if err := game.ServeSSE(r.Context(), w, cli, hs.LastEvSeq, hs.ProtocolVersion); err != nil {
+		logger.Infof("sse: %+v", err)
+		return
+	}
+	logger.Debugf("sse: finish: room=%v client=%v", roomId, hs.ClientId)
+}
+
+// HandleSSETicket : ヘッダで認証した観戦者に、SSEの接続に使う一度きりのチケットを発行する. see game/sse.go
+func (s *WSHandler) HandleSSETicket(w http.ResponseWriter, r *http.Request) {
+	roomId := chi.URLParam(r, "id")
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "sse:ticket",
+		log.KeyRoom, roomId,
+		log.KeyRequestedAt, float64(time.Now().UnixNano()/1000000)/1000,
+		log.KeyRequestId, requestid.FromContext(r.Context()),
+	)
+	game.SSEAllowOrigin(w, r, s.conf.SSEAllowOrigins)
+	hs, err := game.SSETicketRequest(r)
+	if err != nil {
+		logger.Infof("sse: invalid request: %+v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	logger = logger.With(log.KeyApp, hs.AppId, log.KeyClient, hs.ClientId)
+
+	cli, err := s.repo.GetClient(roomId, hs.ClientId)
+	if err != nil {
+		logger.Infof("sse: repo.GetClient: %v", err)
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err := cli.ValidAuthData(hs.AuthData); err != nil {
+		logger.Infof("sse: Authorization: %+v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(s.sseTickets.Issue(roomId, hs)))
+}
+
+// HandleSSEPreflight : チケットの発行のCORSのpreflightに応える
+func (s *WSHandler) HandleSSEPreflight(w http.ResponseWriter, r *http.Request) {
+	game.SSEAllowOrigin(w, r, s.conf.SSEAllowOrigins)
+	w.WriteHeader(http.StatusNoContent)
+}
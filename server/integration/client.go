@@ -0,0 +1,343 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"hash"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shiguredo/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/xerrors"
+
+	"wsnet2/auth"
+	"wsnet2/binary"
+	"wsnet2/lobby"
+	"wsnet2/pb"
+)
+
+// EventTimeout : Expectでイベントを待つ時間
+var EventTimeout = 5 * time.Second
+
+// Client : シナリオを実行するクライアント
+//
+// client パッケージと異なり再接続を自動で行わないので、
+// Disconnect と Reconnect で切断と LastEventSeq を使った再接続を明示的に行える.
+type Client struct {
+	Id string
+
+	// Ignore : Expectで読み飛ばすイベント
+	Ignore map[binary.EvType]bool
+
+	h      *Harness
+	macKey string
+	emk    string
+	hmac   hash.Hash
+
+	room *pb.JoinedRoomRes
+
+	mu     sync.Mutex
+	ws     *websocket.Conn
+	msgseq int
+	lastev int
+
+	evch   chan binary.Event
+	closed chan error
+}
+
+// NewClient : クライアントを生成する
+func (h *Harness) NewClient(t testing.TB, id string) *Client {
+	t.Helper()
+	macKey := auth.GenMACKey()
+	emk, err := auth.EncryptMACKey(AppKey, macKey)
+	if err != nil {
+		t.Fatalf("encrypt mac key: %+v", err)
+	}
+	return &Client{
+		Id: id,
+		Ignore: map[binary.EvType]bool{
+			binary.EvTypePeerReady:    true,
+			binary.EvTypePong:         true,
+			binary.EvTypeBackpressure: true,
+		},
+		h:      h,
+		macKey: macKey,
+		emk:    emk,
+		hmac:   hmac.New(sha1.New, []byte(macKey)),
+	}
+}
+
+// Room : 入室した部屋の情報
+func (c *Client) Room() *pb.JoinedRoomRes {
+	return c.room
+}
+
+// Create : 部屋を作成して接続する
+func (c *Client) Create(t testing.TB, opt *pb.RoomOption) *pb.JoinedRoomRes {
+	t.Helper()
+	param := &lobby.CreateParam{
+		RoomOption: opt,
+		ClientInfo: &pb.ClientInfo{Id: c.Id},
+		EncMACKey:  c.emk,
+	}
+	return c.enter(t, "/rooms", param)
+}
+
+// Join : RoomIDを指定して入室する
+func (c *Client) Join(t testing.TB, roomId string) *pb.JoinedRoomRes {
+	t.Helper()
+	param := &lobby.JoinParam{
+		ClientInfo: &pb.ClientInfo{Id: c.Id},
+		EncMACKey:  c.emk,
+	}
+	return c.enter(t, "/rooms/join/id/"+roomId, param)
+}
+
+// Watch : RoomIDを指定して観戦入室する
+func (c *Client) Watch(t testing.TB, roomId string) *pb.JoinedRoomRes {
+	t.Helper()
+	param := &lobby.JoinParam{
+		ClientInfo: &pb.ClientInfo{Id: c.Id},
+		EncMACKey:  c.emk,
+	}
+	return c.enter(t, "/rooms/watch/id/"+roomId, param)
+}
+
+func (c *Client) enter(t testing.TB, path string, param interface{}) *pb.JoinedRoomRes {
+	t.Helper()
+	res, err := c.lobbyRequest(path, param)
+	if err != nil {
+		t.Fatalf("%v: lobby request %v: %+v", c.Id, path, err)
+	}
+	if res.Type != lobby.ResponseTypeOK || res.Room == nil {
+		t.Fatalf("%v: lobby request %v: %v %v", c.Id, path, res.Type, res.Msg)
+	}
+	c.room = res.Room
+	if err := c.dial(); err != nil {
+		t.Fatalf("%v: dial: %+v", c.Id, err)
+	}
+	return c.room
+}
+
+func (c *Client) lobbyRequest(path string, param interface{}) (*lobby.Response, error) {
+	var p bytes.Buffer
+	enc := msgpack.NewEncoder(&p)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(param); err != nil {
+		return nil, xerrors.Errorf("encode param: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.h.LobbyURL+path, &p)
+	if err != nil {
+		return nil, xerrors.Errorf("new request: %w", err)
+	}
+	bearer, err := auth.GenerateAuthData(AppKey, c.Id, time.Now())
+	if err != nil {
+		return nil, xerrors.Errorf("auth data: %w", err)
+	}
+	req.Header.Add("Content-Type", "application/x-msgpack")
+	req.Header.Add("Wsnet2-App", AppID)
+	req.Header.Add("Wsnet2-User", c.Id)
+	req.Header.Add("Authorization", "Bearer "+bearer)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("do request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("status %v", res.StatusCode)
+	}
+
+	var r lobby.Response
+	dec := msgpack.NewDecoder(res.Body)
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&r); err != nil {
+		return nil, xerrors.Errorf("decode response: %w", err)
+	}
+	return &r, nil
+}
+
+// dial : LastEventSeqを指定してgame(hub)に接続し、受信を開始する
+func (c *Client) dial() error {
+	c.mu.Lock()
+	lastev := c.lastev
+	c.mu.Unlock()
+
+	bearer, err := auth.GenerateAuthData(c.room.AuthKey, c.Id, time.Now())
+	if err != nil {
+		return xerrors.Errorf("auth data: %w", err)
+	}
+	hdr := http.Header{}
+	hdr.Add("Wsnet2-App", AppID)
+	hdr.Add("Wsnet2-User", c.Id)
+	hdr.Add("Wsnet2-LastEventSeq", strconv.Itoa(lastev))
+	hdr.Add("Authorization", "Bearer "+bearer)
+
+	dialer := &websocket.Dialer{
+		Subprotocols:    []string{"wsnet2"},
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+	}
+	ws, _, err := dialer.Dial(c.room.Url, hdr)
+	if err != nil {
+		return xerrors.Errorf("dial %v: %w", c.room.Url, err)
+	}
+
+	c.mu.Lock()
+	c.ws = ws
+	c.evch = make(chan binary.Event, 128)
+	c.closed = make(chan error, 1)
+	evch, closed := c.evch, c.closed
+	c.mu.Unlock()
+
+	go c.receiver(ws, evch, closed)
+	return nil
+}
+
+func (c *Client) receiver(ws *websocket.Conn, evch chan<- binary.Event, closed chan<- error) {
+	defer close(evch)
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			closed <- err
+			return
+		}
+		ev, seq, err := binary.UnmarshalEvent(data)
+		if err != nil {
+			closed <- xerrors.Errorf("unmarshal event: %w", err)
+			return
+		}
+
+		c.mu.Lock()
+		if _, ok := ev.(*binary.RegularEvent); ok {
+			if seq != c.lastev+1 {
+				c.mu.Unlock()
+				closed <- xerrors.Errorf("invalid event sequence num: %v wants %v", seq, c.lastev+1)
+				return
+			}
+			c.lastev = seq
+		}
+		if ev.Type() == binary.EvTypePeerReady {
+			// 再接続時は受理済みのMsgの続きから送る
+			if n, err := binary.UnmarshalEvPeerReadyPayload(ev.Payload()); err == nil {
+				c.msgseq = n
+			}
+		}
+		c.mu.Unlock()
+
+		evch <- ev
+	}
+}
+
+// Send : RegularMsgを送信する
+func (c *Client) Send(t testing.TB, typ binary.MsgType, payload []byte) {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgseq++
+	frame := binary.BuildRegularMsgFrame(typ, c.msgseq, payload, c.hmac)
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatalf("%v: send %v: %+v", c.Id, typ, err)
+	}
+}
+
+// Disconnect : 退室せずに接続を切断する
+func (c *Client) Disconnect(t testing.TB) {
+	t.Helper()
+	c.mu.Lock()
+	ws, evch := c.ws, c.evch
+	c.mu.Unlock()
+	ws.Close()
+	for range evch {
+	}
+}
+
+// Reconnect : 最後に受信したイベントのシーケンス番号を指定して再接続する
+func (c *Client) Reconnect(t testing.TB) {
+	t.Helper()
+	if err := c.dial(); err != nil {
+		t.Fatalf("%v: reconnect: %+v", c.Id, err)
+	}
+}
+
+// LastEventSeq : 最後に受信したRegularEventのシーケンス番号
+func (c *Client) LastEventSeq() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastev
+}
+
+// Next : Ignore以外の次のイベントを返す
+func (c *Client) Next(t testing.TB) binary.Event {
+	t.Helper()
+	c.mu.Lock()
+	evch := c.evch
+	c.mu.Unlock()
+
+	timeout := time.After(EventTimeout)
+	for {
+		select {
+		case ev, ok := <-evch:
+			if !ok {
+				t.Fatalf("%v: connection closed: %v", c.Id, <-c.closed)
+			}
+			if c.Ignore[ev.Type()] {
+				continue
+			}
+			return ev
+		case <-timeout:
+			t.Fatalf("%v: no event in %v", c.Id, EventTimeout)
+		}
+	}
+}
+
+// Expect : 指定した種類のイベントを順に受信することを検証する
+func (c *Client) Expect(t testing.TB, types ...binary.EvType) []binary.Event {
+	t.Helper()
+	evs := make([]binary.Event, 0, len(types))
+	for i, typ := range types {
+		ev := c.Next(t)
+		if ev.Type() != typ {
+			t.Fatalf("%v: event[%d] = %v, wants %v", c.Id, i, ev.Type(), typ)
+		}
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+// ExpectClosed : サーバから接続が切断されることを検証する
+func (c *Client) ExpectClosed(t testing.TB) {
+	t.Helper()
+	c.mu.Lock()
+	evch := c.evch
+	c.mu.Unlock()
+
+	timeout := time.After(EventTimeout)
+	for {
+		select {
+		case ev, ok := <-evch:
+			if !ok {
+				return
+			}
+			if !c.Ignore[ev.Type()] {
+				t.Fatalf("%v: unexpected event: %v", c.Id, ev.Type())
+			}
+		case <-timeout:
+			t.Fatalf("%v: connection is not closed in %v", c.Id, EventTimeout)
+		}
+	}
+}
+
+// Leave : 退室する
+func (c *Client) Leave(t testing.TB) {
+	t.Helper()
+	c.Send(t, binary.MsgTypeLeave, binary.MarshalLeavePayload("leave"))
+	c.ExpectClosed(t)
+}
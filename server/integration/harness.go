@@ -0,0 +1,270 @@
+// Package integration : lobby, game, hub をプロセス内で起動し、複数クライアントのシナリオを検証するテストハーネス
+//
+// MySQLに一時DBを作成してスキーマを流し込み、各サービスを空きポートで起動する.
+// ローカルで実行するときは次のようにしてDBを起動しておく.
+//
+//	docker run -e MYSQL_ALLOW_EMPTY_PASSWORD=yes -p 3306:3306 --rm --name mysql mysql:8.0
+package integration
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+
+	"wsnet2/config"
+	gamesvc "wsnet2/game/service"
+	hubsvc "wsnet2/hub/service"
+	lobbysvc "wsnet2/lobby/service"
+)
+
+const (
+	AppID  = "testapp"
+	AppKey = "testapppkey"
+
+	mysqlDSN = "root@tcp(127.0.0.1:3306)/"
+
+	readyTimeout = 10 * time.Second
+)
+
+// Harness : プロセス内で起動したlobby, game, hub
+type Harness struct {
+	LobbyURL string
+
+	GameConf  *config.GameConf
+	HubConf   *config.HubConf
+	LobbyConf *config.LobbyConf
+
+	db     *sqlx.DB
+	dbname string
+	cancel context.CancelFunc
+	errCh  chan error
+}
+
+// Start : 一時DBを作成して lobby, game, hub を起動する.
+//
+// MySQLに接続できないときはテストをスキップする.
+// 環境変数 WSNET2_FORCE_DB_TEST が設定されているときはスキップせず失敗とする.
+// 起動したサービスと一時DBはテスト終了時に破棄される.
+func Start(t testing.TB) *Harness {
+	t.Helper()
+
+	h := &Harness{
+		dbname: fmt.Sprintf("wsnet2_test_integration_%d", time.Now().UnixNano()),
+		errCh:  make(chan error, 3),
+	}
+
+	if err := h.setupDB(); err != nil {
+		if os.Getenv("WSNET2_FORCE_DB_TEST") == "" {
+			t.Skipf("skip integration test: %v", err)
+		}
+		t.Fatalf("setup db: %+v", err)
+	}
+
+	ports, err := freePorts(5)
+	if err != nil {
+		h.Close()
+		t.Fatalf("free ports: %+v", err)
+	}
+
+	h.GameConf = &config.GameConf{
+		Hostname:          "127.0.0.1",
+		PublicName:        "127.0.0.1",
+		GRPCPort:          ports[0],
+		WebsocketPort:     ports[1],
+		RetryCount:        5,
+		MaxRoomNum:        999999,
+		MaxRooms:          1000,
+		MaxClients:        5000,
+		DefaultMaxPlayers: 10,
+		DefaultDeadline:   5,
+		DefaultLoglevel:   2,
+		HeartBeatInterval: config.Duration(100 * time.Millisecond),
+
+		SwitchMasterTimeout: config.Duration(time.Second),
+
+		ClientConf: config.ClientConf{
+			EventBufSize:   128,
+			WaitAfterClose: config.Duration(time.Second),
+			AuthKeyLen:     32,
+		},
+	}
+	h.HubConf = &config.HubConf{
+		Hostname:          "127.0.0.1",
+		PublicName:        "127.0.0.1",
+		GRPCPort:          ports[2],
+		WebsocketPort:     ports[3],
+		MaxClients:        5000,
+		DefaultLoglevel:   2,
+		ValidHeartBeat:    config.Duration(5 * time.Second),
+		HeartBeatInterval: config.Duration(100 * time.Millisecond),
+		NodeCountInterval: config.Duration(100 * time.Millisecond),
+		ClientConf: config.ClientConf{
+			EventBufSize:   128,
+			WaitAfterClose: config.Duration(time.Second),
+			AuthKeyLen:     32,
+		},
+	}
+	h.LobbyConf = &config.LobbyConf{
+		Hostname:       "127.0.0.1",
+		Net:            "tcp",
+		Port:           ports[4],
+		Loglevel:       2,
+		ValidHeartBeat: config.Duration(5 * time.Second),
+		AuthDataExpire: config.Duration(time.Minute),
+		ApiTimeout:     config.Duration(5 * time.Second),
+		HubMaxWatchers: 10000,
+	}
+	h.LobbyURL = fmt.Sprintf("http://127.0.0.1:%d", ports[4])
+
+	if err := h.serve(); err != nil {
+		h.Close()
+		t.Fatalf("serve: %+v", err)
+	}
+	t.Cleanup(h.Close)
+
+	return h
+}
+
+// Close : 起動したサービスを停止して一時DBを削除する
+func (h *Harness) Close() {
+	if h.cancel != nil {
+		h.cancel()
+	}
+	if h.db != nil {
+		h.db.Exec("DROP DATABASE IF EXISTS " + h.dbname)
+		h.db.Close()
+	}
+}
+
+// DB : 一時DBへの接続
+func (h *Harness) DB() *sqlx.DB {
+	return h.db
+}
+
+func (h *Harness) setupDB() error {
+	db, err := sqlx.Connect("mysql", mysqlDSN)
+	if err != nil {
+		return xerrors.Errorf("connect mysql: %w", err)
+	}
+	if _, err := db.Exec("CREATE DATABASE " + h.dbname); err != nil {
+		db.Close()
+		return xerrors.Errorf("create database: %w", err)
+	}
+	db.Close()
+
+	h.db, err = sqlx.Connect("mysql", mysqlDSN+h.dbname+"?multiStatements=true")
+	if err != nil {
+		return xerrors.Errorf("connect %v: %w", h.dbname, err)
+	}
+
+	schema, err := os.ReadFile(filepath.Join(sqlDir(), "10-schema.sql"))
+	if err != nil {
+		return xerrors.Errorf("read schema: %w", err)
+	}
+	if _, err := h.db.Exec(string(schema)); err != nil {
+		return xerrors.Errorf("exec schema: %w", err)
+	}
+	if _, err := h.db.Exec("INSERT INTO app (id, name, `key`) VALUES (?, ?, ?)", AppID, AppID, AppKey); err != nil {
+		return xerrors.Errorf("insert app: %w", err)
+	}
+	return nil
+}
+
+// serve : game, hubを起動してheartbeatを確認してからlobbyを起動する.
+// lobbyは起動時にappを、リクエスト時にgame/hubの一覧をDBから読み込む.
+func (h *Harness) serve() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+
+	game, err := gamesvc.New(h.db, h.GameConf)
+	if err != nil {
+		return xerrors.Errorf("game service: %w", err)
+	}
+	go func() { h.errCh <- game.Serve(ctx) }()
+
+	hub, err := hubsvc.New(h.db, h.HubConf)
+	if err != nil {
+		return xerrors.Errorf("hub service: %w", err)
+	}
+	go func() { h.errCh <- hub.Serve(ctx) }()
+
+	if err := h.waitHeartbeat("game_server", game.HostId); err != nil {
+		return err
+	}
+	if err := h.waitHeartbeat("hub_server", hub.HostId); err != nil {
+		return err
+	}
+
+	lobby, err := lobbysvc.New(h.db, h.LobbyConf)
+	if err != nil {
+		return xerrors.Errorf("lobby service: %w", err)
+	}
+	go func() { h.errCh <- lobby.Serve(ctx) }()
+
+	return h.waitLobby()
+}
+
+func (h *Harness) waitHeartbeat(table string, hostId int64) error {
+	query := "SELECT COUNT(*) FROM `" + table + "` WHERE id=? AND heartbeat IS NOT NULL"
+	return h.waitReady(table, func() bool {
+		var n int
+		err := h.db.Get(&n, query, hostId)
+		return err == nil && n > 0
+	})
+}
+
+func (h *Harness) waitLobby() error {
+	return h.waitReady("lobby", func() bool {
+		res, err := http.Get(h.LobbyURL + "/health")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return true
+	})
+}
+
+func (h *Harness) waitReady(name string, ready func() bool) error {
+	timeout := time.After(readyTimeout)
+	t := time.NewTicker(50 * time.Millisecond)
+	defer t.Stop()
+	for !ready() {
+		select {
+		case err := <-h.errCh:
+			return xerrors.Errorf("%v: service stopped: %w", name, err)
+		case <-timeout:
+			return xerrors.Errorf("%v: not ready in %v", name, readyTimeout)
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+// sqlDir : リポジトリの sql ディレクトリ
+func sqlDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "sql")
+}
+
+func freePorts(n int) ([]int, error) {
+	ports := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		defer l.Close()
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
@@ -0,0 +1,178 @@
+package integration
+
+import (
+	"testing"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func newRoomOption() *pb.RoomOption {
+	return &pb.RoomOption{
+		Visible:     true,
+		Joinable:    true,
+		Watchable:   true,
+		MaxPlayers:  4,
+		SearchGroup: 1,
+	}
+}
+
+func TestScenarioJoin(t *testing.T) {
+	h := Start(t)
+
+	master := h.NewClient(t, "master")
+	room := master.Create(t, newRoomOption())
+	master.Expect(t, binary.EvTypeJoined)
+
+	player := h.NewClient(t, "player")
+	player.Join(t, room.RoomInfo.Id)
+	evs := player.Expect(t, binary.EvTypeJoined)
+	cli, err := binary.UnmarshalEvJoinedPayload(evs[0].Payload())
+	if err != nil {
+		t.Fatalf("unmarshal joined: %+v", err)
+	}
+	if cli.Id != "player" {
+		t.Fatalf("joined client = %v, wants player", cli.Id)
+	}
+	master.Expect(t, binary.EvTypeJoined)
+
+	player.Send(t, binary.MsgTypeBroadcast, []byte("hello"))
+	for _, c := range []*Client{master, player} {
+		evs := c.Expect(t, binary.EvTypeMessage)
+		from, body, err := binary.UnmarshalEvMessage(evs[0].Payload())
+		if err != nil {
+			t.Fatalf("unmarshal message: %+v", err)
+		}
+		if from != "player" || string(body) != "hello" {
+			t.Fatalf("%v: message = %v %q, wants player \"hello\"", c.Id, from, body)
+		}
+	}
+
+	player.Leave(t)
+	evs = master.Expect(t, binary.EvTypeLeft)
+	left, err := binary.UnmarshalEvLeftPayload(evs[0].Payload())
+	if err != nil {
+		t.Fatalf("unmarshal left: %+v", err)
+	}
+	if left.ClientId != "player" {
+		t.Fatalf("left client = %v, wants player", left.ClientId)
+	}
+}
+
+func TestScenarioSwitchMaster(t *testing.T) {
+	h := Start(t)
+
+	master := h.NewClient(t, "master")
+	room := master.Create(t, newRoomOption())
+	master.Expect(t, binary.EvTypeJoined)
+
+	player := h.NewClient(t, "player")
+	player.Join(t, room.RoomInfo.Id)
+	player.Expect(t, binary.EvTypeJoined)
+	master.Expect(t, binary.EvTypeJoined)
+
+	// Master以外は移譲できない
+	player.Send(t, binary.MsgTypeSwitchMaster, binary.MarshalSwitchMasterPayload("player"))
+	player.Expect(t, binary.EvTypePermissionDenied)
+
+	// 移譲先が受諾するとMasterが交代する
+	master.Send(t, binary.MsgTypeSwitchMaster, binary.MarshalSwitchMasterPayload("player"))
+	evs := player.Expect(t, binary.EvTypeMasterSwitchRequested)
+	from, err := binary.UnmarshalEvMasterSwitchRequestedPayload(evs[0].Payload())
+	if err != nil {
+		t.Fatalf("unmarshal master switch requested: %+v", err)
+	}
+	if from != "master" {
+		t.Fatalf("master switch requested by %v, wants master", from)
+	}
+	player.Send(t, binary.MsgTypeAcceptMaster, nil)
+	master.Expect(t, binary.EvTypeSucceeded)
+	for _, c := range []*Client{master, player} {
+		evs := c.Expect(t, binary.EvTypeMasterSwitched)
+		id, err := binary.UnmarshalEvMasterSwitchedPayload(evs[0].Payload())
+		if err != nil {
+			t.Fatalf("unmarshal master switched: %+v", err)
+		}
+		if id != "player" {
+			t.Fatalf("%v: new master = %v, wants player", c.Id, id)
+		}
+	}
+
+	// Masterになったplayerが退室すると残ったmasterがMasterに戻る
+	player.Leave(t)
+	evs = master.Expect(t, binary.EvTypeLeft)
+	left, err := binary.UnmarshalEvLeftPayload(evs[0].Payload())
+	if err != nil {
+		t.Fatalf("unmarshal left: %+v", err)
+	}
+	if left.MasterId != "master" {
+		t.Fatalf("master after leave = %v, wants master", left.MasterId)
+	}
+}
+
+func TestScenarioReconnect(t *testing.T) {
+	h := Start(t)
+
+	master := h.NewClient(t, "master")
+	room := master.Create(t, newRoomOption())
+	master.Expect(t, binary.EvTypeJoined)
+
+	player := h.NewClient(t, "player")
+	player.Join(t, room.RoomInfo.Id)
+	player.Expect(t, binary.EvTypeJoined)
+	master.Expect(t, binary.EvTypeJoined)
+
+	player.Disconnect(t)
+	lastev := player.LastEventSeq()
+
+	// 切断中に送られたイベントは再接続時に LastEventSeq の続きから届く
+	master.Send(t, binary.MsgTypeBroadcast, []byte("while offline"))
+	master.Expect(t, binary.EvTypeMessage)
+
+	player.Reconnect(t)
+	evs := player.Expect(t, binary.EvTypeMessage)
+	_, body, err := binary.UnmarshalEvMessage(evs[0].Payload())
+	if err != nil {
+		t.Fatalf("unmarshal message: %+v", err)
+	}
+	if string(body) != "while offline" {
+		t.Fatalf("message = %q, wants \"while offline\"", body)
+	}
+	if seq := player.LastEventSeq(); seq != lastev+1 {
+		t.Fatalf("last event seq = %v, wants %v", seq, lastev+1)
+	}
+
+	// 再接続後の送信も届く
+	player.Send(t, binary.MsgTypeBroadcast, []byte("back"))
+	master.Expect(t, binary.EvTypeMessage)
+	player.Expect(t, binary.EvTypeMessage)
+}
+
+func TestScenarioKick(t *testing.T) {
+	h := Start(t)
+
+	master := h.NewClient(t, "master")
+	room := master.Create(t, newRoomOption())
+	master.Expect(t, binary.EvTypeJoined)
+
+	player := h.NewClient(t, "player")
+	player.Join(t, room.RoomInfo.Id)
+	player.Expect(t, binary.EvTypeJoined)
+	master.Expect(t, binary.EvTypeJoined)
+
+	master.Send(t, binary.MsgTypeKick, binary.MarshalKickPayload("nobody", "", binary.KickReasonNone, 0))
+	master.Expect(t, binary.EvTypeTargetNotFound)
+
+	master.Send(t, binary.MsgTypeKick, binary.MarshalKickPayload("player", "bye", binary.KickReasonNone, 0))
+	master.Expect(t, binary.EvTypeSucceeded)
+	evs := master.Expect(t, binary.EvTypeLeft)
+	left, err := binary.UnmarshalEvLeftPayload(evs[0].Payload())
+	if err != nil {
+		t.Fatalf("unmarshal left: %+v", err)
+	}
+	if left.ClientId != "player" || !left.Kicked {
+		t.Fatalf("left = %+v, wants kicked player", left)
+	}
+
+	player.ExpectClosed(t)
+}
@@ -3,6 +3,7 @@ package lobby
 import (
 	"fmt"
 
+	"wsnet2/binary"
 	"wsnet2/pb"
 )
 
@@ -10,22 +11,82 @@ type CreateParam struct {
 	RoomOption *pb.RoomOption `json:"room"`
 	ClientInfo *pb.ClientInfo `json:"client"`
 	EncMACKey  string         `json:"emk"`
+	// Latencies : /probes のgameサーバ毎に計測したRTT. 小さいサーバを優先する
+	Latencies Latencies `json:"latencies,omitempty"`
 }
 
 type JoinParam struct {
 	Queries    []PropQueries  `json:"query"`
 	ClientInfo *pb.ClientInfo `json:"client"`
 	EncMACKey  string         `json:"emk"`
+	// Latencies : /probes のgameサーバ毎に計測したRTT. ランダム入室で小さいサーバの部屋を優先する
+	Latencies Latencies `json:"latencies,omitempty"`
+	// WaitList : 満室なら部屋の順番待ちの列に並ぶ (ID, 部屋番号での入室のみ)
+	WaitList bool `json:"wait_list,omitempty"`
 }
 
+type WaitSeatParam struct {
+	// Leave : 順番待ちの列から外れる
+	Leave bool `json:"leave"`
+}
+
+// MaxSearchGroups : 1回の検索で指定できる検索グループの最大数
+const MaxSearchGroups = 16
+
 type SearchParam struct {
 	SearchGroup    uint32        `json:"group"`
+	SearchGroups   []uint32      `json:"groups,omitempty"`
 	Queries        []PropQueries `json:"query"`
 	Limit          uint32        `json:"limit"`
 	CheckJoinable  bool          `json:"joinable,omitempty"`
 	CheckWatchable bool          `json:"watchable,omitempty"`
 }
 
+// Groups : 検索対象の検索グループ. SearchGroupsが空ならSearchGroupのみ.
+func (p *SearchParam) Groups() []uint32 {
+	if len(p.SearchGroups) == 0 {
+		return []uint32{p.SearchGroup}
+	}
+	return p.SearchGroups
+}
+
+// WatchableSortKey : 観戦可能な部屋一覧の並び順
+type WatchableSortKey byte
+
+const (
+	SortByWatchers WatchableSortKey = iota // 観戦者数の多い順
+	SortByPlayers                          // プレイヤー数の多い順
+)
+
+type SearchWatchableParam struct {
+	SearchGroups []uint32         `json:"groups"`
+	Queries      []PropQueries    `json:"query"`
+	Limit        uint32           `json:"limit"`
+	SortBy       WatchableSortKey `json:"sort,omitempty"`
+}
+
+type InviteParam struct {
+	// UserId : 指定するとそのユーザだけが招待を使える
+	UserId string `json:"user,omitempty"`
+	// Expire : 有効期間（秒）. 0または上限(max_invite_expire)を超えるときは上限
+	Expire uint32 `json:"expire,omitempty"`
+	// Reserve : 招待の期限までUserIdの席を確保する. UserIdの指定が必要
+	Reserve bool `json:"reserve,omitempty"`
+}
+
+type JoinByInviteParam struct {
+	Token      string         `json:"token"`
+	ClientInfo *pb.ClientInfo `json:"client"`
+	EncMACKey  string         `json:"emk"`
+}
+
+// Invitation : 部屋への招待
+type Invitation struct {
+	Token  string `json:"token"`
+	Url    string `json:"url,omitempty"`
+	Expire int64  `json:"expire"` // unixtime
+}
+
 type SearchByIdsParam struct {
 	RoomIDs []string      `json:"ids"`
 	Queries []PropQueries `json:"query"`
@@ -40,11 +101,161 @@ type AdminKickParam struct {
 	TargetID string `json:"target_id"`
 }
 
-type Response struct {
+type AdminMessageParam struct {
+	Message string `json:"message"`
+}
+
+// ServerMessageParam : push APIのパラメータ. dataはマーシャル済みの値 (JSONではbase64)
+type ServerMessageParam struct {
+	RoomID   string `json:"room_id,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Data     []byte `json:"data"`
+}
+
+type ServerMessageResponse struct {
+	Msg   string `json:"msg"`
+	Rooms int    `json:"rooms"`
+}
+
+// CreateChannelParam : 観戦専用の部屋を作るAPIのパラメータ. max_playersは0にする
+type CreateChannelParam struct {
+	RoomOption *pb.RoomOption `json:"room"`
+}
+
+type CreateChannelResponse struct {
+	Msg  string       `json:"msg"`
+	Room *pb.RoomInfo `json:"room"`
+}
+
+type AdminRoomsParam struct {
+	SearchGroup *uint32 `json:"search_group,omitempty"`
+	ClientID    string  `json:"client_id,omitempty"`
+	Limit       int     `json:"limit,omitempty"`
+}
+
+type AdminRoomsResponse struct {
+	Msg   string               `json:"msg"`
+	Rooms []*pb.GetRoomInfoRes `json:"rooms"`
+}
+
+// AdminStatsResponse : appの部屋数やメッセージ数. hostsはgameサーバ毎の内訳
+type AdminStatsResponse struct {
 	Msg   string            `json:"msg"`
-	Type  ResponseType      `json:"type"`
-	Room  *pb.JoinedRoomRes `json:"room,omitempty"`
-	Rooms []*pb.RoomInfo    `json:"rooms,omitempty"`
+	Total *pb.AppStatsRes   `json:"total"`
+	Hosts []*pb.AppStatsRes `json:"hosts"`
+	// Tags : gameサーバのタグ毎の集計. タグのないgameサーバは空文字列
+	Tags map[string]*pb.AppStatsRes `json:"tags"`
+}
+
+// AdminHost : gameサーバの状態. statusは "starting", "running", "closing", "down" (HeartBeatが途絶えている)
+type AdminHost struct {
+	Id         uint32 `json:"id"`
+	Hostname   string `json:"hostname"`
+	PublicName string `json:"public_name"`
+	Tag        string `json:"tag"`
+	Status     string `json:"status"`
+	// Heartbeat : 最後のHeartBeatの時刻 (unixtime)
+	Heartbeat int64 `json:"heartbeat"`
+}
+
+type AdminHostsResponse struct {
+	Msg   string       `json:"msg"`
+	Hosts []*AdminHost `json:"hosts"`
+}
+
+// AdminEraseParam : クライアントのデータを削除するAPIのパラメータ
+type AdminEraseParam struct {
+	ClientID string `json:"client_id"`
+}
+
+// AdminEraseResponse : erasedはテーブル毎に削除した行数
+type AdminEraseResponse struct {
+	Msg    string           `json:"msg"`
+	Erased map[string]int64 `json:"erased"`
+}
+
+// AdminFeatureFlagParam : 機能フラグを変更するAPIのパラメータ. nameが空なら一覧を返すだけ
+type AdminFeatureFlagParam struct {
+	Name       string `json:"name"`
+	Percentage uint32 `json:"percentage"` // フラグを有効にする部屋の割合(%)
+	Delete     bool   `json:"delete"`
+}
+
+// FeatureFlag : appの機能フラグ
+type FeatureFlag struct {
+	Name       string `json:"name" db:"name"`
+	Percentage uint32 `json:"percentage" db:"percentage"`
+}
+
+type AdminFeatureFlagResponse struct {
+	Msg   string         `json:"msg"`
+	Flags []*FeatureFlag `json:"flags"`
+}
+
+// AdminAppParam : appの登録やkeyの更新のパラメータ. keyが空ならサーバで生成する
+type AdminAppParam struct {
+	Id   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	Key  string `json:"key,omitempty"`
+
+	// GracePeriod : keyの更新後、古いkeyも受け付ける期間(秒). 0ならapp_key_grace_period
+	GracePeriod int `json:"grace_period,omitempty"`
+}
+
+type AdminApp struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Key      string `json:"key"`
+	Disabled bool   `json:"disabled"`
+
+	// OldKeyExpire : 古いkeyを受け付ける期限 (unixtime)
+	OldKeyExpire int64 `json:"old_key_expire,omitempty"`
+}
+
+type AdminAppResponse struct {
+	Msg string    `json:"msg"`
+	App *AdminApp `json:"app"`
+}
+
+// RoomLocation : 部屋番号から引いた部屋の所在
+type RoomLocation struct {
+	RoomId string `json:"room_id"`
+	Number int32  `json:"number"`
+	HostId uint32 `json:"host_id"`
+	Host   string `json:"host"`
+	Url    string `json:"url"`
+}
+
+// SeatWaiting : 満室の部屋の順番待ちの状態
+type SeatWaiting struct {
+	RoomId string `json:"room_id"`
+
+	// Position : 順番. 0なら席を確保済みで、Expireまでに入室する
+	Position uint32 `json:"position"`
+
+	// Expire : 次の問い合わせ、または入室の期限 (unixtime)
+	Expire int64 `json:"expire"`
+}
+
+// RoomTicket : 非同期の部屋作成のチケット
+type RoomTicket struct {
+	Id string `json:"id"`
+
+	// Expire : 結果を受け取れる期限 (unixtime)
+	Expire int64 `json:"expire"`
+}
+
+type Response struct {
+	Msg      string            `json:"msg"`
+	Type     ResponseType      `json:"type"`
+	Code     binary.ErrorCode  `json:"code,omitempty"`
+	Room     *pb.JoinedRoomRes `json:"room,omitempty"`
+	Rooms    []*pb.RoomInfo    `json:"rooms,omitempty"`
+	Location *RoomLocation     `json:"location,omitempty"`
+	Invite   *Invitation       `json:"invite,omitempty"`
+	Probes   []*ProbeTarget    `json:"probes,omitempty"`
+	Ticket   *RoomTicket       `json:"ticket,omitempty"`
+	Waiting  *SeatWaiting      `json:"waiting,omitempty"`
 }
 
 type ResponseType byte
@@ -54,6 +265,7 @@ const (
 	ResponseTypeRoomLimit
 	ResponseTypeNoRoomFound
 	ResponseTypeRoomFull
+	ResponseTypePending
 )
 
 func (r ResponseType) String() string {
@@ -66,6 +278,8 @@ func (r ResponseType) String() string {
 		return "NoRoomFound"
 	case ResponseTypeRoomFull:
 		return "RoomFull"
+	case ResponseTypePending:
+		return "Pending"
 	default:
 		return fmt.Sprintf("UnknownType(%v)", byte(r))
 	}
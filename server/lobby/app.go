@@ -0,0 +1,169 @@
+package lobby
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"regexp"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/log"
+)
+
+const (
+	maxAppIdLen   = 32
+	maxAppNameLen = 191
+	maxAppKeyLen  = 191
+)
+
+var appIdPattern = regexp.MustCompile(`^[0-9A-Za-z_\-]+$`)
+
+// generateAppKey : 新しいapp keyを生成する
+func generateAppKey() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func validateAppKey(key string) error {
+	if len(key) > maxAppKeyLen {
+		return xerrors.Errorf("key is too long: %v", len(key))
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return xerrors.Errorf("key must be printable ascii")
+		}
+	}
+	return nil
+}
+
+func (a *appEntry) adminApp() *AdminApp {
+	app := &AdminApp{
+		Id:       a.Id,
+		Name:     a.Name,
+		Key:      a.Key,
+		Disabled: a.Disabled,
+	}
+	if a.OldKeyExpire.Valid {
+		app.OldKeyExpire = a.OldKeyExpire.Time.Unix()
+	}
+	return app
+}
+
+func (rs *RoomService) selectApp(ctx context.Context, appId string) (*appEntry, error) {
+	var app appEntry
+	err := rs.db.GetContext(ctx, &app,
+		"SELECT id, name, `key`, old_key, old_key_expire, disabled FROM app WHERE id = ?", appId)
+	if err != nil {
+		if xerrors.Is(err, sql.ErrNoRows) {
+			return nil, withType(xerrors.Errorf("app not found: %v", appId), ErrAppNotFound)
+		}
+		return nil, xerrors.Errorf("select app (id=%v): %w", appId, err)
+	}
+	return &app, nil
+}
+
+// AdminCreateApp : appを登録する. keyが空なら生成する.
+func (rs *RoomService) AdminCreateApp(ctx context.Context, appId, name, key string, logger log.Logger) (*AdminApp, error) {
+	if len(appId) == 0 || len(appId) > maxAppIdLen || !appIdPattern.MatchString(appId) {
+		return nil, withType(xerrors.Errorf("invalid app id: %q", appId), ErrArgument)
+	}
+	if len([]rune(name)) > maxAppNameLen {
+		return nil, withType(xerrors.Errorf("name is too long: %v", len(name)), ErrArgument)
+	}
+	if key == "" {
+		key = generateAppKey()
+	}
+	if err := validateAppKey(key); err != nil {
+		return nil, withType(err, ErrArgument)
+	}
+
+	_, err := rs.db.ExecContext(ctx, "INSERT INTO app (id, name, `key`) VALUES (?, ?, ?)", appId, name, key)
+	if err != nil {
+		if _, e := rs.selectApp(ctx, appId); e == nil {
+			return nil, withType(xerrors.Errorf("app already exists: %v", appId), ErrArgument)
+		}
+		return nil, xerrors.Errorf("insert app (id=%v): %w", appId, err)
+	}
+	logger.Infof("app created: %v %q", appId, name)
+
+	if err := rs.apps.Refresh(); err != nil {
+		logger.Errorf("refresh apps: %+v", err)
+	}
+	return &AdminApp{Id: appId, Name: name, Key: key}, nil
+}
+
+// AdminRotateAppKey : app keyを更新する. keyが空なら生成する.
+// 更新前のkeyはgraceの間も受け付けるので、その間にクライアントやゲームAPIサーバのkeyを差し替える.
+func (rs *RoomService) AdminRotateAppKey(ctx context.Context, appId, key string, grace time.Duration, logger log.Logger) (*AdminApp, error) {
+	if key == "" {
+		key = generateAppKey()
+	}
+	if err := validateAppKey(key); err != nil {
+		return nil, withType(err, ErrArgument)
+	}
+	if grace <= 0 {
+		grace = time.Duration(rs.conf.AppKeyGracePeriod)
+	}
+
+	tx, err := rs.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	var app appEntry
+	err = tx.GetContext(ctx, &app,
+		"SELECT id, name, `key`, old_key, old_key_expire, disabled FROM app WHERE id = ? FOR UPDATE", appId)
+	if err != nil {
+		if xerrors.Is(err, sql.ErrNoRows) {
+			return nil, withType(xerrors.Errorf("app not found: %v", appId), ErrAppNotFound)
+		}
+		return nil, xerrors.Errorf("select app (id=%v): %w", appId, err)
+	}
+	if key == app.Key {
+		return nil, withType(xerrors.Errorf("new key is same as the current key: %v", appId), ErrArgument)
+	}
+
+	// 猶予期間中にもう一度更新したときは、その時点の現在のkeyだけを古いkeyとして残す
+	app.OldKey = app.Key
+	app.OldKeyExpire = sql.NullTime{Time: time.Now().Add(grace), Valid: true}
+	app.Key = key
+	_, err = tx.ExecContext(ctx, "UPDATE app SET `key` = ?, old_key = ?, old_key_expire = ? WHERE id = ?",
+		app.Key, app.OldKey, app.OldKeyExpire, appId)
+	if err != nil {
+		return nil, xerrors.Errorf("update app (id=%v): %w", appId, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, xerrors.Errorf("commit: %w", err)
+	}
+	logger.Infof("app key rotated: %v (old key expires at %v)", appId, app.OldKeyExpire.Time)
+
+	if err := rs.apps.Refresh(); err != nil {
+		logger.Errorf("refresh apps: %+v", err)
+	}
+	return app.adminApp(), nil
+}
+
+// AdminSetAppDisabled : appを無効化(または再度有効化)する.
+// 無効化したappの認証や部屋の作成・入室はできなくなる. 既存の部屋はそのまま残る.
+func (rs *RoomService) AdminSetAppDisabled(ctx context.Context, appId string, disabled bool, logger log.Logger) (*AdminApp, error) {
+	app, err := rs.selectApp(ctx, appId)
+	if err != nil {
+		return nil, err
+	}
+	_, err = rs.db.ExecContext(ctx, "UPDATE app SET disabled = ? WHERE id = ?", disabled, appId)
+	if err != nil {
+		return nil, xerrors.Errorf("update app (id=%v): %w", appId, err)
+	}
+	app.Disabled = disabled
+	logger.Infof("app disabled=%v: %v", disabled, appId)
+
+	if err := rs.apps.Refresh(); err != nil {
+		logger.Errorf("refresh apps: %+v", err)
+	}
+	return app.adminApp(), nil
+}
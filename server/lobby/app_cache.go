@@ -0,0 +1,96 @@
+package lobby
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+
+	"wsnet2/common"
+	"wsnet2/log"
+)
+
+// appEntry : appテーブルの行
+type appEntry struct {
+	Id           string       `db:"id"`
+	Name         string       `db:"name"`
+	Key          string       `db:"key"`
+	OldKey       string       `db:"old_key"`
+	OldKeyExpire sql.NullTime `db:"old_key_expire"`
+	Disabled     bool         `db:"disabled"`
+}
+
+// validKeys : nowの時点で受け付けるkey. 先頭が現在のkey.
+// keyの更新後、猶予期間の間は古いkeyも受け付ける.
+func (a *appEntry) validKeys(now time.Time) []string {
+	if a.OldKey != "" && a.OldKeyExpire.Valid && now.Before(a.OldKeyExpire.Time) {
+		return []string{a.Key, a.OldKey}
+	}
+	return []string{a.Key}
+}
+
+// appCache : 有効なappの一覧. 管理APIによる変更をlobbyの再起動なしに反映するため定期的に読み直す.
+type appCache struct {
+	sync.Mutex
+	db     *sqlx.DB
+	expire time.Duration
+	clock  common.Clock
+
+	apps        map[string]*appEntry
+	lastUpdated time.Time
+}
+
+func newAppCache(db *sqlx.DB, expire time.Duration) *appCache {
+	return &appCache{
+		db:     db,
+		expire: expire,
+		clock:  common.RealClock,
+		apps:   make(map[string]*appEntry),
+	}
+}
+
+func (c *appCache) updateInner() error {
+	query := "SELECT id, name, `key`, old_key, old_key_expire, disabled FROM app WHERE disabled = 0"
+
+	var apps []*appEntry
+	err := c.db.Select(&apps, query)
+	if err != nil {
+		return xerrors.Errorf("select apps: %w", err)
+	}
+
+	log.Debugf("Now enabled apps: %v", len(apps))
+
+	c.apps = make(map[string]*appEntry, len(apps))
+	for _, app := range apps {
+		c.apps[app.Id] = app
+	}
+	c.lastUpdated = c.clock.Now()
+	return nil
+}
+
+func (c *appCache) update() error {
+	if c.clock.Now().Sub(c.lastUpdated) > c.expire {
+		return c.updateInner()
+	}
+	return nil
+}
+
+// Refresh : 有効期限に関わらず読み直す
+func (c *appCache) Refresh() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.updateInner()
+}
+
+// Get : 有効なapp. 読み直しに失敗したときは古い一覧を使う.
+func (c *appCache) Get(appId string) (*appEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.update(); err != nil {
+		log.Errorf("appCache: %+v", err)
+	}
+	app, found := c.apps[appId]
+	return app, found
+}
@@ -0,0 +1,81 @@
+package lobby
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"wsnet2/common"
+)
+
+func TestAppValidKeys(t *testing.T) {
+	now := time.Now()
+	tests := map[string]struct {
+		app  appEntry
+		want []string
+	}{
+		"no old key": {
+			appEntry{Key: "new"},
+			[]string{"new"}},
+		"in grace period": {
+			appEntry{Key: "new", OldKey: "old", OldKeyExpire: sql.NullTime{Time: now.Add(time.Second), Valid: true}},
+			[]string{"new", "old"}},
+		"grace period expired": {
+			appEntry{Key: "new", OldKey: "old", OldKeyExpire: sql.NullTime{Time: now, Valid: true}},
+			[]string{"new"}},
+		"no expire": {
+			appEntry{Key: "new", OldKey: "old"},
+			[]string{"new"}},
+	}
+	for name, tc := range tests {
+		if got := tc.app.validKeys(now); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%v: validKeys = %v, wants %v", name, got, tc.want)
+		}
+	}
+}
+
+func TestAppCache(t *testing.T) {
+	if lobbyDB == nil {
+		t.Skip("require database")
+	}
+
+	lobbyDB.MustExec("DROP TABLE IF EXISTS `app`")
+	lobbyDB.MustExec(
+		"CREATE TABLE `app` (\n" +
+			"  `id`             VARCHAR(32) COLLATE ascii_bin PRIMARY KEY,\n" +
+			"  `name`           VARCHAR(191) COLLATE utf8mb4_bin,\n" +
+			"  `key`            VARCHAR(191) COLLATE ascii_bin,\n" +
+			"  `old_key`        VARCHAR(191) COLLATE ascii_bin NOT NULL DEFAULT '',\n" +
+			"  `old_key_expire` DATETIME,\n" +
+			"  `disabled`       TINYINT NOT NULL DEFAULT 0\n" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4")
+	lobbyDB.MustExec(
+		"INSERT INTO app (id, name, `key`, disabled) VALUES (\"app1\", \"app1\", \"key1\", 0), (\"app2\", \"app2\", \"key2\", 1)")
+
+	clock := common.NewFakeClock(time.Now())
+	ac := newAppCache(lobbyDB, time.Second)
+	ac.clock = clock
+
+	if _, found := ac.Get("app1"); !found {
+		t.Fatalf("app1 not found")
+	}
+	if _, found := ac.Get("app2"); found {
+		t.Fatalf("disabled app2 found")
+	}
+
+	// 有効期限までは読み直さない
+	lobbyDB.MustExec("INSERT INTO app (id, name, `key`) VALUES (\"app3\", \"app3\", \"key3\")")
+	lobbyDB.MustExec("UPDATE app SET disabled = 1 WHERE id = \"app1\"")
+	if _, found := ac.Get("app3"); found {
+		t.Fatalf("app3 found before expire")
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, found := ac.Get("app3"); !found {
+		t.Fatalf("app3 not found after expire")
+	}
+	if _, found := ac.Get("app1"); found {
+		t.Fatalf("disabled app1 found after expire")
+	}
+}
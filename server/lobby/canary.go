@@ -0,0 +1,28 @@
+package lobby
+
+import (
+	"math/rand"
+)
+
+// canaryのgameサーバへの振り分け:
+// tag (config.GameConf.Tag) がCanaryTagのgameサーバをcanaryとして扱い、
+// app毎にCanaryPercentageFor(%)の新しい部屋をcanaryに、それ以外の部屋をcanary以外のgameサーバに作る.
+// 振り分け先のgameサーバがひとつもなければ全てのgameサーバから選ぶ.
+// ロビー部屋の子の部屋は親の部屋と同じgameサーバに作るので振り分けない.
+
+// canaryMatch : 部屋を作るgameサーバの条件. canaryならcanaryのgameサーバ、そうでなければそれ以外.
+// tagが空ならnil (条件なし)
+func canaryMatch(tag string, canary bool) func(*gameServer) bool {
+	if tag == "" {
+		return nil
+	}
+	return func(game *gameServer) bool {
+		return (game.Tag == tag) == canary
+	}
+}
+
+// hostMatch : appの新しい部屋を作るgameサーバの条件
+func (rs *RoomService) hostMatch(appId string) func(*gameServer) bool {
+	canary := rand.Intn(100) < rs.conf.CanaryPercentageFor(appId)
+	return canaryMatch(rs.conf.CanaryTag, canary)
+}
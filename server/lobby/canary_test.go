@@ -0,0 +1,57 @@
+package lobby
+
+import (
+	"testing"
+	"time"
+
+	"wsnet2/config"
+)
+
+func TestCanaryHost(t *testing.T) {
+	gc := newGameCache(nil, time.Hour, time.Hour)
+	gc.lastUpdated = time.Now()
+	for id, tag := range map[uint32]string{1: "", 2: "canary", 3: ""} {
+		gc.servers[id] = &gameServer{hostInfo: hostInfo{Id: id}, Tag: tag}
+		gc.order = append(gc.order, id)
+	}
+
+	for i := 0; i < 20; i++ {
+		game, err := gc.Nearest(nil, 0, canaryMatch("canary", true))
+		if err != nil {
+			t.Fatalf("Nearest: %v", err)
+		}
+		if game.Id != 2 {
+			t.Fatalf("canary host = %v, wants 2", game.Id)
+		}
+		game, err = gc.Nearest(nil, 0, canaryMatch("canary", false))
+		if err != nil {
+			t.Fatalf("Nearest: %v", err)
+		}
+		if game.Id == 2 {
+			t.Fatalf("non-canary host must not be 2")
+		}
+	}
+
+	// canaryのgameサーバがなければ全てから選ぶ
+	game, err := gc.Nearest(nil, 0, canaryMatch("beta", true))
+	if err != nil || game == nil {
+		t.Fatalf("Nearest must fall back to all servers: %v, %v", game, err)
+	}
+	if canaryMatch("", true) != nil {
+		t.Fatalf("canaryMatch must be nil when tag is empty")
+	}
+
+	rs := &RoomService{conf: &config.LobbyConf{
+		CanaryTag:           "canary",
+		CanaryPercentage:    100,
+		AppCanaryPercentage: map[string]int{"stable": 0},
+	}}
+	for i := 0; i < 20; i++ {
+		if g, _ := gc.Nearest(nil, 0, rs.hostMatch("app")); g.Id != 2 {
+			t.Fatalf("app host = %v, wants 2", g.Id)
+		}
+		if g, _ := gc.Nearest(nil, 0, rs.hostMatch("stable")); g.Id == 2 {
+			t.Fatalf("stable host must not be 2")
+		}
+	}
+}
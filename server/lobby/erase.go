@@ -0,0 +1,125 @@
+package lobby
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+
+	"wsnet2/log"
+)
+
+// クライアントのデータの削除 (AdminEraseClient):
+// appの運用者の依頼で、指定したクライアントIDを含む行をDBから削除する.
+//
+// クライアントIDを含むテーブルと保持期間:
+//   - player_log: 入退室と接続切断の記録. gameサーバの player_log_retention_days で削除する (see game/retention.go)
+//   - player_stats: 終了した部屋のPlayer毎の統計. gameサーバの player_stats_retention_days で削除する
+//   - room_ticket: 非同期の部屋作成のチケット. async_create_timeout後に次の作成時に削除する.
+//     他のクライアントのチケットの結果 (JoinedRoomRes) のPlayersにも含まれるので取り除く
+//   - client_session: 稼働中の部屋のセッション. 部屋を閉じると削除する
+//
+// room_history (終了した部屋) はクライアントIDを持たない. ただしアプリが部屋のプロパティに
+// クライアントIDを入れている場合は削除できないので、アプリ側で入れないようにする.
+// 稼働中の部屋のclient_sessionは部屋の再開に使うので削除しない. 部屋を閉じるか、Kickしてから削除する.
+// 現在リプレイ (部屋のイベントの保存) は実装していない.
+
+// eraseTables : クライアントのデータを削除するテーブルとクライアントIDのカラム.
+// legacyならapp_idが空の行 (app_idのカラムを追加する前の記録) も削除する
+var eraseTables = []struct {
+	table  string
+	column string
+	legacy bool
+}{
+	{"player_log", "player_id", true},
+	{"player_stats", "player_id", false},
+	{"room_ticket", "user_id", false},
+}
+
+// erasedTicketPlayers : AdminEraseClientの結果で、Playersから取り除いた他のクライアントのチケットの数のキー
+const erasedTicketPlayers = "room_ticket.players"
+
+// AdminEraseClient : appのクライアントのデータを削除する. テーブル毎に削除した行数を返す.
+func (rs *RoomService) AdminEraseClient(ctx context.Context, appId, clientId string, logger log.Logger) (map[string]int64, error) {
+	if _, found := rs.apps.Get(appId); !found {
+		return nil, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+	if clientId == "" {
+		return nil, withType(xerrors.Errorf("client_id is empty"), ErrArgument)
+	}
+
+	tx, err := rs.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	erased := make(map[string]int64, len(eraseTables))
+	for _, t := range eraseTables {
+		app := "app_id = ?"
+		if t.legacy {
+			app = "app_id IN (?, '')"
+		}
+		res, err := tx.ExecContext(ctx, "DELETE FROM "+t.table+" WHERE "+app+" AND "+t.column+" = ?", appId, clientId)
+		if err != nil {
+			return nil, xerrors.Errorf("delete %v (client=%v): %w", t.table, clientId, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, xerrors.Errorf("delete %v (client=%v): %w", t.table, clientId, err)
+		}
+		erased[t.table] = n
+	}
+	n, err := eraseTicketPlayers(ctx, tx, appId, clientId)
+	if err != nil {
+		return nil, err
+	}
+	erased[erasedTicketPlayers] = n
+
+	if err := tx.Commit(); err != nil {
+		return nil, xerrors.Errorf("commit: %w", err)
+	}
+	logger.Infof("client data erased: %v %v", clientId, erased)
+	return erased, nil
+}
+
+// eraseTicketPlayers : 他のクライアントのチケットの結果からクライアントを取り除く.
+// チケットは期限が過ぎると消えるので、appの作成済みのチケットを全て調べる.
+func eraseTicketPlayers(ctx context.Context, tx *sqlx.Tx, appId, clientId string) (int64, error) {
+	var tickets []roomTicket
+	err := tx.SelectContext(ctx, &tickets,
+		"SELECT id, room FROM room_ticket WHERE app_id = ? AND room IS NOT NULL FOR UPDATE", appId)
+	if err != nil {
+		return 0, xerrors.Errorf("select room_ticket (client=%v): %w", clientId, err)
+	}
+
+	var n int64
+	for _, t := range tickets {
+		res, err := unmarshalTicketRoom(t.Room)
+		if err != nil {
+			return 0, xerrors.Errorf("ticket %v: %w", t.Id, err)
+		}
+		players := res.Players[:0]
+		for _, p := range res.Players {
+			if p.Id != clientId {
+				players = append(players, p)
+			}
+		}
+		if len(players) == len(res.Players) && res.MasterId != clientId {
+			continue
+		}
+		res.Players = players
+		if res.MasterId == clientId {
+			res.MasterId = ""
+		}
+		room, err := marshalTicketRoom(res)
+		if err != nil {
+			return 0, xerrors.Errorf("ticket %v: %w", t.Id, err)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE room_ticket SET room = ? WHERE id = ?", room, t.Id); err != nil {
+			return 0, xerrors.Errorf("update room_ticket %v (client=%v): %w", t.Id, clientId, err)
+		}
+		n++
+	}
+	return n, nil
+}
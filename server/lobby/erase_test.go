@@ -0,0 +1,102 @@
+package lobby
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+
+	"wsnet2/common"
+	"wsnet2/pb"
+)
+
+// ticketPlayers : チケットの結果のPlayersのIDと一致する
+type ticketPlayers []string
+
+func (tp ticketPlayers) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	if !ok {
+		return false
+	}
+	res, err := unmarshalTicketRoom(b)
+	if err != nil {
+		return false
+	}
+	ids := make([]string, len(res.Players))
+	for i, p := range res.Players {
+		ids[i] = p.Id
+	}
+	return reflect.DeepEqual(ids, []string(tp))
+}
+
+func TestAdminEraseClient(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock error: %+v", err)
+	}
+	rs := &RoomService{
+		db: sqlx.NewDb(db, "mysql"),
+		apps: &appCache{
+			expire:      time.Hour,
+			clock:       common.RealClock,
+			apps:        map[string]*appEntry{"app1": {Id: "app1"}},
+			lastUpdated: time.Now(),
+		},
+	}
+	if _, err := rs.AdminEraseClient(ctx, "app1", "", logger); err == nil {
+		t.Fatalf("empty client id must be an error")
+	}
+	if _, err := rs.AdminEraseClient(ctx, "app2", "user1", logger); err == nil {
+		t.Fatalf("unknown app must be an error")
+	}
+
+	other, err := marshalTicketRoom(&pb.JoinedRoomRes{
+		RoomInfo: &pb.RoomInfo{Id: "room1"},
+		Players:  []*pb.ClientInfo{{Id: "user2"}, {Id: "user1"}},
+		MasterId: "user2",
+	})
+	if err != nil {
+		t.Fatalf("marshalTicketRoom: %+v", err)
+	}
+	unrelated, err := marshalTicketRoom(&pb.JoinedRoomRes{
+		RoomInfo: &pb.RoomInfo{Id: "room2"},
+		Players:  []*pb.ClientInfo{{Id: "user3"}},
+		MasterId: "user3",
+	})
+	if err != nil {
+		t.Fatalf("marshalTicketRoom: %+v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM player_log WHERE app_id IN (?, '') AND player_id = ?")).
+		WithArgs("app1", "user1").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM player_stats WHERE app_id = ? AND player_id = ?")).
+		WithArgs("app1", "user1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM room_ticket WHERE app_id = ? AND user_id = ?")).
+		WithArgs("app1", "user1").WillReturnResult(sqlmock.NewResult(0, 0))
+	// 他のクライアントのチケットのPlayersから取り除く
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, room FROM room_ticket WHERE app_id = ? AND room IS NOT NULL FOR UPDATE")).
+		WithArgs("app1").WillReturnRows(sqlmock.NewRows([]string{"id", "room"}).
+		AddRow("ticket1", other).AddRow("ticket2", unrelated))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE room_ticket SET room = ? WHERE id = ?")).
+		WithArgs(ticketPlayers{"user2"}, "ticket1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	erased, err := rs.AdminEraseClient(ctx, "app1", "user1", logger)
+	if err != nil {
+		t.Fatalf("AdminEraseClient: %+v", err)
+	}
+	want := map[string]int64{"player_log": 3, "player_stats": 1, "room_ticket": 0, "room_ticket.players": 1}
+	if !reflect.DeepEqual(erased, want) {
+		t.Fatalf("erased = %v, wants %v", erased, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -2,8 +2,11 @@ package lobby
 
 import (
 	"fmt"
+	"time"
 
 	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
 )
 
 type ErrType int
@@ -15,8 +18,40 @@ const (
 	ErrRoomFull
 	ErrAlreadyJoined
 	ErrNoWatchableRoom
+	ErrRoomNotFound
+	ErrAppNotFound
+	ErrRateLimited
+	ErrBanned
+	ErrGameServerDown
 )
 
+// Code : クライアントに返すエラーの種類
+func (t ErrType) Code() binary.ErrorCode {
+	switch t {
+	case ErrArgument, ErrAppNotFound:
+		return binary.ErrorCodeInvalidArgument
+	case ErrRoomLimit:
+		return binary.ErrorCodeRoomLimit
+	case ErrNoJoinableRoom:
+		return binary.ErrorCodeNotJoinable
+	case ErrRoomFull:
+		return binary.ErrorCodeRoomFull
+	case ErrAlreadyJoined:
+		return binary.ErrorCodeAlreadyJoined
+	case ErrNoWatchableRoom:
+		return binary.ErrorCodeNotWatchable
+	case ErrRoomNotFound:
+		return binary.ErrorCodeRoomNotFound
+	case ErrRateLimited:
+		return binary.ErrorCodeRateLimited
+	case ErrBanned:
+		return binary.ErrorCodeBanned
+	case ErrGameServerDown:
+		return binary.ErrorCodeGameServerDown
+	}
+	return binary.ErrorCodeUnknown
+}
+
 // ErrorWithErrType : ErrTypeとerrorの組
 type ErrorWithType interface {
 	error
@@ -27,13 +62,24 @@ type ErrorWithType interface {
 type errorWithType struct {
 	error
 	errType ErrType
+
+	// retryAfter : クライアントが再試行できるまでの時間. 0なら指定しない
+	retryAfter time.Duration
 }
 
 func withType(err error, errType ErrType) ErrorWithType {
 	if err == nil {
 		return nil
 	}
-	return &errorWithType{err, errType}
+	return &errorWithType{err, errType, 0}
+}
+
+// RetryAfter : errに再試行までの時間が付いていれば返す
+func RetryAfter(err error) (time.Duration, bool) {
+	if e, ok := err.(*errorWithType); ok && e.retryAfter > 0 {
+		return e.retryAfter, true
+	}
+	return 0, false
 }
 
 func (e *errorWithType) ErrType() ErrType {
@@ -54,6 +100,16 @@ func (e *errorWithType) Message() string {
 		return "Already exists"
 	case ErrNoWatchableRoom:
 		return "No watchable room found"
+	case ErrRoomNotFound:
+		return "Room not found"
+	case ErrAppNotFound:
+		return "App not found"
+	case ErrRateLimited:
+		return "Rate limit exceeded"
+	case ErrBanned:
+		return "Banned from the room"
+	case ErrGameServerDown:
+		return "Game server is down"
 	}
 	return ""
 }
@@ -0,0 +1,55 @@
+package lobby
+
+import (
+	"context"
+	"regexp"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/log"
+)
+
+// 機能フラグの管理 (AdminFeatureFlags):
+// app_feature_flagテーブルのappの機能フラグを登録、変更、削除する.
+// gameサーバは部屋の作成時に数秒毎に読み直したフラグを使い、部屋で有効にするフラグを決める (see game/feature_flag.go).
+
+const maxFeatureFlagNameLen = 64
+
+var featureFlagNamePattern = regexp.MustCompile(`^[0-9A-Za-z_\-.]+$`)
+
+// AdminFeatureFlags : appの機能フラグを変更し、変更後の一覧を返す. nameが空なら一覧だけ返す.
+// deleteならフラグを削除し、それ以外はpercentage(%)の部屋で有効にする.
+func (rs *RoomService) AdminFeatureFlags(ctx context.Context, appId string, param *AdminFeatureFlagParam, logger log.Logger) ([]*FeatureFlag, error) {
+	if _, found := rs.apps.Get(appId); !found {
+		return nil, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+
+	switch {
+	case param.Name == "":
+	case len(param.Name) > maxFeatureFlagNameLen || !featureFlagNamePattern.MatchString(param.Name):
+		return nil, withType(xerrors.Errorf("invalid name: %q", param.Name), ErrArgument)
+	case param.Delete:
+		_, err := rs.db.ExecContext(ctx, "DELETE FROM app_feature_flag WHERE app_id = ? AND name = ?", appId, param.Name)
+		if err != nil {
+			return nil, xerrors.Errorf("delete app_feature_flag (%v): %w", param.Name, err)
+		}
+		logger.Infof("feature flag deleted: %v", param.Name)
+	case param.Percentage > 100:
+		return nil, withType(xerrors.Errorf("invalid percentage: %v", param.Percentage), ErrArgument)
+	default:
+		_, err := rs.db.ExecContext(ctx,
+			"INSERT INTO app_feature_flag (app_id, name, percentage) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE percentage = VALUES(percentage)",
+			appId, param.Name, param.Percentage)
+		if err != nil {
+			return nil, xerrors.Errorf("insert app_feature_flag (%v): %w", param.Name, err)
+		}
+		logger.Infof("feature flag updated: %v %v%%", param.Name, param.Percentage)
+	}
+
+	flags := []*FeatureFlag{}
+	err := rs.db.SelectContext(ctx, &flags, "SELECT name, percentage FROM app_feature_flag WHERE app_id = ? ORDER BY name", appId)
+	if err != nil {
+		return nil, xerrors.Errorf("select app_feature_flag: %w", err)
+	}
+	return flags, nil
+}
@@ -0,0 +1,72 @@
+package lobby
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+
+	"wsnet2/common"
+)
+
+func TestAdminFeatureFlags(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock error: %+v", err)
+	}
+	rs := &RoomService{
+		db: sqlx.NewDb(db, "mysql"),
+		apps: &appCache{
+			expire:      time.Hour,
+			clock:       common.RealClock,
+			apps:        map[string]*appEntry{"app1": {Id: "app1"}},
+			lastUpdated: time.Now(),
+		},
+	}
+	if _, err := rs.AdminFeatureFlags(ctx, "app2", &AdminFeatureFlagParam{}, logger); err == nil {
+		t.Fatalf("unknown app must be an error")
+	}
+	if _, err := rs.AdminFeatureFlags(ctx, "app1", &AdminFeatureFlagParam{Name: "bad name"}, logger); err == nil {
+		t.Fatalf("invalid name must be an error")
+	}
+	if _, err := rs.AdminFeatureFlags(ctx, "app1", &AdminFeatureFlagParam{Name: "f", Percentage: 101}, logger); err == nil {
+		t.Fatalf("invalid percentage must be an error")
+	}
+
+	selectQuery := regexp.QuoteMeta("SELECT name, percentage FROM app_feature_flag WHERE app_id = ? ORDER BY name")
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO app_feature_flag (app_id, name, percentage) VALUES (?, ?, ?)")).
+		WithArgs("app1", "compression", 30).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(selectQuery).WithArgs("app1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "percentage"}).AddRow("compression", 30).AddRow("turn_mode", 100))
+	flags, err := rs.AdminFeatureFlags(ctx, "app1", &AdminFeatureFlagParam{Name: "compression", Percentage: 30}, logger)
+	if err != nil {
+		t.Fatalf("AdminFeatureFlags: %+v", err)
+	}
+	want := []*FeatureFlag{{"compression", 30}, {"turn_mode", 100}}
+	if !reflect.DeepEqual(flags, want) {
+		t.Fatalf("flags = %v, wants %v", flags, want)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM app_feature_flag WHERE app_id = ? AND name = ?")).
+		WithArgs("app1", "compression").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(selectQuery).WithArgs("app1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "percentage"}).AddRow("turn_mode", 100))
+	flags, err = rs.AdminFeatureFlags(ctx, "app1", &AdminFeatureFlagParam{Name: "compression", Delete: true}, logger)
+	if err != nil {
+		t.Fatalf("AdminFeatureFlags: %+v", err)
+	}
+	want = []*FeatureFlag{{"turn_mode", 100}}
+	if !reflect.DeepEqual(flags, want) {
+		t.Fatalf("flags = %v, wants %v", flags, want)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -1,7 +1,9 @@
 package lobby
 
 import (
+	"math"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
@@ -18,22 +20,34 @@ type hostInfo struct {
 	PublicName    string `db:"public_name"`
 	GRPCPort      int    `db:"grpc_port"`
 	WebSocketPort int    `db:"ws_port"`
+	// WSURL : 部屋IDを後ろに付けると部屋のwebsocket URLになる
+	WSURL string `db:"ws_url"`
 }
 
 type gameServer struct {
 	hostInfo
-	Status int32
+	Status    int32
+	Heartbeat int64
+	// Tag : gameサーバの種別 (例: "canary"). see config.GameConf.Tag
+	Tag string
 }
 
+// errGameServerDown : HeartBeatが途絶えたgameサーバ
+var errGameServerDown = xerrors.New("game server is down")
+
 type gameCache struct {
 	sync.Mutex
 	db     *sqlx.DB
 	expire time.Duration
 	valid  time.Duration
+	clock  common.Clock
 
 	servers     map[uint32]*gameServer
 	order       []uint32
 	lastUpdated time.Time
+
+	// down : HeartBeatが途絶えたgameサーバ. 部屋の行が残っていても入室できない
+	down map[uint32]*gameServer
 }
 
 func newGameCache(db *sqlx.DB, expire time.Duration, valid time.Duration) *gameCache {
@@ -41,28 +55,35 @@ func newGameCache(db *sqlx.DB, expire time.Duration, valid time.Duration) *gameC
 		db:      db,
 		expire:  expire,
 		valid:   valid,
+		clock:   common.RealClock,
 		servers: make(map[uint32]*gameServer),
 		order:   []uint32{},
+		down:    make(map[uint32]*gameServer),
 	}
 }
 
 func (c *gameCache) updateInner() error {
 	// 再入室のために、graceful shutdown中のサーバー(status == closing == 2)の情報も取得する.
-	query := ("SELECT id, hostname, public_name, grpc_port, ws_port, status\n" +
-		"FROM game_server WHERE status IN (1, 2) AND heartbeat >= ?")
+	// HeartBeatが途絶えたサーバーはdownとして区別する.
+	query := ("SELECT id, hostname, public_name, grpc_port, ws_port, ws_url, status, COALESCE(heartbeat, 0) AS heartbeat, tag\n" +
+		"FROM game_server WHERE status IN (1, 2)")
 
 	var servers []gameServer
-	err := c.db.Select(&servers, query, time.Now().Add(-c.valid).Unix())
+	err := c.db.Select(&servers, query)
 	if err != nil {
 		return xerrors.Errorf("selecting game servers: %w", err)
 	}
 
-	log.Debugf("Now alive game servers: %+v", servers)
-
+	valid := c.clock.Now().Add(-c.valid).Unix()
 	c.servers = make(map[uint32]*gameServer, len(servers))
 	c.order = make([]uint32, 0, len(servers))
+	c.down = make(map[uint32]*gameServer)
 	for i := range servers {
 		s := &servers[i]
+		if s.Heartbeat < valid {
+			c.down[s.Id] = s
+			continue
+		}
 		c.servers[s.Id] = s
 		// Rand() がgraceful shutdown中のサーバーを返さないために、
 		// status=running のサーバーのみ order に追加する.
@@ -70,12 +91,13 @@ func (c *gameCache) updateInner() error {
 			c.order = append(c.order, s.Id)
 		}
 	}
-	c.lastUpdated = time.Now()
+	log.Debugf("Now alive game servers: %v, down: %v", len(c.servers), len(c.down))
+	c.lastUpdated = c.clock.Now()
 	return nil
 }
 
 func (c *gameCache) update() error {
-	if time.Since(c.lastUpdated) > c.expire {
+	if c.clock.Now().Sub(c.lastUpdated) > c.expire {
 		return c.updateInner()
 	}
 	return nil
@@ -88,6 +110,9 @@ func (c *gameCache) Get(id uint32) (*gameServer, error) {
 		return nil, err
 	}
 
+	if game := c.down[id]; game != nil {
+		return nil, xerrors.Errorf("id=%v, heartbeat=%v: %w", id, game.Heartbeat, errGameServerDown)
+	}
 	if len(c.servers) == 0 {
 		return nil, xerrors.New("no available game server")
 	}
@@ -98,6 +123,16 @@ func (c *gameCache) Get(id uint32) (*gameServer, error) {
 	return game, nil
 }
 
+// IsDown : HeartBeatが途絶えたgameサーバか
+func (c *gameCache) IsDown(id uint32) bool {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.update(); err != nil {
+		return false
+	}
+	return c.down[id] != nil
+}
+
 func (c *gameCache) Rand() (*gameServer, error) {
 	c.Lock()
 	defer c.Unlock()
@@ -112,6 +147,47 @@ func (c *gameCache) Rand() (*gameServer, error) {
 	return c.servers[id], nil
 }
 
+// Nearest : クライアントが計測したRTTが最も小さい(margin以内の)gameサーバからランダムに選ぶ.
+// 計測値がひとつもなければRand()と同じ.
+// matchがnilでなければmatchを満たすgameサーバから選び、ひとつもなければ全てのgameサーバから選ぶ.
+func (c *gameCache) Nearest(latencies Latencies, margin uint32, match func(*gameServer) bool) (*gameServer, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.update(); err != nil {
+		return nil, err
+	}
+
+	if len(c.order) == 0 {
+		return nil, xerrors.New("no available game server")
+	}
+	order := c.order
+	if match != nil {
+		matched := make([]uint32, 0, len(c.order))
+		for _, id := range c.order {
+			if match(c.servers[id]) {
+				matched = append(matched, id)
+			}
+		}
+		if len(matched) > 0 {
+			order = matched
+		}
+	}
+	rank := latencyRank(latencies, order, margin)
+	best := uint32(math.MaxUint32)
+	candidates := make([]uint32, 0, len(order))
+	for _, id := range order {
+		switch r := rank[id]; {
+		case r < best:
+			best = r
+			candidates = append(candidates[:0], id)
+		case r == best:
+			candidates = append(candidates, id)
+		}
+	}
+	id := candidates[rand.Intn(len(candidates))]
+	return c.servers[id], nil
+}
+
 func (c *gameCache) All() ([]*gameServer, error) {
 	c.Lock()
 	defer c.Unlock()
@@ -125,3 +201,25 @@ func (c *gameCache) All() ([]*gameServer, error) {
 	}
 	return res, nil
 }
+
+// Hosts : 管理API用に、HeartBeatが途絶えたものも含めた全てのgameサーバを返す.
+// 2つ目の返り値はHeartBeatが途絶えたgameサーバのID
+func (c *gameCache) Hosts() ([]*gameServer, map[uint32]bool, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.update(); err != nil {
+		return nil, nil, err
+	}
+
+	res := make([]*gameServer, 0, len(c.servers)+len(c.down))
+	down := make(map[uint32]bool, len(c.down))
+	for _, gs := range c.servers {
+		res = append(res, gs)
+	}
+	for id, gs := range c.down {
+		res = append(res, gs)
+		down[id] = true
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Id < res[j].Id })
+	return res, down, nil
+}
@@ -3,6 +3,8 @@ package lobby
 import (
 	"testing"
 	"time"
+
+	"golang.org/x/xerrors"
 )
 
 func TestGameCache(t *testing.T) {
@@ -19,19 +21,21 @@ func TestGameCache(t *testing.T) {
 			"  `public_name` VARCHAR(191) NOT NULL,\n" +
 			"  `grpc_port`   INTEGER NOT NULL,\n" +
 			"  `ws_port`     INTEGER NOT NULL,\n" +
+			"  `ws_url`      VARCHAR(191) NOT NULL DEFAULT '',\n" +
 			"  `status`      TINYINT NOT NULL,\n" +
 			"  `heartbeat`   BIGINT,\n" +
+			"  `tag`         VARCHAR(32) NOT NULL DEFAULT '',\n" +
 			"  UNIQUE KEY `idx_hostname` (`hostname`)\n" +
 			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4")
 
 	now := time.Now()
 	nowUnix := now.Unix()
 	lobbyDB.MustExec(
-		`INSERT INTO game_server (id, hostname, public_name, grpc_port, ws_port, status, heartbeat) VALUES
-		(1, "host1", "global1", 1001, 1002, 0, ?),
-		(2, "host2", "global2", 2001, 2002, 1, ?),
-		(3, "host3", "global3", 3001, 3002, 2, ?),
-		(4, "host4", "global4", 4001, 4002, 1, ?)`,
+		`INSERT INTO game_server (id, hostname, public_name, grpc_port, ws_port, ws_url, status, heartbeat) VALUES
+		(1, "host1", "global1", 1001, 1002, "ws://global1:1002/room/", 0, ?),
+		(2, "host2", "global2", 2001, 2002, "ws://global2:2002/room/", 1, ?),
+		(3, "host3", "global3", 3001, 3002, "ws://global3:3002/room/", 2, ?),
+		(4, "host4", "global4", 4001, 4002, "ws://global4:4002/room/", 1, ?)`,
 		nowUnix, nowUnix, nowUnix, nowUnix-100)
 	// host1 - not ready
 	// host2 - ready
@@ -39,6 +43,7 @@ func TestGameCache(t *testing.T) {
 	// host4 - expired
 	// randではhost2のみが選択される
 	// Getではhost3も取得可能
+	// host4はGetでerrGameServerDownになる
 
 	hc := newGameCache(lobbyDB, time.Second, time.Second*10)
 	err := hc.update()
@@ -51,6 +56,9 @@ func TestGameCache(t *testing.T) {
 	if len(hc.servers) != 2 {
 		t.Errorf("len(servers) is not 2: %v", hc.servers)
 	}
+	if len(hc.down) != 1 || hc.down[4] == nil {
+		t.Errorf("down is not [host4]: %v", hc.down)
+	}
 	if len(hc.order) != 1 {
 		t.Errorf("len(order) is not 1: %v", hc.order)
 	}
@@ -64,6 +72,9 @@ func TestGameCache(t *testing.T) {
 	if host.Id != 2 {
 		t.Errorf("host.Id is not 2: %v", host.Id)
 	}
+	if host.WSURL != "ws://global2:2002/room/" {
+		t.Errorf("host.WSURL is not ws://global2:2002/room/: %v", host.WSURL)
+	}
 	host2, err := hc.Get(host.Id)
 	if err != nil {
 		t.Fatalf("hc.Get(%v): %v", host.Id, err)
@@ -79,4 +90,27 @@ func TestGameCache(t *testing.T) {
 	if host3 == nil {
 		t.Fatalf("host3 is nil")
 	}
+
+	_, err = hc.Get(4)
+	if !xerrors.Is(err, errGameServerDown) {
+		t.Errorf("hc.Get(4) must be errGameServerDown: %v", err)
+	}
+	if !hc.IsDown(4) || hc.IsDown(2) {
+		t.Errorf("IsDown: 4=%v 2=%v", hc.IsDown(4), hc.IsDown(2))
+	}
+	_, err = hc.Get(1)
+	if err == nil || xerrors.Is(err, errGameServerDown) {
+		t.Errorf("hc.Get(1) must be not found: %v", err)
+	}
+
+	hosts, down, err := hc.Hosts()
+	if err != nil {
+		t.Fatalf("hc.Hosts(): %v", err)
+	}
+	if len(hosts) != 3 || hosts[0].Id != 2 || hosts[1].Id != 3 || hosts[2].Id != 4 {
+		t.Errorf("hosts is not [2, 3, 4]: %v", hosts)
+	}
+	if len(down) != 1 || !down[4] {
+		t.Errorf("down is not {4}: %v", down)
+	}
 }
@@ -8,16 +8,29 @@ import (
 	"github.com/jmoiron/sqlx"
 	"golang.org/x/xerrors"
 
+	"wsnet2/common"
 	"wsnet2/log"
 )
 
-type hubServer hostInfo
+type hubServer struct {
+	hostInfo
+
+	// 負荷情報. HubサーバがHeartBeatと同時に更新する
+	Watchers  int   `db:"watchers"`
+	Rooms     int   `db:"rooms"`
+	Bandwidth int64 `db:"bandwidth"` // bytes/sec
+}
 
 type hubCache struct {
 	sync.Mutex
 	db     *sqlx.DB
 	expire time.Duration
 	valid  time.Duration
+	clock  common.Clock
+
+	// 負荷がこれらを超えたHubサーバには、他に空きがある限り新しい観戦者を割り当てない. 0は無制限
+	shedWatchers  int
+	shedBandwidth int64
 
 	servers     map[uint32]*hubServer
 	order       []uint32
@@ -29,16 +42,17 @@ func newHubCache(db *sqlx.DB, expire time.Duration, valid time.Duration) *hubCac
 		db:      db,
 		expire:  expire,
 		valid:   valid,
+		clock:   common.RealClock,
 		servers: make(map[uint32]*hubServer),
 		order:   []uint32{},
 	}
 }
 
 func (c *hubCache) updateInner() error {
-	query := "SELECT id, hostname, public_name, grpc_port, ws_port FROM hub_server WHERE status=1 AND heartbeat >= ?"
+	query := "SELECT id, hostname, public_name, grpc_port, ws_port, ws_url, watchers, rooms, bandwidth FROM hub_server WHERE status=1 AND heartbeat >= ?"
 
 	var servers []hubServer
-	err := c.db.Select(&servers, query, time.Now().Add(-c.valid).Unix())
+	err := c.db.Select(&servers, query, c.clock.Now().Add(-c.valid).Unix())
 	if err != nil {
 		return xerrors.Errorf("selecting hub servers: %w", err)
 	}
@@ -51,12 +65,12 @@ func (c *hubCache) updateInner() error {
 		c.servers[s.Id] = &servers[i]
 		c.order[i] = s.Id
 	}
-	c.lastUpdated = time.Now()
+	c.lastUpdated = c.clock.Now()
 	return nil
 }
 
 func (c *hubCache) update() error {
-	if time.Since(c.lastUpdated) > c.expire {
+	if c.clock.Now().Sub(c.lastUpdated) > c.expire {
 		return c.updateInner()
 	}
 	return nil
@@ -79,6 +93,61 @@ func (c *hubCache) Get(id uint32) (*hubServer, error) {
 	return hub, nil
 }
 
+// overloaded : 新しい観戦者の割り当てを控えるべきか
+func (c *hubCache) overloaded(s *hubServer) bool {
+	return (c.shedWatchers > 0 && s.Watchers >= c.shedWatchers) ||
+		(c.shedBandwidth > 0 && s.Bandwidth >= c.shedBandwidth)
+}
+
+// Select : 観戦者を割り当てるHubサーバを選ぶ.
+// 部屋を中継済みのHubサーバ(candidates)のうち過負荷でないものを優先し、
+// 無ければ過負荷でないHubサーバから負荷の低いものを選ぶ.
+func (c *hubCache) Select(candidates []uint32) (*hubServer, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.update(); err != nil {
+		return nil, err
+	}
+
+	if len(c.order) == 0 {
+		return nil, xerrors.New("no available hub server")
+	}
+
+	var ok []*hubServer
+	for _, id := range candidates {
+		if s := c.servers[id]; s != nil && !c.overloaded(s) {
+			ok = append(ok, s)
+		}
+	}
+	if len(ok) > 0 {
+		return ok[rand.Intn(len(ok))], nil
+	}
+
+	all := make([]*hubServer, 0, len(c.order))
+	for _, id := range c.order {
+		if s := c.servers[id]; !c.overloaded(s) {
+			ok = append(ok, s)
+		}
+		all = append(all, c.servers[id])
+	}
+	if len(ok) == 0 {
+		// 全て過負荷のときも観戦は断らず、負荷の低いものに割り当てる
+		ok = all
+	}
+	return lessLoaded(ok), nil
+}
+
+// lessLoaded : ランダムに2つ選び、観戦者数の少ない方を返す.
+// 常に最小のものを選ぶとキャッシュの有効期間中に1台に集中するため.
+func lessLoaded(servers []*hubServer) *hubServer {
+	a := servers[rand.Intn(len(servers))]
+	b := servers[rand.Intn(len(servers))]
+	if b.Watchers < a.Watchers {
+		return b
+	}
+	return a
+}
+
 func (c *hubCache) Rand() (*hubServer, error) {
 	c.Lock()
 	defer c.Unlock()
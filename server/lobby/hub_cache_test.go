@@ -19,8 +19,12 @@ func TestHubCache(t *testing.T) {
 			"  `public_name` VARCHAR(191) NOT NULL,\n" +
 			"  `grpc_port`   INTEGER NOT NULL,\n" +
 			"  `ws_port`     INTEGER NOT NULL,\n" +
+			"  `ws_url`      VARCHAR(191) NOT NULL DEFAULT '',\n" +
 			"  `status`      TINYINT NOT NULL,\n" +
 			"  `heartbeat`   BIGINT,\n" +
+			"  `watchers`    INTEGER UNSIGNED NOT NULL DEFAULT 0,\n" +
+			"  `rooms`       INTEGER UNSIGNED NOT NULL DEFAULT 0,\n" +
+			"  `bandwidth`   BIGINT UNSIGNED NOT NULL DEFAULT 0,\n" +
 			"  UNIQUE KEY `idx_hostname` (`hostname`)\n" +
 			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4")
 
@@ -71,3 +75,45 @@ func TestHubCache(t *testing.T) {
 		t.Errorf("host != host2: %+v != %+v", host, host2)
 	}
 }
+
+func TestHubCacheSelect(t *testing.T) {
+	hc := newHubCache(nil, time.Hour, time.Hour)
+	hc.shedWatchers = 100
+	hc.shedBandwidth = 1000
+	for _, s := range []*hubServer{
+		{hostInfo: hostInfo{Id: 1}, Watchers: 100},                 // 観戦者数で過負荷
+		{hostInfo: hostInfo{Id: 2}, Watchers: 10, Bandwidth: 1000}, // 帯域で過負荷
+		{hostInfo: hostInfo{Id: 3}, Watchers: 50},
+	} {
+		hc.servers[s.Id] = s
+		hc.order = append(hc.order, s.Id)
+	}
+	hc.lastUpdated = time.Now()
+
+	tests := []struct {
+		candidates []uint32
+		want       uint32
+	}{
+		{[]uint32{3}, 3},
+		{[]uint32{1, 2}, 3},
+		{[]uint32{4}, 3},
+		{nil, 3},
+	}
+	for _, test := range tests {
+		for i := 0; i < 10; i++ {
+			s, err := hc.Select(test.candidates)
+			if err != nil {
+				t.Fatalf("Select(%v): %v", test.candidates, err)
+			}
+			if s.Id != test.want {
+				t.Fatalf("Select(%v) = %v, wants %v", test.candidates, s.Id, test.want)
+			}
+		}
+	}
+
+	// 全て過負荷のときも割り当て先を返す
+	hc.servers[3].Watchers = 200
+	if s, err := hc.Select(nil); err != nil || s == nil {
+		t.Fatalf("Select when all overloaded: %v, %v", s, err)
+	}
+}
@@ -0,0 +1,100 @@
+package lobby
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/common"
+	"wsnet2/pb"
+)
+
+// Latencies : クライアントが計測したgameサーバ毎のRTT (host_id -> ミリ秒)
+type Latencies map[uint32]uint32
+
+// latencyRank : 計測したRTTの最小値からmargin以内のホストを0、それ以外はRTT、未計測はMaxUint32とした順位
+func latencyRank(latencies Latencies, hostIds []uint32, margin uint32) map[uint32]uint32 {
+	min := uint64(math.MaxUint32)
+	for _, id := range hostIds {
+		if rtt, ok := latencies[id]; ok && uint64(rtt) < min {
+			min = uint64(rtt)
+		}
+	}
+	rank := make(map[uint32]uint32, len(hostIds))
+	for _, id := range hostIds {
+		rtt, ok := latencies[id]
+		switch {
+		case !ok:
+			rank[id] = math.MaxUint32
+		case uint64(rtt) <= min+uint64(margin):
+			rank[id] = 0
+		default:
+			rank[id] = rtt
+		}
+	}
+	return rank
+}
+
+// sortByLatency : 部屋をgameサーバのRTTが小さい順に並べる. 同順位の部屋の順序は保つ
+func sortByLatency(rooms []*pb.RoomInfo, latencies Latencies, margin uint32) {
+	if len(latencies) == 0 {
+		return
+	}
+	hostIds := make([]uint32, len(rooms))
+	for i, r := range rooms {
+		hostIds[i] = r.HostId
+	}
+	rank := latencyRank(latencies, hostIds, margin)
+	sort.SliceStable(rooms, func(i, j int) bool {
+		return rank[rooms[i].HostId] < rank[rooms[j].HostId]
+	})
+}
+
+func (rs *RoomService) latencyMargin() uint32 {
+	return uint32(time.Duration(rs.conf.LatencyMargin) / time.Millisecond)
+}
+
+// ProbeTarget : クライアントがRTTを計測するgameサーバのエンドポイント
+type ProbeTarget struct {
+	HostId uint32 `json:"host_id"`
+	Url    string `json:"url"`
+}
+
+// probeURL : gameサーバのws_urlからRTT計測用のURLを作る ("wss://host:port/room/" -> "https://host:port/ping").
+// websocketを中継するときはlobby経由のURLにする.
+func probeURL(host *hostInfo, proxy string) string {
+	u := strings.TrimSuffix(host.WSURL, "/room/")
+	if proxy != "" {
+		u = fmt.Sprintf("%s/ws/%s/%d", strings.TrimSuffix(proxy, "/"), ProxyKindGame, host.Id)
+	}
+	if strings.HasPrefix(u, "wss://") {
+		return "https://" + u[len("wss://"):] + "/ping"
+	}
+	return "http://" + strings.TrimPrefix(u, "ws://") + "/ping"
+}
+
+// Probes : 部屋を作成できるgameサーバのRTT計測用エンドポイント一覧
+func (rs *RoomService) Probes(appId string) ([]*ProbeTarget, error) {
+	if _, found := rs.apps.Get(appId); !found {
+		return nil, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+
+	games, err := rs.gameCache.All()
+	if err != nil {
+		return nil, xerrors.Errorf("get game servers: %w", err)
+	}
+
+	probes := make([]*ProbeTarget, 0, len(games))
+	for _, g := range games {
+		if g.Status != common.HostStatusRunning || g.WSURL == "" {
+			continue
+		}
+		probes = append(probes, &ProbeTarget{HostId: g.Id, Url: probeURL(&g.hostInfo, rs.conf.WebsocketProxy)})
+	}
+	sort.Slice(probes, func(i, j int) bool { return probes[i].HostId < probes[j].HostId })
+	return probes, nil
+}
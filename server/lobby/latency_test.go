@@ -0,0 +1,57 @@
+package lobby
+
+import (
+	"reflect"
+	"testing"
+
+	"wsnet2/pb"
+)
+
+func TestSortByLatency(t *testing.T) {
+	rooms := []*pb.RoomInfo{
+		{Id: "r1", HostId: 1},
+		{Id: "r2", HostId: 2},
+		{Id: "r3", HostId: 3},
+		{Id: "r4", HostId: 1},
+		{Id: "r5", HostId: 4},
+	}
+	tests := map[string]struct {
+		latencies Latencies
+		margin    uint32
+		want      []string
+	}{
+		"no latencies":    {nil, 20, []string{"r1", "r2", "r3", "r4", "r5"}},
+		"nearest":         {Latencies{1: 150, 2: 30, 3: 80}, 20, []string{"r2", "r3", "r1", "r4", "r5"}},
+		"within margin":   {Latencies{1: 40, 2: 30, 3: 80}, 20, []string{"r1", "r2", "r4", "r3", "r5"}},
+		"unmeasured last": {Latencies{4: 300}, 0, []string{"r5", "r1", "r2", "r3", "r4"}},
+	}
+	for name, tc := range tests {
+		rs := make([]*pb.RoomInfo, len(rooms))
+		copy(rs, rooms)
+		sortByLatency(rs, tc.latencies, tc.margin)
+		ids := make([]string, len(rs))
+		for i, r := range rs {
+			ids[i] = r.Id
+		}
+		if !reflect.DeepEqual(ids, tc.want) {
+			t.Fatalf("%v: sortByLatency = %v, wants %v", name, ids, tc.want)
+		}
+	}
+}
+
+func TestProbeURL(t *testing.T) {
+	tests := []struct {
+		host  hostInfo
+		proxy string
+		want  string
+	}{
+		{hostInfo{Id: 1, WSURL: "wss://game1.example.com:8000/room/"}, "", "https://game1.example.com:8000/ping"},
+		{hostInfo{Id: 2, WSURL: "ws://localhost:8000/room/"}, "", "http://localhost:8000/ping"},
+		{hostInfo{Id: 3, WSURL: "ws://game3.local:8000/room/"}, "wss://lobby.example.com/", "https://lobby.example.com/ws/game/3/ping"},
+	}
+	for _, tc := range tests {
+		if u := probeURL(&tc.host, tc.proxy); u != tc.want {
+			t.Fatalf("probeURL(%q, %q) = %q, wants %q", tc.host.WSURL, tc.proxy, u, tc.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package lobby
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/pb"
+)
+
+// ProxyKind : websocketの中継先の種類
+type ProxyKind string
+
+const (
+	ProxyKindGame ProxyKind = "game"
+	ProxyKindHub  ProxyKind = "hub"
+)
+
+// WebsocketBackend : websocketの中継先
+type WebsocketBackend struct {
+	// Scheme : http or https
+	Scheme string
+	// Host : 中継先のhost:port (内部向けのHostname)
+	Host string
+	// ServerName : TLS証明書の検証に使う公開名
+	ServerName string
+}
+
+// WebsocketBackend : 中継先のgame/hubサーバを返す
+func (rs *RoomService) WebsocketBackend(kind ProxyKind, hostId uint32) (*WebsocketBackend, error) {
+	var host *hostInfo
+	switch kind {
+	case ProxyKindGame:
+		game, err := rs.gameCache.Get(hostId)
+		if err != nil {
+			return nil, xerrors.Errorf("get game server(%v): %w", hostId, err)
+		}
+		host = &game.hostInfo
+	case ProxyKindHub:
+		hub, err := rs.hubCache.Get(hostId)
+		if err != nil {
+			return nil, xerrors.Errorf("get hub server(%v): %w", hostId, err)
+		}
+		host = &hub.hostInfo
+	default:
+		return nil, xerrors.Errorf("unknown proxy kind: %v", kind)
+	}
+	return websocketBackend(host), nil
+}
+
+func websocketBackend(host *hostInfo) *WebsocketBackend {
+	scheme := "http"
+	if strings.HasPrefix(host.WSURL, "wss://") {
+		scheme = "https"
+	}
+	return &WebsocketBackend{
+		Scheme:     scheme,
+		Host:       fmt.Sprintf("%s:%d", host.Hostname, host.WebSocketPort),
+		ServerName: host.PublicName,
+	}
+}
+
+// proxyURL : 中継が有効なとき、部屋のwebsocket URLをlobbyの中継用URLに書き換える
+func (rs *RoomService) proxyURL(res *pb.JoinedRoomRes, kind ProxyKind, hostId uint32) error {
+	if rs.conf.WebsocketProxy == "" {
+		return nil
+	}
+	u, err := rewriteProxyURL(rs.conf.WebsocketProxy, res.Url, kind, hostId)
+	if err != nil {
+		return err
+	}
+	res.Url = u
+	return nil
+}
+
+// rewriteProxyURL : "{proxy}/ws/{kind}/{hostId}{元のURLのpath}" を返す
+func rewriteProxyURL(proxy, orig string, kind ProxyKind, hostId uint32) (string, error) {
+	u, err := url.Parse(orig)
+	if err != nil {
+		return "", xerrors.Errorf("parse url %q: %w", orig, err)
+	}
+	return fmt.Sprintf("%s/ws/%s/%d%s", strings.TrimSuffix(proxy, "/"), kind, hostId, u.Path), nil
+}
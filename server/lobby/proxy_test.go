@@ -0,0 +1,50 @@
+package lobby
+
+import (
+	"testing"
+)
+
+func TestRewriteProxyURL(t *testing.T) {
+	tests := []struct {
+		proxy  string
+		orig   string
+		kind   ProxyKind
+		hostId uint32
+		want   string
+	}{
+		{"wss://lobby.example.com", "wss://game1.example.com:8000/room/0123abcd", ProxyKindGame, 3, "wss://lobby.example.com/ws/game/3/room/0123abcd"},
+		{"wss://lobby.example.com/", "ws://hub1.example.com:8001/room/0123abcd", ProxyKindHub, 12, "wss://lobby.example.com/ws/hub/12/room/0123abcd"},
+		{"ws://localhost:8080/wsnet2", "ws://localhost:8000/room/xyz", ProxyKindGame, 1, "ws://localhost:8080/wsnet2/ws/game/1/room/xyz"},
+	}
+	for _, tc := range tests {
+		u, err := rewriteProxyURL(tc.proxy, tc.orig, tc.kind, tc.hostId)
+		if err != nil {
+			t.Fatalf("rewriteProxyURL(%q, %q): %+v", tc.proxy, tc.orig, err)
+		}
+		if u != tc.want {
+			t.Fatalf("rewriteProxyURL(%q, %q) = %q, wants %q", tc.proxy, tc.orig, u, tc.want)
+		}
+	}
+}
+
+func TestWebsocketBackend(t *testing.T) {
+	tests := []struct {
+		host hostInfo
+		want WebsocketBackend
+	}{
+		{
+			hostInfo{Hostname: "game1.local", PublicName: "game1.example.com", WebSocketPort: 8000, WSURL: "wss://game1.example.com:8000/room/"},
+			WebsocketBackend{Scheme: "https", Host: "game1.local:8000", ServerName: "game1.example.com"},
+		},
+		{
+			hostInfo{Hostname: "hub1.local", PublicName: "hub1.example.com", WebSocketPort: 8001, WSURL: "ws://hub1.example.com:8001/room/"},
+			WebsocketBackend{Scheme: "http", Host: "hub1.local:8001", ServerName: "hub1.example.com"},
+		},
+	}
+	for _, tc := range tests {
+		be := websocketBackend(&tc.host)
+		if *be != tc.want {
+			t.Fatalf("websocketBackend(%v) = %+v, wants %+v", tc.host.Hostname, *be, tc.want)
+		}
+	}
+}
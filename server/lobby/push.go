@@ -0,0 +1,149 @@
+package lobby
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"wsnet2/log"
+	"wsnet2/pb"
+)
+
+// pushLimiter : app毎のpush APIの呼び出し回数を制限する.
+// rate(回/sec)で補充し、burst回までの連続呼び出しを許容するtoken bucket.
+type pushLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*pushBucket
+}
+
+type pushBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newPushLimiter(rate float64, burst int) *pushLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &pushLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*pushBucket),
+	}
+}
+
+// allow : appIdの呼び出しを許可するならtokenを1つ消費してtrueを返す. rateが0以下なら制限しない
+func (l *pushLimiter) allow(appId string, now time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[appId]
+	if !ok {
+		b = &pushBucket{tokens: l.burst, last: now}
+		l.buckets[appId] = b
+	}
+	return b.take(now, l.rate, l.burst)
+}
+
+// take : 前回からの経過時間分のtokenを補充し、1つ以上あれば消費してtrueを返す.
+// 複数のlobbyで共有するときは時計がずれていることがあるので、時刻が戻ったら補充しない.
+func (b *pushBucket) take(now time.Time, rate, burst float64) bool {
+	if d := now.Sub(b.last); d > 0 {
+		b.tokens += d.Seconds() * rate
+		b.last = now
+	}
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// ServerMessage : appのサーバからのメッセージを部屋またはクライアントに送る.
+//
+// roomIdを指定したときはその部屋に送り、clientIdも指定したときはそのクライアントだけに送る.
+// roomIdが空のときは、全gameサーバーでclientIdのクライアントが入室している部屋に送る.
+// 送信できた部屋の数を返す.
+func (rs *RoomService) ServerMessage(ctx context.Context, appId, roomId, clientId string, data []byte, logger log.Logger) (int, error) {
+	if _, found := rs.apps.Get(appId); !found {
+		return 0, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+	if roomId == "" && clientId == "" {
+		return 0, withType(xerrors.Errorf("room_id or client_id is required"), ErrArgument)
+	}
+	if len(data) == 0 {
+		return 0, withType(xerrors.Errorf("data is empty"), ErrArgument)
+	}
+	if !rs.pushLimiter.allow(appId, time.Now()) {
+		return 0, withType(xerrors.Errorf("push rate limit exceeded: app=%v", appId), ErrRateLimited)
+	}
+
+	req := &pb.ServerMessageReq{
+		AppId:    appId,
+		RoomId:   roomId,
+		ClientId: clientId,
+		Data:     data,
+	}
+
+	if roomId != "" {
+		var room pb.RoomInfo
+		err := rs.db.GetContext(ctx, &room, "SELECT * FROM room WHERE app_id = ? AND id = ?", appId, roomId)
+		if err != nil {
+			return 0, withType(xerrors.Errorf("select room (id=%v): %w", roomId, err), ErrRoomNotFound)
+		}
+		game, err := rs.gameCache.Get(room.HostId)
+		if err != nil {
+			return 0, xerrors.Errorf("get game server(%v): %w", room.HostId, err)
+		}
+		return rs.serverMessage(ctx, game, req)
+	}
+
+	allGameServers, err := rs.gameCache.All()
+	if err != nil {
+		return 0, xerrors.Errorf("get all game servers: %w", err)
+	}
+	n := 0
+	for _, game := range allGameServers {
+		rooms, err := rs.serverMessage(ctx, game, req)
+		if err != nil {
+			logger.Errorf("ServerMessage: app=%q client=%q host=%q err=%+v", appId, clientId, game.Hostname, err)
+			continue
+		}
+		n += rooms
+	}
+	return n, nil
+}
+
+func (rs *RoomService) serverMessage(ctx context.Context, game *gameServer, req *pb.ServerMessageReq) (int, error) {
+	grpcAddr := fmt.Sprintf("%s:%d", game.Hostname, game.GRPCPort)
+	conn, err := rs.grpcPool.Get(grpcAddr)
+	if err != nil {
+		return 0, xerrors.Errorf("grpcPool.Get(%s): %w", grpcAddr, err)
+	}
+
+	res, err := pb.NewGameClient(conn).ServerMessage(ctx, req)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			return 0, withType(xerrors.Errorf("gRPC ServerMessage: %w", err), ErrRoomNotFound)
+		case codes.InvalidArgument:
+			return 0, withType(xerrors.Errorf("gRPC ServerMessage: %w", err), ErrArgument)
+		}
+		return 0, xerrors.Errorf("gRPC ServerMessage: %w", err)
+	}
+	return int(res.Rooms), nil
+}
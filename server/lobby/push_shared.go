@@ -0,0 +1,142 @@
+package lobby
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/log"
+	"wsnet2/redis"
+)
+
+// 複数のlobbyでのpush APIの呼び出し回数の制限:
+// pushLimiterはlobby毎に数えるので、lobbyを増やすとapp全体の上限も増えてしまう.
+// rate_limit_redis_urlを設定すると、token bucketをRedisに置いて全lobbyで共有する.
+// Redisに繋がらないときは、pushを止めないようにlobby毎のpushLimiterで制限する.
+
+const (
+	sharedRateLimitKeyPrefix = "wsnet2:ratelimit:push:"
+	sharedRateLimitTimeout   = time.Second
+	// sharedRateLimitRetry : 接続に失敗してから再接続を試みるまでの間隔. その間はlobby毎に数える
+	sharedRateLimitRetry = 5 * time.Second
+)
+
+// sharedRateLimitScript : pushBucket.takeと同じtoken bucketをRedisのhash (tokens, updated) で行う.
+// updatedはマイクロ秒. 満タンまで補充される時間が経ったら消えてよいのでPEXPIREする.
+// KEYS[1]: key, ARGV: rate, burst, now, expire(ミリ秒). 許可したら1を返す
+const sharedRateLimitScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local v = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(v[1])
+local updated = v[2]
+if tokens == nil or updated == false then
+  tokens = burst
+  updated = ARGV[3]
+end
+local d = now - tonumber(updated)
+if d > 0 then
+  tokens = tokens + d / 1000000 * rate
+  updated = ARGV[3]
+end
+if tokens > burst then
+  tokens = burst
+end
+local ok = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  ok = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', updated)
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return ok
+`
+
+type rateLimiter interface {
+	allow(appId string, now time.Time) bool
+}
+
+var (
+	_ rateLimiter = &pushLimiter{}
+	_ rateLimiter = &sharedPushLimiter{}
+)
+
+type sharedPushLimiter struct {
+	dial  func(ctx context.Context) (*redis.Conn, error)
+	local *pushLimiter
+
+	// Redisへの読み書きは1つずつ行う
+	mu    sync.Mutex
+	conn  *redis.Conn // 未接続やエラーの後はnil
+	retry time.Time
+}
+
+func newSharedPushLimiter(redisURL string, rate float64, burst int) *sharedPushLimiter {
+	return &sharedPushLimiter{
+		dial: func(ctx context.Context) (*redis.Conn, error) {
+			return redis.Dial(ctx, redisURL)
+		},
+		local: newPushLimiter(rate, burst),
+	}
+}
+
+func (l *sharedPushLimiter) allow(appId string, now time.Time) bool {
+	if l.local.rate <= 0 {
+		return true
+	}
+	ok, err := l.take(appId, now)
+	if err != nil {
+		log.Errorf("shared push limiter: app=%v: %+v", appId, err)
+		return l.local.allow(appId, now)
+	}
+	return ok
+}
+
+func (l *sharedPushLimiter) take(appId string, now time.Time) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		if time.Now().Before(l.retry) {
+			return false, xerrors.Errorf("redis unavailable")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), sharedRateLimitTimeout)
+		defer cancel()
+		conn, err := l.dial(ctx)
+		if err != nil {
+			l.retry = time.Now().Add(sharedRateLimitRetry)
+			return false, xerrors.Errorf("dial: %w", err)
+		}
+		l.conn = conn
+	}
+
+	// 全lobbyが満タンまで補充されるまでの時間はkeyを残す
+	expire := int64(math.Ceil(l.local.burst/l.local.rate*1000)) + 1000
+
+	l.conn.SetDeadline(time.Now().Add(sharedRateLimitTimeout))
+	reply, err := l.conn.Do("EVAL", sharedRateLimitScript, "1", sharedRateLimitKeyPrefix+appId,
+		strconv.FormatFloat(l.local.rate, 'g', -1, 64),
+		strconv.FormatFloat(l.local.burst, 'g', -1, 64),
+		strconv.FormatInt(now.UnixMicro(), 10),
+		strconv.FormatInt(expire, 10))
+	if err != nil {
+		// スクリプトのエラーでなければ応答の途中で切れているかもしれないので繋ぎ直す
+		if _, ok := err.(redis.Error); !ok {
+			l.conn.Close()
+			l.conn = nil
+		}
+		return false, xerrors.Errorf("EVAL: %w", err)
+	}
+	l.conn.SetDeadline(time.Time{})
+
+	n, ok := reply.(int64)
+	if !ok {
+		return false, xerrors.Errorf("unexpected reply: %#v", reply)
+	}
+	return n == 1, nil
+}
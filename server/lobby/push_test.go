@@ -0,0 +1,135 @@
+package lobby
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/xerrors"
+
+	"wsnet2/log"
+	"wsnet2/redis"
+)
+
+func TestPushLimiter(t *testing.T) {
+	now := time.Now()
+	l := newPushLimiter(2, 3)
+
+	for i := 0; i < 3; i++ {
+		if !l.allow("app1", now) {
+			t.Fatalf("allow #%v in burst must be true", i)
+		}
+	}
+	if l.allow("app1", now) {
+		t.Fatalf("allow over burst must be false")
+	}
+	if !l.allow("app2", now) {
+		t.Fatalf("other app must not be limited")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !l.allow("app1", now) {
+		t.Fatalf("allow after refill must be true")
+	}
+	if l.allow("app1", now) {
+		t.Fatalf("allow over refilled tokens must be false")
+	}
+
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		if !l.allow("app1", now) {
+			t.Fatalf("allow #%v after long idle must be true", i)
+		}
+	}
+	if l.allow("app1", now) {
+		t.Fatalf("tokens must not exceed burst")
+	}
+
+	unlimited := newPushLimiter(0, 0)
+	for i := 0; i < 1000; i++ {
+		if !unlimited.allow("app1", now) {
+			t.Fatalf("rate 0 must not limit")
+		}
+	}
+}
+
+func TestSharedPushLimiter(t *testing.T) {
+	defer log.SetLevel(log.SetLevel(log.NOLOG))
+
+	cli, svr := net.Pipe()
+	defer cli.Close()
+	dials := 0
+	l := newSharedPushLimiter("", 2, 3)
+	l.dial = func(ctx context.Context) (*redis.Conn, error) {
+		dials++
+		if dials > 1 {
+			return nil, xerrors.New("redis down")
+		}
+		return redis.NewConn(cli, nil, 0)
+	}
+	now := time.Now()
+
+	// 受け取ったEVALに順に応答する. 空文字列なら接続を切る
+	replies := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv, _ := redis.NewConn(svr, nil, 0)
+		want := []any{[]byte("EVAL"), []byte(sharedRateLimitScript), []byte("1"), []byte("wsnet2:ratelimit:push:app1"),
+			[]byte("2"), []byte("3"), []byte(strconv.FormatInt(now.UnixMicro(), 10)), []byte("2500")}
+		for rep := range replies {
+			cmd, err := srv.ReadReply()
+			if err != nil {
+				t.Errorf("read command: %v", err)
+				return
+			}
+			if diff := cmp.Diff(cmd, want); diff != "" {
+				t.Errorf("command differs: (-got +want)\n%s", diff)
+			}
+			if rep == "" {
+				svr.Close()
+				return
+			}
+			svr.Write([]byte(rep))
+		}
+	}()
+
+	// 他のlobbyが使い切っている
+	replies <- ":0\r\n"
+	if l.allow("app1", now) {
+		t.Fatalf("allow must be false when shared tokens are exhausted")
+	}
+	replies <- ":1\r\n"
+	if !l.allow("app1", now) {
+		t.Fatalf("allow must be true when shared tokens remain")
+	}
+
+	// Redisのエラーならlobby毎に数える
+	replies <- "-ERR script error\r\n"
+	if !l.allow("app1", now) {
+		t.Fatalf("allow with local limiter must be true")
+	}
+	if l.conn == nil {
+		t.Fatalf("connection must be kept after an error reply")
+	}
+	replies <- ""
+	if !l.allow("app1", now) {
+		t.Fatalf("allow with local limiter must be true")
+	}
+	close(replies)
+	<-done
+
+	// 繋ぎ直せなければしばらくlobby毎に数える
+	if !l.allow("app1", now) {
+		t.Fatalf("allow with local limiter must be true")
+	}
+	if l.allow("app1", now) {
+		t.Fatalf("allow over local burst must be false")
+	}
+	if dials != 2 {
+		t.Fatalf("dials = %v, wants 2", dials)
+	}
+}
@@ -2,8 +2,10 @@ package lobby
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"math/rand"
+	"sort"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -13,62 +15,80 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
 
+	"wsnet2/auth"
 	"wsnet2/binary"
 	"wsnet2/common"
 	"wsnet2/config"
 	"wsnet2/log"
 	"wsnet2/pb"
+	"wsnet2/requestid"
 )
 
 type RoomService struct {
 	db       *sqlx.DB
 	conf     *config.LobbyConf
-	apps     map[string]*pb.App
+	apps     *appCache
 	grpcPool *common.GrpcPool
 
 	roomCache *RoomCache
 	gameCache *gameCache
 	hubCache  *hubCache
+
+	pushLimiter rateLimiter
 }
 
-func NewRoomService(db *sqlx.DB, conf *config.LobbyConf) (*RoomService, error) {
-	query := "SELECT id, `key` FROM app"
-	var apps []*pb.App
-	err := db.Select(&apps, query)
-	if err != nil {
-		return nil, xerrors.Errorf("select apps: %w", err)
+// defaultDialOptions : gameサーバとhubサーバへのgRPC接続のDialOption.
+// リクエストIDをmetadataで渡す (see wsnet2/requestid)
+func defaultDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(requestid.UnaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(requestid.StreamClientInterceptor),
+	}
+}
+
+// NewRoomService : opts はgameサーバとhubサーバへのgRPC接続に追加するDialOption (interceptorなど)
+func NewRoomService(db *sqlx.DB, conf *config.LobbyConf, opts ...grpc.DialOption) (*RoomService, error) {
+	apps := newAppCache(db, time.Second*5)
+	if err := apps.Refresh(); err != nil {
+		return nil, err
 	}
 	rs := &RoomService{
 		db:        db,
 		conf:      conf,
-		apps:      make(map[string]*pb.App),
-		grpcPool:  common.NewGrpcPool(grpc.WithTransportCredentials(insecure.NewCredentials())),
-		roomCache: NewRoomCache(db, time.Millisecond*10),
+		apps:      apps,
+		grpcPool:  common.NewGrpcPool(append(defaultDialOptions(), opts...)...),
+		roomCache: NewRoomCache(db, time.Millisecond*10, conf.IndexedProps),
 		gameCache: newGameCache(db, time.Second*1, time.Duration(conf.ValidHeartBeat)),
 		hubCache:  newHubCache(db, time.Second*1, time.Duration(conf.ValidHeartBeat)),
+
+		pushLimiter: newPushLimiter(conf.PushRate, conf.PushBurst),
 	}
-	for i, app := range apps {
-		rs.apps[app.Id] = apps[i]
+	if conf.RateLimitRedisURL != "" {
+		rs.pushLimiter = newSharedPushLimiter(conf.RateLimitRedisURL, conf.PushRate, conf.PushBurst)
 	}
+	rs.hubCache.shedWatchers = conf.HubShedWatchers
+	rs.hubCache.shedBandwidth = conf.HubShedBandwidth
 	return rs, nil
 }
 
-func (rs *RoomService) GetAppKey(appId string) (string, bool) {
-	app, found := rs.apps[appId]
+// GetAppKeys : appの受け付けるkey. 先頭が現在のkey. 無効なappならfalse.
+func (rs *RoomService) GetAppKeys(appId string) ([]string, bool) {
+	app, found := rs.apps.Get(appId)
 	if !found {
-		return "", false
+		return nil, false
 	}
-	return app.Key, true
+	return app.validKeys(time.Now()), true
 }
 
-func (rs *RoomService) Create(ctx context.Context, appId string, roomOption *pb.RoomOption, clientInfo *pb.ClientInfo, macKey string) (*pb.JoinedRoomRes, error) {
-	if _, found := rs.apps[appId]; !found {
+func (rs *RoomService) Create(ctx context.Context, appId string, roomOption *pb.RoomOption, clientInfo *pb.ClientInfo, macKey string, latencies Latencies) (*pb.JoinedRoomRes, error) {
+	if _, found := rs.apps.Get(appId); !found {
 		return nil, xerrors.Errorf("Unknown appId: %v", appId)
 	}
 
-	game, err := rs.gameCache.Rand()
+	game, err := rs.createHost(ctx, appId, roomOption, latencies)
 	if err != nil {
-		return nil, xerrors.Errorf("get game server: %w", err)
+		return nil, err
 	}
 
 	grpcAddr := fmt.Sprintf("%s:%d", game.Hostname, game.GRPCPort)
@@ -94,6 +114,8 @@ func (rs *RoomService) Create(ctx context.Context, appId string, roomOption *pb.
 			switch st.Code() {
 			case codes.InvalidArgument:
 				err = withType(err, ErrArgument)
+			case codes.NotFound: // 親の部屋が既に消えた
+				err = withType(err, ErrArgument)
 			case codes.ResourceExhausted:
 				err = withType(err, ErrRoomLimit)
 			}
@@ -101,9 +123,84 @@ func (rs *RoomService) Create(ctx context.Context, appId string, roomOption *pb.
 		return nil, err
 	}
 
+	if err := rs.proxyURL(res, ProxyKindGame, game.Id); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
+// createHost : 部屋を作るgameサーバ. ロビー部屋の子の部屋は親の部屋と同じgameサーバに作る
+func (rs *RoomService) createHost(ctx context.Context, appId string, roomOption *pb.RoomOption, latencies Latencies) (*gameServer, error) {
+	parent := roomOption.GetParentRoom()
+	if parent == "" {
+		game, err := rs.gameCache.Nearest(latencies, rs.latencyMargin(), rs.hostMatch(appId))
+		if err != nil {
+			return nil, xerrors.Errorf("get game server: %w", err)
+		}
+		return game, nil
+	}
+
+	var hostId uint32
+	err := rs.db.GetContext(ctx, &hostId, "SELECT host_id FROM room WHERE app_id = ? AND id = ?", appId, parent)
+	if err != nil {
+		return nil, withType(
+			xerrors.Errorf("select parent room (id=%v): %w", parent, err),
+			ErrArgument)
+	}
+	game, err := rs.gameCache.Get(hostId)
+	if err != nil {
+		return nil, xerrors.Errorf("get game server(%v): %w", hostId, err)
+	}
+	return game, nil
+}
+
+// CreateChannel : Masterを指定せずに観戦専用の部屋を作る. 部屋へのイベントはServerMessageで送る
+func (rs *RoomService) CreateChannel(ctx context.Context, appId string, roomOption *pb.RoomOption) (*pb.RoomInfo, error) {
+	res, err := rs.Create(ctx, appId, roomOption, nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.RoomInfo, nil
+}
+
+// excludeDownHosts : HeartBeatが途絶えたgameサーバの部屋を除く.
+// rooms, propsはキャッシュを共有しているので書き換えず、除く部屋があるときだけ新しいsliceを返す.
+func (rs *RoomService) excludeDownHosts(rooms []*pb.RoomInfo, props []binary.Dict) ([]*pb.RoomInfo, []binary.Dict) {
+	down := make(map[uint32]bool)
+	n := 0
+	for _, r := range rooms {
+		d, ok := down[r.HostId]
+		if !ok {
+			d = rs.gameCache.IsDown(r.HostId)
+			down[r.HostId] = d
+		}
+		if d {
+			n++
+		}
+	}
+	if n == 0 {
+		return rooms, props
+	}
+	aliveRooms := make([]*pb.RoomInfo, 0, len(rooms)-n)
+	aliveProps := make([]binary.Dict, 0, len(rooms)-n)
+	for i, r := range rooms {
+		if !down[r.HostId] {
+			aliveRooms = append(aliveRooms, r)
+			aliveProps = append(aliveProps, props[i])
+		}
+	}
+	return aliveRooms, aliveProps
+}
+
+// gameServerError : gameCache.Get()のエラー. HeartBeatが途絶えていれば再試行までの時間を付けたErrGameServerDownにする
+func (rs *RoomService) gameServerError(err error, hostId uint32) error {
+	err = xerrors.Errorf("get game server(%v): %w", hostId, err)
+	if xerrors.Is(err, errGameServerDown) {
+		return &errorWithType{err, ErrGameServerDown, time.Duration(rs.conf.GameDownRetryAfter)}
+	}
+	return err
+}
+
 func filter(rooms []*pb.RoomInfo, props []binary.Dict, queries []PropQueries, limit int, checkJoinable, checkWatchable bool, logger log.Logger) []*pb.RoomInfo {
 	if limit == 0 || limit > len(rooms) {
 		limit = len(rooms)
@@ -137,9 +234,14 @@ func filter(rooms []*pb.RoomInfo, props []binary.Dict, queries []PropQueries, li
 }
 
 func (rs *RoomService) join(ctx context.Context, appId, roomId string, clientInfo *pb.ClientInfo, macKey string, hostId uint32) (*pb.JoinedRoomRes, error) {
+	return rs.joinWithInvite(ctx, appId, roomId, clientInfo, macKey, "", hostId)
+}
+
+// joinWithInvite : 招待トークンを添えて入室する. gameサーバは招待の部屋とユーザ、期限を確認する
+func (rs *RoomService) joinWithInvite(ctx context.Context, appId, roomId string, clientInfo *pb.ClientInfo, macKey, invite string, hostId uint32) (*pb.JoinedRoomRes, error) {
 	game, err := rs.gameCache.Get(hostId)
 	if err != nil {
-		return nil, xerrors.Errorf("get game server(%v): %w", hostId, err)
+		return nil, rs.gameServerError(err, hostId)
 	}
 
 	grpcAddr := fmt.Sprintf("%s:%d", game.Hostname, game.GRPCPort)
@@ -151,38 +253,49 @@ func (rs *RoomService) join(ctx context.Context, appId, roomId string, clientInf
 	client := pb.NewGameClient(conn)
 
 	req := &pb.JoinRoomReq{
-		AppId:      appId,
-		RoomId:     roomId,
-		ClientInfo: clientInfo,
-		MacKey:     macKey,
+		AppId:       appId,
+		RoomId:      roomId,
+		ClientInfo:  clientInfo,
+		MacKey:      macKey,
+		InviteToken: invite,
 	}
 
 	res, err := client.Join(ctx, req)
 	if err != nil {
-		st, ok := status.FromError(err)
-		err = xerrors.Errorf("gRPC Join: %w", err)
-		if ok {
-			switch st.Code() {
-			case codes.NotFound: // roomが既に消えた
-				err = withType(err, ErrNoJoinableRoom)
-			case codes.FailedPrecondition: // joinableでなくなっていた
-				err = withType(err, ErrNoJoinableRoom)
-			case codes.ResourceExhausted: // 満室
-				err = withType(err, ErrRoomFull)
-			case codes.AlreadyExists: // 既に入室している
-				err = withType(err, ErrAlreadyJoined)
-			case codes.InvalidArgument:
-				err = withType(err, ErrArgument)
-			}
-		}
-		return nil, err
+		return nil, joinError("Join", err)
 	}
 
+	if err := rs.proxyURL(res, ProxyKindGame, game.Id); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
+// joinError : gameサーバの入室に関するgRPCのエラーにErrTypeを付ける
+func joinError(method string, err error) error {
+	st, ok := status.FromError(err)
+	err = xerrors.Errorf("gRPC %s: %w", method, err)
+	if ok {
+		switch st.Code() {
+		case codes.NotFound: // roomが既に消えた
+			err = withType(err, ErrNoJoinableRoom)
+		case codes.FailedPrecondition: // joinableでなくなっていた
+			err = withType(err, ErrNoJoinableRoom)
+		case codes.ResourceExhausted: // 満室
+			err = withType(err, ErrRoomFull)
+		case codes.AlreadyExists: // 既に入室している
+			err = withType(err, ErrAlreadyJoined)
+		case codes.PermissionDenied: // banされている
+			err = withType(err, ErrBanned)
+		case codes.InvalidArgument:
+			err = withType(err, ErrArgument)
+		}
+	}
+	return err
+}
+
 func (rs *RoomService) JoinById(ctx context.Context, appId, roomId string, queries []PropQueries, clientInfo *pb.ClientInfo, macKey string, logger log.Logger) (*pb.JoinedRoomRes, error) {
-	if _, found := rs.apps[appId]; !found {
+	if _, found := rs.apps.Get(appId); !found {
 		return nil, xerrors.Errorf("Unknown appId: %v", appId)
 	}
 
@@ -210,7 +323,7 @@ func (rs *RoomService) JoinById(ctx context.Context, appId, roomId string, queri
 }
 
 func (rs *RoomService) JoinByNumber(ctx context.Context, appId string, roomNumber int32, queries []PropQueries, clientInfo *pb.ClientInfo, macKey string, logger log.Logger) (*pb.JoinedRoomRes, error) {
-	if _, found := rs.apps[appId]; !found {
+	if _, found := rs.apps.Get(appId); !found {
 		return nil, xerrors.Errorf("Unknown appId: %v", appId)
 	}
 
@@ -237,14 +350,136 @@ func (rs *RoomService) JoinByNumber(ctx context.Context, appId string, roomNumbe
 	return rs.join(ctx, appId, filtered[0].Id, clientInfo, macKey, filtered[0].HostId)
 }
 
-func (rs *RoomService) JoinAtRandom(ctx context.Context, appId string, searchGroup uint32, queries []PropQueries, clientInfo *pb.ClientInfo, macKey string, logger log.Logger) (*pb.JoinedRoomRes, error) {
-	rooms, props, err := rs.roomCache.GetRooms(ctx, appId, searchGroup)
+// Invite : 部屋への招待トークンを発行する. 発行できるのは部屋にいるクライアントのみ.
+// トークンはappのkeyで署名するので、app keyを知らない相手にも共有できる.
+func (rs *RoomService) Invite(ctx context.Context, appId, roomId, inviterId string, param *InviteParam, logger log.Logger) (*Invitation, error) {
+	app, found := rs.apps.Get(appId)
+	if !found {
+		return nil, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+
+	var room pb.RoomInfo
+	err := rs.db.GetContext(ctx, &room, "SELECT * FROM room WHERE app_id = ? AND id = ?", appId, roomId)
+	if err != nil {
+		return nil, withType(
+			xerrors.Errorf("select room (id=%v): %w", roomId, err),
+			ErrRoomNotFound)
+	}
+
+	info, err := rs.getRoomInfo(ctx, appId, &room)
+	if err != nil {
+		return nil, xerrors.Errorf("getRoomInfo: %w", err)
+	}
+	if !hasClient(info, inviterId) {
+		return nil, withType(
+			xerrors.Errorf("inviter is not in the room: room=%v inviter=%v", roomId, inviterId),
+			ErrArgument)
+	}
+
+	if param.Reserve && param.UserId == "" {
+		return nil, withType(xerrors.Errorf("reserve needs invitee user id"), ErrArgument)
+	}
+
+	expire := time.Duration(rs.conf.MaxInviteExpire)
+	if d := time.Duration(param.Expire) * time.Second; d > 0 && d < expire {
+		expire = d
+	}
+	expireAt := time.Now().Add(expire)
+
+	token, err := auth.GenerateInviteToken(app.Key, roomId, param.UserId, expireAt)
+	if err != nil {
+		return nil, withType(xerrors.Errorf("GenerateInviteToken: %w", err), ErrArgument)
+	}
+	logger.Debugf("invite: room=%v user=%q expire=%v reserve=%v", roomId, param.UserId, expireAt, param.Reserve)
+
+	if param.Reserve {
+		if err := rs.reserveSeat(ctx, appId, &room, token); err != nil {
+			return nil, err
+		}
+	}
+
+	inv := &Invitation{
+		Token:  token,
+		Expire: expireAt.Unix(),
+	}
+	if rs.conf.InviteURLFormat != "" {
+		inv.Url = fmt.Sprintf(rs.conf.InviteURLFormat, token)
+	}
+	return inv, nil
+}
+
+// reserveSeat : 招待されたユーザの席を招待の期限まで確保する
+func (rs *RoomService) reserveSeat(ctx context.Context, appId string, room *pb.RoomInfo, token string) error {
+	game, err := rs.gameCache.Get(room.HostId)
+	if err != nil {
+		return rs.gameServerError(err, room.HostId)
+	}
+	grpcAddr := fmt.Sprintf("%s:%d", game.Hostname, game.GRPCPort)
+	conn, err := rs.grpcPool.Get(grpcAddr)
+	if err != nil {
+		return xerrors.Errorf("grpcPool.Get(%s): %w", grpcAddr, err)
+	}
+
+	_, err = pb.NewGameClient(conn).ReserveSeat(ctx, &pb.ReserveSeatReq{
+		AppId:       appId,
+		RoomId:      room.Id,
+		InviteToken: token,
+	})
+	if err != nil {
+		return joinError("ReserveSeat", err)
+	}
+	return nil
+}
+
+// JoinByInvite : 招待トークンの部屋に入室する.
+// ユーザを指定した招待は、そのユーザ（userIdとClientInfo.Idが一致）だけが使える.
+func (rs *RoomService) JoinByInvite(ctx context.Context, appId, userId, token string, clientInfo *pb.ClientInfo, macKey string, logger log.Logger) (*pb.JoinedRoomRes, error) {
+	app, found := rs.apps.Get(appId)
+	if !found {
+		return nil, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+
+	// keyの更新前に発行したトークンも猶予期間の間は使える
+	var roomId, invitee string
+	var err error
+	now := time.Now()
+	for _, key := range app.validKeys(now) {
+		roomId, invitee, err = auth.ValidInviteToken(token, key, now)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, withType(xerrors.Errorf("invalid invite token: %w", err), ErrArgument)
+	}
+	if invitee != "" && (invitee != userId || invitee != clientInfo.GetId()) {
+		return nil, withType(
+			xerrors.Errorf("invite is for other user: invitee=%v user=%v client=%v", invitee, userId, clientInfo.GetId()),
+			ErrArgument)
+	}
+	logger.Debugf("join by invite: room=%v", roomId)
+
+	var room pb.RoomInfo
+	err = rs.db.GetContext(ctx, &room, "SELECT * FROM room WHERE app_id = ? AND id = ? AND joinable = 1", appId, roomId)
+	if err != nil {
+		return nil, withType(
+			xerrors.Errorf("select room (id=%v): %w", roomId, err),
+			ErrNoJoinableRoom)
+	}
+
+	return rs.joinWithInvite(ctx, appId, room.Id, clientInfo, macKey, token, room.HostId)
+}
+
+func (rs *RoomService) JoinAtRandom(ctx context.Context, appId string, searchGroup uint32, queries []PropQueries, clientInfo *pb.ClientInfo, macKey string, latencies Latencies, logger log.Logger) (*pb.JoinedRoomRes, error) {
+	rooms, props, err := rs.roomCache.GetRooms(ctx, appId, searchGroup, queries)
 	if err != nil {
 		return nil, xerrors.Errorf("get rooms (group=%v): %w", searchGroup, err)
 	}
+	rooms, props = rs.excludeDownHosts(rooms, props)
 	filtered := filter(rooms, props, queries, 1000, true, false, logger)
 
 	rand.Shuffle(len(filtered), func(i, j int) { filtered[i], filtered[j] = filtered[j], filtered[i] })
+	sortByLatency(filtered, latencies, rs.latencyMargin())
 
 	for _, room := range filtered {
 		select {
@@ -272,13 +507,95 @@ func (rs *RoomService) JoinAtRandom(ctx context.Context, appId string, searchGro
 		ErrNoJoinableRoom)
 }
 
-func (rs *RoomService) Search(ctx context.Context, appId string, searchGroup uint32, queries []PropQueries, limit int, joinable, watchable bool, logger log.Logger) ([]*pb.RoomInfo, error) {
-	rooms, props, err := rs.roomCache.GetRooms(ctx, appId, searchGroup)
-	if err != nil {
-		return nil, xerrors.Errorf("get rooms (group=%v): %w", searchGroup, err)
+// Search : searchGroupsの部屋を検索する.
+// 複数の検索グループの結果は指定順に連結し、同じ部屋は1つにまとめる.
+func (rs *RoomService) Search(ctx context.Context, appId string, searchGroups []uint32, queries []PropQueries, limit int, joinable, watchable bool, logger log.Logger) ([]*pb.RoomInfo, error) {
+	if len(searchGroups) == 1 {
+		rooms, props, err := rs.roomCache.GetRooms(ctx, appId, searchGroups[0], queries)
+		if err != nil {
+			return nil, xerrors.Errorf("get rooms (group=%v): %w", searchGroups[0], err)
+		}
+		rooms, props = rs.excludeDownHosts(rooms, props)
+		return filter(rooms, props, queries, limit, joinable, watchable, logger), nil
 	}
 
-	return filter(rooms, props, queries, limit, joinable, watchable, logger), nil
+	found := []*pb.RoomInfo{}
+	seen := make(map[string]struct{})
+	for _, sg := range searchGroups {
+		rest := 0
+		if limit > 0 {
+			rest = limit - len(found)
+			if rest <= 0 {
+				break
+			}
+		}
+		rooms, props, err := rs.roomCache.GetRooms(ctx, appId, sg, queries)
+		if err != nil {
+			return nil, xerrors.Errorf("get rooms (group=%v): %w", sg, err)
+		}
+		rooms, props = rs.excludeDownHosts(rooms, props)
+		found = mergeRooms(found, seen, filter(rooms, props, queries, rest, joinable, watchable, logger))
+	}
+	return found, nil
+}
+
+// SearchWatchable : 観戦可能な部屋を観戦者数やプレイヤー数の多い順に返す.
+// 部屋の人数はGameが変更の度にDBに書き込んだもので、
+// HeartBeatが途絶えたGameの部屋は人数が古くなっているので含めない.
+func (rs *RoomService) SearchWatchable(ctx context.Context, appId string, searchGroups []uint32, queries []PropQueries, limit int, sortBy WatchableSortKey, logger log.Logger) ([]*pb.RoomInfo, error) {
+	found := []*pb.RoomInfo{}
+	seen := make(map[string]struct{})
+	for _, sg := range searchGroups {
+		rooms, props, err := rs.roomCache.GetRooms(ctx, appId, sg, queries)
+		if err != nil {
+			return nil, xerrors.Errorf("get rooms (group=%v): %w", sg, err)
+		}
+		found = mergeRooms(found, seen, filter(rooms, props, queries, 0, false, true, logger))
+	}
+
+	alive := found[:0]
+	for _, r := range found {
+		if _, err := rs.gameCache.Get(r.HostId); err != nil {
+			logger.Debugf("skip room %v: %v", r.Id, err)
+			continue
+		}
+		alive = append(alive, r)
+	}
+
+	sortWatchable(alive, sortBy)
+	if limit > 0 && len(alive) > limit {
+		alive = alive[:limit]
+	}
+	return alive, nil
+}
+
+// sortWatchable : sortByの人数の多い順に並べる. 同数のときはもう一方の人数の多い順.
+func sortWatchable(rooms []*pb.RoomInfo, sortBy WatchableSortKey) {
+	sort.SliceStable(rooms, func(i, j int) bool {
+		a, b := rooms[i], rooms[j]
+		if sortBy == SortByPlayers {
+			if a.Players != b.Players {
+				return a.Players > b.Players
+			}
+			return a.Watchers > b.Watchers
+		}
+		if a.Watchers != b.Watchers {
+			return a.Watchers > b.Watchers
+		}
+		return a.Players > b.Players
+	})
+}
+
+// mergeRooms : roomsのうちseenに無い部屋をdstに追加する
+func mergeRooms(dst []*pb.RoomInfo, seen map[string]struct{}, rooms []*pb.RoomInfo) []*pb.RoomInfo {
+	for _, r := range rooms {
+		if _, ok := seen[r.Id]; ok {
+			continue
+		}
+		seen[r.Id] = struct{}{}
+		dst = append(dst, r)
+	}
+	return dst
 }
 
 func (rs *RoomService) SearchByIds(ctx context.Context, appId string, roomIds []string, queries []PropQueries, logger log.Logger) ([]*pb.RoomInfo, error) {
@@ -321,9 +638,46 @@ func (rs *RoomService) searchBySQL(ctx context.Context, sql string, params []any
 			return nil, xerrors.Errorf("unmarshalProps(room=%v): %w", r.Id, err)
 		}
 	}
+	rooms, props = rs.excludeDownHosts(rooms, props)
 	return filter(rooms, props, queries, len(rooms), false, false, logger), nil
 }
 
+// ResolveNumber : 部屋番号から部屋のあるgameサーバとwebsocket URLを引く.
+// 部屋番号は全gameサーバで一意なので、どのgameサーバの部屋でも引ける.
+func (rs *RoomService) ResolveNumber(ctx context.Context, appId string, roomNumber int32, logger log.Logger) (*RoomLocation, error) {
+	if _, found := rs.apps.Get(appId); !found {
+		return nil, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+
+	var room pb.RoomInfo
+	err := rs.db.GetContext(ctx, &room, "SELECT * FROM room WHERE app_id = ? AND number = ?", appId, roomNumber)
+	if err != nil {
+		if xerrors.Is(err, sql.ErrNoRows) {
+			return nil, withType(
+				xerrors.Errorf("room not found (num=%v): %w", roomNumber, err),
+				ErrRoomNotFound)
+		}
+		return nil, xerrors.Errorf("select room (num=%v): %w", roomNumber, err)
+	}
+
+	game, err := rs.gameCache.Get(room.HostId)
+	if err != nil {
+		return nil, rs.gameServerError(err, room.HostId)
+	}
+	if game.WSURL == "" {
+		return nil, xerrors.Errorf("game server has no ws_url (id=%v)", game.Id)
+	}
+	logger.Debugf("resolved room number: %v -> %v@%v", roomNumber, room.Id, game.Hostname)
+
+	return &RoomLocation{
+		RoomId: room.Id,
+		Number: roomNumber,
+		HostId: game.Id,
+		Host:   game.PublicName,
+		Url:    game.WSURL + room.Id,
+	}, nil
+}
+
 func (rs *RoomService) watch(ctx context.Context, room *pb.RoomInfo, clientInfo *pb.ClientInfo, macKey string) (*pb.JoinedRoomRes, error) {
 	var hubIDs []uint32
 	err := rs.db.Select(&hubIDs, "SELECT `host_id` FROM `hub` WHERE `room_id`=? AND `watchers`<?", room.Id, rs.conf.HubMaxWatchers)
@@ -331,13 +685,7 @@ func (rs *RoomService) watch(ctx context.Context, room *pb.RoomInfo, clientInfo
 		return nil, xerrors.Errorf("select hub: %w", err)
 	}
 
-	var hub *hubServer
-	if len(hubIDs) > 0 {
-		n := rand.Intn(len(hubIDs))
-		hub, err = rs.hubCache.Get(hubIDs[n])
-	} else {
-		hub, err = rs.hubCache.Rand()
-	}
+	hub, err := rs.hubCache.Select(hubIDs)
 	if err != nil {
 		return nil, xerrors.Errorf("get hub server: %w", err)
 	}
@@ -352,7 +700,7 @@ func (rs *RoomService) watch(ctx context.Context, room *pb.RoomInfo, clientInfo
 
 	game, err := rs.gameCache.Get(room.HostId)
 	if err != nil {
-		return nil, xerrors.Errorf("get game server: %w", err)
+		return nil, rs.gameServerError(err, room.HostId)
 	}
 
 	req := &pb.JoinRoomReq{
@@ -374,6 +722,8 @@ func (rs *RoomService) watch(ctx context.Context, room *pb.RoomInfo, clientInfo
 				err = withType(err, ErrNoWatchableRoom)
 			case codes.FailedPrecondition: // watchableでなくなっていた
 				err = withType(err, ErrNoWatchableRoom)
+			case codes.ResourceExhausted: // 観戦者数の上限
+				err = withType(err, ErrRoomFull)
 			case codes.AlreadyExists: // 既に入室している
 				err = withType(err, ErrAlreadyJoined)
 			case codes.InvalidArgument:
@@ -383,11 +733,14 @@ func (rs *RoomService) watch(ctx context.Context, room *pb.RoomInfo, clientInfo
 		return nil, err
 	}
 
+	if err := rs.proxyURL(res, ProxyKindHub, hub.Id); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
 func (rs *RoomService) WatchById(ctx context.Context, appId, roomId string, queries []PropQueries, clientInfo *pb.ClientInfo, macKey string, logger log.Logger) (*pb.JoinedRoomRes, error) {
-	if _, found := rs.apps[appId]; !found {
+	if _, found := rs.apps.Get(appId); !found {
 		return nil, xerrors.Errorf("Unknown appId: %v", appId)
 	}
 
@@ -415,7 +768,7 @@ func (rs *RoomService) WatchById(ctx context.Context, appId, roomId string, quer
 }
 
 func (rs *RoomService) WatchByNumber(ctx context.Context, appId string, roomNumber int32, queries []PropQueries, clientInfo *pb.ClientInfo, macKey string, logger log.Logger) (*pb.JoinedRoomRes, error) {
-	if _, found := rs.apps[appId]; !found {
+	if _, found := rs.apps.Get(appId); !found {
 		return nil, xerrors.Errorf("Unknown appId: %v", appId)
 	}
 
@@ -443,7 +796,7 @@ func (rs *RoomService) WatchByNumber(ctx context.Context, appId string, roomNumb
 }
 
 func (rs *RoomService) AdminKick(ctx context.Context, appId, targetID string, logger log.Logger) error {
-	if _, found := rs.apps[appId]; !found {
+	if _, found := rs.apps.Get(appId); !found {
 		return xerrors.Errorf("Unknown appId: %v", appId)
 	}
 
@@ -480,3 +833,231 @@ func (rs *RoomService) adminKick(appID, targetID string, logger log.Logger) {
 	}
 
 }
+
+func (rs *RoomService) AdminMessage(ctx context.Context, appId, message string, logger log.Logger) error {
+	if _, found := rs.apps.Get(appId); !found {
+		return xerrors.Errorf("Unknown appId: %v", appId)
+	}
+
+	go rs.adminMessage(appId, message, logger)
+	return nil
+}
+
+func (rs *RoomService) adminMessage(appID, message string, logger log.Logger) {
+	allGameServers, err := rs.gameCache.All()
+	if err != nil {
+		logger.Errorf("adminMessage: get all game servers: %+v", err)
+		return
+	}
+
+	for _, game := range allGameServers {
+		grpcAddr := fmt.Sprintf("%s:%d", game.Hostname, game.GRPCPort)
+		conn, err := rs.grpcPool.Get(grpcAddr)
+		if err != nil {
+			logger.Errorf("adminMessage: gRPC: %+v", err)
+			continue
+		}
+
+		client := pb.NewGameClient(conn)
+		req := &pb.AdminMessageReq{
+			AppId:   appID,
+			Message: message,
+		}
+		res, err := client.AdminMessage(context.Background(), req)
+		if err != nil {
+			logger.Errorf("adminMessage: app=%q host=%q err=%+v", appID, game.Hostname, err)
+			continue
+		}
+		logger.Debugf("adminMessage: app=%q host=%q rooms=%v", appID, game.Hostname, res.Rooms)
+	}
+}
+
+// adminRoomsDefaultLimit : AdminQueryRoomsで取得する部屋数のデフォルト
+const adminRoomsDefaultLimit = 100
+
+// AdminQueryRooms : 全gameサーバーから部屋とプレイヤーの情報を取得する
+//
+// roomテーブルから条件に合う部屋を選び、各gameサーバーにGetRoomInfoで問い合わせる.
+// clientIdを指定したときはplayer_logから入室した部屋を探し、現在入室中の部屋だけを返す.
+func (rs *RoomService) AdminQueryRooms(ctx context.Context, appId string, searchGroup *uint32, clientId string, limit int, logger log.Logger) ([]*pb.GetRoomInfoRes, error) {
+	if _, found := rs.apps.Get(appId); !found {
+		return nil, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+	if limit <= 0 {
+		limit = adminRoomsDefaultLimit
+	}
+
+	sql := "SELECT * FROM room WHERE app_id = ?"
+	params := []any{appId}
+	if searchGroup != nil {
+		sql += " AND search_group = ?"
+		params = append(params, *searchGroup)
+	}
+	if clientId != "" {
+		sql += " AND id IN (SELECT room_id FROM player_log WHERE player_id = ?)"
+		params = append(params, clientId)
+	}
+	sql += " ORDER BY created LIMIT ?"
+	params = append(params, limit)
+
+	var rooms []*pb.RoomInfo
+	err := rs.db.SelectContext(ctx, &rooms, sql, params...)
+	if err != nil {
+		return nil, xerrors.Errorf("Select: %w", err)
+	}
+
+	infos := make([]*pb.GetRoomInfoRes, 0, len(rooms))
+	for _, room := range rooms {
+		info, err := rs.getRoomInfo(ctx, appId, room)
+		if err != nil {
+			// 問い合わせの間に部屋が終了していることがある
+			logger.Infof("AdminQueryRooms: room=%v host=%v: %+v", room.Id, room.HostId, err)
+			continue
+		}
+		if clientId != "" && !hasClient(info, clientId) {
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func (rs *RoomService) getRoomInfo(ctx context.Context, appId string, room *pb.RoomInfo) (*pb.GetRoomInfoRes, error) {
+	game, err := rs.gameCache.Get(room.HostId)
+	if err != nil {
+		return nil, xerrors.Errorf("get game server(%v): %w", room.HostId, err)
+	}
+
+	grpcAddr := fmt.Sprintf("%s:%d", game.Hostname, game.GRPCPort)
+	conn, err := rs.grpcPool.Get(grpcAddr)
+	if err != nil {
+		return nil, xerrors.Errorf("grpcPool.Get(%s): %w", grpcAddr, err)
+	}
+
+	res, err := pb.NewGameClient(conn).GetRoomInfo(ctx, &pb.GetRoomInfoReq{AppId: appId, RoomId: room.Id})
+	if err != nil {
+		return nil, xerrors.Errorf("gRPC GetRoomInfo: %w", err)
+	}
+	return res, nil
+}
+
+func hasClient(info *pb.GetRoomInfoRes, clientId string) bool {
+	for _, c := range info.ClientInfos {
+		if c.Id == clientId {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminAppStats : 全gameサーバーからappの部屋数、プレイヤー数、メッセージ数を集計する.
+// gameサーバ毎の内訳と、gameサーバのタグ毎の集計も返す.
+//
+// 応答しなかったgameサーバーは集計から除く.
+func (rs *RoomService) AdminAppStats(ctx context.Context, appId string, logger log.Logger) (*pb.AppStatsRes, []*pb.AppStatsRes, map[string]*pb.AppStatsRes, error) {
+	if _, found := rs.apps.Get(appId); !found {
+		return nil, nil, nil, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+
+	allGameServers, err := rs.gameCache.All()
+	if err != nil {
+		return nil, nil, nil, xerrors.Errorf("get all game servers: %w", err)
+	}
+
+	total := &pb.AppStatsRes{}
+	hosts := make([]*pb.AppStatsRes, 0, len(allGameServers))
+	tags := make(map[string]*pb.AppStatsRes)
+	for _, game := range allGameServers {
+		grpcAddr := fmt.Sprintf("%s:%d", game.Hostname, game.GRPCPort)
+		conn, err := rs.grpcPool.Get(grpcAddr)
+		if err != nil {
+			logger.Errorf("AdminAppStats: gRPC: %+v", err)
+			continue
+		}
+
+		res, err := pb.NewGameClient(conn).GetAppStats(ctx, &pb.AppStatsReq{AppId: appId})
+		if err != nil {
+			logger.Errorf("AdminAppStats: app=%q host=%q err=%+v", appId, game.Hostname, err)
+			continue
+		}
+		hosts = append(hosts, res)
+
+		addAppStats(total, res)
+		if tags[game.Tag] == nil {
+			tags[game.Tag] = &pb.AppStatsRes{}
+		}
+		addAppStats(tags[game.Tag], res)
+	}
+
+	return total, hosts, tags, nil
+}
+
+// addAppStats : resの値をtotalに加える
+func addAppStats(total, res *pb.AppStatsRes) {
+	total.Rooms += res.Rooms
+	total.Players += res.Players
+	total.Watchers += res.Watchers
+	total.Conns += res.Conns
+	total.MessageRecv += res.MessageRecv
+	total.MessageSent += res.MessageSent
+	total.BytesIn += res.BytesIn
+	total.BytesOut += res.BytesOut
+	total.MessageRecvRate += res.MessageRecvRate
+	total.MessageSentRate += res.MessageSentRate
+	total.RoomBytes += res.RoomBytes
+	total.RoomGoroutines += res.RoomGoroutines
+	total.HeavyRooms = mergeHeavyRooms(total.HeavyRooms, res.HeavyRooms)
+}
+
+// mergeHeavyRooms : メモリの使用量が多い部屋をまとめ、多い順にgameサーバが返した件数だけ残す
+func mergeHeavyRooms(a, b []*pb.RoomUsage) []*pb.RoomUsage {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	rooms := make([]*pb.RoomUsage, 0, len(a)+len(b))
+	rooms = append(append(rooms, a...), b...)
+	pb.SortRoomUsages(rooms)
+	return rooms[:n]
+}
+
+// AdminHosts : HeartBeatが途絶えたものも含めて、gameサーバの状態を返す
+func (rs *RoomService) AdminHosts(ctx context.Context, appId string, logger log.Logger) ([]*AdminHost, error) {
+	if _, found := rs.apps.Get(appId); !found {
+		return nil, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+
+	games, down, err := rs.gameCache.Hosts()
+	if err != nil {
+		return nil, xerrors.Errorf("get game servers: %w", err)
+	}
+
+	hosts := make([]*AdminHost, 0, len(games))
+	for _, game := range games {
+		hosts = append(hosts, &AdminHost{
+			Id:         game.Id,
+			Hostname:   game.Hostname,
+			PublicName: game.PublicName,
+			Tag:        game.Tag,
+			Status:     hostStatus(game.Status, down[game.Id]),
+			Heartbeat:  game.Heartbeat,
+		})
+	}
+	return hosts, nil
+}
+
+func hostStatus(status int32, down bool) string {
+	if down {
+		return "down"
+	}
+	switch status {
+	case common.HostStatusStarting:
+		return "starting"
+	case common.HostStatusRunning:
+		return "running"
+	case common.HostStatusClosing:
+		return "closing"
+	}
+	return fmt.Sprintf("unknown(%v)", status)
+}
@@ -1,13 +1,17 @@
 package lobby
 
 import (
+	"bytes"
 	"context"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
 
 	"wsnet2/binary"
+	"wsnet2/common"
 	"wsnet2/log"
 	"wsnet2/pb"
 )
@@ -16,77 +20,193 @@ type roomCacheQuery struct {
 	sync.Mutex
 	db     *sqlx.DB
 	expire time.Duration
+	clock  common.Clock
 	query  string
 	args   []interface{}
 
 	lastUpdated time.Time
 	result      []*pb.RoomInfo
 	props       []binary.Dict
+	index       propIndex
 	lastError   error
+
+	// indexKeys : 索引を作る公開プロパティのキー
+	indexKeys []string
+
+	// propsCache : 部屋ごとのunmarshal済みprops. propsが変わっていない部屋は再利用する
+	propsCache map[string]cachedProps
+}
+
+// cachedProps : unmarshal済みのprops.
+// dictは raw を参照しているので raw と一緒に保持する.
+type cachedProps struct {
+	raw  []byte
+	dict binary.Dict
 }
 
 func newRoomCacheQuery(db *sqlx.DB, expire time.Duration, sql string, args ...interface{}) *roomCacheQuery {
 	return &roomCacheQuery{
 		db:     db,
 		expire: expire,
+		clock:  common.RealClock,
 		query:  sql,
 		args:   args,
 	}
 }
 
-func (q *roomCacheQuery) do(ctx context.Context) ([]*pb.RoomInfo, []binary.Dict, error) {
+func (q *roomCacheQuery) do(ctx context.Context) ([]*pb.RoomInfo, []binary.Dict, propIndex, error) {
 	q.Lock()
 	defer q.Unlock()
 
-	now := time.Now()
+	now := q.clock.Now()
 
 	if q.lastUpdated.Add(q.expire).After(now) {
-		return q.result, q.props, q.lastError
+		return q.result, q.props, q.index, q.lastError
 	}
 
 	rooms := []*pb.RoomInfo{}
 	err := q.db.SelectContext(ctx, &rooms, q.query, q.args...)
 	if err != nil {
 		q.result = nil
+		q.props = nil
+		q.index = nil
 		q.lastError = err
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	props := []binary.Dict{}
+	props, cache, errs := unmarshalRoomProps(rooms, q.propsCache)
+	for _, err := range errs {
+		log.Errorf("props unmarshal error: %+v", err)
+	}
+
+	q.result = rooms
+	q.props = props
+	q.index = newPropIndex(props, q.indexKeys)
+	q.propsCache = cache
+	q.lastError = nil
+	q.lastUpdated = q.clock.Now()
+
+	return q.result, q.props, q.index, q.lastError
+}
+
+// unmarshalRoomProps : 部屋のpropsをunmarshalする.
+// 前回から変わっていない部屋は prev のものを再利用する.
+// unmarshalできなかった部屋のpropsは空にし、そのエラーを返す.
+func unmarshalRoomProps(rooms []*pb.RoomInfo, prev map[string]cachedProps) ([]binary.Dict, map[string]cachedProps, []error) {
+	props := make([]binary.Dict, 0, len(rooms))
+	cache := make(map[string]cachedProps, len(rooms))
+	var errs []error
 	for _, r := range rooms {
+		if c, ok := prev[r.Id]; ok && bytes.Equal(c.raw, r.PublicProps) {
+			props = append(props, c.dict)
+			cache[r.Id] = c
+			continue
+		}
 		um, err := unmarshalProps(r.PublicProps)
 		if err != nil {
-			log.Errorf("props unmarshal error: %+v", err)
+			errs = append(errs, xerrors.Errorf("room %v: %w", r.Id, err))
 			props = append(props, binary.Dict{})
 			continue
 		}
 		props = append(props, um)
+		cache[r.Id] = cachedProps{r.PublicProps, um}
 	}
+	return props, cache, errs
+}
 
-	q.result = rooms
-	q.props = props
-	q.lastError = nil
-	q.lastUpdated = time.Now()
+// propIndex : 公開プロパティのキー -> marshal済みの値 -> 部屋のindex(昇順)
+type propIndex map[string]map[string][]int
 
-	return q.result, q.props, q.lastError
+// newPropIndex : propsのkeysについての転置索引を作る
+func newPropIndex(props []binary.Dict, keys []string) propIndex {
+	if len(keys) == 0 {
+		return nil
+	}
+	idx := make(propIndex, len(keys))
+	for _, k := range keys {
+		vals := make(map[string][]int)
+		for i, p := range props {
+			if v, ok := p[k]; ok {
+				vals[string(v)] = append(vals[string(v)], i)
+			}
+		}
+		idx[k] = vals
+	}
+	return idx
+}
+
+// candidates : queriesにマッチし得る部屋のindex(昇順).
+// 索引で絞り込めないORの項があるときは全部屋が候補となるので ok=false を返す.
+func (idx propIndex) candidates(queries []PropQueries) ([]int, bool) {
+	if len(idx) == 0 || len(queries) == 0 {
+		return nil, false
+	}
+	lists := make([][]int, 0, len(queries))
+	for _, pqs := range queries {
+		// AND条件の中で最も候補の少ない等値検索を使う
+		var best []int
+		found := false
+		for _, q := range pqs {
+			if q.Op != OpEqual {
+				continue
+			}
+			vals, ok := idx[q.Key]
+			if !ok {
+				continue
+			}
+			l := vals[string(q.Val)]
+			if !found || len(l) < len(best) {
+				best, found = l, true
+			}
+		}
+		if !found {
+			return nil, false
+		}
+		lists = append(lists, best)
+	}
+	if len(lists) == 1 {
+		return lists[0], true
+	}
+
+	// ORの各項の候補を合わせ、元の順序に並べる
+	seen := make(map[int]struct{})
+	var ids []int
+	for _, l := range lists {
+		for _, i := range l {
+			if _, ok := seen[i]; !ok {
+				seen[i] = struct{}{}
+				ids = append(ids, i)
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids, true
 }
 
 type RoomCache struct {
 	sync.Mutex
 	db      *sqlx.DB
 	expire  time.Duration
+	clock   common.Clock
 	queries map[string]map[uint32]*roomCacheQuery
+
+	// indexedProps : app毎の索引を作る公開プロパティのキー
+	indexedProps map[string][]string
 }
 
-func NewRoomCache(db *sqlx.DB, expire time.Duration) *RoomCache {
+func NewRoomCache(db *sqlx.DB, expire time.Duration, indexedProps map[string][]string) *RoomCache {
 	return &RoomCache{
-		db:      db,
-		expire:  expire,
-		queries: make(map[string]map[uint32]*roomCacheQuery),
+		db:           db,
+		expire:       expire,
+		clock:        common.RealClock,
+		queries:      make(map[string]map[uint32]*roomCacheQuery),
+		indexedProps: indexedProps,
 	}
 }
 
-func (c *RoomCache) GetRooms(ctx context.Context, appId string, searchGroup uint32) ([]*pb.RoomInfo, []binary.Dict, error) {
+// GetRooms : 検索グループの部屋とそのpropsを返す.
+// 索引のあるキーについての等値検索がqueriesに含まれていれば、マッチし得る部屋に絞り込む.
+func (c *RoomCache) GetRooms(ctx context.Context, appId string, searchGroup uint32, queries []PropQueries) ([]*pb.RoomInfo, []binary.Dict, error) {
 	c.Lock()
 	q := c.queries[appId][searchGroup]
 	if q == nil {
@@ -94,9 +214,25 @@ func (c *RoomCache) GetRooms(ctx context.Context, appId string, searchGroup uint
 			c.queries[appId] = make(map[uint32]*roomCacheQuery)
 		}
 		q = newRoomCacheQuery(c.db, c.expire, "SELECT * FROM room WHERE app_id = ? AND search_group = ? AND visible = 1 LIMIT 1000", appId, searchGroup)
+		q.clock = c.clock
+		q.indexKeys = c.indexedProps[appId]
 		c.queries[appId][searchGroup] = q
 	}
 	c.Unlock()
 
-	return q.do(ctx)
+	rooms, props, index, err := q.do(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids, ok := index.candidates(queries)
+	if !ok {
+		return rooms, props, nil
+	}
+	crooms := make([]*pb.RoomInfo, len(ids))
+	cprops := make([]binary.Dict, len(ids))
+	for i, id := range ids {
+		crooms[i] = rooms[id]
+		cprops[i] = props[id]
+	}
+	return crooms, cprops, nil
 }
@@ -0,0 +1,120 @@
+package lobby
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"wsnet2/binary"
+	"wsnet2/pb"
+)
+
+func TestUnmarshalRoomProps(t *testing.T) {
+	rooms := []*pb.RoomInfo{
+		{Id: "room1", PublicProps: binary.MarshalDict(binary.Dict{"a": binary.MarshalInt(1)})},
+		{Id: "room2", PublicProps: binary.MarshalDict(binary.Dict{"a": binary.MarshalInt(2)})},
+		{Id: "room3", PublicProps: []byte{0xff}},
+	}
+	props, cache, errs := unmarshalRoomProps(rooms, nil)
+	if len(errs) != 1 {
+		t.Fatalf("errs = %v, wants 1 error", errs)
+	}
+	if len(props) != len(rooms) {
+		t.Fatalf("len(props) = %v, wants %v", len(props), len(rooms))
+	}
+	if len(props[2]) != 0 {
+		t.Fatalf("props of broken room = %v, wants empty", props[2])
+	}
+	if _, ok := cache["room3"]; ok {
+		t.Fatalf("broken props must not be cached")
+	}
+
+	// room1は変更なし, room2は変更あり, room3は消えた
+	next := []*pb.RoomInfo{
+		{Id: "room1", PublicProps: binary.MarshalDict(binary.Dict{"a": binary.MarshalInt(1)})},
+		{Id: "room2", PublicProps: binary.MarshalDict(binary.Dict{"a": binary.MarshalInt(3)})},
+	}
+	props2, cache2, errs := unmarshalRoomProps(next, cache)
+	if len(errs) != 0 {
+		t.Fatalf("errs = %v, wants no error", errs)
+	}
+	if len(cache2) != 2 {
+		t.Fatalf("len(cache) = %v, wants 2", len(cache2))
+	}
+	if &props2[0]["a"][0] != &props[0]["a"][0] {
+		t.Fatalf("props of unchanged room must be reused")
+	}
+	if v, _, _ := binary.Unmarshal(props2[1]["a"]); v != 3 {
+		t.Fatalf("props of changed room = %v, wants 3", v)
+	}
+}
+
+func TestPropIndexCandidates(t *testing.T) {
+	props := []binary.Dict{
+		{"mode": binary.MarshalInt(1), "stage": binary.MarshalStr8("a")},
+		{"mode": binary.MarshalInt(2), "stage": binary.MarshalStr8("a")},
+		{"mode": binary.MarshalInt(1), "stage": binary.MarshalStr8("b")},
+		{"stage": binary.MarshalStr8("b")},
+		{"mode": binary.MarshalInt(1), "stage": binary.MarshalStr8("a")},
+	}
+	idx := newPropIndex(props, []string{"mode", "stage"})
+
+	eq := func(k string, v []byte) PropQuery { return PropQuery{Key: k, Op: OpEqual, Val: v} }
+
+	tests := map[string]struct {
+		queries []PropQueries
+		want    []int
+		ok      bool
+	}{
+		"no query": {nil, nil, false},
+		"single": {
+			[]PropQueries{{eq("mode", binary.MarshalInt(1))}},
+			[]int{0, 2, 4}, true,
+		},
+		"smallest in and": {
+			[]PropQueries{{eq("mode", binary.MarshalInt(1)), eq("stage", binary.MarshalStr8("b"))}},
+			[]int{2, 3}, true,
+		},
+		"no match": {
+			[]PropQueries{{eq("mode", binary.MarshalInt(3))}},
+			nil, true,
+		},
+		"or": {
+			[]PropQueries{
+				{eq("mode", binary.MarshalInt(2))},
+				{eq("stage", binary.MarshalStr8("b"))},
+				{eq("mode", binary.MarshalInt(2))},
+			},
+			[]int{1, 2, 3}, true,
+		},
+		"not indexed key": {
+			[]PropQueries{{eq("other", binary.MarshalInt(1))}},
+			nil, false,
+		},
+		"not equal op": {
+			[]PropQueries{{{Key: "mode", Op: OpNot, Val: binary.MarshalInt(1)}}},
+			nil, false,
+		},
+		"or with unindexed": {
+			[]PropQueries{
+				{eq("mode", binary.MarshalInt(2))},
+				{},
+			},
+			nil, false,
+		},
+	}
+	for name, test := range tests {
+		got, ok := idx.candidates(test.queries)
+		if ok != test.ok {
+			t.Fatalf("%v: ok = %v, wants %v", name, ok, test.ok)
+		}
+		if diff := cmp.Diff(got, test.want, cmpopts.EquateEmpty()); diff != "" {
+			t.Fatalf("%v: candidates (-got +want)\n%s", name, diff)
+		}
+	}
+
+	if idx := newPropIndex(props, nil); idx != nil {
+		t.Fatalf("index without keys = %v, wants nil", idx)
+	}
+}
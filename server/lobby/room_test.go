@@ -0,0 +1,185 @@
+package lobby
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+	"wsnet2/common"
+	"wsnet2/config"
+	"wsnet2/pb"
+)
+
+func TestSearchParamGroups(t *testing.T) {
+	p := SearchParam{SearchGroup: 3}
+	if diff := cmp.Diff(p.Groups(), []uint32{3}); diff != "" {
+		t.Fatalf("Groups (-got +want)\n%s", diff)
+	}
+	p.SearchGroups = []uint32{1, 2}
+	if diff := cmp.Diff(p.Groups(), []uint32{1, 2}); diff != "" {
+		t.Fatalf("Groups (-got +want)\n%s", diff)
+	}
+}
+
+func TestMergeRooms(t *testing.T) {
+	r1 := &pb.RoomInfo{Id: "room1"}
+	r2 := &pb.RoomInfo{Id: "room2"}
+	r3 := &pb.RoomInfo{Id: "room3"}
+
+	seen := make(map[string]struct{})
+	found := mergeRooms([]*pb.RoomInfo{}, seen, []*pb.RoomInfo{r1, r2})
+	found = mergeRooms(found, seen, []*pb.RoomInfo{r2, r3, r1})
+
+	var ids []string
+	for _, r := range found {
+		ids = append(ids, r.Id)
+	}
+	if diff := cmp.Diff(ids, []string{"room1", "room2", "room3"}); diff != "" {
+		t.Fatalf("mergeRooms (-got +want)\n%s", diff)
+	}
+}
+
+func TestSortWatchable(t *testing.T) {
+	rooms := []*pb.RoomInfo{
+		{Id: "a", Players: 2, Watchers: 10},
+		{Id: "b", Players: 4, Watchers: 10},
+		{Id: "c", Players: 3, Watchers: 30},
+		{Id: "d", Players: 4, Watchers: 0},
+	}
+	tests := map[WatchableSortKey][]string{
+		SortByWatchers: {"c", "b", "a", "d"},
+		SortByPlayers:  {"b", "d", "c", "a"},
+	}
+	for key, want := range tests {
+		sortWatchable(rooms, key)
+		var ids []string
+		for _, r := range rooms {
+			ids = append(ids, r.Id)
+		}
+		if diff := cmp.Diff(ids, want); diff != "" {
+			t.Fatalf("sortWatchable(%v) (-got +want)\n%s", key, diff)
+		}
+	}
+}
+
+func TestExcludeDownHosts(t *testing.T) {
+	gc := newGameCache(nil, time.Hour, time.Hour)
+	gc.lastUpdated = time.Now()
+	gc.down[2] = &gameServer{hostInfo: hostInfo{Id: 2}}
+	rs := &RoomService{
+		conf:      &config.LobbyConf{GameDownRetryAfter: config.Duration(5 * time.Second)},
+		gameCache: gc,
+	}
+
+	rooms := []*pb.RoomInfo{
+		{Id: "a", HostId: 1},
+		{Id: "b", HostId: 2},
+		{Id: "c", HostId: 3},
+	}
+	props := []binary.Dict{{"k": nil}, {"k": nil}, {"k": nil}}
+
+	alive, aliveProps := rs.excludeDownHosts(rooms, props)
+	var ids []string
+	for _, r := range alive {
+		ids = append(ids, r.Id)
+	}
+	if diff := cmp.Diff(ids, []string{"a", "c"}); diff != "" {
+		t.Fatalf("excludeDownHosts (-got +want)\n%s", diff)
+	}
+	if len(aliveProps) != 2 {
+		t.Fatalf("len(props) = %v, wants 2", len(aliveProps))
+	}
+	if rooms[1].Id != "b" {
+		t.Fatalf("rooms must not be modified: %v", rooms)
+	}
+
+	_, err := rs.join(context.Background(), "app", "b", nil, "", 2)
+	if e, ok := err.(ErrorWithType); !ok || e.ErrType() != ErrGameServerDown {
+		t.Fatalf("join to down host: %v", err)
+	}
+	if d, ok := RetryAfter(err); !ok || d != 5*time.Second {
+		t.Fatalf("RetryAfter = %v, %v, wants 5s", d, ok)
+	}
+}
+
+func TestHostStatus(t *testing.T) {
+	tests := []struct {
+		status int32
+		down   bool
+		want   string
+	}{
+		{common.HostStatusStarting, false, "starting"},
+		{common.HostStatusRunning, false, "running"},
+		{common.HostStatusClosing, false, "closing"},
+		{common.HostStatusRunning, true, "down"},
+		{9, false, "unknown(9)"},
+	}
+	for _, tc := range tests {
+		if got := hostStatus(tc.status, tc.down); got != tc.want {
+			t.Errorf("hostStatus(%v, %v) = %q, wants %q", tc.status, tc.down, got, tc.want)
+		}
+	}
+}
+
+func TestMergeHeavyRooms(t *testing.T) {
+	host1 := []*pb.RoomUsage{{RoomId: "a", EvbufBytes: 500}, {RoomId: "b", PropsBytes: 100}}
+	host2 := []*pb.RoomUsage{{RoomId: "c", HistoryBytes: 300}, {RoomId: "d", EvbufBytes: 10}}
+
+	var total []*pb.RoomUsage
+	total = mergeHeavyRooms(total, host1)
+	total = mergeHeavyRooms(total, host2)
+
+	ids := []string{}
+	for _, u := range total {
+		ids = append(ids, u.RoomId)
+	}
+	if diff := cmp.Diff([]string{"a", "c"}, ids); diff != "" {
+		t.Fatalf("heavy rooms (-want +got):\n%s", diff)
+	}
+}
+
+func TestResolveNumberError(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock error: %+v", err)
+	}
+	rs := &RoomService{
+		db: sqlx.NewDb(db, "mysql"),
+		apps: &appCache{
+			expire:      time.Hour,
+			clock:       common.RealClock,
+			apps:        map[string]*appEntry{"app1": {Id: "app1"}},
+			lastUpdated: time.Now(),
+		},
+	}
+	query := regexp.QuoteMeta("SELECT * FROM room WHERE app_id = ? AND number = ?")
+
+	mock.ExpectQuery(query).WithArgs("app1", 10).WillReturnError(sql.ErrNoRows)
+	_, err = rs.ResolveNumber(ctx, "app1", 10, logger)
+	if et, ok := err.(ErrorWithType); !ok || et.ErrType() != ErrRoomNotFound {
+		t.Fatalf("ResolveNumber error = %v, wants ErrRoomNotFound", err)
+	}
+
+	// DBの障害はroom not foundにしない
+	mock.ExpectQuery(query).WithArgs("app1", 10).WillReturnError(xerrors.New("connection refused"))
+	_, err = rs.ResolveNumber(ctx, "app1", 10, logger)
+	if err == nil {
+		t.Fatalf("ResolveNumber must return the db error")
+	}
+	if et, ok := err.(ErrorWithType); ok && et.ErrType() == ErrRoomNotFound {
+		t.Fatalf("db error must not be ErrRoomNotFound: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,193 @@
+package lobby
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/xerrors"
+
+	"wsnet2/log"
+	"wsnet2/pb"
+)
+
+// 非同期の部屋作成:
+// gameサーバの応答が遅いとクライアントがタイムアウトしてしまうので、CreateAsyncはチケットを発行してすぐに返し、部屋はバックグラウンドで作る.
+// 結果はroom_ticketテーブルに書くので、クライアントはどのlobbyからでもPollTicketで受け取れる.
+// 作成中のlobbyが落ちたチケットは、async_create_timeoutを過ぎたら失敗として扱う.
+
+const (
+	lenTicketId = 16
+
+	// ticketPollInterval : PollTicketで結果を待つときにDBを確認する間隔
+	ticketPollInterval = 200 * time.Millisecond
+	// ticketRetention : 作成の期限を過ぎてからチケットを残しておく期間
+	ticketRetention = time.Minute
+	// ticketWriteTimeout : 結果を書き込むときのタイムアウト
+	ticketWriteTimeout = 5 * time.Second
+)
+
+const (
+	ticketPending = 0
+	ticketDone    = 1
+)
+
+type roomTicket struct {
+	Id      string         `db:"id"`
+	AppId   string         `db:"app_id"`
+	UserId  string         `db:"user_id"`
+	Status  int            `db:"status"`
+	Room    []byte         `db:"room"`
+	ErrType ErrType        `db:"err_type"`
+	ErrMsg  sql.NullString `db:"err_msg"`
+	Created time.Time      `db:"created"`
+}
+
+func newTicketId() string {
+	buf := make([]byte, lenTicketId)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// CreateAsync : 部屋の作成を受け付けてチケットを返す. 部屋はバックグラウンドで作る.
+func (rs *RoomService) CreateAsync(ctx context.Context, appId, userId string, roomOption *pb.RoomOption, clientInfo *pb.ClientInfo, macKey string, latencies Latencies, logger log.Logger) (*RoomTicket, error) {
+	if _, found := rs.apps.Get(appId); !found {
+		return nil, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+
+	timeout := time.Duration(rs.conf.AsyncCreateTimeout)
+	now := time.Now()
+	id := newTicketId()
+	_, err := rs.db.ExecContext(ctx,
+		"INSERT INTO room_ticket (id, app_id, user_id, status, created) VALUES (?, ?, ?, ?, ?)",
+		id, appId, userId, ticketPending, now)
+	if err != nil {
+		return nil, xerrors.Errorf("insert room_ticket: %w", err)
+	}
+
+	// 期限切れのチケットを片付ける
+	_, err = rs.db.ExecContext(ctx, "DELETE FROM room_ticket WHERE created < ?", now.Add(-timeout-ticketRetention))
+	if err != nil {
+		logger.Errorf("delete expired room_ticket: %+v", err)
+	}
+
+	go rs.createTicketRoom(id, appId, roomOption, clientInfo, macKey, latencies, timeout, logger)
+
+	return &RoomTicket{
+		Id:     id,
+		Expire: now.Add(timeout + ticketRetention).Unix(),
+	}, nil
+}
+
+func (rs *RoomService) createTicketRoom(id, appId string, roomOption *pb.RoomOption, clientInfo *pb.ClientInfo, macKey string, latencies Latencies, timeout time.Duration, logger log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var room []byte
+	var errType ErrType
+	var errMsg sql.NullString
+
+	res, err := rs.Create(ctx, appId, roomOption, clientInfo, macKey, latencies)
+	if err == nil {
+		room, err = marshalTicketRoom(res)
+	}
+	if err != nil {
+		logger.Infof("async create failed: ticket=%v: %+v", id, err)
+		errType, errMsg = ticketError(err)
+	} else {
+		logger.Infof("async create: ticket=%v room=%v", id, res.RoomInfo.Id)
+	}
+
+	// 作成でctxを使い切っていても書き込めるようにする
+	wctx, wcancel := context.WithTimeout(context.Background(), ticketWriteTimeout)
+	defer wcancel()
+	_, err = rs.db.ExecContext(wctx,
+		"UPDATE room_ticket SET status = ?, room = ?, err_type = ?, err_msg = ? WHERE id = ?",
+		ticketDone, room, errType, errMsg, id)
+	if err != nil {
+		logger.Errorf("update room_ticket (%v): %+v", id, err)
+	}
+}
+
+// ticketError : チケットに保存するエラーの種類とメッセージ
+func ticketError(err error) (ErrType, sql.NullString) {
+	if e, ok := err.(*errorWithType); ok {
+		return e.errType, sql.NullString{String: e.error.Error(), Valid: true}
+	}
+	return 0, sql.NullString{String: err.Error(), Valid: true}
+}
+
+func marshalTicketRoom(res *pb.JoinedRoomRes) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(res); err != nil {
+		return nil, xerrors.Errorf("marshal JoinedRoomRes: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func unmarshalTicketRoom(data []byte) (*pb.JoinedRoomRes, error) {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	var res pb.JoinedRoomRes
+	if err := dec.Decode(&res); err != nil {
+		return nil, xerrors.Errorf("unmarshal JoinedRoomRes: %w", err)
+	}
+	return &res, nil
+}
+
+// PollTicket : チケットの部屋の作成結果を返す.
+// 作成中ならwaitの間は完了を待ち、それでも終わらなければ nil, nil を返す.
+func (rs *RoomService) PollTicket(ctx context.Context, appId, userId, ticketId string, wait time.Duration) (*pb.JoinedRoomRes, error) {
+	timeout := time.Duration(rs.conf.AsyncCreateTimeout)
+	until := time.Now().Add(wait)
+	for {
+		var t roomTicket
+		err := rs.db.GetContext(ctx, &t, "SELECT * FROM room_ticket WHERE id = ? AND app_id = ?", ticketId, appId)
+		if err != nil {
+			if xerrors.Is(err, sql.ErrNoRows) {
+				return nil, withType(xerrors.Errorf("ticket not found: %v", ticketId), ErrArgument)
+			}
+			return nil, xerrors.Errorf("select room_ticket: %w", err)
+		}
+		if t.UserId != userId {
+			return nil, withType(xerrors.Errorf("ticket %v is not owned by %v", ticketId, userId), ErrArgument)
+		}
+
+		if t.Status == ticketDone {
+			return rs.ticketResult(&t)
+		}
+		now := time.Now()
+		if now.After(t.Created.Add(timeout + ticketWriteTimeout)) {
+			return nil, xerrors.Errorf("async create timed out: ticket=%v", ticketId)
+		}
+		if !now.Add(ticketPollInterval).Before(until) {
+			return nil, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-time.After(ticketPollInterval):
+		}
+	}
+}
+
+func (rs *RoomService) ticketResult(t *roomTicket) (*pb.JoinedRoomRes, error) {
+	if t.Room != nil {
+		return unmarshalTicketRoom(t.Room)
+	}
+	err := xerrors.Errorf("async create: %s", t.ErrMsg.String)
+	switch t.ErrType {
+	case 0:
+		return nil, err
+	case ErrGameServerDown:
+		return nil, &errorWithType{err, t.ErrType, time.Duration(rs.conf.GameDownRetryAfter)}
+	}
+	return nil, withType(err, t.ErrType)
+}
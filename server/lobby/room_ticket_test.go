@@ -0,0 +1,107 @@
+package lobby
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/xerrors"
+
+	"wsnet2/config"
+	"wsnet2/pb"
+)
+
+func TestTicketError(t *testing.T) {
+	errType, msg := ticketError(withType(xerrors.Errorf("too many rooms"), ErrRoomLimit))
+	if errType != ErrRoomLimit || msg.String != "too many rooms" {
+		t.Fatalf("ticketError = %v, %q", errType, msg.String)
+	}
+	errType, msg = ticketError(xerrors.Errorf("grpc error"))
+	if errType != 0 || msg.String != "grpc error" {
+		t.Fatalf("ticketError = %v, %q", errType, msg.String)
+	}
+}
+
+func TestPollTicket(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock error: %+v", err)
+	}
+	rs := &RoomService{
+		db: sqlx.NewDb(db, "mysql"),
+		conf: &config.LobbyConf{
+			AsyncCreateTimeout: config.Duration(30 * time.Second),
+			GameDownRetryAfter: config.Duration(5 * time.Second),
+		},
+	}
+
+	room, err := marshalTicketRoom(&pb.JoinedRoomRes{
+		RoomInfo: &pb.RoomInfo{Id: "room1"},
+		Url:      "ws://localhost/room/room1",
+		MasterId: "user1",
+	})
+	if err != nil {
+		t.Fatalf("marshalTicketRoom: %+v", err)
+	}
+
+	query := regexp.QuoteMeta("SELECT * FROM room_ticket WHERE id = ? AND app_id = ?")
+	cols := []string{"id", "app_id", "user_id", "status", "room", "err_type", "err_msg", "created"}
+	expect := func(status int, room []byte, errType ErrType, errMsg interface{}, created time.Time) {
+		mock.ExpectQuery(query).WithArgs("ticket1", "app1").WillReturnRows(
+			sqlmock.NewRows(cols).AddRow("ticket1", "app1", "user1", status, room, errType, errMsg, created))
+	}
+	now := time.Now()
+
+	// 作成中
+	expect(ticketPending, nil, 0, nil, now)
+	res, err := rs.PollTicket(ctx, "app1", "user1", "ticket1", 0)
+	if res != nil || err != nil {
+		t.Fatalf("pending: res=%v, err=%v", res, err)
+	}
+
+	// 待っている間に完了
+	expect(ticketPending, nil, 0, nil, now)
+	expect(ticketDone, room, 0, nil, now)
+	res, err = rs.PollTicket(ctx, "app1", "user1", "ticket1", time.Second)
+	if err != nil {
+		t.Fatalf("done: %+v", err)
+	}
+	if res.RoomInfo.Id != "room1" || res.MasterId != "user1" || res.Url != "ws://localhost/room/room1" {
+		t.Fatalf("done: res=%v", res)
+	}
+
+	// 作成に失敗
+	expect(ticketDone, nil, ErrGameServerDown, "host 1 is down", now)
+	_, err = rs.PollTicket(ctx, "app1", "user1", "ticket1", 0)
+	if e, ok := err.(ErrorWithType); !ok || e.ErrType() != ErrGameServerDown {
+		t.Fatalf("failed: err=%v", err)
+	}
+	if d, ok := RetryAfter(err); !ok || d != 5*time.Second {
+		t.Fatalf("RetryAfter = %v, %v", d, ok)
+	}
+
+	// 作成中のまま期限切れ
+	expect(ticketPending, nil, 0, nil, now.Add(-time.Minute))
+	res, err = rs.PollTicket(ctx, "app1", "user1", "ticket1", 0)
+	if res != nil || err == nil {
+		t.Fatalf("expired: res=%v, err=%v", res, err)
+	}
+	if _, ok := err.(ErrorWithType); ok {
+		t.Fatalf("expired: err must not have type: %v", err)
+	}
+
+	// 他のユーザのチケット
+	expect(ticketDone, room, 0, nil, now)
+	_, err = rs.PollTicket(ctx, "app1", "user2", "ticket1", 0)
+	if e, ok := err.(ErrorWithType); !ok || e.ErrType() != ErrArgument {
+		t.Fatalf("other user: err=%v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
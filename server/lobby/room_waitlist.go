@@ -0,0 +1,82 @@
+package lobby
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/pb"
+)
+
+// 満室の部屋の順番待ち (see: game/room_waitlist.go):
+// クライアントはWaitSeatを繰り返し呼んで順番を問い合わせる. 問い合わせが途絶えると列から外れる.
+// waitを指定すると、順番が変わるか席が確保されるまでその間待ってから返す.
+// 席が確保されたら (Positionが0) 期限までに通常のJoinで入室する.
+
+// seatWaitPollInterval : WaitSeatで順番が変わるのを待つときにgameサーバに問い合わせる間隔
+const seatWaitPollInterval = 500 * time.Millisecond
+
+// WaitSeat : 満室の部屋の順番待ちの列に並び (並んでいれば問い合わせ)、順番を返す. leaveなら列から外れる.
+// roomIdが空ならroomNumberで部屋を探す.
+func (rs *RoomService) WaitSeat(ctx context.Context, appId, roomId string, roomNumber int32, userId string, leave bool, wait time.Duration) (*SeatWaiting, error) {
+	if _, found := rs.apps.Get(appId); !found {
+		return nil, xerrors.Errorf("Unknown appId: %v", appId)
+	}
+
+	var room pb.RoomInfo
+	var err error
+	if roomId != "" {
+		err = rs.db.GetContext(ctx, &room, "SELECT * FROM room WHERE app_id = ? AND id = ?", appId, roomId)
+	} else {
+		err = rs.db.GetContext(ctx, &room, "SELECT * FROM room WHERE app_id = ? AND number = ?", appId, roomNumber)
+	}
+	if err != nil {
+		return nil, withType(
+			xerrors.Errorf("select room (id=%v, num=%v): %w", roomId, roomNumber, err),
+			ErrRoomNotFound)
+	}
+
+	game, err := rs.gameCache.Get(room.HostId)
+	if err != nil {
+		return nil, rs.gameServerError(err, room.HostId)
+	}
+	grpcAddr := fmt.Sprintf("%s:%d", game.Hostname, game.GRPCPort)
+	conn, err := rs.grpcPool.Get(grpcAddr)
+	if err != nil {
+		return nil, xerrors.Errorf("grpcPool.Get(%s): %w", grpcAddr, err)
+	}
+	client := pb.NewGameClient(conn)
+
+	req := &pb.WaitSeatReq{
+		AppId:    appId,
+		RoomId:   room.Id,
+		ClientId: userId,
+		Leave:    leave,
+	}
+	until := time.Now().Add(wait)
+	var first uint32
+	for n := 0; ; n++ {
+		res, err := client.WaitSeat(ctx, req)
+		if err != nil {
+			return nil, joinError("WaitSeat", err)
+		}
+		if n == 0 {
+			first = res.Position
+		}
+		if leave || res.Position == 0 || res.Position != first || !time.Now().Add(seatWaitPollInterval).Before(until) {
+			return &SeatWaiting{
+				RoomId:   room.Id,
+				Position: res.Position,
+				Expire:   res.Expire,
+			}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, xerrors.Errorf("WaitSeat: %w", ctx.Err())
+		case <-time.After(seatWaitPollInterval):
+		}
+	}
+}
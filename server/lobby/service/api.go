@@ -18,9 +18,11 @@ import (
 	"golang.org/x/xerrors"
 
 	"wsnet2/auth"
+	"wsnet2/binary"
 	"wsnet2/lobby"
 	"wsnet2/log"
 	"wsnet2/pb"
+	"wsnet2/requestid"
 )
 
 func msgpackDecode(r io.Reader, out interface{}) error {
@@ -61,10 +63,7 @@ func (sv *LobbyService) serveAPI(ctx context.Context) <-chan error {
 			}
 		}
 
-		r := chi.NewMux()
-		sv.registerRoutes(r)
-
-		errCh <- http.Serve(listener, r)
+		errCh <- http.Serve(listener, sv.Handler())
 	}()
 
 	return errCh
@@ -75,20 +74,67 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("wsnet2 works\n"))
 }
 
-func (sv *LobbyService) registerRoutes(r chi.Router) {
+// handleErrors : サーバが送りうるエラーコードと切断理由の一覧. 認証は不要
+func handleErrors(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(binary.NewRegistry())
+	if err != nil {
+		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// Handler : Lobby APIのhttp.Handler. middlewaresは全てのAPIに、リクエストIDを設定した後に適用する.
+// 既存のサーバに組み込むときは、chiのMountやhttp.StripPrefixで任意のパスの下に置ける.
+func (sv *LobbyService) Handler(middlewares ...func(http.Handler) http.Handler) http.Handler {
+	r := chi.NewMux()
+	r.Use(requestid.Middleware)
+	r.Use(middlewares...)
+	sv.RegisterRoutes(r)
+	return r
+}
+
+// RegisterRoutes : Lobby APIをrに登録する
+func (sv *LobbyService) RegisterRoutes(r chi.Router) {
 	r.Get("/health", handleHealth)
 	r.Get("/health/", handleHealth)
+	r.Get("/errors", handleErrors)
 
 	r.Post("/rooms", sv.handleCreateRoom)
+	r.Post("/rooms/async", sv.handleCreateRoomAsync)
+	r.Post("/rooms/async/{ticketId:[0-9a-f]+}", sv.handlePollRoomTicket)
 	r.Post("/rooms/join/id/{roomId}", sv.handleJoinRoom)
 	r.Post("/rooms/join/number/{roomNumber:[0-9]+}", sv.handleJoinRoomByNumber)
 	r.Post("/rooms/join/random/{searchGroup:[0-9]+}", sv.handleJoinRoomAtRandom)
+	r.Post("/rooms/join/invite", sv.handleJoinRoomByInvite)
+	r.Post("/rooms/wait/id/{roomId}", sv.handleWaitSeat)
+	r.Post("/rooms/wait/number/{roomNumber:[0-9]+}", sv.handleWaitSeat)
+	r.Post("/rooms/invite/{roomId}", sv.handleInviteRoom)
 	r.Post("/rooms/search", sv.handleSearchRooms)
 	r.Post("/rooms/search/ids", sv.handleSearchByIds)
 	r.Post("/rooms/search/numbers", sv.handleSearchByNumbers)
+	r.Post("/rooms/search/watchable", sv.handleSearchWatchable)
 	r.Post("/rooms/watch/id/{roomId}", sv.handleWatchRoom)
 	r.Post("/rooms/watch/number/{roomNumber:[0-9]+}", sv.handleWatchRoomByNumber)
+	r.Get("/rooms/resolve/number/{roomNumber:[0-9]+}", sv.handleResolveRoomNumber)
+	r.Post("/probes", sv.handleProbes)
 	r.Post("/_admin/kick", sv.handleAdminKick)
+	r.Post("/_admin/message", sv.handleAdminMessage)
+	r.Post("/_admin/push", sv.handleServerMessage)
+	r.Post("/_admin/channels", sv.handleCreateChannel)
+	r.Post("/_admin/rooms", sv.handleAdminRooms)
+	r.Post("/_admin/stats", sv.handleAdminStats)
+	r.Post("/_admin/hosts", sv.handleAdminHosts)
+	r.Post("/_admin/erase", sv.handleAdminErase)
+	r.Post("/_admin/feature_flags", sv.handleAdminFeatureFlags)
+	r.Post("/_admin/apps", sv.handleAdminCreateApp)
+	r.Post("/_admin/apps/{appId}/rotate", sv.handleAdminRotateAppKey)
+	r.Post("/_admin/apps/{appId}/{op:disable|enable}", sv.handleAdminDisableApp)
+
+	if sv.conf.WebsocketProxy != "" {
+		r.Get("/ws/{kind:game|hub}/{hostId:[0-9]+}/*", sv.handleWebsocketProxy)
+	}
 }
 
 type header struct {
@@ -125,7 +171,8 @@ func prepareLogger(handler string, hdr header, r *http.Request) log.Logger {
 		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
 		log.KeyApp, hdr.appId,
 		log.KeyClient, hdr.userId,
-		log.KeyRemoteAddr, raddr)
+		log.KeyRemoteAddr, raddr,
+		log.KeyRequestId, requestid.FromContext(r.Context()))
 	if err != nil {
 		l.Errorf("SplitHostPort: %v", err)
 	}
@@ -170,39 +217,67 @@ func renderErrorResponse(w http.ResponseWriter, msg string, status int, err erro
 		if m := e.Message(); m != "" {
 			msg = m
 		}
+		code := e.ErrType().Code()
 		switch e.ErrType() {
 		case lobby.ErrArgument:
 			status = http.StatusBadRequest
 		case lobby.ErrRoomLimit:
 			logger.Infof("Failed with status OK: %+v", err)
-			renderResponse(w, &lobby.Response{Msg: msg, Type: lobby.ResponseTypeRoomLimit}, logger)
+			renderResponse(w, &lobby.Response{Msg: msg, Type: lobby.ResponseTypeRoomLimit, Code: code}, logger)
 			return
 		case lobby.ErrAlreadyJoined:
 			status = http.StatusConflict
+		case lobby.ErrAppNotFound:
+			status = http.StatusNotFound
+		case lobby.ErrRateLimited:
+			status = http.StatusTooManyRequests
 		case lobby.ErrRoomFull:
 			logger.Infof("Failed with status OK: %+v", err)
-			renderResponse(w, &lobby.Response{Msg: msg, Type: lobby.ResponseTypeRoomFull}, logger)
+			renderResponse(w, &lobby.Response{Msg: msg, Type: lobby.ResponseTypeRoomFull, Code: code}, logger)
 			return
-		case lobby.ErrNoJoinableRoom, lobby.ErrNoWatchableRoom:
+		case lobby.ErrNoJoinableRoom, lobby.ErrNoWatchableRoom, lobby.ErrRoomNotFound, lobby.ErrBanned:
 			logger.Infof("Failed with status OK: %+v", err)
-			renderResponse(w, &lobby.Response{Msg: msg, Type: lobby.ResponseTypeNoRoomFound}, logger)
+			renderResponse(w, &lobby.Response{Msg: msg, Type: lobby.ResponseTypeNoRoomFound, Code: code}, logger)
 			return
+		case lobby.ErrGameServerDown:
+			status = http.StatusServiceUnavailable
+			if d, ok := lobby.RetryAfter(err); ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int((d+time.Second-1)/time.Second)))
+			}
 		}
+		w.Header().Set(binary.ErrorCodeHeader, strconv.Itoa(int(code)))
 	}
 	logger.Errorf("ErrorResponse: %d %s: %+v", status, logmsg, err)
 	http.Error(w, msg, status)
 }
 
+// authUser : 認証データを検証して、検証できたapp keyを返す.
+// keyの更新後の猶予期間は古いkeyでも認証でき、MACKeyの復号にも同じkeyを使う.
 func (sv *LobbyService) authUser(h header) (string, error) {
-	appKey, found := sv.roomService.GetAppKey(h.appId)
+	appKeys, found := sv.roomService.GetAppKeys(h.appId)
 	if !found {
 		return "", xerrors.Errorf("Invalid appId: %v", h.appId)
 	}
 	expired := time.Now().Add(-time.Duration(sv.conf.AuthDataExpire))
-	if err := auth.ValidAuthData(h.authData, appKey, h.userId, expired); err != nil {
-		return "", xerrors.Errorf("invalid authdata: %w", err)
+	var err error
+	for _, appKey := range appKeys {
+		if err = auth.ValidAuthData(h.authData, appKey, h.userId, expired); err == nil {
+			return appKey, nil
+		}
+	}
+	return "", xerrors.Errorf("invalid authdata: %w", err)
+}
+
+// authAdmin : app管理APIの認証. 認証データをadmin_keyで検証する
+func (sv *LobbyService) authAdmin(h header) error {
+	if sv.conf.AdminKey == "" {
+		return xerrors.Errorf("admin api is disabled")
+	}
+	expired := time.Now().Add(-time.Duration(sv.conf.AuthDataExpire))
+	if err := auth.ValidAuthData(h.authData, sv.conf.AdminKey, h.userId, expired); err != nil {
+		return xerrors.Errorf("invalid authdata: %w", err)
 	}
-	return appKey, nil
+	return nil
 }
 
 // 部屋を作成する
@@ -235,11 +310,93 @@ func (sv *LobbyService) handleCreateRoom(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	room, err := sv.roomService.Create(ctx, h.appId, param.RoomOption, param.ClientInfo, macKey)
+	room, err := sv.roomService.Create(ctx, h.appId, param.RoomOption, param.ClientInfo, macKey, param.Latencies)
+	if err != nil {
+		renderErrorResponse(w, "Failed to create room", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	renderJoinedRoomResponse(w, room, logger)
+}
+
+// handleCreateRoomAsync : 部屋の作成を受け付けてチケットを返す
+// POST Params: handleCreateRoomと同じ
+// Response: 200 OK (Type: Pending)
+func (sv *LobbyService) handleCreateRoomAsync(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:create_async", h, r)
+	logger.Debugf("handleCreateRoomAsync")
+
+	appKey, err := sv.authUser(h)
+	if err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var param lobby.CreateParam
+	if err := msgpackDecode(r.Body, &param); err != nil {
+		renderErrorResponse(w, "Failed to read request body", http.StatusBadRequest, err, logger)
+		return
+	}
+	macKey, err := auth.DecryptMACKey(appKey, param.EncMACKey)
+	if err != nil {
+		renderErrorResponse(w, "Failed to read MAC Key", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	ticket, err := sv.roomService.CreateAsync(ctx, h.appId, h.userId, param.RoomOption, param.ClientInfo, macKey, param.Latencies, logger)
+	if err != nil {
+		renderErrorResponse(w, "Failed to create room", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	renderResponse(w, &lobby.Response{Msg: "Pending", Type: lobby.ResponseTypePending, Ticket: ticket}, logger)
+}
+
+// handlePollRoomTicket : 非同期の部屋作成の結果を返す.
+// 作成中ならクエリパラメータwait(秒)の間は完了を待つ. api_timeoutより長くは待たない.
+// Response: 200 OK. 作成中ならType: Pending
+func (sv *LobbyService) handlePollRoomTicket(w http.ResponseWriter, r *http.Request) {
+	timeout := time.Duration(sv.conf.ApiTimeout)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:poll_ticket", h, r)
+	ticketId := chi.URLParam(r, "ticketId")
+	logger.Debugf("handlePollRoomTicket: ticket=%v", ticketId)
+
+	if _, err := sv.authUser(h); err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var wait time.Duration
+	if s := r.URL.Query().Get("wait"); s != "" {
+		sec, err := strconv.Atoi(s)
+		if err != nil || sec < 0 {
+			renderErrorResponse(w, "Invalid wait", http.StatusBadRequest, err, logger)
+			return
+		}
+		wait = time.Duration(sec) * time.Second
+	}
+	if wait > timeout-time.Second {
+		wait = timeout - time.Second
+	}
+
+	room, err := sv.roomService.PollTicket(ctx, h.appId, h.userId, ticketId, wait)
 	if err != nil {
 		renderErrorResponse(w, "Failed to create room", http.StatusInternalServerError, err, logger)
 		return
 	}
+	if room == nil {
+		ticket := &lobby.RoomTicket{Id: ticketId}
+		renderResponse(w, &lobby.Response{Msg: "Pending", Type: lobby.ResponseTypePending, Ticket: ticket}, logger)
+		return
+	}
 
 	renderJoinedRoomResponse(w, room, logger)
 }
@@ -314,6 +471,178 @@ func (sv *LobbyService) handleJoinRoom(w http.ResponseWriter, r *http.Request) {
 	logger = logger.With(log.KeyRoom, roomId)
 
 	room, err := sv.roomService.JoinById(ctx, h.appId, roomId, param.Queries, param.ClientInfo, macKey, logger)
+	if err != nil {
+		if param.WaitList && sv.waitSeatOnFull(ctx, w, h, roomId, 0, err, logger) {
+			return
+		}
+		renderErrorResponse(w, "Failed to join room", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	renderJoinedRoomResponse(w, room, logger)
+}
+
+// waitSeatOnFull : 満室で入室できなかったとき、順番待ちの列に並んで順番を返す.
+// 満室でないか、列に並べなければfalseを返すので、元のエラーを返す.
+func (sv *LobbyService) waitSeatOnFull(ctx context.Context, w http.ResponseWriter, h header, roomId string, roomNumber int32, err error, logger log.Logger) bool {
+	if e, ok := err.(lobby.ErrorWithType); !ok || e.ErrType() != lobby.ErrRoomFull {
+		return false
+	}
+	waiting, werr := sv.roomService.WaitSeat(ctx, h.appId, roomId, roomNumber, h.userId, false, 0)
+	if werr != nil {
+		logger.Infof("WaitSeat: %+v", werr)
+		return false
+	}
+	renderSeatWaitingResponse(w, waiting, logger)
+	return true
+}
+
+func renderSeatWaitingResponse(w http.ResponseWriter, waiting *lobby.SeatWaiting, logger log.Logger) {
+	logger = logger.With(log.KeyRoom, waiting.RoomId)
+	logger.Debugf("seat waiting: %v", waiting)
+	if waiting.Position == 0 {
+		renderResponse(w, &lobby.Response{Msg: "Seat reserved", Waiting: waiting}, logger)
+		return
+	}
+	renderResponse(w, &lobby.Response{
+		Msg:     "Room full",
+		Type:    lobby.ResponseTypeRoomFull,
+		Code:    lobby.ErrRoomFull.Code(),
+		Waiting: waiting,
+	}, logger)
+}
+
+// 満室の部屋の順番待ちの列に並び、順番を返す.
+// クエリパラメータwait(秒)の間は順番が変わるのを待つ. api_timeoutより長くは待たない.
+// Method: POST
+// Path: /rooms/wait/id/{roomId}, /rooms/wait/number/{roomNumber}
+// POST Params: {"leave": false}
+// Response: 200 OK. 順番待ちならType: RoomFull
+func (sv *LobbyService) handleWaitSeat(w http.ResponseWriter, r *http.Request) {
+	timeout := time.Duration(sv.conf.ApiTimeout)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:wait", h, r)
+	logger.Debugf("handleWaitSeat")
+
+	if _, err := sv.authUser(h); err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var param lobby.WaitSeatParam
+	if err := msgpackDecode(r.Body, &param); err != nil {
+		renderErrorResponse(w, "Failed to read request body", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	vars := NewJoinVars(r)
+	roomId := vars.roomId()
+	roomNumber := vars.roomNumber()
+	if roomId == "" && roomNumber == 0 {
+		renderErrorResponse(
+			w, "Invalid room", http.StatusBadRequest, xerrors.Errorf("Invalid room id or number"), logger)
+		return
+	}
+
+	var wait time.Duration
+	if s := r.URL.Query().Get("wait"); s != "" {
+		sec, err := strconv.Atoi(s)
+		if err != nil || sec < 0 {
+			renderErrorResponse(w, "Invalid wait", http.StatusBadRequest, err, logger)
+			return
+		}
+		wait = time.Duration(sec) * time.Second
+	}
+	if wait > timeout-time.Second {
+		wait = timeout - time.Second
+	}
+
+	waiting, err := sv.roomService.WaitSeat(ctx, h.appId, roomId, roomNumber, h.userId, param.Leave, wait)
+	if err != nil {
+		renderErrorResponse(w, "Failed to wait seat", http.StatusInternalServerError, err, logger)
+		return
+	}
+	if param.Leave {
+		renderResponse(w, &lobby.Response{Msg: "OK"}, logger)
+		return
+	}
+
+	renderSeatWaitingResponse(w, waiting, logger)
+}
+
+// 部屋への招待トークンを発行する
+// Method: POST
+// Path: /rooms/invite/{roomId}
+// POST Params: {"user": "invitee", "expire": 3600}
+// Response: 200 OK
+func (sv *LobbyService) handleInviteRoom(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:invite", h, r)
+	logger.Debugf("handleInviteRoom")
+
+	if _, err := sv.authUser(h); err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var param lobby.InviteParam
+	if err := msgpackDecode(r.Body, &param); err != nil {
+		renderErrorResponse(w, "Failed to read request body", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	vars := NewJoinVars(r)
+	roomId := vars.roomId()
+	if roomId == "" {
+		renderErrorResponse(
+			w, "Invalid room id", http.StatusBadRequest, xerrors.Errorf("Invalid room id"), logger)
+		return
+	}
+	logger = logger.With(log.KeyRoom, roomId)
+
+	inv, err := sv.roomService.Invite(ctx, h.appId, roomId, h.userId, &param, logger)
+	if err != nil {
+		renderErrorResponse(w, "Failed to invite", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	renderResponse(w, &lobby.Response{Msg: "OK", Invite: inv}, logger)
+}
+
+func (sv *LobbyService) handleJoinRoomByInvite(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:join/invite", h, r)
+	logger.Debugf("handleJoinRoomByInvite")
+
+	appKey, err := sv.authUser(h)
+	if err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var param lobby.JoinByInviteParam
+	err = msgpackDecode(r.Body, &param)
+	if err != nil {
+		renderErrorResponse(w, "Failed to read request body", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	macKey, err := auth.DecryptMACKey(appKey, param.EncMACKey)
+	if err != nil {
+		renderErrorResponse(w, "Failed to read MAC Key", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	room, err := sv.roomService.JoinByInvite(ctx, h.appId, h.userId, param.Token, param.ClientInfo, macKey, logger)
 	if err != nil {
 		renderErrorResponse(w, "Failed to join room", http.StatusInternalServerError, err, logger)
 		return
@@ -360,6 +689,9 @@ func (sv *LobbyService) handleJoinRoomByNumber(w http.ResponseWriter, r *http.Re
 
 	room, err := sv.roomService.JoinByNumber(ctx, h.appId, roomNumber, param.Queries, param.ClientInfo, macKey, logger)
 	if err != nil {
+		if param.WaitList && sv.waitSeatOnFull(ctx, w, h, "", roomNumber, err, logger) {
+			return
+		}
 		renderErrorResponse(w, "Failed to join room", http.StatusInternalServerError, err, logger)
 		return
 	}
@@ -398,7 +730,7 @@ func (sv *LobbyService) handleJoinRoomAtRandom(w http.ResponseWriter, r *http.Re
 	searchGroup := vars.searchGroup()
 	logger = logger.With(log.KeySearchGroup, searchGroup)
 
-	room, err := sv.roomService.JoinAtRandom(ctx, h.appId, searchGroup, param.Queries, param.ClientInfo, macKey, logger)
+	room, err := sv.roomService.JoinAtRandom(ctx, h.appId, searchGroup, param.Queries, param.ClientInfo, macKey, param.Latencies, logger)
 	if err != nil {
 		renderErrorResponse(w, "Failed to join room", http.StatusInternalServerError, err, logger)
 		return
@@ -425,10 +757,17 @@ func (sv *LobbyService) handleSearchRooms(w http.ResponseWriter, r *http.Request
 	}
 
 	logger.Debugf("search param: %#v", param)
-	logger = logger.With(log.KeySearchGroup, param.SearchGroup)
+	groups := param.Groups()
+	logger = logger.With(log.KeySearchGroup, groups)
+
+	if len(groups) > lobby.MaxSearchGroups {
+		renderErrorResponse(w, "Too many search groups", http.StatusBadRequest,
+			xerrors.Errorf("too many search groups: %v", len(groups)), logger)
+		return
+	}
 
 	rooms, err := sv.roomService.Search(r.Context(),
-		h.appId, param.SearchGroup, param.Queries, int(param.Limit), param.CheckJoinable, param.CheckWatchable, logger)
+		h.appId, groups, param.Queries, int(param.Limit), param.CheckJoinable, param.CheckWatchable, logger)
 	if err != nil {
 		renderErrorResponse(w, "Failed to search rooms", http.StatusInternalServerError, err, logger)
 		return
@@ -437,6 +776,42 @@ func (sv *LobbyService) handleSearchRooms(w http.ResponseWriter, r *http.Request
 	renderFoundRoomsResponse(w, rooms, logger)
 }
 
+func (sv *LobbyService) handleSearchWatchable(w http.ResponseWriter, r *http.Request) {
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:search/watchable", h, r)
+	logger.Debugf("handleSearchWatchable")
+
+	if _, err := sv.authUser(h); err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var param lobby.SearchWatchableParam
+	err := msgpackDecode(r.Body, &param)
+	if err != nil {
+		renderErrorResponse(w, "Failed to read request body", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	logger.Debugf("search watchable param: %#v", param)
+	logger = logger.With(log.KeySearchGroup, param.SearchGroups)
+
+	if len(param.SearchGroups) == 0 || len(param.SearchGroups) > lobby.MaxSearchGroups {
+		renderErrorResponse(w, "Invalid search groups", http.StatusBadRequest,
+			xerrors.Errorf("invalid number of search groups: %v", len(param.SearchGroups)), logger)
+		return
+	}
+
+	rooms, err := sv.roomService.SearchWatchable(r.Context(),
+		h.appId, param.SearchGroups, param.Queries, int(param.Limit), param.SortBy, logger)
+	if err != nil {
+		renderErrorResponse(w, "Failed to search watchable rooms", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	renderFoundRoomsResponse(w, rooms, logger)
+}
+
 func (sv *LobbyService) handleSearchByIds(w http.ResponseWriter, r *http.Request) {
 	h := parseSpecificHeader(r)
 	logger := prepareLogger("lobby:search/ids", h, r)
@@ -585,6 +960,62 @@ func (sv *LobbyService) handleWatchRoomByNumber(w http.ResponseWriter, r *http.R
 	renderJoinedRoomResponse(w, room, logger)
 }
 
+// 部屋番号から部屋の所在（gameサーバとwebsocket URL）を返す
+// Method: GET
+// Path: /rooms/resolve/number/{roomNumber}
+// Response: 200 OK
+func (sv *LobbyService) handleResolveRoomNumber(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:resolve/number", h, r)
+	logger.Debugf("handleResolveRoomNumber")
+
+	if _, err := sv.authUser(h); err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	vars := NewJoinVars(r)
+	roomNumber := vars.roomNumber()
+	if roomNumber == 0 {
+		renderErrorResponse(
+			w, "Invalid room number", http.StatusBadRequest, xerrors.Errorf("Invalid room number: 0"), logger)
+		return
+	}
+	logger = logger.With(log.KeyRoomNumber, roomNumber)
+
+	loc, err := sv.roomService.ResolveNumber(ctx, h.appId, roomNumber, logger)
+	if err != nil {
+		renderErrorResponse(w, "Failed to resolve room number", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	renderResponse(w, &lobby.Response{Msg: "OK", Location: loc}, logger)
+}
+
+// RTT計測用のgameサーバのエンドポイント一覧を返す.
+// クライアントは各URLへのRTTを計測し、Create/JoinAtRandomのlatenciesに指定する.
+func (sv *LobbyService) handleProbes(w http.ResponseWriter, r *http.Request) {
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:probes", h, r)
+	logger.Debugf("handleProbes")
+
+	if _, err := sv.authUser(h); err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	probes, err := sv.roomService.Probes(h.appId)
+	if err != nil {
+		renderErrorResponse(w, "Failed to get probes", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	renderResponse(w, &lobby.Response{Msg: "OK", Probes: probes}, logger)
+}
+
 // 対象ユーザーをKickする。ゲームAPIサーバーからリクエストされる。
 // php, Python等からアクセスしやすくするために、msgpackではなくてJSONを使う。
 func (sv *LobbyService) handleAdminKick(w http.ResponseWriter, r *http.Request) {
@@ -621,3 +1052,436 @@ func (sv *LobbyService) handleAdminKick(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{"msg": "ok"}`))
 }
+
+// 全gameサーバーの部屋とプレイヤーの情報を検索する。CSツールやダッシュボードからリクエストされる。
+// AdminKickと同様にJSONを使う。
+func (sv *LobbyService) handleAdminRooms(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:admin/rooms", h, r)
+	if h.appId != h.userId {
+		err := xerrors.Errorf("bad userID: appID=%q userID=%q", h.appId, h.userId)
+		renderErrorResponse(w, "Failed to auth", http.StatusForbidden, err, logger)
+		return
+	}
+
+	_, err := sv.authUser(h)
+	if err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var req lobby.AdminRoomsParam
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		renderErrorResponse(w, "failed to decode JSON request", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	rooms, err := sv.roomService.AdminQueryRooms(ctx, h.appId, req.SearchGroup, req.ClientID, req.Limit, logger)
+	if err != nil {
+		renderErrorResponse(w, "Internal Server Error", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	body, err := json.Marshal(&lobby.AdminRoomsResponse{Msg: "ok", Rooms: rooms})
+	if err != nil {
+		renderErrorResponse(w, "Failed to marshal response", http.StatusInternalServerError, err, logger)
+		return
+	}
+	logger.Infof("Rresponse(OK): admin rooms: %v rooms", len(rooms))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// アプリの部屋数、プレイヤー数、メッセージレートを全gameサーバーから集計する。
+// 認証はアプリ毎なので、各アプリの運用者は自分のアプリの情報だけを見られる。AdminKickと同様にJSONを使う。
+func (sv *LobbyService) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:admin/stats", h, r)
+	if h.appId != h.userId {
+		err := xerrors.Errorf("bad userID: appID=%q userID=%q", h.appId, h.userId)
+		renderErrorResponse(w, "Failed to auth", http.StatusForbidden, err, logger)
+		return
+	}
+
+	_, err := sv.authUser(h)
+	if err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	total, hosts, tags, err := sv.roomService.AdminAppStats(ctx, h.appId, logger)
+	if err != nil {
+		renderErrorResponse(w, "Internal Server Error", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	body, err := json.Marshal(&lobby.AdminStatsResponse{Msg: "ok", Total: total, Hosts: hosts, Tags: tags})
+	if err != nil {
+		renderErrorResponse(w, "Failed to marshal response", http.StatusInternalServerError, err, logger)
+		return
+	}
+	logger.Infof("Rresponse(OK): admin stats: rooms=%v players=%v", total.Rooms, total.Players)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// gameサーバの状態を返す。HeartBeatが途絶えたサーバも"down"として含める。
+// AdminStatsと同様にアプリの認証を使い、JSONで返す。
+func (sv *LobbyService) handleAdminHosts(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:admin/hosts", h, r)
+	if h.appId != h.userId {
+		err := xerrors.Errorf("bad userID: appID=%q userID=%q", h.appId, h.userId)
+		renderErrorResponse(w, "Failed to auth", http.StatusForbidden, err, logger)
+		return
+	}
+
+	_, err := sv.authUser(h)
+	if err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	hosts, err := sv.roomService.AdminHosts(ctx, h.appId, logger)
+	if err != nil {
+		renderErrorResponse(w, "Internal Server Error", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	body, err := json.Marshal(&lobby.AdminHostsResponse{Msg: "ok", Hosts: hosts})
+	if err != nil {
+		renderErrorResponse(w, "Failed to marshal response", http.StatusInternalServerError, err, logger)
+		return
+	}
+	logger.Infof("Rresponse(OK): admin hosts: %v hosts", len(hosts))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// クライアントIDを含む記録(player_logなど)をDBから削除する。利用者からの削除依頼に応じて運用者がリクエストする。
+// AdminStatsと同様にアプリの認証を使い、自分のアプリの記録だけを削除できる。
+func (sv *LobbyService) handleAdminErase(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:admin/erase", h, r)
+	if h.appId != h.userId {
+		err := xerrors.Errorf("bad userID: appID=%q userID=%q", h.appId, h.userId)
+		renderErrorResponse(w, "Failed to auth", http.StatusForbidden, err, logger)
+		return
+	}
+
+	_, err := sv.authUser(h)
+	if err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var req lobby.AdminEraseParam
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		renderErrorResponse(w, "failed to decode JSON request", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	erased, err := sv.roomService.AdminEraseClient(ctx, h.appId, req.ClientID, logger)
+	if err != nil {
+		renderErrorResponse(w, "Internal Server Error", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	body, err := json.Marshal(&lobby.AdminEraseResponse{Msg: "ok", Erased: erased})
+	if err != nil {
+		renderErrorResponse(w, "Failed to marshal response", http.StatusInternalServerError, err, logger)
+		return
+	}
+	logger.Infof("Rresponse(OK): admin erase: %v %v", req.ClientID, erased)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// アプリの機能フラグを変更し、一覧を返す。運用者がリクエストする。
+// AdminStatsと同様にアプリの認証を使い、自分のアプリのフラグだけを変更できる。
+func (sv *LobbyService) handleAdminFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:admin/feature_flags", h, r)
+	if h.appId != h.userId {
+		err := xerrors.Errorf("bad userID: appID=%q userID=%q", h.appId, h.userId)
+		renderErrorResponse(w, "Failed to auth", http.StatusForbidden, err, logger)
+		return
+	}
+
+	_, err := sv.authUser(h)
+	if err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var req lobby.AdminFeatureFlagParam
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		renderErrorResponse(w, "failed to decode JSON request", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	flags, err := sv.roomService.AdminFeatureFlags(ctx, h.appId, &req, logger)
+	if err != nil {
+		renderErrorResponse(w, "Internal Server Error", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	body, err := json.Marshal(&lobby.AdminFeatureFlagResponse{Msg: "ok", Flags: flags})
+	if err != nil {
+		renderErrorResponse(w, "Failed to marshal response", http.StatusInternalServerError, err, logger)
+		return
+	}
+	logger.Infof("Rresponse(OK): admin feature flags: %v", len(flags))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// アプリの全ての部屋に管理者メッセージを送る。ゲームAPIサーバーからリクエストされる。
+// AdminKickと同様にJSONを使う。
+func (sv *LobbyService) handleAdminMessage(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:admin/message", h, r)
+	if h.appId != h.userId {
+		err := xerrors.Errorf("bad userID: appID=%q userID=%q", h.appId, h.userId)
+		renderErrorResponse(w, "Failed to auth", http.StatusForbidden, err, logger)
+		return
+	}
+
+	_, err := sv.authUser(h)
+	if err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var req lobby.AdminMessageParam
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		renderErrorResponse(w, "failed to decode JSON request", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	err = sv.roomService.AdminMessage(ctx, h.appId, req.Message, logger)
+	if err != nil {
+		renderErrorResponse(w, "Internal Server Error", http.StatusInternalServerError, err, logger)
+		return
+	}
+	logger.Infof("Rresponse(OK): admin message: %q", req.Message)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"msg": "ok"}`))
+}
+
+// 部屋またはクライアントにappのサーバからのメッセージ(EvTypeServerMessage)を送る。ゲームAPIサーバーからリクエストされる。
+// AdminKickと同様にJSONを使う。
+func (sv *LobbyService) handleServerMessage(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:admin/push", h, r)
+	if h.appId != h.userId {
+		err := xerrors.Errorf("bad userID: appID=%q userID=%q", h.appId, h.userId)
+		renderErrorResponse(w, "Failed to auth", http.StatusForbidden, err, logger)
+		return
+	}
+
+	_, err := sv.authUser(h)
+	if err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var req lobby.ServerMessageParam
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		renderErrorResponse(w, "failed to decode JSON request", http.StatusBadRequest, err, logger)
+		return
+	}
+	logger = logger.With(log.KeyRoom, req.RoomID)
+
+	rooms, err := sv.roomService.ServerMessage(ctx, h.appId, req.RoomID, req.ClientID, req.Data, logger)
+	if err != nil {
+		if e, ok := err.(lobby.ErrorWithType); ok && e.ErrType() == lobby.ErrRoomNotFound {
+			// JSONのAPIなのでNoRoomFoundのmsgpackではなく404を返す
+			logger.Infof("ErrorResponse: %d %s: %+v", http.StatusNotFound, e.Message(), err)
+			http.Error(w, e.Message(), http.StatusNotFound)
+			return
+		}
+		renderErrorResponse(w, "Internal Server Error", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	body, err := json.Marshal(&lobby.ServerMessageResponse{Msg: "ok", Rooms: rooms})
+	if err != nil {
+		renderErrorResponse(w, "Failed to marshal response", http.StatusInternalServerError, err, logger)
+		return
+	}
+	logger.Infof("Rresponse(OK): push: room=%q client=%q rooms=%v", req.RoomID, req.ClientID, rooms)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// Playerの居ない観戦専用の部屋を作る。ゲームAPIサーバーからリクエストされる。
+// イベントはpush APIで送る。AdminKickと同様にJSONを使う。
+func (sv *LobbyService) handleCreateChannel(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:admin/channels", h, r)
+	if h.appId != h.userId {
+		err := xerrors.Errorf("bad userID: appID=%q userID=%q", h.appId, h.userId)
+		renderErrorResponse(w, "Failed to auth", http.StatusForbidden, err, logger)
+		return
+	}
+
+	_, err := sv.authUser(h)
+	if err != nil {
+		renderErrorResponse(w, "Failed to user auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var req lobby.CreateChannelParam
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		renderErrorResponse(w, "failed to decode JSON request", http.StatusBadRequest, err, logger)
+		return
+	}
+	if req.RoomOption == nil {
+		req.RoomOption = &pb.RoomOption{}
+	}
+
+	room, err := sv.roomService.CreateChannel(ctx, h.appId, req.RoomOption)
+	if err != nil {
+		if e, ok := err.(lobby.ErrorWithType); ok && e.ErrType() == lobby.ErrRoomLimit {
+			// JSONのAPIなのでRoomLimitのmsgpackではなく503を返す
+			logger.Infof("ErrorResponse: %d %s: %+v", http.StatusServiceUnavailable, e.Message(), err)
+			http.Error(w, e.Message(), http.StatusServiceUnavailable)
+			return
+		}
+		renderErrorResponse(w, "Failed to create channel", http.StatusInternalServerError, err, logger)
+		return
+	}
+
+	body, err := json.Marshal(&lobby.CreateChannelResponse{Msg: "ok", Room: room})
+	if err != nil {
+		renderErrorResponse(w, "Failed to marshal response", http.StatusInternalServerError, err, logger)
+		return
+	}
+	logger.Infof("Rresponse(OK): channel: room=%q", room.Id)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func renderAdminAppResponse(w http.ResponseWriter, app *lobby.AdminApp, logger log.Logger) {
+	body, err := json.Marshal(&lobby.AdminAppResponse{Msg: "ok", App: app})
+	if err != nil {
+		renderErrorResponse(w, "Failed to marshal response", http.StatusInternalServerError, err, logger)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// appを登録する。運用ツールからリクエストされる。
+// 認証データはadmin_keyで生成する。AdminKickと同様にJSONを使う。
+func (sv *LobbyService) handleAdminCreateApp(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:admin/apps", h, r)
+	if err := sv.authAdmin(h); err != nil {
+		renderErrorResponse(w, "Failed to admin auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var req lobby.AdminAppParam
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		renderErrorResponse(w, "failed to decode JSON request", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	app, err := sv.roomService.AdminCreateApp(ctx, req.Id, req.Name, req.Key, logger)
+	if err != nil {
+		renderErrorResponse(w, "Internal Server Error", http.StatusInternalServerError, err, logger)
+		return
+	}
+	logger.Infof("Rresponse(OK): admin create app: %v", app.Id)
+	renderAdminAppResponse(w, app, logger)
+}
+
+// app keyを更新する。古いkeyも猶予期間(grace_period)の間は受け付ける。
+func (sv *LobbyService) handleAdminRotateAppKey(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:admin/apps/rotate", h, r)
+	if err := sv.authAdmin(h); err != nil {
+		renderErrorResponse(w, "Failed to admin auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	var req lobby.AdminAppParam
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		renderErrorResponse(w, "failed to decode JSON request", http.StatusBadRequest, err, logger)
+		return
+	}
+
+	appId := chi.RouteContext(r.Context()).URLParam("appId")
+	grace := time.Duration(req.GracePeriod) * time.Second
+	app, err := sv.roomService.AdminRotateAppKey(ctx, appId, req.Key, grace, logger)
+	if err != nil {
+		renderErrorResponse(w, "Internal Server Error", http.StatusInternalServerError, err, logger)
+		return
+	}
+	logger.Infof("Rresponse(OK): admin rotate app key: %v", appId)
+	renderAdminAppResponse(w, app, logger)
+}
+
+// appを無効化(disable)または再度有効化(enable)する。
+func (sv *LobbyService) handleAdminDisableApp(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(sv.conf.ApiTimeout))
+	defer cancel()
+
+	h := parseSpecificHeader(r)
+	logger := prepareLogger("lobby:admin/apps/disable", h, r)
+	if err := sv.authAdmin(h); err != nil {
+		renderErrorResponse(w, "Failed to admin auth", http.StatusUnauthorized, err, logger)
+		return
+	}
+
+	rctx := chi.RouteContext(r.Context())
+	appId := rctx.URLParam("appId")
+	disabled := rctx.URLParam("op") == "disable"
+	app, err := sv.roomService.AdminSetAppDisabled(ctx, appId, disabled, logger)
+	if err != nil {
+		renderErrorResponse(w, "Internal Server Error", http.StatusInternalServerError, err, logger)
+		return
+	}
+	logger.Infof("Rresponse(OK): admin disable app: %v disabled=%v", appId, disabled)
+	renderAdminAppResponse(w, app, logger)
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"wsnet2/lobby"
+	"wsnet2/log"
+	"wsnet2/requestid"
+)
+
+// handleWebsocketProxy : /ws/{kind}/{hostId}/* へのwebsocketをgame/hubサーバへ中継する
+func (sv *LobbyService) handleWebsocketProxy(w http.ResponseWriter, r *http.Request) {
+	ctx := chi.RouteContext(r.Context())
+	kind := lobby.ProxyKind(ctx.URLParam("kind"))
+	hostId, _ := strconv.ParseUint(ctx.URLParam("hostId"), 10, 32)
+	path := "/" + ctx.URLParam("*")
+
+	logger := log.GetLoggerWith(
+		log.KeyHandler, "websocket proxy",
+		log.KeyRequestedAt, float64(time.Now().UnixMilli())/1000,
+		log.KeyRequestId, requestid.FromContext(r.Context()),
+	)
+
+	be, err := sv.roomService.WebsocketBackend(kind, uint32(hostId))
+	if err != nil {
+		logger.Warnf("websocket backend: %+v", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	logger.Debugf("proxy websocket: %v%v -> %v://%v%v", r.Host, r.URL.Path, be.Scheme, be.Host, path)
+
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = be.Scheme
+			req.URL.Host = be.Host
+			req.URL.Path = path
+			req.URL.RawPath = ""
+			req.Host = be.Host
+		},
+		// upgradeされた接続はTransportのpoolに戻らないので、都度作って使い捨てる
+		Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			DisableKeepAlives: true,
+			TLSClientConfig:   &tls.Config{ServerName: be.ServerName},
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logger.Warnf("proxy error: %+v", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
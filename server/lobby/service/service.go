@@ -5,6 +5,7 @@ import (
 
 	"github.com/jmoiron/sqlx"
 	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
 
 	"wsnet2/config"
 	"wsnet2/lobby"
@@ -15,15 +16,27 @@ type LobbyService struct {
 	roomService *lobby.RoomService
 }
 
-func New(db *sqlx.DB, conf *config.LobbyConf) (*LobbyService, error) {
-	roomService, err := lobby.NewRoomService(db, conf)
+// New : 単体のwsnet2-lobbyとして動かすLobbyServiceを作る.
+// opts はgameサーバとhubサーバへのgRPC接続に追加するDialOption (interceptorなど)
+func New(db *sqlx.DB, conf *config.LobbyConf, opts ...grpc.DialOption) (*LobbyService, error) {
+	roomService, err := lobby.NewRoomService(db, conf, opts...)
 	if err != nil {
 		return nil, xerrors.Errorf("NewRoomService: %w", err)
 	}
+	return NewWithRoomService(conf, roomService), nil
+}
+
+// NewWithRoomService : 作成済みのRoomServiceを使うLobbyServiceを作る.
+// 既存のGoのサービスに組み込むときは、Serveを呼ばずにHandlerかRegisterRoutesでAPIを登録する.
+func NewWithRoomService(conf *config.LobbyConf, roomService *lobby.RoomService) *LobbyService {
 	return &LobbyService{
 		conf:        conf,
 		roomService: roomService,
-	}, nil
+	}
+}
+
+func (s *LobbyService) RoomService() *lobby.RoomService {
+	return s.roomService
 }
 
 func (s *LobbyService) Serve(ctx context.Context) error {
@@ -54,6 +54,10 @@ const (
 	KeyRemoteAddr = "remoteAddr"
 	// Requested at (unix timestamp, float64)
 	KeyRequestedAt = "requestedAt"
+	// Request ID (see wsnet2/requestid)
+	KeyRequestId = "requestId"
+	// Request ID of the room creation (see wsnet2/requestid)
+	KeyRoomRequestId = "roomRequestId"
 	// Room ID
 	KeyRoom = "room"
 	// Room count
@@ -2,6 +2,8 @@ package metrics
 
 import (
 	"expvar"
+	"sync"
+	"time"
 )
 
 var (
@@ -11,6 +13,65 @@ var (
 	Hubs        = new(expvar.Int)
 	MessageSent = new(expvar.Int)
 	MessageRecv = new(expvar.Int)
+	BytesSent   = new(expvar.Int)
+	BytesRecv   = new(expvar.Int)
+
+	// AppBytesSent, AppBytesRecv : app毎のクライアントとの送受信バイト数
+	AppBytesSent = new(expvar.Map)
+	AppBytesRecv = new(expvar.Map)
+
+	// AppMessageSent, AppMessageRecv : app毎のクライアントとの送受信メッセージ数
+	AppMessageSent = new(expvar.Map)
+	AppMessageRecv = new(expvar.Map)
+
+	// AppConns, AppRooms : app毎の接続数と部屋数
+	AppConns = new(expvar.Map)
+	AppRooms = new(expvar.Map)
+
+	Backpressure = new(expvar.Int)
+	DegradedHubs = new(expvar.Int)
+
+	// BridgeDropped : 配信キューが溢れて捨てたbridgeのイベント数
+	BridgeDropped = new(expvar.Int)
+
+	// RelayDropped : 配信キューが溢れて捨てたrelayのイベント数 (game)
+	RelayDropped = new(expvar.Int)
+	// RelayedEvents : websocketより先にrelayで受け取ったイベント数 (hub)
+	RelayedEvents = new(expvar.Int)
+	// RelaySkipped : relayが主経路のとき、Hubが受信を通知したのでwebsocketで送らなかったイベント数 (game)
+	RelaySkipped = new(expvar.Int)
+
+	// DBWriteErrors : 部屋情報やプレイヤーログのDB書き込みの失敗数
+	DBWriteErrors = new(expvar.Int)
+	// DBDegraded : DB書き込みに失敗してリトライ中のwriterの数. 0でなければDB障害中
+	DBDegraded = new(expvar.Int)
+	// PlayerLogDropped : DB障害中などにキューが溢れて捨てたプレイヤーログの数
+	PlayerLogDropped = new(expvar.Int)
+
+	// ModerationDropped : 審査キューが溢れて審査しなかったメッセージ数
+	ModerationDropped = new(expvar.Int)
+	// ModerationErrors : 審査サービスへのリクエストの失敗数
+	ModerationErrors = new(expvar.Int)
+
+	// EncryptedRejected : サイズや頻度の上限を超えて中継しなかった暗号化メッセージ数
+	EncryptedRejected = new(expvar.Int)
+
+	// PropsRejected : 部屋のプロパティの合計サイズが上限を超えるため拒否した変更の数
+	PropsRejected = new(expvar.Int)
+
+	// MsgSeqRejected : 通し番号が不正で受理しなかったMsg数 (理由毎: duplicate, too_old, gap)
+	MsgSeqRejected = new(expvar.Map)
+
+	// SlowConsumers : 受信が遅れ続けたクライアントへの対応の数 (対応毎: warn, disconnect)
+	SlowConsumers = new(expvar.Map)
+
+	// EventAcks : クライアントから受け取ったMsgTypeEventAckの数
+	EventAcks = new(expvar.Int)
+	// EventsLost : 送信したがクライアントが受信できず、再接続で再送したイベント数
+	EventsLost = new(expvar.Int)
+
+	// ServerTag : gameサーバのタグ (例: "canary"). 集計時にタグ毎に分けるため
+	ServerTag = new(expvar.String)
 )
 
 func init() {
@@ -19,4 +80,162 @@ func init() {
 	expmap.Set("hubs", Hubs)
 	expmap.Set("message_sent", MessageSent)
 	expmap.Set("message_recv", MessageRecv)
+	expmap.Set("bytes_sent", BytesSent)
+	expmap.Set("bytes_recv", BytesRecv)
+	expmap.Set("app_bytes_sent", AppBytesSent)
+	expmap.Set("app_bytes_recv", AppBytesRecv)
+	expmap.Set("app_message_sent", AppMessageSent)
+	expmap.Set("app_message_recv", AppMessageRecv)
+	expmap.Set("app_conns", AppConns)
+	expmap.Set("app_rooms", AppRooms)
+	expmap.Set("backpressure", Backpressure)
+	expmap.Set("degraded_hubs", DegradedHubs)
+	expmap.Set("bridge_dropped", BridgeDropped)
+	expmap.Set("relay_dropped", RelayDropped)
+	expmap.Set("relayed_events", RelayedEvents)
+	expmap.Set("relay_skipped", RelaySkipped)
+	expmap.Set("db_write_errors", DBWriteErrors)
+	expmap.Set("db_degraded", DBDegraded)
+	expmap.Set("player_log_dropped", PlayerLogDropped)
+	expmap.Set("moderation_dropped", ModerationDropped)
+	expmap.Set("moderation_errors", ModerationErrors)
+	expmap.Set("encrypted_rejected", EncryptedRejected)
+	expmap.Set("props_rejected", PropsRejected)
+	expmap.Set("msg_seq_rejected", MsgSeqRejected)
+	expmap.Set("slow_consumers", SlowConsumers)
+	expmap.Set("event_acks", EventAcks)
+	expmap.Set("events_lost", EventsLost)
+	expmap.Set("server_tag", ServerTag)
+}
+
+// SetQueueDepth : キューに溜まっている数を返す関数を登録する
+//   - msg: 部屋のMsgキュー
+//   - event: クライアントへの未送信Event
+func SetQueueDepth(msg, event func() int) {
+	expmap.Set("msg_queue_depth", expvar.Func(func() any { return msg() }))
+	expmap.Set("event_queue_depth", expvar.Func(func() any { return event() }))
+}
+
+// SetHeavyRooms : メモリの使用量が多い部屋の一覧を返す関数を登録する
+func SetHeavyRooms(rooms func() any) {
+	expmap.Set("heavy_rooms", expvar.Func(rooms))
+}
+
+// AddAppTraffic : app毎の受信(in)・送信(out)バイト数とメッセージ数を加算する.
+// 1回の呼び出しを1メッセージとして数える.
+func AddAppTraffic(appId string, in, out int) {
+	if in > 0 {
+		AppBytesRecv.Add(appId, int64(in))
+		AppMessageRecv.Add(appId, 1)
+		appRate(appId).recv.add(time.Now(), 1)
+	}
+	if out > 0 {
+		AppBytesSent.Add(appId, int64(out))
+		AppMessageSent.Add(appId, 1)
+		appRate(appId).sent.add(time.Now(), 1)
+	}
+}
+
+// AddAppConns : app毎の接続数を加算する
+func AddAppConns(appId string, delta int64) {
+	Conns.Add(delta)
+	AppConns.Add(appId, delta)
+}
+
+// AddAppRooms : app毎の部屋数を加算する
+func AddAppRooms(appId string, delta int64) {
+	Rooms.Add(delta)
+	AppRooms.Add(appId, delta)
+}
+
+// AppStat : 1つのappのメトリクス
+type AppStat struct {
+	Conns       int64
+	Rooms       int64
+	MessageSent int64
+	MessageRecv int64
+	BytesSent   int64
+	BytesRecv   int64
+
+	// MessageSentRate, MessageRecvRate : 直前の1分間の送受信メッセージ数(/sec)
+	MessageSentRate float64
+	MessageRecvRate float64
+}
+
+// GetAppStat : appのメトリクスを返す
+func GetAppStat(appId string) AppStat {
+	now := time.Now()
+	r := appRate(appId)
+	return AppStat{
+		Conns:           intValue(AppConns, appId),
+		Rooms:           intValue(AppRooms, appId),
+		MessageSent:     intValue(AppMessageSent, appId),
+		MessageRecv:     intValue(AppMessageRecv, appId),
+		BytesSent:       intValue(AppBytesSent, appId),
+		BytesRecv:       intValue(AppBytesRecv, appId),
+		MessageSentRate: r.sent.rate(now),
+		MessageRecvRate: r.recv.rate(now),
+	}
+}
+
+func intValue(m *expvar.Map, key string) int64 {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// rateWindow : メッセージレートを集計する期間
+const rateWindow = time.Minute
+
+var rates sync.Map // map[string]*appRates
+
+type appRates struct {
+	sent rateCounter
+	recv rateCounter
+}
+
+func appRate(appId string) *appRates {
+	if r, ok := rates.Load(appId); ok {
+		return r.(*appRates)
+	}
+	r, _ := rates.LoadOrStore(appId, &appRates{})
+	return r.(*appRates)
+}
+
+// rateCounter : rateWindow毎に数を集計し、直前の期間の数からレートを求める
+type rateCounter struct {
+	mu    sync.Mutex
+	start time.Time
+	cur   int64
+	prev  int64
+}
+
+func (c *rateCounter) add(now time.Time, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roll(now)
+	c.cur += n
+}
+
+// rate : 直前の期間の1秒あたりの数
+func (c *rateCounter) rate(now time.Time) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roll(now)
+	return float64(c.prev) / rateWindow.Seconds()
+}
+
+func (c *rateCounter) roll(now time.Time) {
+	d := now.Sub(c.start)
+	if d < rateWindow {
+		return
+	}
+	if d < rateWindow*2 {
+		c.prev = c.cur
+	} else {
+		c.prev = 0
+	}
+	c.cur = 0
+	c.start = now.Truncate(rateWindow)
 }
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateCounter(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var c rateCounter
+
+	c.add(base, 30)
+	c.add(base.Add(30*time.Second), 30)
+	if r := c.rate(base.Add(59 * time.Second)); r != 0 {
+		t.Fatalf("rate in first window: %v, wants 0", r)
+	}
+	if r := c.rate(base.Add(61 * time.Second)); r != 1 {
+		t.Fatalf("rate in second window: %v, wants 1", r)
+	}
+	c.add(base.Add(90*time.Second), 120)
+	if r := c.rate(base.Add(121 * time.Second)); r != 2 {
+		t.Fatalf("rate in third window: %v, wants 2", r)
+	}
+	if r := c.rate(base.Add(5 * time.Minute)); r != 0 {
+		t.Fatalf("rate after idle: %v, wants 0", r)
+	}
+}
+
+func TestGetAppStat(t *testing.T) {
+	AddAppRooms("statapp", 2)
+	AddAppRooms("statapp", -1)
+	AddAppConns("statapp", 3)
+	AddAppTraffic("statapp", 10, 0)
+	AddAppTraffic("statapp", 0, 20)
+	AddAppTraffic("statapp", 0, 5)
+
+	s := GetAppStat("statapp")
+	want := AppStat{Conns: 3, Rooms: 1, MessageSent: 2, MessageRecv: 1, BytesSent: 25, BytesRecv: 10}
+	if s != want {
+		t.Fatalf("GetAppStat: %+v, wants %+v", s, want)
+	}
+	if s := GetAppStat("unknown"); s != (AppStat{}) {
+		t.Fatalf("GetAppStat(unknown): %+v", s)
+	}
+}
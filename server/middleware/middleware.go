@@ -0,0 +1,132 @@
+// Package middleware : game/hubのwebsocket接続のUpgrade前に適用するmiddleware.
+//
+// 社内の認証基盤やログ、ヘッダの書き換えなどをハンドラを変更せずに組み込むためのもの.
+// 設定 (config.WebsocketMiddlewareConf) から作るものの後に、コードから渡したものを適用する.
+// websocketのUpgradeにはhttp.Hijackerが必要なので、ResponseWriterを包まないこと.
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"wsnet2/config"
+	"wsnet2/log"
+)
+
+// Middleware : http.Handlerを包むmiddleware. chiのUseやWithにそのまま渡せる
+type Middleware = func(http.Handler) http.Handler
+
+// OriginalURIHeader : 認証サービスへのリクエストに付ける、接続のリクエストURI
+const OriginalURIHeader = "X-Original-URI"
+
+// FromConfig : 設定から作ったmiddlewareを適用する順に返す. handlerはアクセスログに付ける名前
+func FromConfig(conf *config.WebsocketMiddlewareConf, handler string) []Middleware {
+	var mws []Middleware
+	if len(conf.SetHeaders) > 0 {
+		mws = append(mws, SetHeaders(conf.SetHeaders))
+	}
+	if conf.AccessLog {
+		mws = append(mws, AccessLog(handler))
+	}
+	if conf.AuthURL != "" {
+		mws = append(mws, Auth(conf.AuthURL, conf.AuthHeaders, conf.AuthResponseHeaders, time.Duration(conf.AuthTimeout)))
+	}
+	return mws
+}
+
+// SetHeaders : リクエストヘッダを書き換える. 値が空文字列ならヘッダを削除する
+func SetHeaders(headers map[string]string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, v := range headers {
+				if v == "" {
+					r.Header.Del(k)
+				} else {
+					r.Header.Set(k, v)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AccessLog : 接続のリクエストと、接続が終わるまでの時間をログに出力する
+func AccessLog(handler string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger := log.GetLoggerWith(
+				log.KeyHandler, handler,
+				log.KeyApp, r.Header.Get("Wsnet2-App"),
+				log.KeyClient, r.Header.Get("Wsnet2-User"),
+				log.KeyRemoteAddr, r.RemoteAddr,
+			)
+			start := time.Now()
+			logger.Infof("websocket request: %v %v", r.Method, r.URL.RequestURI())
+			next.ServeHTTP(w, r)
+			logger.Infof("websocket finished: %v %v (%v)", r.Method, r.URL.RequestURI(), time.Since(start))
+		})
+	}
+}
+
+// Auth : 外部の認証サービスにリクエストヘッダを付けてGETし、2xxなら次に進む.
+// それ以外は認証サービスと同じステータスで拒否し、認証サービスに接続できなければ503で拒否する.
+// headersが空ならwebsocketのハンドシェイク以外の全てのヘッダを転送する.
+// responseHeadersに指定したレスポンスヘッダは接続のリクエストヘッダに設定する.
+func Auth(url string, headers, responseHeaders []string, timeout time.Duration) Middleware {
+	client := &http.Client{Timeout: timeout}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, url, nil)
+			if err != nil {
+				log.Errorf("websocket auth: new request: %+v", err)
+				http.Error(w, "Auth service unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			copyAuthHeaders(req.Header, r.Header, headers)
+			req.Header.Set(OriginalURIHeader, r.URL.RequestURI())
+
+			res, err := client.Do(req)
+			if err != nil {
+				log.Errorf("websocket auth: %+v", err)
+				http.Error(w, "Auth service unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+
+			if res.StatusCode < 200 || res.StatusCode >= 300 {
+				log.Infof("websocket auth rejected: %v %v", res.StatusCode, r.URL.RequestURI())
+				http.Error(w, http.StatusText(res.StatusCode), res.StatusCode)
+				return
+			}
+			for _, h := range responseHeaders {
+				if v := res.Header.Values(h); len(v) > 0 {
+					r.Header[http.CanonicalHeaderKey(h)] = v
+				} else {
+					r.Header.Del(h)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func copyAuthHeaders(dst, src http.Header, headers []string) {
+	if len(headers) > 0 {
+		for _, h := range headers {
+			if v := src.Values(h); len(v) > 0 {
+				dst[http.CanonicalHeaderKey(h)] = v
+			}
+		}
+		return
+	}
+	for k, v := range src {
+		switch {
+		case k == "Connection", k == "Upgrade", strings.HasPrefix(k, "Sec-Websocket-"):
+			continue
+		}
+		dst[k] = v
+	}
+}
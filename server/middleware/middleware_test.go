@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"wsnet2/config"
+	"wsnet2/log"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.NOLOG)
+	os.Exit(m.Run())
+}
+
+// echoUser : 受け取ったX-User-Idヘッダを返すハンドラ
+func echoUser(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(r.Header.Get("X-User-Id")))
+}
+
+func serve(mws []Middleware, req *http.Request) *httptest.ResponseRecorder {
+	r := chi.NewMux()
+	r.With(mws...).Get("/room/{id}", echoUser)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSetHeaders(t *testing.T) {
+	mws := FromConfig(&config.WebsocketMiddlewareConf{
+		SetHeaders: map[string]string{"X-User-Id": "rewritten", "X-Debug": ""},
+	}, "test")
+
+	req := httptest.NewRequest(http.MethodGet, "/room/1", nil)
+	req.Header.Set("X-User-Id", "original")
+	req.Header.Set("X-Debug", "1")
+	w := serve(mws, req)
+	if body := w.Body.String(); body != "rewritten" {
+		t.Fatalf("X-User-Id = %q, wants %q", body, "rewritten")
+	}
+	if req.Header.Get("X-Debug") != "" {
+		t.Fatalf("X-Debug is not deleted")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Sec-Websocket-Key") != "" {
+			t.Errorf("handshake header is forwarded")
+		}
+		if r.Header.Get(OriginalURIHeader) != "/room/1" {
+			t.Errorf("%v = %q", OriginalURIHeader, r.Header.Get(OriginalURIHeader))
+		}
+		switch r.Header.Get("Authorization") {
+		case "Bearer ok":
+			w.Header().Set("X-User-Id", "user1")
+		case "Bearer nouser":
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer auth.Close()
+
+	mws := FromConfig(&config.WebsocketMiddlewareConf{
+		AuthURL:             auth.URL,
+		AuthResponseHeaders: []string{"X-User-Id"},
+		AuthTimeout:         config.Duration(time.Second),
+	}, "test")
+
+	tests := []struct {
+		authz  string
+		status int
+		user   string
+	}{
+		{"Bearer ok", http.StatusOK, "user1"},
+		// 認証サービスが返さなかったヘッダはクライアントの指定を消す
+		{"Bearer nouser", http.StatusOK, ""},
+		{"Bearer ng", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/room/1", nil)
+		req.Header.Set("Authorization", tt.authz)
+		req.Header.Set("Sec-Websocket-Key", "key")
+		req.Header.Set("X-User-Id", "spoofed")
+		w := serve(mws, req)
+		if w.Code != tt.status {
+			t.Errorf("%v: status = %v, wants %v", tt.authz, w.Code, tt.status)
+			continue
+		}
+		if tt.status == http.StatusOK && w.Body.String() != tt.user {
+			t.Errorf("%v: X-User-Id = %q, wants %q", tt.authz, w.Body.String(), tt.user)
+		}
+	}
+
+	// 認証サービスに接続できなければ拒否する
+	auth.Close()
+	req := httptest.NewRequest(http.MethodGet, "/room/1", nil)
+	if w := serve(mws, req); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %v, wants %v", w.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,188 @@
+// Package moderation : 部屋で中継したメッセージを外部の審査サービス (HTTP) に送り、審査結果を部屋に反映する.
+//
+// メッセージは中継した後に審査キューに入れ、審査サービスへは別のgoroutineから送るので中継は遅れない.
+// 審査サービスには Request をJSONでPOSTし、200で Verdict のJSONが返れば部屋に反映する.
+// 204 (No Content) や何もしない Verdict のときは何もしない.
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/config"
+	"wsnet2/log"
+	"wsnet2/metrics"
+)
+
+// 審査するメッセージの種類
+const (
+	KindBroadcast = "Broadcast"
+	KindTargets   = "Targets"
+	KindToMaster  = "ToMaster"
+	KindToRole    = "ToRole"
+)
+
+// Request : 審査サービスに送るメッセージ (JSON)
+type Request struct {
+	AppId    string `json:"app_id"`
+	RoomId   string `json:"room_id"`
+	ClientId string `json:"client_id"`
+	Kind     string `json:"kind"`
+	// Data : マーシャル済みの値 (JSONではbase64)
+	Data []byte `json:"data"`
+}
+
+// Verdict : 審査サービスからの審査結果 (JSON)
+type Verdict struct {
+	// Redact : 受信者にメッセージの取り消し (EvTypeRedacted) を通知する
+	Redact bool `json:"redact"`
+	// Kick : 送信者を退室させる
+	Kick bool `json:"kick"`
+	// BanDuration : Kickしたとき、この秒数の間は再入室させない
+	BanDuration uint32 `json:"ban_duration"`
+	// Reason : Kickの理由コード (see: binary.KickReason)
+	Reason byte `json:"reason"`
+	// Message : Kickしたときの退室メッセージ
+	Message string `json:"message"`
+}
+
+// VerdictFunc : 審査結果を部屋に反映する
+type VerdictFunc func(v *Verdict)
+
+type request struct {
+	req   *Request
+	apply VerdictFunc
+}
+
+// Moderator : 審査キューのメッセージを審査サービスに送る
+type Moderator struct {
+	conf   *config.ModerationConf
+	client *http.Client
+
+	kinds map[string]bool
+	apps  map[string]bool
+
+	queue chan request
+}
+
+// New : confからModeratorを作る. URLが空ならnilを返す
+func New(conf *config.ModerationConf) *Moderator {
+	if conf.URL == "" {
+		return nil
+	}
+	m := &Moderator{
+		conf:   conf,
+		client: &http.Client{Timeout: time.Duration(conf.Timeout)},
+		queue:  make(chan request, conf.QueueSize),
+	}
+	if len(conf.Kinds) > 0 {
+		m.kinds = make(map[string]bool, len(conf.Kinds))
+		for _, k := range conf.Kinds {
+			m.kinds[k] = true
+		}
+	}
+	if len(conf.Apps) > 0 {
+		m.apps = make(map[string]bool, len(conf.Apps))
+		for _, a := range conf.Apps {
+			m.apps[a] = true
+		}
+	}
+	return m
+}
+
+// Selects : appIdのkindのメッセージを審査するか
+func (m *Moderator) Selects(appId, kind string) bool {
+	if m.apps != nil && !m.apps[appId] {
+		return false
+	}
+	return m.kinds == nil || m.kinds[kind]
+}
+
+// Scan : メッセージを審査キューに入れる. キューが溢れていたら審査しない.
+// 中継goroutineから呼ばれるのでブロックしない.
+func (m *Moderator) Scan(req *Request, apply VerdictFunc) {
+	select {
+	case m.queue <- request{req, apply}:
+	default:
+		metrics.ModerationDropped.Add(1)
+	}
+}
+
+// Serve : Workersの数のgoroutineで審査キューのメッセージを審査サービスに送る.
+// ctxが終了するまで返らない.
+func (m *Moderator) Serve(ctx context.Context) error {
+	workers := m.conf.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			m.work(ctx)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (m *Moderator) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-m.queue:
+			v, err := m.post(ctx, r.req)
+			if err != nil {
+				metrics.ModerationErrors.Add(1)
+				log.Infof("moderation: app=%v room=%v client=%v: %+v", r.req.AppId, r.req.RoomId, r.req.ClientId, err)
+				continue
+			}
+			if v != nil && (v.Redact || v.Kick) {
+				r.apply(v)
+			}
+		}
+	}
+}
+
+// post : 審査サービスにメッセージを送り、審査結果を返す. 何もしないときはnil
+func (m *Moderator) post(ctx context.Context, req *Request) (*Verdict, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, xerrors.Errorf("marshal request: %w", err)
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, m.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, xerrors.Errorf("new request: %w", err)
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+
+	res, err := m.client.Do(hreq)
+	if err != nil {
+		return nil, xerrors.Errorf("post: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		io.Copy(io.Discard, res.Body)
+		return nil, xerrors.Errorf("status: %v", res.Status)
+	}
+
+	var v Verdict
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return nil, xerrors.Errorf("decode verdict: %w", err)
+	}
+	return &v, nil
+}
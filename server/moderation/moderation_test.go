@@ -0,0 +1,94 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"wsnet2/config"
+	"wsnet2/log"
+	"wsnet2/metrics"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.NOLOG)
+	os.Exit(m.Run())
+}
+
+func TestSelects(t *testing.T) {
+	m := New(&config.ModerationConf{
+		URL:   "http://localhost/scan",
+		Kinds: []string{KindBroadcast},
+		Apps:  []string{"app1"},
+	})
+	tests := []struct {
+		app, kind string
+		want      bool
+	}{
+		{"app1", KindBroadcast, true},
+		{"app1", KindTargets, false},
+		{"app2", KindBroadcast, false},
+	}
+	for _, tt := range tests {
+		if got := m.Selects(tt.app, tt.kind); got != tt.want {
+			t.Errorf("Selects(%v, %v) = %v, wants %v", tt.app, tt.kind, got, tt.want)
+		}
+	}
+
+	if New(&config.ModerationConf{}) != nil {
+		t.Fatalf("New without URL must be nil")
+	}
+}
+
+func TestModerator(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		switch string(req.Data) {
+		case "bad":
+			json.NewEncoder(w).Encode(&Verdict{Redact: true, Kick: true, BanDuration: 60, Reason: 2})
+		case "error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer svr.Close()
+
+	m := New(&config.ModerationConf{URL: svr.URL, Workers: 2, Timeout: config.Duration(time.Second), QueueSize: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Serve(ctx)
+
+	verdicts := make(chan *Verdict, 3)
+	errors := metrics.ModerationErrors.Value()
+	for _, data := range []string{"ok", "error", "bad"} {
+		m.Scan(&Request{AppId: "app", RoomId: "room", ClientId: "c", Kind: KindBroadcast, Data: []byte(data)},
+			func(v *Verdict) { verdicts <- v })
+	}
+
+	select {
+	case v := <-verdicts:
+		want := Verdict{Redact: true, Kick: true, BanDuration: 60, Reason: 2}
+		if *v != want {
+			t.Fatalf("verdict = %+v, wants %+v", *v, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("verdict timeout")
+	}
+
+	// 何もしない審査結果は反映しない
+	time.Sleep(100 * time.Millisecond)
+	if len(verdicts) != 0 {
+		t.Fatalf("unexpected verdict: %+v", <-verdicts)
+	}
+	if e := metrics.ModerationErrors.Value() - errors; e != 1 {
+		t.Fatalf("errors = %v, wants 1", e)
+	}
+}
@@ -0,0 +1,19 @@
+package pb
+
+import "sort"
+
+// Bytes : 部屋が使っているメモリの概算
+func (u *RoomUsage) Bytes() uint64 {
+	return u.EvbufBytes + u.PropsBytes + u.HistoryBytes
+}
+
+// SortRoomUsages : メモリの使用量が多い順に並べる. 同じならgoroutineが多い順.
+func SortRoomUsages(usages []*RoomUsage) {
+	sort.SliceStable(usages, func(i, j int) bool {
+		bi, bj := usages[i].Bytes(), usages[j].Bytes()
+		if bi != bj {
+			return bi > bj
+		}
+		return usages[i].Goroutines > usages[j].Goroutines
+	})
+}
@@ -1,3 +1,32 @@
 package pb
 
 type AppId = string
+
+// RejoinPolicy : 入室済みのPlayerと同じクライアントIDでの入室の扱い (RoomOption.RejoinPolicy)
+// 入室済みのPlayerが接続していないときはいずれの場合も置き換える.
+type RejoinPolicy = uint32
+
+const (
+	// RejoinPolicyReplace : 入室済みの接続を新しい接続に置き換える
+	RejoinPolicyReplace RejoinPolicy = iota
+	// RejoinPolicyReject : 新しい接続の入室を拒否する
+	RejoinPolicyReject
+	// RejoinPolicyConfirm : 入室済みの接続に確認し、受諾されたら置き換える.
+	// 確認に対応していないクライアントの場合は置き換える.
+	RejoinPolicyConfirm
+)
+
+// SlowConsumerPolicy : イベントの受信が遅れ続けるクライアントの扱い (RoomOption.SlowConsumerPolicy)
+// いずれの場合もEvTypeSlowConsumerで警告する. Hubは配下の観戦者を巻き込むので切断しない.
+type SlowConsumerPolicy = uint32
+
+const (
+	// SlowConsumerPolicyDefault : gameサーバの設定 (slow_consumer_policy) に従う
+	SlowConsumerPolicyDefault SlowConsumerPolicy = iota
+	// SlowConsumerPolicyWarn : 警告のみで切断しない
+	SlowConsumerPolicyWarn
+	// SlowConsumerPolicyDisconnectWatchers : 観戦者は切断し、Playerは警告のみ
+	SlowConsumerPolicyDisconnectWatchers
+	// SlowConsumerPolicyDisconnect : Playerも観戦者も切断する
+	SlowConsumerPolicyDisconnect
+)
@@ -0,0 +1,221 @@
+// Package redis : RESP (REdis Serialization Protocol) の最小限のクライアント.
+// relayのpub/subとlobbyのpush APIの呼び出し回数の共有に使う.
+// see: https://redis.io/docs/reference/protocol-spec/
+package redis
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultPort = "6379"
+	dialTimeout = 5 * time.Second
+	maxBulkLen  = 512 * 1024 * 1024
+)
+
+// Error : Redisが返したエラー (-ERR ...)
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Conn : Redisへの接続.
+// 書き込み (Send, Flush) は複数のgoroutineから呼べるが、読み込み (ReadReply) は1つのgoroutineで行うこと.
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	muw sync.Mutex
+	w   *bufio.Writer
+}
+
+// Dial : Redisサーバに接続する.
+// URLのuserinfoがあればAUTHを、pathにDB番号があればSELECTを送る.
+func Dial(ctx context.Context, rawurl string) (*Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, xerrors.Errorf("parse url: %w", err)
+	}
+	if u.Scheme != "redis" {
+		return nil, xerrors.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	var db int
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		db, err = strconv.Atoi(p)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid db number: %q", p)
+		}
+	}
+
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, xerrors.Errorf("dial: %w", err)
+	}
+	c, err := NewConn(conn, u.User, db)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewConn : 接続済みのconnでAUTHとSELECTを送る
+func NewConn(conn net.Conn, user *url.Userinfo, db int) (*Conn, error) {
+	c := &Conn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if user != nil {
+		// "redis://:pass@host" はパスワードのみ (Redis 6未満のAUTH)
+		args := []string{"AUTH"}
+		if pass, ok := user.Password(); ok {
+			if user.Username() != "" {
+				args = append(args, user.Username())
+			}
+			args = append(args, pass)
+		} else {
+			args = append(args, user.Username())
+		}
+		if _, err := c.Do(args...); err != nil {
+			return nil, xerrors.Errorf("AUTH: %w", err)
+		}
+	}
+	if db != 0 {
+		if _, err := c.Do("SELECT", strconv.Itoa(db)); err != nil {
+			return nil, xerrors.Errorf("SELECT: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// SetDeadline : 読み書きの期限を設定する
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// Do : コマンドを送って応答を待つ. 他のgoroutineが読み書きしない間だけ使う
+func (c *Conn) Do(args ...string) (any, error) {
+	bargs := make([][]byte, len(args))
+	for i, a := range args {
+		bargs[i] = []byte(a)
+	}
+	if err := c.Send(bargs...); err != nil {
+		return nil, err
+	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	return c.ReadReply()
+}
+
+// Send : コマンドを書き込む. 送信はバッファされるのでFlushを呼ぶこと
+func (c *Conn) Send(args ...[]byte) error {
+	c.muw.Lock()
+	defer c.muw.Unlock()
+	c.w.WriteByte('*')
+	c.w.WriteString(strconv.Itoa(len(args)))
+	c.w.WriteString("\r\n")
+	for _, a := range args {
+		c.w.WriteByte('$')
+		c.w.WriteString(strconv.Itoa(len(a)))
+		c.w.WriteString("\r\n")
+		c.w.Write(a)
+		if _, err := c.w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Conn) Flush() error {
+	c.muw.Lock()
+	defer c.muw.Unlock()
+	return c.w.Flush()
+}
+
+func (c *Conn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", xerrors.Errorf("invalid line: %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// ReadReply : 応答を1つ読む.
+// 型は string (simple string), int64, []byte (bulk string), []any (array), nil (null), Error のいずれか
+func (c *Conn) ReadReply() (any, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid integer: %q", line)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxBulkLen {
+			return nil, xerrors.Errorf("invalid bulk length: %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, xerrors.Errorf("invalid array length: %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			arr[i], err = c.ReadReply()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return nil, xerrors.Errorf("unknown reply: %q", line)
+}
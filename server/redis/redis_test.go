@@ -0,0 +1,94 @@
+package redis
+
+import (
+	"bufio"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// readCommand : クライアントが送ったコマンドを読む
+func readCommand(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+	c := &Conn{r: r}
+	reply, err := c.ReadReply()
+	if err != nil {
+		t.Fatalf("read command: %v", err)
+	}
+	arr, ok := reply.([]any)
+	if !ok {
+		t.Fatalf("command is not an array: %#v", reply)
+	}
+	cmd := make([]string, len(arr))
+	for i, a := range arr {
+		cmd[i] = string(a.([]byte))
+	}
+	return cmd
+}
+
+func TestConn(t *testing.T) {
+	cli, svr := net.Pipe()
+	defer cli.Close()
+	defer svr.Close()
+
+	r := bufio.NewReader(svr)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if cmd := readCommand(t, r); !cmp.Equal(cmd, []string{"AUTH", "pass"}) {
+			t.Errorf("AUTH = %q", cmd)
+		}
+		svr.Write([]byte("+OK\r\n"))
+		if cmd := readCommand(t, r); !cmp.Equal(cmd, []string{"SELECT", "2"}) {
+			t.Errorf("SELECT = %q", cmd)
+		}
+		svr.Write([]byte("+OK\r\n"))
+	}()
+
+	c, err := NewConn(cli, url.UserPassword("", "pass"), 2)
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	<-done
+
+	go func() {
+		c.Send([]byte("PUBLISH"), []byte("wsnet2:hub:1"), []byte("a\r\nb"))
+		c.Flush()
+	}()
+	if cmd := readCommand(t, r); !cmp.Equal(cmd, []string{"PUBLISH", "wsnet2:hub:1", "a\r\nb"}) {
+		t.Fatalf("PUBLISH = %q", cmd)
+	}
+
+	go svr.Write([]byte(":1\r\n*3\r\n$7\r\nmessage\r\n$1\r\nc\r\n$-1\r\n-ERR oops\r\n"))
+	if reply, err := c.ReadReply(); err != nil || reply != int64(1) {
+		t.Fatalf("integer reply = %#v, %v", reply, err)
+	}
+	reply, err := c.ReadReply()
+	if err != nil {
+		t.Fatalf("array reply: %v", err)
+	}
+	if diff := cmp.Diff(reply, []any{[]byte("message"), []byte("c"), nil}); diff != "" {
+		t.Fatalf("array reply differs: (-got +want)\n%s", diff)
+	}
+	if _, err := c.ReadReply(); err != Error("ERR oops") {
+		t.Fatalf("error reply = %v", err)
+	}
+}
+
+func TestConnAuthError(t *testing.T) {
+	cli, svr := net.Pipe()
+	defer cli.Close()
+	defer svr.Close()
+
+	go func() {
+		r := bufio.NewReader(svr)
+		readCommand(t, r)
+		svr.Write([]byte("-WRONGPASS invalid username-password pair\r\n"))
+	}()
+
+	if _, err := NewConn(cli, url.UserPassword("user", "pass"), 0); err == nil {
+		t.Fatalf("NewConn must fail on auth error")
+	}
+}
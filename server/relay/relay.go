@@ -0,0 +1,295 @@
+// Package relay : game->hubのイベントをRedis pub/sub経由でも中継する.
+//
+// gameはHubのクライアントに送るイベントを、websocketに加えて "{prefix}{clientId}" チャネルにも
+// sequence number付きのフレームとしてPUBLISHする.
+// hubはこれをSUBSCRIBEしておき、gameとのwebsocketが切れている間も観戦者への配信を続ける.
+// 重複や欠落はsequence numberで判定し、欠落分はwebsocketの再接続時に再送される.
+//
+// Primaryの設定ではRedisが主経路になる. hubはRedisで受け取ったイベントをEventAckでgameに通知し、
+// gameはFallbackDelayの間に通知されなかったイベントだけをwebsocketで送る.
+package relay
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"wsnet2/binary"
+	"wsnet2/config"
+	"wsnet2/log"
+	"wsnet2/metrics"
+	"wsnet2/redis"
+)
+
+const (
+	retryIntervalMin = time.Second
+	retryIntervalMax = time.Minute
+)
+
+// backoff : 再接続の間隔. 接続できたらresetで最小に戻す
+type backoff struct {
+	interval time.Duration
+}
+
+// next : 次の再接続までの間隔. 失敗が続くたびに倍にする
+func (b *backoff) next() time.Duration {
+	if b.interval == 0 {
+		b.interval = retryIntervalMin
+	} else {
+		b.interval *= 2
+		if b.interval > retryIntervalMax {
+			b.interval = retryIntervalMax
+		}
+	}
+	return b.interval
+}
+
+func (b *backoff) reset() {
+	b.interval = 0
+}
+
+// serveLoop : serveを接続が切れるたびに間隔をあけて呼び直す. ctxが終了するまで返らない.
+// serveは接続できたらconnectedを呼ぶ.
+func serveLoop(ctx context.Context, name string, serve func(ctx context.Context, connected func()) error) error {
+	var bo backoff
+	for {
+		err := serve(ctx, bo.reset)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		interval := bo.next()
+		log.Errorf("%v: %+v (retry after %v)", name, err, interval)
+
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+type message struct {
+	channel string
+	data    []byte
+}
+
+// Publisher : gameからHubのクライアントへのイベントをRedisにPUBLISHする
+type Publisher struct {
+	conf  *config.RelayConf
+	queue chan message
+}
+
+// NewPublisher : confからPublisherを作る. RedisURLが空ならnilを返す
+func NewPublisher(conf *config.RelayConf) *Publisher {
+	if conf.RedisURL == "" {
+		return nil
+	}
+	return &Publisher{
+		conf:  conf,
+		queue: make(chan message, conf.QueueSize),
+	}
+}
+
+// Relay : Hubのクライアントに送るイベントを配信キューに入れる. キューが溢れていたら捨てる.
+// 捨てたイベントもwebsocketで届くので、Hubでの配信が遅れるだけ.
+func (p *Publisher) Relay(clientId string, seq int, ev *binary.RegularEvent) {
+	m := message{
+		channel: p.conf.ChannelPrefix + clientId,
+		data:    ev.Marshal(seq),
+	}
+	select {
+	case p.queue <- m:
+	default:
+		metrics.RelayDropped.Add(1)
+	}
+}
+
+// FallbackDelay : Primaryのとき、websocketでイベントを送るまで待つ時間. Primaryでなければ0
+func (p *Publisher) FallbackDelay() time.Duration {
+	if !p.conf.Primary {
+		return 0
+	}
+	return time.Duration(p.conf.FallbackDelay)
+}
+
+// Serve : Redisに接続してキューのイベントをPUBLISHする. ctxが終了するまで返らない.
+func (p *Publisher) Serve(ctx context.Context) error {
+	return serveLoop(ctx, "relay publisher", p.serve)
+}
+
+func (p *Publisher) serve(ctx context.Context, connected func()) error {
+	conn, err := redis.Dial(ctx, p.conf.RedisURL)
+	if err != nil {
+		return xerrors.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+	log.Infof("relay publisher: connected to %v", p.conf.RedisURL)
+	connected()
+
+	// PUBLISHの応答 (購読者数) は読み捨てる
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := conn.ReadReply(); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	publish := []byte("PUBLISH")
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-readErr:
+			return xerrors.Errorf("read: %w", err)
+		case m := <-p.queue:
+			if err := conn.Send(publish, []byte(m.channel), m.data); err != nil {
+				return xerrors.Errorf("publish: %w", err)
+			}
+			// キューが空になるまでまとめて送る
+			if len(p.queue) == 0 {
+				if err := conn.Flush(); err != nil {
+					return xerrors.Errorf("flush: %w", err)
+				}
+			}
+		}
+	}
+}
+
+// Subscriber : hubでgameからのイベントをRedisからSUBSCRIBEする
+type Subscriber struct {
+	conf *config.RelayConf
+
+	mu       sync.Mutex
+	handlers map[string]func(data []byte)
+	conn     *redis.Conn // 接続中のみ
+}
+
+// NewSubscriber : confからSubscriberを作る. RedisURLが空ならnilを返す
+func NewSubscriber(conf *config.RelayConf) *Subscriber {
+	if conf.RedisURL == "" {
+		return nil
+	}
+	return &Subscriber{
+		conf:     conf,
+		handlers: make(map[string]func([]byte)),
+	}
+}
+
+// Primary : Redisが主経路なら、受け取ったイベントをEventAckでgameに通知する
+func (s *Subscriber) Primary() bool {
+	return s.conf.Primary
+}
+
+// Subscribe : clientId宛てのイベントをhandlerで受け取る.
+// handlerはRedisからの受信goroutineで呼ばれるのでブロックしないこと.
+func (s *Subscriber) Subscribe(clientId string, handler func(data []byte)) {
+	ch := s.conf.ChannelPrefix + clientId
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[ch] = handler
+	if s.conn != nil {
+		// 失敗したときは受信側で切断を検知して再接続し、購読し直す
+		s.conn.Send([]byte("SUBSCRIBE"), []byte(ch))
+		s.conn.Flush()
+	}
+}
+
+// Unsubscribe : clientId宛てのイベントの購読をやめる
+func (s *Subscriber) Unsubscribe(clientId string) {
+	ch := s.conf.ChannelPrefix + clientId
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.handlers, ch)
+	if s.conn != nil {
+		s.conn.Send([]byte("UNSUBSCRIBE"), []byte(ch))
+		s.conn.Flush()
+	}
+}
+
+// Serve : Redisに接続して購読中のチャネルのメッセージをhandlerに渡す. ctxが終了するまで返らない.
+func (s *Subscriber) Serve(ctx context.Context) error {
+	return serveLoop(ctx, "relay subscriber", s.serve)
+}
+
+func (s *Subscriber) serve(ctx context.Context, connected func()) error {
+	conn, err := redis.Dial(ctx, s.conf.RedisURL)
+	if err != nil {
+		return xerrors.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+	log.Infof("relay subscriber: connected to %v", s.conf.RedisURL)
+
+	if err := s.attach(conn); err != nil {
+		return xerrors.Errorf("subscribe: %w", err)
+	}
+	defer s.detach()
+	connected()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	for {
+		reply, err := conn.ReadReply()
+		if err != nil {
+			return xerrors.Errorf("read: %w", err)
+		}
+		// ["message", channel, data]. subscribe/unsubscribeの応答は無視する
+		arr, ok := reply.([]any)
+		if !ok || len(arr) != 3 {
+			continue
+		}
+		kind, _ := arr[0].([]byte)
+		ch, _ := arr[1].([]byte)
+		data, _ := arr[2].([]byte)
+		if string(kind) != "message" {
+			continue
+		}
+		s.mu.Lock()
+		h := s.handlers[string(ch)]
+		s.mu.Unlock()
+		if h != nil {
+			h(data)
+		}
+	}
+}
+
+// attach : 購読中のチャネルをまとめてSUBSCRIBEし、以降のSubscribeでconnを使う
+func (s *Subscriber) attach(conn *redis.Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.handlers) > 0 {
+		args := make([][]byte, 0, len(s.handlers)+1)
+		args = append(args, []byte("SUBSCRIBE"))
+		for ch := range s.handlers {
+			args = append(args, []byte(ch))
+		}
+		if err := conn.Send(args...); err != nil {
+			return err
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *Subscriber) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = nil
+}
@@ -0,0 +1,27 @@
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	var b backoff
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if d := b.next(); d != want {
+			t.Fatalf("next = %v, wants %v", d, want)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		b.next()
+	}
+	if d := b.next(); d != retryIntervalMax {
+		t.Fatalf("next = %v, wants %v", d, retryIntervalMax)
+	}
+
+	// 接続できたら最小の間隔から数え直す
+	b.reset()
+	if d := b.next(); d != retryIntervalMin {
+		t.Fatalf("next after reset = %v, wants %v", d, retryIntervalMin)
+	}
+}
@@ -0,0 +1,135 @@
+// Package requestid : 1つのリクエストや接続を lobby -> game/hub まで追跡するためのID.
+//
+// LobbyでHTTPリクエスト毎にIDを作り (クライアントが Header を付けていればそれを使う)、
+// レスポンスヘッダで返すとともに、gRPCのmetadataとwebsocketの中継のヘッダでgame/hubに渡す.
+// 各サーバはIDをログに付けるので、1人のプレイヤーの問題をサーバを跨いで追える.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// Header : リクエストIDのHTTPヘッダ. リクエストとレスポンスの両方に使う
+	Header = "Wsnet2-Request-Id"
+	// MetadataKey : リクエストIDのgRPCのmetadataのキー
+	MetadataKey = "wsnet2-request-id"
+
+	// maxLen : クライアントから受け取るIDの長さの上限
+	maxLen = 64
+)
+
+type ctxKey struct{}
+
+// New : 新しいリクエストIDを作る
+func New() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewContext : idを持つcontextを返す
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext : contextのリクエストID. 無ければ空文字列
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// valid : ログやヘッダに出しても安全なIDか
+func valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case '0' <= c && c <= '9', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware : リクエストのIDをcontextとリクエストヘッダに設定し、レスポンスヘッダで返す.
+// リクエストに正しい形式のIDが付いていればそれを使い、無ければ作る.
+// リクエストヘッダにも設定するので、websocketの中継先にもIDが渡る.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !valid(id) {
+			id = New()
+			r.Header.Set(Header, id)
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// UpgradeHeader : websocketのUpgradeのレスポンスに付けるヘッダ.
+// UpgraderはResponseWriterのヘッダを使わないので、Middlewareで設定したIDをこれで渡す.
+func UpgradeHeader(ctx context.Context) http.Header {
+	id := FromContext(ctx)
+	if id == "" {
+		return nil
+	}
+	return http.Header{Header: []string{id}}
+}
+
+// UnaryClientInterceptor : contextのIDをgRPCのmetadataに付ける
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+}
+
+// StreamClientInterceptor : contextのIDをgRPCのmetadataに付ける
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(outgoing(ctx), desc, cc, method, opts...)
+}
+
+func outgoing(ctx context.Context) context.Context {
+	if id := FromContext(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, MetadataKey, id)
+	}
+	return ctx
+}
+
+// UnaryServerInterceptor : gRPCのmetadataのIDをcontextに設定する. 無ければ作る
+func UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(incoming(ctx), req)
+}
+
+// StreamServerInterceptor : gRPCのmetadataのIDをstreamのcontextに設定する. 無ければ作る
+func StreamServerInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &serverStream{ss, incoming(ss.Context())})
+}
+
+func incoming(ctx context.Context) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(MetadataKey); len(v) > 0 {
+			id = v[0]
+		}
+	}
+	if !valid(id) {
+		id = New()
+	}
+	return NewContext(ctx, id)
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
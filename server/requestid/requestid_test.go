@@ -0,0 +1,77 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestMiddleware(t *testing.T) {
+	var got, forwarded string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+		forwarded = r.Header.Get(Header)
+	}))
+
+	tests := []struct {
+		header string
+		keep   bool
+	}{
+		{"abc-123_X.y", true},
+		{"", false},
+		{"has space", false},
+		{strings.Repeat("a", maxLen+1), false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			req.Header.Set(Header, tt.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if got == "" || got != forwarded || w.Header().Get(Header) != got {
+			t.Errorf("%q: context=%q, request=%q, response=%q", tt.header, got, forwarded, w.Header().Get(Header))
+		}
+		if (got == tt.header) != tt.keep {
+			t.Errorf("%q: id = %q, keep = %v", tt.header, got, tt.keep)
+		}
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	ctx := NewContext(context.Background(), "req-1")
+
+	var md metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	if err := UnaryClientInterceptor(ctx, "/m", nil, nil, nil, invoker); err != nil {
+		t.Fatalf("UnaryClientInterceptor: %v", err)
+	}
+	if v := md.Get(MetadataKey); len(v) != 1 || v[0] != "req-1" {
+		t.Fatalf("metadata = %v", md)
+	}
+
+	var id string
+	handler := func(ctx context.Context, req any) (any, error) {
+		id = FromContext(ctx)
+		return nil, nil
+	}
+	UnaryServerInterceptor(metadata.NewIncomingContext(context.Background(), md), nil, nil, handler)
+	if id != "req-1" {
+		t.Fatalf("server id = %q, wants %q", id, "req-1")
+	}
+
+	// metadataが無ければ作る
+	UnaryServerInterceptor(context.Background(), nil, nil, handler)
+	if id == "" || id == "req-1" {
+		t.Fatalf("server id = %q, wants new id", id)
+	}
+}
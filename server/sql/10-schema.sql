@@ -6,8 +6,10 @@ CREATE TABLE `game_server` (
   `public_name` VARCHAR(191) NOT NULL,
   `grpc_port`   INTEGER NOT NULL,
   `ws_port`     INTEGER NOT NULL,
+  `ws_url`      VARCHAR(191) NOT NULL DEFAULT '',
   `status`      TINYINT NOT NULL,
   `heartbeat`   BIGINT,
+  `tag`         VARCHAR(32) NOT NULL DEFAULT '',
   UNIQUE KEY `idx_hostname` (`hostname`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
@@ -18,16 +20,23 @@ CREATE TABLE `hub_server` (
   `public_name` VARCHAR(191) NOT NULL,
   `grpc_port`   INTEGER NOT NULL,
   `ws_port`     INTEGER NOT NULL,
+  `ws_url`      VARCHAR(191) NOT NULL DEFAULT '',
   `status`      TINYINT NOT NULL,
   `heartbeat`   BIGINT,
+  `watchers`    INTEGER UNSIGNED NOT NULL DEFAULT 0,
+  `rooms`       INTEGER UNSIGNED NOT NULL DEFAULT 0,
+  `bandwidth`   BIGINT UNSIGNED NOT NULL DEFAULT 0,
   UNIQUE KEY `idx_hostname` (`hostname`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 DROP TABLE IF EXISTS `app`;
 CREATE TABLE app (
-  `id`   VARCHAR(32) COLLATE ascii_bin PRIMARY KEY,
-  `name` VARCHAR(191) COLLATE utf8mb4_bin,
-  `key`  VARCHAR(191) COLLATE ascii_bin
+  `id`             VARCHAR(32) COLLATE ascii_bin PRIMARY KEY,
+  `name`           VARCHAR(191) COLLATE utf8mb4_bin,
+  `key`            VARCHAR(191) COLLATE ascii_bin,
+  `old_key`        VARCHAR(191) COLLATE ascii_bin NOT NULL DEFAULT '',
+  `old_key_expire` DATETIME,
+  `disabled`       TINYINT NOT NULL DEFAULT 0
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 DROP TABLE IF EXISTS `room`;
@@ -43,12 +52,82 @@ CREATE TABLE room (
   `max_players` INTEGER UNSIGNED NOT NULL,
   `players` INTEGER UNSIGNED NOT NULL,
   `watchers` INTEGER UNSIGNED NOT NULL,
+  `max_watchers` INTEGER UNSIGNED NOT NULL DEFAULT 0,
   `props` BLOB,
   `created` DATETIME,
   UNIQUE KEY `idx_number` (`number`),
   KEY `idx_search_group` (`app_id`, `search_group`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
+DROP TABLE IF EXISTS `room_session`;
+CREATE TABLE room_session (
+  `room_id` VARCHAR(32) PRIMARY KEY,
+  `host_id` INTEGER UNSIGNED NOT NULL,
+  `master_id` VARCHAR(32) NOT NULL,
+  `deadline` INTEGER UNSIGNED NOT NULL,
+  `watcher_delay` INTEGER UNSIGNED NOT NULL,
+  `max_bandwidth` INTEGER UNSIGNED NOT NULL,
+  `history_size` INTEGER UNSIGNED NOT NULL,
+  `rejoin_policy` INTEGER UNSIGNED NOT NULL,
+  `log_level` INTEGER UNSIGNED NOT NULL,
+  `lifetime` INTEGER UNSIGNED NOT NULL DEFAULT 0,
+  `close_on_master_leave` TINYINT NOT NULL DEFAULT 0,
+  `slow_consumer_policy` INTEGER UNSIGNED NOT NULL DEFAULT 0,
+  `ping_interval` INTEGER UNSIGNED NOT NULL DEFAULT 0,
+  `stale_threshold` INTEGER UNSIGNED NOT NULL DEFAULT 0,
+  `auto_promote_watchers` TINYINT NOT NULL DEFAULT 0,
+  `watch_only` TINYINT NOT NULL DEFAULT 0,
+  `lobby_room` TINYINT NOT NULL DEFAULT 0,
+  `parent_room` VARCHAR(32) NOT NULL DEFAULT '',
+  `private_props` BLOB,
+  `updated` DATETIME NOT NULL,
+  KEY `host_id` (`host_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+DROP TABLE IF EXISTS `client_session`;
+CREATE TABLE client_session (
+  `room_id` VARCHAR(32) NOT NULL,
+  `client_id` VARCHAR(32) NOT NULL,
+  `is_player` TINYINT NOT NULL,
+  `is_hub` TINYINT NOT NULL,
+  `is_bot` TINYINT NOT NULL DEFAULT 0,
+  `props` BLOB,
+  `mac_key` VARCHAR(191) NOT NULL,
+  `mac_algorithm` VARCHAR(16) NOT NULL DEFAULT 'hmac-sha1',
+  `auth_key` VARCHAR(191) NOT NULL,
+  `ev_seq` INTEGER UNSIGNED NOT NULL,
+  `msg_seq` INTEGER UNSIGNED NOT NULL,
+  PRIMARY KEY (`room_id`, `client_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+DROP TABLE IF EXISTS `room_number_seq`;
+CREATE TABLE `room_number_seq` (
+  `id`  TINYINT UNSIGNED NOT NULL PRIMARY KEY,
+  `seq` BIGINT UNSIGNED NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+INSERT INTO `room_number_seq` (`id`, `seq`) VALUES (1, 0);
+
+DROP TABLE IF EXISTS `app_feature_flag`;
+CREATE TABLE `app_feature_flag` (
+  `app_id`     VARCHAR(32) NOT NULL,
+  `name`       VARCHAR(64) COLLATE ascii_bin NOT NULL,
+  `percentage` TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  PRIMARY KEY (`app_id`, `name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+DROP TABLE IF EXISTS `room_ticket`;
+CREATE TABLE `room_ticket` (
+  `id`       CHAR(32) NOT NULL PRIMARY KEY,
+  `app_id`   VARCHAR(32) NOT NULL,
+  `user_id`  VARCHAR(191) NOT NULL,
+  `status`   TINYINT NOT NULL DEFAULT 0,
+  `room`     MEDIUMBLOB,
+  `err_type` INT NOT NULL DEFAULT 0,
+  `err_msg`  TEXT,
+  `created`  DATETIME NOT NULL,
+  KEY `idx_created` (`created`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
 DROP TABLE IF EXISTS `room_history`;
 CREATE TABLE `room_history` (
   `id` BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
@@ -66,15 +145,50 @@ CREATE TABLE `room_history` (
   KEY `created` (`created`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
+DROP TABLE IF EXISTS `room_successor`;
+CREATE TABLE `room_successor` (
+  `room_id` VARCHAR(32) PRIMARY KEY,
+  `successor_id` VARCHAR(32) NOT NULL,
+  `app_id` VARCHAR(32) NOT NULL,
+  `created` DATETIME NOT NULL,
+  KEY `successor_id` (`successor_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
 DROP TABLE IF EXISTS `player_log`;
 CREATE TABLE player_log (
-  `id`        BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
-  `room_id`   VARCHAR(32) NOT NULL,
-  `player_id` VARCHAR(32) NOT NULL,
-  `message`   VARCHAR(32) NOT NULL,
-  `datetime`  DATETIME,
+  `id`          BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+  `app_id`      VARCHAR(32) NOT NULL DEFAULT '',
+  `room_id`     VARCHAR(32) NOT NULL,
+  `player_id`   VARCHAR(32) NOT NULL,
+  `message`     VARCHAR(32) NOT NULL,
+  `platform`    VARCHAR(32) NOT NULL DEFAULT '',
+  `app_version` VARCHAR(32) NOT NULL DEFAULT '',
+  `device`      VARCHAR(64) NOT NULL DEFAULT '',
+  `request_id`  VARCHAR(64) NOT NULL DEFAULT '',
+  `datetime`    DATETIME,
+  KEY `room_id` (`room_id`),
+  KEY `player_id` (`player_id`),
+  KEY `app_id_datetime` (`app_id`, `datetime`),
+  KEY `app_version` (`app_version`),
+  KEY `datetime` (`datetime`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+DROP TABLE IF EXISTS `player_stats`;
+CREATE TABLE player_stats (
+  `id`            BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+  `app_id`        VARCHAR(32) NOT NULL DEFAULT '',
+  `room_id`       VARCHAR(32) NOT NULL,
+  `player_id`     VARCHAR(32) NOT NULL,
+  `room_duration` INTEGER UNSIGNED NOT NULL DEFAULT 0,
+  `stay`          INTEGER UNSIGNED NOT NULL DEFAULT 0,
+  `msg_sent`      BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  `ev_received`   BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  `disconnects`   INTEGER UNSIGNED NOT NULL DEFAULT 0,
+  `closed`        DATETIME,
   KEY `room_id` (`room_id`),
-  KEY `player_id` (`player_id`)
+  KEY `player_id` (`player_id`),
+  KEY `app_id_closed` (`app_id`, `closed`),
+  KEY `closed` (`closed`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 DROP TABLE IF EXISTS `hub`;
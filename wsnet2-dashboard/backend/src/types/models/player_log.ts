@@ -6,9 +6,13 @@ export const playerLogModel = objectType({
   description: player_log.$description,
   definition(t) {
     t.field(player_log.id);
+    t.field(player_log.app_id);
     t.field(player_log.room_id);
     t.field(player_log.player_id);
     t.field(player_log.message);
+    t.field(player_log.platform);
+    t.field(player_log.app_version);
+    t.field(player_log.device);
     t.field(player_log.datetime);
   },
 });
@@ -2,5 +2,6 @@ export * from "./appResolver";
 export * from "./gameServerResolver";
 export * from "./hubResolver";
 export * from "./hubServerResolver";
+export * from "./playerLogResolver";
 export * from "./roomResolver";
 export * from "./roomHistoryResolver";
@@ -0,0 +1,71 @@
+import { extendType, stringArg, intArg, arg } from "nexus";
+import { Context } from "../../context";
+
+interface IConditions {
+  room_id?: string;
+  player_id?: string;
+  platform?: string;
+  app_version?: string;
+  device?: string;
+  datetime?: {
+    lte?: Date;
+    gte?: Date;
+  };
+}
+
+export const playerLogQuery = extendType({
+  type: "Query",
+  definition(t) {
+    t.list.field("playerLogs", {
+      type: "player_log",
+      description: "Get player logs filtered by player and client environment",
+      args: {
+        room_id: stringArg(),
+        player_id: stringArg(),
+        platform: stringArg(),
+        app_version: stringArg(),
+        device: stringArg(),
+        before: arg({
+          type: "DateTime",
+        }),
+        after: arg({
+          type: "DateTime",
+        }),
+        limit: intArg(),
+      },
+      resolve(
+        _,
+        {
+          room_id,
+          player_id,
+          platform,
+          app_version,
+          device,
+          before,
+          after,
+          limit,
+        },
+        ctx: Context
+      ) {
+        const conditions: IConditions = {};
+        if (room_id != null) conditions.room_id = String(room_id);
+        if (player_id != null) conditions.player_id = String(player_id);
+        if (platform != null) conditions.platform = String(platform);
+        if (app_version != null) conditions.app_version = String(app_version);
+        if (device != null) conditions.device = String(device);
+        if (after != null || before != null) {
+          conditions.datetime = {};
+          if (after != null) conditions.datetime.gte = new Date(String(after));
+          if (before != null)
+            conditions.datetime.lte = new Date(String(before));
+        }
+
+        return ctx.prisma.player_log.findMany({
+          where: conditions,
+          orderBy: { id: "desc" },
+          take: limit != null ? Number(limit) : undefined,
+        });
+      },
+    });
+  },
+});
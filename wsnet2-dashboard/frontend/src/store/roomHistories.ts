@@ -28,6 +28,9 @@ export interface RoomHistory {
 export interface PlayerLog {
   player_id: string;
   message: string;
+  platform: string;
+  app_version: string;
+  device: string;
   datetime: string;
 }
 
@@ -101,6 +104,9 @@ class RoomHistoriesModule extends VuexModule {
             player_logs {
               player_id
               message
+              platform
+              app_version
+              device
               datetime
             }
           }
@@ -276,6 +276,37 @@ namespace WSNet2.Core.Test
             Assert.AreEqual(v, r);
         }
 
+        [TestCase(0L, new byte[] { (byte)Type.Timestamp, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00 })]
+        [TestCase(-1L, new byte[] { (byte)Type.Timestamp, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff })]
+        [TestCase(1700000000123L, new byte[] { (byte)Type.Timestamp, 0x80, 0x00, 0x01, 0x8b, 0xcf, 0xe5, 0x68, 0x7b })]
+        public void TestDateTimeOffset(long ms, byte[] expect)
+        {
+            var v = DateTimeOffset.FromUnixTimeMilliseconds(ms).ToOffset(TimeSpan.FromHours(9));
+            writer.Write(v);
+            Assert.AreEqual(expect, writer.ArraySegment());
+
+            var reader = WSNet2Serializer.NewReader(writer.ArraySegment());
+            var r = reader.ReadDateTimeOffset();
+            Assert.AreEqual(v, r);
+            Assert.AreEqual(TimeSpan.Zero, r.Offset);
+        }
+
+        [TestCase("00000000-0000-0000-0000-000000000000",
+                  new byte[] { (byte)Type.UUID, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0 })]
+        [TestCase("123e4567-e89b-12d3-a456-426614174000",
+                  new byte[] { (byte)Type.UUID, 0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3,
+                               0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00 })]
+        public void TestGuid(string s, byte[] expect)
+        {
+            var v = Guid.Parse(s);
+            writer.Write(v);
+            Assert.AreEqual(expect, writer.ArraySegment());
+
+            var reader = WSNet2Serializer.NewReader(writer.ArraySegment());
+            var r = reader.ReadGuid();
+            Assert.AreEqual(v, r);
+        }
+
         [TestCase("", new byte[] { (byte)Type.Str8, 0 })]
         [TestCase("abc", new byte[] { (byte)Type.Str8, 3, 0x61, 0x62, 0x63 })]
         [TestCase("あ", new byte[] { (byte)Type.Str8, 3, 0xe3, 0x81, 0x82 })]
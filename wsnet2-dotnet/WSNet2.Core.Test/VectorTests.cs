@@ -0,0 +1,208 @@
+using NUnit.Framework;
+using System;
+using System.Collections;
+using System.Collections.Generic;
+using System.IO;
+using System.Linq;
+using System.Text.Json;
+
+namespace WSNet2.Core.Test
+{
+    /// <summary>
+    ///   テストベクタのObj. 中身は任意の値の並び
+    /// </summary>
+    class VectorObj : IWSNet2Serializable, IEquatable<VectorObj>
+    {
+        public List<object> Body = new List<object>();
+
+        public void Serialize(SerialWriter writer)
+        {
+            foreach (var v in Body)
+            {
+                VectorTests.Write(writer, v);
+            }
+        }
+
+        public void Deserialize(SerialReader reader, int size)
+        {
+            Body.Clear();
+            var end = reader.GetRest().Count - size;
+            while (reader.GetRest().Count > end)
+            {
+                Body.Add(reader.Read());
+            }
+        }
+
+        public bool Equals(VectorObj o)
+        {
+            return o != null && Body.SequenceEqual(o.Body);
+        }
+
+        public override bool Equals(object o)
+        {
+            return Equals(o as VectorObj);
+        }
+
+        public override int GetHashCode()
+        {
+            return Body.Count;
+        }
+
+        public override string ToString()
+        {
+            return string.Format("VectorObj:[{0}]", string.Join(",", Body));
+        }
+    }
+
+    /// <summary>
+    ///   サーバの適合性テストベクタ (server/binary/vectors.json) との相互変換.
+    ///   ベクタの生成は server の make vectors を参照
+    /// </summary>
+    public class VectorTests
+    {
+        /// <summary>ベクタのObjのClassID</summary>
+        const byte VectorClassID = 1;
+
+        [OneTimeSetUp]
+        public void OneTimeSetUp()
+        {
+            WSNet2Serializer.Register<VectorObj>(VectorClassID);
+        }
+
+        static IEnumerable<TestCaseData> Vectors()
+        {
+            var path = Path.Combine(AppContext.BaseDirectory, "vectors.json");
+            using var doc = JsonDocument.Parse(File.ReadAllText(path));
+            foreach (var v in doc.RootElement.EnumerateArray())
+            {
+                var name = v.GetProperty("name").GetString();
+                var hex = v.GetProperty("hex").GetString();
+                var value = v.GetProperty("value");
+                yield return new TestCaseData(
+                    value.GetProperty("type").GetString(),
+                    Convert.FromHexString(hex),
+                    Expected(value)).SetName("Vector_" + name);
+            }
+        }
+
+        [TestCaseSource(nameof(Vectors))]
+        public void TestVector(string type, byte[] data, object expect)
+        {
+            var reader = WSNet2Serializer.NewReader(new ArraySegment<byte>(data));
+            object actual;
+            switch (type)
+            {
+                case "Char":
+                    actual = reader.ReadChar();
+                    break;
+                case "Chars":
+                    actual = reader.ReadChars();
+                    break;
+                default:
+                    actual = reader.Read();
+                    break;
+            }
+            Assert.AreEqual(expect, actual);
+            Assert.AreEqual(0, reader.GetRest().Count);
+
+            var writer = WSNet2Serializer.NewWriter();
+            Write(writer, expect);
+            Assert.AreEqual(data, writer.ArraySegment());
+        }
+
+        /// <summary>
+        ///   VectorValueをC#の値にする
+        /// </summary>
+        static object Expected(JsonElement v)
+        {
+            var value = v.GetProperty("value");
+            switch (v.GetProperty("type").GetString())
+            {
+                case "Null": return null;
+                case "True": return true;
+                case "False": return false;
+                case "SByte": return value.GetSByte();
+                case "Byte": return value.GetByte();
+                case "Char": return (char)value.GetUInt16();
+                case "Short": return value.GetInt16();
+                case "UShort": return value.GetUInt16();
+                case "Int": return value.GetInt32();
+                case "UInt": return value.GetUInt32();
+                case "Long": return value.GetInt64();
+                case "ULong": return value.GetUInt64();
+                case "Float": return value.GetSingle();
+                case "Double": return value.GetDouble();
+                case "Str8":
+                case "Str16":
+                    return value.GetString();
+                case "Timestamp": return DateTimeOffset.FromUnixTimeMilliseconds(value.GetInt64());
+                case "UUID": return new Guid(value.GetString());
+                case "Obj":
+                    Assert.AreEqual(VectorClassID, value.GetProperty("class_id").GetByte());
+                    return new VectorObj { Body = value.GetProperty("body").EnumerateArray().Select(Expected).ToList() };
+                case "List": return value.EnumerateArray().Select(Expected).ToList();
+                case "Dict": return value.EnumerateObject().ToDictionary(p => p.Name, p => Expected(p.Value));
+                case "Bools": return value.EnumerateArray().Select(e => e.GetBoolean()).ToArray();
+                case "SBytes": return value.EnumerateArray().Select(e => e.GetSByte()).ToArray();
+                case "Bytes": return value.EnumerateArray().Select(e => e.GetByte()).ToArray();
+                case "Chars": return value.EnumerateArray().Select(e => (char)e.GetUInt16()).ToArray();
+                case "Shorts": return value.EnumerateArray().Select(e => e.GetInt16()).ToArray();
+                case "UShorts": return value.EnumerateArray().Select(e => e.GetUInt16()).ToArray();
+                case "Ints": return value.EnumerateArray().Select(e => e.GetInt32()).ToArray();
+                case "UInts": return value.EnumerateArray().Select(e => e.GetUInt32()).ToArray();
+                case "Longs": return value.EnumerateArray().Select(e => e.GetInt64()).ToArray();
+                case "ULongs": return value.EnumerateArray().Select(e => e.GetUInt64()).ToArray();
+                case "Floats": return value.EnumerateArray().Select(e => e.GetSingle()).ToArray();
+                case "Doubles": return value.EnumerateArray().Select(e => e.GetDouble()).ToArray();
+                default:
+                    Assert.Fail("unknown vector type: {0}", v.GetProperty("type").GetString());
+                    return null;
+            }
+        }
+
+        /// <summary>
+        ///   値の型に合ったWriteを呼ぶ
+        /// </summary>
+        public static void Write(SerialWriter writer, object v)
+        {
+            switch (v)
+            {
+                case null: writer.Write(); break;
+                case bool e: writer.Write(e); break;
+                case sbyte e: writer.Write(e); break;
+                case byte e: writer.Write(e); break;
+                case char e: writer.Write(e); break;
+                case short e: writer.Write(e); break;
+                case ushort e: writer.Write(e); break;
+                case int e: writer.Write(e); break;
+                case uint e: writer.Write(e); break;
+                case long e: writer.Write(e); break;
+                case ulong e: writer.Write(e); break;
+                case float e: writer.Write(e); break;
+                case double e: writer.Write(e); break;
+                case string e: writer.Write(e); break;
+                case DateTimeOffset e: writer.Write(e); break;
+                case Guid e: writer.Write(e); break;
+                case VectorObj e: writer.Write(e); break;
+                case IDictionary<string, object> e: writer.Write(e); break;
+                case bool[] e: writer.Write(e); break;
+                // CLRではbyte[]とsbyte[]などが相互にマッチするので型を厳密に比べる
+                case sbyte[] e when v.GetType() == typeof(sbyte[]): writer.Write(e); break;
+                case byte[] e when v.GetType() == typeof(byte[]): writer.Write(e); break;
+                case char[] e: writer.Write(e); break;
+                case short[] e when v.GetType() == typeof(short[]): writer.Write(e); break;
+                case ushort[] e when v.GetType() == typeof(ushort[]): writer.Write(e); break;
+                case int[] e when v.GetType() == typeof(int[]): writer.Write(e); break;
+                case uint[] e when v.GetType() == typeof(uint[]): writer.Write(e); break;
+                case long[] e when v.GetType() == typeof(long[]): writer.Write(e); break;
+                case ulong[] e when v.GetType() == typeof(ulong[]): writer.Write(e); break;
+                case float[] e: writer.Write(e); break;
+                case double[] e: writer.Write(e); break;
+                case IEnumerable e: writer.Write(e); break;
+                default:
+                    Assert.Fail("unsupported value: {0}", v.GetType());
+                    break;
+            }
+        }
+    }
+}
@@ -1,5 +1,6 @@
 ﻿using System;
 using System.Collections.Concurrent;
+using System.Collections.Generic;
 using System.Net.WebSockets;
 using System.Security.Cryptography;
 using System.Threading.Tasks;
@@ -25,6 +26,7 @@ namespace WSNet2
 
         Uri uri;
         string authKey;
+        HashSet<WebSocketCloseStatus> noReconnectCloseCodes;
         HMAC hmac;
         volatile int pingInterval;
         volatile uint lastPingTime;
@@ -51,6 +53,19 @@ namespace WSNet2
             this.clientId = clientId;
             this.uri = new Uri(joined.url);
             this.authKey = joined.authKey;
+            this.noReconnectCloseCodes = new HashSet<WebSocketCloseStatus>();
+            if (joined.noReconnectCloseCodes != null && joined.noReconnectCloseCodes.Length > 0)
+            {
+                foreach (var code in joined.noReconnectCloseCodes)
+                {
+                    noReconnectCloseCodes.Add((WebSocketCloseStatus)code);
+                }
+            }
+            else
+            {
+                noReconnectCloseCodes.Add(WebSocketCloseStatus.NormalClosure);
+                noReconnectCloseCodes.Add(WebSocketCloseStatus.EndpointUnavailable);
+            }
             this.hmac = hmac;
             this.pingInterval = calcPingInterval(room.ClientDeadline);
             this.pingerDelayCanceller = new CancellationTokenSource();
@@ -276,16 +291,14 @@ namespace WSNet2
 
                     if (ret.CloseStatus.HasValue)
                     {
-                        switch (ret.CloseStatus.Value)
+                        // 再接続しないcloseコードはサーバから入室時に通知される
+                        if (noReconnectCloseCodes.Contains(ret.CloseStatus.Value))
                         {
-                            case WebSocketCloseStatus.NormalClosure:
-                            case WebSocketCloseStatus.EndpointUnavailable:
-                                // unreconnectable states.
-                                evBufPool.Add(buf);
-                                return new EvClosed(ret.CloseStatusDescription);
-                            default:
-                                throw new Exception("ws status:(" + ret.CloseStatus.Value + ") " + ret.CloseStatusDescription);
+                            // unreconnectable states.
+                            evBufPool.Add(buf);
+                            return new EvClosed(ret.CloseStatusDescription);
                         }
+                        throw new Exception("ws status:(" + ret.CloseStatus.Value + ") " + ret.CloseStatusDescription);
                     }
 
                     pos += ret.Count;
@@ -15,4 +15,20 @@
             NewMasterId = reader.ReadString();
         }
     }
+
+    /// <summary>
+    ///   マスタープレイヤーの移譲を打診されました
+    /// </summary>
+    public class EvMasterSwitchRequested : Event
+    {
+        public string MasterId { get; private set; }
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        public EvMasterSwitchRequested(SerialReader reader) : base(EvType.MasterSwitchRequested, reader)
+        {
+            MasterId = reader.ReadString();
+        }
+    }
 }
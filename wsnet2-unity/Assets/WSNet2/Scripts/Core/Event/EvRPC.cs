@@ -32,4 +32,28 @@
             RpcID = reader.ReadByte();
         }
     }
+
+    /// <summary>
+    ///   Appのサーバからのメッセージイベント
+    /// </summary>
+    public class EvServerMessage : Event
+    {
+        /// <summary>宛先 (部屋全体へのときは空文字列)</summary>
+        public string TargetID { get; private set; }
+
+        public SerialReader Reader { get { return reader; } }
+
+        /// <summary>
+        ///   コンストラクタ
+        /// </summary>
+        /// <remarks>
+        ///   <para>
+        ///     メッセージの中身は通知時にメインスレッドでデシリアライズする。
+        ///   </para>
+        /// </remarks>
+        public EvServerMessage(SerialReader reader) : base(EvType.ServerMessage, reader)
+        {
+            TargetID = reader.ReadString();
+        }
+    }
 }
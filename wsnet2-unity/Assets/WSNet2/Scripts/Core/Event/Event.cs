@@ -18,6 +18,9 @@ namespace WSNet2
         Message,
         Rejoined,
 
+        MasterSwitchRequested = EvTypeExt.regularEvType + 13,
+        ServerMessage,
+
         Succeeded = EvTypeExt.responseEvType,
         PermissionDenied,
         TargetNotFound,
@@ -98,6 +101,12 @@ namespace WSNet2
                 case EvType.Rejoined:
                     ev = new EvRejoined(reader);
                     break;
+                case EvType.MasterSwitchRequested:
+                    ev = new EvMasterSwitchRequested(reader);
+                    break;
+                case EvType.ServerMessage:
+                    ev = new EvServerMessage(reader);
+                    break;
 
                 case EvType.Succeeded:
                 case EvType.PermissionDenied:
@@ -9,6 +9,15 @@ namespace WSNet2
         [Key("id")]
         public string Id;
 
+        [Key("caps")]
+        public Capabilities Caps;
+
+        [Key("app_version")]
+        public string AppVersion;
+
+        [Key("device")]
+        public string Device;
+
         [Key("props")]
         public byte[] Props;
 
@@ -19,6 +28,7 @@ namespace WSNet2
         public ClientInfo(string id, IDictionary<string, object> props = null)
         {
             this.Id = id;
+            this.Caps = Capabilities.Default;
 
             var writer = WSNet2Serializer.GetWriter();
             lock (writer)
@@ -31,4 +41,34 @@ namespace WSNet2
             }
         }
     }
+
+    /// <summary>
+    /// 入室時にサーバへ申告する対応機能
+    /// </summary>
+    /// <remarks>
+    /// 部屋の他のクライアントからは Player.Props の "@caps" として参照できる
+    /// </remarks>
+    [MessagePackObject]
+    public class Capabilities
+    {
+        public static Capabilities Default => new Capabilities()
+        {
+            Compression = false,
+            Batch = false,
+            ProtocolVersion = 1,
+            Platform = "unity",
+        };
+
+        [Key("compression")]
+        public bool Compression;
+
+        [Key("batch")]
+        public bool Batch;
+
+        [Key("protocol_version")]
+        public uint ProtocolVersion;
+
+        [Key("platform")]
+        public string Platform;
+    }
 }
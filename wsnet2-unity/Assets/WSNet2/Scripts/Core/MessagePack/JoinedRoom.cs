@@ -22,5 +22,11 @@ namespace WSNet2
 
         [Key("deadline")]
         public uint deadline;
+
+        [Key("no_reconnect_close_codes")]
+        public uint[] noReconnectCloseCodes;
+
+        [Key("feature_flags")]
+        public string[] featureFlags;
     }
 }
@@ -14,6 +14,9 @@ namespace WSNet2
 
         [Key("emk")]
         public string encryptedMACKey;
+
+        [Key("latencies")]
+        public IDictionary<uint, uint> latencies;
     }
 
     [MessagePackObject]
@@ -27,6 +30,42 @@ namespace WSNet2
 
         [Key("emk")]
         public string encryptedMACKey;
+
+        [Key("latencies")]
+        public IDictionary<uint, uint> latencies;
+    }
+
+    [MessagePackObject]
+    public class JoinByInviteParam
+    {
+        [Key("token")]
+        public string token;
+
+        [Key("client")]
+        public ClientInfo clientInfo;
+
+        [Key("emk")]
+        public string encryptedMACKey;
+    }
+
+    [MessagePackObject]
+    public class InviteParam
+    {
+        [Key("user")]
+        public string userId;
+
+        [Key("expire")]
+        public uint expire;
+
+        [Key("reserve")]
+        public bool reserve;
+    }
+
+    [MessagePackObject]
+    public class WaitSeatParam
+    {
+        [Key("leave")]
+        public bool leave;
     }
 
     [MessagePackObject]
@@ -35,6 +74,9 @@ namespace WSNet2
         [Key("group")]
         public uint group;
 
+        [Key("groups")]
+        public uint[] groups;
+
         [Key("query")]
         public List<List<Query.Condition>> queries;
 
@@ -48,6 +90,31 @@ namespace WSNet2
         public bool checkWatchable;
     }
 
+    /// <summary>
+    ///   観戦可能な部屋一覧の並び順
+    /// </summary>
+    public enum WatchableSortKey : byte
+    {
+        Watchers = 0, // 観戦者数の多い順
+        Players = 1,  // プレイヤー数の多い順
+    }
+
+    [MessagePackObject]
+    public class SearchWatchableParam
+    {
+        [Key("groups")]
+        public uint[] groups;
+
+        [Key("query")]
+        public List<List<Query.Condition>> queries;
+
+        [Key("limit")]
+        public int limit;
+
+        [Key("sort")]
+        public byte sortBy;
+    }
+
     [MessagePackObject]
     public class SearchByIdsParam
     {
@@ -16,6 +16,85 @@ namespace WSNet2
 
         [Key("rooms")]
         public RoomInfo[] rooms;
+
+        [Key("invite")]
+        public Invitation invite;
+
+        [Key("probes")]
+        public ProbeTarget[] probes;
+
+        [Key("ticket")]
+        public RoomTicket ticket;
+
+        [Key("waiting")]
+        public SeatWaiting waiting;
+    }
+
+    /// <summary>
+    ///   満室の部屋の順番待ちの状態
+    /// </summary>
+    [MessagePackObject]
+    public class SeatWaiting
+    {
+        /// <summary>部屋ID</summary>
+        [Key("room_id")]
+        public string roomId;
+
+        /// <summary>順番. 0なら席を確保済みで、expireまでに入室する</summary>
+        [Key("position")]
+        public uint position;
+
+        /// <summary>次の問い合わせ、または入室の期限（unixtime）</summary>
+        [Key("expire")]
+        public long expire;
+    }
+
+    /// <summary>
+    ///   非同期の部屋作成のチケット
+    /// </summary>
+    [MessagePackObject]
+    public class RoomTicket
+    {
+        [Key("id")]
+        public string id;
+
+        /// <summary>結果を受け取れる期限（unixtime）</summary>
+        [Key("expire")]
+        public long expire;
+    }
+
+    /// <summary>
+    ///   RTT計測用のGameサーバのエンドポイント
+    /// </summary>
+    [MessagePackObject]
+    public class ProbeTarget
+    {
+        /// <summary>GameサーバのHostID</summary>
+        [Key("host_id")]
+        public uint hostId;
+
+        /// <summary>計測用URL（GETすると"pong"を返す）</summary>
+        [Key("url")]
+        public string url;
+    }
+
+    /// <summary>
+    ///   部屋への招待
+    /// </summary>
+    [MessagePackObject]
+    public class Invitation
+    {
+        /// <summary>招待トークン</summary>
+        [Key("token")]
+        public string token;
+
+        /// <summary>招待URL（Lobbyにinvite_url_formatが設定されているときのみ）</summary>
+        [Key("url")]
+        public string url;
+
+        /// <summary>有効期限（unixtime）</summary>
+        [Key("expire")]
+        public long expire;
     }
 
     public enum LobbyResponseType : byte
@@ -24,5 +103,6 @@ namespace WSNet2
         RoomLimit,
         NoRoomFound,
         RoomFull,
+        Pending,
     }
 }
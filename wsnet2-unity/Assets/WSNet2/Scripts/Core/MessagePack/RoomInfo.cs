@@ -38,6 +38,9 @@ namespace WSNet2
         [Key("watchers")]
         public uint watchers;
 
+        [Key("max_watchers")]
+        public uint maxWatchers;
+
         [Key("public_props")]
         public byte[] publicProps;
 
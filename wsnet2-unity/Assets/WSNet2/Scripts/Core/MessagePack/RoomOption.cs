@@ -50,6 +50,15 @@ namespace WSNet2
         [Key("log_level")]
         public LogLevel logLevel;
 
+        [Key("watcher_delay")]
+        public uint watcherDelay;
+
+        [Key("max_watchers")]
+        public uint maxWatchers;
+
+        [Key("max_bandwidth")]
+        public uint maxBandwidth;
+
         public RoomOption()
         {
         }
@@ -152,6 +161,45 @@ namespace WSNet2
             return this;
         }
 
+        /// <summary>
+        ///   最大観戦者数を設定する
+        /// </summary>
+        /// <param name="val">設定値</param>
+        /// <remarks>
+        ///   デフォルト0（上限なし）
+        /// </remarks>
+        public RoomOption WithMaxWatchers(uint val)
+        {
+            this.maxWatchers = val;
+            return this;
+        }
+
+        /// <summary>
+        ///   部屋の送受信帯域の上限を設定する
+        /// </summary>
+        /// <param name="bytesPerSec">設定値（bytes/sec）</param>
+        /// <remarks>
+        ///   デフォルト0（サーバ側の設定による）. サーバ側の上限を超える値は上限になる
+        /// </remarks>
+        public RoomOption WithMaxBandwidth(uint bytesPerSec)
+        {
+            this.maxBandwidth = bytesPerSec;
+            return this;
+        }
+
+        /// <summary>
+        ///   観戦者へのイベントの遅延を設定する
+        /// </summary>
+        /// <param name="sec">設定値（秒）</param>
+        /// <remarks>
+        ///   デフォルト0（遅延しない）. 上限はサーバ側の設定による
+        /// </remarks>
+        public RoomOption WithWatcherDelay(uint sec)
+        {
+            this.watcherDelay = sec;
+            return this;
+        }
+
         /// <summary>
         ///   部屋のLogLevelを設定する
         /// </summary>
@@ -12,6 +12,8 @@
         ToMaster,
         Broadcast,
         Kick,
+
+        AcceptMaster = MsgTypeExt.regularMsgType + 16,
     }
 
     static class MsgTypeExt
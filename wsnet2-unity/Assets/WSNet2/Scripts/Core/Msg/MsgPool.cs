@@ -127,6 +127,19 @@ namespace WSNet2
             }
         }
 
+        /// <summary>
+        ///   Master移譲の受諾メッセージを投下
+        /// </summary>
+        public int PostAcceptMaster()
+        {
+            lock (this)
+            {
+                var writer = writeMsgType(MsgType.AcceptMaster);
+                writer.AppendHMAC(hmac);
+                return sequenceNum;
+            }
+        }
+
         /// <summary>
         ///   RoomPorps変更メッセージを投下
         /// </summary>
@@ -591,6 +604,14 @@ namespace WSNet2
                         SequenceNum = reader.Get24(),
                         NewMaster = reader.ReadString(),
                     };
+                case MsgType.AcceptMaster:
+                    return new NetworkInformer.RoomSendAcceptMasterInfo()
+                    {
+                        BodySize = bodysize,
+                        RoomID = room.Id,
+                        MsgType = msgType,
+                        SequenceNum = reader.Get24(),
+                    };
                 case MsgType.Target:
                     seqnum = reader.Get24();
                     var targets = reader.ReadStrings();
@@ -146,6 +146,14 @@ namespace WSNet2
             public string NewMaster;
         }
 
+        /// <summary>
+        ///   Master移譲受諾送信情報
+        /// </summary>
+        [Serializable]
+        public class RoomSendAcceptMasterInfo : RoomSendInfo
+        {
+        }
+
         /// <summary>
         ///   RPC送信情報
         /// </summary>
@@ -306,6 +314,16 @@ namespace WSNet2
             public string NewMasterID;
         }
 
+        /// <summary>
+        ///   Master移譲打診受信情報
+        /// </summary>
+        [Serializable]
+        public class RoomReceiveMasterSwitchRequestedInfo : RoomReceiveInfo
+        {
+            /// <summary>現マスターID</summary>
+            public string MasterID;
+        }
+
         /// <summary>
         ///   RPC受信情報
         /// </summary>
@@ -560,6 +578,15 @@ namespace WSNet2
                             NewMasterID = evMasterSwitched.NewMasterId,
                         };
                         break;
+                    case EvMasterSwitchRequested evMasterSwitchRequested:
+                        info = new RoomReceiveMasterSwitchRequestedInfo()
+                        {
+                            BodySize = bodySize,
+                            RoomID = room.Id,
+                            EvType = ev.Type,
+                            MasterID = evMasterSwitchRequested.MasterId,
+                        };
+                        break;
                     case EvRPC evRpc:
                         info = new RoomReceiveRPCInfo()
                         {
@@ -35,6 +35,9 @@ namespace WSNet2
         /// <summary>観戦人数</summary>
         public uint WatcherCount => info.watchers;
 
+        /// <summary>最大観戦人数. 0は上限なし</summary>
+        public uint MaxWatchers => info.maxWatchers;
+
         /// <summary>ルームの公開プロパティ</summary>
         public IReadOnlyDictionary<string, object> PublicProps => publicProps;
 
@@ -42,6 +42,9 @@ namespace WSNet2
         /// <summary>全Playerの最終メッセージ受信時刻 (playerId => unixtime millisec)</summary>
         public IReadOnlyDictionary<string, ulong> LastMsgTimestamps { get => lastMsgTimestamps; }
 
+        /// <summary>部屋で有効な機能フラグ</summary>
+        public IReadOnlyCollection<string> FeatureFlags { get => featureFlags; }
+
         /// <summary>
         ///   入室イベント通知
         /// </summary>
@@ -105,6 +108,16 @@ namespace WSNet2
         /// </remarks>
         public Action<ulong, ulong, IReadOnlyDictionary<string, ulong>> OnPongReceived;
 
+        /// <summary>
+        ///   Appのサーバからのメッセージ受信通知
+        /// </summary>
+        /// OnServerMessage(targetId, reader)
+        /// <remarks>
+        ///   targetIdは部屋全体へのメッセージのときは空文字列。
+        ///   readerからサーバが送った値を読み出せます。
+        /// </remarks>
+        public Action<string, SerialReader> OnServerMessage;
+
         /// <summary>
         ///   接続状態変化通知
         /// </summary>
@@ -130,6 +143,7 @@ namespace WSNet2
         string masterId;
         uint clientDeadline;
         Dictionary<string, ulong> lastMsgTimestamps;
+        HashSet<string> featureFlags;
 
         CallbackPool callbackPool;
         Dictionary<Delegate, byte> rpcMap;
@@ -184,6 +198,15 @@ namespace WSNet2
             }
 
             this.masterId = joined.masterId;
+            this.featureFlags = new HashSet<string>(joined.featureFlags ?? new string[0]);
+        }
+
+        /// <summary>
+        ///   機能フラグが部屋で有効か
+        /// </summary>
+        public bool HasFeature(string name)
+        {
+            return featureFlags.Contains(name);
         }
 
         /// <summary>
@@ -926,9 +949,15 @@ namespace WSNet2
                 case EvMasterSwitched evMasterSwitched:
                     OnEvMasterSwitched(evMasterSwitched);
                     break;
+                case EvMasterSwitchRequested evMasterSwitchRequested:
+                    OnEvMasterSwitchRequested(evMasterSwitchRequested);
+                    break;
                 case EvRPC evRpc:
                     OnEvRPC(evRpc);
                     break;
+                case EvServerMessage evServerMessage:
+                    OnEvServerMessage(evServerMessage);
+                    break;
                 case EvClosed evClosed:
                     OnEvClosed(evClosed);
                     break;
@@ -1152,6 +1181,31 @@ namespace WSNet2
             });
         }
 
+        /// <summary>
+        ///   マスタープレイヤー移譲の打診イベント
+        /// </summary>
+        /// <remarks>
+        ///   応答できていることをサーバに示すため自動で受諾する
+        /// </remarks>
+        private void OnEvMasterSwitchRequested(EvMasterSwitchRequested ev)
+        {
+            logger?.Info("master switch requested by {0}", ev.MasterId);
+            con.msgPool.PostAcceptMaster();
+        }
+
+        /// <summary>
+        ///   Appのサーバからのメッセージイベント
+        /// </summary>
+        private void OnEvServerMessage(EvServerMessage ev)
+        {
+            logger?.Debug("server message: target={0}", ev.TargetID);
+
+            callbackPool.Add(() =>
+            {
+                OnServerMessage?.Invoke(ev.TargetID, ev.Reader);
+            });
+        }
+
         /// <summary>
         ///   RPCイベント
         /// </summary>
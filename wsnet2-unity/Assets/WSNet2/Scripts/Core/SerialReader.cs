@@ -164,6 +164,36 @@ namespace WSNet2
             return BitConverter.Int64BitsToDouble(b);
         }
 
+        /// <summary>
+        ///   日時を取り出す
+        /// </summary>
+        /// <remarks>
+        ///   <para>
+        ///     オフセットは保存されないのでUTCになる。
+        ///   </para>
+        /// </remarks>
+        public DateTimeOffset ReadDateTimeOffset()
+        {
+            checkType(Type.Timestamp);
+            return DateTimeOffset.FromUnixTimeMilliseconds((long)Get64() + long.MinValue);
+        }
+
+        /// <summary>
+        ///   Guidを取り出す
+        /// </summary>
+        public Guid ReadGuid()
+        {
+            checkType(Type.UUID);
+            checkLength(16);
+            var b = new byte[16];
+            for (var i = 0; i < b.Length; i++)
+            {
+                b[WSNet2Serializer.GuidByteOrder[i]] = buf[pos + i];
+            }
+            pos += b.Length;
+            return new Guid(b);
+        }
+
         /// <summary>
         ///   string値を取り出す
         /// </summary>
@@ -876,6 +906,10 @@ namespace WSNet2
                 case Type.Str8:
                 case Type.Str16:
                     return ReadString();
+                case Type.Timestamp:
+                    return ReadDateTimeOffset();
+                case Type.UUID:
+                    return ReadGuid();
                 case Type.Obj:
                     var cid = buf[pos + 1];
                     var read = readFuncs[cid];
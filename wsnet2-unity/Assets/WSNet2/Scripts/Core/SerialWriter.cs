@@ -227,6 +227,41 @@ namespace WSNet2
             Put64((ulong)b);
         }
 
+        /// <summary>
+        ///   日時をunix epochからのミリ秒として書き込む
+        /// </summary>
+        /// <remarks>
+        ///   <para>
+        ///     ミリ秒未満とオフセットは保存されない。
+        ///   </para>
+        /// </remarks>
+        /// <param name="v">値</param>
+        public void Write(DateTimeOffset v)
+        {
+            expand(9);
+            buf[pos] = (byte)Type.Timestamp;
+            pos++;
+            ulong n = (ulong)(v.ToUnixTimeMilliseconds() - long.MinValue);
+            Put64(n);
+        }
+
+        /// <summary>
+        ///   GuidをRFC 4122のバイト順で書き込む
+        /// </summary>
+        /// <param name="v">値</param>
+        public void Write(Guid v)
+        {
+            expand(17);
+            buf[pos] = (byte)Type.UUID;
+            pos++;
+            var b = v.ToByteArray();
+            for (var i = 0; i < b.Length; i++)
+            {
+                buf[pos + i] = b[WSNet2Serializer.GuidByteOrder[i]];
+            }
+            pos += b.Length;
+        }
+
         /// <summary>
         ///   文字列を書き込む
         /// </summary>
@@ -956,6 +991,12 @@ namespace WSNet2
                 case string e:
                     Write(e);
                     break;
+                case DateTimeOffset e:
+                    Write(e);
+                    break;
+                case Guid e:
+                    Write(e);
+                    break;
                 case IWSNet2Serializable e:
                     Write(e);
                     break;
@@ -15,11 +15,16 @@ namespace WSNet2
     public class WSNet2Client
     {
         const int httpPostTimeoutMillisec = 5000;
+        const int ticketPollWaitSec = 3;
 
         string baseUri;
         string appId;
         string userId;
         AuthData authData;
+        IDictionary<uint, uint> latencies;
+        string platform;
+        string appVersion;
+        string device;
         Dictionary<string, string> requestHeaders;
 
         List<Room> rooms = new List<Room>();
@@ -78,6 +83,39 @@ namespace WSNet2
             this.requestHeaders["Authorization"] = authData.Bearer;
         }
 
+        /// <summary>
+        ///   Gameサーバ毎に計測したRTTを設定
+        /// </summary>
+        /// <param name="latencies">HostID毎のRTT（ミリ秒）. nullなら指定しない</param>
+        /// <remarks>
+        ///   <para>
+        ///     GetProbes()で取得したURLへのRTTを計測して設定すると、
+        ///     Create()やRandomJoin()でRTTの小さいGameサーバが優先される。
+        ///   </para>
+        /// </remarks>
+        public void SetLatencies(IDictionary<uint, uint> latencies)
+        {
+            this.latencies = latencies;
+        }
+
+        /// <summary>
+        ///   入室時にサーバへ送る環境情報を設定
+        /// </summary>
+        /// <param name="platform">プラットフォーム（"android"など）. nullなら"unity"</param>
+        /// <param name="appVersion">アプリのバージョン</param>
+        /// <param name="device">端末の機種名など</param>
+        /// <remarks>
+        ///   <para>
+        ///     サーバのplayer_logに記録され、不具合や迷惑行為の報告をビルドと突き合わせるのに使える。
+        ///   </para>
+        /// </remarks>
+        public void SetClientMetadata(string platform, string appVersion, string device)
+        {
+            this.platform = platform;
+            this.appVersion = appVersion;
+            this.device = device;
+        }
+
         /// <summary>
         ///   蓄積されたCallbackを処理する。
         /// </summary>
@@ -154,8 +192,9 @@ namespace WSNet2
             var param = new CreateParam()
             {
                 roomOption = roomOption,
-                clientInfo = new ClientInfo(userId, clientProps),
+                clientInfo = newClientInfo(clientProps),
                 encryptedMACKey = authData.EncryptedMACKey,
+                latencies = latencies,
             };
 
             var content = MessagePackSerializer.Serialize(param);
@@ -163,6 +202,59 @@ namespace WSNet2
             Task.Run(() => connectToRoom("/rooms", content, authData, onSuccess, onFailed, roomLogger));
         }
 
+        /// <summary>
+        ///   部屋を作成して入室 (Lobbyのバックグラウンドで作成)
+        /// </summary>
+        /// <param name="roomOption">作成する部屋のオプション</param>
+        /// <param name="clientProps">自身のカスタムプロパティ</param>
+        /// <param name="onSuccess">成功時callback. 引数は作成した部屋</param>
+        /// <param name="onFailed">失敗時callback. 引数は例外オブジェクト</param>
+        /// <param name="roomLogger">Logger</param>
+        /// <remarks>
+        ///   <para>
+        ///     Lobbyは作成を受け付けるとすぐにチケットを返し、クライアントは作成が終わるまで結果を問い合わせる。
+        ///     Gameサーバの応答が遅いときでもHTTPのタイムアウトで失敗しにくい。
+        ///   </para>
+        ///   <para>callbackの扱いはCreate()と同じ</para>
+        /// </remarks>
+        public void CreateInBackground(
+            RoomOption roomOption,
+            IDictionary<string, object> clientProps,
+            Action<Room> onSuccess,
+            Action<Exception> onFailed,
+            IWSNet2Logger<WSNet2LogPayload> roomLogger)
+        {
+            logger?.Debug("WSNet2Client.CreateInBackground()");
+
+            var authData = this.authData;
+            var param = new CreateParam()
+            {
+                roomOption = roomOption,
+                clientInfo = newClientInfo(clientProps),
+                encryptedMACKey = authData.EncryptedMACKey,
+                latencies = latencies,
+            };
+
+            var content = MessagePackSerializer.Serialize(param);
+
+            Task.Run(() => connectToRoom(() => createInBackground(content), authData, onSuccess, onFailed, roomLogger));
+        }
+
+        private async Task<LobbyResponse> createInBackground(byte[] content)
+        {
+            var res = await post("/rooms/async", content);
+            var ticket = res.ticket;
+            while (res.type == LobbyResponseType.Pending)
+            {
+                if (DateTimeOffset.UtcNow.ToUnixTimeSeconds() > ticket.expire)
+                {
+                    throw new Exception($"wsnet2 /rooms/async failed: ticket {ticket.id} expired");
+                }
+                res = await post($"/rooms/async/{ticket.id}?wait={ticketPollWaitSec}", new byte[0]);
+            }
+            return res;
+        }
+
         /// <summary>
         ///   部屋IDを指定して入室
         /// </summary>
@@ -186,7 +278,7 @@ namespace WSNet2
             var param = new JoinParam()
             {
                 queries = query?.condsList,
-                clientInfo = new ClientInfo(userId, clientProps),
+                clientInfo = newClientInfo(clientProps),
                 encryptedMACKey = authData.EncryptedMACKey,
             };
             var content = MessagePackSerializer.Serialize(param);
@@ -223,7 +315,7 @@ namespace WSNet2
             var param = new JoinParam()
             {
                 queries = query?.condsList,
-                clientInfo = new ClientInfo(userId, clientProps),
+                clientInfo = newClientInfo(clientProps),
                 encryptedMACKey = authData.EncryptedMACKey,
             };
             var content = MessagePackSerializer.Serialize(param);
@@ -231,6 +323,190 @@ namespace WSNet2
             Task.Run(() => connectToRoom($"/rooms/join/number/{number}", content, authData, onSuccess, onFailed, roomLogger));
         }
 
+        /// <summary>
+        ///   招待トークンで入室
+        /// </summary>
+        /// <param name="token">招待トークン</param>
+        /// <param name="clientProps">自身のカスタムプロパティ</param>
+        /// <param name="onSuccess">成功時callback. 引数は入室した部屋</param>
+        /// <param name="onFailed">失敗時callback. 引数は例外オブジェクト</param>
+        /// <param name="roomLogger">Logger</param>
+        public void JoinByInvite(
+            string token,
+            IDictionary<string, object> clientProps,
+            Action<Room> onSuccess,
+            Action<Exception> onFailed,
+            IWSNet2Logger<WSNet2LogPayload> roomLogger)
+        {
+            logger?.Debug("WSNet2Client.JoinByInvite()");
+
+            var authData = this.authData;
+            var param = new JoinByInviteParam()
+            {
+                token = token,
+                clientInfo = newClientInfo(clientProps),
+                encryptedMACKey = authData.EncryptedMACKey,
+            };
+            var content = MessagePackSerializer.Serialize(param);
+
+            Task.Run(() => connectToRoom("/rooms/join/invite", content, authData, onSuccess, onFailed, roomLogger));
+        }
+
+        /// <summary>
+        ///   入室中の部屋への招待トークンを発行
+        /// </summary>
+        /// <param name="roomId">Room ID</param>
+        /// <param name="inviteeId">招待するユーザID. nullなら誰でも使える</param>
+        /// <param name="expireSec">有効期間（秒）. 0ならLobbyの上限</param>
+        /// <param name="reserveSeat">有効期間の間inviteeIdの席を確保する</param>
+        /// <param name="onSuccess">成功時callback. 引数は招待</param>
+        /// <param name="onFailed">失敗時callback. 引数は例外オブジェクト</param>
+        public void Invite(
+            string roomId,
+            string inviteeId,
+            uint expireSec,
+            bool reserveSeat,
+            Action<Invitation> onSuccess,
+            Action<Exception> onFailed)
+        {
+            logger?.Debug("WSNet2Client.Invite(roomId={0}, invitee={1})", roomId, inviteeId);
+
+            var param = new InviteParam()
+            {
+                userId = inviteeId,
+                expire = expireSec,
+                reserve = reserveSeat,
+            };
+            var content = MessagePackSerializer.Serialize(param);
+
+            Task.Run(async () =>
+            {
+                try
+                {
+                    var res = await post($"/rooms/invite/{roomId}", content);
+                    if (res.type == LobbyResponseType.NoRoomFound)
+                    {
+                        throw new RoomNotFoundException(res.msg);
+                    }
+                    callbackPool.Add(() => onSuccess(res.invite));
+                }
+                catch (Exception e)
+                {
+                    logger?.Error(e, "Failed to invite");
+                    callbackPool.Add(() => onFailed(e));
+                }
+            });
+        }
+
+        /// <summary>
+        ///   満室の部屋の順番待ちの列に並ぶ（並んでいれば順番を問い合わせる）
+        /// </summary>
+        /// <param name="roomId">Room ID</param>
+        /// <param name="waitSec">順番が変わるまでLobbyで待つ時間（秒）</param>
+        /// <param name="onSuccess">成功時callback. 引数は順番待ちの状態</param>
+        /// <param name="onFailed">失敗時callback. 引数は例外オブジェクト</param>
+        /// <remarks>
+        ///   <para>
+        ///     SeatWaiting.expireまでに再び呼ばないと列から外れる。
+        ///     positionが0になったら席が確保されているので、expireまでにJoin()で入室する。
+        ///   </para>
+        /// </remarks>
+        public void WaitSeat(
+            string roomId,
+            int waitSec,
+            Action<SeatWaiting> onSuccess,
+            Action<Exception> onFailed)
+        {
+            logger?.Debug("WSNet2Client.WaitSeat({0})", roomId);
+            waitSeat(roomId, waitSec, false, onSuccess, onFailed);
+        }
+
+        /// <summary>
+        ///   満室の部屋の順番待ちの列から外れる. 確保された席も解放する
+        /// </summary>
+        /// <param name="roomId">Room ID</param>
+        /// <param name="onSuccess">成功時callback</param>
+        /// <param name="onFailed">失敗時callback. 引数は例外オブジェクト</param>
+        public void LeaveWaitList(
+            string roomId,
+            Action onSuccess,
+            Action<Exception> onFailed)
+        {
+            logger?.Debug("WSNet2Client.LeaveWaitList({0})", roomId);
+            waitSeat(roomId, 0, true, _ => onSuccess(), onFailed);
+        }
+
+        private void waitSeat(
+            string roomId,
+            int waitSec,
+            bool leave,
+            Action<SeatWaiting> onSuccess,
+            Action<Exception> onFailed)
+        {
+            var param = new WaitSeatParam()
+            {
+                leave = leave,
+            };
+            var content = MessagePackSerializer.Serialize(param);
+
+            Task.Run(async () =>
+            {
+                try
+                {
+                    var res = await post($"/rooms/wait/id/{roomId}?wait={waitSec}", content);
+                    switch (res.type)
+                    {
+                        case LobbyResponseType.NoRoomFound:
+                            throw new RoomNotFoundException(res.msg);
+                        case LobbyResponseType.RoomFull:
+                            if (res.waiting == null)
+                            {
+                                throw new RoomFullException(res.msg);
+                            }
+                            break;
+                    }
+                    callbackPool.Add(() => onSuccess(res.waiting));
+                }
+                catch (LobbyNormalException e)
+                {
+                    logger?.Info(e, "Failed to wait seat");
+                    callbackPool.Add(() => onFailed(e));
+                }
+                catch (Exception e)
+                {
+                    logger?.Error(e, "Failed to wait seat");
+                    callbackPool.Add(() => onFailed(e));
+                }
+            });
+        }
+
+        /// <summary>
+        ///   RTT計測用のGameサーバのエンドポイント一覧を取得
+        /// </summary>
+        /// <param name="onSuccess">成功時callback. 引数はエンドポイント一覧</param>
+        /// <param name="onFailed">失敗時callback. 引数は例外オブジェクト</param>
+        public void GetProbes(
+            Action<ProbeTarget[]> onSuccess,
+            Action<Exception> onFailed)
+        {
+            logger?.Debug("WSNet2Client.GetProbes()");
+
+            Task.Run(async () =>
+            {
+                try
+                {
+                    var res = await post("/probes", new byte[0]);
+                    var probes = res.probes ?? new ProbeTarget[0];
+                    callbackPool.Add(() => onSuccess(probes));
+                }
+                catch (Exception e)
+                {
+                    logger?.Error(e, "Failed to get probes");
+                    callbackPool.Add(() => onFailed(e));
+                }
+            });
+        }
+
         /// <summary>
         ///   検索クエリに合致する部屋にランダム入室
         /// </summary>
@@ -254,8 +530,9 @@ namespace WSNet2
             var param = new JoinParam()
             {
                 queries = query?.condsList,
-                clientInfo = new ClientInfo(userId, clientProps),
+                clientInfo = newClientInfo(clientProps),
                 encryptedMACKey = authData.EncryptedMACKey,
+                latencies = latencies,
             };
             var content = MessagePackSerializer.Serialize(param);
 
@@ -283,7 +560,7 @@ namespace WSNet2
             var param = new JoinParam()
             {
                 queries = query?.condsList,
-                clientInfo = new ClientInfo(userId),
+                clientInfo = newClientInfo(),
                 encryptedMACKey = authData.EncryptedMACKey,
             };
             var content = MessagePackSerializer.Serialize(param);
@@ -318,7 +595,7 @@ namespace WSNet2
             var param = new JoinParam()
             {
                 queries = query?.condsList,
-                clientInfo = new ClientInfo(userId),
+                clientInfo = newClientInfo(),
                 encryptedMACKey = authData.EncryptedMACKey,
             };
             var content = MessagePackSerializer.Serialize(param);
@@ -378,6 +655,71 @@ namespace WSNet2
             Task.Run(() => search("/rooms/search", content, onSuccess, onFailed));
         }
 
+        /// <summary>
+        ///   複数の検索グループをまとめて部屋検索
+        /// </summary>
+        /// <param name="groups">検索グループ（最大16個）</param>
+        /// <param name="query">検索クエリ</param>
+        /// <param name="limit">件数上限（全グループの合計）</param>
+        /// <param name="checkJoinable">入室可能な部屋のみ含める</param>
+        /// <param name="checkWatchable">観戦可能な部屋のみ含める</param>
+        /// <param name="onSuccess">成功時callback. 引数は検索でヒットした部屋一覧</param>
+        /// <param name="onFailed">失敗時callback. 引数は例外オブジェクト</param>
+        public void Search(
+            uint[] groups,
+            Query query,
+            int limit,
+            bool checkJoinable,
+            bool checkWatchable,
+            Action<PublicRoom[]> onSuccess,
+            Action<Exception> onFailed)
+        {
+            logger?.Debug("WSNet2Client.Search(groups={0})", string.Join(",", groups));
+
+            var param = new SearchParam()
+            {
+                groups = groups,
+                queries = query?.condsList,
+                limit = limit,
+                checkJoinable = checkJoinable,
+                checkWatchable = checkWatchable,
+            };
+            var content = MessagePackSerializer.Serialize(param);
+
+            Task.Run(() => search("/rooms/search", content, onSuccess, onFailed));
+        }
+
+        /// <summary>
+        ///   観戦可能な部屋を人数の多い順に検索
+        /// </summary>
+        /// <param name="groups">検索グループ（最大16個）</param>
+        /// <param name="query">検索クエリ</param>
+        /// <param name="limit">件数上限</param>
+        /// <param name="sortBy">並び順</param>
+        /// <param name="onSuccess">成功時callback. 引数は検索でヒットした部屋一覧</param>
+        /// <param name="onFailed">失敗時callback. 引数は例外オブジェクト</param>
+        public void SearchWatchable(
+            uint[] groups,
+            Query query,
+            int limit,
+            WatchableSortKey sortBy,
+            Action<PublicRoom[]> onSuccess,
+            Action<Exception> onFailed)
+        {
+            logger?.Debug("WSNet2Client.SearchWatchable(groups={0}, sort={1})", string.Join(",", groups), sortBy);
+
+            var param = new SearchWatchableParam()
+            {
+                groups = groups,
+                queries = query?.condsList,
+                limit = limit,
+                sortBy = (byte)sortBy,
+            };
+            var content = MessagePackSerializer.Serialize(param);
+
+            Task.Run(() => search("/rooms/search/watchable", content, onSuccess, onFailed));
+        }
+
         /// <summary>
         ///   部屋IDによる部屋検索
         /// </summary>
@@ -420,6 +762,20 @@ namespace WSNet2
             Task.Run(() => search("/rooms/search/numbers", content, onSuccess, onFailed));
         }
 
+        private ClientInfo newClientInfo(IDictionary<string, object> props = null)
+        {
+            var info = new ClientInfo(userId, props)
+            {
+                AppVersion = appVersion,
+                Device = device,
+            };
+            if (platform != null)
+            {
+                info.Caps.Platform = platform;
+            }
+            return info;
+        }
+
         private async Task<LobbyResponse> post(string path, byte[] content)
         {
             var url = baseUri + path;
@@ -457,17 +813,27 @@ namespace WSNet2
             return MessagePackSerializer.Deserialize<LobbyResponse>(body);
         }
 
-        private async Task connectToRoom(
+        private Task connectToRoom(
             string path,
             byte[] content,
             AuthData authData,
             Action<Room> onSuccess,
             Action<Exception> onFailed,
             IWSNet2Logger<WSNet2LogPayload> roomLogger)
+        {
+            return connectToRoom(() => post(path, content), authData, onSuccess, onFailed, roomLogger);
+        }
+
+        private async Task connectToRoom(
+            Func<Task<LobbyResponse>> request,
+            AuthData authData,
+            Action<Room> onSuccess,
+            Action<Exception> onFailed,
+            IWSNet2Logger<WSNet2LogPayload> roomLogger)
         {
             try
             {
-                var res = await post(path, content);
+                var res = await request();
                 switch (res.type)
                 {
                     case LobbyResponseType.RoomLimit:
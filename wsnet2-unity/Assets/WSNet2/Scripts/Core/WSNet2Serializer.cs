@@ -22,6 +22,17 @@ namespace WSNet2
         static ReadFunc[] readFuncs = new ReadFunc[256];
         static SerialWriter writer;
 
+        /// <summary>
+        ///   Guid.ToByteArray()とRFC 4122のバイト順の対応
+        /// </summary>
+        /// <remarks>
+        ///   <para>
+        ///     Guid.ToByteArray()は先頭の3つのフィールドがlittle endianなので入れ替える。
+        ///     どちら向きの変換にも使える。
+        ///   </para>
+        /// </remarks>
+        internal static readonly int[] GuidByteOrder = { 3, 2, 1, 0, 5, 4, 7, 6, 8, 9, 10, 11, 12, 13, 14, 15 };
+
         /// <summary>
         ///   SerialWriter新規作成
         /// </summary>
@@ -149,6 +160,9 @@ namespace WSNet2
         Floats,
         Doubles,
         Decimals,
+
+        Timestamp,
+        UUID,
     }
 
     [Serializable()]